@@ -2,21 +2,31 @@ package main
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"log"
-	"net/http"
 	"os"
+	"strings"
 	"time"
 
+	dockerclient "github.com/docker/docker/client"
 	"github.com/gorilla/mux"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
+	"golang.org/x/crypto/ssh"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
 
+	"github.com/ringo380/lessoncraft/backend"
 	"github.com/ringo380/lessoncraft/config"
 	"github.com/ringo380/lessoncraft/docker"
 	"github.com/ringo380/lessoncraft/event"
 	"github.com/ringo380/lessoncraft/handlers"
 	"github.com/ringo380/lessoncraft/id"
+	"github.com/ringo380/lessoncraft/images"
 	"github.com/ringo380/lessoncraft/k8s"
+	"github.com/ringo380/lessoncraft/placement"
 	"github.com/ringo380/lessoncraft/provisioner"
 	"github.com/ringo380/lessoncraft/pwd"
 	"github.com/ringo380/lessoncraft/pwd/types"
@@ -25,6 +35,14 @@ import (
 	"github.com/ringo380/lessoncraft/storage"
 
 	"github.com/ringo380/lessoncraft/api"
+	"github.com/ringo380/lessoncraft/api/audit"
+	"github.com/ringo380/lessoncraft/api/auth"
+	"github.com/ringo380/lessoncraft/api/hosts"
+	imagesapi "github.com/ringo380/lessoncraft/api/images"
+	"github.com/ringo380/lessoncraft/api/org"
+	"github.com/ringo380/lessoncraft/api/quota"
+	"github.com/ringo380/lessoncraft/api/ratelimit"
+	"github.com/ringo380/lessoncraft/api/snapshot"
 	"github.com/ringo380/lessoncraft/api/store"
 )
 
@@ -56,14 +74,35 @@ func main() {
 	// Initialize core LessonCraft components
 	e := initEvent()
 	s := initStorage()
-	df := initDockerFactory(s)
+	// Sessions are spread across the docker hosts of the registry
+	hostRegistry := initHostRegistry()
+	hostFactory := docker.NewMultiHostFactory(s, hostRegistry, initPlacementStrategy())
+	go hostFactory.Run(context.Background(), config.HostProbeInterval)
+	var df docker.FactoryApi = hostFactory
 	kf := initK8sFactory(s)
 
-	ipf := provisioner.NewInstanceProvisionerFactory(provisioner.NewWindowsASG(df, s), provisioner.NewDinD(id.XIDGenerator{}, df, s))
+	rf := initRuntimeFactory(df)
+
+	dind := provisioner.NewDinDWithRuntime(id.XIDGenerator{}, rf, s)
+	if config.L2InstanceSSHKey != "" {
+		dind.SetInstanceSSHKey(instanceSSHKey(config.L2InstanceSSHKey))
+	}
+	if config.WarmPoolInterval > 0 {
+		// Instances start from idle containers created ahead of time
+		pool := provisioner.NewWarmPool(id.XIDGenerator{}, rf, s)
+		dind.SetWarmPool(pool)
+		pool.Start(config.WarmPoolInterval)
+	}
+	ipf := provisioner.NewInstanceProvisionerFactory(provisioner.NewWindowsASG(df, s), dind)
 	sp := provisioner.NewOverlaySessionProvisioner(df)
 
 	core := pwd.NewLessonCraft(df, e, s, sp, ipf) // Using the new function name as per the TODO
 
+	// Sessions and instances count against the quotas of their users,
+	// organisations and playgrounds
+	quotas := quota.NewService(quota.NewMemoryStore(), quota.DefaultPolicy())
+	core.SetQuota(quotas)
+
 	tasks := []scheduler.Task{
 		task.NewCheckPorts(e, df),
 		task.NewCheckSwarmPorts(e, df),
@@ -77,6 +116,12 @@ func main() {
 		log.Fatal("Error initializing the scheduler: ", err)
 	}
 
+	// Expired sessions are snapshotted before they are closed, so learners can
+	// restore their work later
+	snapshots := snapshot.NewService(snapshot.NewMemoryStore(), core, snapshot.DefaultPolicy())
+	sch.SetSnapshotter(snapshots)
+	snapshots.Start(time.Hour)
+
 	sch.Start()
 
 	d, err := time.ParseDuration("4h")
@@ -98,19 +143,104 @@ func main() {
 	}
 
 	// Initialize API handlers
-	router := mux.NewRouter()
 	apiHandler := api.NewApiHandler(lessonStore)
-	apiHandler.RegisterRoutes(router)
+	userStore := auth.NewMemoryUserStore()
+	jwtService := auth.NewJWTService(jwtSecret(), "lessoncraft", 24*time.Hour)
+	authHandler := auth.NewAuthHandler(userStore, jwtService)
+	apiKeyStore := auth.NewMemoryAPIKeyStore()
+	apiKeys := auth.NewAPIKeyService(apiKeyStore, userStore, jwtService)
+	apiKeyHandler := auth.NewAPIKeyHandler(userStore, apiKeyStore, apiKeys)
+	authHandler.UseAPIKeys(apiKeys)
+	oidcHandler := auth.NewOIDCHandler(authHandler, initOIDCProviders()...)
+	sshKeyHandler := auth.NewSSHKeyHandler(authHandler)
+	if config.L2AccessKey != "" {
+		// The l2 router checks SSH logins with the keys of the session owners
+		sshKeyHandler.UseGateway([]byte(config.L2AccessKey), core)
+	}
+	quotaHandler := quota.NewHandler(quotas, authHandler.TokenValidator())
+	catalog := images.NewCatalog(images.NewMemoryStore(), docker.NewImageBuilder(hostFactory))
+	imagesHandler := imagesapi.NewHandler(catalog, lessonStore, authHandler.TokenValidator())
+	hostsHandler := hosts.NewHandler(hostRegistry, hostFactory, authHandler.TokenValidator())
+	snapshotHandler := snapshot.NewHandler(snapshots, authHandler.TokenValidator())
+	orgHandler := org.NewHandler(org.NewMemoryStore(), userStore, lessonStore, authHandler.TokenValidator())
+
+	// Administrative and account actions are recorded in the audit log, which
+	// admins can query and export
+	auditStore := initAuditStore()
+	auditLog := audit.NewLogger(auditStore)
+	auditHandler := audit.NewHandler(auditStore, authHandler.TokenValidator())
+	apiHandler.Lessons().SetAuditor(auditLog)
+	authHandler.SetAuditor(auditLog)
+	apiKeyHandler.SetAuditor(auditLog)
+	orgHandler.SetAuditor(auditLog)
+	handlers.SetAuditor(auditLog.Record)
 
-	// Bootstrap LessonCraft handlers
+	// Lessons and sessions of an organisation are only visible to its members
+	apiHandler.Lessons().SetLessonAccess(orgHandler.Policy())
+	imagesHandler.SetLessonAccess(orgHandler.Policy())
+	handlers.SetSessionAccess(handlers.UserSessionAccess(orgHandler.Policy().CanViewSession))
+	handlers.SetSessionManager(orgHandler.Policy().CanManageSession)
+
+	// User tokens and API keys, within their scopes, work on lessons and sessions
+	apiHandler.Lessons().SetTokenValidator(authHandler.TokenValidator())
+	handlers.SetTokenValidator(authHandler.TokenValidator())
+
+	// Requests are limited per API key, user or address
+	if config.RateLimit > 0 {
+		handlers.SetRateLimiter(initRateLimiter())
+	}
+
+	// New instances can be created from the usable images of the catalog
+	handlers.SetInstanceImages(func(playground *types.Playground) ([]string, error) {
+		return catalog.InstanceImages(playground.AvailableDinDInstanceImages)
+	})
+
+	// Playground logins resolve to the same accounts as the API
+	handlers.SetIdentityResolver(authHandler)
+
+	// Bootstrap LessonCraft handlers, serving the API alongside the session routes
 	handlers.Bootstrap(core, e)
-	handlers.Register(router)
+	handlers.Register(func(r *mux.Router) {
+		apiHandler.RegisterRoutes(r)
+		authHandler.RegisterRoutes(r)
+		oidcHandler.RegisterRoutes(r)
+		apiKeyHandler.RegisterRoutes(r)
+		sshKeyHandler.RegisterRoutes(r)
+		orgHandler.RegisterRoutes(r)
+		snapshotHandler.RegisterRoutes(r)
+		quotaHandler.RegisterRoutes(r)
+		hostsHandler.RegisterRoutes(r)
+		imagesHandler.RegisterRoutes(r)
+		auditHandler.RegisterRoutes(r)
+	})
+}
 
-	// Start server
-	log.Println("Starting server on :8080")
-	if err := http.ListenAndServe(":8080", router); err != nil {
-		log.Fatal(err)
+// jwtSecret returns the key user tokens are signed with. Without JWT_SECRET a
+// random key is used, so tokens do not survive a restart.
+func jwtSecret() string {
+	if secret := os.Getenv("JWT_SECRET"); secret != "" {
+		return secret
 	}
+	log.Println("JWT_SECRET is not set, using a random key")
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		log.Fatal("Error generating the JWT key: ", err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// instanceSSHKey returns the public key of the private key file the l2 router
+// logs in to instances with
+func instanceSSHKey(path string) ssh.PublicKey {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		log.Fatal("Error reading the instance SSH key: ", err)
+	}
+	signer, err := ssh.ParsePrivateKey(b)
+	if err != nil {
+		log.Fatal("Error parsing the instance SSH key: ", err)
+	}
+	return signer.PublicKey()
 }
 
 func initStorage() storage.StorageApi {
@@ -121,12 +251,110 @@ func initStorage() storage.StorageApi {
 	return s
 }
 
+// initOIDCProviders discovers the OpenID Connect providers of the OIDC
+// configuration, if any
+func initOIDCProviders() []*auth.OIDCProvider {
+	if config.OIDCConfigPath == "" {
+		return nil
+	}
+	configs, err := auth.LoadOIDCConfigs(config.OIDCConfigPath)
+	if err != nil {
+		log.Fatal("Error loading the OIDC configuration: ", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	providers := make([]*auth.OIDCProvider, 0, len(configs))
+	for _, c := range configs {
+		p, err := auth.NewOIDCProvider(ctx, c, nil)
+		if err != nil {
+			log.Fatalf("Error initializing the OIDC provider %s: %v", c.Name, err)
+		}
+		providers = append(providers, p)
+	}
+	return providers
+}
+
+func initRateLimiter() *ratelimit.Limiter {
+	var backend ratelimit.Backend = ratelimit.NewMemoryBackend()
+	if config.RateLimitRedis != "" {
+		backend = ratelimit.NewRedisBackend(ratelimit.RedisConfig{Addr: config.RateLimitRedis, Password: config.RateLimitRedisPassword})
+	}
+	return ratelimit.NewLimiter(backend, ratelimit.Policy{Name: "default", Limit: config.RateLimit, Window: time.Minute})
+}
+
+func initAuditStore() audit.Store {
+	if config.AuditLogPath == "" {
+		log.Println("audit-log is not set, keeping the audit log in memory")
+		return audit.NewMemoryStore()
+	}
+	s, err := audit.NewFileStore(config.AuditLogPath)
+	if err != nil {
+		log.Fatal("Error opening the audit log: ", err)
+	}
+	return s
+}
+
 func initEvent() event.EventApi {
 	return event.NewLocalBroker()
 }
 
-func initDockerFactory(s storage.StorageApi) docker.FactoryApi {
-	return docker.NewLocalCachedFactory(s)
+// initHostRegistry registers the docker hosts of the docker-hosts flag, or the
+// local daemon when it is empty
+func initHostRegistry() *placement.Registry {
+	registry := placement.NewRegistry()
+	if config.DockerHosts == "" {
+		if _, err := registry.Add(placement.Host{ID: "local", Addr: dockerclient.DefaultDockerHost}); err != nil {
+			log.Fatal("Error registering the local docker host: ", err)
+		}
+		return registry
+	}
+	for _, entry := range strings.Split(config.DockerHosts, ",") {
+		hostID, addr, found := strings.Cut(strings.TrimSpace(entry), "=")
+		if !found {
+			log.Fatalf("Docker host %q is not an id=addr pair", entry)
+		}
+		if _, err := registry.Add(placement.Host{ID: hostID, Addr: addr}); err != nil {
+			log.Fatalf("Error registering the docker host %s: %v", hostID, err)
+		}
+	}
+	return registry
+}
+
+func initPlacementStrategy() placement.Strategy {
+	strategy, err := placement.ParseStrategy(config.PlacementStrategy)
+	if err != nil {
+		log.Fatal("Error parsing the placement strategy: ", err)
+	}
+	return strategy
+}
+
+// initRuntimeFactory returns the runtimes instances run on, the docker hosts
+// sessions are placed on or the pods of a Kubernetes namespace
+func initRuntimeFactory(df docker.FactoryApi) backend.Factory {
+	switch config.Runtime {
+	case "docker":
+		return docker.NewRuntimeFactory(df)
+	case "kubernetes":
+		var restConfig *rest.Config
+		var err error
+		if config.KubeConfig == "" {
+			restConfig, err = rest.InClusterConfig()
+		} else {
+			restConfig, err = clientcmd.BuildConfigFromFlags("", config.KubeConfig)
+		}
+		if err != nil {
+			log.Fatal("Error loading the Kubernetes configuration: ", err)
+		}
+		clientset, err := kubernetes.NewForConfig(restConfig)
+		if err != nil {
+			log.Fatal("Error creating the Kubernetes client: ", err)
+		}
+		return backend.NewStaticFactory(backend.NewKubernetes(clientset, restConfig, config.KubeNamespace))
+	default:
+		log.Fatalf("Unknown runtime %q, expected docker or kubernetes", config.Runtime)
+		return nil
+	}
 }
 
 func initK8sFactory(s storage.StorageApi) k8s.FactoryApi {
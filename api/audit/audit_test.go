@@ -0,0 +1,172 @@
+package audit
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/ringo380/lessoncraft/api/apitest"
+	"github.com/ringo380/lessoncraft/api/auth"
+	"github.com/stretchr/testify/assert"
+)
+
+type target struct {
+	Name string `json:"name"`
+}
+
+func TestLogger_Record(t *testing.T) {
+	store := NewMemoryStore()
+	logger := NewLogger(store)
+
+	req := httptest.NewRequest("PUT", "/api/lessons/l1", nil)
+	req.RemoteAddr = "10.0.0.1:1234"
+	req.Header.Set("X-Request-ID", "req-1")
+	req.Header.Set("X-Forwarded-For", "203.0.113.9")
+	ctx := context.WithValue(req.Context(), auth.UserContextKey, "alice")
+	logger.Record(req.WithContext(ctx), "lesson.update", "lesson", "l1", target{"old"}, target{"new"})
+
+	// An API key checked by the route itself is recorded by its public prefix only
+	req = httptest.NewRequest("POST", "/playgrounds", nil)
+	req.Header.Set("Authorization", "Bearer lck_abc123_secret")
+	logger.SetTrustProxy(true)
+	req.Header.Set("X-Forwarded-For", "203.0.113.9, 10.0.0.2")
+	logger.Record(req, "playground.create", "playground", "p1", nil, target{"p1"})
+
+	req = httptest.NewRequest("DELETE", "/sessions/s1", nil)
+	req.SetBasicAuth("admin", "token")
+	logger.Record(req, "session.close", "session", "s1", target{"s1"}, nil)
+
+	var entries []*Entry
+	assert.Nil(t, store.Query(Filter{}, func(e *Entry) error {
+		entries = append(entries, e)
+		return nil
+	}))
+	assert.Len(t, entries, 3)
+
+	assert.Equal(t, ActorUser, entries[0].ActorType)
+	assert.Equal(t, "alice", entries[0].ActorID)
+	assert.Equal(t, "req-1", entries[0].RequestID)
+	assert.Equal(t, "10.0.0.1", entries[0].SourceIP)
+	assert.Equal(t, hashState(target{"old"}), entries[0].BeforeHash)
+	assert.NotEqual(t, entries[0].BeforeHash, entries[0].AfterHash)
+
+	assert.Equal(t, ActorAPIKey, entries[1].ActorType)
+	assert.Equal(t, "lck_abc123", entries[1].APIKeyID)
+	assert.Equal(t, "203.0.113.9", entries[1].SourceIP)
+	assert.Empty(t, entries[1].BeforeHash)
+
+	assert.Equal(t, ActorAdminToken, entries[2].ActorType)
+	assert.Empty(t, entries[2].AfterHash)
+
+	assert.Equal(t, entries[0].Hash, entries[1].PrevHash)
+	n, err := Verify(store)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, n)
+}
+
+func TestFilter_Match(t *testing.T) {
+	now := time.Now()
+	e := &Entry{ActorID: "alice", Action: "lesson.update", TargetType: "lesson", TargetID: "l1", Time: now}
+
+	assert.True(t, Filter{}.Match(e))
+	assert.True(t, Filter{Action: "lesson."}.Match(e))
+	assert.False(t, Filter{Action: "lesson"}.Match(e))
+	assert.False(t, Filter{ActorID: "bob"}.Match(e))
+	assert.True(t, Filter{TargetType: "lesson", TargetID: "l1"}.Match(e))
+	assert.True(t, Filter{Since: now, Until: now.Add(time.Second)}.Match(e))
+	assert.False(t, Filter{Until: now}.Match(e))
+}
+
+func TestFileStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+
+	store, err := NewFileStore(path)
+	assert.Nil(t, err)
+	assert.Nil(t, store.Append(&Entry{ID: "1", Action: "lesson.create"}))
+	assert.Nil(t, store.Append(&Entry{ID: "2", Action: "lesson.delete"}))
+	assert.Nil(t, store.Close())
+
+	// Reopening resumes the hash chain
+	store, err = NewFileStore(path)
+	assert.Nil(t, err)
+	assert.Nil(t, store.Append(&Entry{ID: "3", Action: "session.close"}))
+
+	var ids []string
+	assert.Nil(t, store.Query(Filter{Action: "lesson."}, func(e *Entry) error {
+		ids = append(ids, e.ID)
+		return nil
+	}))
+	assert.Equal(t, []string{"1", "2"}, ids)
+
+	n, err := Verify(store)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, n)
+	assert.Nil(t, store.Close())
+
+	// Editing an entry breaks the chain
+	data, err := os.ReadFile(path)
+	assert.Nil(t, err)
+	assert.Nil(t, os.WriteFile(path, []byte(strings.Replace(string(data), "lesson.delete", "lesson.update", 1)), 0600))
+	store, err = NewFileStore(path)
+	assert.Nil(t, err)
+	defer store.Close()
+	_, err = Verify(store)
+	assert.NotNil(t, err)
+}
+
+func TestHandler(t *testing.T) {
+	jwt := auth.NewJWTService("secret", "lessoncraft", time.Hour)
+	store := NewMemoryStore()
+	logger := NewLogger(store)
+	for _, action := range []string{"lesson.create", "lesson.update", "session.close"} {
+		logger.Record(httptest.NewRequest("POST", "/", nil), action, "lesson", "l1", nil, nil)
+	}
+
+	r := mux.NewRouter()
+	NewHandler(store, jwt).RegisterRoutes(r)
+
+	do := func(roles []auth.Role, path string) *httptest.ResponseRecorder {
+		token, _, err := jwt.GenerateToken("u1", "u1@example.com", roles)
+		assert.Nil(t, err)
+		return apitest.Do(r, token, "GET", path, nil)
+	}
+
+	rr := do([]auth.Role{auth.RoleEducator}, "/api/audit")
+	assert.Equal(t, http.StatusForbidden, rr.Code)
+
+	rr = do([]auth.Role{auth.RoleAdmin}, "/api/audit?action=lesson.&limit=1")
+	assert.Equal(t, http.StatusOK, rr.Code)
+	var entries []Entry
+	assert.Nil(t, json.NewDecoder(rr.Body).Decode(&entries))
+	assert.Len(t, entries, 1)
+	assert.Equal(t, "lesson.update", entries[0].Action)
+
+	rr = do([]auth.Role{auth.RoleAdmin}, "/api/audit?since=yesterday")
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+
+	rr = do([]auth.Role{auth.RoleAdmin}, "/api/audit/export")
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "application/x-ndjson", rr.Header().Get("Content-Type"))
+	var actions []string
+	scanner := bufio.NewScanner(rr.Body)
+	for scanner.Scan() {
+		var e Entry
+		assert.Nil(t, json.Unmarshal(scanner.Bytes(), &e))
+		actions = append(actions, e.Action)
+	}
+	assert.Equal(t, []string{"lesson.create", "lesson.update", "session.close"}, actions)
+
+	rr = do([]auth.Role{auth.RoleAdmin}, "/api/audit/verify")
+	var verify VerifyResponse
+	assert.Nil(t, json.NewDecoder(rr.Body).Decode(&verify))
+	assert.True(t, verify.Valid)
+	assert.Equal(t, 3, verify.Entries)
+}
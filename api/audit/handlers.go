@@ -0,0 +1,156 @@
+package audit
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/ringo380/lessoncraft/api/auth"
+	"github.com/ringo380/lessoncraft/api/middleware"
+)
+
+const (
+	defaultQueryLimit = 100
+	maxQueryLimit     = 1000
+)
+
+// Handler serves the audit log to administrators. All routes require the admin
+// role, and API keys need the admin scope.
+type Handler struct {
+	store  Store
+	tokens auth.TokenValidator
+}
+
+// NewHandler creates a new Handler
+func NewHandler(store Store, tokens auth.TokenValidator) *Handler {
+	return &Handler{
+		store:  store,
+		tokens: tokens,
+	}
+}
+
+// RegisterRoutes registers the audit log routes with the provided router:
+//   - GET /api/audit: Query entries, newest first
+//   - GET /api/audit/export: Export entries as newline-delimited JSON, oldest first
+//   - GET /api/audit/verify: Check the hash chain of the whole log
+//
+// The query and export endpoints accept the filters actor, action, target_type,
+// target_id, request_id, since and until (RFC 3339).
+func (h *Handler) RegisterRoutes(r *mux.Router) {
+	authMiddleware := auth.AuthMiddleware(h.tokens)
+	adminMiddleware := auth.RoleMiddleware(auth.RoleAdmin)
+	scopeMiddleware := auth.ScopeMiddleware(auth.ScopeAdmin)
+	handle := func(path string, f http.HandlerFunc) {
+		r.Handle(path, authMiddleware(adminMiddleware(scopeMiddleware(f)))).Methods("GET")
+	}
+
+	handle("/api/audit", h.Query)
+	handle("/api/audit/export", h.Export)
+	handle("/api/audit/verify", h.Verify)
+}
+
+// Query returns the newest entries matching the filters
+func (h *Handler) Query(w http.ResponseWriter, r *http.Request) {
+	f, err := parseFilter(r)
+	if err != nil {
+		middleware.WriteError(w, "ValidationError", http.StatusBadRequest, "Invalid filter", err)
+		return
+	}
+
+	limit := defaultQueryLimit
+	if v := r.URL.Query().Get("limit"); v != "" {
+		limit, err = strconv.Atoi(v)
+		if err != nil || limit <= 0 || limit > maxQueryLimit {
+			middleware.WriteError(w, "ValidationError", http.StatusBadRequest, fmt.Sprintf("limit must be between 1 and %d", maxQueryLimit), err)
+			return
+		}
+	}
+
+	// Entries come oldest first, so keep the last limit matches
+	entries := []*Entry{}
+	err = h.store.Query(f, func(e *Entry) error {
+		entries = append(entries, e)
+		if len(entries) > limit {
+			entries = entries[1:]
+		}
+		return nil
+	})
+	if err != nil {
+		middleware.WriteError(w, "DatabaseError", http.StatusInternalServerError, "Failed to query audit log", err)
+		return
+	}
+	for i, j := 0, len(entries)-1; i < j; i, j = i+1, j-1 {
+		entries[i], entries[j] = entries[j], entries[i]
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(entries)
+}
+
+// Export streams all entries matching the filters as newline-delimited JSON
+func (h *Handler) Export(w http.ResponseWriter, r *http.Request) {
+	f, err := parseFilter(r)
+	if err != nil {
+		middleware.WriteError(w, "ValidationError", http.StatusBadRequest, "Invalid filter", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"audit-%s.ndjson\"", time.Now().UTC().Format("20060102T150405Z")))
+
+	enc := json.NewEncoder(w)
+	err = h.store.Query(f, func(e *Entry) error {
+		return enc.Encode(e)
+	})
+	if err != nil {
+		// The status has already been sent, so the export can only be cut short
+		log.Printf("Error exporting audit log: %v", err)
+	}
+}
+
+// VerifyResponse reports the result of checking the hash chain
+type VerifyResponse struct {
+	Entries int    `json:"entries"`
+	Valid   bool   `json:"valid"`
+	Error   string `json:"error,omitempty"`
+}
+
+// Verify checks the hash chain of the whole log
+func (h *Handler) Verify(w http.ResponseWriter, r *http.Request) {
+	n, err := Verify(h.store)
+	resp := VerifyResponse{Entries: n, Valid: err == nil}
+	if err != nil {
+		resp.Error = err.Error()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+func parseFilter(r *http.Request) (Filter, error) {
+	q := r.URL.Query()
+	f := Filter{
+		ActorID:    q.Get("actor"),
+		Action:     q.Get("action"),
+		TargetType: q.Get("target_type"),
+		TargetID:   q.Get("target_id"),
+		RequestID:  q.Get("request_id"),
+	}
+
+	var err error
+	if v := q.Get("since"); v != "" {
+		if f.Since, err = time.Parse(time.RFC3339, v); err != nil {
+			return f, fmt.Errorf("invalid since: %v", err)
+		}
+	}
+	if v := q.Get("until"); v != "" {
+		if f.Until, err = time.Parse(time.RFC3339, v); err != nil {
+			return f, fmt.Errorf("invalid until: %v", err)
+		}
+	}
+	return f, nil
+}
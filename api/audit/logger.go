@@ -0,0 +1,115 @@
+package audit
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/ringo380/lessoncraft/api/auth"
+)
+
+// Logger records audited actions to a Store. Its Record method satisfies the
+// auditor hooks of the lesson, auth and playground handlers.
+type Logger struct {
+	store      Store
+	trustProxy bool
+	now        func() time.Time
+}
+
+// NewLogger creates a new Logger writing to the given store
+func NewLogger(store Store) *Logger {
+	return &Logger{
+		store: store,
+		now:   time.Now,
+	}
+}
+
+// SetTrustProxy makes the logger take the source IP from the X-Forwarded-For
+// header. Only enable it when the server is behind a proxy that sets the header,
+// otherwise clients can forge their address.
+func (l *Logger) SetTrustProxy(trust bool) {
+	l.trustProxy = trust
+}
+
+// Record appends an entry for an action on a target. before and after are the
+// state of the target around the action and are stored as hashes only; pass nil
+// when there is no such state. Failures are logged rather than returned, so
+// auditing never fails the request that triggered it.
+func (l *Logger) Record(r *http.Request, action, targetType, targetID string, before, after interface{}) {
+	e := &Entry{
+		ID:         uuid.New().String(),
+		Time:       l.now().UTC(),
+		Action:     action,
+		TargetType: targetType,
+		TargetID:   targetID,
+		BeforeHash: hashState(before),
+		AfterHash:  hashState(after),
+	}
+	if r != nil {
+		e.ActorType, e.ActorID, e.APIKeyID = actor(r)
+		e.RequestID = r.Header.Get("X-Request-ID")
+		e.SourceIP = l.sourceIP(r)
+	}
+
+	if err := l.store.Append(e); err != nil {
+		log.Printf("Error writing audit entry for %s on %s %s: %v", action, targetType, targetID, err)
+	}
+}
+
+// actor works out who made the request. Routes behind AuthMiddleware carry the
+// user in the context; the playground admin endpoints authenticate inline with
+// either an API key or the shared admin token.
+func actor(r *http.Request) (ActorType, string, string) {
+	if userID, ok := auth.GetUserID(r); ok {
+		if keyID, ok := auth.GetAPIKeyID(r); ok {
+			return ActorAPIKey, userID, keyID
+		}
+		return ActorUser, userID, ""
+	}
+
+	if token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer "); strings.HasPrefix(token, auth.APIKeyPrefix) {
+		// Only the public prefix of the key is recorded, never the secret
+		prefix := ""
+		if i := strings.LastIndex(token, "_"); i > len(auth.APIKeyPrefix) {
+			prefix = token[:i]
+		}
+		return ActorAPIKey, "", prefix
+	}
+
+	if _, _, ok := r.BasicAuth(); ok {
+		return ActorAdminToken, "", ""
+	}
+	return ActorAnonymous, "", ""
+}
+
+func (l *Logger) sourceIP(r *http.Request) string {
+	if l.trustProxy {
+		if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
+			return strings.TrimSpace(strings.Split(forwarded, ",")[0])
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// hashState returns the hex SHA-256 of the JSON encoding of v, or "" for nil
+func hashState(v interface{}) string {
+	if v == nil {
+		return ""
+	}
+	data, err := json.Marshal(v)
+	if err != nil || string(data) == "null" {
+		return ""
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
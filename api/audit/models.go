@@ -0,0 +1,89 @@
+package audit
+
+import (
+	"strings"
+	"time"
+)
+
+// ActorType describes how the actor of an audited action was authenticated
+type ActorType string
+
+const (
+	// ActorUser is a user authenticated with a JWT
+	ActorUser ActorType = "user"
+	// ActorAPIKey is a service account authenticated with an API key
+	ActorAPIKey ActorType = "api_key"
+	// ActorAdminToken is a caller using the shared admin token
+	ActorAdminToken ActorType = "admin_token"
+	// ActorAnonymous is an unauthenticated caller, e.g. a user registering or logging in
+	ActorAnonymous ActorType = "anonymous"
+)
+
+// Entry is a single record in the audit log. Entries are never modified once
+// appended; each one includes the hash of the previous entry, so removing or
+// editing an entry breaks the chain and is detected by Verify.
+type Entry struct {
+	ID        string    `json:"id"`
+	Time      time.Time `json:"time"`
+	ActorType ActorType `json:"actor_type"`
+	ActorID   string    `json:"actor_id,omitempty"`
+	// APIKeyID is the ID of the API key used, or its public prefix on routes that
+	// check the key themselves instead of going through AuthMiddleware
+	APIKeyID   string `json:"api_key_id,omitempty"`
+	Action     string `json:"action"`
+	TargetType string `json:"target_type"`
+	TargetID   string `json:"target_id,omitempty"`
+	// BeforeHash and AfterHash are SHA-256 hashes of the JSON encoding of the target
+	// before and after the action. They are empty when there is no such state,
+	// e.g. before a create or after a delete.
+	BeforeHash string `json:"before_hash,omitempty"`
+	AfterHash  string `json:"after_hash,omitempty"`
+	RequestID  string `json:"request_id,omitempty"`
+	SourceIP   string `json:"source_ip,omitempty"`
+	PrevHash   string `json:"prev_hash"`
+	Hash       string `json:"hash"`
+}
+
+// Filter selects audit entries. Empty fields match everything.
+type Filter struct {
+	ActorID string
+	// Action matches exactly, or by prefix when it ends with a dot (e.g. "lesson.")
+	Action     string
+	TargetType string
+	TargetID   string
+	RequestID  string
+	Since      time.Time
+	Until      time.Time
+}
+
+// Match checks if an entry is selected by the filter
+func (f Filter) Match(e *Entry) bool {
+	if f.ActorID != "" && e.ActorID != f.ActorID {
+		return false
+	}
+	if f.Action != "" {
+		if strings.HasSuffix(f.Action, ".") {
+			if !strings.HasPrefix(e.Action, f.Action) {
+				return false
+			}
+		} else if e.Action != f.Action {
+			return false
+		}
+	}
+	if f.TargetType != "" && e.TargetType != f.TargetType {
+		return false
+	}
+	if f.TargetID != "" && e.TargetID != f.TargetID {
+		return false
+	}
+	if f.RequestID != "" && e.RequestID != f.RequestID {
+		return false
+	}
+	if !f.Since.IsZero() && e.Time.Before(f.Since) {
+		return false
+	}
+	if !f.Until.IsZero() && !e.Time.Before(f.Until) {
+		return false
+	}
+	return true
+}
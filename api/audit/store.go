@@ -0,0 +1,199 @@
+package audit
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+)
+
+// ErrStop can be returned from a Query callback to stop iterating without an error
+var ErrStop = errors.New("stop")
+
+// Store defines the interface for audit log storage. Stores are append-only:
+// there is no way to update or delete entries.
+type Store interface {
+	// Append seals the entry into the hash chain and stores it
+	Append(e *Entry) error
+	// Query calls fn for every entry matching the filter, oldest first. Iteration
+	// stops at the first error returned by fn; ErrStop is not reported to the caller.
+	Query(f Filter, fn func(*Entry) error) error
+}
+
+// seal links the entry to the previous one and computes its hash
+func seal(e *Entry, prevHash string) error {
+	e.PrevHash = prevHash
+	e.Hash = ""
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	sum := sha256.Sum256(data)
+	e.Hash = hex.EncodeToString(sum[:])
+	return nil
+}
+
+// Verify walks the whole store and checks the hash chain. It returns the number
+// of entries checked and an error describing the first broken link.
+func Verify(store Store) (int, error) {
+	n := 0
+	prev := ""
+	err := store.Query(Filter{}, func(e *Entry) error {
+		n++
+		check := *e
+		if err := seal(&check, prev); err != nil {
+			return err
+		}
+		if e.PrevHash != prev || check.Hash != e.Hash {
+			return fmt.Errorf("audit entry %s breaks the hash chain", e.ID)
+		}
+		prev = e.Hash
+		return nil
+	})
+	return n, err
+}
+
+// MemoryStore is an in-memory implementation of Store
+type MemoryStore struct {
+	mu      sync.RWMutex
+	entries []*Entry
+}
+
+// NewMemoryStore creates a new MemoryStore
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{}
+}
+
+// Append seals the entry into the hash chain and stores it
+func (s *MemoryStore) Append(e *Entry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	prev := ""
+	if len(s.entries) > 0 {
+		prev = s.entries[len(s.entries)-1].Hash
+	}
+	if err := seal(e, prev); err != nil {
+		return err
+	}
+	stored := *e
+	s.entries = append(s.entries, &stored)
+	return nil
+}
+
+// Query calls fn for every entry matching the filter, oldest first
+func (s *MemoryStore) Query(f Filter, fn func(*Entry) error) error {
+	s.mu.RLock()
+	entries := s.entries
+	s.mu.RUnlock()
+
+	for _, e := range entries {
+		if !f.Match(e) {
+			continue
+		}
+		entry := *e
+		if err := fn(&entry); err != nil {
+			if err == ErrStop {
+				return nil
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// FileStore is an implementation of Store that appends entries to a file as
+// newline-delimited JSON. Queries scan the file, so the log never has to fit in memory.
+type FileStore struct {
+	mu       sync.Mutex
+	path     string
+	file     *os.File
+	lastHash string
+}
+
+// NewFileStore opens or creates the audit log at path and resumes its hash chain
+func NewFileStore(path string) (*FileStore, error) {
+	s := &FileStore{path: path}
+	err := s.scan(Filter{}, func(e *Entry) error {
+		s.lastHash = e.Hash
+		return nil
+	})
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	s.file, err = os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Append seals the entry into the hash chain and writes it to the file
+func (s *FileStore) Append(e *Entry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := seal(e, s.lastHash); err != nil {
+		return err
+	}
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	if _, err := s.file.Write(append(data, '\n')); err != nil {
+		return err
+	}
+	if err := s.file.Sync(); err != nil {
+		return err
+	}
+	s.lastHash = e.Hash
+	return nil
+}
+
+// Query calls fn for every entry matching the filter, oldest first
+func (s *FileStore) Query(f Filter, fn func(*Entry) error) error {
+	err := s.scan(f, fn)
+	if err == ErrStop {
+		return nil
+	}
+	return err
+}
+
+// Close closes the underlying file
+func (s *FileStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.file.Close()
+}
+
+func (s *FileStore) scan(f Filter, fn func(*Entry) error) error {
+	file, err := os.Open(s.path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var e Entry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			return fmt.Errorf("corrupt audit log %s: %v", s.path, err)
+		}
+		if !f.Match(&e) {
+			continue
+		}
+		if err := fn(&e); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
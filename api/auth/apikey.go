@@ -0,0 +1,405 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Scope limits what an API key may do, on top of the roles of its service account
+type Scope string
+
+const (
+	// ScopeLessonsRead allows reading lessons
+	ScopeLessonsRead Scope = "lessons:read"
+	// ScopeLessonsWrite allows creating, updating and deleting lessons
+	ScopeLessonsWrite Scope = "lessons:write"
+	// ScopeSessionsWrite allows creating and closing sessions
+	ScopeSessionsWrite Scope = "sessions:write"
+	// ScopeOrgsRead allows reading organisations, classrooms and assignments
+	ScopeOrgsRead Scope = "orgs:read"
+	// ScopeOrgsWrite allows managing organisations, their members, invites,
+	// classrooms and assignments
+	ScopeOrgsWrite Scope = "orgs:write"
+	// ScopeAccountRead allows reading the sign-in methods of the service account
+	ScopeAccountRead Scope = "account:read"
+	// ScopeAccountWrite allows changing the password, linked identities and SSH
+	// keys of the service account
+	ScopeAccountWrite Scope = "account:write"
+	// ScopeAdmin allows using the admin endpoints
+	ScopeAdmin Scope = "admin"
+)
+
+// ValidScopes lists the scopes that can be granted to an API key
+var ValidScopes = []Scope{
+	ScopeLessonsRead, ScopeLessonsWrite, ScopeSessionsWrite,
+	ScopeOrgsRead, ScopeOrgsWrite, ScopeAccountRead, ScopeAccountWrite,
+	ScopeAdmin,
+}
+
+// APIKeyPrefix starts every API key so keys can be told apart from JWTs and
+// found by secret scanners
+const APIKeyPrefix = "lck_"
+
+// apiKeyLastUsedInterval limits how often the last-used time is written to the store
+const apiKeyLastUsedInterval = time.Minute
+
+var (
+	// ErrAPIKeyNotFound is returned when an API key does not exist
+	ErrAPIKeyNotFound = errors.New("api key not found")
+	// ErrInvalidScope is returned when an API key is requested with an unknown scope
+	ErrInvalidScope = errors.New("invalid api key scope")
+)
+
+// APIKey is a long-lived credential owned by a service account. Only a hash of the
+// secret is stored; the full key is shown once when it is created.
+type APIKey struct {
+	// ID is a unique identifier for the key
+	ID string `json:"id" bson:"id"`
+	// Name describes what the key is used for (e.g. "CI lesson publishing")
+	Name string `json:"name" bson:"name"`
+	// Prefix is the public part of the key, used to look it up and to recognise it
+	Prefix string `json:"prefix" bson:"prefix"`
+	// Hash is the SHA-256 hash of the secret part of the key
+	Hash string `json:"-" bson:"hash"`
+	// OwnerID is the ID of the service account the key belongs to
+	OwnerID string `json:"owner_id" bson:"owner_id"`
+	// Scopes limits what the key may do
+	Scopes []Scope `json:"scopes" bson:"scopes"`
+	// CreatedBy is the ID of the user that created the key
+	CreatedBy string `json:"created_by" bson:"created_by"`
+	// CreatedAt records when the key was created
+	CreatedAt time.Time `json:"created_at" bson:"created_at"`
+	// ExpiresAt is when the key stops working (zero means never)
+	ExpiresAt time.Time `json:"expires_at,omitempty" bson:"expires_at,omitempty"`
+	// LastUsedAt records when the key was last used, at minute granularity
+	LastUsedAt time.Time `json:"last_used_at,omitempty" bson:"last_used_at,omitempty"`
+	// RevokedAt records when the key was revoked (zero if active)
+	RevokedAt time.Time `json:"revoked_at,omitempty" bson:"revoked_at,omitempty"`
+}
+
+// Revoked checks if the key has been revoked
+func (k *APIKey) Revoked() bool {
+	return !k.RevokedAt.IsZero()
+}
+
+// Expired checks if the key has expired at the given time
+func (k *APIKey) Expired(now time.Time) bool {
+	return !k.ExpiresAt.IsZero() && now.After(k.ExpiresAt)
+}
+
+// HasScope checks if the key was granted the given scope
+func (k *APIKey) HasScope(scope Scope) bool {
+	for _, s := range k.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// APIKeyStore defines the interface for API key storage operations
+type APIKeyStore interface {
+	// CreateAPIKey stores a new API key
+	CreateAPIKey(key *APIKey) error
+	// GetAPIKey retrieves an API key by ID
+	GetAPIKey(id string) (*APIKey, error)
+	// GetAPIKeyByPrefix retrieves an API key by its public prefix
+	GetAPIKeyByPrefix(prefix string) (*APIKey, error)
+	// ListAPIKeys retrieves the API keys owned by a service account
+	ListAPIKeys(ownerID string) ([]*APIKey, error)
+	// UpdateAPIKey updates an existing API key
+	UpdateAPIKey(key *APIKey) error
+	// TouchAPIKey records when an API key was last used
+	TouchAPIKey(id string, t time.Time) error
+}
+
+// MemoryAPIKeyStore is an in-memory implementation of the APIKeyStore interface
+// It is primarily used for testing purposes. Keys are copied in and out, so
+// callers never share a key with concurrent requests.
+type MemoryAPIKeyStore struct {
+	keys     map[string]*APIKey // Map of key ID to key
+	prefixes map[string]string  // Map of prefix to key ID
+	mu       sync.RWMutex
+}
+
+// NewMemoryAPIKeyStore creates a new in-memory API key store
+func NewMemoryAPIKeyStore() *MemoryAPIKeyStore {
+	return &MemoryAPIKeyStore{
+		keys:     make(map[string]*APIKey),
+		prefixes: make(map[string]string),
+	}
+}
+
+// CreateAPIKey stores a new API key
+func (s *MemoryAPIKeyStore) CreateAPIKey(key *APIKey) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.prefixes[key.Prefix]; ok {
+		return fmt.Errorf("api key prefix %s already exists", key.Prefix)
+	}
+	stored := *key
+	s.keys[key.ID] = &stored
+	s.prefixes[key.Prefix] = key.ID
+	return nil
+}
+
+// GetAPIKey retrieves an API key by ID
+func (s *MemoryAPIKeyStore) GetAPIKey(id string) (*APIKey, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	key, ok := s.keys[id]
+	if !ok {
+		return nil, ErrAPIKeyNotFound
+	}
+	k := *key
+	return &k, nil
+}
+
+// GetAPIKeyByPrefix retrieves an API key by its public prefix
+func (s *MemoryAPIKeyStore) GetAPIKeyByPrefix(prefix string) (*APIKey, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	id, ok := s.prefixes[prefix]
+	if !ok {
+		return nil, ErrAPIKeyNotFound
+	}
+	k := *s.keys[id]
+	return &k, nil
+}
+
+// ListAPIKeys retrieves the API keys owned by a service account
+func (s *MemoryAPIKeyStore) ListAPIKeys(ownerID string) ([]*APIKey, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	keys := []*APIKey{}
+	for _, key := range s.keys {
+		if key.OwnerID == ownerID {
+			k := *key
+			keys = append(keys, &k)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].CreatedAt.Before(keys[j].CreatedAt) })
+	return keys, nil
+}
+
+// UpdateAPIKey updates an existing API key
+func (s *MemoryAPIKeyStore) UpdateAPIKey(key *APIKey) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.keys[key.ID]; !ok {
+		return ErrAPIKeyNotFound
+	}
+	stored := *key
+	s.keys[key.ID] = &stored
+	return nil
+}
+
+// TouchAPIKey records when an API key was last used
+func (s *MemoryAPIKeyStore) TouchAPIKey(id string, t time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key, ok := s.keys[id]
+	if !ok {
+		return ErrAPIKeyNotFound
+	}
+	key.LastUsedAt = t
+	return nil
+}
+
+// APIKeyService issues and validates API keys. It implements TokenValidator, so it
+// can be passed to AuthMiddleware to accept API keys alongside user JWTs.
+type APIKeyService struct {
+	store      APIKeyStore
+	users      UserStore
+	jwtService *JWTService
+}
+
+// NewAPIKeyService creates a new API key service. Tokens that are not API keys are
+// validated with the JWT service.
+func NewAPIKeyService(store APIKeyStore, users UserStore, jwtService *JWTService) *APIKeyService {
+	return &APIKeyService{
+		store:      store,
+		users:      users,
+		jwtService: jwtService,
+	}
+}
+
+// Generate creates a new API key for a service account and returns the full key,
+// which cannot be retrieved again.
+func (s *APIKeyService) Generate(owner *UserWithAuth, name string, scopes []Scope, expiresAt time.Time, createdBy string) (string, *APIKey, error) {
+	if !owner.ServiceAccount {
+		return "", nil, fmt.Errorf("user %s is not a service account", owner.Id)
+	}
+	for _, scope := range scopes {
+		if !validScope(scope) {
+			return "", nil, fmt.Errorf("%w: %s", ErrInvalidScope, scope)
+		}
+	}
+
+	prefix, err := randomToken(6)
+	if err != nil {
+		return "", nil, err
+	}
+	secret, err := randomToken(32)
+	if err != nil {
+		return "", nil, err
+	}
+
+	key := &APIKey{
+		ID:        uuid.New().String(),
+		Name:      name,
+		Prefix:    APIKeyPrefix + prefix,
+		Hash:      hashSecret(secret),
+		OwnerID:   owner.Id,
+		Scopes:    scopes,
+		CreatedBy: createdBy,
+		CreatedAt: time.Now(),
+		ExpiresAt: expiresAt,
+	}
+	if err := s.store.CreateAPIKey(key); err != nil {
+		return "", nil, err
+	}
+
+	log.Printf("API key %s (%s) created for service account %s by %s\n", key.ID, key.Prefix, owner.Id, createdBy)
+	return key.Prefix + "_" + secret, key, nil
+}
+
+// Revoke revokes an API key. Revoked keys are kept so they can still be audited.
+func (s *APIKeyService) Revoke(id, revokedBy string) (*APIKey, error) {
+	key, err := s.store.GetAPIKey(id)
+	if err != nil {
+		return nil, err
+	}
+	if key.Revoked() {
+		return key, nil
+	}
+
+	key.RevokedAt = time.Now()
+	if err := s.store.UpdateAPIKey(key); err != nil {
+		return nil, err
+	}
+
+	log.Printf("API key %s (%s) revoked by %s\n", key.ID, key.Prefix, revokedBy)
+	return key, nil
+}
+
+// ValidateToken validates an API key or, for any other token, a user JWT
+func (s *APIKeyService) ValidateToken(token string) (*TokenClaims, error) {
+	if !strings.HasPrefix(token, APIKeyPrefix) {
+		return s.jwtService.ValidateToken(token)
+	}
+	return s.ValidateAPIKey(token)
+}
+
+// ValidateAPIKey validates an API key and returns claims with the roles of its
+// service account and the scopes of the key
+func (s *APIKeyService) ValidateAPIKey(token string) (*TokenClaims, error) {
+	i := strings.LastIndex(token, "_")
+	if i <= len(APIKeyPrefix) {
+		return nil, ErrInvalidToken
+	}
+	prefix, secret := token[:i], token[i+1:]
+
+	key, err := s.store.GetAPIKeyByPrefix(prefix)
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+	if subtle.ConstantTimeCompare([]byte(key.Hash), []byte(hashSecret(secret))) != 1 {
+		return nil, ErrInvalidToken
+	}
+
+	now := time.Now()
+	if key.Revoked() {
+		log.Printf("Rejected revoked API key %s (%s)\n", key.ID, key.Prefix)
+		return nil, ErrInvalidToken
+	}
+	if key.Expired(now) {
+		return nil, ErrExpiredToken
+	}
+
+	owner, err := s.users.GetUserByID(key.OwnerID)
+	if err != nil || !owner.ServiceAccount || owner.IsBanned || (owner.AccountStatus != "" && owner.AccountStatus != "active") {
+		log.Printf("Rejected API key %s (%s): service account %s is not active\n", key.ID, key.Prefix, key.OwnerID)
+		return nil, ErrInvalidToken
+	}
+
+	if now.Sub(key.LastUsedAt) > apiKeyLastUsedInterval {
+		if err := s.store.TouchAPIKey(key.ID, now); err != nil {
+			log.Printf("Could not update last use of API key %s: %v\n", key.ID, err)
+		}
+	}
+
+	return &TokenClaims{
+		UserID:   owner.Id,
+		Email:    owner.Email,
+		Roles:    owner.Roles,
+		APIKeyID: key.ID,
+		Scopes:   key.Scopes,
+		IssuedAt: key.CreatedAt.Unix(),
+		Subject:  owner.Id,
+	}, nil
+}
+
+// AuthorizeAdmin checks if the token is an API key with the admin scope whose service
+// account has the admin role. It lets automation use endpoints protected by the shared
+// admin token with a revocable credential instead.
+func (s *APIKeyService) AuthorizeAdmin(token string) bool {
+	if !strings.HasPrefix(token, APIKeyPrefix) {
+		return false
+	}
+	claims, err := s.ValidateAPIKey(token)
+	if err != nil {
+		return false
+	}
+	hasRole := false
+	for _, role := range claims.Roles {
+		hasRole = hasRole || role == RoleAdmin
+	}
+	for _, scope := range claims.Scopes {
+		if scope == ScopeAdmin && hasRole {
+			return true
+		}
+	}
+	return false
+}
+
+func validScope(scope Scope) bool {
+	for _, s := range ValidScopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+func hashSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+func randomToken(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	// The separator between prefix and secret is "_", so it must not appear in either part
+	return strings.ReplaceAll(base64.RawURLEncoding.EncodeToString(b), "_", "-"), nil
+}
@@ -0,0 +1,279 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/ringo380/lessoncraft/api/middleware"
+	"github.com/ringo380/lessoncraft/pwd/types"
+)
+
+// serviceAccountEmailDomain is used for the placeholder email of service accounts,
+// which never receive mail. The .invalid TLD is reserved and cannot be registered.
+const serviceAccountEmailDomain = "service-accounts.invalid"
+
+// APIKeyHandler handles HTTP requests to manage service accounts and their API keys.
+// All routes require the admin role, and API keys need the admin scope.
+type APIKeyHandler struct {
+	users   UserStore
+	apiKeys *APIKeyService
+	keys    APIKeyStore
+	auditor Auditor // Optional audit log, nil disables auditing
+}
+
+// NewAPIKeyHandler creates a new APIKeyHandler
+func NewAPIKeyHandler(users UserStore, keys APIKeyStore, apiKeys *APIKeyService) *APIKeyHandler {
+	return &APIKeyHandler{
+		users:   users,
+		apiKeys: apiKeys,
+		keys:    keys,
+	}
+}
+
+// SetAuditor sets the audit log that service account and key changes are recorded in
+func (h *APIKeyHandler) SetAuditor(auditor Auditor) {
+	h.auditor = auditor
+}
+
+func (h *APIKeyHandler) audit(r *http.Request, action, targetType, targetID string, before, after interface{}) {
+	if h.auditor != nil {
+		h.auditor.Record(r, action, targetType, targetID, before, after)
+	}
+}
+
+// RegisterRoutes registers the service account routes with the provided router
+func (h *APIKeyHandler) RegisterRoutes(r *mux.Router) {
+	authMiddleware := AuthMiddleware(h.apiKeys)
+	adminMiddleware := RoleMiddleware(RoleAdmin)
+	scopeMiddleware := ScopeMiddleware(ScopeAdmin)
+	handle := func(path string, f http.HandlerFunc, method string) {
+		r.Handle(path, authMiddleware(adminMiddleware(scopeMiddleware(f)))).Methods(method)
+	}
+
+	handle("/api/service-accounts", h.CreateServiceAccount, "POST")
+	handle("/api/service-accounts", h.ListServiceAccounts, "GET")
+	handle("/api/service-accounts/{id}", h.DeleteServiceAccount, "DELETE")
+	handle("/api/service-accounts/{id}/keys", h.CreateKey, "POST")
+	handle("/api/service-accounts/{id}/keys", h.ListKeys, "GET")
+	handle("/api/service-accounts/{id}/keys/{keyId}", h.RevokeKey, "DELETE")
+}
+
+// CreateServiceAccountRequest represents a request to create a service account
+type CreateServiceAccountRequest struct {
+	Name  string `json:"name"`
+	Roles []Role `json:"roles"`
+}
+
+// CreateAPIKeyRequest represents a request to create an API key
+type CreateAPIKeyRequest struct {
+	Name   string  `json:"name"`
+	Scopes []Scope `json:"scopes"`
+	// ExpiresIn is how long the key is valid, as a Go duration string (e.g. "2160h").
+	// Keys without expiry must be revoked explicitly.
+	ExpiresIn string `json:"expires_in"`
+}
+
+// CreateAPIKeyResponse contains the full API key, which is only returned once
+type CreateAPIKeyResponse struct {
+	Key    string  `json:"key"`
+	APIKey *APIKey `json:"api_key"`
+}
+
+// CreateServiceAccount creates a service account with the given roles
+func (h *APIKeyHandler) CreateServiceAccount(w http.ResponseWriter, r *http.Request) {
+	var req CreateServiceAccountRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		middleware.WriteError(w, "InvalidRequest", http.StatusBadRequest, "Invalid request format", err)
+		return
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" || len(req.Roles) == 0 {
+		middleware.WriteError(w, "ValidationError", http.StatusBadRequest, "Name and at least one role are required", nil)
+		return
+	}
+	for _, role := range req.Roles {
+		if role != RoleAdmin && role != RoleEducator && role != RoleLearner {
+			middleware.WriteError(w, "ValidationError", http.StatusBadRequest, "Invalid role "+string(role), nil)
+			return
+		}
+	}
+
+	now := time.Now()
+	id := uuid.New().String()
+	user := &UserWithAuth{
+		User: types.User{
+			Id:       id,
+			Name:     req.Name,
+			Email:    id + "@" + serviceAccountEmailDomain,
+			Provider: "service-account",
+		},
+		Roles:          req.Roles,
+		AccountStatus:  "active",
+		CreatedAt:      now,
+		UpdatedAt:      now,
+		ServiceAccount: true,
+	}
+	if err := h.users.CreateUser(user); err != nil {
+		middleware.WriteError(w, "DatabaseError", http.StatusInternalServerError, "Failed to create service account", err)
+		return
+	}
+	h.audit(r, "service_account.create", "user", user.Id, nil, user)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(user)
+}
+
+// ListServiceAccounts returns all service accounts
+func (h *APIKeyHandler) ListServiceAccounts(w http.ResponseWriter, r *http.Request) {
+	users, err := h.users.ListUsers()
+	if err != nil {
+		middleware.WriteError(w, "DatabaseError", http.StatusInternalServerError, "Failed to retrieve service accounts", err)
+		return
+	}
+
+	accounts := []*UserWithAuth{}
+	for _, user := range users {
+		if user.ServiceAccount {
+			accounts = append(accounts, user)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(accounts)
+}
+
+// DeleteServiceAccount revokes all keys of a service account and deletes it
+func (h *APIKeyHandler) DeleteServiceAccount(w http.ResponseWriter, r *http.Request) {
+	account, ok := h.serviceAccount(w, r)
+	if !ok {
+		return
+	}
+	userID, _ := GetUserID(r)
+
+	keys, err := h.keys.ListAPIKeys(account.Id)
+	if err != nil {
+		middleware.WriteError(w, "DatabaseError", http.StatusInternalServerError, "Failed to retrieve API keys", err)
+		return
+	}
+	for _, key := range keys {
+		if key.Revoked() {
+			continue
+		}
+		before := *key
+		revoked, err := h.apiKeys.Revoke(key.ID, userID)
+		if err != nil {
+			middleware.WriteError(w, "DatabaseError", http.StatusInternalServerError, "Failed to revoke API key", err)
+			return
+		}
+		h.audit(r, "api_key.revoke", "api_key", key.ID, before, revoked)
+	}
+
+	if err := h.users.DeleteUser(account.Id); err != nil {
+		middleware.WriteError(w, "DatabaseError", http.StatusInternalServerError, "Failed to delete service account", err)
+		return
+	}
+	h.audit(r, "service_account.delete", "user", account.Id, account, nil)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// CreateKey creates an API key for a service account
+func (h *APIKeyHandler) CreateKey(w http.ResponseWriter, r *http.Request) {
+	account, ok := h.serviceAccount(w, r)
+	if !ok {
+		return
+	}
+	userID, _ := GetUserID(r)
+
+	var req CreateAPIKeyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		middleware.WriteError(w, "InvalidRequest", http.StatusBadRequest, "Invalid request format", err)
+		return
+	}
+	if strings.TrimSpace(req.Name) == "" || len(req.Scopes) == 0 {
+		middleware.WriteError(w, "ValidationError", http.StatusBadRequest, "Name and at least one scope are required", nil)
+		return
+	}
+
+	var expiresAt time.Time
+	if req.ExpiresIn != "" {
+		d, err := time.ParseDuration(req.ExpiresIn)
+		if err != nil || d <= 0 {
+			middleware.WriteError(w, "ValidationError", http.StatusBadRequest, "Invalid expires_in duration", err)
+			return
+		}
+		expiresAt = time.Now().Add(d)
+	}
+
+	key, apiKey, err := h.apiKeys.Generate(account, strings.TrimSpace(req.Name), req.Scopes, expiresAt, userID)
+	if err != nil {
+		if errors.Is(err, ErrInvalidScope) {
+			middleware.WriteError(w, "ValidationError", http.StatusBadRequest, "Invalid scope", err)
+			return
+		}
+		middleware.WriteError(w, "DatabaseError", http.StatusInternalServerError, "Failed to create API key", err)
+		return
+	}
+	h.audit(r, "api_key.create", "api_key", apiKey.ID, nil, apiKey)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(CreateAPIKeyResponse{Key: key, APIKey: apiKey})
+}
+
+// ListKeys returns the API keys of a service account, including revoked keys
+func (h *APIKeyHandler) ListKeys(w http.ResponseWriter, r *http.Request) {
+	account, ok := h.serviceAccount(w, r)
+	if !ok {
+		return
+	}
+
+	keys, err := h.keys.ListAPIKeys(account.Id)
+	if err != nil {
+		middleware.WriteError(w, "DatabaseError", http.StatusInternalServerError, "Failed to retrieve API keys", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(keys)
+}
+
+// RevokeKey revokes an API key of a service account
+func (h *APIKeyHandler) RevokeKey(w http.ResponseWriter, r *http.Request) {
+	account, ok := h.serviceAccount(w, r)
+	if !ok {
+		return
+	}
+	userID, _ := GetUserID(r)
+
+	key, err := h.keys.GetAPIKey(mux.Vars(r)["keyId"])
+	if err != nil || key.OwnerID != account.Id {
+		middleware.WriteError(w, "NotFound", http.StatusNotFound, "API key not found", err)
+		return
+	}
+
+	before := *key
+	revoked, err := h.apiKeys.Revoke(key.ID, userID)
+	if err != nil {
+		middleware.WriteError(w, "DatabaseError", http.StatusInternalServerError, "Failed to revoke API key", err)
+		return
+	}
+	h.audit(r, "api_key.revoke", "api_key", key.ID, before, revoked)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *APIKeyHandler) serviceAccount(w http.ResponseWriter, r *http.Request) (*UserWithAuth, bool) {
+	user, err := h.users.GetUserByID(mux.Vars(r)["id"])
+	if err != nil || !user.ServiceAccount {
+		middleware.WriteError(w, "NotFound", http.StatusNotFound, "Service account not found", err)
+		return nil, false
+	}
+	return user, true
+}
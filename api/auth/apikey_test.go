@@ -0,0 +1,203 @@
+package auth
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/ringo380/lessoncraft/api/apitest"
+	"github.com/stretchr/testify/assert"
+)
+
+type apiKeyTestEnv struct {
+	users   *MemoryUserStore
+	keys    *MemoryAPIKeyStore
+	jwt     *JWTService
+	apiKeys *APIKeyService
+	router  *mux.Router
+	admin   string // JWT of an admin user
+}
+
+func newAPIKeyTestEnv(t *testing.T) *apiKeyTestEnv {
+	env := &apiKeyTestEnv{
+		users: NewMemoryUserStore(),
+		keys:  NewMemoryAPIKeyStore(),
+		jwt:   NewJWTService("secret", "lessoncraft", time.Hour),
+	}
+	env.apiKeys = NewAPIKeyService(env.keys, env.users, env.jwt)
+
+	admin := &UserWithAuth{Roles: []Role{RoleAdmin}, AccountStatus: "active"}
+	admin.Id = "admin"
+	admin.Email = "admin@example.com"
+	assert.Nil(t, env.users.CreateUser(admin))
+
+	token, _, err := env.jwt.GenerateToken(admin.Id, admin.Email, admin.Roles)
+	assert.Nil(t, err)
+	env.admin = token
+
+	env.router = mux.NewRouter()
+	NewAPIKeyHandler(env.users, env.keys, env.apiKeys).RegisterRoutes(env.router)
+
+	authHandler := NewAuthHandler(env.users, env.jwt)
+	authHandler.UseAPIKeys(env.apiKeys)
+	authHandler.RegisterRoutes(env.router)
+	NewOIDCHandler(authHandler).RegisterRoutes(env.router)
+	NewSSHKeyHandler(authHandler).RegisterRoutes(env.router)
+	return env
+}
+
+func (e *apiKeyTestEnv) do(token, method, path string, body interface{}) *httptest.ResponseRecorder {
+	return apitest.Do(e.router, token, method, path, body)
+}
+
+func (e *apiKeyTestEnv) createKey(t *testing.T, roles []Role, req CreateAPIKeyRequest) (string, *UserWithAuth, CreateAPIKeyResponse) {
+	rr := e.do(e.admin, "POST", "/api/service-accounts", CreateServiceAccountRequest{Name: "ci", Roles: roles})
+	assert.Equal(t, http.StatusCreated, rr.Code)
+	var account UserWithAuth
+	assert.Nil(t, json.NewDecoder(rr.Body).Decode(&account))
+	assert.True(t, account.ServiceAccount)
+
+	rr = e.do(e.admin, "POST", "/api/service-accounts/"+account.Id+"/keys", req)
+	assert.Equal(t, http.StatusCreated, rr.Code)
+	var resp CreateAPIKeyResponse
+	assert.Nil(t, json.NewDecoder(rr.Body).Decode(&resp))
+	return resp.Key, &account, resp
+}
+
+func TestAPIKey_Authenticates(t *testing.T) {
+	env := newAPIKeyTestEnv(t)
+	key, account, resp := env.createKey(t, []Role{RoleEducator}, CreateAPIKeyRequest{Name: "publish", Scopes: []Scope{ScopeLessonsWrite}})
+
+	assert.True(t, strings.HasPrefix(key, resp.APIKey.Prefix+"_"))
+	assert.True(t, strings.HasPrefix(key, APIKeyPrefix))
+
+	// Only the hash of the secret is stored
+	stored, err := env.keys.GetAPIKey(resp.APIKey.ID)
+	assert.Nil(t, err)
+	assert.NotContains(t, key, stored.Hash)
+	assert.NotEmpty(t, stored.Hash)
+
+	rr := env.do(key, "GET", "/api/auth/me", nil)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), account.Id)
+
+	stored, _ = env.keys.GetAPIKey(resp.APIKey.ID)
+	assert.False(t, stored.LastUsedAt.IsZero())
+
+	claims, err := env.apiKeys.ValidateToken(key)
+	assert.Nil(t, err)
+	assert.Equal(t, []Role{RoleEducator}, claims.Roles)
+	assert.Equal(t, []Scope{ScopeLessonsWrite}, claims.Scopes)
+
+	// User JWTs keep working through the same validator
+	claims, err = env.apiKeys.ValidateToken(env.admin)
+	assert.Nil(t, err)
+	assert.Equal(t, "admin", claims.UserID)
+
+	_, err = env.apiKeys.ValidateToken(resp.APIKey.Prefix + "_wrong")
+	assert.Equal(t, ErrInvalidToken, err)
+}
+
+func TestAPIKey_Revoke(t *testing.T) {
+	env := newAPIKeyTestEnv(t)
+	key, account, resp := env.createKey(t, []Role{RoleEducator}, CreateAPIKeyRequest{Name: "publish", Scopes: []Scope{ScopeLessonsWrite}})
+
+	rr := env.do(env.admin, "DELETE", "/api/service-accounts/"+account.Id+"/keys/"+resp.APIKey.ID, nil)
+	assert.Equal(t, http.StatusNoContent, rr.Code)
+
+	rr = env.do(key, "GET", "/api/auth/me", nil)
+	assert.Equal(t, http.StatusUnauthorized, rr.Code)
+
+	// Revoked keys are still listed for auditing
+	rr = env.do(env.admin, "GET", "/api/service-accounts/"+account.Id+"/keys", nil)
+	var keys []APIKey
+	assert.Nil(t, json.NewDecoder(rr.Body).Decode(&keys))
+	assert.Len(t, keys, 1)
+	assert.True(t, keys[0].Revoked())
+}
+
+func TestAPIKey_ConcurrentUse(t *testing.T) {
+	env := newAPIKeyTestEnv(t)
+	key, _, resp := env.createKey(t, []Role{RoleEducator}, CreateAPIKeyRequest{Name: "publish", Scopes: []Scope{ScopeLessonsWrite}})
+
+	// Recording the last use must not race with other requests or a revocation
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				env.apiKeys.ValidateToken(key)
+			}
+		}()
+	}
+	_, err := env.apiKeys.Revoke(resp.APIKey.ID, "admin")
+	assert.Nil(t, err)
+	wg.Wait()
+
+	stored, _ := env.keys.GetAPIKey(resp.APIKey.ID)
+	assert.True(t, stored.Revoked())
+	_, err = env.apiKeys.ValidateToken(key)
+	assert.Equal(t, ErrInvalidToken, err)
+}
+
+func TestAPIKey_Expired(t *testing.T) {
+	env := newAPIKeyTestEnv(t)
+	key, _, resp := env.createKey(t, []Role{RoleEducator}, CreateAPIKeyRequest{Name: "publish", Scopes: []Scope{ScopeLessonsRead}, ExpiresIn: "1h"})
+
+	stored, _ := env.keys.GetAPIKey(resp.APIKey.ID)
+	stored.ExpiresAt = time.Now().Add(-time.Minute)
+	assert.Nil(t, env.keys.UpdateAPIKey(stored))
+
+	_, err := env.apiKeys.ValidateToken(key)
+	assert.Equal(t, ErrExpiredToken, err)
+}
+
+func TestAPIKey_Scopes(t *testing.T) {
+	env := newAPIKeyTestEnv(t)
+
+	// An admin service account with a key lacking the admin scope cannot manage keys
+	key, _, _ := env.createKey(t, []Role{RoleAdmin}, CreateAPIKeyRequest{Name: "read", Scopes: []Scope{ScopeLessonsRead}})
+	rr := env.do(key, "GET", "/api/service-accounts", nil)
+	assert.Equal(t, http.StatusForbidden, rr.Code)
+	assert.False(t, env.apiKeys.AuthorizeAdmin(key))
+
+	key, _, _ = env.createKey(t, []Role{RoleAdmin}, CreateAPIKeyRequest{Name: "admin", Scopes: []Scope{ScopeAdmin}})
+	rr = env.do(key, "GET", "/api/service-accounts", nil)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.True(t, env.apiKeys.AuthorizeAdmin(key))
+
+	rr = env.do(env.admin, "POST", "/api/service-accounts/"+"missing"+"/keys", CreateAPIKeyRequest{Name: "x", Scopes: []Scope{ScopeAdmin}})
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+}
+
+func TestAPIKey_AccountScopes(t *testing.T) {
+	env := newAPIKeyTestEnv(t)
+
+	// Keys cannot change how their service account signs in without the account scopes
+	key, _, _ := env.createKey(t, []Role{RoleEducator}, CreateAPIKeyRequest{Name: "publish", Scopes: []Scope{ScopeLessonsWrite}})
+	assert.Equal(t, http.StatusForbidden, env.do(key, "POST", "/api/auth/password", SetPasswordRequest{NewPassword: "correct horse battery"}).Code)
+	assert.Equal(t, http.StatusForbidden, env.do(key, "GET", "/api/auth/identities", nil).Code)
+	assert.Equal(t, http.StatusForbidden, env.do(key, "GET", "/api/auth/ssh-keys", nil).Code)
+
+	key, _, _ = env.createKey(t, []Role{RoleEducator}, CreateAPIKeyRequest{Name: "account", Scopes: []Scope{ScopeAccountRead}})
+	assert.Equal(t, http.StatusOK, env.do(key, "GET", "/api/auth/identities", nil).Code)
+	assert.Equal(t, http.StatusOK, env.do(key, "GET", "/api/auth/ssh-keys", nil).Code)
+	assert.Equal(t, http.StatusForbidden, env.do(key, "POST", "/api/auth/password", SetPasswordRequest{NewPassword: "correct horse battery"}).Code)
+}
+
+func TestAPIKey_OnlyServiceAccounts(t *testing.T) {
+	env := newAPIKeyTestEnv(t)
+	admin, _ := env.users.GetUserByID("admin")
+
+	_, _, err := env.apiKeys.Generate(admin, "mine", []Scope{ScopeAdmin}, time.Time{}, "admin")
+	assert.NotNil(t, err)
+
+	rr := env.do(env.admin, "POST", "/api/service-accounts/admin/keys", CreateAPIKeyRequest{Name: "x", Scopes: []Scope{ScopeAdmin}})
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+}
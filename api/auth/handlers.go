@@ -24,12 +24,24 @@ type UserStore interface {
 	UpdateUser(id string, user *UserWithAuth) error
 	// DeleteUser deletes a user
 	DeleteUser(id string) error
+	// GetUserByIdentity retrieves the user that has linked the given provider identity
+	GetUserByIdentity(provider, subject string) (*UserWithAuth, error)
+	// ListUsers retrieves all users
+	ListUsers() ([]*UserWithAuth, error)
+}
+
+// Auditor records administrative and account actions in the audit log. before and
+// after are the state of the target around the action and may be nil.
+type Auditor interface {
+	Record(r *http.Request, action, targetType, targetID string, before, after interface{})
 }
 
 // AuthHandler handles HTTP requests related to authentication
 type AuthHandler struct {
 	userStore  UserStore
 	jwtService *JWTService
+	tokens     TokenValidator // Validates bearer tokens on protected routes
+	auditor    Auditor        // Optional audit log, nil disables auditing
 }
 
 // NewAuthHandler creates a new AuthHandler
@@ -37,9 +49,34 @@ func NewAuthHandler(userStore UserStore, jwtService *JWTService) *AuthHandler {
 	return &AuthHandler{
 		userStore:  userStore,
 		jwtService: jwtService,
+		tokens:     jwtService,
 	}
 }
 
+// UseAPIKeys makes the protected routes accept API keys in addition to user JWTs.
+// It must be called before RegisterRoutes.
+func (h *AuthHandler) UseAPIKeys(apiKeys *APIKeyService) {
+	h.tokens = apiKeys
+}
+
+// SetAuditor sets the audit log that registrations, logins and bans are recorded in
+func (h *AuthHandler) SetAuditor(auditor Auditor) {
+	h.auditor = auditor
+}
+
+// audit records an action on a user if an auditor is configured
+func (h *AuthHandler) audit(r *http.Request, action, userID string, before, after interface{}) {
+	if h.auditor != nil {
+		h.auditor.Record(r, action, "user", userID, before, after)
+	}
+}
+
+// TokenValidator returns the validator used on protected routes, so other handlers
+// can accept the same credentials
+func (h *AuthHandler) TokenValidator() TokenValidator {
+	return h.tokens
+}
+
 // RegisterRoutes registers the authentication routes with the provided router
 func (h *AuthHandler) RegisterRoutes(r *mux.Router) {
 	r.HandleFunc("/api/auth/register", h.Register).Methods("POST")
@@ -47,10 +84,15 @@ func (h *AuthHandler) RegisterRoutes(r *mux.Router) {
 	r.HandleFunc("/api/auth/refresh", h.RefreshToken).Methods("POST")
 
 	// Protected routes that require authentication
-	authMiddleware := AuthMiddleware(h.jwtService)
+	authMiddleware := AuthMiddleware(h.tokens)
 
 	r.Handle("/api/auth/me", authMiddleware(http.HandlerFunc(h.GetCurrentUser))).Methods("GET")
 	r.Handle("/api/auth/logout", authMiddleware(http.HandlerFunc(h.Logout))).Methods("POST")
+
+	// Admin routes
+	adminMiddleware := RoleMiddleware(RoleAdmin)
+	scopeMiddleware := ScopeMiddleware(ScopeAdmin)
+	r.Handle("/api/auth/users/{id}/ban", authMiddleware(adminMiddleware(scopeMiddleware(http.HandlerFunc(h.SetBanned))))).Methods("PUT")
 }
 
 // Register handles user registration
@@ -126,6 +168,7 @@ func (h *AuthHandler) Register(w http.ResponseWriter, r *http.Request) {
 		EmailVerified: false,
 		CreatedAt:     now,
 		UpdatedAt:     now,
+		Identities:    []Identity{{Provider: LocalProvider, Subject: req.Email, Email: req.Email, LinkedAt: now}},
 	}
 
 	if err := h.userStore.CreateUser(user); err != nil {
@@ -141,44 +184,8 @@ func (h *AuthHandler) Register(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Generate tokens
-	token, expiresAt, err := h.jwtService.GenerateToken(user.Id, user.Email, user.Roles)
-	if err != nil {
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(middleware.ErrorResponse{
-			Error:     "TokenGenerationError",
-			Code:      http.StatusInternalServerError,
-			Message:   "Failed to generate token",
-			Details:   err.Error(),
-			TimeStamp: time.Now(),
-		})
-		return
-	}
-
-	refreshToken, err := h.jwtService.GenerateRefreshToken()
-	if err != nil {
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(middleware.ErrorResponse{
-			Error:     "TokenGenerationError",
-			Code:      http.StatusInternalServerError,
-			Message:   "Failed to generate refresh token",
-			Details:   err.Error(),
-			TimeStamp: time.Now(),
-		})
-		return
-	}
-
-	// Return response
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusCreated)
-	json.NewEncoder(w).Encode(LoginResponse{
-		Token:        token,
-		RefreshToken: refreshToken,
-		ExpiresAt:    expiresAt,
-		User:         user.User,
-	})
+	h.audit(r, "user.register", user.Id, nil, user)
+	h.writeLoginResponse(w, http.StatusCreated, user)
 }
 
 // Login handles user login
@@ -237,6 +244,11 @@ func (h *AuthHandler) Login(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if user.IsBanned {
+		middleware.WriteError(w, "Forbidden", http.StatusForbidden, "Account is banned", nil)
+		return
+	}
+
 	// Update last login time
 	user.LastLogin = time.Now()
 	if err := h.userStore.UpdateUser(user.Id, user); err != nil {
@@ -244,38 +256,30 @@ func (h *AuthHandler) Login(w http.ResponseWriter, r *http.Request) {
 		// TODO: Add proper logging
 	}
 
+	h.audit(r, "user.login", user.Id, nil, nil)
+	h.writeLoginResponse(w, http.StatusOK, user)
+}
+
+// writeLoginResponse generates an access and refresh token for the user and writes
+// them as a LoginResponse. Every login method (password, OIDC) goes through here so
+// clients always receive the same kind of JWT.
+func (h *AuthHandler) writeLoginResponse(w http.ResponseWriter, status int, user *UserWithAuth) {
 	// Generate tokens
 	token, expiresAt, err := h.jwtService.GenerateToken(user.Id, user.Email, user.Roles)
 	if err != nil {
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(middleware.ErrorResponse{
-			Error:     "TokenGenerationError",
-			Code:      http.StatusInternalServerError,
-			Message:   "Failed to generate token",
-			Details:   err.Error(),
-			TimeStamp: time.Now(),
-		})
+		middleware.WriteError(w, "TokenGenerationError", http.StatusInternalServerError, "Failed to generate token", err)
 		return
 	}
 
 	refreshToken, err := h.jwtService.GenerateRefreshToken()
 	if err != nil {
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(middleware.ErrorResponse{
-			Error:     "TokenGenerationError",
-			Code:      http.StatusInternalServerError,
-			Message:   "Failed to generate refresh token",
-			Details:   err.Error(),
-			TimeStamp: time.Now(),
-		})
+		middleware.WriteError(w, "TokenGenerationError", http.StatusInternalServerError, "Failed to generate refresh token", err)
 		return
 	}
 
 	// Return response
 	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
+	w.WriteHeader(status)
 	json.NewEncoder(w).Encode(LoginResponse{
 		Token:        token,
 		RefreshToken: refreshToken,
@@ -353,3 +357,44 @@ func (h *AuthHandler) Logout(w http.ResponseWriter, r *http.Request) {
 		"message": "Logout successful",
 	})
 }
+
+// BanRequest represents a request to ban or unban a user
+type BanRequest struct {
+	Banned bool `json:"banned"`
+}
+
+// SetBanned bans or unbans a user. Banned users can no longer log in.
+func (h *AuthHandler) SetBanned(w http.ResponseWriter, r *http.Request) {
+	var req BanRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		middleware.WriteError(w, "InvalidRequest", http.StatusBadRequest, "Invalid request format", err)
+		return
+	}
+
+	user, err := h.userStore.GetUserByID(mux.Vars(r)["id"])
+	if err != nil {
+		middleware.WriteError(w, "NotFound", http.StatusNotFound, "User not found", err)
+		return
+	}
+	if userID, _ := GetUserID(r); userID == user.Id {
+		middleware.WriteError(w, "ValidationError", http.StatusBadRequest, "Cannot ban yourself", nil)
+		return
+	}
+
+	before := *user
+	user.IsBanned = req.Banned
+	user.UpdatedAt = time.Now()
+	if err := h.userStore.UpdateUser(user.Id, user); err != nil {
+		middleware.WriteError(w, "DatabaseError", http.StatusInternalServerError, "Failed to update user", err)
+		return
+	}
+
+	action := "user.unban"
+	if req.Banned {
+		action = "user.ban"
+	}
+	h.audit(r, action, user.Id, before, user)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(user.User)
+}
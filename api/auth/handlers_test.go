@@ -0,0 +1,66 @@
+package auth
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// recordingAuditor collects the actions recorded by the handlers
+type recordingAuditor struct {
+	actions []string
+}
+
+func (a *recordingAuditor) Record(r *http.Request, action, targetType, targetID string, before, after interface{}) {
+	a.actions = append(a.actions, action+" "+targetType+" "+targetID)
+}
+
+func TestSetBanned(t *testing.T) {
+	env := newAPIKeyTestEnv(t)
+	auditor := &recordingAuditor{}
+
+	// Replace the routes so they use the auditing handler
+	authHandler := NewAuthHandler(env.users, env.jwt)
+	authHandler.UseAPIKeys(env.apiKeys)
+	authHandler.SetAuditor(auditor)
+	env.router = mux.NewRouter()
+	authHandler.RegisterRoutes(env.router)
+
+	hash, err := bcrypt.GenerateFromPassword([]byte("password"), bcrypt.MinCost)
+	assert.Nil(t, err)
+	user := &UserWithAuth{PasswordHash: string(hash), Roles: []Role{RoleLearner}, AccountStatus: "active"}
+	user.Id = "bob"
+	user.Email = "bob@example.com"
+	assert.Nil(t, env.users.CreateUser(user))
+
+	login := func() int {
+		body, _ := json.Marshal(LoginRequest{Email: "bob@example.com", Password: "password"})
+		rr := httptest.NewRecorder()
+		env.router.ServeHTTP(rr, httptest.NewRequest("POST", "/api/auth/login", bytes.NewReader(body)))
+		return rr.Code
+	}
+	assert.Equal(t, http.StatusOK, login())
+
+	rr := env.do(env.admin, "PUT", "/api/auth/users/bob/ban", BanRequest{Banned: true})
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, http.StatusForbidden, login())
+
+	rr = env.do(env.admin, "PUT", "/api/auth/users/admin/ban", BanRequest{Banned: true})
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+
+	token, _, _ := env.jwt.GenerateToken("bob", "bob@example.com", []Role{RoleLearner})
+	rr = env.do(token, "PUT", "/api/auth/users/admin/ban", BanRequest{Banned: true})
+	assert.Equal(t, http.StatusForbidden, rr.Code)
+
+	rr = env.do(env.admin, "PUT", "/api/auth/users/bob/ban", BanRequest{Banned: false})
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, http.StatusOK, login())
+
+	assert.Equal(t, []string{"user.login user bob", "user.ban user bob", "user.unban user bob", "user.login user bob"}, auditor.actions)
+}
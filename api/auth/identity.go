@@ -0,0 +1,143 @@
+package auth
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/ringo380/lessoncraft/pwd/types"
+)
+
+var (
+	// ErrAccountInactive is returned when a banned or deactivated account logs in
+	ErrAccountInactive = errors.New("account is not active")
+	// ErrLinkRequired is returned when an identity has the email of an existing
+	// account that it cannot be linked to automatically. The user has to log in to
+	// that account and link the identity from there.
+	ErrLinkRequired = errors.New("identity must be linked from the existing account")
+)
+
+// ResolveIdentity returns the account of a user that logged in with one of the
+// legacy OAuth providers, creating it on first login. Legacy and OIDC logins share
+// accounts, so a user has a single identity whichever way they log in. New accounts
+// keep the ID of the legacy user so the sessions they already own stay theirs.
+func (h *AuthHandler) ResolveIdentity(provider string, user *types.User) (*types.User, error) {
+	// Legacy providers do not tell whether the email is verified, so it is never
+	// used to link an existing account
+	claims := &OIDCClaims{Subject: user.ProviderUserId, Email: user.Email, Name: user.Name, Picture: user.Avatar}
+	account, _, err := h.resolveIdentity(provider, claims, "", user.Id)
+	if err != nil {
+		return nil, err
+	}
+	return &account.User, nil
+}
+
+// LookupUser returns the account with the given ID. Banned accounts are returned
+// together with ErrAccountInactive.
+func (h *AuthHandler) LookupUser(id string) (*types.User, error) {
+	user, err := h.userStore.GetUserByID(id)
+	if err != nil {
+		return nil, err
+	}
+	if user.IsBanned {
+		return &user.User, ErrAccountInactive
+	}
+	return &user.User, nil
+}
+
+// resolveIdentity finds or creates the account for a verified identity. Identities are
+// matched by provider and subject first. Otherwise the identity is linked to the user
+// that started a link flow, or to an existing account with the same email when both
+// sides verified it, and finally a new account is created with newUserID, or a random
+// ID if it is empty. Returns an HTTP status code on error.
+func (h *AuthHandler) resolveIdentity(provider string, claims *OIDCClaims, linkUserID, newUserID string) (*UserWithAuth, int, error) {
+	store := h.userStore
+	now := time.Now()
+
+	user, err := store.GetUserByIdentity(provider, claims.Subject)
+	if err == nil && linkUserID != "" && user.Id != linkUserID {
+		return nil, http.StatusConflict, ErrIdentityAlreadyLinked
+	}
+
+	if err != nil {
+		if linkUserID != "" {
+			user, err = store.GetUserByID(linkUserID)
+			if err != nil {
+				return nil, http.StatusInternalServerError, err
+			}
+		} else if claims.Email != "" {
+			if existing, err := store.GetUserByEmail(claims.Email); err == nil {
+				// Anyone can register an account with somebody else's address, so only
+				// an address both sides proved ownership of links the two
+				if !claims.EmailVerified || !existing.EmailVerified || existing.ServiceAccount {
+					return nil, http.StatusConflict, ErrLinkRequired
+				}
+				user = existing
+			}
+		}
+
+		if user == nil {
+			if newUserID == "" {
+				newUserID = uuid.New().String()
+			} else if _, err := store.GetUserByID(newUserID); err == nil {
+				return nil, http.StatusConflict, ErrUserAlreadyExists
+			}
+			roles := claims.Roles
+			if len(roles) == 0 {
+				roles = []Role{RoleLearner}
+			}
+			user = &UserWithAuth{
+				User: types.User{
+					Id:             newUserID,
+					Name:           claims.Name,
+					Email:          claims.Email,
+					Avatar:         claims.Picture,
+					Provider:       provider,
+					ProviderUserId: claims.Subject,
+				},
+				Roles:         roles,
+				AccountStatus: "active",
+				EmailVerified: claims.EmailVerified,
+				CreatedAt:     now,
+				UpdatedAt:     now,
+			}
+			user.Identities = append(user.Identities, Identity{Provider: provider, Subject: claims.Subject, Email: claims.Email, LinkedAt: now, Roles: claims.Roles})
+			user.LastLogin = now
+
+			if err := store.CreateUser(user); err != nil {
+				if err == ErrUserAlreadyExists || err == ErrIdentityAlreadyLinked {
+					return nil, http.StatusConflict, err
+				}
+				return nil, http.StatusInternalServerError, err
+			}
+			return user, http.StatusOK, nil
+		}
+
+		if _, linked := user.GetIdentity(provider); linked {
+			// Only one identity per provider, otherwise unlinking becomes ambiguous
+			return nil, http.StatusConflict, ErrIdentityAlreadyLinked
+		}
+		user.Identities = append(user.Identities, Identity{Provider: provider, Subject: claims.Subject, Email: claims.Email, LinkedAt: now})
+	}
+
+	if user.IsBanned || (user.AccountStatus != "" && user.AccountStatus != "active") {
+		return nil, http.StatusForbidden, ErrAccountInactive
+	}
+
+	// The provider is authoritative for the roles it grants, so revoked groups take effect
+	user.SetIdentityRoles(provider, claims.Roles)
+	if claims.EmailVerified && claims.Email == user.Email {
+		user.EmailVerified = true
+	}
+	user.LastLogin = now
+	user.UpdatedAt = now
+	if err := store.UpdateUser(user.Id, user); err != nil {
+		if err == ErrIdentityAlreadyLinked {
+			return nil, http.StatusConflict, err
+		}
+		return nil, http.StatusInternalServerError, err
+	}
+
+	return user, http.StatusOK, nil
+}
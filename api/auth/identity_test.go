@@ -0,0 +1,59 @@
+package auth
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ringo380/lessoncraft/pwd/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAuthHandler_ResolveIdentity(t *testing.T) {
+	store := NewMemoryUserStore()
+	h := NewAuthHandler(store, NewJWTService("secret", "lessoncraft", time.Hour))
+
+	legacy := &types.User{Id: "legacy-id", Provider: "github", ProviderUserId: "42", Name: "Jane"}
+	user, err := h.ResolveIdentity("github", legacy)
+	assert.Nil(t, err)
+	assert.Equal(t, "legacy-id", user.Id)
+
+	account, err := store.GetUserByIdentity("github", "42")
+	assert.Nil(t, err)
+	assert.Equal(t, []Role{RoleLearner}, account.Roles)
+
+	// Later logins resolve the same account, whatever ID the legacy store reports
+	user, err = h.ResolveIdentity("github", &types.User{Id: "other-id", Provider: "github", ProviderUserId: "42"})
+	assert.Nil(t, err)
+	assert.Equal(t, "legacy-id", user.Id)
+
+	// Users without an email do not collide with each other
+	user, err = h.ResolveIdentity("github", &types.User{Id: "second-id", Provider: "github", ProviderUserId: "43"})
+	assert.Nil(t, err)
+	assert.Equal(t, "second-id", user.Id)
+
+	found, err := h.LookupUser("legacy-id")
+	assert.Nil(t, err)
+	assert.Equal(t, "Jane", found.Name)
+
+	account.IsBanned = true
+	_, err = h.ResolveIdentity("github", legacy)
+	assert.ErrorIs(t, err, ErrAccountInactive)
+	_, err = h.LookupUser("legacy-id")
+	assert.ErrorIs(t, err, ErrAccountInactive)
+}
+
+func TestAuthHandler_ResolveIdentityUnverifiedEmail(t *testing.T) {
+	store := NewMemoryUserStore()
+	h := NewAuthHandler(store, NewJWTService("secret", "lessoncraft", time.Hour))
+
+	local := &UserWithAuth{AccountStatus: "active"}
+	local.Id = "local-user"
+	local.Email = "jane@example.com"
+	assert.Nil(t, store.CreateUser(local))
+
+	// Legacy providers cannot vouch for the email, so the account is not taken over
+	_, err := h.ResolveIdentity("github", &types.User{Id: "legacy-id", ProviderUserId: "42", Email: "jane@example.com"})
+	assert.ErrorIs(t, err, ErrLinkRequired)
+	_, err = store.GetUserByIdentity("github", "42")
+	assert.ErrorIs(t, err, ErrUserNotFound)
+}
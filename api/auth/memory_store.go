@@ -2,6 +2,7 @@ package auth
 
 import (
 	"errors"
+	"sort"
 	"sync"
 )
 
@@ -10,21 +11,92 @@ var (
 	ErrUserNotFound = errors.New("user not found")
 	// ErrUserAlreadyExists is returned when a user with the same email already exists
 	ErrUserAlreadyExists = errors.New("user with this email already exists")
+	// ErrIdentityAlreadyLinked is returned when an identity is already linked to another user
+	ErrIdentityAlreadyLinked = errors.New("identity is already linked to another user")
 )
 
 // MemoryUserStore is an in-memory implementation of the UserStore interface
 // It is primarily used for testing purposes
 type MemoryUserStore struct {
-	users  map[string]*UserWithAuth // Map of user ID to user
-	emails map[string]string        // Map of email to user ID
-	mu     sync.RWMutex             // Mutex to protect concurrent access
+	users      map[string]*UserWithAuth // Map of user ID to user
+	emails     map[string]string        // Map of email to user ID
+	identities map[identityKey]string   // Map of provider identity to user ID
+	mu         sync.RWMutex             // Mutex to protect concurrent access
 }
 
 // NewMemoryUserStore creates a new in-memory user store
 func NewMemoryUserStore() *MemoryUserStore {
 	return &MemoryUserStore{
-		users:  make(map[string]*UserWithAuth),
-		emails: make(map[string]string),
+		users:      make(map[string]*UserWithAuth),
+		emails:     make(map[string]string),
+		identities: make(map[identityKey]string),
+	}
+}
+
+// identityKey identifies a linked identity. Subjects are chosen by the provider and
+// may contain any character, so the parts are kept apart rather than joined.
+type identityKey struct {
+	provider string
+	subject  string
+}
+
+// GetUserByIdentity retrieves the user that has linked the given provider identity
+func (s *MemoryUserStore) GetUserByIdentity(provider, subject string) (*UserWithAuth, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	userID, ok := s.identities[identityKey{provider, subject}]
+	if !ok {
+		return nil, ErrUserNotFound
+	}
+
+	user, ok := s.users[userID]
+	if !ok {
+		return nil, ErrUserNotFound
+	}
+
+	return user, nil
+}
+
+// ListUsers retrieves all users ordered by creation time
+func (s *MemoryUserStore) ListUsers() ([]*UserWithAuth, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	users := make([]*UserWithAuth, 0, len(s.users))
+	for _, user := range s.users {
+		users = append(users, user)
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].CreatedAt.Before(users[j].CreatedAt) })
+
+	return users, nil
+}
+
+// checkIdentities verifies that none of the user's identities belong to another user.
+// The caller must hold the write lock.
+func (s *MemoryUserStore) checkIdentities(user *UserWithAuth) error {
+	for _, identity := range user.Identities {
+		if owner, ok := s.identities[identityKey{identity.Provider, identity.Subject}]; ok && owner != user.Id {
+			return ErrIdentityAlreadyLinked
+		}
+	}
+	return nil
+}
+
+// indexIdentities replaces the identity index entries of a user. Stale entries are
+// found by owner rather than from the previous user value, since callers commonly
+// update the same pointer they retrieved from the store.
+// The caller must hold the write lock.
+func (s *MemoryUserStore) indexIdentities(userID string, user *UserWithAuth) {
+	for key, owner := range s.identities {
+		if owner == userID {
+			delete(s.identities, key)
+		}
+	}
+	if user != nil {
+		for _, identity := range user.Identities {
+			s.identities[identityKey{identity.Provider, identity.Subject}] = userID
+		}
 	}
 }
 
@@ -64,14 +136,21 @@ func (s *MemoryUserStore) CreateUser(user *UserWithAuth) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	// Check if user with this email already exists
-	if _, ok := s.emails[user.Email]; ok {
+	// Check if user with this email already exists. Accounts created through a
+	// provider may have no email.
+	if _, ok := s.emails[user.Email]; ok && user.Email != "" {
 		return ErrUserAlreadyExists
 	}
+	if err := s.checkIdentities(user); err != nil {
+		return err
+	}
 
 	// Store user
 	s.users[user.Id] = user
-	s.emails[user.Email] = user.Id
+	if user.Email != "" {
+		s.emails[user.Email] = user.Id
+	}
+	s.indexIdentities(user.Id, user)
 
 	return nil
 }
@@ -87,19 +166,26 @@ func (s *MemoryUserStore) UpdateUser(id string, user *UserWithAuth) error {
 		return ErrUserNotFound
 	}
 
+	if err := s.checkIdentities(user); err != nil {
+		return err
+	}
+
 	// Check if email is being changed
 	if existingUser.Email != user.Email {
 		// Check if new email is already in use
-		if _, ok := s.emails[user.Email]; ok {
+		if _, ok := s.emails[user.Email]; ok && user.Email != "" {
 			return ErrUserAlreadyExists
 		}
 
 		// Update email mapping
 		delete(s.emails, existingUser.Email)
-		s.emails[user.Email] = id
+		if user.Email != "" {
+			s.emails[user.Email] = id
+		}
 	}
 
 	// Update user
+	s.indexIdentities(id, user)
 	s.users[id] = user
 
 	return nil
@@ -119,6 +205,7 @@ func (s *MemoryUserStore) DeleteUser(id string) error {
 	// Delete user
 	delete(s.users, id)
 	delete(s.emails, user.Email)
+	s.indexIdentities(id, nil)
 
 	return nil
 }
@@ -18,12 +18,28 @@ const (
 	UserContextKey contextKey = "user"
 	// RolesContextKey is the key for storing user roles in the request context
 	RolesContextKey contextKey = "roles"
+	// ScopesContextKey is the key for storing API key scopes in the request context
+	ScopesContextKey contextKey = "scopes"
+	// APIKeyContextKey is the key for storing the ID of the API key used for the request
+	APIKeyContextKey contextKey = "api_key"
 )
 
-// AuthMiddleware creates a middleware that validates JWT tokens and extracts user information
-func AuthMiddleware(jwtService *JWTService) func(http.Handler) http.Handler {
+// TokenValidator validates bearer tokens. JWTService validates user JWTs and
+// APIKeyService additionally accepts API keys.
+type TokenValidator interface {
+	ValidateToken(tokenString string) (*TokenClaims, error)
+}
+
+// AuthMiddleware creates a middleware that validates bearer tokens and extracts user information.
+// Requests already authenticated by IdentifyMiddleware are not validated again.
+func AuthMiddleware(validator TokenValidator) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if _, ok := GetUserID(r); ok {
+				next.ServeHTTP(w, r)
+				return
+			}
+
 			// Extract token from Authorization header
 			authHeader := r.Header.Get("Authorization")
 			if authHeader == "" {
@@ -56,7 +72,7 @@ func AuthMiddleware(jwtService *JWTService) func(http.Handler) http.Handler {
 			tokenString := parts[1]
 
 			// Validate the token
-			claims, err := jwtService.ValidateToken(tokenString)
+			claims, err := validator.ValidateToken(tokenString)
 			if err != nil {
 				var status int
 				var message string
@@ -88,12 +104,52 @@ func AuthMiddleware(jwtService *JWTService) func(http.Handler) http.Handler {
 				return
 			}
 
-			// Add user information to the request context
-			ctx := context.WithValue(r.Context(), UserContextKey, claims.UserID)
-			ctx = context.WithValue(ctx, RolesContextKey, claims.Roles)
+			// Call the next handler with the user information in the context
+			next.ServeHTTP(w, withClaims(r, claims))
+		})
+	}
+}
+
+// IdentifyMiddleware creates a middleware that adds the user of a valid bearer
+// token to the request context, and lets every request through. It lets
+// middleware wrapping a whole router, e.g. a rate limiter, know the user, while
+// the routes keep deciding whether they require one.
+func IdentifyMiddleware(validator TokenValidator) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			parts := strings.Split(r.Header.Get("Authorization"), " ")
+			if len(parts) == 2 && parts[0] == "Bearer" {
+				if claims, err := validator.ValidateToken(parts[1]); err == nil {
+					r = withClaims(r, claims)
+				}
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// withClaims adds the user information of token claims to the request context
+func withClaims(r *http.Request, claims *TokenClaims) *http.Request {
+	ctx := context.WithValue(r.Context(), UserContextKey, claims.UserID)
+	ctx = context.WithValue(ctx, RolesContextKey, claims.Roles)
+	if claims.APIKeyID != "" {
+		ctx = context.WithValue(ctx, APIKeyContextKey, claims.APIKeyID)
+		ctx = context.WithValue(ctx, ScopesContextKey, claims.Scopes)
+	}
+	return r.WithContext(ctx)
+}
 
-			// Call the next handler with the updated context
-			next.ServeHTTP(w, r.WithContext(ctx))
+// OptionalAuthMiddleware creates a middleware that authenticates requests with a
+// bearer token like AuthMiddleware, and lets requests without one through anonymously
+func OptionalAuthMiddleware(validator TokenValidator) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		authenticated := AuthMiddleware(validator)(next)
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("Authorization") == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+			authenticated.ServeHTTP(w, r)
 		})
 	}
 }
@@ -157,6 +213,51 @@ func RoleMiddleware(requiredRole Role) func(http.Handler) http.Handler {
 	}
 }
 
+// ScopeMiddleware creates a middleware that checks if an API key has the required scope.
+// Requests authenticated with a user token are not restricted by scopes.
+func ScopeMiddleware(requiredScope Scope) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !HasScope(r, requiredScope) {
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusForbidden)
+				json.NewEncoder(w).Encode(middleware.ErrorResponse{
+					Error:     "Forbidden",
+					Code:      http.StatusForbidden,
+					Message:   "API key is missing the " + string(requiredScope) + " scope",
+					TimeStamp: time.Now(),
+				})
+				return
+			}
+
+			// Call the next handler
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// GetAPIKeyID extracts the ID of the API key used for the request, if any
+func GetAPIKeyID(r *http.Request) (string, bool) {
+	keyID, ok := r.Context().Value(APIKeyContextKey).(string)
+	return keyID, ok
+}
+
+// HasScope checks if the request may act within the given scope. Only API keys are
+// restricted by scopes; user tokens are limited by their roles alone.
+func HasScope(r *http.Request, scope Scope) bool {
+	if _, ok := GetAPIKeyID(r); !ok {
+		return true
+	}
+
+	scopes, _ := r.Context().Value(ScopesContextKey).([]Scope)
+	for _, s := range scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
 // GetUserID extracts the user ID from the request context
 func GetUserID(r *http.Request) (string, bool) {
 	userID, ok := r.Context().Value(UserContextKey).(string)
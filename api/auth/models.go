@@ -18,6 +18,9 @@ const (
 	RoleLearner Role = "learner"
 )
 
+// LocalProvider is the identity provider name used for email and password login
+const LocalProvider = "local"
+
 // UserWithAuth extends the base User type with authentication and authorization fields
 type UserWithAuth struct {
 	// Embed the base User type
@@ -36,6 +39,29 @@ type UserWithAuth struct {
 	CreatedAt time.Time `json:"created_at" bson:"created_at"`
 	// UpdatedAt records when the user account was last updated
 	UpdatedAt time.Time `json:"updated_at" bson:"updated_at"`
+	// Identities lists the external and local login methods linked to this account
+	Identities []Identity `json:"identities" bson:"identities"`
+	// ServiceAccount marks accounts used by automation. They cannot log in and
+	// authenticate with API keys only.
+	ServiceAccount bool `json:"service_account,omitempty" bson:"service_account,omitempty"`
+	// SSHKeys lists the public keys the user logs in to instances with over SSH
+	SSHKeys []SSHKey `json:"ssh_keys,omitempty" bson:"ssh_keys,omitempty"`
+}
+
+// Identity links a user account to a login method. Provider is the name of the
+// configured identity provider (or "local" for password login) and Subject is the
+// provider's stable identifier for the user.
+type Identity struct {
+	// Provider is the name of the identity provider
+	Provider string `json:"provider" bson:"provider"`
+	// Subject is the provider's unique identifier for the user ("sub" claim)
+	Subject string `json:"subject" bson:"subject"`
+	// Email is the email address reported by the provider when the identity was linked
+	Email string `json:"email,omitempty" bson:"email,omitempty"`
+	// LinkedAt records when the identity was linked to the account
+	LinkedAt time.Time `json:"linked_at" bson:"linked_at"`
+	// Roles are the roles the provider granted at the last login
+	Roles []Role `json:"roles,omitempty" bson:"roles,omitempty"`
 }
 
 // TokenClaims represents the claims in a JWT token
@@ -43,6 +69,10 @@ type TokenClaims struct {
 	UserID string `json:"user_id"`
 	Email  string `json:"email"`
 	Roles  []Role `json:"roles"`
+	// APIKeyID is set when the request was authenticated with an API key
+	APIKeyID string `json:"api_key_id,omitempty"`
+	// Scopes limits what an API key may do. It is empty for user tokens.
+	Scopes []Scope `json:"scopes,omitempty"`
 	// Standard JWT claims
 	ExpiresAt int64  `json:"exp"`
 	IssuedAt  int64  `json:"iat"`
@@ -100,3 +130,74 @@ func (u *UserWithAuth) IsEducator() bool {
 func (u *UserWithAuth) IsLearner() bool {
 	return u.HasRole(RoleLearner)
 }
+
+// HasPassword checks if the user can log in with a local password
+func (u *UserWithAuth) HasPassword() bool {
+	return u.PasswordHash != ""
+}
+
+// GetIdentity returns the identity linked for the given provider, if any
+func (u *UserWithAuth) GetIdentity(provider string) (*Identity, bool) {
+	for i := range u.Identities {
+		if u.Identities[i].Provider == provider {
+			return &u.Identities[i], true
+		}
+	}
+	return nil, false
+}
+
+// AddRoles adds the given roles to the user, skipping roles the user already has
+func (u *UserWithAuth) AddRoles(roles ...Role) {
+	for _, role := range roles {
+		if !u.HasRole(role) {
+			u.Roles = append(u.Roles, role)
+		}
+	}
+}
+
+// SetIdentityRoles replaces the roles granted through the linked identity of the
+// given provider. Roles the provider no longer grants are removed unless another
+// provider grants them; roles the user holds locally are never removed.
+func (u *UserWithAuth) SetIdentityRoles(provider string, roles []Role) {
+	identity, ok := u.GetIdentity(provider)
+	if !ok {
+		return
+	}
+
+	others := map[Role]bool{}
+	for _, other := range u.Identities {
+		if other.Provider != provider {
+			for _, role := range other.Roles {
+				others[role] = true
+			}
+		}
+	}
+
+	// A role counts as granted by the provider unless the user already held it locally
+	granted := make([]Role, 0, len(roles))
+	for _, role := range roles {
+		if !u.HasRole(role) || containsRole(identity.Roles, role) || others[role] {
+			granted = append(granted, role)
+		}
+	}
+
+	kept := make([]Role, 0, len(u.Roles))
+	for _, role := range u.Roles {
+		if containsRole(identity.Roles, role) && !containsRole(roles, role) && !others[role] {
+			continue
+		}
+		kept = append(kept, role)
+	}
+	u.Roles = kept
+	u.AddRoles(roles...)
+	identity.Roles = granted
+}
+
+func containsRole(roles []Role, role Role) bool {
+	for _, r := range roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,351 @@
+package auth
+
+import (
+	"context"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"golang.org/x/oauth2"
+)
+
+var (
+	// ErrOIDCDiscovery is returned when the provider's discovery document cannot be used
+	ErrOIDCDiscovery = errors.New("invalid OIDC discovery document")
+	// ErrMissingIDToken is returned when the token response does not include an ID token
+	ErrMissingIDToken = errors.New("token response does not contain an id_token")
+	// ErrInvalidNonce is returned when the ID token nonce does not match the login request
+	ErrInvalidNonce = errors.New("id_token nonce does not match")
+)
+
+// jwksRefreshInterval is the minimum time between fetches of a provider's signing keys
+const jwksRefreshInterval = time.Minute
+
+// OIDCConfig configures a generic OpenID Connect provider
+type OIDCConfig struct {
+	// Name identifies the provider in URLs and linked identities (e.g. "okta")
+	Name string `json:"name"`
+	// IssuerURL is the issuer identifier. The discovery document is read from
+	// IssuerURL + "/.well-known/openid-configuration"
+	IssuerURL string `json:"issuer_url"`
+	// ClientID is the OAuth2 client ID registered with the provider
+	ClientID string `json:"client_id"`
+	// ClientSecret is the OAuth2 client secret registered with the provider
+	ClientSecret string `json:"client_secret"`
+	// RedirectURL is the callback URL registered with the provider
+	RedirectURL string `json:"redirect_url"`
+	// Scopes are requested in addition to "openid"
+	Scopes []string `json:"scopes"`
+	// RoleClaim is the ID token claim holding the user's groups (default "groups")
+	RoleClaim string `json:"role_claim"`
+	// RoleMapping maps claim values (groups) to roles
+	RoleMapping map[string]Role `json:"role_mapping"`
+	// DefaultRoles are assigned to new users when no claim value is mapped (default learner)
+	DefaultRoles []Role `json:"default_roles"`
+}
+
+// LoadOIDCConfigs reads a JSON array of provider configurations from a file
+func LoadOIDCConfigs(path string) ([]OIDCConfig, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var configs []OIDCConfig
+	if err := json.NewDecoder(f).Decode(&configs); err != nil {
+		return nil, fmt.Errorf("could not decode OIDC configuration %s: %w", path, err)
+	}
+	return configs, nil
+}
+
+// OIDCClaims holds the identity information extracted from a verified ID token
+type OIDCClaims struct {
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Name          string
+	Picture       string
+	// Roles are the roles mapped from the configured role claim
+	Roles []Role
+}
+
+type oidcDiscovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	UserinfoEndpoint      string `json:"userinfo_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+type jsonWebKey struct {
+	Kid string `json:"kid"`
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+// OIDCProvider authenticates users against an OpenID Connect provider using the
+// authorization code flow and verifies the returned ID tokens against the
+// provider's published signing keys.
+type OIDCProvider struct {
+	config     OIDCConfig
+	discovery  oidcDiscovery
+	oauth2     *oauth2.Config
+	httpClient *http.Client
+
+	mu          sync.RWMutex
+	keys        map[string]*rsa.PublicKey
+	refreshedAt time.Time
+}
+
+// NewOIDCProvider fetches the provider's discovery document and creates a provider
+func NewOIDCProvider(ctx context.Context, config OIDCConfig, httpClient *http.Client) (*OIDCProvider, error) {
+	if config.Name == "" || config.IssuerURL == "" || config.ClientID == "" {
+		return nil, fmt.Errorf("OIDC provider requires a name, an issuer URL and a client ID")
+	}
+	if config.Name == LocalProvider {
+		return nil, fmt.Errorf("OIDC provider name %q is reserved", LocalProvider)
+	}
+	if httpClient == nil {
+		httpClient = &http.Client{Timeout: 10 * time.Second}
+	}
+	if config.RoleClaim == "" {
+		config.RoleClaim = "groups"
+	}
+	if len(config.DefaultRoles) == 0 {
+		config.DefaultRoles = []Role{RoleLearner}
+	}
+
+	p := &OIDCProvider{config: config, httpClient: httpClient, keys: map[string]*rsa.PublicKey{}}
+
+	wellKnown := strings.TrimSuffix(config.IssuerURL, "/") + "/.well-known/openid-configuration"
+	if err := p.getJSON(ctx, wellKnown, &p.discovery); err != nil {
+		return nil, fmt.Errorf("could not fetch OIDC discovery document for %s: %w", config.Name, err)
+	}
+	if p.discovery.Issuer != strings.TrimSuffix(config.IssuerURL, "/") && p.discovery.Issuer != config.IssuerURL {
+		return nil, fmt.Errorf("%w: issuer %q does not match %q", ErrOIDCDiscovery, p.discovery.Issuer, config.IssuerURL)
+	}
+	if p.discovery.AuthorizationEndpoint == "" || p.discovery.TokenEndpoint == "" || p.discovery.JWKSURI == "" {
+		return nil, fmt.Errorf("%w: missing endpoints for %s", ErrOIDCDiscovery, config.Name)
+	}
+
+	p.oauth2 = &oauth2.Config{
+		ClientID:     config.ClientID,
+		ClientSecret: config.ClientSecret,
+		RedirectURL:  config.RedirectURL,
+		Scopes:       append([]string{"openid", "email", "profile"}, config.Scopes...),
+		Endpoint: oauth2.Endpoint{
+			AuthURL:  p.discovery.AuthorizationEndpoint,
+			TokenURL: p.discovery.TokenEndpoint,
+		},
+	}
+
+	return p, nil
+}
+
+// Name returns the configured provider name
+func (p *OIDCProvider) Name() string {
+	return p.config.Name
+}
+
+// AuthCodeURL returns the URL of the provider's consent page
+func (p *OIDCProvider) AuthCodeURL(state, nonce string) string {
+	return p.oauth2.AuthCodeURL(state, oauth2.SetAuthURLParam("nonce", nonce))
+}
+
+// Exchange trades an authorization code for tokens, verifies the ID token and
+// returns its claims
+func (p *OIDCProvider) Exchange(ctx context.Context, code, nonce string) (*OIDCClaims, error) {
+	ctx = context.WithValue(ctx, oauth2.HTTPClient, p.httpClient)
+	tok, err := p.oauth2.Exchange(ctx, code)
+	if err != nil {
+		return nil, err
+	}
+
+	rawIDToken, ok := tok.Extra("id_token").(string)
+	if !ok || rawIDToken == "" {
+		return nil, ErrMissingIDToken
+	}
+
+	return p.VerifyIDToken(ctx, rawIDToken, nonce)
+}
+
+// VerifyIDToken validates the signature, issuer, audience, expiry and nonce of an
+// ID token and maps its claims
+func (p *OIDCProvider) VerifyIDToken(ctx context.Context, rawIDToken, nonce string) (*OIDCClaims, error) {
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(rawIDToken, claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		return p.signingKey(ctx, kid)
+	},
+		jwt.WithValidMethods([]string{"RS256", "RS384", "RS512"}),
+		jwt.WithIssuer(p.discovery.Issuer),
+		jwt.WithAudience(p.config.ClientID),
+		jwt.WithExpirationRequired(),
+	)
+	if err != nil {
+		if errors.Is(err, jwt.ErrTokenExpired) {
+			return nil, ErrExpiredToken
+		}
+		return nil, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+
+	if nonce != "" {
+		if n, _ := claims["nonce"].(string); n != nonce {
+			return nil, ErrInvalidNonce
+		}
+	}
+
+	subject, _ := claims["sub"].(string)
+	if subject == "" {
+		return nil, ErrInvalidClaims
+	}
+
+	result := &OIDCClaims{Subject: subject}
+	result.Email, _ = claims["email"].(string)
+	result.Name, _ = claims["name"].(string)
+	result.Picture, _ = claims["picture"].(string)
+	switch v := claims["email_verified"].(type) {
+	case bool:
+		result.EmailVerified = v
+	case string:
+		result.EmailVerified = v == "true"
+	}
+	result.Roles = p.mapRoles(claims[p.config.RoleClaim])
+
+	return result, nil
+}
+
+// mapRoles converts the role claim (a string or a list of strings) into roles using
+// the configured mapping
+func (p *OIDCProvider) mapRoles(claim interface{}) []Role {
+	var values []string
+	switch v := claim.(type) {
+	case string:
+		values = strings.Fields(v)
+	case []interface{}:
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				values = append(values, s)
+			}
+		}
+	}
+
+	roles := []Role{}
+	seen := map[Role]bool{}
+	for _, value := range values {
+		if role, ok := p.config.RoleMapping[value]; ok && !seen[role] {
+			roles = append(roles, role)
+			seen[role] = true
+		}
+	}
+	if len(roles) == 0 {
+		return append(roles, p.config.DefaultRoles...)
+	}
+	return roles
+}
+
+// signingKey returns the key with the given ID, refreshing the key set once if the
+// key is unknown (providers rotate keys without notice). Refreshes are limited to one
+// per jwksRefreshInterval so tokens with made up key IDs cannot flood the provider.
+func (p *OIDCProvider) signingKey(ctx context.Context, kid string) (*rsa.PublicKey, error) {
+	if key := p.lookupKey(kid); key != nil {
+		return key, nil
+	}
+	if !p.claimRefresh() {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+	if err := p.refreshKeys(ctx); err != nil {
+		return nil, err
+	}
+	if key := p.lookupKey(kid); key != nil {
+		return key, nil
+	}
+	return nil, fmt.Errorf("unknown signing key %q", kid)
+}
+
+func (p *OIDCProvider) lookupKey(kid string) *rsa.PublicKey {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	if kid == "" && len(p.keys) == 1 {
+		for _, key := range p.keys {
+			return key
+		}
+	}
+	return p.keys[kid]
+}
+
+// claimRefresh reports whether the key set may be refreshed now and, if so, records
+// the refresh
+func (p *OIDCProvider) claimRefresh() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(p.refreshedAt) < jwksRefreshInterval {
+		return false
+	}
+	p.refreshedAt = now
+	return true
+}
+
+func (p *OIDCProvider) refreshKeys(ctx context.Context) error {
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := p.getJSON(ctx, p.discovery.JWKSURI, &set); err != nil {
+		return fmt.Errorf("could not fetch signing keys: %w", err)
+	}
+
+	keys := map[string]*rsa.PublicKey{}
+	for _, k := range set.Keys {
+		if k.Kty != "RSA" || (k.Use != "" && k.Use != "sig") {
+			continue
+		}
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			continue
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			continue
+		}
+		keys[k.Kid] = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+	}
+
+	p.mu.Lock()
+	p.keys = keys
+	p.mu.Unlock()
+	return nil
+}
+
+func (p *OIDCProvider) getJSON(ctx context.Context, url string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := p.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s returned %d", url, resp.StatusCode)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
@@ -0,0 +1,382 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/ringo380/lessoncraft/api/middleware"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// oidcStateTTL is how long a user has to complete a login at the provider
+const oidcStateTTL = 10 * time.Minute
+
+// oidcStateCookie binds a pending login to the browser that started it, so a
+// callback URL cannot be completed in somebody else's browser
+const oidcStateCookie = "oidc_state"
+
+// oidcLoginState is remembered between redirecting to the provider and its callback
+type oidcLoginState struct {
+	provider  string
+	nonce     string
+	browser   string // value of the oidcStateCookie set when the flow started
+	linkUser  string // ID of the user linking an identity, empty for a login
+	expiresAt time.Time
+}
+
+// OIDCHandler handles login and account linking with OpenID Connect providers.
+// Successful logins are answered with the same LoginResponse as password logins.
+type OIDCHandler struct {
+	auth      *AuthHandler
+	providers map[string]*OIDCProvider
+
+	mu     sync.Mutex
+	states map[string]oidcLoginState
+}
+
+// NewOIDCHandler creates a new OIDCHandler for the given providers
+func NewOIDCHandler(auth *AuthHandler, providers ...*OIDCProvider) *OIDCHandler {
+	h := &OIDCHandler{
+		auth:      auth,
+		providers: make(map[string]*OIDCProvider),
+		states:    make(map[string]oidcLoginState),
+	}
+	for _, p := range providers {
+		h.providers[p.Name()] = p
+	}
+	return h
+}
+
+// RegisterRoutes registers the OIDC and identity management routes with the provided router
+func (h *OIDCHandler) RegisterRoutes(r *mux.Router) {
+	r.HandleFunc("/api/auth/oidc/providers", h.ListProviders).Methods("GET")
+	r.HandleFunc("/api/auth/oidc/{provider}/login", h.Login).Methods("GET")
+	r.HandleFunc("/api/auth/oidc/{provider}/callback", h.Callback).Methods("GET")
+
+	// Protected routes that require authentication. API keys need the account
+	// scopes, so a leaked key cannot take over the sign-in of its account.
+	authMiddleware := AuthMiddleware(h.auth.tokens)
+	readScope := ScopeMiddleware(ScopeAccountRead)
+	writeScope := ScopeMiddleware(ScopeAccountWrite)
+
+	r.Handle("/api/auth/oidc/{provider}/link", authMiddleware(writeScope(http.HandlerFunc(h.Link)))).Methods("POST")
+	r.Handle("/api/auth/identities", authMiddleware(readScope(http.HandlerFunc(h.ListIdentities)))).Methods("GET")
+	r.Handle("/api/auth/identities/{provider}", authMiddleware(writeScope(http.HandlerFunc(h.Unlink)))).Methods("DELETE")
+	r.Handle("/api/auth/password", authMiddleware(writeScope(http.HandlerFunc(h.SetPassword)))).Methods("POST")
+}
+
+// ListProviders returns the names of the configured providers
+func (h *OIDCHandler) ListProviders(w http.ResponseWriter, r *http.Request) {
+	names := make([]string, 0, len(h.providers))
+	for name := range h.providers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"providers": names})
+}
+
+// Login redirects the user to the provider's consent page
+func (h *OIDCHandler) Login(w http.ResponseWriter, r *http.Request) {
+	provider, ok := h.provider(w, r)
+	if !ok {
+		return
+	}
+
+	url, browser, err := h.authCodeURL(provider, "")
+	if err != nil {
+		middleware.WriteError(w, "InternalError", http.StatusInternalServerError, "Failed to start login", err)
+		return
+	}
+
+	setStateCookie(w, r, browser)
+	http.Redirect(w, r, url, http.StatusFound)
+}
+
+// Link starts linking a provider identity to the authenticated user. The returned URL
+// must be opened by the user's browser; the provider redirects back to the callback.
+func (h *OIDCHandler) Link(w http.ResponseWriter, r *http.Request) {
+	provider, ok := h.provider(w, r)
+	if !ok {
+		return
+	}
+
+	userID, ok := GetUserID(r)
+	if !ok {
+		middleware.WriteError(w, "Unauthorized", http.StatusUnauthorized, "User not authenticated", nil)
+		return
+	}
+
+	url, browser, err := h.authCodeURL(provider, userID)
+	if err != nil {
+		middleware.WriteError(w, "InternalError", http.StatusInternalServerError, "Failed to start account linking", err)
+		return
+	}
+
+	setStateCookie(w, r, browser)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"url": url})
+}
+
+// Callback completes a login or link started by Login or Link
+func (h *OIDCHandler) Callback(w http.ResponseWriter, r *http.Request) {
+	provider, ok := h.provider(w, r)
+	if !ok {
+		return
+	}
+
+	query := r.URL.Query()
+	if e := query.Get("error"); e != "" {
+		middleware.WriteError(w, "ProviderError", http.StatusUnauthorized, "Login was rejected by the identity provider", errors.New(e))
+		return
+	}
+
+	state, ok := h.takeState(query.Get("state"))
+	setStateCookie(w, r, "")
+	cookie, err := r.Cookie(oidcStateCookie)
+	if !ok || state.provider != provider.Name() || err != nil ||
+		subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(state.browser)) != 1 {
+		middleware.WriteError(w, "InvalidState", http.StatusBadRequest, "Login state is invalid or has expired", nil)
+		return
+	}
+
+	claims, err := provider.Exchange(r.Context(), query.Get("code"), state.nonce)
+	if err != nil {
+		middleware.WriteError(w, "InvalidCredentials", http.StatusUnauthorized, "Failed to verify identity", err)
+		return
+	}
+
+	user, status, err := h.auth.resolveIdentity(provider.Name(), claims, state.linkUser, "")
+	if err != nil {
+		switch {
+		case err == ErrLinkRequired:
+			middleware.WriteError(w, "LinkRequired", status, "An account with this email exists; log in to it and link the identity", err)
+		case status == http.StatusConflict:
+			middleware.WriteError(w, "IdentityConflict", status, "Identity is already linked to another account", err)
+		case status == http.StatusForbidden:
+			middleware.WriteError(w, "Forbidden", status, "Account is not active", err)
+		default:
+			middleware.WriteError(w, "DatabaseError", status, "Failed to store user", err)
+		}
+		return
+	}
+
+	h.auth.audit(r, "user.login", user.Id, nil, nil)
+	h.auth.writeLoginResponse(w, http.StatusOK, user)
+}
+
+// ListIdentities returns the login methods linked to the authenticated user
+func (h *OIDCHandler) ListIdentities(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.currentUser(w, r)
+	if !ok {
+		return
+	}
+
+	identities := user.Identities
+	if identities == nil {
+		identities = []Identity{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(identities)
+}
+
+// Unlink removes a linked identity from the authenticated user. The last remaining
+// login method cannot be removed.
+func (h *OIDCHandler) Unlink(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.currentUser(w, r)
+	if !ok {
+		return
+	}
+
+	provider := mux.Vars(r)["provider"]
+	if _, linked := user.GetIdentity(provider); !linked {
+		middleware.WriteError(w, "NotFound", http.StatusNotFound, "Identity is not linked", nil)
+		return
+	}
+	if len(user.Identities) < 2 {
+		middleware.WriteError(w, "ValidationError", http.StatusBadRequest, "Cannot remove the last login method", nil)
+		return
+	}
+
+	before := *user
+	identities := make([]Identity, 0, len(user.Identities)-1)
+	for _, identity := range user.Identities {
+		if identity.Provider != provider {
+			identities = append(identities, identity)
+		}
+	}
+	user.Identities = identities
+	if provider == LocalProvider {
+		user.PasswordHash = ""
+	}
+	user.UpdatedAt = time.Now()
+
+	if err := h.auth.userStore.UpdateUser(user.Id, user); err != nil {
+		middleware.WriteError(w, "DatabaseError", http.StatusInternalServerError, "Failed to update user", err)
+		return
+	}
+
+	h.auth.audit(r, "user.identity.unlink", user.Id, before, user)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// SetPasswordRequest represents a request to set or change the local password
+type SetPasswordRequest struct {
+	// CurrentPassword is required when the user already has a password
+	CurrentPassword string `json:"current_password"`
+	// NewPassword is the password to set
+	NewPassword string `json:"new_password"`
+}
+
+// SetPassword sets or changes the local password of the authenticated user, which
+// also links the local identity for accounts created through a provider
+func (h *OIDCHandler) SetPassword(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.currentUser(w, r)
+	if !ok {
+		return
+	}
+
+	var req SetPasswordRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		middleware.WriteError(w, "InvalidRequest", http.StatusBadRequest, "Invalid request format", err)
+		return
+	}
+	if len(req.NewPassword) < 8 {
+		middleware.WriteError(w, "ValidationError", http.StatusBadRequest, "Password must be at least 8 characters", nil)
+		return
+	}
+	if user.Email == "" {
+		middleware.WriteError(w, "ValidationError", http.StatusBadRequest, "An email address is required for password login", nil)
+		return
+	}
+	if user.HasPassword() {
+		if err := bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(req.CurrentPassword)); err != nil {
+			middleware.WriteError(w, "InvalidCredentials", http.StatusUnauthorized, "Current password is incorrect", nil)
+			return
+		}
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(req.NewPassword), bcrypt.DefaultCost)
+	if err != nil {
+		middleware.WriteError(w, "InternalError", http.StatusInternalServerError, "Failed to process password", err)
+		return
+	}
+
+	now := time.Now()
+	before := *user
+	user.PasswordHash = string(hash)
+	if _, linked := user.GetIdentity(LocalProvider); !linked {
+		user.Identities = append(user.Identities, Identity{Provider: LocalProvider, Subject: user.Email, Email: user.Email, LinkedAt: now})
+	}
+	user.UpdatedAt = now
+
+	if err := h.auth.userStore.UpdateUser(user.Id, user); err != nil {
+		middleware.WriteError(w, "DatabaseError", http.StatusInternalServerError, "Failed to update user", err)
+		return
+	}
+
+	h.auth.audit(r, "user.password.set", user.Id, before, user)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *OIDCHandler) provider(w http.ResponseWriter, r *http.Request) (*OIDCProvider, bool) {
+	provider, ok := h.providers[mux.Vars(r)["provider"]]
+	if !ok {
+		middleware.WriteError(w, "NotFound", http.StatusNotFound, "Unknown identity provider", nil)
+	}
+	return provider, ok
+}
+
+func (h *OIDCHandler) currentUser(w http.ResponseWriter, r *http.Request) (*UserWithAuth, bool) {
+	userID, ok := GetUserID(r)
+	if !ok {
+		middleware.WriteError(w, "Unauthorized", http.StatusUnauthorized, "User not authenticated", nil)
+		return nil, false
+	}
+
+	user, err := h.auth.userStore.GetUserByID(userID)
+	if err != nil {
+		middleware.WriteError(w, "DatabaseError", http.StatusInternalServerError, "Failed to retrieve user", err)
+		return nil, false
+	}
+	return user, true
+}
+
+// authCodeURL starts a login and returns the provider URL together with the value
+// of the cookie that must accompany the callback
+func (h *OIDCHandler) authCodeURL(provider *OIDCProvider, linkUserID string) (string, string, error) {
+	state, err := randomString()
+	if err != nil {
+		return "", "", err
+	}
+	nonce, err := randomString()
+	if err != nil {
+		return "", "", err
+	}
+	browser, err := randomString()
+	if err != nil {
+		return "", "", err
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	now := time.Now()
+	for key, s := range h.states {
+		if now.After(s.expiresAt) {
+			delete(h.states, key)
+		}
+	}
+	h.states[state] = oidcLoginState{provider: provider.Name(), nonce: nonce, browser: browser, linkUser: linkUserID, expiresAt: now.Add(oidcStateTTL)}
+
+	return provider.AuthCodeURL(state, nonce), browser, nil
+}
+
+// setStateCookie sets the oidcStateCookie, or clears it when value is empty
+func setStateCookie(w http.ResponseWriter, r *http.Request, value string) {
+	maxAge := int(oidcStateTTL / time.Second)
+	if value == "" {
+		maxAge = -1
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcStateCookie,
+		Value:    value,
+		Path:     "/api/auth/oidc",
+		MaxAge:   maxAge,
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		// Lax so the cookie is sent on the top-level redirect back from the provider
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// takeState returns and forgets a pending login state; states are single use
+func (h *OIDCHandler) takeState(key string) (oidcLoginState, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	state, ok := h.states[key]
+	delete(h.states, key)
+	if !ok || time.Now().After(state.expiresAt) {
+		return oidcLoginState{}, false
+	}
+	return state, true
+}
+
+func randomString() (string, error) {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
@@ -0,0 +1,395 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/gorilla/mux"
+	"github.com/ringo380/lessoncraft/api/apitest"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// stubIssuer is a minimal OIDC provider that issues ID tokens for a configurable user
+type stubIssuer struct {
+	server *httptest.Server
+	key    *rsa.PrivateKey
+	claims jwt.MapClaims
+	nonces map[string]string // code -> nonce
+	jwks   int               // number of signing key requests
+}
+
+func newStubIssuer(t *testing.T) *stubIssuer {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.Nil(t, err)
+
+	s := &stubIssuer{key: key, nonces: map[string]string{}}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 s.server.URL,
+			"authorization_endpoint": s.server.URL + "/authorize",
+			"token_endpoint":         s.server.URL + "/token",
+			"jwks_uri":               s.server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		s.jwks++
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []map[string]string{{
+				"kid": "test",
+				"kty": "RSA",
+				"use": "sig",
+				"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			}},
+		})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		claims := jwt.MapClaims{
+			"iss":   s.server.URL,
+			"aud":   "client",
+			"exp":   time.Now().Add(time.Hour).Unix(),
+			"iat":   time.Now().Unix(),
+			"nonce": s.nonces[r.Form.Get("code")],
+		}
+		for k, v := range s.claims {
+			claims[k] = v
+		}
+		token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+		token.Header["kid"] = "test"
+		idToken, err := token.SignedString(key)
+		assert.Nil(t, err)
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"access_token": "access",
+			"token_type":   "Bearer",
+			"expires_in":   3600,
+			"id_token":     idToken,
+		})
+	})
+	s.server = httptest.NewServer(mux)
+	t.Cleanup(s.server.Close)
+	return s
+}
+
+type oidcTestEnv struct {
+	issuer  *stubIssuer
+	store   *MemoryUserStore
+	jwt     *JWTService
+	router  *mux.Router
+	handler *OIDCHandler
+	browser []*http.Cookie // cookies set when the last flow was started
+}
+
+func newOIDCTestEnv(t *testing.T) *oidcTestEnv {
+	issuer := newStubIssuer(t)
+	provider, err := NewOIDCProvider(context.Background(), OIDCConfig{
+		Name:        "stub",
+		IssuerURL:   issuer.server.URL,
+		ClientID:    "client",
+		RedirectURL: "http://localhost/api/auth/oidc/stub/callback",
+		RoleMapping: map[string]Role{"teachers": RoleEducator, "ops": RoleAdmin},
+	}, issuer.server.Client())
+	assert.Nil(t, err)
+
+	store := NewMemoryUserStore()
+	jwtService := NewJWTService("secret", "lessoncraft", time.Hour)
+	handler := NewOIDCHandler(NewAuthHandler(store, jwtService), provider)
+	router := mux.NewRouter()
+	handler.RegisterRoutes(router)
+
+	return &oidcTestEnv{issuer: issuer, store: store, jwt: jwtService, router: router, handler: handler}
+}
+
+// login runs the authorization code flow against the stub issuer and returns the callback response
+func (e *oidcTestEnv) login(t *testing.T, startURL *url.URL) *httptest.ResponseRecorder {
+	state := startURL.Query().Get("state")
+	e.issuer.nonces["code"] = startURL.Query().Get("nonce")
+
+	req := httptest.NewRequest("GET", "/api/auth/oidc/stub/callback?code=code&state="+url.QueryEscape(state), nil)
+	for _, c := range e.browser {
+		req.AddCookie(c)
+	}
+	rr := httptest.NewRecorder()
+	e.router.ServeHTTP(rr, req)
+	return rr
+}
+
+func (e *oidcTestEnv) startLogin(t *testing.T) *url.URL {
+	rr := httptest.NewRecorder()
+	e.router.ServeHTTP(rr, httptest.NewRequest("GET", "/api/auth/oidc/stub/login", nil))
+	assert.Equal(t, http.StatusFound, rr.Code)
+	e.browser = rr.Result().Cookies()
+
+	u, err := url.Parse(rr.Header().Get("Location"))
+	assert.Nil(t, err)
+	return u
+}
+
+func TestOIDC_LoginCreatesUser(t *testing.T) {
+	env := newOIDCTestEnv(t)
+	env.issuer.claims = jwt.MapClaims{"sub": "abc", "email": "jane@example.com", "email_verified": true, "name": "Jane", "groups": []string{"teachers"}}
+
+	start := env.startLogin(t)
+	assert.Equal(t, "/authorize", start.Path)
+	assert.Equal(t, "client", start.Query().Get("client_id"))
+
+	rr := env.login(t, start)
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	var resp LoginResponse
+	assert.Nil(t, json.NewDecoder(rr.Body).Decode(&resp))
+	claims, err := env.jwt.ValidateToken(resp.Token)
+	assert.Nil(t, err)
+	assert.Equal(t, []Role{RoleEducator}, claims.Roles)
+
+	user, err := env.store.GetUserByIdentity("stub", "abc")
+	assert.Nil(t, err)
+	assert.Equal(t, claims.UserID, user.Id)
+	assert.Equal(t, "jane@example.com", user.Email)
+	assert.True(t, user.EmailVerified)
+	assert.False(t, user.HasPassword())
+
+	// Logging in again resolves the same account
+	rr = env.login(t, env.startLogin(t))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Len(t, env.store.users, 1)
+}
+
+func TestOIDC_LoginLinksVerifiedEmail(t *testing.T) {
+	env := newOIDCTestEnv(t)
+	hash, _ := bcrypt.GenerateFromPassword([]byte("password"), bcrypt.MinCost)
+	local := &UserWithAuth{PasswordHash: string(hash), Roles: []Role{RoleLearner}, AccountStatus: "active", EmailVerified: true}
+	local.Id = "local-user"
+	local.Email = "jane@example.com"
+	local.Identities = []Identity{{Provider: LocalProvider, Subject: local.Email}}
+	assert.Nil(t, env.store.CreateUser(local))
+
+	env.issuer.claims = jwt.MapClaims{"sub": "abc", "email": "jane@example.com", "email_verified": true, "groups": "ops"}
+	rr := env.login(t, env.startLogin(t))
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	user, err := env.store.GetUserByIdentity("stub", "abc")
+	assert.Nil(t, err)
+	assert.Equal(t, "local-user", user.Id)
+	assert.ElementsMatch(t, []Role{RoleLearner, RoleAdmin}, user.Roles)
+	assert.Len(t, user.Identities, 2)
+
+	// Leaving the group revokes the role but keeps the locally held one
+	env.issuer.claims = jwt.MapClaims{"sub": "abc", "email": "jane@example.com", "email_verified": true, "groups": []string{"teachers"}}
+	rr = env.login(t, env.startLogin(t))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.ElementsMatch(t, []Role{RoleLearner, RoleEducator}, user.Roles)
+
+	env.issuer.claims = jwt.MapClaims{"sub": "abc", "email": "jane@example.com", "email_verified": true}
+	rr = env.login(t, env.startLogin(t))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, []Role{RoleLearner}, user.Roles)
+}
+
+func TestOIDC_LoginRevokesProviderRoles(t *testing.T) {
+	env := newOIDCTestEnv(t)
+	env.issuer.claims = jwt.MapClaims{"sub": "abc", "groups": []string{"teachers", "ops"}}
+	rr := env.login(t, env.startLogin(t))
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	user, err := env.store.GetUserByIdentity("stub", "abc")
+	assert.Nil(t, err)
+	assert.ElementsMatch(t, []Role{RoleEducator, RoleAdmin}, user.Roles)
+
+	env.issuer.claims = jwt.MapClaims{"sub": "abc", "groups": []string{"teachers"}}
+	rr = env.login(t, env.startLogin(t))
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	var resp LoginResponse
+	assert.Nil(t, json.NewDecoder(rr.Body).Decode(&resp))
+	claims, err := env.jwt.ValidateToken(resp.Token)
+	assert.Nil(t, err)
+	assert.Equal(t, []Role{RoleEducator}, claims.Roles)
+	assert.Equal(t, []Role{RoleEducator}, user.Roles)
+}
+
+func TestOIDC_LoginUnverifiedEmailConflicts(t *testing.T) {
+	env := newOIDCTestEnv(t)
+	local := &UserWithAuth{AccountStatus: "active"}
+	local.Id = "local-user"
+	local.Email = "jane@example.com"
+	assert.Nil(t, env.store.CreateUser(local))
+
+	env.issuer.claims = jwt.MapClaims{"sub": "abc", "email": "jane@example.com", "email_verified": false}
+	rr := env.login(t, env.startLogin(t))
+	assert.Equal(t, http.StatusConflict, rr.Code)
+}
+
+func TestOIDC_LoginRequiresLinkForUnverifiedAccounts(t *testing.T) {
+	env := newOIDCTestEnv(t)
+	// Registered by somebody else with the victim's address, never verified
+	squatter := &UserWithAuth{AccountStatus: "active"}
+	squatter.Id = "squatter"
+	squatter.Email = "jane@example.com"
+	assert.Nil(t, env.store.CreateUser(squatter))
+	service := &UserWithAuth{AccountStatus: "active", EmailVerified: true, ServiceAccount: true}
+	service.Id = "ci"
+	service.Email = "ci@example.com"
+	assert.Nil(t, env.store.CreateUser(service))
+
+	for _, email := range []string{"jane@example.com", "ci@example.com"} {
+		env.issuer.claims = jwt.MapClaims{"sub": email, "email": email, "email_verified": true}
+		rr := env.login(t, env.startLogin(t))
+		assert.Equal(t, http.StatusConflict, rr.Code)
+		assert.Contains(t, rr.Body.String(), "LinkRequired")
+
+		_, err := env.store.GetUserByIdentity("stub", email)
+		assert.ErrorIs(t, err, ErrUserNotFound)
+	}
+}
+
+func TestOIDC_LinkAndUnlink(t *testing.T) {
+	env := newOIDCTestEnv(t)
+	hash, _ := bcrypt.GenerateFromPassword([]byte("password"), bcrypt.MinCost)
+	local := &UserWithAuth{PasswordHash: string(hash), Roles: []Role{RoleLearner}, AccountStatus: "active"}
+	local.Id = "local-user"
+	local.Email = "jane@example.com"
+	local.Identities = []Identity{{Provider: LocalProvider, Subject: local.Email}}
+	assert.Nil(t, env.store.CreateUser(local))
+
+	token, _, err := env.jwt.GenerateToken(local.Id, local.Email, local.Roles)
+	assert.Nil(t, err)
+
+	rr := apitest.Do(env.router, token, "POST", "/api/auth/oidc/stub/link", nil)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	env.browser = rr.Result().Cookies()
+
+	var link map[string]string
+	assert.Nil(t, json.NewDecoder(rr.Body).Decode(&link))
+	start, err := url.Parse(link["url"])
+	assert.Nil(t, err)
+
+	// The provider reports a different email; linking is driven by the session, not the email
+	env.issuer.claims = jwt.MapClaims{"sub": "xyz", "email": "jane@work.example.com"}
+	rr = env.login(t, start)
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	user, err := env.store.GetUserByIdentity("stub", "xyz")
+	assert.Nil(t, err)
+	assert.Equal(t, "local-user", user.Id)
+
+	rr = apitest.Do(env.router, token, "DELETE", "/api/auth/identities/local", nil)
+	assert.Equal(t, http.StatusNoContent, rr.Code)
+	assert.False(t, user.HasPassword())
+
+	rr = apitest.Do(env.router, token, "DELETE", "/api/auth/identities/stub", nil)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+}
+
+func TestOIDC_CallbackRejectsUnknownState(t *testing.T) {
+	env := newOIDCTestEnv(t)
+	env.issuer.claims = jwt.MapClaims{"sub": "abc"}
+
+	start := env.startLogin(t)
+	rr := env.login(t, start)
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	// States are single use
+	rr = env.login(t, start)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+}
+
+func TestOIDC_CallbackRejectsOtherBrowser(t *testing.T) {
+	env := newOIDCTestEnv(t)
+	env.issuer.claims = jwt.MapClaims{"sub": "abc"}
+
+	start := env.startLogin(t)
+	if assert.Len(t, env.browser, 1) {
+		assert.True(t, env.browser[0].HttpOnly)
+	}
+
+	// The callback URL is opened in a browser that did not start the flow
+	env.browser = nil
+	rr := env.login(t, start)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	_, err := env.store.GetUserByIdentity("stub", "abc")
+	assert.NotNil(t, err)
+}
+
+func TestOIDC_VerifyIDTokenRejectsWrongAudience(t *testing.T) {
+	env := newOIDCTestEnv(t)
+	provider := env.handler.providers["stub"]
+
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+		"iss": env.issuer.server.URL,
+		"aud": "someone-else",
+		"sub": "abc",
+		"exp": time.Now().Add(time.Hour).Unix(),
+	})
+	token.Header["kid"] = "test"
+	raw, err := token.SignedString(env.issuer.key)
+	assert.Nil(t, err)
+
+	_, err = provider.VerifyIDToken(context.Background(), raw, "")
+	assert.ErrorIs(t, err, ErrInvalidToken)
+}
+
+func TestOIDC_VerifyIDTokenLimitsKeyRefresh(t *testing.T) {
+	env := newOIDCTestEnv(t)
+	provider := env.handler.providers["stub"]
+
+	sign := func(kid string) string {
+		token := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+			"iss": env.issuer.server.URL,
+			"aud": "client",
+			"sub": "abc",
+			"exp": time.Now().Add(time.Hour).Unix(),
+		})
+		token.Header["kid"] = kid
+		raw, err := token.SignedString(env.issuer.key)
+		assert.Nil(t, err)
+		return raw
+	}
+
+	_, err := provider.VerifyIDToken(context.Background(), sign("test"), "")
+	assert.Nil(t, err)
+	assert.Equal(t, 1, env.issuer.jwks)
+
+	// Unknown key IDs do not cause a fetch per token
+	for i := 0; i < 3; i++ {
+		_, err = provider.VerifyIDToken(context.Background(), sign("unknown"), "")
+		assert.ErrorIs(t, err, ErrInvalidToken)
+	}
+	assert.Equal(t, 1, env.issuer.jwks)
+
+	// Once the interval has passed the key set is fetched again
+	provider.refreshedAt = time.Now().Add(-jwksRefreshInterval)
+	_, err = provider.VerifyIDToken(context.Background(), sign("unknown"), "")
+	assert.ErrorIs(t, err, ErrInvalidToken)
+	assert.Equal(t, 2, env.issuer.jwks)
+}
+
+func TestMemoryUserStore_identitiesAreNotAmbiguous(t *testing.T) {
+	store := NewMemoryUserStore()
+	a := &UserWithAuth{Identities: []Identity{{Provider: "a_b", Subject: "c"}}}
+	a.Id = "a"
+	a.Email = "a@example.com"
+	b := &UserWithAuth{Identities: []Identity{{Provider: "a", Subject: "b_c"}}}
+	b.Id = "b"
+	b.Email = "b@example.com"
+	assert.Nil(t, store.CreateUser(a))
+	assert.Nil(t, store.CreateUser(b))
+
+	user, err := store.GetUserByIdentity("a", "b_c")
+	assert.Nil(t, err)
+	assert.Equal(t, "b", user.Id)
+}
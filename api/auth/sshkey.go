@@ -0,0 +1,62 @@
+package auth
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"golang.org/x/crypto/ssh"
+)
+
+var (
+	// ErrInvalidSSHKey is returned when a public key cannot be parsed
+	ErrInvalidSSHKey = errors.New("invalid ssh public key")
+	// ErrDuplicateSSHKey is returned when a user adds a key they already have
+	ErrDuplicateSSHKey = errors.New("ssh key already added")
+)
+
+// SSHKey is a public key a user logs in to instances with through the SSH
+// gateway
+type SSHKey struct {
+	// ID is a unique identifier for the key
+	ID string `json:"id" bson:"id"`
+	// Name describes the key, e.g. the machine it is used from. It defaults to
+	// the comment of the key.
+	Name string `json:"name" bson:"name"`
+	// PublicKey is the key in authorized_keys format, without comment
+	PublicKey string `json:"public_key" bson:"public_key"`
+	// Fingerprint is the SHA256 fingerprint of the key
+	Fingerprint string `json:"fingerprint" bson:"fingerprint"`
+	// CreatedAt records when the key was added
+	CreatedAt time.Time `json:"created_at" bson:"created_at"`
+}
+
+// ParseSSHKey parses a public key in authorized_keys format
+func ParseSSHKey(name, authorizedKey string) (*SSHKey, error) {
+	pub, comment, _, _, err := ssh.ParseAuthorizedKey([]byte(authorizedKey))
+	if err != nil {
+		return nil, ErrInvalidSSHKey
+	}
+	name = strings.TrimSpace(name)
+	if name == "" {
+		name = comment
+	}
+	return &SSHKey{
+		ID:          uuid.New().String(),
+		Name:        name,
+		PublicKey:   strings.TrimSpace(string(ssh.MarshalAuthorizedKey(pub))),
+		Fingerprint: ssh.FingerprintSHA256(pub),
+		CreatedAt:   time.Now(),
+	}, nil
+}
+
+// GetSSHKey returns the key of the user with the given fingerprint, if any
+func (u *UserWithAuth) GetSSHKey(fingerprint string) (*SSHKey, bool) {
+	for i := range u.SSHKeys {
+		if u.SSHKeys[i].Fingerprint == fingerprint {
+			return &u.SSHKeys[i], true
+		}
+	}
+	return nil, false
+}
@@ -0,0 +1,251 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/ringo380/lessoncraft/api/middleware"
+	"github.com/ringo380/lessoncraft/pwd/types"
+	"github.com/ringo380/lessoncraft/router"
+	"golang.org/x/crypto/ssh"
+)
+
+// maxSSHAuthRequestSize limits the body of login checks sent by the SSH gateway
+const maxSSHAuthRequestSize = 64 * 1024
+
+// SessionGetter looks up playground sessions, e.g. pwd.PWDApi
+type SessionGetter interface {
+	SessionGet(sessionId string) (*types.Session, error)
+}
+
+// SSHKeyHandler handles HTTP requests to manage the SSH keys of users, and the
+// login checks of the SSH gateway
+type SSHKeyHandler struct {
+	auth       *AuthHandler
+	sessions   SessionGetter
+	gatewayKey []byte
+
+	// seen holds the signatures of recent login checks, so each can only be
+	// used once while its timestamp is valid
+	seenMu sync.Mutex
+	seen   map[string]time.Time
+}
+
+// NewSSHKeyHandler creates a new SSHKeyHandler
+func NewSSHKeyHandler(auth *AuthHandler) *SSHKeyHandler {
+	return &SSHKeyHandler{auth: auth}
+}
+
+// UseGateway enables the endpoint the SSH gateway checks logins with. Checks
+// must be signed with key, which is shared with the gateway. It must be called
+// before RegisterRoutes.
+func (h *SSHKeyHandler) UseGateway(key []byte, sessions SessionGetter) {
+	h.gatewayKey = key
+	h.sessions = sessions
+	h.seen = make(map[string]time.Time)
+}
+
+// RegisterRoutes registers the SSH key routes with the provided router
+func (h *SSHKeyHandler) RegisterRoutes(r *mux.Router) {
+	authMiddleware := AuthMiddleware(h.auth.tokens)
+	readScope := ScopeMiddleware(ScopeAccountRead)
+	writeScope := ScopeMiddleware(ScopeAccountWrite)
+
+	r.Handle("/api/auth/ssh-keys", authMiddleware(readScope(http.HandlerFunc(h.ListKeys)))).Methods("GET")
+	r.Handle("/api/auth/ssh-keys", authMiddleware(writeScope(http.HandlerFunc(h.AddKey)))).Methods("POST")
+	r.Handle("/api/auth/ssh-keys/{id}", authMiddleware(writeScope(http.HandlerFunc(h.DeleteKey)))).Methods("DELETE")
+
+	if len(h.gatewayKey) > 0 {
+		r.HandleFunc("/api/ssh/authorize", h.Authorize).Methods("POST")
+	}
+}
+
+// AddSSHKeyRequest represents a request to add an SSH key
+type AddSSHKeyRequest struct {
+	Name string `json:"name"`
+	// PublicKey is in authorized_keys format, e.g. the content of id_ed25519.pub
+	PublicKey string `json:"public_key"`
+}
+
+// ListKeys returns the SSH keys of the authenticated user
+func (h *SSHKeyHandler) ListKeys(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.currentUser(w, r)
+	if !ok {
+		return
+	}
+
+	keys := user.SSHKeys
+	if keys == nil {
+		keys = []SSHKey{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(keys)
+}
+
+// AddKey adds an SSH key to the authenticated user
+func (h *SSHKeyHandler) AddKey(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.currentUser(w, r)
+	if !ok {
+		return
+	}
+
+	var req AddSSHKeyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		middleware.WriteError(w, "InvalidRequest", http.StatusBadRequest, "Invalid request format", err)
+		return
+	}
+	key, err := ParseSSHKey(req.Name, req.PublicKey)
+	if err != nil {
+		middleware.WriteError(w, "ValidationError", http.StatusBadRequest, "Invalid public key", err)
+		return
+	}
+	if _, found := user.GetSSHKey(key.Fingerprint); found {
+		middleware.WriteError(w, "Conflict", http.StatusConflict, "SSH key already added", ErrDuplicateSSHKey)
+		return
+	}
+
+	before := *user
+	user.SSHKeys = append(append([]SSHKey{}, user.SSHKeys...), *key)
+	user.UpdatedAt = time.Now()
+	if err := h.auth.userStore.UpdateUser(user.Id, user); err != nil {
+		middleware.WriteError(w, "DatabaseError", http.StatusInternalServerError, "Failed to update user", err)
+		return
+	}
+	h.auth.audit(r, "user.ssh_key.add", user.Id, before, user)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(key)
+}
+
+// DeleteKey removes an SSH key from the authenticated user
+func (h *SSHKeyHandler) DeleteKey(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.currentUser(w, r)
+	if !ok {
+		return
+	}
+
+	id := mux.Vars(r)["id"]
+	keys := make([]SSHKey, 0, len(user.SSHKeys))
+	for _, key := range user.SSHKeys {
+		if key.ID != id {
+			keys = append(keys, key)
+		}
+	}
+	if len(keys) == len(user.SSHKeys) {
+		middleware.WriteError(w, "NotFound", http.StatusNotFound, "SSH key not found", nil)
+		return
+	}
+
+	before := *user
+	user.SSHKeys = keys
+	user.UpdatedAt = time.Now()
+	if err := h.auth.userStore.UpdateUser(user.Id, user); err != nil {
+		middleware.WriteError(w, "DatabaseError", http.StatusInternalServerError, "Failed to update user", err)
+		return
+	}
+	h.auth.audit(r, "user.ssh_key.delete", user.Id, before, user)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Authorize checks a login of the SSH gateway. The SSH user names the
+// instance, and the key must belong to the owner of its session.
+func (h *SSHKeyHandler) Authorize(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxSSHAuthRequestSize))
+	if err != nil {
+		middleware.WriteError(w, "InvalidRequest", http.StatusBadRequest, "Invalid request format", err)
+		return
+	}
+	signature := r.Header.Get(router.SignatureHeader)
+	now := time.Now()
+	if err := router.VerifyTimedRequest(h.gatewayKey, body, r.Header.Get(router.TimestampHeader), signature, now); err != nil {
+		middleware.WriteError(w, "Unauthorized", http.StatusUnauthorized, "Invalid signature", err)
+		return
+	}
+	if !h.firstUse(signature, now) {
+		middleware.WriteError(w, "Unauthorized", http.StatusUnauthorized, "Request already used", nil)
+		return
+	}
+
+	var req router.SSHAuthRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		middleware.WriteError(w, "InvalidRequest", http.StatusBadRequest, "Invalid request format", err)
+		return
+	}
+	pub, _, _, _, err := ssh.ParseAuthorizedKey([]byte(req.PublicKey))
+	if err != nil {
+		middleware.WriteError(w, "ValidationError", http.StatusBadRequest, "Invalid public key", ErrInvalidSSHKey)
+		return
+	}
+
+	user, err := h.sessionOwner(req.User)
+	if err != nil {
+		middleware.WriteError(w, "Forbidden", http.StatusForbidden, "Access denied", err)
+		return
+	}
+	if _, found := user.GetSSHKey(ssh.FingerprintSHA256(pub)); !found || user.IsBanned {
+		middleware.WriteError(w, "Forbidden", http.StatusForbidden, "Access denied", nil)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(router.SSHAuthResponse{UserID: user.Id})
+}
+
+// firstUse records a signature of a login check, and reports whether it was
+// not seen before. Signatures older than router.MaxRequestAge are forgotten, as
+// VerifyTimedRequest already refuses them.
+func (h *SSHKeyHandler) firstUse(signature string, now time.Time) bool {
+	h.seenMu.Lock()
+	defer h.seenMu.Unlock()
+
+	for s, t := range h.seen {
+		if now.Sub(t) > 2*router.MaxRequestAge {
+			delete(h.seen, s)
+		}
+	}
+	if _, found := h.seen[signature]; found {
+		return false
+	}
+	h.seen[signature] = now
+	return true
+}
+
+// sessionOwner returns the owner of the session of the instance an SSH user
+// names
+func (h *SSHKeyHandler) sessionOwner(sshUser string) (*UserWithAuth, error) {
+	sessionId, err := router.HostSession(sshUser)
+	if err != nil {
+		return nil, err
+	}
+	session, err := h.sessions.SessionGet(sessionId)
+	if err != nil {
+		return nil, err
+	}
+	if session.UserId == "" {
+		return nil, errors.New("session has no owner")
+	}
+	return h.auth.userStore.GetUserByID(session.UserId)
+}
+
+func (h *SSHKeyHandler) currentUser(w http.ResponseWriter, r *http.Request) (*UserWithAuth, bool) {
+	userID, ok := GetUserID(r)
+	if !ok {
+		middleware.WriteError(w, "Unauthorized", http.StatusUnauthorized, "User not authenticated", nil)
+		return nil, false
+	}
+
+	user, err := h.auth.userStore.GetUserByID(userID)
+	if err != nil {
+		middleware.WriteError(w, "DatabaseError", http.StatusInternalServerError, "Failed to retrieve user", err)
+		return nil, false
+	}
+	return user, true
+}
@@ -0,0 +1,150 @@
+package auth
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/ringo380/lessoncraft/api/apitest"
+	"github.com/ringo380/lessoncraft/pwd/types"
+	"github.com/ringo380/lessoncraft/router"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/ssh"
+)
+
+type fakeSessions map[string]*types.Session
+
+func (s fakeSessions) SessionGet(sessionId string) (*types.Session, error) {
+	if session, found := s[sessionId]; found {
+		return session, nil
+	}
+	return nil, errors.New("not found")
+}
+
+func newTestSSHKey(t *testing.T) string {
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	assert.Nil(t, err)
+	key, err := ssh.NewPublicKey(pub)
+	assert.Nil(t, err)
+	return strings.TrimSpace(string(ssh.MarshalAuthorizedKey(key))) + " learner@laptop"
+}
+
+type sshKeyTestEnv struct {
+	users  *MemoryUserStore
+	router *mux.Router
+	token  string // JWT of the learner owning session aaaabbbb
+}
+
+func newSSHKeyTestEnv(t *testing.T) *sshKeyTestEnv {
+	env := &sshKeyTestEnv{users: NewMemoryUserStore(), router: mux.NewRouter()}
+	jwt := NewJWTService("secret", "lessoncraft", time.Hour)
+
+	learner := &UserWithAuth{Roles: []Role{RoleLearner}, AccountStatus: "active"}
+	learner.Id = "learner"
+	learner.Email = "learner@example.com"
+	assert.Nil(t, env.users.CreateUser(learner))
+	token, _, err := jwt.GenerateToken(learner.Id, learner.Email, learner.Roles)
+	assert.Nil(t, err)
+	env.token = token
+
+	h := NewSSHKeyHandler(NewAuthHandler(env.users, jwt))
+	h.UseGateway([]byte("gateway"), fakeSessions{"aaaabbbb": {Id: "aaaabbbb", UserId: learner.Id}, "ccccdddd": {Id: "ccccdddd", UserId: "other"}})
+	h.RegisterRoutes(env.router)
+	return env
+}
+
+func (e *sshKeyTestEnv) do(method, path string, body interface{}) *httptest.ResponseRecorder {
+	return apitest.Do(e.router, e.token, method, path, body)
+}
+
+func (e *sshKeyTestEnv) authorize(key []byte, user, publicKey string) *httptest.ResponseRecorder {
+	body, _ := json.Marshal(router.SSHAuthRequest{User: user, PublicKey: publicKey, Nonce: newTestNonce()})
+	timestamp, signature := router.SignTimedRequest(key, body, time.Now())
+	return e.send(body, timestamp, signature)
+}
+
+func (e *sshKeyTestEnv) send(body []byte, timestamp, signature string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("POST", "/api/ssh/authorize", bytes.NewReader(body))
+	req.Header.Set(router.TimestampHeader, timestamp)
+	req.Header.Set(router.SignatureHeader, signature)
+	rr := httptest.NewRecorder()
+	e.router.ServeHTTP(rr, req)
+	return rr
+}
+
+var testNonces int
+
+func newTestNonce() string {
+	testNonces++
+	return strconv.Itoa(testNonces)
+}
+
+func TestSSHKeys_AddListDelete(t *testing.T) {
+	env := newSSHKeyTestEnv(t)
+	publicKey := newTestSSHKey(t)
+
+	rr := env.do("POST", "/api/auth/ssh-keys", AddSSHKeyRequest{PublicKey: publicKey})
+	assert.Equal(t, http.StatusCreated, rr.Code)
+	var key SSHKey
+	json.NewDecoder(rr.Body).Decode(&key)
+	assert.Equal(t, "learner@laptop", key.Name)
+	assert.True(t, strings.HasPrefix(key.Fingerprint, "SHA256:"))
+
+	rr = env.do("POST", "/api/auth/ssh-keys", AddSSHKeyRequest{Name: "again", PublicKey: publicKey})
+	assert.Equal(t, http.StatusConflict, rr.Code)
+	rr = env.do("POST", "/api/auth/ssh-keys", AddSSHKeyRequest{PublicKey: "ssh-rsa garbage"})
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+
+	rr = env.do("GET", "/api/auth/ssh-keys", nil)
+	var keys []SSHKey
+	json.NewDecoder(rr.Body).Decode(&keys)
+	assert.Len(t, keys, 1)
+
+	assert.Equal(t, http.StatusNoContent, env.do("DELETE", "/api/auth/ssh-keys/"+key.ID, nil).Code)
+	assert.Equal(t, http.StatusNotFound, env.do("DELETE", "/api/auth/ssh-keys/"+key.ID, nil).Code)
+}
+
+func TestSSHKeys_Authorize(t *testing.T) {
+	env := newSSHKeyTestEnv(t)
+	publicKey := newTestSSHKey(t)
+	assert.Equal(t, http.StatusCreated, env.do("POST", "/api/auth/ssh-keys", AddSSHKeyRequest{PublicKey: publicKey}).Code)
+
+	rr := env.authorize([]byte("gateway"), "ip10-0-0-1-aaaabbbb", publicKey)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	var resp router.SSHAuthResponse
+	json.NewDecoder(rr.Body).Decode(&resp)
+	assert.Equal(t, "learner", resp.UserID)
+
+	// Only signed checks are answered
+	assert.Equal(t, http.StatusUnauthorized, env.authorize([]byte("other"), "ip10-0-0-1-aaaabbbb", publicKey).Code)
+	// Keys only open the sessions of their owner
+	assert.Equal(t, http.StatusForbidden, env.authorize([]byte("gateway"), "ip10-0-0-1-ccccdddd", publicKey).Code)
+	assert.Equal(t, http.StatusForbidden, env.authorize([]byte("gateway"), "ip10-0-0-1-eeeeffff", publicKey).Code)
+	assert.Equal(t, http.StatusForbidden, env.authorize([]byte("gateway"), "ip10-0-0-1-aaaabbbb", newTestSSHKey(t)).Code)
+}
+
+func TestSSHKeys_AuthorizeRefusesReplays(t *testing.T) {
+	env := newSSHKeyTestEnv(t)
+	publicKey := newTestSSHKey(t)
+	assert.Equal(t, http.StatusCreated, env.do("POST", "/api/auth/ssh-keys", AddSSHKeyRequest{PublicKey: publicKey}).Code)
+
+	body, _ := json.Marshal(router.SSHAuthRequest{User: "ip10-0-0-1-aaaabbbb", PublicKey: publicKey, Nonce: newTestNonce()})
+	timestamp, signature := router.SignTimedRequest([]byte("gateway"), body, time.Now())
+	assert.Equal(t, http.StatusOK, env.send(body, timestamp, signature).Code)
+	assert.Equal(t, http.StatusUnauthorized, env.send(body, timestamp, signature).Code)
+
+	// Old checks are refused even when never used
+	timestamp, signature = router.SignTimedRequest([]byte("gateway"), body, time.Now().Add(-2*router.MaxRequestAge))
+	assert.Equal(t, http.StatusUnauthorized, env.send(body, timestamp, signature).Code)
+	// Unsigned checks too
+	assert.Equal(t, http.StatusUnauthorized, env.send(body, "", "").Code)
+}
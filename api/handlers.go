@@ -5,17 +5,21 @@ import (
 )
 
 type ApiHandler struct {
-	router        *mux.Router
 	lessonHandler *LessonHandler
 }
 
 func NewApiHandler(lessonStore LessonStore) *ApiHandler {
 	return &ApiHandler{
-		router:        mux.NewRouter(),
 		lessonHandler: NewLessonHandler(lessonStore),
 	}
 }
 
-func (h *ApiHandler) RegisterRoutes(*mux.Router) {
-	h.lessonHandler.RegisterRoutes(h.router)
+// Lessons returns the lesson handler, so its access rules and token validation
+// can be set before RegisterRoutes
+func (h *ApiHandler) Lessons() *LessonHandler {
+	return h.lessonHandler
+}
+
+func (h *ApiHandler) RegisterRoutes(r *mux.Router) {
+	h.lessonHandler.RegisterRoutes(r)
 }
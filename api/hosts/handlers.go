@@ -0,0 +1,151 @@
+package hosts
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/ringo380/lessoncraft/api/auth"
+	"github.com/ringo380/lessoncraft/api/middleware"
+	"github.com/ringo380/lessoncraft/placement"
+)
+
+// Prober refreshes the capacity of the hosts of a registry
+type Prober interface {
+	Probe()
+}
+
+// Handler manages the docker hosts sessions are placed on. All routes require
+// the admin role, and API keys need the admin scope.
+type Handler struct {
+	registry *placement.Registry
+	prober   Prober
+	tokens   auth.TokenValidator
+}
+
+// NewHandler creates a new Handler. The prober is optional, hosts added
+// without one are probed on the next periodic probe.
+func NewHandler(registry *placement.Registry, prober Prober, tokens auth.TokenValidator) *Handler {
+	return &Handler{
+		registry: registry,
+		prober:   prober,
+		tokens:   tokens,
+	}
+}
+
+// AddHostRequest represents a request to register a host
+type AddHostRequest struct {
+	ID          string            `json:"id"`
+	Addr        string            `json:"addr"`
+	Labels      map[string]string `json:"labels"`
+	MaxSessions int               `json:"max_sessions"`
+}
+
+// RegisterRoutes registers the host routes with the provided router:
+//   - GET /api/hosts: List hosts
+//   - POST /api/hosts: Add a host
+//   - GET /api/hosts/{hostId}: Get a host
+//   - DELETE /api/hosts/{hostId}: Remove a host without sessions, or any host with ?force=true
+//   - POST /api/hosts/{hostId}/cordon: Stop placing sessions on a host
+//   - POST /api/hosts/{hostId}/uncordon: Place sessions on a host again
+//   - POST /api/hosts/{hostId}/drain: Stop placing sessions on a host and remove it once empty
+func (h *Handler) RegisterRoutes(r *mux.Router) {
+	authMiddleware := auth.AuthMiddleware(h.tokens)
+	adminMiddleware := auth.RoleMiddleware(auth.RoleAdmin)
+	scopeMiddleware := auth.ScopeMiddleware(auth.ScopeAdmin)
+	handle := func(path string, f http.HandlerFunc, method string) {
+		r.Handle(path, authMiddleware(adminMiddleware(scopeMiddleware(f)))).Methods(method)
+	}
+
+	handle("/api/hosts", h.List, "GET")
+	handle("/api/hosts", h.Add, "POST")
+	handle("/api/hosts/{hostId}", h.Get, "GET")
+	handle("/api/hosts/{hostId}", h.Remove, "DELETE")
+	handle("/api/hosts/{hostId}/cordon", h.state(h.registry.Cordon), "POST")
+	handle("/api/hosts/{hostId}/uncordon", h.state(h.registry.Uncordon), "POST")
+	handle("/api/hosts/{hostId}/drain", h.state(h.registry.Drain), "POST")
+}
+
+// List returns all hosts
+func (h *Handler) List(w http.ResponseWriter, r *http.Request) {
+	middleware.WriteJSON(w, http.StatusOK, h.registry.List())
+}
+
+// Add registers a host and probes it right away when a prober is set
+func (h *Handler) Add(w http.ResponseWriter, r *http.Request) {
+	var req AddHostRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		middleware.WriteError(w, "ValidationError", http.StatusBadRequest, "Invalid request body", err)
+		return
+	}
+
+	host, err := h.registry.Add(placement.Host{ID: req.ID, Addr: req.Addr, Labels: req.Labels, MaxSessions: req.MaxSessions})
+	if errors.Is(err, placement.ErrHostExists) {
+		middleware.WriteError(w, "Conflict", http.StatusConflict, "Host already exists", err)
+		return
+	} else if err != nil {
+		middleware.WriteError(w, "ValidationError", http.StatusBadRequest, "Invalid host", err)
+		return
+	}
+
+	if h.prober != nil {
+		h.prober.Probe()
+		if probed, err := h.registry.Get(host.ID); err == nil {
+			host = probed
+		}
+	}
+	middleware.WriteJSON(w, http.StatusCreated, host)
+}
+
+// Get returns a host
+func (h *Handler) Get(w http.ResponseWriter, r *http.Request) {
+	host, err := h.registry.Get(mux.Vars(r)["hostId"])
+	if err != nil {
+		writeRegistryError(w, err)
+		return
+	}
+	middleware.WriteJSON(w, http.StatusOK, host)
+}
+
+// Remove unregisters a host
+func (h *Handler) Remove(w http.ResponseWriter, r *http.Request) {
+	force := r.URL.Query().Get("force") == "true"
+	if err := h.registry.Remove(mux.Vars(r)["hostId"], force); err != nil {
+		writeRegistryError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// state returns a handler changing the state of a host. Drained hosts without
+// sessions are removed, so they are answered with no content.
+func (h *Handler) state(change func(id string) error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := mux.Vars(r)["hostId"]
+		if err := change(id); err != nil {
+			writeRegistryError(w, err)
+			return
+		}
+		host, err := h.registry.Get(id)
+		if errors.Is(err, placement.ErrHostNotFound) {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		} else if err != nil {
+			writeRegistryError(w, err)
+			return
+		}
+		middleware.WriteJSON(w, http.StatusOK, host)
+	}
+}
+
+func writeRegistryError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, placement.ErrHostNotFound):
+		middleware.WriteError(w, "NotFound", http.StatusNotFound, "Host not found", nil)
+	case errors.Is(err, placement.ErrHostInUse):
+		middleware.WriteError(w, "Conflict", http.StatusConflict, "Host still has sessions, drain it or remove it with force=true", nil)
+	default:
+		middleware.WriteError(w, "InternalError", http.StatusInternalServerError, "Failed to update host", err)
+	}
+}
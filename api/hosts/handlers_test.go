@@ -0,0 +1,105 @@
+package hosts
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/ringo380/lessoncraft/api/apitest"
+	"github.com/ringo380/lessoncraft/api/auth"
+	"github.com/ringo380/lessoncraft/placement"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeProber struct {
+	registry *placement.Registry
+	probes   int
+}
+
+func (p *fakeProber) Probe() {
+	p.probes++
+	for _, h := range p.registry.List() {
+		p.registry.UpdateCapacity(h.ID, placement.Capacity{CPUs: 4}, nil)
+	}
+}
+
+type hostsTestEnv struct {
+	registry *placement.Registry
+	prober   *fakeProber
+	jwt      *auth.JWTService
+	router   *mux.Router
+}
+
+func newHostsTestEnv() *hostsTestEnv {
+	registry := placement.NewRegistry()
+	env := &hostsTestEnv{
+		registry: registry,
+		prober:   &fakeProber{registry: registry},
+		jwt:      auth.NewJWTService("secret", "lessoncraft", time.Hour),
+	}
+	env.router = mux.NewRouter()
+	NewHandler(registry, env.prober, env.jwt).RegisterRoutes(env.router)
+	return env
+}
+
+func (e *hostsTestEnv) do(t *testing.T, role auth.Role, method, path string, body interface{}) *httptest.ResponseRecorder {
+	token, _, err := e.jwt.GenerateToken("admin", "admin@example.com", []auth.Role{role})
+	assert.Nil(t, err)
+
+	return apitest.Do(e.router, token, method, path, body)
+}
+
+func TestHandler_Hosts(t *testing.T) {
+	env := newHostsTestEnv()
+
+	rr := env.do(t, auth.RoleEducator, "GET", "/api/hosts", nil)
+	assert.Equal(t, http.StatusForbidden, rr.Code)
+
+	rr = env.do(t, auth.RoleAdmin, "POST", "/api/hosts", AddHostRequest{ID: "h1"})
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+
+	rr = env.do(t, auth.RoleAdmin, "POST", "/api/hosts", AddHostRequest{ID: "h1", Addr: "tcp://10.0.0.1:2375"})
+	assert.Equal(t, http.StatusCreated, rr.Code)
+	var host placement.Host
+	json.NewDecoder(rr.Body).Decode(&host)
+	assert.True(t, host.Healthy)
+	assert.Equal(t, 4, host.Capacity.CPUs)
+	assert.Equal(t, 1, env.prober.probes)
+
+	rr = env.do(t, auth.RoleAdmin, "POST", "/api/hosts", AddHostRequest{ID: "h1", Addr: "tcp://10.0.0.1:2375"})
+	assert.Equal(t, http.StatusConflict, rr.Code)
+
+	rr = env.do(t, auth.RoleAdmin, "POST", "/api/hosts/h1/cordon", nil)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	json.NewDecoder(rr.Body).Decode(&host)
+	assert.Equal(t, placement.StateCordoned, host.State)
+
+	assert.Nil(t, env.registry.Adopt("h1", placement.Request{SessionID: "s1"}))
+	rr = env.do(t, auth.RoleAdmin, "DELETE", "/api/hosts/h1", nil)
+	assert.Equal(t, http.StatusConflict, rr.Code)
+
+	rr = env.do(t, auth.RoleAdmin, "POST", "/api/hosts/h1/drain", nil)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	json.NewDecoder(rr.Body).Decode(&host)
+	assert.Equal(t, placement.StateDraining, host.State)
+
+	env.registry.Release("s1")
+	rr = env.do(t, auth.RoleAdmin, "GET", "/api/hosts/h1", nil)
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+
+	rr = env.do(t, auth.RoleAdmin, "POST", "/api/hosts", AddHostRequest{ID: "h2", Addr: "tcp://10.0.0.2:2375"})
+	assert.Equal(t, http.StatusCreated, rr.Code)
+	rr = env.do(t, auth.RoleAdmin, "GET", "/api/hosts", nil)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	var hosts []*placement.Host
+	json.NewDecoder(rr.Body).Decode(&hosts)
+	assert.Len(t, hosts, 1)
+
+	rr = env.do(t, auth.RoleAdmin, "DELETE", "/api/hosts/h2", nil)
+	assert.Equal(t, http.StatusNoContent, rr.Code)
+	rr = env.do(t, auth.RoleAdmin, "POST", "/api/hosts/h2/uncordon", nil)
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+}
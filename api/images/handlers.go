@@ -0,0 +1,172 @@
+package images
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/ringo380/lessoncraft/api/auth"
+	"github.com/ringo380/lessoncraft/api/middleware"
+	"github.com/ringo380/lessoncraft/images"
+	"github.com/ringo380/lessoncraft/lesson"
+)
+
+// LessonStore is the part of the lesson store the images of lessons are built from
+type LessonStore interface {
+	GetLesson(id string) (*lesson.Lesson, error)
+}
+
+// LessonAccess decides if the user making a request may manage a lesson
+type LessonAccess interface {
+	CanEditLesson(r *http.Request, l *lesson.Lesson) bool
+}
+
+// Handler serves the image catalog. Everyone authenticated can browse it,
+// educators build and delete lesson images and the scan hook is restricted
+// to admins.
+type Handler struct {
+	catalog *images.Catalog
+	lessons LessonStore
+	access  LessonAccess
+	tokens  auth.TokenValidator
+}
+
+// NewHandler creates a new Handler
+func NewHandler(catalog *images.Catalog, lessons LessonStore, tokens auth.TokenValidator) *Handler {
+	return &Handler{catalog: catalog, lessons: lessons, tokens: tokens}
+}
+
+// SetLessonAccess restricts which lessons a request may build images for
+func (h *Handler) SetLessonAccess(access LessonAccess) {
+	h.access = access
+}
+
+// RegisterRoutes registers the image routes with the provided router:
+//   - GET /api/images: List the catalog, or the images of a lesson with ?lesson_id=
+//   - GET /api/images/{imageId}: Get an image
+//   - GET /api/images/{imageId}/logs: Get the build log of an image
+//   - DELETE /api/images/{imageId}: Remove an image from the catalog and the docker hosts
+//   - PUT /api/images/{imageId}/scan: Report the vulnerability scan of an image
+//   - POST /api/lessons/{lessonId}/image: Build the image of the current version of a lesson
+func (h *Handler) RegisterRoutes(r *mux.Router) {
+	authMiddleware := auth.AuthMiddleware(h.tokens)
+	adminMiddleware := auth.RoleMiddleware(auth.RoleAdmin)
+	scopeMiddleware := auth.ScopeMiddleware(auth.ScopeAdmin)
+	writeMiddleware := auth.ScopeMiddleware(auth.ScopeLessonsWrite)
+	handle := func(path string, f http.Handler, method string) {
+		r.Handle(path, authMiddleware(f)).Methods(method)
+	}
+
+	handle("/api/images", http.HandlerFunc(h.List), "GET")
+	handle("/api/images/{imageId}", http.HandlerFunc(h.Get), "GET")
+	handle("/api/images/{imageId}/logs", educator(http.HandlerFunc(h.Logs)), "GET")
+	handle("/api/images/{imageId}", writeMiddleware(educator(http.HandlerFunc(h.Delete))), "DELETE")
+	handle("/api/images/{imageId}/scan", adminMiddleware(scopeMiddleware(http.HandlerFunc(h.UpdateScan))), "PUT")
+	handle("/api/lessons/{lessonId}/image", writeMiddleware(educator(http.HandlerFunc(h.Build))), "POST")
+}
+
+// educator restricts a handler to educators and admins
+func educator(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !auth.IsEducator(r) && !auth.IsAdmin(r) {
+			middleware.WriteError(w, "Forbidden", http.StatusForbidden, "Only educators can manage lesson images", nil)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// List returns the images of the catalog
+func (h *Handler) List(w http.ResponseWriter, r *http.Request) {
+	list, err := h.catalog.List(r.URL.Query().Get("lesson_id"))
+	if err != nil {
+		middleware.WriteError(w, "DatabaseError", http.StatusInternalServerError, "Failed to retrieve images", err)
+		return
+	}
+	middleware.WriteJSON(w, http.StatusOK, list)
+}
+
+// Get returns an image of the catalog
+func (h *Handler) Get(w http.ResponseWriter, r *http.Request) {
+	image, err := h.catalog.Get(mux.Vars(r)["imageId"])
+	if err != nil {
+		writeCatalogError(w, err)
+		return
+	}
+	middleware.WriteJSON(w, http.StatusOK, image)
+}
+
+// Logs returns the build log of an image
+func (h *Handler) Logs(w http.ResponseWriter, r *http.Request) {
+	image, err := h.catalog.Get(mux.Vars(r)["imageId"])
+	if err != nil {
+		writeCatalogError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprint(w, image.BuildLog)
+}
+
+// Delete removes an image from the catalog and from the docker hosts
+func (h *Handler) Delete(w http.ResponseWriter, r *http.Request) {
+	if err := h.catalog.Delete(mux.Vars(r)["imageId"]); err != nil {
+		writeCatalogError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// UpdateScan records the vulnerability scan of an image
+func (h *Handler) UpdateScan(w http.ResponseWriter, r *http.Request) {
+	var scan images.Scan
+	if err := json.NewDecoder(r.Body).Decode(&scan); err != nil {
+		middleware.WriteError(w, "ValidationError", http.StatusBadRequest, "Invalid request body", err)
+		return
+	}
+	image, err := h.catalog.UpdateScan(mux.Vars(r)["imageId"], scan)
+	if err != nil {
+		writeCatalogError(w, err)
+		return
+	}
+	middleware.WriteJSON(w, http.StatusOK, image)
+}
+
+// Build starts building the image of a lesson. The image is returned while it
+// is being built.
+func (h *Handler) Build(w http.ResponseWriter, r *http.Request) {
+	userID, _ := auth.GetUserID(r)
+	l, err := h.lessons.GetLesson(mux.Vars(r)["lessonId"])
+	if err != nil {
+		middleware.WriteError(w, "NotFound", http.StatusNotFound, "Lesson not found", err)
+		return
+	}
+	if h.access != nil && !h.access.CanEditLesson(r, l) {
+		middleware.WriteError(w, "NotFound", http.StatusNotFound, "Lesson not found", nil)
+		return
+	}
+
+	image, err := h.catalog.Build(userID, l)
+	if err != nil {
+		writeCatalogError(w, err)
+		return
+	}
+	middleware.WriteJSON(w, http.StatusAccepted, image)
+}
+
+func writeCatalogError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, images.ErrNotFound):
+		middleware.WriteError(w, "NotFound", http.StatusNotFound, "Image not found", nil)
+	case errors.Is(err, images.ErrNoBuild):
+		middleware.WriteError(w, "ValidationError", http.StatusBadRequest, "The lesson has no image build", nil)
+	case errors.Is(err, images.ErrInvalidBuild), errors.Is(err, images.ErrInvalidScan):
+		middleware.WriteError(w, "ValidationError", http.StatusBadRequest, err.Error(), nil)
+	case errors.Is(err, images.ErrBuildInProgress):
+		middleware.WriteError(w, "Conflict", http.StatusConflict, "The image is being built", nil)
+	default:
+		middleware.WriteError(w, "InternalError", http.StatusInternalServerError, "Failed to update the image catalog", err)
+	}
+}
@@ -0,0 +1,127 @@
+package images
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/ringo380/lessoncraft/api/apitest"
+	"github.com/ringo380/lessoncraft/api/auth"
+	"github.com/ringo380/lessoncraft/images"
+	"github.com/ringo380/lessoncraft/lesson"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeBuilder struct{}
+
+func (fakeBuilder) Build(ctx context.Context, req images.BuildRequest, log io.Writer) (images.BuildResult, error) {
+	io.WriteString(log, "Successfully built abc\n")
+	return images.BuildResult{Digest: "sha256:abc", Size: 10, Hosts: []string{"host1"}}, nil
+}
+
+func (fakeBuilder) Remove(reference string) error {
+	return nil
+}
+
+type fakeLessons map[string]*lesson.Lesson
+
+func (f fakeLessons) GetLesson(id string) (*lesson.Lesson, error) {
+	l, ok := f[id]
+	if !ok {
+		return nil, errors.New("not found")
+	}
+	return l, nil
+}
+
+type imagesTestEnv struct {
+	jwt    *auth.JWTService
+	router *mux.Router
+}
+
+func newImagesTestEnv() *imagesTestEnv {
+	env := &imagesTestEnv{jwt: auth.NewJWTService("secret", "lessoncraft", time.Hour)}
+	lessons := fakeLessons{
+		"l1": {ID: "l1", Version: 2, Build: &lesson.ImageBuild{Dockerfile: "FROM alpine"}},
+		"l2": {ID: "l2", Version: 1},
+	}
+	env.router = mux.NewRouter()
+	NewHandler(images.NewCatalog(images.NewMemoryStore(), fakeBuilder{}), lessons, env.jwt).RegisterRoutes(env.router)
+	return env
+}
+
+func (e *imagesTestEnv) do(t *testing.T, role auth.Role, method, path string, body interface{}) *httptest.ResponseRecorder {
+	token, _, err := e.jwt.GenerateToken("user1", "user1@example.com", []auth.Role{role})
+	assert.Nil(t, err)
+
+	return apitest.Do(e.router, token, method, path, body)
+}
+
+func TestHandler_Build(t *testing.T) {
+	env := newImagesTestEnv()
+
+	rr := env.do(t, auth.RoleLearner, "POST", "/api/lessons/l1/image", nil)
+	assert.Equal(t, http.StatusForbidden, rr.Code)
+	rr = env.do(t, auth.RoleEducator, "POST", "/api/lessons/missing/image", nil)
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+	rr = env.do(t, auth.RoleEducator, "POST", "/api/lessons/l2/image", nil)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+
+	rr = env.do(t, auth.RoleEducator, "POST", "/api/lessons/l1/image", nil)
+	assert.Equal(t, http.StatusAccepted, rr.Code)
+	var image images.Image
+	json.NewDecoder(rr.Body).Decode(&image)
+	assert.Equal(t, "lessoncraft/lessons/l1:v2", image.Reference)
+
+	assert.Eventually(t, func() bool {
+		rr := env.do(t, auth.RoleLearner, "GET", "/api/images/"+image.ID, nil)
+		json.NewDecoder(rr.Body).Decode(&image)
+		return image.Status == images.StatusReady
+	}, time.Second, 10*time.Millisecond)
+	assert.Equal(t, images.ScanSkipped, image.Scan.Status)
+
+	rr = env.do(t, auth.RoleLearner, "GET", "/api/images?lesson_id=l1", nil)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	var list []images.Image
+	json.NewDecoder(rr.Body).Decode(&list)
+	assert.Len(t, list, 1)
+
+	// Build logs are served to educators only
+	rr = env.do(t, auth.RoleLearner, "GET", "/api/images/"+image.ID+"/logs", nil)
+	assert.Equal(t, http.StatusForbidden, rr.Code)
+	rr = env.do(t, auth.RoleEducator, "GET", "/api/images/"+image.ID+"/logs", nil)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "Successfully built abc\n", rr.Body.String())
+
+	rr = env.do(t, auth.RoleEducator, "DELETE", "/api/images/"+image.ID, nil)
+	assert.Equal(t, http.StatusNoContent, rr.Code)
+	rr = env.do(t, auth.RoleLearner, "GET", "/api/images/"+image.ID, nil)
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+}
+
+func TestHandler_UpdateScan(t *testing.T) {
+	env := newImagesTestEnv()
+
+	rr := env.do(t, auth.RoleEducator, "POST", "/api/lessons/l1/image", nil)
+	var image images.Image
+	json.NewDecoder(rr.Body).Decode(&image)
+
+	scan := images.Scan{Status: images.ScanFailed, Scanner: "trivy", Report: "2 critical vulnerabilities"}
+	rr = env.do(t, auth.RoleEducator, "PUT", "/api/images/"+image.ID+"/scan", scan)
+	assert.Equal(t, http.StatusForbidden, rr.Code)
+
+	rr = env.do(t, auth.RoleAdmin, "PUT", "/api/images/"+image.ID+"/scan", images.Scan{Status: "bogus"})
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+
+	rr = env.do(t, auth.RoleAdmin, "PUT", "/api/images/"+image.ID+"/scan", scan)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	json.NewDecoder(rr.Body).Decode(&image)
+	assert.Equal(t, images.ScanFailed, image.Scan.Status)
+	assert.Equal(t, "trivy", image.Scan.Scanner)
+	assert.False(t, image.Scan.ScannedAt.IsZero())
+}
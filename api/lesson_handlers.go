@@ -3,8 +3,10 @@ package api
 import (
 	"encoding/json"
 	"fmt"
+	"github.com/ringo380/lessoncraft/api/auth"
 	"github.com/ringo380/lessoncraft/api/middleware"
 	"github.com/ringo380/lessoncraft/lesson"
+	"github.com/ringo380/lessoncraft/router"
 	"log"
 	"net/http"
 	"strconv"
@@ -18,8 +20,27 @@ import (
 // It provides endpoints for creating, retrieving, updating, and deleting lessons,
 // as well as starting lessons, completing steps, and validating step outputs.
 type LessonHandler struct {
-	parser lesson.Parser // Parser for converting markdown to lessons
-	store  LessonStore   // Storage for lessons
+	parser lesson.Parser       // Parser for converting markdown to lessons
+	store  LessonStore         // Storage for lessons
+	access LessonAccess        // Optional visibility rules, nil allows everything
+	audit  Auditor             // Optional audit log, nil disables auditing
+	tokens auth.TokenValidator // Optional bearer token validation, nil leaves requests anonymous
+}
+
+// Auditor records authoring actions in the audit log. before and after are the
+// state of the target around the action and may be nil.
+type Auditor interface {
+	Record(r *http.Request, action, targetType, targetID string, before, after interface{})
+}
+
+// LessonAccess decides which lessons the user making a request may see and manage.
+// It is used to restrict lessons that belong to an organisation to its members.
+type LessonAccess interface {
+	// CanViewLesson checks if the request may read the lesson.
+	CanViewLesson(r *http.Request, l *lesson.Lesson) bool
+
+	// CanEditLesson checks if the request may create, update or delete the lesson.
+	CanEditLesson(r *http.Request, l *lesson.Lesson) bool
 }
 
 // LessonStore defines the interface for lesson storage operations.
@@ -57,6 +78,92 @@ func NewLessonHandler(store LessonStore) *LessonHandler {
 	}
 }
 
+// SetLessonAccess sets the visibility rules applied to lesson requests.
+// Lessons that are not visible are reported as not found, so their existence
+// is not revealed.
+//
+// Parameters:
+//   - access: An implementation of the LessonAccess interface, or nil to allow everything
+func (h *LessonHandler) SetLessonAccess(access LessonAccess) {
+	h.access = access
+}
+
+// SetAuditor sets the audit log that lesson changes are recorded in.
+//
+// Parameters:
+//   - auditor: An implementation of the Auditor interface, or nil to disable auditing
+func (h *LessonHandler) SetAuditor(auditor Auditor) {
+	h.audit = auditor
+}
+
+// SetTokenValidator makes the lesson routes authenticate requests, so the visibility
+// rules know which user is asking. Reading lessons stays possible without a token,
+// while creating, updating and deleting them requires one. API keys need the
+// lessons:read or lessons:write scope. It must be called before RegisterRoutes.
+//
+// Parameters:
+//   - tokens: An implementation of the auth.TokenValidator interface, or nil to leave requests anonymous
+func (h *LessonHandler) SetTokenValidator(tokens auth.TokenValidator) {
+	h.tokens = tokens
+}
+
+// authenticate wraps a route with the configured token validation and the API key
+// scope it needs. Routes that do not require a token accept anonymous requests,
+// which only see public lessons.
+func (h *LessonHandler) authenticate(required bool, scope auth.Scope) func(http.HandlerFunc) http.Handler {
+	return func(f http.HandlerFunc) http.Handler {
+		switch {
+		case h.tokens == nil:
+			return f
+		case required:
+			return auth.AuthMiddleware(h.tokens)(auth.ScopeMiddleware(scope)(f))
+		default:
+			return auth.OptionalAuthMiddleware(h.tokens)(auth.ScopeMiddleware(scope)(f))
+		}
+	}
+}
+
+// record adds an entry to the audit log if an auditor is configured.
+func (h *LessonHandler) record(r *http.Request, action, id string, before, after *lesson.Lesson) {
+	if h.audit == nil {
+		return
+	}
+	// Avoid passing typed nil pointers, which would be hashed as "null"
+	var b, a interface{}
+	if before != nil {
+		b = before
+	}
+	if after != nil {
+		a = after
+	}
+	h.audit.Record(r, action, "lesson", id, b, a)
+}
+
+// canView checks the configured visibility rules for reading a lesson.
+func (h *LessonHandler) canView(r *http.Request, l *lesson.Lesson) bool {
+	return h.access == nil || h.access.CanViewLesson(r, l)
+}
+
+// canEdit checks the configured visibility rules for changing a lesson.
+func (h *LessonHandler) canEdit(r *http.Request, l *lesson.Lesson) bool {
+	return h.access == nil || h.access.CanEditLesson(r, l)
+}
+
+// getVisibleLesson retrieves a lesson and writes a not found error if it does not
+// exist or is not visible to the request.
+func (h *LessonHandler) getVisibleLesson(w http.ResponseWriter, r *http.Request, id string) (*lesson.Lesson, bool) {
+	l, err := h.store.GetLesson(id)
+	if err != nil {
+		writeError(w, "NotFound", http.StatusNotFound, "Lesson not found", err)
+		return nil, false
+	}
+	if !h.canView(r, l) {
+		writeError(w, "NotFound", http.StatusNotFound, "Lesson not found", fmt.Errorf("lesson %s is not visible", id))
+		return nil, false
+	}
+	return l, true
+}
+
 // RegisterRoutes registers the lesson-related routes with the provided router.
 // It sets up the following endpoints:
 //   - GET /api/lessons: List all lessons
@@ -71,18 +178,21 @@ func NewLessonHandler(store LessonStore) *LessonHandler {
 // Parameters:
 //   - r: A mux.Router to register the routes with
 func (h *LessonHandler) RegisterRoutes(r *mux.Router) {
-	r.HandleFunc("/api/lessons", h.listLessons).Methods("GET")
-	r.HandleFunc("/api/lessons/{id}", h.getLesson).Methods("GET")
-	r.HandleFunc("/api/lessons", h.createLesson).Methods("POST")
-	r.HandleFunc("/api/lessons/{id}", h.updateLesson).Methods("PUT")
-	r.HandleFunc("/api/lessons/{id}", h.deleteLesson).Methods("DELETE")
-	r.HandleFunc("/api/lessons/{id}/start", h.startLesson).Methods("POST")
-	r.HandleFunc("/api/lessons/{id}/steps/{step}/complete", h.completeStep).Methods("POST")
-	r.HandleFunc("/api/lessons/{id}/validate", h.validateStep).Methods("POST")
+	read := h.authenticate(false, auth.ScopeLessonsRead)
+	write := h.authenticate(true, auth.ScopeLessonsWrite)
+
+	r.Handle("/api/lessons", read(h.listLessons)).Methods("GET")
+	r.Handle("/api/lessons/{id}", read(h.getLesson)).Methods("GET")
+	r.Handle("/api/lessons", write(h.createLesson)).Methods("POST")
+	r.Handle("/api/lessons/{id}", write(h.updateLesson)).Methods("PUT")
+	r.Handle("/api/lessons/{id}", write(h.deleteLesson)).Methods("DELETE")
+	r.Handle("/api/lessons/{id}/start", read(h.startLesson)).Methods("POST")
+	r.Handle("/api/lessons/{id}/steps/{step}/complete", read(h.completeStep)).Methods("POST")
+	r.Handle("/api/lessons/{id}/validate", read(h.validateStep)).Methods("POST")
 
 	// New endpoints for lesson editor
-	r.HandleFunc("/api/lessons/parse", h.parseMarkdown).Methods("POST")
-	r.HandleFunc("/api/lessons/validate", h.validateLesson).Methods("POST")
+	r.Handle("/api/lessons/parse", read(h.parseMarkdown)).Methods("POST")
+	r.Handle("/api/lessons/validate", read(h.validateLesson)).Methods("POST")
 }
 
 func (h *LessonHandler) listLessons(w http.ResponseWriter, r *http.Request) {
@@ -91,6 +201,15 @@ func (h *LessonHandler) listLessons(w http.ResponseWriter, r *http.Request) {
 		writeError(w, "DatabaseError", http.StatusInternalServerError, "Failed to retrieve lessons", err)
 		return
 	}
+	if h.access != nil {
+		visible := make([]lesson.Lesson, 0, len(lessons))
+		for i := range lessons {
+			if h.access.CanViewLesson(r, &lessons[i]) {
+				visible = append(visible, lessons[i])
+			}
+		}
+		lessons = visible
+	}
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(lessons)
 }
@@ -103,9 +222,8 @@ func (h *LessonHandler) getLesson(w http.ResponseWriter, r *http.Request) {
 	}
 	id := vars["id"]
 
-	lesson, err := h.store.GetLesson(id)
-	if err != nil {
-		writeError(w, "NotFound", http.StatusNotFound, "Lesson not found", err)
+	lesson, ok := h.getVisibleLesson(w, r, id)
+	if !ok {
 		return
 	}
 	w.Header().Set("Content-Type", "application/json")
@@ -124,10 +242,16 @@ func (h *LessonHandler) createLesson(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if !h.canEdit(r, &lesson) {
+		writeError(w, "Forbidden", http.StatusForbidden, "Not allowed to create lessons for this organisation", fmt.Errorf("organisation %s", lesson.OrgID))
+		return
+	}
+
 	if err := h.store.CreateLesson(&lesson); err != nil {
 		writeError(w, "DatabaseError", http.StatusInternalServerError, "Failed to create lesson", err)
 		return
 	}
+	h.record(r, "lesson.create", lesson.ID, nil, &lesson)
 
 	w.WriteHeader(http.StatusCreated)
 	json.NewEncoder(w).Encode(lesson)
@@ -206,6 +330,29 @@ func validateLesson(l *lesson.Lesson) error {
 		if step.Timeout < 0 || step.Timeout > time.Hour {
 			return fmt.Errorf("step %d timeout must be between 0 and 1 hour", i+1)
 		}
+		if step.Setup != nil {
+			if err := step.Setup.Validate(); err != nil {
+				return fmt.Errorf("step %d setup: %v", i+1, err)
+			}
+		}
+	}
+	names := map[string]bool{}
+	for i, e := range l.Endpoints {
+		if !router.ValidAliasName(e.Name) {
+			return fmt.Errorf("endpoint %d must have a name of lowercase letters, digits and hyphens", i+1)
+		}
+		if names[e.Name] {
+			return fmt.Errorf("endpoint %d name %s is used twice", i+1, e.Name)
+		}
+		names[e.Name] = true
+		if e.Port < 1 || e.Port > 65535 {
+			return fmt.Errorf("endpoint %d port must be between 1 and 65535", i+1)
+		}
+	}
+	if l.NetworkPolicy != nil {
+		if err := l.NetworkPolicy.Validate(); err != nil {
+			return fmt.Errorf("network policy: %v", err)
+		}
 	}
 	return nil
 }
@@ -316,6 +463,7 @@ func (h *LessonHandler) updateLesson(w http.ResponseWriter, r *http.Request) {
 	}
 	id := vars["id"]
 
+	var existing *lesson.Lesson
 	var lesson lesson.Lesson
 	if err := json.NewDecoder(r.Body).Decode(&lesson); err != nil {
 		writeError(w, "InvalidRequest", http.StatusBadRequest, "Invalid lesson format", err)
@@ -327,10 +475,32 @@ func (h *LessonHandler) updateLesson(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if h.access != nil {
+		var ok bool
+		existing, ok = h.getVisibleLesson(w, r, id)
+		if !ok {
+			return
+		}
+		// Both the current and the new organisation must allow the change
+		if !h.access.CanEditLesson(r, existing) || !h.access.CanEditLesson(r, &lesson) {
+			writeError(w, "Forbidden", http.StatusForbidden, "Not allowed to change this lesson", fmt.Errorf("lesson %s", id))
+			return
+		}
+	} else if h.audit != nil {
+		// The previous version is only needed for its hash
+		existing, _ = h.store.GetLesson(id)
+	}
+	if existing != nil {
+		// Stores may hand out the stored lesson itself, so keep a copy of the old version
+		previous := *existing
+		existing = &previous
+	}
+
 	if err := h.store.UpdateLesson(id, &lesson); err != nil {
 		writeError(w, "DatabaseError", http.StatusInternalServerError, "Failed to update lesson", err)
 		return
 	}
+	h.record(r, "lesson.update", id, existing, &lesson)
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(lesson)
@@ -345,9 +515,12 @@ func (h *LessonHandler) deleteLesson(w http.ResponseWriter, r *http.Request) {
 	id := vars["id"]
 
 	// Check if lesson exists before deleting
-	_, err := h.store.GetLesson(id)
-	if err != nil {
-		writeError(w, "NotFound", http.StatusNotFound, "Lesson not found", err)
+	existing, ok := h.getVisibleLesson(w, r, id)
+	if !ok {
+		return
+	}
+	if !h.canEdit(r, existing) {
+		writeError(w, "Forbidden", http.StatusForbidden, "Not allowed to delete this lesson", fmt.Errorf("lesson %s", id))
 		return
 	}
 
@@ -355,6 +528,7 @@ func (h *LessonHandler) deleteLesson(w http.ResponseWriter, r *http.Request) {
 		writeError(w, "DatabaseError", http.StatusInternalServerError, "Failed to delete lesson", err)
 		return
 	}
+	h.record(r, "lesson.delete", id, existing, nil)
 
 	w.WriteHeader(http.StatusNoContent)
 }
@@ -367,9 +541,8 @@ func (h *LessonHandler) startLesson(w http.ResponseWriter, r *http.Request) {
 	}
 	id := vars["id"]
 
-	lesson, err := h.store.GetLesson(id)
-	if err != nil {
-		writeError(w, "NotFound", http.StatusNotFound, "Lesson not found", err)
+	lesson, ok := h.getVisibleLesson(w, r, id)
+	if !ok {
 		return
 	}
 
@@ -396,9 +569,8 @@ func (h *LessonHandler) completeStep(w http.ResponseWriter, r *http.Request) {
 	stepStr := vars["step"]
 
 	// Get the lesson
-	lesson, err := h.store.GetLesson(id)
-	if err != nil {
-		writeError(w, "NotFound", http.StatusNotFound, "Lesson not found", err)
+	lesson, ok := h.getVisibleLesson(w, r, id)
+	if !ok {
 		return
 	}
 
@@ -472,9 +644,8 @@ func (h *LessonHandler) validateStep(w http.ResponseWriter, r *http.Request) {
 	id := vars["id"]
 	stepIndex := vars["step"]
 
-	lesson, err := h.store.GetLesson(id)
-	if err != nil {
-		writeError(w, "NotFound", http.StatusNotFound, "Lesson not found", err)
+	lesson, ok := h.getVisibleLesson(w, r, id)
+	if !ok {
 		return
 	}
 
@@ -4,7 +4,11 @@ import (
 	"bytes"
 	"encoding/json"
 	"errors"
+	"github.com/gorilla/mux"
+	"github.com/ringo380/lessoncraft/api/auth"
+	"github.com/ringo380/lessoncraft/api/org"
 	"github.com/ringo380/lessoncraft/lesson"
+	"github.com/ringo380/lessoncraft/netpolicy"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 	"net/http"
@@ -276,6 +280,46 @@ func TestCreateLessonInvalidLesson(t *testing.T) {
 	assert.Equal(t, http.StatusBadRequest, rr.Code)
 }
 
+func TestCreateLessonInvalidSetup(t *testing.T) {
+	mockStore := new(MockLessonStore)
+	handler := NewLessonHandler(mockStore)
+
+	invalidLesson := createTestLesson()
+	invalidLesson.Steps[0].Setup = &lesson.StepSetup{
+		Ready: []lesson.ReadinessProbe{{Type: lesson.ProbePort, Port: 0}},
+	}
+	body, err := json.Marshal(invalidLesson)
+	assert.NoError(t, err)
+
+	req, err := http.NewRequest("POST", "/api/lessons", bytes.NewBuffer(body))
+	assert.NoError(t, err)
+	rr := httptest.NewRecorder()
+	handler.createLesson(rr, req)
+
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.Contains(t, rr.Body.String(), "step 1 setup: probe 1 port must be between 1 and 65535")
+	mockStore.AssertNotCalled(t, "CreateLesson", mock.Anything)
+}
+
+func TestCreateLessonInvalidNetworkPolicy(t *testing.T) {
+	mockStore := new(MockLessonStore)
+	handler := NewLessonHandler(mockStore)
+
+	invalidLesson := createTestLesson()
+	invalidLesson.NetworkPolicy = &netpolicy.Policy{Deny: []netpolicy.Rule{{CIDR: "somewhere"}}}
+	body, err := json.Marshal(invalidLesson)
+	assert.NoError(t, err)
+
+	req, err := http.NewRequest("POST", "/api/lessons", bytes.NewBuffer(body))
+	assert.NoError(t, err)
+	rr := httptest.NewRecorder()
+	handler.createLesson(rr, req)
+
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.Contains(t, rr.Body.String(), "network policy: deny rule 1: invalid cidr")
+	mockStore.AssertNotCalled(t, "CreateLesson", mock.Anything)
+}
+
 // Test updateLesson handler
 func TestUpdateLesson(t *testing.T) {
 	// Create a mock store
@@ -475,3 +519,216 @@ func TestValidateStep(t *testing.T) {
 	// Verify that expectations were met
 	mockStore.AssertExpectations(t)
 }
+
+// orgOnlyAccess hides lessons of other organisations than the one in the X-Org header
+type orgOnlyAccess struct{}
+
+func (orgOnlyAccess) CanViewLesson(r *http.Request, l *lesson.Lesson) bool {
+	return l.OrgID == "" || l.OrgID == r.Header.Get("X-Org")
+}
+
+func (orgOnlyAccess) CanEditLesson(r *http.Request, l *lesson.Lesson) bool {
+	return l.OrgID == r.Header.Get("X-Org")
+}
+
+// Test that lesson access rules filter lists and hide lessons of other organisations
+func TestLessonAccess(t *testing.T) {
+	// Create a mock store
+	mockStore := new(MockLessonStore)
+
+	public := createTestLesson()
+	private := createTestLesson()
+	private.ID = "private-id"
+	private.OrgID = "acme"
+
+	// Set up expectations
+	mockStore.On("ListLessons").Return([]lesson.Lesson{public, private}, nil)
+	mockStore.On("GetLesson", "private-id").Return(&private, nil)
+
+	// Create handler with mock store and access rules
+	handler := NewLessonHandler(mockStore)
+	handler.SetLessonAccess(orgOnlyAccess{})
+
+	// Lessons of other organisations are not listed
+	req, err := http.NewRequest("GET", "/api/lessons", nil)
+	assert.NoError(t, err)
+	rr := httptest.NewRecorder()
+	handler.listLessons(rr, req)
+
+	var responseBody []lesson.Lesson
+	err = json.Unmarshal(rr.Body.Bytes(), &responseBody)
+	assert.NoError(t, err)
+	assert.Len(t, responseBody, 1)
+	assert.Equal(t, public.ID, responseBody[0].ID)
+
+	// Members of the organisation see them
+	req.Header.Set("X-Org", "acme")
+	rr = httptest.NewRecorder()
+	handler.listLessons(rr, req)
+	err = json.Unmarshal(rr.Body.Bytes(), &responseBody)
+	assert.NoError(t, err)
+	assert.Len(t, responseBody, 2)
+
+	// Hidden lessons are reported as not found
+	req, err = http.NewRequest("GET", "/api/lessons/private-id", nil)
+	assert.NoError(t, err)
+	req = SetURLVars(req, map[string]string{"id": "private-id"})
+	rr = httptest.NewRecorder()
+	handler.getLesson(rr, req)
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+
+	// Lessons can only be deleted by the organisation
+	req, err = http.NewRequest("DELETE", "/api/lessons/private-id", nil)
+	assert.NoError(t, err)
+	req = SetURLVars(req, map[string]string{"id": "private-id"})
+	req.Header.Set("X-Org", "other")
+	rr = httptest.NewRecorder()
+	handler.deleteLesson(rr, req)
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+
+	// Verify that expectations were met
+	mockStore.AssertExpectations(t)
+}
+
+// Test that the lesson routes authenticate requests, so organisation members see
+// their lessons while anonymous requests only see public ones
+func TestLessonRoutesAuthentication(t *testing.T) {
+	// Create a mock store
+	mockStore := new(MockLessonStore)
+
+	private := createTestLesson()
+	private.ID = "private-id"
+	private.OrgID = "acme"
+	mockStore.On("GetLesson", "private-id").Return(&private, nil)
+
+	orgs := org.NewMemoryStore()
+	assert.NoError(t, orgs.CreateOrganisation(&org.Organisation{ID: "acme", Name: "Acme"}))
+	assert.NoError(t, orgs.SetMembership(&org.Membership{OrgID: "acme", UserID: "member", Role: org.RoleStudent}))
+	jwtService := auth.NewJWTService("secret", "lessoncraft", time.Hour)
+	token, _, err := jwtService.GenerateToken("member", "member@example.com", []auth.Role{auth.RoleLearner})
+	assert.NoError(t, err)
+
+	// Create handler with organisation rules and token validation
+	handler := NewLessonHandler(mockStore)
+	handler.SetLessonAccess(org.NewPolicy(orgs))
+	handler.SetTokenValidator(jwtService)
+	router := mux.NewRouter()
+	handler.RegisterRoutes(router)
+
+	request := func(method, path, token string) int {
+		req, err := http.NewRequest(method, path, nil)
+		assert.NoError(t, err)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr.Code
+	}
+
+	// Anonymous requests are accepted, but do not see lessons of organisations
+	assert.Equal(t, http.StatusNotFound, request("GET", "/api/lessons/private-id", ""))
+	assert.Equal(t, http.StatusOK, request("GET", "/api/lessons/private-id", token))
+
+	// Changing lessons requires a token
+	assert.Equal(t, http.StatusUnauthorized, request("DELETE", "/api/lessons/private-id", ""))
+	assert.Equal(t, http.StatusUnauthorized, request("GET", "/api/lessons/private-id", "invalid"))
+	mockStore.AssertNotCalled(t, "DeleteLesson", "private-id")
+}
+
+// Test that API keys can only use the lesson routes within their scopes
+func TestLessonRoutesAPIKeyScopes(t *testing.T) {
+	// Create a mock store
+	mockStore := new(MockLessonStore)
+	public := createTestLesson()
+	mockStore.On("GetLesson", "test-id").Return(&public, nil)
+	mockStore.On("DeleteLesson", "test-id").Return(nil)
+
+	users := auth.NewMemoryUserStore()
+	account := &auth.UserWithAuth{ServiceAccount: true, AccountStatus: "active", Roles: []auth.Role{auth.RoleEducator}}
+	account.Id = "ci"
+	assert.NoError(t, users.CreateUser(account))
+	apiKeys := auth.NewAPIKeyService(auth.NewMemoryAPIKeyStore(), users, auth.NewJWTService("secret", "lessoncraft", time.Hour))
+	readKey, _, err := apiKeys.Generate(account, "read", []auth.Scope{auth.ScopeLessonsRead}, time.Time{}, "admin")
+	assert.NoError(t, err)
+	writeKey, _, err := apiKeys.Generate(account, "write", []auth.Scope{auth.ScopeLessonsWrite}, time.Time{}, "admin")
+	assert.NoError(t, err)
+
+	handler := NewLessonHandler(mockStore)
+	handler.SetTokenValidator(apiKeys)
+	router := mux.NewRouter()
+	handler.RegisterRoutes(router)
+
+	request := func(method, key string) int {
+		req, err := http.NewRequest(method, "/api/lessons/test-id", nil)
+		assert.NoError(t, err)
+		req.Header.Set("Authorization", "Bearer "+key)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr.Code
+	}
+
+	assert.Equal(t, http.StatusOK, request("GET", readKey))
+	assert.Equal(t, http.StatusForbidden, request("GET", writeKey))
+	assert.Equal(t, http.StatusForbidden, request("DELETE", readKey))
+	mockStore.AssertNotCalled(t, "DeleteLesson", "test-id")
+	assert.Equal(t, http.StatusNoContent, request("DELETE", writeKey))
+}
+
+// recordingAuditor collects the actions recorded by the handler
+type recordingAuditor struct {
+	actions []string
+	before  []interface{}
+	after   []interface{}
+}
+
+func (a *recordingAuditor) Record(r *http.Request, action, targetType, targetID string, before, after interface{}) {
+	a.actions = append(a.actions, action+" "+targetType+" "+targetID)
+	a.before = append(a.before, before)
+	a.after = append(a.after, after)
+}
+
+// TestLessonAudit tests that lesson changes are recorded in the audit log
+func TestLessonAudit(t *testing.T) {
+	// Create a mock store
+	mockStore := new(MockLessonStore)
+
+	existing := createTestLesson()
+	updated := createTestLesson()
+	updated.Title = "Updated Title"
+
+	// Set up expectations
+	mockStore.On("GetLesson", "test-id").Return(&existing, nil)
+	mockStore.On("UpdateLesson", "test-id", mock.AnythingOfType("*lesson.Lesson")).Return(nil)
+	mockStore.On("DeleteLesson", "test-id").Return(nil)
+
+	// Create handler with mock store and auditor
+	handler := NewLessonHandler(mockStore)
+	auditor := &recordingAuditor{}
+	handler.SetAuditor(auditor)
+
+	body, err := json.Marshal(updated)
+	assert.NoError(t, err)
+	req, err := http.NewRequest("PUT", "/api/lessons/test-id", bytes.NewBuffer(body))
+	assert.NoError(t, err)
+	req = SetURLVars(req, map[string]string{"id": "test-id"})
+	rr := httptest.NewRecorder()
+	handler.updateLesson(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	req, err = http.NewRequest("DELETE", "/api/lessons/test-id", nil)
+	assert.NoError(t, err)
+	req = SetURLVars(req, map[string]string{"id": "test-id"})
+	rr = httptest.NewRecorder()
+	handler.deleteLesson(rr, req)
+	assert.Equal(t, http.StatusNoContent, rr.Code)
+
+	// The previous version is recorded with the update, nothing remains after a delete
+	assert.Equal(t, []string{"lesson.update lesson test-id", "lesson.delete lesson test-id"}, auditor.actions)
+	assert.Equal(t, existing.Title, auditor.before[0].(*lesson.Lesson).Title)
+	assert.Equal(t, updated.Title, auditor.after[0].(*lesson.Lesson).Title)
+	assert.Nil(t, auditor.after[1])
+
+	// Verify that expectations were met
+	mockStore.AssertExpectations(t)
+}
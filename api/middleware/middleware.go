@@ -5,6 +5,7 @@ import (
 	"log"
 	"net/http"
 	"runtime"
+	"strconv"
 	"time"
 
 	"github.com/ringo380/lessoncraft/api/metrics"
@@ -29,6 +30,28 @@ type ErrorResponse struct {
 	TimeStamp time.Time   `json:"timestamp"`
 }
 
+// WriteJSON writes v as a JSON response with the given status code
+func WriteJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
+
+// WriteError writes a standardized error response. The error, if any, is sent
+// as the details.
+func WriteError(w http.ResponseWriter, errType string, code int, message string, err error) {
+	resp := ErrorResponse{
+		Error:     errType,
+		Code:      code,
+		Message:   message,
+		TimeStamp: time.Now(),
+	}
+	if err != nil {
+		resp.Details = err.Error()
+	}
+	WriteJSON(w, code, resp)
+}
+
 // Logger is the global logger for the application
 var Logger = logrus.New()
 
@@ -67,6 +90,10 @@ func ErrorHandler(next http.Handler) http.Handler {
 	})
 }
 
+// RateLimitMiddleware limits all requests together to 10 per second.
+//
+// Deprecated: one noisy client throttles everyone. Use ratelimit.Limiter, which
+// keeps a counter per user, API key or IP with per-route policies.
 func RateLimitMiddleware(next http.Handler) http.Handler {
 	limiter := rate.NewLimiter(rate.Every(time.Second), 10) // 10 requests per second
 
@@ -140,7 +167,7 @@ func LoggingMiddleware(next http.Handler) http.Handler {
 		metrics.RequestDuration.WithLabelValues(
 			r.URL.Path,
 			r.Method,
-			string(rw.status),
+			strconv.Itoa(rw.status),
 		).Observe(duration.Seconds())
 
 		Logger.WithFields(logrus.Fields{
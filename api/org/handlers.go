@@ -0,0 +1,769 @@
+package org
+
+import (
+	"crypto/rand"
+	"encoding/base32"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/ringo380/lessoncraft/api/auth"
+	"github.com/ringo380/lessoncraft/api/middleware"
+	"github.com/ringo380/lessoncraft/lesson"
+)
+
+// LessonGetter is the part of the lesson store needed to validate assignments
+type LessonGetter interface {
+	// GetLesson retrieves a lesson by its ID
+	GetLesson(id string) (*lesson.Lesson, error)
+}
+
+// Handler handles HTTP requests related to organisations, classrooms, invites,
+// enrollments and assignments. All routes require authentication.
+type Handler struct {
+	store   Store
+	policy  *Policy
+	users   auth.UserStore
+	lessons LessonGetter
+	tokens  auth.TokenValidator
+	auditor auth.Auditor // Optional audit log, nil disables auditing
+}
+
+// NewHandler creates a new Handler
+func NewHandler(store Store, users auth.UserStore, lessons LessonGetter, tokens auth.TokenValidator) *Handler {
+	return &Handler{
+		store:   store,
+		policy:  NewPolicy(store),
+		users:   users,
+		lessons: lessons,
+		tokens:  tokens,
+	}
+}
+
+// Policy returns the visibility policy used by the handler, so lesson and session
+// handlers can apply the same rules
+func (h *Handler) Policy() *Policy {
+	return h.policy
+}
+
+// SetAuditor sets the audit log that changes to organisations, their members and
+// classrooms are recorded in
+func (h *Handler) SetAuditor(auditor auth.Auditor) {
+	h.auditor = auditor
+}
+
+// audit records an action if an auditor is configured
+func (h *Handler) audit(r *http.Request, action, targetType, targetID string, before, after interface{}) {
+	if h.auditor != nil {
+		h.auditor.Record(r, action, targetType, targetID, before, after)
+	}
+}
+
+// RegisterRoutes registers the organisation routes with the provided router. API
+// keys need the orgs:read scope to read and the orgs:write scope to make changes.
+func (h *Handler) RegisterRoutes(r *mux.Router) {
+	authMiddleware := auth.AuthMiddleware(h.tokens)
+	handle := func(path string, f http.HandlerFunc, method string) {
+		scope := auth.ScopeOrgsWrite
+		if method == "GET" {
+			scope = auth.ScopeOrgsRead
+		}
+		r.Handle(path, authMiddleware(auth.ScopeMiddleware(scope)(f))).Methods(method)
+	}
+
+	handle("/api/orgs", h.createOrganisation, "POST")
+	handle("/api/orgs", h.listOrganisations, "GET")
+	handle("/api/orgs/{orgId}", h.getOrganisation, "GET")
+	handle("/api/orgs/{orgId}", h.deleteOrganisation, "DELETE")
+
+	handle("/api/orgs/{orgId}/members", h.listMembers, "GET")
+	handle("/api/orgs/{orgId}/members/{userId}", h.setMember, "PUT")
+	handle("/api/orgs/{orgId}/members/{userId}", h.removeMember, "DELETE")
+
+	handle("/api/orgs/{orgId}/invites", h.createInvite, "POST")
+	handle("/api/orgs/{orgId}/invites", h.listInvites, "GET")
+	handle("/api/orgs/{orgId}/invites/{code}", h.deleteInvite, "DELETE")
+	handle("/api/invites/{code}/accept", h.acceptInvite, "POST")
+
+	handle("/api/orgs/{orgId}/classrooms", h.createClassroom, "POST")
+	handle("/api/orgs/{orgId}/classrooms", h.listClassrooms, "GET")
+	handle("/api/classrooms/{classroomId}", h.getClassroom, "GET")
+	handle("/api/classrooms/{classroomId}", h.deleteClassroom, "DELETE")
+
+	handle("/api/classrooms/{classroomId}/enrollments", h.enroll, "POST")
+	handle("/api/classrooms/{classroomId}/enrollments", h.listEnrollments, "GET")
+	handle("/api/classrooms/{classroomId}/enrollments/{userId}", h.unenroll, "DELETE")
+
+	handle("/api/classrooms/{classroomId}/assignments", h.createAssignment, "POST")
+	handle("/api/classrooms/{classroomId}/assignments", h.listAssignments, "GET")
+	handle("/api/classrooms/{classroomId}/assignments/{assignmentId}", h.deleteAssignment, "DELETE")
+
+	handle("/api/me/assignments", h.myAssignments, "GET")
+}
+
+// CreateOrganisationRequest represents a request to create an organisation
+type CreateOrganisationRequest struct {
+	Name string `json:"name"`
+}
+
+// createOrganisation creates an organisation. Only educators and admins can create
+// organisations; the creator becomes its first org admin.
+func (h *Handler) createOrganisation(w http.ResponseWriter, r *http.Request) {
+	userID, _ := auth.GetUserID(r)
+	if !auth.IsEducator(r) && !auth.IsAdmin(r) {
+		middleware.WriteError(w, "Forbidden", http.StatusForbidden, "Only educators can create organisations", nil)
+		return
+	}
+
+	var req CreateOrganisationRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		middleware.WriteError(w, "InvalidRequest", http.StatusBadRequest, "Invalid request format", err)
+		return
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" {
+		middleware.WriteError(w, "ValidationError", http.StatusBadRequest, "Organisation name is required", nil)
+		return
+	}
+
+	now := time.Now()
+	o := &Organisation{ID: uuid.New().String(), Name: req.Name, CreatedBy: userID, CreatedAt: now}
+	if err := h.store.CreateOrganisation(o); err != nil {
+		middleware.WriteError(w, "DatabaseError", http.StatusInternalServerError, "Failed to create organisation", err)
+		return
+	}
+	if err := h.store.SetMembership(&Membership{OrgID: o.ID, UserID: userID, Role: RoleOrgAdmin, JoinedAt: now}); err != nil {
+		middleware.WriteError(w, "DatabaseError", http.StatusInternalServerError, "Failed to add organisation admin", err)
+		return
+	}
+
+	h.audit(r, "org.create", "organisation", o.ID, nil, o)
+	middleware.WriteJSON(w, http.StatusCreated, o)
+}
+
+func (h *Handler) listOrganisations(w http.ResponseWriter, r *http.Request) {
+	userID, _ := auth.GetUserID(r)
+	orgs, err := h.store.ListOrganisationsForUser(userID)
+	if err != nil {
+		middleware.WriteError(w, "DatabaseError", http.StatusInternalServerError, "Failed to retrieve organisations", err)
+		return
+	}
+	middleware.WriteJSON(w, http.StatusOK, orgs)
+}
+
+func (h *Handler) getOrganisation(w http.ResponseWriter, r *http.Request) {
+	o, ok := h.organisation(w, r, RoleStudent)
+	if !ok {
+		return
+	}
+	middleware.WriteJSON(w, http.StatusOK, o)
+}
+
+func (h *Handler) deleteOrganisation(w http.ResponseWriter, r *http.Request) {
+	o, ok := h.organisation(w, r, RoleOrgAdmin)
+	if !ok {
+		return
+	}
+	if err := h.store.DeleteOrganisation(o.ID); err != nil {
+		middleware.WriteError(w, "DatabaseError", http.StatusInternalServerError, "Failed to delete organisation", err)
+		return
+	}
+	h.audit(r, "org.delete", "organisation", o.ID, o, nil)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Handler) listMembers(w http.ResponseWriter, r *http.Request) {
+	o, ok := h.organisation(w, r, RoleInstructor)
+	if !ok {
+		return
+	}
+	members, err := h.store.ListMembers(o.ID)
+	if err != nil {
+		middleware.WriteError(w, "DatabaseError", http.StatusInternalServerError, "Failed to retrieve members", err)
+		return
+	}
+	middleware.WriteJSON(w, http.StatusOK, members)
+}
+
+// SetMemberRequest represents a request to add a member or change a member's role
+type SetMemberRequest struct {
+	Role Role `json:"role"`
+}
+
+func (h *Handler) setMember(w http.ResponseWriter, r *http.Request) {
+	o, ok := h.organisation(w, r, RoleOrgAdmin)
+	if !ok {
+		return
+	}
+	memberID := mux.Vars(r)["userId"]
+
+	var req SetMemberRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		middleware.WriteError(w, "InvalidRequest", http.StatusBadRequest, "Invalid request format", err)
+		return
+	}
+	if !req.Role.Valid() {
+		middleware.WriteError(w, "ValidationError", http.StatusBadRequest, "Invalid organisation role", nil)
+		return
+	}
+	if _, err := h.users.GetUserByID(memberID); err != nil {
+		middleware.WriteError(w, "NotFound", http.StatusNotFound, "User not found", err)
+		return
+	}
+
+	var before interface{}
+	m, err := h.store.GetMembership(o.ID, memberID)
+	if err != nil {
+		m = &Membership{OrgID: o.ID, UserID: memberID, JoinedAt: time.Now()}
+	} else if m.Role == RoleOrgAdmin && req.Role != RoleOrgAdmin && h.lastAdmin(o.ID) {
+		middleware.WriteError(w, "ValidationError", http.StatusBadRequest, "An organisation needs at least one org admin", nil)
+		return
+	} else {
+		before = *m
+	}
+	m.Role = req.Role
+
+	if err := h.store.SetMembership(m); err != nil {
+		middleware.WriteError(w, "DatabaseError", http.StatusInternalServerError, "Failed to update member", err)
+		return
+	}
+	h.audit(r, "org.member.set", "organisation", o.ID, before, m)
+	middleware.WriteJSON(w, http.StatusOK, m)
+}
+
+func (h *Handler) removeMember(w http.ResponseWriter, r *http.Request) {
+	memberID := mux.Vars(r)["userId"]
+	userID, _ := auth.GetUserID(r)
+
+	// Members can always leave an organisation themselves
+	required := RoleOrgAdmin
+	if memberID == userID {
+		required = RoleStudent
+	}
+	o, ok := h.organisation(w, r, required)
+	if !ok {
+		return
+	}
+
+	m, err := h.store.GetMembership(o.ID, memberID)
+	if err != nil {
+		middleware.WriteError(w, "NotFound", http.StatusNotFound, "Member not found", err)
+		return
+	}
+	if m.Role == RoleOrgAdmin && h.lastAdmin(o.ID) {
+		middleware.WriteError(w, "ValidationError", http.StatusBadRequest, "An organisation needs at least one org admin", nil)
+		return
+	}
+
+	if err := h.store.RemoveMember(o.ID, memberID); err != nil {
+		middleware.WriteError(w, "DatabaseError", http.StatusInternalServerError, "Failed to remove member", err)
+		return
+	}
+	h.audit(r, "org.member.remove", "organisation", o.ID, m, nil)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// CreateInviteRequest represents a request to create an invite
+type CreateInviteRequest struct {
+	// Role is the role granted by the invite (default student)
+	Role Role `json:"role"`
+	// ClassroomID optionally enrolls users accepting the invite into a classroom
+	ClassroomID string `json:"classroom_id"`
+	// MaxUses limits how often the invite can be accepted (0 means unlimited)
+	MaxUses int `json:"max_uses"`
+	// ExpiresIn is how long the invite is valid, as a Go duration string (e.g. "72h")
+	ExpiresIn string `json:"expires_in"`
+}
+
+// createInvite creates an invite. Instructors can invite students; only org admins
+// can invite instructors and org admins.
+func (h *Handler) createInvite(w http.ResponseWriter, r *http.Request) {
+	o, ok := h.organisation(w, r, RoleInstructor)
+	if !ok {
+		return
+	}
+	userID, _ := auth.GetUserID(r)
+	roles, _ := auth.GetUserRoles(r)
+
+	var req CreateInviteRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		middleware.WriteError(w, "InvalidRequest", http.StatusBadRequest, "Invalid request format", err)
+		return
+	}
+	if req.Role == "" {
+		req.Role = RoleStudent
+	}
+	if !req.Role.Valid() || req.MaxUses < 0 {
+		middleware.WriteError(w, "ValidationError", http.StatusBadRequest, "Invalid invite", nil)
+		return
+	}
+	if req.Role != RoleStudent && !h.policy.HasRole(o.ID, userID, roles, RoleOrgAdmin) {
+		middleware.WriteError(w, "Forbidden", http.StatusForbidden, "Only org admins can invite staff", nil)
+		return
+	}
+	if req.ClassroomID != "" {
+		if c, err := h.store.GetClassroom(req.ClassroomID); err != nil || c.OrgID != o.ID {
+			middleware.WriteError(w, "ValidationError", http.StatusBadRequest, "Classroom does not belong to the organisation", err)
+			return
+		}
+	}
+
+	now := time.Now()
+	invite := &Invite{OrgID: o.ID, Role: req.Role, ClassroomID: req.ClassroomID, MaxUses: req.MaxUses, CreatedBy: userID, CreatedAt: now}
+	if req.ExpiresIn != "" {
+		d, err := time.ParseDuration(req.ExpiresIn)
+		if err != nil || d <= 0 {
+			middleware.WriteError(w, "ValidationError", http.StatusBadRequest, "Invalid expires_in duration", err)
+			return
+		}
+		invite.ExpiresAt = now.Add(d)
+	}
+	if err := h.createInviteWithCode(invite); err != nil {
+		middleware.WriteError(w, "DatabaseError", http.StatusInternalServerError, "Failed to create invite", err)
+		return
+	}
+
+	h.audit(r, "org.invite.create", "organisation", o.ID, nil, invite)
+	middleware.WriteJSON(w, http.StatusCreated, invite)
+}
+
+func (h *Handler) listInvites(w http.ResponseWriter, r *http.Request) {
+	o, ok := h.organisation(w, r, RoleInstructor)
+	if !ok {
+		return
+	}
+	invites, err := h.store.ListInvites(o.ID)
+	if err != nil {
+		middleware.WriteError(w, "DatabaseError", http.StatusInternalServerError, "Failed to retrieve invites", err)
+		return
+	}
+	middleware.WriteJSON(w, http.StatusOK, invites)
+}
+
+func (h *Handler) deleteInvite(w http.ResponseWriter, r *http.Request) {
+	o, ok := h.organisation(w, r, RoleInstructor)
+	if !ok {
+		return
+	}
+	invite, err := h.store.GetInvite(mux.Vars(r)["code"])
+	if err != nil || invite.OrgID != o.ID {
+		middleware.WriteError(w, "NotFound", http.StatusNotFound, "Invite not found", err)
+		return
+	}
+	if err := h.store.DeleteInvite(invite.Code); err != nil {
+		middleware.WriteError(w, "DatabaseError", http.StatusInternalServerError, "Failed to delete invite", err)
+		return
+	}
+	h.audit(r, "org.invite.delete", "organisation", o.ID, invite, nil)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// acceptInvite adds the authenticated user to the invite's organisation and enrolls
+// them into its classroom. Existing members keep their role if it is higher.
+func (h *Handler) acceptInvite(w http.ResponseWriter, r *http.Request) {
+	userID, _ := auth.GetUserID(r)
+
+	// The use is counted before joining, so concurrent accepts cannot go past MaxUses
+	now := time.Now()
+	invite, err := h.store.UseInvite(mux.Vars(r)["code"], now)
+	if err == ErrInviteUnusable {
+		middleware.WriteError(w, "Gone", http.StatusGone, "Invite has expired", nil)
+		return
+	} else if err != nil {
+		middleware.WriteError(w, "NotFound", http.StatusNotFound, "Invite not found", err)
+		return
+	}
+
+	m, err := h.store.GetMembership(invite.OrgID, userID)
+	if err != nil {
+		m = &Membership{OrgID: invite.OrgID, UserID: userID, Role: invite.Role, JoinedAt: now}
+	} else if !m.Role.AtLeast(invite.Role) {
+		m.Role = invite.Role
+	}
+	if err := h.store.SetMembership(m); err != nil {
+		middleware.WriteError(w, "DatabaseError", http.StatusInternalServerError, "Failed to join organisation", err)
+		return
+	}
+
+	if invite.ClassroomID != "" {
+		err := h.store.Enroll(&Enrollment{ClassroomID: invite.ClassroomID, UserID: userID, EnrolledAt: now})
+		if err != nil && err != ErrAlreadyExists {
+			middleware.WriteError(w, "DatabaseError", http.StatusInternalServerError, "Failed to enroll in classroom", err)
+			return
+		}
+	}
+
+	h.audit(r, "org.invite.accept", "organisation", invite.OrgID, nil, m)
+	middleware.WriteJSON(w, http.StatusOK, m)
+}
+
+// CreateClassroomRequest represents a request to create a classroom
+type CreateClassroomRequest struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+
+// createClassroom creates a classroom together with a student invite code for it
+func (h *Handler) createClassroom(w http.ResponseWriter, r *http.Request) {
+	o, ok := h.organisation(w, r, RoleInstructor)
+	if !ok {
+		return
+	}
+	userID, _ := auth.GetUserID(r)
+
+	var req CreateClassroomRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		middleware.WriteError(w, "InvalidRequest", http.StatusBadRequest, "Invalid request format", err)
+		return
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" {
+		middleware.WriteError(w, "ValidationError", http.StatusBadRequest, "Classroom name is required", nil)
+		return
+	}
+
+	now := time.Now()
+	c := &Classroom{ID: uuid.New().String(), OrgID: o.ID, Name: req.Name, Description: req.Description, CreatedBy: userID, CreatedAt: now}
+	if err := h.store.CreateClassroom(c); err != nil {
+		middleware.WriteError(w, "DatabaseError", http.StatusInternalServerError, "Failed to create classroom", err)
+		return
+	}
+
+	invite := &Invite{OrgID: o.ID, Role: RoleStudent, ClassroomID: c.ID, CreatedBy: userID, CreatedAt: now}
+	if err := h.createInviteWithCode(invite); err != nil {
+		middleware.WriteError(w, "DatabaseError", http.StatusInternalServerError, "Failed to create classroom invite", err)
+		return
+	}
+	c.InviteCode = invite.Code
+	if err := h.store.UpdateClassroom(c); err != nil {
+		middleware.WriteError(w, "DatabaseError", http.StatusInternalServerError, "Failed to update classroom", err)
+		return
+	}
+
+	h.audit(r, "classroom.create", "classroom", c.ID, nil, c)
+	middleware.WriteJSON(w, http.StatusCreated, c)
+}
+
+func (h *Handler) listClassrooms(w http.ResponseWriter, r *http.Request) {
+	o, ok := h.organisation(w, r, RoleStudent)
+	if !ok {
+		return
+	}
+	classrooms, err := h.store.ListClassrooms(o.ID)
+	if err != nil {
+		middleware.WriteError(w, "DatabaseError", http.StatusInternalServerError, "Failed to retrieve classrooms", err)
+		return
+	}
+
+	// Students only see the classrooms they are enrolled in and never the invite codes
+	if !h.canManage(r, o.ID) {
+		userID, _ := auth.GetUserID(r)
+		visible := []*Classroom{}
+		for _, c := range classrooms {
+			if h.enrolled(c.ID, userID) {
+				visible = append(visible, withoutInviteCode(c))
+			}
+		}
+		classrooms = visible
+	}
+	middleware.WriteJSON(w, http.StatusOK, classrooms)
+}
+
+func (h *Handler) getClassroom(w http.ResponseWriter, r *http.Request) {
+	c, manage, ok := h.classroom(w, r, false)
+	if !ok {
+		return
+	}
+	if !manage {
+		c = withoutInviteCode(c)
+	}
+	middleware.WriteJSON(w, http.StatusOK, c)
+}
+
+func (h *Handler) deleteClassroom(w http.ResponseWriter, r *http.Request) {
+	c, _, ok := h.classroom(w, r, true)
+	if !ok {
+		return
+	}
+	if err := h.store.DeleteClassroom(c.ID); err != nil {
+		middleware.WriteError(w, "DatabaseError", http.StatusInternalServerError, "Failed to delete classroom", err)
+		return
+	}
+	h.audit(r, "classroom.delete", "classroom", c.ID, c, nil)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// EnrollRequest represents a request to enroll a learner into a classroom
+type EnrollRequest struct {
+	UserID string `json:"user_id"`
+}
+
+// enroll adds a learner to a classroom. Learners that are not yet members of the
+// organisation are added as students.
+func (h *Handler) enroll(w http.ResponseWriter, r *http.Request) {
+	c, _, ok := h.classroom(w, r, true)
+	if !ok {
+		return
+	}
+
+	var req EnrollRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		middleware.WriteError(w, "InvalidRequest", http.StatusBadRequest, "Invalid request format", err)
+		return
+	}
+	if _, err := h.users.GetUserByID(req.UserID); err != nil {
+		middleware.WriteError(w, "NotFound", http.StatusNotFound, "User not found", err)
+		return
+	}
+
+	now := time.Now()
+	if _, err := h.store.GetMembership(c.OrgID, req.UserID); err != nil {
+		if err := h.store.SetMembership(&Membership{OrgID: c.OrgID, UserID: req.UserID, Role: RoleStudent, JoinedAt: now}); err != nil {
+			middleware.WriteError(w, "DatabaseError", http.StatusInternalServerError, "Failed to add member", err)
+			return
+		}
+	}
+
+	e := &Enrollment{ClassroomID: c.ID, UserID: req.UserID, EnrolledAt: now}
+	if err := h.store.Enroll(e); err != nil {
+		if err == ErrAlreadyExists {
+			middleware.WriteError(w, "Conflict", http.StatusConflict, "User is already enrolled", err)
+			return
+		}
+		middleware.WriteError(w, "DatabaseError", http.StatusInternalServerError, "Failed to enroll user", err)
+		return
+	}
+	h.audit(r, "classroom.enroll", "classroom", c.ID, nil, e)
+	middleware.WriteJSON(w, http.StatusCreated, e)
+}
+
+func (h *Handler) listEnrollments(w http.ResponseWriter, r *http.Request) {
+	c, _, ok := h.classroom(w, r, true)
+	if !ok {
+		return
+	}
+	enrollments, err := h.store.ListEnrollments(c.ID)
+	if err != nil {
+		middleware.WriteError(w, "DatabaseError", http.StatusInternalServerError, "Failed to retrieve enrollments", err)
+		return
+	}
+	middleware.WriteJSON(w, http.StatusOK, enrollments)
+}
+
+func (h *Handler) unenroll(w http.ResponseWriter, r *http.Request) {
+	c, _, ok := h.classroom(w, r, true)
+	if !ok {
+		return
+	}
+	userID := mux.Vars(r)["userId"]
+	if err := h.store.Unenroll(c.ID, userID); err != nil {
+		middleware.WriteError(w, "NotFound", http.StatusNotFound, "Enrollment not found", err)
+		return
+	}
+	h.audit(r, "classroom.unenroll", "classroom", c.ID, &Enrollment{ClassroomID: c.ID, UserID: userID}, nil)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// CreateAssignmentRequest represents a request to assign a lesson to a classroom
+type CreateAssignmentRequest struct {
+	LessonID string `json:"lesson_id"`
+	// DueAt is optional; zero means no due date
+	DueAt time.Time `json:"due_at"`
+}
+
+func (h *Handler) createAssignment(w http.ResponseWriter, r *http.Request) {
+	c, _, ok := h.classroom(w, r, true)
+	if !ok {
+		return
+	}
+	userID, _ := auth.GetUserID(r)
+
+	var req CreateAssignmentRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		middleware.WriteError(w, "InvalidRequest", http.StatusBadRequest, "Invalid request format", err)
+		return
+	}
+
+	// Only public lessons and lessons of the classroom's organisation can be assigned
+	l, err := h.lessons.GetLesson(req.LessonID)
+	if err != nil || (l.OrgID != "" && l.OrgID != c.OrgID) {
+		middleware.WriteError(w, "ValidationError", http.StatusBadRequest, "Lesson not found", err)
+		return
+	}
+	if !req.DueAt.IsZero() && req.DueAt.Before(time.Now()) {
+		middleware.WriteError(w, "ValidationError", http.StatusBadRequest, "Due date must be in the future", nil)
+		return
+	}
+
+	a := &Assignment{ID: uuid.New().String(), ClassroomID: c.ID, LessonID: l.ID, DueAt: req.DueAt, AssignedBy: userID, CreatedAt: time.Now()}
+	if err := h.store.CreateAssignment(a); err != nil {
+		middleware.WriteError(w, "DatabaseError", http.StatusInternalServerError, "Failed to create assignment", err)
+		return
+	}
+	h.audit(r, "classroom.assignment.create", "classroom", c.ID, nil, a)
+	middleware.WriteJSON(w, http.StatusCreated, a)
+}
+
+func (h *Handler) listAssignments(w http.ResponseWriter, r *http.Request) {
+	c, _, ok := h.classroom(w, r, false)
+	if !ok {
+		return
+	}
+	assignments, err := h.store.ListAssignments(c.ID)
+	if err != nil {
+		middleware.WriteError(w, "DatabaseError", http.StatusInternalServerError, "Failed to retrieve assignments", err)
+		return
+	}
+	middleware.WriteJSON(w, http.StatusOK, assignments)
+}
+
+func (h *Handler) deleteAssignment(w http.ResponseWriter, r *http.Request) {
+	c, _, ok := h.classroom(w, r, true)
+	if !ok {
+		return
+	}
+	a, err := h.store.GetAssignment(mux.Vars(r)["assignmentId"])
+	if err != nil || a.ClassroomID != c.ID {
+		middleware.WriteError(w, "NotFound", http.StatusNotFound, "Assignment not found", err)
+		return
+	}
+	if err := h.store.DeleteAssignment(a.ID); err != nil {
+		middleware.WriteError(w, "DatabaseError", http.StatusInternalServerError, "Failed to delete assignment", err)
+		return
+	}
+	h.audit(r, "classroom.assignment.delete", "classroom", c.ID, a, nil)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// myAssignments lists the assignments of every classroom the user is enrolled in,
+// ordered by due date
+func (h *Handler) myAssignments(w http.ResponseWriter, r *http.Request) {
+	userID, _ := auth.GetUserID(r)
+
+	enrollments, err := h.store.ListEnrollmentsForUser(userID)
+	if err != nil {
+		middleware.WriteError(w, "DatabaseError", http.StatusInternalServerError, "Failed to retrieve enrollments", err)
+		return
+	}
+
+	assignments := []*Assignment{}
+	for _, e := range enrollments {
+		as, err := h.store.ListAssignments(e.ClassroomID)
+		if err != nil {
+			middleware.WriteError(w, "DatabaseError", http.StatusInternalServerError, "Failed to retrieve assignments", err)
+			return
+		}
+		assignments = append(assignments, as...)
+	}
+	middleware.WriteJSON(w, http.StatusOK, assignments)
+}
+
+// organisation loads the organisation from the URL and checks that the user has at
+// least the given role in it. Non-members get a 404 so organisations cannot be probed.
+func (h *Handler) organisation(w http.ResponseWriter, r *http.Request, role Role) (*Organisation, bool) {
+	userID, _ := auth.GetUserID(r)
+	roles, _ := auth.GetUserRoles(r)
+
+	o, err := h.store.GetOrganisation(mux.Vars(r)["orgId"])
+	if err != nil {
+		middleware.WriteError(w, "NotFound", http.StatusNotFound, "Organisation not found", err)
+		return nil, false
+	}
+	if !h.policy.HasRole(o.ID, userID, roles, RoleStudent) {
+		middleware.WriteError(w, "NotFound", http.StatusNotFound, "Organisation not found", nil)
+		return nil, false
+	}
+	if !h.policy.HasRole(o.ID, userID, roles, role) {
+		middleware.WriteError(w, "Forbidden", http.StatusForbidden, "Insufficient organisation permissions", nil)
+		return nil, false
+	}
+	return o, true
+}
+
+// classroom loads the classroom from the URL. Instructors and org admins of the
+// organisation can manage it; enrolled students can only view it.
+func (h *Handler) classroom(w http.ResponseWriter, r *http.Request, manage bool) (*Classroom, bool, bool) {
+	userID, _ := auth.GetUserID(r)
+
+	c, err := h.store.GetClassroom(mux.Vars(r)["classroomId"])
+	if err != nil {
+		middleware.WriteError(w, "NotFound", http.StatusNotFound, "Classroom not found", err)
+		return nil, false, false
+	}
+
+	canManage := h.canManage(r, c.OrgID)
+	if !canManage && !h.enrolled(c.ID, userID) {
+		middleware.WriteError(w, "NotFound", http.StatusNotFound, "Classroom not found", nil)
+		return nil, false, false
+	}
+	if manage && !canManage {
+		middleware.WriteError(w, "Forbidden", http.StatusForbidden, "Insufficient organisation permissions", nil)
+		return nil, false, false
+	}
+	return c, canManage, true
+}
+
+func (h *Handler) canManage(r *http.Request, orgID string) bool {
+	userID, _ := auth.GetUserID(r)
+	roles, _ := auth.GetUserRoles(r)
+	return h.policy.HasRole(orgID, userID, roles, RoleInstructor)
+}
+
+func (h *Handler) enrolled(classroomID, userID string) bool {
+	enrollments, err := h.store.ListEnrollments(classroomID)
+	if err != nil {
+		return false
+	}
+	for _, e := range enrollments {
+		if e.UserID == userID {
+			return true
+		}
+	}
+	return false
+}
+
+func (h *Handler) lastAdmin(orgID string) bool {
+	members, err := h.store.ListMembers(orgID)
+	if err != nil {
+		return false
+	}
+	admins := 0
+	for _, m := range members {
+		if m.Role == RoleOrgAdmin {
+			admins++
+		}
+	}
+	return admins <= 1
+}
+
+// createInviteWithCode stores the invite under a newly generated code
+func (h *Handler) createInviteWithCode(invite *Invite) error {
+	for {
+		code, err := inviteCode()
+		if err != nil {
+			return err
+		}
+		invite.Code = code
+		if err := h.store.CreateInvite(invite); err != ErrAlreadyExists {
+			return err
+		}
+	}
+}
+
+func withoutInviteCode(c *Classroom) *Classroom {
+	copy := *c
+	copy.InviteCode = ""
+	return &copy
+}
+
+// inviteCode returns a short code that is easy to read out in a classroom
+func inviteCode() (string, error) {
+	b := make([]byte, 5)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base32.StdEncoding.EncodeToString(b), nil
+}
@@ -0,0 +1,285 @@
+package org
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/ringo380/lessoncraft/api/apitest"
+	"github.com/ringo380/lessoncraft/api/auth"
+	"github.com/ringo380/lessoncraft/lesson"
+	"github.com/stretchr/testify/assert"
+)
+
+type lessonMap map[string]*lesson.Lesson
+
+func (m lessonMap) GetLesson(id string) (*lesson.Lesson, error) {
+	if l, ok := m[id]; ok {
+		return l, nil
+	}
+	return nil, errors.New("lesson not found")
+}
+
+type orgTestEnv struct {
+	store   *MemoryStore
+	users   *auth.MemoryUserStore
+	jwt     *auth.JWTService
+	handler *Handler
+	router  *mux.Router
+}
+
+func newOrgTestEnv(t *testing.T, lessons lessonMap) *orgTestEnv {
+	env := &orgTestEnv{
+		store: NewMemoryStore(),
+		users: auth.NewMemoryUserStore(),
+		jwt:   auth.NewJWTService("secret", "lessoncraft", time.Hour),
+	}
+	for _, u := range []struct {
+		id    string
+		roles []auth.Role
+	}{
+		{"teacher", []auth.Role{auth.RoleEducator}},
+		{"alice", []auth.Role{auth.RoleLearner}},
+		{"bob", []auth.Role{auth.RoleLearner}},
+		{"root", []auth.Role{auth.RoleAdmin}},
+	} {
+		user := &auth.UserWithAuth{Roles: u.roles}
+		user.Id = u.id
+		user.Email = u.id + "@example.com"
+		assert.Nil(t, env.users.CreateUser(user))
+	}
+
+	env.router = mux.NewRouter()
+	env.handler = NewHandler(env.store, env.users, lessons, env.jwt)
+	env.handler.RegisterRoutes(env.router)
+	return env
+}
+
+func (e *orgTestEnv) do(t *testing.T, userID, method, path string, body interface{}) *httptest.ResponseRecorder {
+	user, err := e.users.GetUserByID(userID)
+	assert.Nil(t, err)
+	token, _, err := e.jwt.GenerateToken(user.Id, user.Email, user.Roles)
+	assert.Nil(t, err)
+
+	return apitest.Do(e.router, token, method, path, body)
+}
+
+func (e *orgTestEnv) createOrg(t *testing.T) *Organisation {
+	rr := e.do(t, "teacher", "POST", "/api/orgs", CreateOrganisationRequest{Name: "Acme"})
+	assert.Equal(t, http.StatusCreated, rr.Code)
+
+	var o Organisation
+	assert.Nil(t, json.NewDecoder(rr.Body).Decode(&o))
+	return &o
+}
+
+func TestCreateOrganisation(t *testing.T) {
+	env := newOrgTestEnv(t, lessonMap{})
+
+	rr := env.do(t, "alice", "POST", "/api/orgs", CreateOrganisationRequest{Name: "Acme"})
+	assert.Equal(t, http.StatusForbidden, rr.Code)
+
+	o := env.createOrg(t)
+	m, err := env.store.GetMembership(o.ID, "teacher")
+	assert.Nil(t, err)
+	assert.Equal(t, RoleOrgAdmin, m.Role)
+
+	// Non-members cannot see the organisation
+	rr = env.do(t, "alice", "GET", "/api/orgs/"+o.ID, nil)
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+
+	// Global admins can
+	rr = env.do(t, "root", "GET", "/api/orgs/"+o.ID, nil)
+	assert.Equal(t, http.StatusOK, rr.Code)
+}
+
+func TestClassroomInviteEnrollsStudent(t *testing.T) {
+	env := newOrgTestEnv(t, lessonMap{})
+	o := env.createOrg(t)
+
+	rr := env.do(t, "teacher", "POST", "/api/orgs/"+o.ID+"/classrooms", CreateClassroomRequest{Name: "Cohort 1"})
+	assert.Equal(t, http.StatusCreated, rr.Code)
+	var c Classroom
+	assert.Nil(t, json.NewDecoder(rr.Body).Decode(&c))
+	assert.NotEmpty(t, c.InviteCode)
+
+	rr = env.do(t, "alice", "POST", "/api/invites/"+c.InviteCode+"/accept", nil)
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	m, err := env.store.GetMembership(o.ID, "alice")
+	assert.Nil(t, err)
+	assert.Equal(t, RoleStudent, m.Role)
+
+	enrollments, err := env.store.ListEnrollments(c.ID)
+	assert.Nil(t, err)
+	assert.Len(t, enrollments, 1)
+	assert.Equal(t, "alice", enrollments[0].UserID)
+
+	// Students see their classroom without the invite code and cannot manage it
+	rr = env.do(t, "alice", "GET", "/api/classrooms/"+c.ID, nil)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	var seen Classroom
+	assert.Nil(t, json.NewDecoder(rr.Body).Decode(&seen))
+	assert.Empty(t, seen.InviteCode)
+
+	rr = env.do(t, "alice", "GET", "/api/classrooms/"+c.ID+"/enrollments", nil)
+	assert.Equal(t, http.StatusForbidden, rr.Code)
+}
+
+func TestInviteLimits(t *testing.T) {
+	env := newOrgTestEnv(t, lessonMap{})
+	o := env.createOrg(t)
+
+	rr := env.do(t, "teacher", "POST", "/api/orgs/"+o.ID+"/invites", CreateInviteRequest{Role: RoleInstructor, MaxUses: 1})
+	assert.Equal(t, http.StatusCreated, rr.Code)
+	var invite Invite
+	assert.Nil(t, json.NewDecoder(rr.Body).Decode(&invite))
+
+	rr = env.do(t, "alice", "POST", "/api/invites/"+invite.Code+"/accept", nil)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	role, _ := NewPolicy(env.store).Role(o.ID, "alice")
+	assert.Equal(t, RoleInstructor, role)
+
+	rr = env.do(t, "bob", "POST", "/api/invites/"+invite.Code+"/accept", nil)
+	assert.Equal(t, http.StatusGone, rr.Code)
+
+	// Concurrent accepts cannot go past the limit
+	rr = env.do(t, "teacher", "POST", "/api/orgs/"+o.ID+"/invites", CreateInviteRequest{Role: RoleStudent, MaxUses: 1})
+	assert.Equal(t, http.StatusCreated, rr.Code)
+	assert.Nil(t, json.NewDecoder(rr.Body).Decode(&invite))
+	codes := make(chan int, 2)
+	for _, user := range []string{"bob", "root"} {
+		go func(user string) {
+			codes <- env.do(t, user, "POST", "/api/invites/"+invite.Code+"/accept", nil).Code
+		}(user)
+	}
+	accepted := 0
+	for range []string{"bob", "root"} {
+		if <-codes == http.StatusOK {
+			accepted++
+		}
+	}
+	assert.Equal(t, 1, accepted)
+	used, _ := env.store.GetInvite(invite.Code)
+	assert.Equal(t, 1, used.Uses)
+
+	// Instructors can only invite students
+	rr = env.do(t, "alice", "POST", "/api/orgs/"+o.ID+"/invites", CreateInviteRequest{Role: RoleOrgAdmin})
+	assert.Equal(t, http.StatusForbidden, rr.Code)
+}
+
+func TestAssignments(t *testing.T) {
+	lessons := lessonMap{
+		"public":  &lesson.Lesson{ID: "public"},
+		"private": &lesson.Lesson{ID: "private", OrgID: "someone-else"},
+	}
+	env := newOrgTestEnv(t, lessons)
+	o := env.createOrg(t)
+
+	rr := env.do(t, "teacher", "POST", "/api/orgs/"+o.ID+"/classrooms", CreateClassroomRequest{Name: "Cohort 1"})
+	var c Classroom
+	assert.Nil(t, json.NewDecoder(rr.Body).Decode(&c))
+
+	rr = env.do(t, "teacher", "POST", "/api/classrooms/"+c.ID+"/enrollments", EnrollRequest{UserID: "bob"})
+	assert.Equal(t, http.StatusCreated, rr.Code)
+
+	due := time.Now().Add(48 * time.Hour).UTC().Truncate(time.Second)
+	rr = env.do(t, "teacher", "POST", "/api/classrooms/"+c.ID+"/assignments", CreateAssignmentRequest{LessonID: "public", DueAt: due})
+	assert.Equal(t, http.StatusCreated, rr.Code)
+
+	// Lessons of other organisations cannot be assigned
+	rr = env.do(t, "teacher", "POST", "/api/classrooms/"+c.ID+"/assignments", CreateAssignmentRequest{LessonID: "private"})
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+
+	rr = env.do(t, "bob", "GET", "/api/me/assignments", nil)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	var assignments []Assignment
+	assert.Nil(t, json.NewDecoder(rr.Body).Decode(&assignments))
+	assert.Len(t, assignments, 1)
+	assert.Equal(t, "public", assignments[0].LessonID)
+	assert.True(t, due.Equal(assignments[0].DueAt))
+
+	// Removing bob from the organisation drops the enrollment
+	rr = env.do(t, "teacher", "DELETE", "/api/orgs/"+o.ID+"/members/bob", nil)
+	assert.Equal(t, http.StatusNoContent, rr.Code)
+	rr = env.do(t, "bob", "GET", "/api/me/assignments", nil)
+	assert.Nil(t, json.NewDecoder(rr.Body).Decode(&assignments))
+	assert.Len(t, assignments, 0)
+}
+
+func TestLastOrgAdminCannotLeave(t *testing.T) {
+	env := newOrgTestEnv(t, lessonMap{})
+	o := env.createOrg(t)
+
+	rr := env.do(t, "teacher", "DELETE", "/api/orgs/"+o.ID+"/members/teacher", nil)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+
+	rr = env.do(t, "teacher", "PUT", "/api/orgs/"+o.ID+"/members/alice", SetMemberRequest{Role: RoleOrgAdmin})
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	rr = env.do(t, "teacher", "DELETE", "/api/orgs/"+o.ID+"/members/teacher", nil)
+	assert.Equal(t, http.StatusNoContent, rr.Code)
+}
+
+// recordingAuditor collects the actions recorded by the handler
+type recordingAuditor struct {
+	actions []string
+}
+
+func (a *recordingAuditor) Record(r *http.Request, action, targetType, targetID string, before, after interface{}) {
+	a.actions = append(a.actions, action+" "+targetType)
+}
+
+func TestAudit(t *testing.T) {
+	env := newOrgTestEnv(t, lessonMap{})
+	auditor := &recordingAuditor{}
+	env.handler.SetAuditor(auditor)
+
+	o := env.createOrg(t)
+	rr := env.do(t, "teacher", "PUT", "/api/orgs/"+o.ID+"/members/alice", SetMemberRequest{Role: RoleInstructor})
+	assert.Equal(t, http.StatusOK, rr.Code)
+	rr = env.do(t, "teacher", "POST", "/api/orgs/"+o.ID+"/classrooms", CreateClassroomRequest{Name: "Cohort 1"})
+	assert.Equal(t, http.StatusCreated, rr.Code)
+	var c Classroom
+	assert.Nil(t, json.NewDecoder(rr.Body).Decode(&c))
+	rr = env.do(t, "teacher", "POST", "/api/classrooms/"+c.ID+"/enrollments", EnrollRequest{UserID: "bob"})
+	assert.Equal(t, http.StatusCreated, rr.Code)
+	// Failed actions are not recorded
+	rr = env.do(t, "alice", "DELETE", "/api/orgs/"+o.ID, nil)
+	assert.Equal(t, http.StatusForbidden, rr.Code)
+	rr = env.do(t, "teacher", "DELETE", "/api/orgs/"+o.ID+"/members/alice", nil)
+	assert.Equal(t, http.StatusNoContent, rr.Code)
+
+	assert.Equal(t, []string{
+		"org.create organisation",
+		"org.member.set organisation",
+		"classroom.create classroom",
+		"classroom.enroll classroom",
+		"org.member.remove organisation",
+	}, auditor.actions)
+}
+
+func TestAPIKeyScopes(t *testing.T) {
+	env := newOrgTestEnv(t, lessonMap{})
+	apiKeys := auth.NewAPIKeyService(auth.NewMemoryAPIKeyStore(), env.users, env.jwt)
+	env.router = mux.NewRouter()
+	NewHandler(env.store, env.users, lessonMap{}, apiKeys).RegisterRoutes(env.router)
+
+	account := &auth.UserWithAuth{Roles: []auth.Role{auth.RoleEducator}, ServiceAccount: true}
+	account.Id = "ci"
+	assert.Nil(t, env.users.CreateUser(account))
+	do := func(scopes []auth.Scope, method, path string, body interface{}) int {
+		key, _, err := apiKeys.Generate(account, "ci", scopes, time.Time{}, "root")
+		assert.Nil(t, err)
+		return apitest.Do(env.router, key, method, path, body).Code
+	}
+
+	assert.Equal(t, http.StatusForbidden, do([]auth.Scope{auth.ScopeLessonsWrite}, "GET", "/api/orgs", nil))
+	assert.Equal(t, http.StatusOK, do([]auth.Scope{auth.ScopeOrgsRead}, "GET", "/api/orgs", nil))
+	assert.Equal(t, http.StatusForbidden, do([]auth.Scope{auth.ScopeOrgsRead}, "POST", "/api/orgs", CreateOrganisationRequest{Name: "Acme"}))
+	assert.Equal(t, http.StatusCreated, do([]auth.Scope{auth.ScopeOrgsWrite}, "POST", "/api/orgs", CreateOrganisationRequest{Name: "Acme"}))
+}
@@ -0,0 +1,441 @@
+package org
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// MemoryStore is an in-memory implementation of the Store interface
+// It is primarily used for testing purposes
+type MemoryStore struct {
+	organisations map[string]*Organisation
+	memberships   map[string]map[string]*Membership // Map of org ID to user ID to membership
+	classrooms    map[string]*Classroom
+	invites       map[string]*Invite
+	enrollments   map[string]map[string]*Enrollment // Map of classroom ID to user ID to enrollment
+	assignments   map[string]*Assignment
+	mu            sync.RWMutex
+}
+
+// NewMemoryStore creates a new in-memory organisation store
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		organisations: make(map[string]*Organisation),
+		memberships:   make(map[string]map[string]*Membership),
+		classrooms:    make(map[string]*Classroom),
+		invites:       make(map[string]*Invite),
+		enrollments:   make(map[string]map[string]*Enrollment),
+		assignments:   make(map[string]*Assignment),
+	}
+}
+
+// CreateOrganisation creates a new organisation
+func (s *MemoryStore) CreateOrganisation(o *Organisation) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.organisations[o.ID]; ok {
+		return ErrAlreadyExists
+	}
+	s.organisations[o.ID] = o
+	s.memberships[o.ID] = make(map[string]*Membership)
+	return nil
+}
+
+// GetOrganisation retrieves an organisation by ID
+func (s *MemoryStore) GetOrganisation(id string) (*Organisation, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	o, ok := s.organisations[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return o, nil
+}
+
+// ListOrganisationsForUser retrieves the organisations a user is a member of
+func (s *MemoryStore) ListOrganisationsForUser(userID string) ([]*Organisation, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	orgs := []*Organisation{}
+	for orgID, members := range s.memberships {
+		if _, ok := members[userID]; ok {
+			orgs = append(orgs, s.organisations[orgID])
+		}
+	}
+	sort.Slice(orgs, func(i, j int) bool { return orgs[i].Name < orgs[j].Name })
+	return orgs, nil
+}
+
+// DeleteOrganisation deletes an organisation with its members, classrooms and invites
+func (s *MemoryStore) DeleteOrganisation(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.organisations[id]; !ok {
+		return ErrNotFound
+	}
+	for classroomID, c := range s.classrooms {
+		if c.OrgID == id {
+			s.deleteClassroom(classroomID)
+		}
+	}
+	for code, i := range s.invites {
+		if i.OrgID == id {
+			delete(s.invites, code)
+		}
+	}
+	delete(s.memberships, id)
+	delete(s.organisations, id)
+	return nil
+}
+
+// SetMembership creates or updates the membership of a user
+func (s *MemoryStore) SetMembership(m *Membership) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	members, ok := s.memberships[m.OrgID]
+	if !ok {
+		return ErrNotFound
+	}
+	members[m.UserID] = m
+	return nil
+}
+
+// GetMembership retrieves the membership of a user, or ErrNotMember
+func (s *MemoryStore) GetMembership(orgID, userID string) (*Membership, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	m, ok := s.memberships[orgID][userID]
+	if !ok {
+		return nil, ErrNotMember
+	}
+	return m, nil
+}
+
+// ListMembers retrieves the members of an organisation
+func (s *MemoryStore) ListMembers(orgID string) ([]*Membership, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	members, ok := s.memberships[orgID]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	result := make([]*Membership, 0, len(members))
+	for _, m := range members {
+		result = append(result, m)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].JoinedAt.Before(result[j].JoinedAt) })
+	return result, nil
+}
+
+// RemoveMember removes a user from an organisation and its classrooms
+func (s *MemoryStore) RemoveMember(orgID, userID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.memberships[orgID][userID]; !ok {
+		return ErrNotMember
+	}
+	delete(s.memberships[orgID], userID)
+	for classroomID, c := range s.classrooms {
+		if c.OrgID == orgID {
+			delete(s.enrollments[classroomID], userID)
+		}
+	}
+	return nil
+}
+
+// CreateClassroom creates a new classroom
+func (s *MemoryStore) CreateClassroom(c *Classroom) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.organisations[c.OrgID]; !ok {
+		return ErrNotFound
+	}
+	if _, ok := s.classrooms[c.ID]; ok {
+		return ErrAlreadyExists
+	}
+	s.classrooms[c.ID] = c
+	s.enrollments[c.ID] = make(map[string]*Enrollment)
+	return nil
+}
+
+// GetClassroom retrieves a classroom by ID
+func (s *MemoryStore) GetClassroom(id string) (*Classroom, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	c, ok := s.classrooms[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return c, nil
+}
+
+// UpdateClassroom updates an existing classroom
+func (s *MemoryStore) UpdateClassroom(c *Classroom) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.classrooms[c.ID]; !ok {
+		return ErrNotFound
+	}
+	s.classrooms[c.ID] = c
+	return nil
+}
+
+// ListClassrooms retrieves the classrooms of an organisation
+func (s *MemoryStore) ListClassrooms(orgID string) ([]*Classroom, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	result := []*Classroom{}
+	for _, c := range s.classrooms {
+		if c.OrgID == orgID {
+			result = append(result, c)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].CreatedAt.Before(result[j].CreatedAt) })
+	return result, nil
+}
+
+// DeleteClassroom deletes a classroom with its enrollments and assignments
+func (s *MemoryStore) DeleteClassroom(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.classrooms[id]; !ok {
+		return ErrNotFound
+	}
+	s.deleteClassroom(id)
+	return nil
+}
+
+// deleteClassroom removes a classroom and everything that refers to it.
+// The caller must hold the write lock.
+func (s *MemoryStore) deleteClassroom(id string) {
+	for assignmentID, a := range s.assignments {
+		if a.ClassroomID == id {
+			delete(s.assignments, assignmentID)
+		}
+	}
+	for code, i := range s.invites {
+		if i.ClassroomID == id {
+			delete(s.invites, code)
+		}
+	}
+	delete(s.enrollments, id)
+	delete(s.classrooms, id)
+}
+
+// CreateInvite creates a new invite
+func (s *MemoryStore) CreateInvite(i *Invite) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.organisations[i.OrgID]; !ok {
+		return ErrNotFound
+	}
+	if _, ok := s.invites[i.Code]; ok {
+		return ErrAlreadyExists
+	}
+	s.invites[i.Code] = i
+	return nil
+}
+
+// GetInvite retrieves an invite by code
+func (s *MemoryStore) GetInvite(code string) (*Invite, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	i, ok := s.invites[code]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return i, nil
+}
+
+// UpdateInvite updates an existing invite
+func (s *MemoryStore) UpdateInvite(i *Invite) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.invites[i.Code]; !ok {
+		return ErrNotFound
+	}
+	s.invites[i.Code] = i
+	return nil
+}
+
+// UseInvite counts an acceptance of an invite that is usable at the given time
+func (s *MemoryStore) UseInvite(code string, now time.Time) (*Invite, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i, ok := s.invites[code]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	if !i.Usable(now) {
+		return nil, ErrInviteUnusable
+	}
+	used := *i
+	used.Uses++
+	s.invites[code] = &used
+	return &used, nil
+}
+
+// ListInvites retrieves the invites of an organisation
+func (s *MemoryStore) ListInvites(orgID string) ([]*Invite, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	result := []*Invite{}
+	for _, i := range s.invites {
+		if i.OrgID == orgID {
+			result = append(result, i)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].CreatedAt.Before(result[j].CreatedAt) })
+	return result, nil
+}
+
+// DeleteInvite deletes an invite
+func (s *MemoryStore) DeleteInvite(code string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.invites[code]; !ok {
+		return ErrNotFound
+	}
+	delete(s.invites, code)
+	return nil
+}
+
+// Enroll adds a learner to a classroom
+func (s *MemoryStore) Enroll(e *Enrollment) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	enrollments, ok := s.enrollments[e.ClassroomID]
+	if !ok {
+		return ErrNotFound
+	}
+	if _, ok := enrollments[e.UserID]; ok {
+		return ErrAlreadyExists
+	}
+	enrollments[e.UserID] = e
+	return nil
+}
+
+// Unenroll removes a learner from a classroom
+func (s *MemoryStore) Unenroll(classroomID, userID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.enrollments[classroomID][userID]; !ok {
+		return ErrNotFound
+	}
+	delete(s.enrollments[classroomID], userID)
+	return nil
+}
+
+// ListEnrollments retrieves the learners enrolled in a classroom
+func (s *MemoryStore) ListEnrollments(classroomID string) ([]*Enrollment, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	enrollments, ok := s.enrollments[classroomID]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	result := make([]*Enrollment, 0, len(enrollments))
+	for _, e := range enrollments {
+		result = append(result, e)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].EnrolledAt.Before(result[j].EnrolledAt) })
+	return result, nil
+}
+
+// ListEnrollmentsForUser retrieves the classrooms a learner is enrolled in
+func (s *MemoryStore) ListEnrollmentsForUser(userID string) ([]*Enrollment, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	result := []*Enrollment{}
+	for _, enrollments := range s.enrollments {
+		if e, ok := enrollments[userID]; ok {
+			result = append(result, e)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].EnrolledAt.Before(result[j].EnrolledAt) })
+	return result, nil
+}
+
+// CreateAssignment creates a new lesson assignment
+func (s *MemoryStore) CreateAssignment(a *Assignment) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.classrooms[a.ClassroomID]; !ok {
+		return ErrNotFound
+	}
+	if _, ok := s.assignments[a.ID]; ok {
+		return ErrAlreadyExists
+	}
+	s.assignments[a.ID] = a
+	return nil
+}
+
+// GetAssignment retrieves an assignment by ID
+func (s *MemoryStore) GetAssignment(id string) (*Assignment, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	a, ok := s.assignments[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return a, nil
+}
+
+// ListAssignments retrieves the assignments of a classroom
+func (s *MemoryStore) ListAssignments(classroomID string) ([]*Assignment, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	result := []*Assignment{}
+	for _, a := range s.assignments {
+		if a.ClassroomID == classroomID {
+			result = append(result, a)
+		}
+	}
+	// Assignments without a due date go last
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].DueAt.IsZero() != result[j].DueAt.IsZero() {
+			return result[j].DueAt.IsZero()
+		}
+		return result[i].DueAt.Before(result[j].DueAt)
+	})
+	return result, nil
+}
+
+// DeleteAssignment deletes an assignment
+func (s *MemoryStore) DeleteAssignment(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.assignments[id]; !ok {
+		return ErrNotFound
+	}
+	delete(s.assignments, id)
+	return nil
+}
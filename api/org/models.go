@@ -0,0 +1,137 @@
+package org
+
+import (
+	"time"
+)
+
+// Role represents a user's role within an organisation. Organisation roles are
+// independent of the global auth.Role a user holds.
+type Role string
+
+const (
+	// RoleOrgAdmin manages the organisation, its members and invites
+	RoleOrgAdmin Role = "org_admin"
+	// RoleInstructor manages classrooms, enrollments and assignments
+	RoleInstructor Role = "instructor"
+	// RoleStudent is enrolled into classrooms and works on assigned lessons
+	RoleStudent Role = "student"
+)
+
+// rank orders roles so that a higher role includes the permissions of lower ones
+var rank = map[Role]int{
+	RoleStudent:    1,
+	RoleInstructor: 2,
+	RoleOrgAdmin:   3,
+}
+
+// Valid checks if the role is a known organisation role
+func (r Role) Valid() bool {
+	_, ok := rank[r]
+	return ok
+}
+
+// AtLeast checks if the role includes the permissions of the given role
+func (r Role) AtLeast(other Role) bool {
+	return rank[r] >= rank[other]
+}
+
+// Organisation is a customer team. Lessons and sessions that belong to an
+// organisation are only visible to its members.
+type Organisation struct {
+	// ID is a unique identifier for the organisation
+	ID string `json:"id" bson:"id"`
+	// Name is the display name of the organisation
+	Name string `json:"name" bson:"name"`
+	// CreatedBy is the ID of the user that created the organisation
+	CreatedBy string `json:"created_by" bson:"created_by"`
+	// CreatedAt records when the organisation was created
+	CreatedAt time.Time `json:"created_at" bson:"created_at"`
+}
+
+// Membership assigns a user a role within an organisation
+type Membership struct {
+	// OrgID is the ID of the organisation
+	OrgID string `json:"org_id" bson:"org_id"`
+	// UserID is the ID of the member
+	UserID string `json:"user_id" bson:"user_id"`
+	// Role is the member's role within the organisation
+	Role Role `json:"role" bson:"role"`
+	// JoinedAt records when the user joined the organisation
+	JoinedAt time.Time `json:"joined_at" bson:"joined_at"`
+}
+
+// Classroom is a cohort of students within an organisation that work through
+// the same lesson assignments
+type Classroom struct {
+	// ID is a unique identifier for the classroom
+	ID string `json:"id" bson:"id"`
+	// OrgID is the ID of the organisation the classroom belongs to
+	OrgID string `json:"org_id" bson:"org_id"`
+	// Name is the display name of the classroom (e.g. "Spring 2025 cohort")
+	Name string `json:"name" bson:"name"`
+	// Description is an optional description of the classroom
+	Description string `json:"description,omitempty" bson:"description,omitempty"`
+	// InviteCode is the code students use to join the organisation and enroll in the classroom
+	InviteCode string `json:"invite_code,omitempty" bson:"invite_code,omitempty"`
+	// CreatedBy is the ID of the user that created the classroom
+	CreatedBy string `json:"created_by" bson:"created_by"`
+	// CreatedAt records when the classroom was created
+	CreatedAt time.Time `json:"created_at" bson:"created_at"`
+}
+
+// Invite lets users join an organisation with a given role. Invites created for a
+// classroom also enroll the user into that classroom.
+type Invite struct {
+	// Code is the secret code used to accept the invite
+	Code string `json:"code" bson:"code"`
+	// OrgID is the ID of the organisation the invite is for
+	OrgID string `json:"org_id" bson:"org_id"`
+	// Role is the role granted when the invite is accepted
+	Role Role `json:"role" bson:"role"`
+	// ClassroomID is the classroom the user is enrolled into, if any
+	ClassroomID string `json:"classroom_id,omitempty" bson:"classroom_id,omitempty"`
+	// MaxUses limits how often the invite can be accepted (0 means unlimited)
+	MaxUses int `json:"max_uses" bson:"max_uses"`
+	// Uses counts how often the invite has been accepted
+	Uses int `json:"uses" bson:"uses"`
+	// ExpiresAt is when the invite stops being valid (zero means never)
+	ExpiresAt time.Time `json:"expires_at,omitempty" bson:"expires_at,omitempty"`
+	// CreatedBy is the ID of the user that created the invite
+	CreatedBy string `json:"created_by" bson:"created_by"`
+	// CreatedAt records when the invite was created
+	CreatedAt time.Time `json:"created_at" bson:"created_at"`
+}
+
+// Usable checks if the invite can still be accepted at the given time
+func (i *Invite) Usable(now time.Time) bool {
+	if !i.ExpiresAt.IsZero() && now.After(i.ExpiresAt) {
+		return false
+	}
+	return i.MaxUses == 0 || i.Uses < i.MaxUses
+}
+
+// Enrollment records that a learner is part of a classroom
+type Enrollment struct {
+	// ClassroomID is the ID of the classroom
+	ClassroomID string `json:"classroom_id" bson:"classroom_id"`
+	// UserID is the ID of the enrolled learner
+	UserID string `json:"user_id" bson:"user_id"`
+	// EnrolledAt records when the learner was enrolled
+	EnrolledAt time.Time `json:"enrolled_at" bson:"enrolled_at"`
+}
+
+// Assignment assigns a lesson to every learner in a classroom
+type Assignment struct {
+	// ID is a unique identifier for the assignment
+	ID string `json:"id" bson:"id"`
+	// ClassroomID is the ID of the classroom the lesson is assigned to
+	ClassroomID string `json:"classroom_id" bson:"classroom_id"`
+	// LessonID is the ID of the assigned lesson
+	LessonID string `json:"lesson_id" bson:"lesson_id"`
+	// DueAt is when the lesson should be completed (zero means no due date)
+	DueAt time.Time `json:"due_at,omitempty" bson:"due_at,omitempty"`
+	// AssignedBy is the ID of the instructor that created the assignment
+	AssignedBy string `json:"assigned_by" bson:"assigned_by"`
+	// CreatedAt records when the assignment was created
+	CreatedAt time.Time `json:"created_at" bson:"created_at"`
+}
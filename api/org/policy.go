@@ -0,0 +1,114 @@
+package org
+
+import (
+	"net/http"
+
+	"github.com/ringo380/lessoncraft/api/auth"
+	"github.com/ringo380/lessoncraft/lesson"
+	"github.com/ringo380/lessoncraft/pwd/types"
+)
+
+// Policy applies the organisation visibility rules:
+//   - global admins can see and manage everything
+//   - lessons and sessions without an organisation are visible to everyone
+//   - lessons of an organisation are visible to its members and can be managed by
+//     its instructors and org admins
+//   - sessions of an organisation are visible to their owner and to the
+//     organisation's instructors and org admins
+type Policy struct {
+	store Store
+}
+
+// NewPolicy creates a new Policy backed by the given store
+func NewPolicy(store Store) *Policy {
+	return &Policy{store: store}
+}
+
+// Role returns the user's role in the organisation, or false if the user is not a member
+func (p *Policy) Role(orgID, userID string) (Role, bool) {
+	m, err := p.store.GetMembership(orgID, userID)
+	if err != nil {
+		return "", false
+	}
+	return m.Role, true
+}
+
+// HasRole checks if the user has at least the given role in the organisation.
+// Global admins have every role in every organisation.
+func (p *Policy) HasRole(orgID, userID string, roles []auth.Role, role Role) bool {
+	if isAdmin(roles) {
+		return true
+	}
+	r, ok := p.Role(orgID, userID)
+	return ok && r.AtLeast(role)
+}
+
+// CanViewLesson checks if the authenticated user of the request may see the lesson
+func (p *Policy) CanViewLesson(r *http.Request, l *lesson.Lesson) bool {
+	if l.OrgID == "" {
+		return true
+	}
+	userID, _ := auth.GetUserID(r)
+	roles, _ := auth.GetUserRoles(r)
+	return p.HasRole(l.OrgID, userID, roles, RoleStudent)
+}
+
+// CanEditLesson checks if the authenticated user of the request may create, change or
+// delete the lesson. Lessons without an organisation keep the global rules.
+func (p *Policy) CanEditLesson(r *http.Request, l *lesson.Lesson) bool {
+	if l.OrgID == "" {
+		return true
+	}
+	userID, _ := auth.GetUserID(r)
+	roles, _ := auth.GetUserRoles(r)
+	return p.HasRole(l.OrgID, userID, roles, RoleInstructor)
+}
+
+// CanViewSession checks if the user may see the session
+func (p *Policy) CanViewSession(userID string, roles []auth.Role, s *types.Session) bool {
+	if s.OrgId == "" || isAdmin(roles) {
+		return true
+	}
+	if userID == "" {
+		return false
+	}
+	if s.UserId == userID {
+		_, member := p.Role(s.OrgId, userID)
+		return member
+	}
+	return p.HasRole(s.OrgId, userID, roles, RoleInstructor)
+}
+
+// CanManageSession checks if the user may act on the session from outside the
+// playground, e.g. get signed links to its ports. Unlike viewing, sessions without
+// an organisation are not open to everyone: only their owner, the instructors and
+// org admins of the session's organisation, and global admins qualify.
+func (p *Policy) CanManageSession(userID string, roles []auth.Role, s *types.Session) bool {
+	if isAdmin(roles) {
+		return true
+	}
+	if userID == "" {
+		return false
+	}
+	if s.UserId == userID {
+		return true
+	}
+	return s.OrgId != "" && p.HasRole(s.OrgId, userID, roles, RoleInstructor)
+}
+
+// CanCreateSession checks if the user may start a session in the organisation
+func (p *Policy) CanCreateSession(userID string, roles []auth.Role, orgID string) bool {
+	if orgID == "" {
+		return true
+	}
+	return p.HasRole(orgID, userID, roles, RoleStudent)
+}
+
+func isAdmin(roles []auth.Role) bool {
+	for _, r := range roles {
+		if r == auth.RoleAdmin {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,69 @@
+package org
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/ringo380/lessoncraft/api/auth"
+	"github.com/ringo380/lessoncraft/lesson"
+	"github.com/ringo380/lessoncraft/pwd/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func newPolicyTestStore(t *testing.T) *MemoryStore {
+	store := NewMemoryStore()
+	assert.Nil(t, store.CreateOrganisation(&Organisation{ID: "acme", Name: "Acme"}))
+	now := time.Now()
+	assert.Nil(t, store.SetMembership(&Membership{OrgID: "acme", UserID: "teacher", Role: RoleInstructor, JoinedAt: now}))
+	assert.Nil(t, store.SetMembership(&Membership{OrgID: "acme", UserID: "alice", Role: RoleStudent, JoinedAt: now}))
+	assert.Nil(t, store.SetMembership(&Membership{OrgID: "acme", UserID: "bob", Role: RoleStudent, JoinedAt: now}))
+	return store
+}
+
+func TestPolicy_Lessons(t *testing.T) {
+	policy := NewPolicy(newPolicyTestStore(t))
+
+	as := func(userID string, roles ...auth.Role) *http.Request {
+		req := httptest.NewRequest("GET", "/api/lessons", nil)
+		ctx := context.WithValue(req.Context(), auth.UserContextKey, userID)
+		ctx = context.WithValue(ctx, auth.RolesContextKey, roles)
+		return req.WithContext(ctx)
+	}
+
+	public := &lesson.Lesson{ID: "public"}
+	private := &lesson.Lesson{ID: "private", OrgID: "acme"}
+
+	assert.True(t, policy.CanViewLesson(as("stranger"), public))
+	assert.False(t, policy.CanViewLesson(as("stranger"), private))
+	assert.True(t, policy.CanViewLesson(as("alice"), private))
+	assert.True(t, policy.CanViewLesson(as("root", auth.RoleAdmin), private))
+
+	assert.False(t, policy.CanEditLesson(as("alice"), private))
+	assert.True(t, policy.CanEditLesson(as("teacher"), private))
+}
+
+func TestPolicy_Sessions(t *testing.T) {
+	policy := NewPolicy(newPolicyTestStore(t))
+	session := &types.Session{Id: "s1", UserId: "alice", OrgId: "acme"}
+
+	assert.True(t, policy.CanViewSession("alice", nil, session))
+	assert.False(t, policy.CanViewSession("bob", nil, session))
+	assert.True(t, policy.CanViewSession("teacher", nil, session))
+	assert.False(t, policy.CanViewSession("", nil, session))
+	assert.True(t, policy.CanViewSession("", nil, &types.Session{Id: "s2"}))
+
+	assert.True(t, policy.CanManageSession("alice", nil, session))
+	assert.True(t, policy.CanManageSession("teacher", nil, session))
+	assert.False(t, policy.CanManageSession("bob", nil, session))
+	public := &types.Session{Id: "s2", UserId: "alice"}
+	assert.True(t, policy.CanManageSession("alice", nil, public))
+	assert.False(t, policy.CanManageSession("bob", nil, public))
+	assert.False(t, policy.CanManageSession("", nil, &types.Session{Id: "s3"}))
+	assert.True(t, policy.CanManageSession("root", []auth.Role{auth.RoleAdmin}, public))
+
+	assert.True(t, policy.CanCreateSession("bob", nil, "acme"))
+	assert.False(t, policy.CanCreateSession("stranger", nil, "acme"))
+}
@@ -0,0 +1,82 @@
+package org
+
+import (
+	"errors"
+	"time"
+)
+
+var (
+	// ErrNotFound is returned when an organisation, classroom, invite or assignment does not exist
+	ErrNotFound = errors.New("not found")
+	// ErrAlreadyExists is returned when creating a record with an ID that is already in use
+	ErrAlreadyExists = errors.New("already exists")
+	// ErrNotMember is returned when a user is not a member of the organisation
+	ErrNotMember = errors.New("user is not a member of the organisation")
+	// ErrInviteUnusable is returned when accepting an invite that has expired or was used up
+	ErrInviteUnusable = errors.New("invite has expired or was used up")
+)
+
+// Store defines the interface for organisation storage operations
+type Store interface {
+	// CreateOrganisation creates a new organisation
+	CreateOrganisation(o *Organisation) error
+	// GetOrganisation retrieves an organisation by ID
+	GetOrganisation(id string) (*Organisation, error)
+	// ListOrganisationsForUser retrieves the organisations a user is a member of
+	ListOrganisationsForUser(userID string) ([]*Organisation, error)
+	// DeleteOrganisation deletes an organisation with its members, classrooms and invites
+	DeleteOrganisation(id string) error
+
+	// SetMembership creates or updates the membership of a user
+	SetMembership(m *Membership) error
+	// GetMembership retrieves the membership of a user, or ErrNotMember
+	GetMembership(orgID, userID string) (*Membership, error)
+	// ListMembers retrieves the members of an organisation
+	ListMembers(orgID string) ([]*Membership, error)
+	// RemoveMember removes a user from an organisation and its classrooms
+	RemoveMember(orgID, userID string) error
+
+	// CreateClassroom creates a new classroom
+	CreateClassroom(c *Classroom) error
+	// GetClassroom retrieves a classroom by ID
+	GetClassroom(id string) (*Classroom, error)
+	// UpdateClassroom updates an existing classroom
+	UpdateClassroom(c *Classroom) error
+	// ListClassrooms retrieves the classrooms of an organisation
+	ListClassrooms(orgID string) ([]*Classroom, error)
+	// DeleteClassroom deletes a classroom with its enrollments and assignments
+	DeleteClassroom(id string) error
+
+	// CreateInvite creates a new invite
+	CreateInvite(i *Invite) error
+	// GetInvite retrieves an invite by code
+	GetInvite(code string) (*Invite, error)
+	// UpdateInvite updates an existing invite
+	UpdateInvite(i *Invite) error
+	// UseInvite counts an acceptance of an invite that is usable at the given time
+	// and returns the updated invite, or ErrInviteUnusable. The check and the count
+	// happen in one step, so concurrent accepts cannot go past MaxUses.
+	UseInvite(code string, now time.Time) (*Invite, error)
+	// ListInvites retrieves the invites of an organisation
+	ListInvites(orgID string) ([]*Invite, error)
+	// DeleteInvite deletes an invite
+	DeleteInvite(code string) error
+
+	// Enroll adds a learner to a classroom
+	Enroll(e *Enrollment) error
+	// Unenroll removes a learner from a classroom
+	Unenroll(classroomID, userID string) error
+	// ListEnrollments retrieves the learners enrolled in a classroom
+	ListEnrollments(classroomID string) ([]*Enrollment, error)
+	// ListEnrollmentsForUser retrieves the classrooms a learner is enrolled in
+	ListEnrollmentsForUser(userID string) ([]*Enrollment, error)
+
+	// CreateAssignment creates a new lesson assignment
+	CreateAssignment(a *Assignment) error
+	// GetAssignment retrieves an assignment by ID
+	GetAssignment(id string) (*Assignment, error)
+	// ListAssignments retrieves the assignments of a classroom
+	ListAssignments(classroomID string) ([]*Assignment, error)
+	// DeleteAssignment deletes an assignment
+	DeleteAssignment(id string) error
+}
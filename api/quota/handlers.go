@@ -0,0 +1,191 @@
+package quota
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/ringo380/lessoncraft/api/auth"
+	"github.com/ringo380/lessoncraft/api/middleware"
+)
+
+// Handler serves quotas and usage history. Users can see their own quota,
+// everything else requires the admin role, and API keys need the admin scope.
+type Handler struct {
+	service *Service
+	tokens  auth.TokenValidator
+}
+
+// NewHandler creates a new Handler
+func NewHandler(service *Service, tokens auth.TokenValidator) *Handler {
+	return &Handler{
+		service: service,
+		tokens:  tokens,
+	}
+}
+
+// HistoryResponse is the usage history of a user, organisation or playground
+type HistoryResponse struct {
+	Allocations []*Allocation `json:"allocations"`
+	Summary     *Summary      `json:"summary"`
+}
+
+// RegisterRoutes registers the quota routes with the provided router:
+//   - GET /api/quotas/me: Get the quota and usage of the current user
+//   - GET /api/quotas/{scope}: List the quotas set for a scope (admin)
+//   - GET /api/quotas/{scope}/{id}: Get a quota and its usage (admin)
+//   - PUT /api/quotas/{scope}/{id}: Set a quota (admin)
+//   - DELETE /api/quotas/{scope}/{id}: Restore the default limits (admin)
+//   - GET /api/quotas/{scope}/{id}/history: Get the usage history (admin)
+//
+// Scopes are user, org and playground. The history accepts since and until
+// (RFC 3339) and defaults to the last 30 days.
+func (h *Handler) RegisterRoutes(r *mux.Router) {
+	authMiddleware := auth.AuthMiddleware(h.tokens)
+	adminMiddleware := auth.RoleMiddleware(auth.RoleAdmin)
+	scopeMiddleware := auth.ScopeMiddleware(auth.ScopeAdmin)
+	admin := func(f http.HandlerFunc) http.Handler {
+		return authMiddleware(adminMiddleware(scopeMiddleware(f)))
+	}
+
+	r.Handle("/api/quotas/me", authMiddleware(http.HandlerFunc(h.Me))).Methods("GET")
+	r.Handle("/api/quotas/{scope}", admin(h.List)).Methods("GET")
+	r.Handle("/api/quotas/{scope}/{id}", admin(h.Get)).Methods("GET")
+	r.Handle("/api/quotas/{scope}/{id}", admin(h.Set)).Methods("PUT")
+	r.Handle("/api/quotas/{scope}/{id}", admin(h.Reset)).Methods("DELETE")
+	r.Handle("/api/quotas/{scope}/{id}/history", admin(h.History)).Methods("GET")
+}
+
+// Me returns the quota of the current user and how much of it is in use
+func (h *Handler) Me(w http.ResponseWriter, r *http.Request) {
+	userID, _ := auth.GetUserID(r)
+	status, err := h.service.Status(ScopeUser, userID)
+	if err != nil {
+		middleware.WriteError(w, "DatabaseError", http.StatusInternalServerError, "Failed to get quota", err)
+		return
+	}
+	middleware.WriteJSON(w, http.StatusOK, status)
+}
+
+// List returns the quotas set for a scope
+func (h *Handler) List(w http.ResponseWriter, r *http.Request) {
+	scope, ok := parseScope(w, r)
+	if !ok {
+		return
+	}
+	quotas, err := h.service.Quotas(scope)
+	if err != nil {
+		middleware.WriteError(w, "DatabaseError", http.StatusInternalServerError, "Failed to list quotas", err)
+		return
+	}
+	middleware.WriteJSON(w, http.StatusOK, quotas)
+}
+
+// Get returns a quota and how much of it is in use
+func (h *Handler) Get(w http.ResponseWriter, r *http.Request) {
+	scope, ok := parseScope(w, r)
+	if !ok {
+		return
+	}
+	status, err := h.service.Status(scope, mux.Vars(r)["id"])
+	if err != nil {
+		middleware.WriteError(w, "DatabaseError", http.StatusInternalServerError, "Failed to get quota", err)
+		return
+	}
+	middleware.WriteJSON(w, http.StatusOK, status)
+}
+
+// Set replaces the limits of a user, organisation or playground
+func (h *Handler) Set(w http.ResponseWriter, r *http.Request) {
+	scope, ok := parseScope(w, r)
+	if !ok {
+		return
+	}
+	var limits Limits
+	if err := json.NewDecoder(r.Body).Decode(&limits); err != nil {
+		middleware.WriteError(w, "ValidationError", http.StatusBadRequest, "Invalid request body", err)
+		return
+	}
+	if err := limits.Validate(); err != nil {
+		middleware.WriteError(w, "ValidationError", http.StatusBadRequest, "Invalid limits", err)
+		return
+	}
+
+	userID, _ := auth.GetUserID(r)
+	q, err := h.service.SetLimits(scope, mux.Vars(r)["id"], limits, userID)
+	if err != nil {
+		middleware.WriteError(w, "DatabaseError", http.StatusInternalServerError, "Failed to set quota", err)
+		return
+	}
+	middleware.WriteJSON(w, http.StatusOK, q)
+}
+
+// Reset removes the quota of a user, organisation or playground, so the
+// default limits of its scope apply again
+func (h *Handler) Reset(w http.ResponseWriter, r *http.Request) {
+	scope, ok := parseScope(w, r)
+	if !ok {
+		return
+	}
+	err := h.service.ResetLimits(scope, mux.Vars(r)["id"])
+	if errors.Is(err, ErrNotFound) {
+		middleware.WriteError(w, "NotFound", http.StatusNotFound, "Quota not found", nil)
+		return
+	} else if err != nil {
+		middleware.WriteError(w, "DatabaseError", http.StatusInternalServerError, "Failed to reset quota", err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// History returns the allocations of a user, organisation or playground and
+// the resource-hours they used in a period
+func (h *Handler) History(w http.ResponseWriter, r *http.Request) {
+	scope, ok := parseScope(w, r)
+	if !ok {
+		return
+	}
+
+	q := r.URL.Query()
+	until := time.Now()
+	var err error
+	if v := q.Get("until"); v != "" {
+		if until, err = time.Parse(time.RFC3339, v); err != nil {
+			middleware.WriteError(w, "ValidationError", http.StatusBadRequest, "Invalid until", err)
+			return
+		}
+	}
+	since := until.AddDate(0, 0, -30)
+	if v := q.Get("since"); v != "" {
+		if since, err = time.Parse(time.RFC3339, v); err != nil {
+			middleware.WriteError(w, "ValidationError", http.StatusBadRequest, "Invalid since", err)
+			return
+		}
+	}
+	if !since.Before(until) {
+		middleware.WriteError(w, "ValidationError", http.StatusBadRequest, "since must be before until", nil)
+		return
+	}
+
+	allocations, summary, err := h.service.History(scope, mux.Vars(r)["id"], since, until)
+	if err != nil {
+		middleware.WriteError(w, "DatabaseError", http.StatusInternalServerError, "Failed to get usage history", err)
+		return
+	}
+	if allocations == nil {
+		allocations = []*Allocation{}
+	}
+	middleware.WriteJSON(w, http.StatusOK, HistoryResponse{Allocations: allocations, Summary: summary})
+}
+
+func parseScope(w http.ResponseWriter, r *http.Request) (Scope, bool) {
+	scope := Scope(mux.Vars(r)["scope"])
+	if !scope.Valid() {
+		middleware.WriteError(w, "ValidationError", http.StatusBadRequest, fmt.Sprintf("Unknown scope %q", scope), nil)
+		return "", false
+	}
+	return scope, true
+}
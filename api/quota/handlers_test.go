@@ -0,0 +1,107 @@
+package quota
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/ringo380/lessoncraft/api/apitest"
+	"github.com/ringo380/lessoncraft/api/auth"
+	"github.com/ringo380/lessoncraft/pwd/types"
+	"github.com/stretchr/testify/assert"
+)
+
+type quotaTestEnv struct {
+	service *Service
+	jwt     *auth.JWTService
+	router  *mux.Router
+}
+
+func newQuotaTestEnv() *quotaTestEnv {
+	env := &quotaTestEnv{
+		service: NewService(NewMemoryStore(), Policy{User: Limits{Sessions: 1}}),
+		jwt:     auth.NewJWTService("secret", "lessoncraft", time.Hour),
+	}
+	env.router = mux.NewRouter()
+	NewHandler(env.service, env.jwt).RegisterRoutes(env.router)
+	return env
+}
+
+func (e *quotaTestEnv) do(t *testing.T, userID string, role auth.Role, method, path string, body interface{}) *httptest.ResponseRecorder {
+	token, _, err := e.jwt.GenerateToken(userID, userID+"@example.com", []auth.Role{role})
+	assert.Nil(t, err)
+
+	return apitest.Do(e.router, token, method, path, body)
+}
+
+func TestHandler_Quotas(t *testing.T) {
+	env := newQuotaTestEnv()
+	assert.Nil(t, env.service.AcquireSession(&types.Session{Id: "s1", UserId: "alice"}))
+
+	rr := env.do(t, "alice", auth.RoleLearner, "GET", "/api/quotas/me", nil)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	var status Status
+	json.NewDecoder(rr.Body).Decode(&status)
+	assert.Equal(t, "alice", status.ID)
+	assert.Equal(t, 1, status.Limits.Sessions)
+	assert.Equal(t, 1, status.Usage.Sessions)
+
+	// Only administrators can see and change quotas of others
+	rr = env.do(t, "alice", auth.RoleLearner, "PUT", "/api/quotas/user/alice", Limits{Sessions: 10})
+	assert.Equal(t, http.StatusForbidden, rr.Code)
+	rr = env.do(t, "alice", auth.RoleLearner, "GET", "/api/quotas/user/bob", nil)
+	assert.Equal(t, http.StatusForbidden, rr.Code)
+
+	rr = env.do(t, "admin", auth.RoleAdmin, "PUT", "/api/quotas/user/alice", Limits{Sessions: -1})
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	rr = env.do(t, "admin", auth.RoleAdmin, "PUT", "/api/quotas/team/alice", Limits{Sessions: 2})
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+
+	rr = env.do(t, "admin", auth.RoleAdmin, "PUT", "/api/quotas/user/alice", Limits{Sessions: 2})
+	assert.Equal(t, http.StatusOK, rr.Code)
+	var q Quota
+	json.NewDecoder(rr.Body).Decode(&q)
+	assert.Equal(t, "admin", q.UpdatedBy)
+	assert.Nil(t, env.service.AcquireSession(&types.Session{Id: "s2", UserId: "alice"}))
+
+	rr = env.do(t, "admin", auth.RoleAdmin, "GET", "/api/quotas/user", nil)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	var quotas []*Quota
+	json.NewDecoder(rr.Body).Decode(&quotas)
+	assert.Len(t, quotas, 1)
+
+	rr = env.do(t, "admin", auth.RoleAdmin, "GET", "/api/quotas/user/alice", nil)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	json.NewDecoder(rr.Body).Decode(&status)
+	assert.False(t, status.Default)
+	assert.Equal(t, 2, status.Usage.Sessions)
+
+	rr = env.do(t, "admin", auth.RoleAdmin, "DELETE", "/api/quotas/user/alice", nil)
+	assert.Equal(t, http.StatusNoContent, rr.Code)
+	rr = env.do(t, "admin", auth.RoleAdmin, "DELETE", "/api/quotas/user/alice", nil)
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+}
+
+func TestHandler_History(t *testing.T) {
+	env := newQuotaTestEnv()
+	session := &types.Session{Id: "s1", UserId: "alice"}
+	assert.Nil(t, env.service.AcquireSession(session))
+	assert.Nil(t, env.service.ReleaseSession(session))
+
+	rr := env.do(t, "admin", auth.RoleAdmin, "GET", "/api/quotas/user/alice/history", nil)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	var history HistoryResponse
+	json.NewDecoder(rr.Body).Decode(&history)
+	assert.Len(t, history.Allocations, 1)
+	assert.NotNil(t, history.Summary)
+
+	rr = env.do(t, "admin", auth.RoleAdmin, "GET", "/api/quotas/user/alice/history?since=yesterday", nil)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	rr = env.do(t, "admin", auth.RoleAdmin, "GET", "/api/quotas/user/alice/history?since=2024-02-01T00:00:00Z&until=2024-01-01T00:00:00Z", nil)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	rr = env.do(t, "alice", auth.RoleLearner, "GET", "/api/quotas/user/alice/history", nil)
+	assert.Equal(t, http.StatusForbidden, rr.Code)
+}
@@ -0,0 +1,149 @@
+package quota
+
+import (
+	"sort"
+	"sync"
+)
+
+type quotaKey struct {
+	scope Scope
+	id    string
+}
+
+// MemoryStore is an in-memory implementation of the Store interface
+type MemoryStore struct {
+	quotas      map[quotaKey]*Quota
+	allocations map[string]*Allocation
+	mu          sync.RWMutex
+}
+
+// NewMemoryStore creates a new in-memory quota store
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		quotas:      make(map[quotaKey]*Quota),
+		allocations: make(map[string]*Allocation),
+	}
+}
+
+// GetQuota retrieves the quota of a user, organisation or playground
+func (s *MemoryStore) GetQuota(scope Scope, id string) (*Quota, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	q, ok := s.quotas[quotaKey{scope, id}]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	c := *q
+	return &c, nil
+}
+
+// PutQuota creates or replaces a quota
+func (s *MemoryStore) PutQuota(q *Quota) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c := *q
+	s.quotas[quotaKey{q.Scope, q.ID}] = &c
+	return nil
+}
+
+// DeleteQuota deletes a quota, so the default limits apply again
+func (s *MemoryStore) DeleteQuota(scope Scope, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.quotas[quotaKey{scope, id}]; !ok {
+		return ErrNotFound
+	}
+	delete(s.quotas, quotaKey{scope, id})
+	return nil
+}
+
+// ListQuotas retrieves the quotas of a scope
+func (s *MemoryStore) ListQuotas(scope Scope) ([]*Quota, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	quotas := []*Quota{}
+	for key, q := range s.quotas {
+		if key.scope == scope {
+			c := *q
+			quotas = append(quotas, &c)
+		}
+	}
+	sort.Slice(quotas, func(i, j int) bool { return quotas[i].ID < quotas[j].ID })
+	return quotas, nil
+}
+
+// CreateAllocation records a new allocation
+func (s *MemoryStore) CreateAllocation(a *Allocation) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.allocations[a.ID]; ok {
+		return ErrAlreadyExists
+	}
+	c := *a
+	s.allocations[a.ID] = &c
+	return nil
+}
+
+// GetAllocation retrieves an allocation by ID
+func (s *MemoryStore) GetAllocation(id string) (*Allocation, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	a, ok := s.allocations[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	c := *a
+	return &c, nil
+}
+
+// UpdateAllocation replaces an allocation
+func (s *MemoryStore) UpdateAllocation(a *Allocation) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.allocations[a.ID]; !ok {
+		return ErrNotFound
+	}
+	c := *a
+	s.allocations[a.ID] = &c
+	return nil
+}
+
+// DeleteAllocation deletes an allocation that was never used
+func (s *MemoryStore) DeleteAllocation(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.allocations[id]; !ok {
+		return ErrNotFound
+	}
+	delete(s.allocations, id)
+	return nil
+}
+
+// ListAllocations retrieves the allocations matching a filter, oldest first
+func (s *MemoryStore) ListAllocations(f AllocationFilter) ([]*Allocation, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	allocations := []*Allocation{}
+	for _, a := range s.allocations {
+		if f.Match(a) {
+			c := *a
+			allocations = append(allocations, &c)
+		}
+	}
+	sort.Slice(allocations, func(i, j int) bool {
+		if allocations[i].StartedAt.Equal(allocations[j].StartedAt) {
+			return allocations[i].ID < allocations[j].ID
+		}
+		return allocations[i].StartedAt.Before(allocations[j].StartedAt)
+	})
+	return allocations, nil
+}
@@ -0,0 +1,240 @@
+package quota
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Scope is what a quota applies to
+type Scope string
+
+const (
+	// ScopeUser quotas limit the sessions and instances of a user
+	ScopeUser Scope = "user"
+	// ScopeOrg quotas limit the sessions and instances of all members of an organisation
+	ScopeOrg Scope = "org"
+	// ScopePlayground quotas limit the sessions and instances run in a playground
+	ScopePlayground Scope = "playground"
+)
+
+// Valid checks if the scope is known
+func (s Scope) Valid() bool {
+	return s == ScopeUser || s == ScopeOrg || s == ScopePlayground
+}
+
+// Resource is a quantity limited by quotas
+type Resource string
+
+const (
+	ResourceSessions  Resource = "sessions"
+	ResourceInstances Resource = "instances"
+	ResourceCPUs      Resource = "cpus"
+	ResourceMemory    Resource = "memory_mb"
+	ResourceStorage   Resource = "storage_mb"
+)
+
+// Limits are the resources that can be in use at the same time. Zero values
+// mean no limit.
+type Limits struct {
+	Sessions  int     `json:"sessions,omitempty"`
+	Instances int     `json:"instances,omitempty"`
+	CPUs      float64 `json:"cpus,omitempty"`
+	MemoryMB  int64   `json:"memory_mb,omitempty"`
+	StorageMB int64   `json:"storage_mb,omitempty"`
+}
+
+// Validate checks that no limit is negative
+func (l Limits) Validate() error {
+	if l.Sessions < 0 || l.Instances < 0 || l.CPUs < 0 || l.MemoryMB < 0 || l.StorageMB < 0 {
+		return errors.New("limits cannot be negative")
+	}
+	return nil
+}
+
+// Quota overrides the default limits of a scope for one user, organisation or playground
+type Quota struct {
+	Scope     Scope     `json:"scope"`
+	ID        string    `json:"id"`
+	Limits    Limits    `json:"limits"`
+	UpdatedAt time.Time `json:"updated_at"`
+	UpdatedBy string    `json:"updated_by,omitempty"`
+}
+
+// Allocation records the resources of a session or an instance while it runs.
+// Ended allocations are the usage history used for chargeback.
+type Allocation struct {
+	ID           string `json:"id"`
+	SessionID    string `json:"session_id"`
+	InstanceName string `json:"instance_name,omitempty"`
+	UserID       string `json:"user_id,omitempty"`
+	OrgID        string `json:"org_id,omitempty"`
+	PlaygroundID string `json:"playground_id,omitempty"`
+
+	Usage
+	StartedAt time.Time `json:"started_at"`
+	// EndedAt is zero while the session or instance runs
+	EndedAt time.Time `json:"ended_at,omitempty"`
+}
+
+// Open checks if the allocation is still in use
+func (a *Allocation) Open() bool {
+	return a.EndedAt.IsZero()
+}
+
+// owner returns the ID of the scope the allocation is counted in
+func (a *Allocation) owner(scope Scope) string {
+	switch scope {
+	case ScopeUser:
+		return a.UserID
+	case ScopeOrg:
+		return a.OrgID
+	case ScopePlayground:
+		return a.PlaygroundID
+	}
+	return ""
+}
+
+// Usage is an amount of each resource
+type Usage struct {
+	Sessions  int     `json:"sessions"`
+	Instances int     `json:"instances"`
+	CPUs      float64 `json:"cpus"`
+	MemoryMB  int64   `json:"memory_mb"`
+	StorageMB int64   `json:"storage_mb"`
+}
+
+func (u *Usage) add(o Usage) {
+	u.Sessions += o.Sessions
+	u.Instances += o.Instances
+	u.CPUs += o.CPUs
+	u.MemoryMB += o.MemoryMB
+	u.StorageMB += o.StorageMB
+}
+
+// exceeded returns the first resource the usage is over its limit for
+func (u Usage) exceeded(l Limits) (Resource, float64, float64) {
+	switch {
+	case l.Sessions > 0 && u.Sessions > l.Sessions:
+		return ResourceSessions, float64(l.Sessions), float64(u.Sessions)
+	case l.Instances > 0 && u.Instances > l.Instances:
+		return ResourceInstances, float64(l.Instances), float64(u.Instances)
+	case l.CPUs > 0 && u.CPUs > l.CPUs:
+		return ResourceCPUs, l.CPUs, u.CPUs
+	case l.MemoryMB > 0 && u.MemoryMB > l.MemoryMB:
+		return ResourceMemory, float64(l.MemoryMB), float64(u.MemoryMB)
+	case l.StorageMB > 0 && u.StorageMB > l.StorageMB:
+		return ResourceStorage, float64(l.StorageMB), float64(u.StorageMB)
+	}
+	return "", 0, 0
+}
+
+// Status is the quota of a user, organisation or playground and how much of it is used
+type Status struct {
+	Scope  Scope  `json:"scope"`
+	ID     string `json:"id"`
+	Limits Limits `json:"limits"`
+	// Default is set when no quota overrides the default limits of the scope
+	Default bool  `json:"default"`
+	Usage   Usage `json:"usage"`
+}
+
+// Summary totals the usage history of a period for chargeback. Hours are
+// resource-hours, e.g. an instance with 2 CPUs running for 3 hours is 6 CPU hours.
+type Summary struct {
+	Since          time.Time `json:"since"`
+	Until          time.Time `json:"until"`
+	SessionHours   float64   `json:"session_hours"`
+	InstanceHours  float64   `json:"instance_hours"`
+	CPUHours       float64   `json:"cpu_hours"`
+	MemoryMBHours  float64   `json:"memory_mb_hours"`
+	StorageMBHours float64   `json:"storage_mb_hours"`
+}
+
+func (s *Summary) add(a *Allocation, hours float64) {
+	s.SessionHours += float64(a.Sessions) * hours
+	s.InstanceHours += float64(a.Instances) * hours
+	s.CPUHours += a.CPUs * hours
+	s.MemoryMBHours += float64(a.MemoryMB) * hours
+	s.StorageMBHours += float64(a.StorageMB) * hours
+}
+
+// Policy holds the limits used when no quota is set, and the resources charged
+// for instances that do not set their own limits
+type Policy struct {
+	User       Limits
+	Org        Limits
+	Playground Limits
+
+	// InstanceCPUs, InstanceMemoryMB and InstanceStorageMB are charged for
+	// instances without the corresponding limit
+	InstanceCPUs      float64
+	InstanceMemoryMB  int64
+	InstanceStorageMB int64
+}
+
+// DefaultPolicy returns the policy used when none is configured
+func DefaultPolicy() Policy {
+	return Policy{
+		User:              Limits{Sessions: 3, Instances: 10},
+		InstanceCPUs:      1,
+		InstanceMemoryMB:  1024,
+		InstanceStorageMB: 10 * 1024,
+	}
+}
+
+// defaults returns the default limits of a scope
+func (p Policy) defaults(scope Scope) Limits {
+	switch scope {
+	case ScopeUser:
+		return p.User
+	case ScopeOrg:
+		return p.Org
+	case ScopePlayground:
+		return p.Playground
+	}
+	return Limits{}
+}
+
+// ExceededError is returned when a session or instance does not fit in a quota
+type ExceededError struct {
+	Scope    Scope    `json:"scope"`
+	ID       string   `json:"id"`
+	Resource Resource `json:"resource"`
+	Limit    float64  `json:"limit"`
+	// Requested is the usage the session or instance would have brought the scope to
+	Requested float64 `json:"requested"`
+}
+
+func (e *ExceededError) Error() string {
+	return fmt.Sprintf("quota of %s [%s] exceeded: %s limit is %g, %g requested", e.Scope, e.ID, e.Resource, e.Limit, e.Requested)
+}
+
+// IsExceeded checks if an error is caused by a quota being exceeded
+func IsExceeded(err error) bool {
+	var e *ExceededError
+	return errors.As(err, &e)
+}
+
+// ParseStorageMB converts a storage size such as 10G, 512M or 1Ti to megabytes.
+// Sizes without a unit are in bytes.
+func ParseStorageMB(size string) (int64, error) {
+	s := strings.TrimSuffix(strings.TrimSuffix(strings.ToUpper(strings.TrimSpace(size)), "B"), "I")
+	if s == "" {
+		return 0, nil
+	}
+	units := map[byte]float64{'K': 1.0 / 1024, 'M': 1, 'G': 1024, 'T': 1024 * 1024}
+	multiplier, found := units[s[len(s)-1]]
+	if found {
+		s = s[:len(s)-1]
+	} else {
+		multiplier = 1.0 / (1024 * 1024)
+	}
+	n, err := strconv.ParseFloat(s, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid storage size %q", size)
+	}
+	return int64(n*multiplier + 0.5), nil
+}
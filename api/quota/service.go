@@ -0,0 +1,269 @@
+package quota
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/ringo380/lessoncraft/pwd/types"
+)
+
+// scopes are the scopes every allocation is checked against
+var scopes = []Scope{ScopeUser, ScopeOrg, ScopePlayground}
+
+// Service admits sessions and instances within the quotas of their users,
+// organisations and playgrounds, and records what they use while they run
+type Service struct {
+	store  Store
+	policy Policy
+	now    func() time.Time
+
+	// mu serializes admission decisions, so concurrent sessions of a user
+	// cannot exceed a quota together
+	mu sync.Mutex
+}
+
+// NewService creates a new quota service
+func NewService(store Store, policy Policy) *Service {
+	return &Service{store: store, policy: policy, now: time.Now}
+}
+
+// AcquireSession records a new session, or returns an *ExceededError if it
+// does not fit in a quota
+func (s *Service) AcquireSession(session *types.Session) error {
+	a := newAllocation(session)
+	a.ID = "session-" + session.Id
+	a.Sessions = 1
+	return s.acquire(a)
+}
+
+// ReleaseSession ends the allocations of a session and of its instances
+func (s *Service) ReleaseSession(session *types.Session) error {
+	allocations, err := s.store.ListAllocations(AllocationFilter{SessionID: session.Id, Open: true})
+	if err != nil {
+		return err
+	}
+	for _, a := range allocations {
+		if err := s.end(a); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// AcquireInstance reserves the resources of a new instance, or returns an
+// *ExceededError if they do not fit in a quota. The reservation is bound to the
+// instance once it is created, or cancelled if it could not be.
+func (s *Service) AcquireInstance(session *types.Session, conf types.InstanceConfig) (string, error) {
+	a := newAllocation(session)
+	a.ID = uuid.New().String()
+	a.Instances = 1
+	a.CPUs = conf.MaxCPUs
+	if a.CPUs == 0 {
+		a.CPUs = s.policy.InstanceCPUs
+	}
+	a.MemoryMB = conf.MaxMemoryMB
+	if a.MemoryMB == 0 {
+		a.MemoryMB = s.policy.InstanceMemoryMB
+	}
+	storage, err := ParseStorageMB(conf.StorageSize)
+	if err != nil {
+		return "", err
+	}
+	a.StorageMB = storage
+	if a.StorageMB == 0 {
+		a.StorageMB = s.policy.InstanceStorageMB
+	}
+
+	if err := s.acquire(a); err != nil {
+		return "", err
+	}
+	return a.ID, nil
+}
+
+// BindInstance records the name of the instance a reservation was made for
+func (s *Service) BindInstance(id, instanceName string) error {
+	a, err := s.store.GetAllocation(id)
+	if err != nil {
+		return err
+	}
+	a.InstanceName = instanceName
+	return s.store.UpdateAllocation(a)
+}
+
+// Cancel removes a reservation whose instance could not be created, so it
+// does not appear in the usage history
+func (s *Service) Cancel(id string) error {
+	return s.store.DeleteAllocation(id)
+}
+
+// ReleaseInstance ends the allocation of an instance
+func (s *Service) ReleaseInstance(session *types.Session, instanceName string) error {
+	allocations, err := s.store.ListAllocations(AllocationFilter{SessionID: session.Id, Open: true})
+	if err != nil {
+		return err
+	}
+	for _, a := range allocations {
+		if a.InstanceName == instanceName {
+			return s.end(a)
+		}
+	}
+	return nil
+}
+
+func newAllocation(session *types.Session) *Allocation {
+	return &Allocation{
+		SessionID:    session.Id,
+		UserID:       session.UserId,
+		OrgID:        session.OrgId,
+		PlaygroundID: session.PlaygroundId,
+	}
+}
+
+func (s *Service) acquire(a *Allocation) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, scope := range scopes {
+		id := a.owner(scope)
+		if id == "" {
+			continue
+		}
+		limits, _, err := s.limits(scope, id)
+		if err != nil {
+			return err
+		}
+		if limits == (Limits{}) {
+			continue
+		}
+		usage, err := s.usage(scope, id)
+		if err != nil {
+			return err
+		}
+		usage.add(a.Usage)
+		if resource, limit, requested := usage.exceeded(limits); resource != "" {
+			return &ExceededError{Scope: scope, ID: id, Resource: resource, Limit: limit, Requested: requested}
+		}
+	}
+
+	a.StartedAt = s.now()
+	return s.store.CreateAllocation(a)
+}
+
+func (s *Service) end(a *Allocation) error {
+	a.EndedAt = s.now()
+	return s.store.UpdateAllocation(a)
+}
+
+// limits returns the limits of a user, organisation or playground and whether
+// they are the defaults of the scope
+func (s *Service) limits(scope Scope, id string) (Limits, bool, error) {
+	q, err := s.store.GetQuota(scope, id)
+	if errors.Is(err, ErrNotFound) {
+		return s.policy.defaults(scope), true, nil
+	} else if err != nil {
+		return Limits{}, false, err
+	}
+	return q.Limits, false, nil
+}
+
+// usage adds up the open allocations of a user, organisation or playground
+func (s *Service) usage(scope Scope, id string) (Usage, error) {
+	allocations, err := s.store.ListAllocations(AllocationFilter{Scope: scope, ID: id, Open: true})
+	if err != nil {
+		return Usage{}, err
+	}
+	var usage Usage
+	for _, a := range allocations {
+		usage.add(a.Usage)
+	}
+	return usage, nil
+}
+
+// Status returns the limits of a user, organisation or playground and how
+// much of them is in use
+func (s *Service) Status(scope Scope, id string) (*Status, error) {
+	if !scope.Valid() {
+		return nil, fmt.Errorf("unknown scope %q", scope)
+	}
+	limits, isDefault, err := s.limits(scope, id)
+	if err != nil {
+		return nil, err
+	}
+	usage, err := s.usage(scope, id)
+	if err != nil {
+		return nil, err
+	}
+	return &Status{Scope: scope, ID: id, Limits: limits, Default: isDefault, Usage: usage}, nil
+}
+
+// SetLimits overrides the default limits for a user, organisation or
+// playground. Sessions and instances already running are not affected.
+func (s *Service) SetLimits(scope Scope, id string, limits Limits, updatedBy string) (*Quota, error) {
+	if !scope.Valid() {
+		return nil, fmt.Errorf("unknown scope %q", scope)
+	}
+	if err := limits.Validate(); err != nil {
+		return nil, err
+	}
+	q := &Quota{Scope: scope, ID: id, Limits: limits, UpdatedAt: s.now(), UpdatedBy: updatedBy}
+	if err := s.store.PutQuota(q); err != nil {
+		return nil, err
+	}
+	log.Printf("Quota of %s [%s] set to %+v by [%s]\n", scope, id, limits, updatedBy)
+	return q, nil
+}
+
+// Quotas returns the quotas set for a scope
+func (s *Service) Quotas(scope Scope) ([]*Quota, error) {
+	if !scope.Valid() {
+		return nil, fmt.Errorf("unknown scope %q", scope)
+	}
+	return s.store.ListQuotas(scope)
+}
+
+// ResetLimits removes the quota of a user, organisation or playground, so the
+// default limits of its scope apply again
+func (s *Service) ResetLimits(scope Scope, id string) error {
+	return s.store.DeleteQuota(scope, id)
+}
+
+// History returns the allocations of a user, organisation or playground in use
+// between since and until, and their usage in that period
+func (s *Service) History(scope Scope, id string, since, until time.Time) ([]*Allocation, *Summary, error) {
+	if !scope.Valid() {
+		return nil, nil, fmt.Errorf("unknown scope %q", scope)
+	}
+	now := s.now()
+	if until.IsZero() || until.After(now) {
+		until = now
+	}
+	if !since.Before(until) {
+		return nil, nil, errors.New("since must be before until")
+	}
+
+	allocations, err := s.store.ListAllocations(AllocationFilter{Scope: scope, ID: id, Since: since, Until: until})
+	if err != nil {
+		return nil, nil, err
+	}
+	summary := &Summary{Since: since, Until: until}
+	for _, a := range allocations {
+		start, end := a.StartedAt, a.EndedAt
+		if a.Open() {
+			end = now
+		}
+		if start.Before(since) {
+			start = since
+		}
+		if end.After(until) {
+			end = until
+		}
+		if end.After(start) {
+			summary.add(a, end.Sub(start).Hours())
+		}
+	}
+	return allocations, summary, nil
+}
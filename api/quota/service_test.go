@@ -0,0 +1,157 @@
+package quota
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ringo380/lessoncraft/pwd/types"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeClock struct {
+	t time.Time
+}
+
+func (c *fakeClock) now() time.Time {
+	return c.t
+}
+
+func newTestService(policy Policy) (*Service, *fakeClock) {
+	clock := &fakeClock{t: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	s := NewService(NewMemoryStore(), policy)
+	s.now = clock.now
+	return s, clock
+}
+
+func TestService_Sessions(t *testing.T) {
+	s, _ := newTestService(Policy{User: Limits{Sessions: 2}})
+
+	assert.Nil(t, s.AcquireSession(&types.Session{Id: "s1", UserId: "alice"}))
+	assert.Nil(t, s.AcquireSession(&types.Session{Id: "s2", UserId: "alice"}))
+	err := s.AcquireSession(&types.Session{Id: "s3", UserId: "alice"})
+	assert.True(t, IsExceeded(err))
+	assert.Equal(t, &ExceededError{Scope: ScopeUser, ID: "alice", Resource: ResourceSessions, Limit: 2, Requested: 3}, err)
+
+	// Other users have their own quota, anonymous sessions have none
+	assert.Nil(t, s.AcquireSession(&types.Session{Id: "s4", UserId: "bob"}))
+	assert.Nil(t, s.AcquireSession(&types.Session{Id: "s5"}))
+
+	assert.Nil(t, s.ReleaseSession(&types.Session{Id: "s1", UserId: "alice"}))
+	assert.Nil(t, s.AcquireSession(&types.Session{Id: "s3", UserId: "alice"}))
+}
+
+func TestService_Instances(t *testing.T) {
+	policy := DefaultPolicy()
+	policy.User = Limits{}
+	policy.Org = Limits{CPUs: 3}
+	s, _ := newTestService(policy)
+	session := &types.Session{Id: "s1", UserId: "alice", OrgId: "acme"}
+
+	id, err := s.AcquireInstance(session, types.InstanceConfig{MaxCPUs: 2})
+	assert.Nil(t, err)
+	assert.Nil(t, s.BindInstance(id, "s1_node1"))
+
+	// The default charge of one CPU fits, a second one does not
+	id, err = s.AcquireInstance(session, types.InstanceConfig{})
+	assert.Nil(t, err)
+	_, err = s.AcquireInstance(&types.Session{Id: "s2", UserId: "bob", OrgId: "acme"}, types.InstanceConfig{})
+	assert.True(t, IsExceeded(err))
+	assert.Equal(t, ResourceCPUs, err.(*ExceededError).Resource)
+	assert.Equal(t, ScopeOrg, err.(*ExceededError).Scope)
+
+	// Cancelled reservations free their resources and leave no history
+	assert.Nil(t, s.Cancel(id))
+	status, err := s.Status(ScopeOrg, "acme")
+	assert.Nil(t, err)
+	assert.Equal(t, Usage{Instances: 1, CPUs: 2, MemoryMB: 1024, StorageMB: 10240}, status.Usage)
+	assert.True(t, status.Default)
+
+	assert.Nil(t, s.ReleaseInstance(session, "s1_node1"))
+	status, err = s.Status(ScopeUser, "alice")
+	assert.Nil(t, err)
+	assert.Equal(t, Usage{}, status.Usage)
+
+	_, err = s.AcquireInstance(session, types.InstanceConfig{StorageSize: "lots"})
+	assert.NotNil(t, err)
+	assert.False(t, IsExceeded(err))
+}
+
+func TestService_SetLimits(t *testing.T) {
+	s, _ := newTestService(Policy{User: Limits{Sessions: 1}})
+
+	_, err := s.SetLimits(ScopeUser, "alice", Limits{Sessions: -1}, "admin")
+	assert.NotNil(t, err)
+	_, err = s.SetLimits("team", "alice", Limits{Sessions: 2}, "admin")
+	assert.NotNil(t, err)
+
+	q, err := s.SetLimits(ScopeUser, "alice", Limits{Sessions: 2}, "admin")
+	assert.Nil(t, err)
+	assert.Equal(t, "admin", q.UpdatedBy)
+	assert.Nil(t, s.AcquireSession(&types.Session{Id: "s1", UserId: "alice"}))
+	assert.Nil(t, s.AcquireSession(&types.Session{Id: "s2", UserId: "alice"}))
+
+	quotas, err := s.Quotas(ScopeUser)
+	assert.Nil(t, err)
+	assert.Len(t, quotas, 1)
+
+	// Running sessions are kept when the limits are lowered again
+	assert.Nil(t, s.ResetLimits(ScopeUser, "alice"))
+	assert.Equal(t, ErrNotFound, s.ResetLimits(ScopeUser, "alice"))
+	status, err := s.Status(ScopeUser, "alice")
+	assert.Nil(t, err)
+	assert.True(t, status.Default)
+	assert.Equal(t, 2, status.Usage.Sessions)
+	assert.True(t, IsExceeded(s.AcquireSession(&types.Session{Id: "s3", UserId: "alice"})))
+}
+
+func TestService_History(t *testing.T) {
+	s, clock := newTestService(Policy{})
+	start := clock.t
+	session := &types.Session{Id: "s1", UserId: "alice", PlaygroundId: "pg"}
+
+	assert.Nil(t, s.AcquireSession(session))
+	id, err := s.AcquireInstance(session, types.InstanceConfig{MaxCPUs: 2, MaxMemoryMB: 512, StorageSize: "1G"})
+	assert.Nil(t, err)
+	assert.Nil(t, s.BindInstance(id, "s1_node1"))
+
+	clock.t = start.Add(2 * time.Hour)
+	assert.Nil(t, s.ReleaseInstance(session, "s1_node1"))
+	clock.t = start.Add(3 * time.Hour)
+	assert.Nil(t, s.ReleaseSession(session))
+	clock.t = start.Add(10 * time.Hour)
+
+	allocations, summary, err := s.History(ScopeUser, "alice", start, time.Time{})
+	assert.Nil(t, err)
+	assert.Len(t, allocations, 2)
+	assert.Equal(t, 3.0, summary.SessionHours)
+	assert.Equal(t, 2.0, summary.InstanceHours)
+	assert.Equal(t, 4.0, summary.CPUHours)
+	assert.Equal(t, 1024.0, summary.MemoryMBHours)
+	assert.Equal(t, 2048.0, summary.StorageMBHours)
+	assert.Equal(t, clock.t, summary.Until)
+
+	// Periods clip the allocations in use during them
+	_, summary, err = s.History(ScopeUser, "alice", start.Add(time.Hour), start.Add(5*time.Hour))
+	assert.Nil(t, err)
+	assert.Equal(t, 2.0, summary.SessionHours)
+	assert.Equal(t, 2.0, summary.CPUHours)
+
+	allocations, _, err = s.History(ScopePlayground, "pg", start.Add(4*time.Hour), time.Time{})
+	assert.Nil(t, err)
+	assert.Empty(t, allocations)
+
+	_, _, err = s.History(ScopeUser, "alice", clock.t, start)
+	assert.NotNil(t, err)
+}
+
+func TestParseStorageMB(t *testing.T) {
+	for size, mb := range map[string]int64{"": 0, "10G": 10240, "512M": 512, "1Ti": 1048576, "5gb": 5120, "2048K": 2, "1048576": 1} {
+		n, err := ParseStorageMB(size)
+		assert.Nil(t, err, size)
+		assert.Equal(t, mb, n, size)
+	}
+	_, err := ParseStorageMB("10X")
+	assert.NotNil(t, err)
+	_, err = ParseStorageMB("-1G")
+	assert.NotNil(t, err)
+}
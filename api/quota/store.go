@@ -0,0 +1,69 @@
+package quota
+
+import (
+	"errors"
+	"time"
+)
+
+var (
+	// ErrNotFound is returned when a quota or an allocation does not exist
+	ErrNotFound = errors.New("not found")
+	// ErrAlreadyExists is returned when creating an allocation with an ID that is already in use
+	ErrAlreadyExists = errors.New("allocation already exists")
+)
+
+// AllocationFilter selects allocations. Zero fields match every allocation.
+type AllocationFilter struct {
+	Scope Scope
+	ID    string
+	// SessionID selects the allocations of a session
+	SessionID string
+	// Open selects the allocations still in use
+	Open bool
+	// Since and Until select the allocations in use at some point between them
+	Since time.Time
+	Until time.Time
+}
+
+// Match checks if an allocation is selected by the filter
+func (f AllocationFilter) Match(a *Allocation) bool {
+	if f.Scope != "" && a.owner(f.Scope) != f.ID {
+		return false
+	}
+	if f.SessionID != "" && a.SessionID != f.SessionID {
+		return false
+	}
+	if f.Open && !a.Open() {
+		return false
+	}
+	if !f.Since.IsZero() && !a.Open() && a.EndedAt.Before(f.Since) {
+		return false
+	}
+	if !f.Until.IsZero() && !a.StartedAt.Before(f.Until) {
+		return false
+	}
+	return true
+}
+
+// Store defines the interface for quota storage operations
+type Store interface {
+	// GetQuota retrieves the quota of a user, organisation or playground
+	GetQuota(scope Scope, id string) (*Quota, error)
+	// PutQuota creates or replaces a quota
+	PutQuota(q *Quota) error
+	// DeleteQuota deletes a quota, so the default limits apply again
+	DeleteQuota(scope Scope, id string) error
+	// ListQuotas retrieves the quotas of a scope
+	ListQuotas(scope Scope) ([]*Quota, error)
+
+	// CreateAllocation records a new allocation
+	CreateAllocation(a *Allocation) error
+	// GetAllocation retrieves an allocation by ID
+	GetAllocation(id string) (*Allocation, error)
+	// UpdateAllocation replaces an allocation
+	UpdateAllocation(a *Allocation) error
+	// DeleteAllocation deletes an allocation that was never used
+	DeleteAllocation(id string) error
+	// ListAllocations retrieves the allocations matching a filter, oldest first
+	ListAllocations(f AllocationFilter) ([]*Allocation, error)
+}
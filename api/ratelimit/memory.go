@@ -0,0 +1,70 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// sweepInterval is how often expired counters are dropped from a MemoryBackend
+const sweepInterval = time.Minute
+
+type window struct {
+	count int
+	reset time.Time
+}
+
+// MemoryBackend keeps counters in memory. Each replica counts on its own, so use
+// RedisBackend when running more than one.
+type MemoryBackend struct {
+	mu        sync.Mutex
+	windows   map[string]*window
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+// NewMemoryBackend creates a new MemoryBackend
+func NewMemoryBackend() *MemoryBackend {
+	return &MemoryBackend{
+		windows: make(map[string]*window),
+		now:     time.Now,
+	}
+}
+
+// Take counts a request against key and reports whether it is within limit
+func (b *MemoryBackend) Take(ctx context.Context, key string, limit int, length time.Duration) (Result, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := b.now()
+	if now.Sub(b.lastSweep) >= sweepInterval {
+		for k, w := range b.windows {
+			if !now.Before(w.reset) {
+				delete(b.windows, k)
+			}
+		}
+		b.lastSweep = now
+	}
+
+	w, ok := b.windows[key]
+	if !ok || !now.Before(w.reset) {
+		w = &window{reset: now.Add(length)}
+		b.windows[key] = w
+	}
+	w.count++
+
+	return newResult(w.count, limit, w.reset.Sub(now)), nil
+}
+
+func newResult(count, limit int, reset time.Duration) Result {
+	remaining := limit - count
+	if remaining < 0 {
+		remaining = 0
+	}
+	return Result{
+		Allowed:   count <= limit,
+		Limit:     limit,
+		Remaining: remaining,
+		Reset:     reset,
+	}
+}
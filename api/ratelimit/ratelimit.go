@@ -0,0 +1,257 @@
+// Package ratelimit limits requests per user, API key or client IP, with
+// policies that can differ per route. Counters live in a Backend, so replicas
+// sharing a Redis backend enforce the same limits.
+package ratelimit
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/ringo380/lessoncraft/api/auth"
+	"github.com/ringo380/lessoncraft/api/middleware"
+)
+
+// Policy allows Limit requests per Window for each key
+type Policy struct {
+	// Name identifies the policy in counter keys and the RateLimit-Policy header.
+	// Routes sharing a policy name share their counters.
+	Name   string
+	Limit  int
+	Window time.Duration
+}
+
+// Result is the state of a counter after taking a request from it
+type Result struct {
+	Allowed   bool
+	Limit     int
+	Remaining int
+	// Reset is the time until the current window ends
+	Reset time.Duration
+}
+
+// Backend stores the request counters. Counters use fixed windows: the first
+// request of a key starts a window of the given length, and the count resets
+// when the window ends.
+type Backend interface {
+	// Take counts a request against key and reports whether it is within limit
+	Take(ctx context.Context, key string, limit int, window time.Duration) (Result, error)
+}
+
+// KeyFunc identifies who a request is counted against. It returns "" when it
+// cannot identify the request, so the next KeyFunc is tried.
+type KeyFunc func(r *http.Request) string
+
+// UserKey identifies requests by the user authenticated by auth.AuthMiddleware.
+// Requests made with an API key are counted per key rather than per service account.
+func UserKey(r *http.Request) string {
+	if keyID, ok := auth.GetAPIKeyID(r); ok {
+		return "key:" + keyID
+	}
+	if userID, ok := auth.GetUserID(r); ok {
+		return "user:" + userID
+	}
+	return ""
+}
+
+// TokenKey identifies requests by the user or API key of their bearer token. It
+// reuses the user auth.IdentifyMiddleware or auth.AuthMiddleware put in the
+// request context, and only validates the token itself when there is none, so
+// it also works for limiters wrapping a whole router. Requests with invalid
+// tokens are not identified.
+func TokenKey(validator auth.TokenValidator) KeyFunc {
+	return func(r *http.Request) string {
+		if key := UserKey(r); key != "" {
+			return key
+		}
+		parts := strings.Split(r.Header.Get("Authorization"), " ")
+		if len(parts) != 2 || parts[0] != "Bearer" {
+			return ""
+		}
+		claims, err := validator.ValidateToken(parts[1])
+		if err != nil {
+			return ""
+		}
+		if claims.APIKeyID != "" {
+			return "key:" + claims.APIKeyID
+		}
+		return "user:" + claims.UserID
+	}
+}
+
+type routePolicy struct {
+	method string
+	path   string
+	policy Policy
+}
+
+// Limiter is an HTTP middleware that applies rate limit policies. Requests are
+// identified by the configured key functions, falling back to the client IP.
+type Limiter struct {
+	backend    Backend
+	policy     Policy
+	keyFuncs   []KeyFunc
+	trustProxy bool
+
+	mu     sync.RWMutex
+	routes []routePolicy
+}
+
+// NewLimiter creates a Limiter that applies the default policy to every route
+// without a more specific one
+func NewLimiter(backend Backend, defaultPolicy Policy) *Limiter {
+	return &Limiter{
+		backend:  backend,
+		policy:   defaultPolicy,
+		keyFuncs: []KeyFunc{UserKey},
+	}
+}
+
+// SetKeyFuncs replaces the functions used to identify requests. They are tried
+// in order; requests none of them identify are counted per client IP.
+func (l *Limiter) SetKeyFuncs(keyFuncs ...KeyFunc) {
+	l.keyFuncs = keyFuncs
+}
+
+// SetTrustProxy makes the limiter take the client IP from the X-Forwarded-For
+// header. Only enable it when the server is behind a proxy that sets the header,
+// otherwise clients can pick their own key.
+func (l *Limiter) SetTrustProxy(trust bool) {
+	l.trustProxy = trust
+}
+
+// LimitRoute applies a policy to a route instead of the default. path is the mux
+// path template the route was registered with, e.g. "/sessions/{sessionId}", and
+// method may be empty to match any method. A policy with a zero Limit disables
+// limiting for the route.
+func (l *Limiter) LimitRoute(method, path string, policy Policy) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for i, route := range l.routes {
+		if route.method == method && route.path == path {
+			l.routes[i].policy = policy
+			return
+		}
+	}
+	l.routes = append(l.routes, routePolicy{method: method, path: path, policy: policy})
+}
+
+// policyFor finds the policy of the route matched by mux, or the default policy.
+// The route is the one being served, or else the one router would match.
+func (l *Limiter) policyFor(r *http.Request, router *mux.Router) Policy {
+	path := r.URL.Path
+	route := mux.CurrentRoute(r)
+	if route == nil && router != nil {
+		var match mux.RouteMatch
+		if router.Match(r, &match) {
+			route = match.Route
+		}
+	}
+	if route != nil {
+		if template, err := route.GetPathTemplate(); err == nil {
+			path = template
+		}
+	}
+
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	policy, found := l.policy, false
+	for _, route := range l.routes {
+		if route.path != path {
+			continue
+		}
+		if route.method == r.Method {
+			return route.policy
+		}
+		if route.method == "" && !found {
+			policy, found = route.policy, true
+		}
+	}
+	return policy
+}
+
+func (l *Limiter) key(r *http.Request) string {
+	for _, f := range l.keyFuncs {
+		if key := f(r); key != "" {
+			return key
+		}
+	}
+	return "ip:" + l.clientIP(r)
+}
+
+func (l *Limiter) clientIP(r *http.Request) string {
+	if l.trustProxy {
+		if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
+			return strings.TrimSpace(strings.Split(forwarded, ",")[0])
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// Middleware limits requests to a handler registered on a mux route, which is
+// used to find the route policy. See Handler for the headers that are set.
+func (l *Limiter) Middleware(next http.Handler) http.Handler {
+	return l.limit(next, nil)
+}
+
+// Handler limits all requests to a router. It sets the RateLimit-Limit,
+// RateLimit-Remaining, RateLimit-Reset and RateLimit-Policy headers, and
+// Retry-After when a request is rejected. When the backend fails, requests are
+// let through rather than rejected.
+func (l *Limiter) Handler(router *mux.Router) http.Handler {
+	return l.limit(router, router)
+}
+
+func (l *Limiter) limit(next http.Handler, router *mux.Router) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		policy := l.policyFor(r, router)
+		if policy.Limit <= 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		key := policy.Name + ":" + l.key(r)
+		result, err := l.backend.Take(r.Context(), key, policy.Limit, policy.Window)
+		if err != nil {
+			log.Printf("Rate limit backend failed for %s, allowing request: %v", key, err)
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		reset := strconv.Itoa(int(math.Ceil(result.Reset.Seconds())))
+		w.Header().Set("RateLimit-Limit", strconv.Itoa(result.Limit))
+		w.Header().Set("RateLimit-Remaining", strconv.Itoa(result.Remaining))
+		w.Header().Set("RateLimit-Reset", reset)
+		w.Header().Set("RateLimit-Policy", fmt.Sprintf("%d;w=%d", policy.Limit, int(math.Ceil(policy.Window.Seconds()))))
+
+		if !result.Allowed {
+			w.Header().Set("Retry-After", reset)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusTooManyRequests)
+			json.NewEncoder(w).Encode(middleware.ErrorResponse{
+				Error:     "RateLimitExceeded",
+				Code:      http.StatusTooManyRequests,
+				Message:   "Too many requests",
+				RequestID: r.Header.Get("X-Request-ID"),
+				TimeStamp: time.Now(),
+			})
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,213 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/ringo380/lessoncraft/api/auth"
+	"github.com/stretchr/testify/assert"
+)
+
+type failingBackend struct{}
+
+func (failingBackend) Take(ctx context.Context, key string, limit int, window time.Duration) (Result, error) {
+	return Result{}, errors.New("connection refused")
+}
+
+func newTestRouter(l *Limiter) http.Handler {
+	r := mux.NewRouter()
+	ok := func(w http.ResponseWriter, r *http.Request) {}
+	r.HandleFunc("/", ok).Methods("GET", "POST")
+	r.HandleFunc("/sessions/{sessionId}/instances/{instanceName}/exec", ok).Methods("POST")
+	return l.Handler(r)
+}
+
+func request(r http.Handler, method, path, ip string, userID string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, nil)
+	req.RemoteAddr = ip + ":5000"
+	if userID != "" {
+		req = req.WithContext(context.WithValue(req.Context(), auth.UserContextKey, userID))
+	}
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+	return rr
+}
+
+func TestLimiter_Headers(t *testing.T) {
+	l := NewLimiter(NewMemoryBackend(), Policy{Name: "default", Limit: 2, Window: time.Minute})
+	r := newTestRouter(l)
+
+	rr := request(r, "GET", "/", "10.0.0.1", "")
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "2", rr.Header().Get("RateLimit-Limit"))
+	assert.Equal(t, "1", rr.Header().Get("RateLimit-Remaining"))
+	assert.Equal(t, "60", rr.Header().Get("RateLimit-Reset"))
+	assert.Equal(t, "2;w=60", rr.Header().Get("RateLimit-Policy"))
+
+	request(r, "GET", "/", "10.0.0.1", "")
+	rr = request(r, "GET", "/", "10.0.0.1", "")
+	assert.Equal(t, http.StatusTooManyRequests, rr.Code)
+	assert.Equal(t, "0", rr.Header().Get("RateLimit-Remaining"))
+	assert.Equal(t, "60", rr.Header().Get("Retry-After"))
+
+	// Other clients are not affected
+	rr = request(r, "GET", "/", "10.0.0.2", "")
+	assert.Equal(t, http.StatusOK, rr.Code)
+}
+
+func TestLimiter_Keys(t *testing.T) {
+	l := NewLimiter(NewMemoryBackend(), Policy{Name: "default", Limit: 1, Window: time.Minute})
+	r := newTestRouter(l)
+
+	// Authenticated users are counted separately even from the same address
+	assert.Equal(t, http.StatusOK, request(r, "GET", "/", "10.0.0.1", "alice").Code)
+	assert.Equal(t, http.StatusOK, request(r, "GET", "/", "10.0.0.1", "bob").Code)
+	assert.Equal(t, http.StatusTooManyRequests, request(r, "GET", "/", "10.0.0.2", "alice").Code)
+
+	// Custom key functions take precedence over the client IP
+	l.SetKeyFuncs(func(r *http.Request) string { return r.Header.Get("X-Session") })
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("X-Session", "s1")
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+}
+
+func TestLimiter_TokenKey(t *testing.T) {
+	jwt := auth.NewJWTService("secret", "lessoncraft", time.Hour)
+	l := NewLimiter(NewMemoryBackend(), Policy{Name: "default", Limit: 1, Window: time.Minute})
+	l.SetKeyFuncs(TokenKey(jwt))
+	r := newTestRouter(l)
+
+	withToken := func(userID string) int {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.RemoteAddr = "10.0.0.1:5000"
+		if userID != "" {
+			token, _, err := jwt.GenerateToken(userID, userID+"@example.com", []auth.Role{auth.RoleLearner})
+			assert.Nil(t, err)
+			req.Header.Set("Authorization", "Bearer "+token)
+		} else {
+			req.Header.Set("Authorization", "Bearer invalid")
+		}
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, req)
+		return rr.Code
+	}
+
+	// Users behind the same address are counted separately, without the
+	// request going through the auth middleware first
+	assert.Equal(t, http.StatusOK, withToken("alice"))
+	assert.Equal(t, http.StatusOK, withToken("bob"))
+	assert.Equal(t, http.StatusTooManyRequests, withToken("alice"))
+
+	// Invalid tokens are counted per client IP
+	assert.Equal(t, http.StatusOK, withToken(""))
+	assert.Equal(t, http.StatusTooManyRequests, withToken(""))
+}
+
+// countingValidator counts how often tokens are validated
+type countingValidator struct {
+	auth.TokenValidator
+	calls int
+}
+
+func (v *countingValidator) ValidateToken(token string) (*auth.TokenClaims, error) {
+	v.calls++
+	return v.TokenValidator.ValidateToken(token)
+}
+
+func TestLimiter_TokenKeyReusesContextUser(t *testing.T) {
+	jwt := auth.NewJWTService("secret", "lessoncraft", time.Hour)
+	validator := &countingValidator{TokenValidator: jwt}
+	l := NewLimiter(NewMemoryBackend(), Policy{Name: "default", Limit: 1, Window: time.Minute})
+	l.SetKeyFuncs(TokenKey(validator))
+
+	var seen string
+	r := mux.NewRouter()
+	r.Handle("/", auth.AuthMiddleware(validator)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen, _ = auth.GetUserID(r)
+	})))
+	h := auth.IdentifyMiddleware(validator)(l.Handler(r))
+
+	token, _, err := jwt.GenerateToken("alice", "alice@example.com", []auth.Role{auth.RoleLearner})
+	assert.Nil(t, err)
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "alice", seen)
+	// Neither the limiter nor the route validate the token again
+	assert.Equal(t, 1, validator.calls)
+}
+
+func TestLimiter_RoutePolicies(t *testing.T) {
+	l := NewLimiter(NewMemoryBackend(), Policy{Name: "default", Limit: 100, Window: time.Minute})
+	l.LimitRoute("POST", "/", Policy{Name: "session-create", Limit: 1, Window: time.Minute})
+	l.LimitRoute("", "/sessions/{sessionId}/instances/{instanceName}/exec", Policy{Name: "exec", Limit: 2, Window: time.Second})
+	r := newTestRouter(l)
+
+	assert.Equal(t, http.StatusOK, request(r, "POST", "/", "10.0.0.1", "").Code)
+	assert.Equal(t, http.StatusTooManyRequests, request(r, "POST", "/", "10.0.0.1", "").Code)
+	// Other methods on the same path use the default policy
+	rr := request(r, "GET", "/", "10.0.0.1", "")
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "100", rr.Header().Get("RateLimit-Limit"))
+
+	// Routes are matched by their template, so every instance shares the policy
+	assert.Equal(t, http.StatusOK, request(r, "POST", "/sessions/s1/instances/a/exec", "10.0.0.1", "").Code)
+	assert.Equal(t, http.StatusOK, request(r, "POST", "/sessions/s1/instances/b/exec", "10.0.0.1", "").Code)
+	assert.Equal(t, http.StatusTooManyRequests, request(r, "POST", "/sessions/s1/instances/c/exec", "10.0.0.1", "").Code)
+
+	// A zero limit disables limiting
+	l.LimitRoute("POST", "/", Policy{})
+	rr = request(r, "POST", "/", "10.0.0.1", "")
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Empty(t, rr.Header().Get("RateLimit-Limit"))
+}
+
+func TestLimiter_Middleware(t *testing.T) {
+	l := NewLimiter(NewMemoryBackend(), Policy{Name: "default", Limit: 100, Window: time.Minute})
+	l.LimitRoute("POST", "/lessons/{id}", Policy{Name: "lesson", Limit: 1, Window: time.Minute})
+
+	r := mux.NewRouter()
+	r.Handle("/lessons/{id}", l.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))).Methods("POST")
+
+	assert.Equal(t, http.StatusOK, request(r, "POST", "/lessons/a", "10.0.0.1", "").Code)
+	assert.Equal(t, http.StatusTooManyRequests, request(r, "POST", "/lessons/b", "10.0.0.1", "").Code)
+}
+
+func TestLimiter_BackendFailure(t *testing.T) {
+	l := NewLimiter(failingBackend{}, Policy{Name: "default", Limit: 1, Window: time.Minute})
+	r := newTestRouter(l)
+
+	// Requests are let through when counters are unavailable
+	assert.Equal(t, http.StatusOK, request(r, "GET", "/", "10.0.0.1", "").Code)
+	assert.Equal(t, http.StatusOK, request(r, "GET", "/", "10.0.0.1", "").Code)
+}
+
+func TestMemoryBackend_Window(t *testing.T) {
+	now := time.Now()
+	b := NewMemoryBackend()
+	b.now = func() time.Time { return now }
+
+	result, _ := b.Take(context.Background(), "k", 1, time.Minute)
+	assert.True(t, result.Allowed)
+	result, _ = b.Take(context.Background(), "k", 1, time.Minute)
+	assert.False(t, result.Allowed)
+
+	now = now.Add(time.Minute)
+	result, _ = b.Take(context.Background(), "k", 1, time.Minute)
+	assert.True(t, result.Allowed)
+
+	// Expired windows are swept
+	now = now.Add(2 * time.Minute)
+	b.Take(context.Background(), "other", 1, time.Minute)
+	assert.Len(t, b.windows, 1)
+}
@@ -0,0 +1,257 @@
+package ratelimit
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"time"
+)
+
+const (
+	defaultRedisTimeout = 2 * time.Second
+	defaultRedisIdle    = 8
+)
+
+// RedisConfig configures a RedisBackend
+type RedisConfig struct {
+	// Addr is the host:port of the server
+	Addr     string
+	Password string
+	DB       int
+	// KeyPrefix is prepended to every counter key, default "ratelimit:"
+	KeyPrefix string
+	// Timeout bounds dialing and each round trip, default 2s
+	Timeout time.Duration
+	// MaxIdle is the number of connections kept open between requests, default 8
+	MaxIdle int
+}
+
+// RedisBackend keeps counters in a server speaking the Redis protocol, so all
+// replicas using the same server share their limits. It only needs the SET, INCR,
+// PTTL and PEXPIRE commands.
+type RedisBackend struct {
+	config RedisConfig
+	idle   chan *redisConn
+}
+
+// NewRedisBackend creates a RedisBackend. Connections are opened on demand, so an
+// unreachable server is only reported by Take.
+func NewRedisBackend(config RedisConfig) *RedisBackend {
+	if config.KeyPrefix == "" {
+		config.KeyPrefix = "ratelimit:"
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = defaultRedisTimeout
+	}
+	if config.MaxIdle <= 0 {
+		config.MaxIdle = defaultRedisIdle
+	}
+	return &RedisBackend{
+		config: config,
+		idle:   make(chan *redisConn, config.MaxIdle),
+	}
+}
+
+// Take counts a request against key and reports whether it is within limit
+func (b *RedisBackend) Take(ctx context.Context, key string, limit int, window time.Duration) (Result, error) {
+	conn, err := b.get(ctx)
+	if err != nil {
+		return Result{}, err
+	}
+
+	key = b.config.KeyPrefix + key
+	ms := strconv.FormatInt(window.Milliseconds(), 10)
+
+	// Start the window if it does not exist, then count the request. INCR keeps the
+	// expiry set by SET, and the three commands are sent in one round trip.
+	replies, err := conn.pipeline(b.deadline(ctx),
+		[]string{"SET", key, "0", "PX", ms, "NX"},
+		[]string{"INCR", key},
+		[]string{"PTTL", key},
+	)
+	if err != nil {
+		conn.Close()
+		return Result{}, err
+	}
+
+	count, ok1 := replies[1].(int64)
+	ttl, ok2 := replies[2].(int64)
+	if !ok1 || !ok2 {
+		conn.Close()
+		return Result{}, fmt.Errorf("unexpected reply to rate limit commands: %v", replies)
+	}
+	if ttl < 0 {
+		// The window expired between SET and INCR, which left the key without expiry
+		if _, err := conn.pipeline(b.deadline(ctx), []string{"PEXPIRE", key, ms}); err != nil {
+			conn.Close()
+			return Result{}, err
+		}
+		ttl = window.Milliseconds()
+	}
+
+	b.put(conn)
+	return newResult(int(count), limit, time.Duration(ttl)*time.Millisecond), nil
+}
+
+// Close closes the idle connections
+func (b *RedisBackend) Close() error {
+	for {
+		select {
+		case conn := <-b.idle:
+			conn.Close()
+		default:
+			return nil
+		}
+	}
+}
+
+func (b *RedisBackend) deadline(ctx context.Context) time.Time {
+	deadline := time.Now().Add(b.config.Timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		return d
+	}
+	return deadline
+}
+
+func (b *RedisBackend) get(ctx context.Context) (*redisConn, error) {
+	select {
+	case conn := <-b.idle:
+		return conn, nil
+	default:
+	}
+
+	dialer := net.Dialer{Timeout: b.config.Timeout}
+	c, err := dialer.DialContext(ctx, "tcp", b.config.Addr)
+	if err != nil {
+		return nil, err
+	}
+	conn := &redisConn{Conn: c, r: bufio.NewReader(c)}
+
+	var setup [][]string
+	if b.config.Password != "" {
+		setup = append(setup, []string{"AUTH", b.config.Password})
+	}
+	if b.config.DB != 0 {
+		setup = append(setup, []string{"SELECT", strconv.Itoa(b.config.DB)})
+	}
+	if len(setup) > 0 {
+		if _, err := conn.pipeline(b.deadline(ctx), setup...); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return conn, nil
+}
+
+func (b *RedisBackend) put(conn *redisConn) {
+	select {
+	case b.idle <- conn:
+	default:
+		conn.Close()
+	}
+}
+
+// redisError is an error reply from the server
+type redisError string
+
+func (e redisError) Error() string {
+	return "redis: " + string(e)
+}
+
+// redisConn is a connection speaking RESP, the Redis serialization protocol
+type redisConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+// pipeline sends the commands and reads one reply for each. Error replies are
+// returned as errors.
+func (c *redisConn) pipeline(deadline time.Time, cmds ...[]string) ([]interface{}, error) {
+	if err := c.SetDeadline(deadline); err != nil {
+		return nil, err
+	}
+
+	w := bufio.NewWriter(c.Conn)
+	for _, cmd := range cmds {
+		fmt.Fprintf(w, "*%d\r\n", len(cmd))
+		for _, arg := range cmd {
+			fmt.Fprintf(w, "$%d\r\n%s\r\n", len(arg), arg)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return nil, err
+	}
+
+	replies := make([]interface{}, len(cmds))
+	for i := range cmds {
+		reply, err := readReply(c.r)
+		if err != nil {
+			return nil, err
+		}
+		if e, ok := reply.(redisError); ok {
+			return nil, e
+		}
+		replies[i] = reply
+	}
+	return replies, nil
+}
+
+// readReply reads a RESP reply. Simple strings and bulk strings are returned as
+// string, integers as int64, nil bulk strings and arrays as nil, arrays as
+// []interface{} and errors as redisError.
+func readReply(r *bufio.Reader) (interface{}, error) {
+	line, err := readLine(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(line) == 0 {
+		return nil, errors.New("redis: empty reply")
+	}
+
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return redisError(line[1:]), nil
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		return string(buf[:n]), nil
+	case '*':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		items := make([]interface{}, n)
+		for i := range items {
+			if items[i], err = readReply(r); err != nil {
+				return nil, err
+			}
+		}
+		return items, nil
+	}
+	return nil, fmt.Errorf("redis: unknown reply %q", line)
+}
+
+func readLine(r *bufio.Reader) (string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	if len(line) < 2 || line[len(line)-2] != '\r' {
+		return "", fmt.Errorf("redis: malformed line %q", line)
+	}
+	return line[:len(line)-2], nil
+}
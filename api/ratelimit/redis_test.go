@@ -0,0 +1,222 @@
+package ratelimit
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeRedis is an in-process server implementing the few Redis commands the
+// backend uses, with a clock the tests control
+type fakeRedis struct {
+	listener net.Listener
+	password string
+
+	mu      sync.Mutex
+	now     time.Time
+	values  map[string]int64
+	expires map[string]time.Time
+}
+
+func newFakeRedis(t *testing.T) *fakeRedis {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+
+	f := &fakeRedis{
+		listener: l,
+		now:      time.Now(),
+		values:   map[string]int64{},
+		expires:  map[string]time.Time{},
+	}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go f.serve(conn)
+		}
+	}()
+	t.Cleanup(func() { l.Close() })
+	return f
+}
+
+func (f *fakeRedis) addr() string {
+	return f.listener.Addr().String()
+}
+
+func (f *fakeRedis) advance(d time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.now = f.now.Add(d)
+}
+
+func (f *fakeRedis) serve(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	authenticated := f.password == ""
+
+	for {
+		reply, err := readReply(r)
+		if err != nil {
+			return
+		}
+		items, _ := reply.([]interface{})
+		args := make([]string, len(items))
+		for i, item := range items {
+			args[i], _ = item.(string)
+		}
+		if len(args) == 0 {
+			return
+		}
+
+		cmd := strings.ToUpper(args[0])
+		var out string
+		switch {
+		case cmd == "AUTH":
+			authenticated = len(args) == 2 && args[1] == f.password
+			out = "+OK\r\n"
+			if !authenticated {
+				out = "-WRONGPASS invalid password\r\n"
+			}
+		case !authenticated:
+			out = "-NOAUTH Authentication required.\r\n"
+		default:
+			out = f.exec(cmd, args[1:])
+		}
+		if _, err := conn.Write([]byte(out)); err != nil {
+			return
+		}
+	}
+}
+
+func (f *fakeRedis) exec(cmd string, args []string) string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	// Drop expired keys
+	for key, at := range f.expires {
+		if !f.now.Before(at) {
+			delete(f.values, key)
+			delete(f.expires, key)
+		}
+	}
+
+	switch cmd {
+	case "SELECT":
+		return "+OK\r\n"
+	case "SET":
+		key := args[0]
+		value, _ := strconv.ParseInt(args[1], 10, 64)
+		var px int64
+		nx := false
+		for i := 2; i < len(args); i++ {
+			switch strings.ToUpper(args[i]) {
+			case "NX":
+				nx = true
+			case "PX":
+				i++
+				px, _ = strconv.ParseInt(args[i], 10, 64)
+			}
+		}
+		if _, exists := f.values[key]; exists && nx {
+			return "$-1\r\n"
+		}
+		f.values[key] = value
+		delete(f.expires, key)
+		if px > 0 {
+			f.expires[key] = f.now.Add(time.Duration(px) * time.Millisecond)
+		}
+		return "+OK\r\n"
+	case "INCR":
+		f.values[args[0]]++
+		return fmt.Sprintf(":%d\r\n", f.values[args[0]])
+	case "PTTL":
+		if _, exists := f.values[args[0]]; !exists {
+			return ":-2\r\n"
+		}
+		at, ok := f.expires[args[0]]
+		if !ok {
+			return ":-1\r\n"
+		}
+		return fmt.Sprintf(":%d\r\n", at.Sub(f.now).Milliseconds())
+	case "PEXPIRE":
+		if _, exists := f.values[args[0]]; !exists {
+			return ":0\r\n"
+		}
+		ms, _ := strconv.ParseInt(args[1], 10, 64)
+		f.expires[args[0]] = f.now.Add(time.Duration(ms) * time.Millisecond)
+		return ":1\r\n"
+	}
+	return "-ERR unknown command '" + cmd + "'\r\n"
+}
+
+func TestRedisBackend_Take(t *testing.T) {
+	server := newFakeRedis(t)
+	backend := NewRedisBackend(RedisConfig{Addr: server.addr()})
+	defer backend.Close()
+	ctx := context.Background()
+
+	for i := 1; i <= 3; i++ {
+		result, err := backend.Take(ctx, "k", 3, time.Minute)
+		assert.Nil(t, err)
+		assert.True(t, result.Allowed)
+		assert.Equal(t, 3-i, result.Remaining)
+		assert.Equal(t, time.Minute, result.Reset)
+	}
+
+	result, err := backend.Take(ctx, "k", 3, time.Minute)
+	assert.Nil(t, err)
+	assert.False(t, result.Allowed)
+	assert.Equal(t, 0, result.Remaining)
+
+	// Another replica shares the counter
+	other := NewRedisBackend(RedisConfig{Addr: server.addr()})
+	defer other.Close()
+	result, err = other.Take(ctx, "k", 3, time.Minute)
+	assert.Nil(t, err)
+	assert.False(t, result.Allowed)
+
+	// The window ends with the key's expiry
+	server.advance(30 * time.Second)
+	result, _ = backend.Take(ctx, "k", 3, time.Minute)
+	assert.Equal(t, 30*time.Second, result.Reset)
+	server.advance(30 * time.Second)
+	result, err = backend.Take(ctx, "k", 3, time.Minute)
+	assert.Nil(t, err)
+	assert.True(t, result.Allowed)
+	assert.Equal(t, 2, result.Remaining)
+}
+
+func TestRedisBackend_Auth(t *testing.T) {
+	server := newFakeRedis(t)
+	server.password = "secret"
+
+	backend := NewRedisBackend(RedisConfig{Addr: server.addr()})
+	_, err := backend.Take(context.Background(), "k", 1, time.Minute)
+	assert.NotNil(t, err)
+
+	backend = NewRedisBackend(RedisConfig{Addr: server.addr(), Password: "secret", DB: 2})
+	defer backend.Close()
+	result, err := backend.Take(context.Background(), "k", 1, time.Minute)
+	assert.Nil(t, err)
+	assert.True(t, result.Allowed)
+}
+
+func TestRedisBackend_Unavailable(t *testing.T) {
+	server := newFakeRedis(t)
+	addr := server.addr()
+	server.listener.Close()
+
+	backend := NewRedisBackend(RedisConfig{Addr: addr, Timeout: 100 * time.Millisecond})
+	_, err := backend.Take(context.Background(), "k", 1, time.Minute)
+	assert.NotNil(t, err)
+}
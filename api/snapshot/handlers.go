@@ -0,0 +1,208 @@
+package snapshot
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/ringo380/lessoncraft/api/auth"
+	"github.com/ringo380/lessoncraft/api/middleware"
+	"github.com/ringo380/lessoncraft/pwd/types"
+)
+
+// Handler handles HTTP requests related to snapshots. All routes require authentication.
+type Handler struct {
+	service *Service
+	tokens  auth.TokenValidator
+}
+
+// NewHandler creates a new Handler
+func NewHandler(service *Service, tokens auth.TokenValidator) *Handler {
+	return &Handler{service: service, tokens: tokens}
+}
+
+// RegisterRoutes registers the snapshot routes with the provided router
+func (h *Handler) RegisterRoutes(r *mux.Router) {
+	authMiddleware := auth.AuthMiddleware(h.tokens)
+	handle := func(path string, f http.HandlerFunc, method string) {
+		r.Handle(path, authMiddleware(f)).Methods(method)
+	}
+
+	handle("/api/sessions/{sessionId}/instances/{instanceName}/snapshots", h.createSnapshot, "POST")
+	handle("/api/snapshots", h.listSnapshots, "GET")
+	handle("/api/snapshots/{snapshotId}", h.getSnapshot, "GET")
+	handle("/api/snapshots/{snapshotId}", h.deleteSnapshot, "DELETE")
+	handle("/api/snapshots/{snapshotId}/restore", h.restoreSnapshot, "POST")
+	handle("/api/lessons/{lessonId}/checkpoints", h.listCheckpoints, "GET")
+}
+
+// CreateSnapshotRequest represents a request to snapshot an instance
+type CreateSnapshotRequest struct {
+	Name string `json:"name"`
+	// Checkpoint makes the snapshot a checkpoint of the given lesson step that
+	// any learner can restore. Only educators can create checkpoints.
+	Checkpoint bool   `json:"checkpoint"`
+	LessonID   string `json:"lesson_id"`
+	StepIndex  int    `json:"step_index"`
+}
+
+func (h *Handler) createSnapshot(w http.ResponseWriter, r *http.Request) {
+	userID, _ := auth.GetUserID(r)
+	vars := mux.Vars(r)
+	session, ok := h.session(w, r, vars["sessionId"])
+	if !ok {
+		return
+	}
+
+	var req CreateSnapshotRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		middleware.WriteError(w, "InvalidRequest", http.StatusBadRequest, "Invalid request format", err)
+		return
+	}
+
+	opts := CreateOptions{Name: strings.TrimSpace(req.Name), Reason: ReasonManual}
+	if req.Checkpoint {
+		if !auth.IsEducator(r) && !auth.IsAdmin(r) {
+			middleware.WriteError(w, "Forbidden", http.StatusForbidden, "Only educators can create checkpoints", nil)
+			return
+		}
+		if req.LessonID == "" || req.StepIndex < 0 {
+			middleware.WriteError(w, "ValidationError", http.StatusBadRequest, "Checkpoints need a lesson and step", nil)
+			return
+		}
+		opts.Reason = ReasonCheckpoint
+		opts.LessonCtx = &types.LessonContext{LessonID: req.LessonID, StepIndex: req.StepIndex}
+	}
+
+	snap, err := h.service.Create(userID, session, vars["instanceName"], opts)
+	if err != nil {
+		switch err {
+		case ErrInstanceNotFound:
+			middleware.WriteError(w, "NotFound", http.StatusNotFound, "Instance not found", err)
+		case ErrQuotaExceeded:
+			middleware.WriteError(w, "QuotaExceeded", http.StatusForbidden, "Snapshot quota exceeded, delete older snapshots first", err)
+		default:
+			middleware.WriteError(w, "SnapshotError", http.StatusInternalServerError, "Failed to snapshot instance", err)
+		}
+		return
+	}
+	middleware.WriteJSON(w, http.StatusCreated, snap)
+}
+
+// ListSnapshotsResponse lists the snapshots of a user with their quota usage
+type ListSnapshotsResponse struct {
+	Snapshots []*Snapshot `json:"snapshots"`
+	Usage     Usage       `json:"usage"`
+}
+
+func (h *Handler) listSnapshots(w http.ResponseWriter, r *http.Request) {
+	userID, _ := auth.GetUserID(r)
+	snapshots, err := h.service.List(userID)
+	if err != nil {
+		middleware.WriteError(w, "DatabaseError", http.StatusInternalServerError, "Failed to retrieve snapshots", err)
+		return
+	}
+	usage, err := h.service.Usage(userID)
+	if err != nil {
+		middleware.WriteError(w, "DatabaseError", http.StatusInternalServerError, "Failed to retrieve snapshot usage", err)
+		return
+	}
+	middleware.WriteJSON(w, http.StatusOK, ListSnapshotsResponse{Snapshots: snapshots, Usage: usage})
+}
+
+func (h *Handler) getSnapshot(w http.ResponseWriter, r *http.Request) {
+	snap, ok := h.snapshot(w, r, false)
+	if !ok {
+		return
+	}
+	middleware.WriteJSON(w, http.StatusOK, snap)
+}
+
+func (h *Handler) deleteSnapshot(w http.ResponseWriter, r *http.Request) {
+	snap, ok := h.snapshot(w, r, true)
+	if !ok {
+		return
+	}
+	if err := h.service.Delete(snap); err != nil {
+		middleware.WriteError(w, "SnapshotError", http.StatusInternalServerError, "Failed to delete snapshot", err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// RestoreSnapshotRequest represents a request to restore a snapshot into a session
+type RestoreSnapshotRequest struct {
+	SessionID string `json:"session_id"`
+}
+
+func (h *Handler) restoreSnapshot(w http.ResponseWriter, r *http.Request) {
+	snap, ok := h.snapshot(w, r, false)
+	if !ok {
+		return
+	}
+
+	var req RestoreSnapshotRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		middleware.WriteError(w, "InvalidRequest", http.StatusBadRequest, "Invalid request format", err)
+		return
+	}
+	if req.SessionID == "" {
+		middleware.WriteError(w, "ValidationError", http.StatusBadRequest, "Session ID is required", nil)
+		return
+	}
+	session, ok := h.session(w, r, req.SessionID)
+	if !ok {
+		return
+	}
+
+	instance, err := h.service.Restore(snap, session)
+	if err != nil {
+		middleware.WriteError(w, "SnapshotError", http.StatusInternalServerError, "Failed to restore snapshot", err)
+		return
+	}
+	middleware.WriteJSON(w, http.StatusCreated, instance)
+}
+
+func (h *Handler) listCheckpoints(w http.ResponseWriter, r *http.Request) {
+	checkpoints, err := h.service.Checkpoints(mux.Vars(r)["lessonId"])
+	if err != nil {
+		middleware.WriteError(w, "DatabaseError", http.StatusInternalServerError, "Failed to retrieve checkpoints", err)
+		return
+	}
+	middleware.WriteJSON(w, http.StatusOK, checkpoints)
+}
+
+// session loads a session the user owns, writing an error response if it
+// cannot be found or belongs to someone else. Admins can use any session.
+func (h *Handler) session(w http.ResponseWriter, r *http.Request, id string) (*types.Session, bool) {
+	userID, _ := auth.GetUserID(r)
+	session, err := h.service.core.SessionGet(id)
+	if err != nil || session == nil {
+		middleware.WriteError(w, "NotFound", http.StatusNotFound, "Session not found", err)
+		return nil, false
+	}
+	if session.UserId != userID && !auth.IsAdmin(r) {
+		middleware.WriteError(w, "Forbidden", http.StatusForbidden, "You do not have access to this session", nil)
+		return nil, false
+	}
+	return session, true
+}
+
+// snapshot loads the snapshot of the request, writing an error response if the
+// user cannot use it. Owners and admins can use any snapshot; everyone can
+// restore checkpoints, but only their owner can modify them.
+func (h *Handler) snapshot(w http.ResponseWriter, r *http.Request, modify bool) (*Snapshot, bool) {
+	userID, _ := auth.GetUserID(r)
+	snap, err := h.service.Get(mux.Vars(r)["snapshotId"])
+	if err != nil {
+		middleware.WriteError(w, "NotFound", http.StatusNotFound, "Snapshot not found", err)
+		return nil, false
+	}
+	if snap.UserID != userID && !auth.IsAdmin(r) && (modify || snap.Reason != ReasonCheckpoint) {
+		middleware.WriteError(w, "Forbidden", http.StatusForbidden, "You do not have access to this snapshot", nil)
+		return nil, false
+	}
+	return snap, true
+}
@@ -0,0 +1,108 @@
+package snapshot
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/ringo380/lessoncraft/api/apitest"
+	"github.com/ringo380/lessoncraft/api/auth"
+	"github.com/ringo380/lessoncraft/pwd/types"
+	"github.com/stretchr/testify/assert"
+)
+
+type snapshotTestEnv struct {
+	core   *fakeCore
+	jwt    *auth.JWTService
+	router *mux.Router
+}
+
+func newSnapshotTestEnv() *snapshotTestEnv {
+	env := &snapshotTestEnv{
+		core: newFakeCore(),
+		jwt:  auth.NewJWTService("secret", "lessoncraft", time.Hour),
+	}
+	env.router = mux.NewRouter()
+	service := NewService(NewMemoryStore(), env.core, Policy{MaxPerUser: 1})
+	NewHandler(service, env.jwt).RegisterRoutes(env.router)
+	return env
+}
+
+func (e *snapshotTestEnv) do(t *testing.T, userID string, role auth.Role, method, path string, body interface{}) *httptest.ResponseRecorder {
+	token, _, err := e.jwt.GenerateToken(userID, userID+"@example.com", []auth.Role{role})
+	assert.Nil(t, err)
+
+	return apitest.Do(e.router, token, method, path, body)
+}
+
+func TestHandler_Snapshots(t *testing.T) {
+	env := newSnapshotTestEnv()
+	env.core.addInstance(&types.Session{Id: "s1", UserId: "alice"}, "s1_node1", 100)
+	env.core.sessions["s2"] = &types.Session{Id: "s2", UserId: "alice"}
+
+	// Only the session owner can snapshot its instances
+	rr := env.do(t, "bob", auth.RoleLearner, "POST", "/api/sessions/s1/instances/s1_node1/snapshots", nil)
+	assert.Equal(t, http.StatusForbidden, rr.Code)
+
+	rr = env.do(t, "alice", auth.RoleLearner, "POST", "/api/sessions/s1/instances/s1_node1/snapshots", CreateSnapshotRequest{Name: "mine"})
+	assert.Equal(t, http.StatusCreated, rr.Code)
+	var snap Snapshot
+	json.NewDecoder(rr.Body).Decode(&snap)
+	assert.Equal(t, "mine", snap.Name)
+
+	rr = env.do(t, "alice", auth.RoleLearner, "POST", "/api/sessions/s1/instances/s1_node1/snapshots", nil)
+	assert.Equal(t, http.StatusForbidden, rr.Code)
+	assert.Contains(t, rr.Body.String(), "QuotaExceeded")
+
+	rr = env.do(t, "alice", auth.RoleLearner, "GET", "/api/snapshots", nil)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	var list ListSnapshotsResponse
+	json.NewDecoder(rr.Body).Decode(&list)
+	assert.Len(t, list.Snapshots, 1)
+	assert.Equal(t, 1, list.Usage.Count)
+
+	rr = env.do(t, "bob", auth.RoleLearner, "GET", "/api/snapshots/"+snap.ID, nil)
+	assert.Equal(t, http.StatusForbidden, rr.Code)
+
+	rr = env.do(t, "alice", auth.RoleLearner, "POST", "/api/snapshots/"+snap.ID+"/restore", RestoreSnapshotRequest{SessionID: "s2"})
+	assert.Equal(t, http.StatusCreated, rr.Code)
+	assert.Equal(t, snap.Image, env.core.created[0].SnapshotImage)
+
+	rr = env.do(t, "alice", auth.RoleLearner, "DELETE", "/api/snapshots/"+snap.ID, nil)
+	assert.Equal(t, http.StatusNoContent, rr.Code)
+	rr = env.do(t, "alice", auth.RoleLearner, "GET", "/api/snapshots/"+snap.ID, nil)
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+}
+
+func TestHandler_Checkpoints(t *testing.T) {
+	env := newSnapshotTestEnv()
+	env.core.addInstance(&types.Session{Id: "s1", UserId: "teacher"}, "s1_node1", 100)
+	env.core.sessions["s2"] = &types.Session{Id: "s2", UserId: "alice"}
+
+	checkpoint := CreateSnapshotRequest{Checkpoint: true, LessonID: "docker-101", StepIndex: 4}
+	rr := env.do(t, "teacher", auth.RoleLearner, "POST", "/api/sessions/s1/instances/s1_node1/snapshots", checkpoint)
+	assert.Equal(t, http.StatusForbidden, rr.Code)
+
+	rr = env.do(t, "teacher", auth.RoleEducator, "POST", "/api/sessions/s1/instances/s1_node1/snapshots", checkpoint)
+	assert.Equal(t, http.StatusCreated, rr.Code)
+	var snap Snapshot
+	json.NewDecoder(rr.Body).Decode(&snap)
+	assert.Equal(t, ReasonCheckpoint, snap.Reason)
+
+	// Learners can find and restore checkpoints, but not delete them
+	rr = env.do(t, "alice", auth.RoleLearner, "GET", "/api/lessons/docker-101/checkpoints", nil)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	var checkpoints []*Snapshot
+	json.NewDecoder(rr.Body).Decode(&checkpoints)
+	assert.Len(t, checkpoints, 1)
+
+	rr = env.do(t, "alice", auth.RoleLearner, "POST", "/api/snapshots/"+snap.ID+"/restore", RestoreSnapshotRequest{SessionID: "s2"})
+	assert.Equal(t, http.StatusCreated, rr.Code)
+	assert.Equal(t, 4, env.core.created[0].LessonCtx.StepIndex)
+
+	rr = env.do(t, "alice", auth.RoleLearner, "DELETE", "/api/snapshots/"+snap.ID, nil)
+	assert.Equal(t, http.StatusForbidden, rr.Code)
+}
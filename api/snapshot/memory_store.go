@@ -0,0 +1,91 @@
+package snapshot
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// MemoryStore is an in-memory implementation of the Store interface
+type MemoryStore struct {
+	snapshots map[string]*Snapshot
+	mu        sync.RWMutex
+}
+
+// NewMemoryStore creates a new in-memory snapshot store
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{snapshots: make(map[string]*Snapshot)}
+}
+
+// CreateSnapshot creates a new snapshot record
+func (s *MemoryStore) CreateSnapshot(snap *Snapshot) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.snapshots[snap.ID]; ok {
+		return ErrAlreadyExists
+	}
+	s.snapshots[snap.ID] = snap
+	return nil
+}
+
+// GetSnapshot retrieves a snapshot by ID
+func (s *MemoryStore) GetSnapshot(id string) (*Snapshot, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	snap, ok := s.snapshots[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return snap, nil
+}
+
+// DeleteSnapshot deletes a snapshot record
+func (s *MemoryStore) DeleteSnapshot(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.snapshots[id]; !ok {
+		return ErrNotFound
+	}
+	delete(s.snapshots, id)
+	return nil
+}
+
+// ListSnapshotsForUser retrieves the snapshots of a user, oldest first
+func (s *MemoryStore) ListSnapshotsForUser(userID string) ([]*Snapshot, error) {
+	return s.list(func(snap *Snapshot) bool {
+		return snap.UserID == userID
+	}), nil
+}
+
+// ListCheckpoints retrieves the checkpoints of a lesson, oldest first
+func (s *MemoryStore) ListCheckpoints(lessonID string) ([]*Snapshot, error) {
+	return s.list(func(snap *Snapshot) bool {
+		return snap.Reason == ReasonCheckpoint && snap.LessonCtx != nil && snap.LessonCtx.LessonID == lessonID
+	}), nil
+}
+
+// ListExpired retrieves the snapshots that expire before the given time
+func (s *MemoryStore) ListExpired(before time.Time) ([]*Snapshot, error) {
+	return s.list(func(snap *Snapshot) bool {
+		return !snap.ExpiresAt.IsZero() && snap.ExpiresAt.Before(before)
+	}), nil
+}
+
+func (s *MemoryStore) list(match func(*Snapshot) bool) []*Snapshot {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	result := []*Snapshot{}
+	for _, snap := range s.snapshots {
+		if match(snap) {
+			result = append(result, snap)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].CreatedAt.Before(result[j].CreatedAt)
+	})
+	return result
+}
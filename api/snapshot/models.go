@@ -0,0 +1,78 @@
+package snapshot
+
+import (
+	"time"
+
+	"github.com/ringo380/lessoncraft/pwd/types"
+)
+
+// Reason records why a snapshot was taken
+type Reason string
+
+const (
+	// ReasonManual snapshots are taken by learners and count against their quota
+	ReasonManual Reason = "manual"
+	// ReasonExpiry snapshots are taken when an expired session is closed. They
+	// count against the quota, but are evicted oldest first to make room for
+	// newer ones.
+	ReasonExpiry Reason = "expiry"
+	// ReasonCheckpoint snapshots are taken by educators so learners can start a
+	// lesson step from them. They do not count against any quota.
+	ReasonCheckpoint Reason = "checkpoint"
+)
+
+// Snapshot is a saved instance filesystem that can be restored into a new session
+type Snapshot struct {
+	ID           string               `json:"id"`
+	UserID       string               `json:"user_id"`
+	Name         string               `json:"name,omitempty"`
+	Reason       Reason               `json:"reason"`
+	SessionID    string               `json:"session_id"`
+	SessionHost  string               `json:"session_host,omitempty"`
+	PlaygroundID string               `json:"playground_id,omitempty"`
+	InstanceName string               `json:"instance_name"`
+	Hostname     string               `json:"hostname,omitempty"`
+	InstanceType string               `json:"instance_type,omitempty"`
+	Image        string               `json:"image"`
+	Size         int64                `json:"size"`
+	InnerImages  []string             `json:"inner_images,omitempty"`
+	LessonCtx    *types.LessonContext `json:"lesson_context,omitempty"`
+	CreatedAt    time.Time            `json:"created_at"`
+	// ExpiresAt is when the snapshot is deleted. Snapshots without it are kept
+	// until they are deleted explicitly.
+	ExpiresAt time.Time `json:"expires_at,omitempty"`
+}
+
+// countsAgainstQuota checks if the snapshot uses the quota of its owner
+func (s *Snapshot) countsAgainstQuota() bool {
+	return s.Reason != ReasonCheckpoint
+}
+
+// Policy limits how many snapshots users keep and for how long
+type Policy struct {
+	// MaxPerUser is the number of snapshots a user can keep, 0 for no limit
+	MaxPerUser int
+	// MaxBytesPerUser is the total size of the snapshots a user can keep, 0 for no limit
+	MaxBytesPerUser int64
+	// Retention is how long manual and expiry snapshots are kept, 0 to keep them
+	Retention time.Duration
+	// CheckpointRetention is how long checkpoints are kept, 0 to keep them
+	CheckpointRetention time.Duration
+}
+
+// DefaultPolicy returns the policy used when none is configured
+func DefaultPolicy() Policy {
+	return Policy{
+		MaxPerUser:      5,
+		MaxBytesPerUser: 10 * 1024 * 1024 * 1024,
+		Retention:       7 * 24 * time.Hour,
+	}
+}
+
+// Usage is how much of the snapshot quota a user has used
+type Usage struct {
+	Count    int   `json:"count"`
+	Bytes    int64 `json:"bytes"`
+	MaxCount int   `json:"max_count,omitempty"`
+	MaxBytes int64 `json:"max_bytes,omitempty"`
+}
@@ -0,0 +1,330 @@
+package snapshot
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/ringo380/lessoncraft/pwd/types"
+)
+
+var (
+	// ErrQuotaExceeded is returned when a snapshot does not fit in the quota of its owner
+	ErrQuotaExceeded = errors.New("snapshot quota exceeded")
+	// ErrInstanceNotFound is returned when snapshotting an instance that does not exist
+	ErrInstanceNotFound = errors.New("instance not found")
+)
+
+// DefaultRepository is the image repository snapshots are committed to
+const DefaultRepository = "lessoncraft/snapshots"
+
+// Core is the part of the playground core snapshots are taken and restored with
+type Core interface {
+	SessionGet(id string) (*types.Session, error)
+	InstanceGet(session *types.Session, name string) *types.Instance
+	InstanceFindBySession(session *types.Session) ([]*types.Instance, error)
+	InstanceNew(session *types.Session, conf types.InstanceConfig) (*types.Instance, error)
+	InstanceExec(instance *types.Instance, cmd []string) (int, error)
+	InstanceSnapshot(instance *types.Instance, image string) (*types.InstanceSnapshot, error)
+	InstanceSnapshotDelete(session *types.Session, snapshot *types.InstanceSnapshot) error
+}
+
+// CreateOptions describes a snapshot to take
+type CreateOptions struct {
+	Name   string
+	Reason Reason
+	// LessonCtx overrides the lesson context of the instance, e.g. to record
+	// the step a checkpoint starts
+	LessonCtx *types.LessonContext
+}
+
+// Service takes, restores and expires snapshots within the limits of a Policy
+type Service struct {
+	store      Store
+	core       Core
+	policy     Policy
+	repository string
+	now        func() time.Time
+
+	// mu serializes quota decisions, so concurrent snapshots of a user cannot
+	// exceed the quota together
+	mu   sync.Mutex
+	stop chan struct{}
+}
+
+// NewService creates a new snapshot service
+func NewService(store Store, core Core, policy Policy) *Service {
+	return &Service{store: store, core: core, policy: policy, repository: DefaultRepository, now: time.Now}
+}
+
+// SetRepository sets the image repository snapshots are committed to, e.g. a
+// registry all docker hosts can pull from
+func (s *Service) SetRepository(repository string) {
+	s.repository = repository
+}
+
+// Create snapshots an instance of a session on behalf of a user
+func (s *Service) Create(userID string, session *types.Session, instanceName string, opts CreateOptions) (*Snapshot, error) {
+	instance := s.core.InstanceGet(session, instanceName)
+	if instance == nil || instance.SessionId != session.Id {
+		return nil, ErrInstanceNotFound
+	}
+	return s.create(userID, session, instance, opts)
+}
+
+// SnapshotSession snapshots every instance of a session before it is closed.
+// Sessions without a user are skipped, as nobody could restore them.
+func (s *Service) SnapshotSession(session *types.Session) error {
+	if session.UserId == "" {
+		return nil
+	}
+	instances, err := s.core.InstanceFindBySession(session)
+	if err != nil {
+		return err
+	}
+	var firstErr error
+	for _, instance := range instances {
+		if _, err := s.create(session.UserId, session, instance, CreateOptions{Reason: ReasonExpiry}); err != nil {
+			log.Printf("Could not snapshot instance %s of session %s: %v\n", instance.Name, session.Id, err)
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	return firstErr
+}
+
+func (s *Service) create(userID string, session *types.Session, instance *types.Instance, opts CreateOptions) (*Snapshot, error) {
+	if opts.Reason == "" {
+		opts.Reason = ReasonManual
+	}
+	lessonCtx := opts.LessonCtx
+	if lessonCtx == nil && instance.LessonCtx != nil {
+		c := *instance.LessonCtx
+		lessonCtx = &c
+	}
+
+	now := s.now()
+	snap := &Snapshot{
+		ID:           uuid.New().String(),
+		UserID:       userID,
+		Name:         opts.Name,
+		Reason:       opts.Reason,
+		SessionID:    session.Id,
+		SessionHost:  session.Host,
+		PlaygroundID: session.PlaygroundId,
+		InstanceName: instance.Name,
+		Hostname:     instance.Hostname,
+		InstanceType: instance.Type,
+		LessonCtx:    lessonCtx,
+		CreatedAt:    now,
+	}
+	retention := s.policy.Retention
+	if snap.Reason == ReasonCheckpoint {
+		retention = s.policy.CheckpointRetention
+	}
+	if retention > 0 {
+		snap.ExpiresAt = now.Add(retention)
+	}
+
+	// Manual snapshots that cannot fit are refused before anything is committed
+	if snap.Reason == ReasonManual {
+		if usage, err := s.Usage(userID); err != nil {
+			return nil, err
+		} else if s.policy.MaxPerUser > 0 && usage.Count >= s.policy.MaxPerUser {
+			return nil, ErrQuotaExceeded
+		}
+	}
+
+	is, err := s.core.InstanceSnapshot(instance, fmt.Sprintf("%s:%s", s.repository, snap.ID))
+	if err != nil {
+		return nil, err
+	}
+	snap.Image = is.Image
+	snap.Size = is.Size
+	snap.InnerImages = is.InnerImages
+
+	if err := s.admit(snap); err != nil {
+		if err := s.deleteImage(snap); err != nil {
+			log.Printf("Could not delete snapshot image %s: %v\n", snap.Image, err)
+		}
+		return nil, err
+	}
+	return snap, nil
+}
+
+// admit stores a committed snapshot if it fits in the quota of its owner.
+// Expiry snapshots evict older expiry snapshots to make room.
+func (s *Service) admit(snap *Snapshot) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if snap.countsAgainstQuota() {
+		existing, err := s.store.ListSnapshotsForUser(snap.UserID)
+		if err != nil {
+			return err
+		}
+		count, bytes := 1, snap.Size
+		for _, e := range existing {
+			if e.countsAgainstQuota() {
+				count++
+				bytes += e.Size
+			}
+		}
+
+		var evict []*Snapshot
+		if snap.Reason == ReasonExpiry {
+			for _, e := range existing {
+				if !s.overQuota(count, bytes) {
+					break
+				}
+				if e.Reason == ReasonExpiry {
+					evict = append(evict, e)
+					count--
+					bytes -= e.Size
+				}
+			}
+		}
+		if s.overQuota(count, bytes) {
+			return ErrQuotaExceeded
+		}
+		for _, e := range evict {
+			if err := s.delete(e); err != nil {
+				return err
+			}
+		}
+	}
+
+	return s.store.CreateSnapshot(snap)
+}
+
+func (s *Service) overQuota(count int, bytes int64) bool {
+	return (s.policy.MaxPerUser > 0 && count > s.policy.MaxPerUser) ||
+		(s.policy.MaxBytesPerUser > 0 && bytes > s.policy.MaxBytesPerUser)
+}
+
+// Usage returns how much of the snapshot quota a user has used
+func (s *Service) Usage(userID string) (Usage, error) {
+	usage := Usage{MaxCount: s.policy.MaxPerUser, MaxBytes: s.policy.MaxBytesPerUser}
+	snapshots, err := s.store.ListSnapshotsForUser(userID)
+	if err != nil {
+		return usage, err
+	}
+	for _, snap := range snapshots {
+		if snap.countsAgainstQuota() {
+			usage.Count++
+			usage.Bytes += snap.Size
+		}
+	}
+	return usage, nil
+}
+
+// Get retrieves a snapshot by ID
+func (s *Service) Get(id string) (*Snapshot, error) {
+	return s.store.GetSnapshot(id)
+}
+
+// List retrieves the snapshots of a user
+func (s *Service) List(userID string) ([]*Snapshot, error) {
+	return s.store.ListSnapshotsForUser(userID)
+}
+
+// Checkpoints retrieves the checkpoints of a lesson
+func (s *Service) Checkpoints(lessonID string) ([]*Snapshot, error) {
+	return s.store.ListCheckpoints(lessonID)
+}
+
+// Restore creates a new instance from a snapshot in the given session. The
+// images of the inner docker daemon are pulled again in the background.
+func (s *Service) Restore(snap *Snapshot, session *types.Session) (*types.Instance, error) {
+	conf := types.InstanceConfig{
+		SnapshotImage:  snap.Image,
+		Type:           snap.InstanceType,
+		PlaygroundFQDN: session.Host,
+		DindVolumeSize: "5G",
+		Privileged:     true,
+	}
+	if snap.LessonCtx != nil {
+		c := *snap.LessonCtx
+		conf.LessonCtx = &c
+	}
+	instance, err := s.core.InstanceNew(session, conf)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(snap.InnerImages) > 0 {
+		go func() {
+			for _, image := range snap.InnerImages {
+				if code, err := s.core.InstanceExec(instance, []string{"docker", "pull", image}); err != nil || code != 0 {
+					log.Printf("Could not pull image %s into restored instance %s: %d %v\n", image, instance.Name, code, err)
+				}
+			}
+		}()
+	}
+	return instance, nil
+}
+
+// Delete removes a snapshot with its image
+func (s *Service) Delete(snap *Snapshot) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.delete(snap)
+}
+
+func (s *Service) delete(snap *Snapshot) error {
+	if err := s.deleteImage(snap); err != nil {
+		return err
+	}
+	return s.store.DeleteSnapshot(snap.ID)
+}
+
+func (s *Service) deleteImage(snap *Snapshot) error {
+	session := &types.Session{Id: snap.SessionID, Host: snap.SessionHost, PlaygroundId: snap.PlaygroundID, UserId: snap.UserID}
+	return s.core.InstanceSnapshotDelete(session, &types.InstanceSnapshot{Image: snap.Image, Type: snap.InstanceType})
+}
+
+// Prune deletes the snapshots past their retention
+func (s *Service) Prune() error {
+	expired, err := s.store.ListExpired(s.now())
+	if err != nil {
+		return err
+	}
+	for _, snap := range expired {
+		if err := s.Delete(snap); err != nil && err != ErrNotFound {
+			log.Printf("Could not delete expired snapshot %s: %v\n", snap.ID, err)
+		}
+	}
+	return nil
+}
+
+// Start prunes expired snapshots at the given interval until Stop is called
+func (s *Service) Start(interval time.Duration) {
+	s.stop = make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if err := s.Prune(); err != nil {
+					log.Printf("Could not prune snapshots: %v\n", err)
+				}
+			case <-s.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Stop stops pruning expired snapshots
+func (s *Service) Stop() {
+	if s.stop != nil {
+		close(s.stop)
+		s.stop = nil
+	}
+}
@@ -0,0 +1,202 @@
+package snapshot
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ringo380/lessoncraft/pwd/types"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeCore keeps sessions and instances in memory and records snapshot images
+type fakeCore struct {
+	mu        sync.Mutex
+	sessions  map[string]*types.Session
+	instances map[string]*types.Instance
+	sizes     map[string]int64
+	images    map[string]bool
+	created   []types.InstanceConfig
+	pulled    chan string
+}
+
+func newFakeCore() *fakeCore {
+	return &fakeCore{
+		sessions:  map[string]*types.Session{},
+		instances: map[string]*types.Instance{},
+		sizes:     map[string]int64{},
+		images:    map[string]bool{},
+		pulled:    make(chan string, 10),
+	}
+}
+
+func (c *fakeCore) addInstance(session *types.Session, name string, size int64) *types.Instance {
+	c.sessions[session.Id] = session
+	i := &types.Instance{Name: name, SessionId: session.Id, Hostname: "node1"}
+	c.instances[name] = i
+	c.sizes[name] = size
+	return i
+}
+
+func (c *fakeCore) SessionGet(id string) (*types.Session, error) {
+	if s, ok := c.sessions[id]; ok {
+		return s, nil
+	}
+	return nil, ErrNotFound
+}
+func (c *fakeCore) InstanceGet(session *types.Session, name string) *types.Instance {
+	return c.instances[name]
+}
+func (c *fakeCore) InstanceFindBySession(session *types.Session) ([]*types.Instance, error) {
+	var instances []*types.Instance
+	for _, i := range c.instances {
+		if i.SessionId == session.Id {
+			instances = append(instances, i)
+		}
+	}
+	return instances, nil
+}
+func (c *fakeCore) InstanceNew(session *types.Session, conf types.InstanceConfig) (*types.Instance, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.created = append(c.created, conf)
+	return &types.Instance{Name: "restored", SessionId: session.Id, LessonCtx: conf.LessonCtx}, nil
+}
+func (c *fakeCore) InstanceExec(instance *types.Instance, cmd []string) (int, error) {
+	c.pulled <- cmd[2]
+	return 0, nil
+}
+func (c *fakeCore) InstanceSnapshot(instance *types.Instance, image string) (*types.InstanceSnapshot, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.images[image] = true
+	return &types.InstanceSnapshot{Image: image, Size: c.sizes[instance.Name], InnerImages: []string{"alpine:latest"}}, nil
+}
+func (c *fakeCore) InstanceSnapshotDelete(session *types.Session, snapshot *types.InstanceSnapshot) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.images, snapshot.Image)
+	return nil
+}
+
+func TestService_CreateAndRestore(t *testing.T) {
+	core := newFakeCore()
+	s := NewService(NewMemoryStore(), core, DefaultPolicy())
+	session := &types.Session{Id: "s1", UserId: "alice", Host: "localhost"}
+	instance := core.addInstance(session, "s1_node1", 100)
+	instance.LessonCtx = &types.LessonContext{LessonID: "docker-101", StepIndex: 2}
+
+	snap, err := s.Create("alice", session, "s1_node1", CreateOptions{Name: "before cleanup"})
+	assert.Nil(t, err)
+	assert.Equal(t, ReasonManual, snap.Reason)
+	assert.Equal(t, DefaultRepository+":"+snap.ID, snap.Image)
+	assert.Equal(t, int64(100), snap.Size)
+	assert.Equal(t, "docker-101", snap.LessonCtx.LessonID)
+	assert.False(t, snap.ExpiresAt.IsZero())
+	assert.True(t, core.images[snap.Image])
+
+	_, err = s.Create("alice", session, "s1_missing", CreateOptions{})
+	assert.Equal(t, ErrInstanceNotFound, err)
+
+	restored, err := s.Restore(snap, &types.Session{Id: "s2", UserId: "alice", Host: "localhost"})
+	assert.Nil(t, err)
+	assert.Equal(t, "s2", restored.SessionId)
+	assert.Equal(t, snap.Image, core.created[0].SnapshotImage)
+	assert.Equal(t, 2, core.created[0].LessonCtx.StepIndex)
+	assert.Equal(t, "alpine:latest", <-core.pulled)
+
+	assert.Nil(t, s.Delete(snap))
+	assert.False(t, core.images[snap.Image])
+	_, err = s.Get(snap.ID)
+	assert.Equal(t, ErrNotFound, err)
+}
+
+func TestService_Quota(t *testing.T) {
+	core := newFakeCore()
+	s := NewService(NewMemoryStore(), core, Policy{MaxPerUser: 2, MaxBytesPerUser: 250})
+	session := &types.Session{Id: "s1", UserId: "alice"}
+	core.addInstance(session, "small", 100)
+	core.addInstance(session, "large", 200)
+
+	first, err := s.Create("alice", session, "small", CreateOptions{})
+	assert.Nil(t, err)
+
+	// Snapshots over the size quota are refused and their image deleted
+	_, err = s.Create("alice", session, "large", CreateOptions{})
+	assert.Equal(t, ErrQuotaExceeded, err)
+	assert.Len(t, core.images, 1)
+
+	_, err = s.Create("alice", session, "small", CreateOptions{})
+	assert.Nil(t, err)
+	_, err = s.Create("alice", session, "small", CreateOptions{})
+	assert.Equal(t, ErrQuotaExceeded, err)
+
+	// Checkpoints do not count against the quota
+	_, err = s.Create("alice", session, "large", CreateOptions{Reason: ReasonCheckpoint, LessonCtx: &types.LessonContext{LessonID: "docker-101", StepIndex: 4}})
+	assert.Nil(t, err)
+	usage, _ := s.Usage("alice")
+	assert.Equal(t, Usage{Count: 2, Bytes: 200, MaxCount: 2, MaxBytes: 250}, usage)
+	checkpoints, _ := s.Checkpoints("docker-101")
+	assert.Len(t, checkpoints, 1)
+
+	// Expiry snapshots never evict manual ones
+	err = s.SnapshotSession(&types.Session{Id: "s1", UserId: "alice"})
+	assert.NotNil(t, err)
+	_, err = s.Get(first.ID)
+	assert.Nil(t, err)
+}
+
+func TestService_SnapshotSession(t *testing.T) {
+	core := newFakeCore()
+	s := NewService(NewMemoryStore(), core, Policy{MaxPerUser: 2})
+	now := time.Now()
+	s.now = func() time.Time { return now }
+
+	// Anonymous sessions are not snapshotted
+	anonymous := &types.Session{Id: "s0"}
+	core.addInstance(anonymous, "s0_node1", 100)
+	assert.Nil(t, s.SnapshotSession(anonymous))
+	assert.Empty(t, core.images)
+
+	var ids []string
+	for _, id := range []string{"s1", "s2", "s3"} {
+		session := &types.Session{Id: id, UserId: "alice"}
+		core.addInstance(session, id+"_node1", 100)
+		assert.Nil(t, s.SnapshotSession(session))
+		now = now.Add(time.Minute)
+		snapshots, _ := s.List("alice")
+		ids = append(ids, snapshots[len(snapshots)-1].ID)
+	}
+
+	// The oldest expiry snapshot was evicted to make room for the newest
+	snapshots, _ := s.List("alice")
+	assert.Len(t, snapshots, 2)
+	assert.Equal(t, ids[1], snapshots[0].ID)
+	assert.Equal(t, ReasonExpiry, snapshots[0].Reason)
+	assert.Len(t, core.images, 2)
+}
+
+func TestService_Prune(t *testing.T) {
+	core := newFakeCore()
+	s := NewService(NewMemoryStore(), core, Policy{Retention: time.Hour})
+	now := time.Now()
+	s.now = func() time.Time { return now }
+	session := &types.Session{Id: "s1", UserId: "alice"}
+	core.addInstance(session, "s1_node1", 100)
+
+	snap, err := s.Create("alice", session, "s1_node1", CreateOptions{})
+	assert.Nil(t, err)
+	checkpoint, err := s.Create("teacher", session, "s1_node1", CreateOptions{Reason: ReasonCheckpoint, LessonCtx: &types.LessonContext{LessonID: "docker-101"}})
+	assert.Nil(t, err)
+
+	assert.Nil(t, s.Prune())
+	_, err = s.Get(snap.ID)
+	assert.Nil(t, err)
+
+	now = now.Add(2 * time.Hour)
+	assert.Nil(t, s.Prune())
+	_, err = s.Get(snap.ID)
+	assert.Equal(t, ErrNotFound, err)
+	_, err = s.Get(checkpoint.ID)
+	assert.Nil(t, err)
+}
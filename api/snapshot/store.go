@@ -0,0 +1,29 @@
+package snapshot
+
+import (
+	"errors"
+	"time"
+)
+
+var (
+	// ErrNotFound is returned when a snapshot does not exist
+	ErrNotFound = errors.New("snapshot not found")
+	// ErrAlreadyExists is returned when creating a snapshot with an ID that is already in use
+	ErrAlreadyExists = errors.New("snapshot already exists")
+)
+
+// Store defines the interface for snapshot storage operations
+type Store interface {
+	// CreateSnapshot creates a new snapshot record
+	CreateSnapshot(s *Snapshot) error
+	// GetSnapshot retrieves a snapshot by ID
+	GetSnapshot(id string) (*Snapshot, error)
+	// DeleteSnapshot deletes a snapshot record
+	DeleteSnapshot(id string) error
+	// ListSnapshotsForUser retrieves the snapshots of a user, oldest first
+	ListSnapshotsForUser(userID string) ([]*Snapshot, error)
+	// ListCheckpoints retrieves the checkpoints of a lesson, oldest first
+	ListCheckpoints(lessonID string) ([]*Snapshot, error)
+	// ListExpired retrieves the snapshots that expire before the given time
+	ListExpired(before time.Time) ([]*Snapshot, error)
+}
@@ -0,0 +1,113 @@
+// Package backend defines the runtime learner instances run on. Provisioners
+// only talk to a Runtime, so instances can run as Docker containers or as
+// Kubernetes pods without the provisioner knowing which.
+package backend
+
+import (
+	"errors"
+	"io"
+	"net"
+
+	"github.com/ringo380/lessoncraft/netpolicy"
+	"github.com/ringo380/lessoncraft/pwd/types"
+)
+
+// ErrNotSupported is returned by runtimes for operations they cannot perform
+var ErrNotSupported = errors.New("operation not supported by the instance runtime")
+
+// CreateOpts describes a new instance
+type CreateOpts struct {
+	Image      string
+	SessionId  string
+	Name       string
+	Hostname   string
+	ServerCert []byte
+	ServerKey  []byte
+	CACert     []byte
+	Privileged bool
+	HostFQDN   string
+	Labels     map[string]string
+	// Networks the instance is attached to. The first one is the session network.
+	Networks       []string
+	DindVolumeSize string
+	Envs           []string
+	// DNS servers of the instance, the runtime default if empty
+	DNS []string
+
+	// Resource limits
+	MaxProcesses int64
+	MaxMemoryMB  int64
+	StorageSize  string
+	MaxCPUs      float64
+}
+
+// Runtime creates and manages instances. Instances are addressed by the name
+// they were created with.
+type Runtime interface {
+	// Host identifies where the runtime runs instances, e.g. the docker daemon
+	Host() string
+
+	// Create starts an instance and returns its IP on each of its networks
+	Create(opts CreateOpts) (map[string]string, error)
+	// Delete removes an instance. Deleting an instance that does not exist is not an error.
+	Delete(name string) error
+	// Pause freezes the processes of an instance. It keeps its filesystem,
+	// memory and addresses, so Unpause resumes it as it was.
+	Pause(name string) error
+	Unpause(name string) error
+	// Stop stops an instance to free its CPU and memory, keeping its
+	// filesystem. Start starts it again and returns its IP on each of its
+	// networks, which may have changed.
+	Stop(name string) error
+	Start(name string) (map[string]string, error)
+	Rename(old, new string) error
+
+	Exec(name string, command []string) (int, error)
+	ExecAttach(name string, command []string, out io.Writer) (int, error)
+	// Attach returns a connection to the instance terminal
+	Attach(name string) (net.Conn, error)
+	Resize(name string, rows, cols uint) error
+
+	CopyTo(name, destination, fileName string, content io.Reader) error
+	CopyFrom(name, filePath string) (io.Reader, error)
+	// CopyTarTo extracts a tar archive into the destination directory
+	CopyTarTo(name, destination string, archive io.Reader) error
+	// CopyTarFrom returns the files under srcPath as a tar archive. Entries
+	// are named after the base name of srcPath, e.g. root/.bashrc for /root.
+	CopyTarFrom(name, srcPath string) (io.ReadCloser, error)
+	Stats(name string) (io.ReadCloser, error)
+
+	// Commit saves the filesystem of an instance to an image and returns the
+	// size it adds to the instance image
+	Commit(name, image string, labels map[string]string) (int64, error)
+	// ImageDelete removes a committed image. Deleting an image that does not exist is not an error.
+	ImageDelete(image string) error
+
+	NetworkCreate(network string) error
+	// NetworkConnect attaches an instance to a network and returns its IP on it
+	NetworkConnect(name, network, ip string) (string, error)
+	NetworkDisconnect(name, network string) error
+	NetworkDelete(network string) error
+
+	// SetEgressPolicy restricts the traffic an instance sends with rules,
+	// replacing any previous rules. Nil rules lift the restrictions.
+	SetEgressPolicy(name string, rules []netpolicy.EgressRule) error
+}
+
+// Factory returns the runtime instances of a session run on
+type Factory interface {
+	GetForSession(session *types.Session) (Runtime, error)
+}
+
+type staticFactory struct {
+	runtime Runtime
+}
+
+// NewStaticFactory returns a Factory that runs every session on the same runtime
+func NewStaticFactory(r Runtime) Factory {
+	return &staticFactory{runtime: r}
+}
+
+func (f *staticFactory) GetForSession(session *types.Session) (Runtime, error) {
+	return f.runtime, nil
+}
@@ -0,0 +1,630 @@
+package backend
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/url"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+
+	"github.com/ringo380/lessoncraft/netpolicy"
+)
+
+const (
+	// SessionLabel is set on every pod to the id of its session
+	SessionLabel = "lessoncraft.io/session"
+	// InstanceLabel is set on every pod to its name, so policies can select it
+	InstanceLabel = "lessoncraft.io/instance"
+	// NetworkLabelPrefix prefixes the labels marking the networks a pod is on
+	NetworkLabelPrefix = "network.lessoncraft.io/"
+
+	instanceContainer = "instance"
+	certsDir          = "/opt/lessoncraft/certs"
+)
+
+// Kubernetes runs instances as pods in a namespace. Networks are modelled with
+// pod labels and a NetworkPolicy per network that only admits traffic from pods
+// on the same network, so they need a network plugin that enforces policies.
+//
+// Pods cannot be renamed, so Rename is not supported, and Stats is not supported
+// since pod metrics live outside the core API.
+type Kubernetes struct {
+	client    kubernetes.Interface
+	config    *rest.Config
+	namespace string
+	stream    streamFunc
+
+	startTimeout time.Duration
+	pollInterval time.Duration
+
+	mu        sync.Mutex
+	terminals map[string]chan terminalSize
+}
+
+// NewKubernetes creates a runtime for the pods of a namespace. config is used
+// for exec and attach requests, which do not go through the clientset.
+func NewKubernetes(client kubernetes.Interface, config *rest.Config, namespace string) *Kubernetes {
+	k := &Kubernetes{
+		client:       client,
+		config:       config,
+		namespace:    namespace,
+		startTimeout: 2 * time.Minute,
+		pollInterval: 500 * time.Millisecond,
+		terminals:    map[string]chan terminalSize{},
+	}
+	k.stream = k.websocketStream
+	return k
+}
+
+// SetStartTimeout sets how long Create waits for a pod to be running
+func (k *Kubernetes) SetStartTimeout(timeout time.Duration) {
+	k.startTimeout = timeout
+}
+
+func (k *Kubernetes) Host() string {
+	host := "kubernetes"
+	if k.config != nil {
+		host = k.config.Host
+	}
+	return host + "/" + k.namespace
+}
+
+// podName turns an instance name into a valid pod name
+func podName(name string) string {
+	return strings.NewReplacer("_", "-", ".", "-").Replace(strings.ToLower(name))
+}
+
+func networkLabel(network string) string {
+	return NetworkLabelPrefix + network
+}
+
+func (k *Kubernetes) Create(opts CreateOpts) (map[string]string, error) {
+	ctx := context.Background()
+	name := podName(opts.Name)
+
+	env := append(opts.Envs, fmt.Sprintf("SESSION_ID=%s", opts.SessionId), fmt.Sprintf("LESSONCRAFT_HOST_FQDN=%s", opts.HostFQDN))
+	certs := map[string][]byte{}
+	if len(opts.ServerCert) > 0 {
+		certs["cert.pem"] = opts.ServerCert
+		env = append(env, `DOCKER_TLSCERT=\/opt\/lessoncraft\/certs\/cert.pem`)
+	}
+	if len(opts.ServerKey) > 0 {
+		certs["key.pem"] = opts.ServerKey
+		env = append(env, `DOCKER_TLSKEY=\/opt\/lessoncraft\/certs\/key.pem`)
+	}
+	if len(opts.CACert) > 0 {
+		certs["ca.pem"] = opts.CACert
+		env = append(env, `DOCKER_TLSCACERT=\/opt\/lessoncraft\/certs\/ca.pem`)
+	}
+	if len(certs) > 0 {
+		env = append(env, "DOCKER_TLSENABLE=true")
+	} else {
+		env = append(env, "DOCKER_TLSENABLE=false")
+	}
+
+	labels := map[string]string{SessionLabel: opts.SessionId, InstanceLabel: name}
+	annotations := map[string]string{}
+	for key, value := range opts.Labels {
+		// Label values are restricted, so other values are kept as annotations
+		if len(validation.IsValidLabelValue(value)) == 0 {
+			labels[key] = value
+		} else {
+			annotations[key] = value
+		}
+	}
+	for _, network := range opts.Networks {
+		labels[networkLabel(network)] = "true"
+	}
+
+	container := corev1.Container{
+		Name:            instanceContainer,
+		Image:           opts.Image,
+		Stdin:           true,
+		TTY:             true,
+		SecurityContext: &corev1.SecurityContext{Privileged: &opts.Privileged},
+		Resources:       corev1.ResourceRequirements{Limits: corev1.ResourceList{}},
+	}
+	for _, e := range env {
+		parts := strings.SplitN(e, "=", 2)
+		v := corev1.EnvVar{Name: parts[0]}
+		if len(parts) == 2 {
+			v.Value = parts[1]
+		}
+		container.Env = append(container.Env, v)
+	}
+	if opts.MaxMemoryMB > 0 {
+		container.Resources.Limits[corev1.ResourceMemory] = *resource.NewQuantity(opts.MaxMemoryMB*1024*1024, resource.BinarySI)
+	}
+	if opts.MaxCPUs > 0 {
+		container.Resources.Limits[corev1.ResourceCPU] = *resource.NewMilliQuantity(int64(opts.MaxCPUs*1000), resource.DecimalSI)
+	}
+	if opts.StorageSize != "" {
+		size, err := resource.ParseQuantity(opts.StorageSize)
+		if err != nil {
+			return nil, fmt.Errorf("invalid storage size %q: %v", opts.StorageSize, err)
+		}
+		container.Resources.Limits[corev1.ResourceEphemeralStorage] = size
+	}
+
+	// The inner docker daemon keeps its data in a volume, like the external DinD
+	// volumes of the docker runtime
+	dockerVolume := corev1.EmptyDirVolumeSource{}
+	if opts.DindVolumeSize != "" {
+		size, err := resource.ParseQuantity(opts.DindVolumeSize)
+		if err != nil {
+			return nil, fmt.Errorf("invalid volume size %q: %v", opts.DindVolumeSize, err)
+		}
+		dockerVolume.SizeLimit = &size
+	}
+	volumes := []corev1.Volume{{Name: "docker", VolumeSource: corev1.VolumeSource{EmptyDir: &dockerVolume}}}
+	container.VolumeMounts = []corev1.VolumeMount{{Name: "docker", MountPath: "/var/lib/docker"}}
+
+	if len(certs) > 0 {
+		secret := &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Labels: map[string]string{SessionLabel: opts.SessionId}},
+			Data:       certs,
+		}
+		if _, err := k.client.CoreV1().Secrets(k.namespace).Create(ctx, secret, metav1.CreateOptions{}); err != nil {
+			return nil, err
+		}
+		volumes = append(volumes, corev1.Volume{Name: "certs", VolumeSource: corev1.VolumeSource{Secret: &corev1.SecretVolumeSource{SecretName: name}}})
+		container.VolumeMounts = append(container.VolumeMounts, corev1.VolumeMount{Name: "certs", MountPath: certsDir, ReadOnly: true})
+	}
+
+	automount := false
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Labels: labels, Annotations: annotations},
+		Spec: corev1.PodSpec{
+			Hostname:                     opts.Hostname,
+			RestartPolicy:                corev1.RestartPolicyNever,
+			AutomountServiceAccountToken: &automount,
+			Containers:                   []corev1.Container{container},
+			Volumes:                      volumes,
+		},
+	}
+	if len(opts.DNS) > 0 {
+		pod.Spec.DNSPolicy = corev1.DNSNone
+		pod.Spec.DNSConfig = &corev1.PodDNSConfig{Nameservers: opts.DNS}
+	}
+	if _, err := k.client.CoreV1().Pods(k.namespace).Create(ctx, pod, metav1.CreateOptions{}); err != nil {
+		k.deleteSecret(ctx, name)
+		return nil, err
+	}
+
+	ip, err := k.waitRunning(ctx, name)
+	if err != nil {
+		k.Delete(opts.Name)
+		return nil, err
+	}
+
+	ips := map[string]string{}
+	for _, network := range opts.Networks {
+		ips[network] = ip
+	}
+	return ips, nil
+}
+
+// waitRunning waits for a pod to be running and returns its IP
+func (k *Kubernetes) waitRunning(ctx context.Context, name string) (string, error) {
+	var ip string
+	err := wait.PollUntilContextTimeout(ctx, k.pollInterval, k.startTimeout, true, func(ctx context.Context) (bool, error) {
+		pod, err := k.client.CoreV1().Pods(k.namespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return false, err
+		}
+		switch pod.Status.Phase {
+		case corev1.PodFailed, corev1.PodSucceeded:
+			return false, fmt.Errorf("pod [%s] exited before it was ready: %s", name, pod.Status.Message)
+		case corev1.PodRunning:
+			ip = pod.Status.PodIP
+			return ip != "", nil
+		}
+		return false, nil
+	})
+	if wait.Interrupted(err) {
+		return "", fmt.Errorf("pod [%s] was not running after %s", name, k.startTimeout)
+	}
+	return ip, err
+}
+
+func (k *Kubernetes) Delete(name string) error {
+	ctx := context.Background()
+	name = podName(name)
+
+	grace := int64(0)
+	err := k.client.CoreV1().Pods(k.namespace).Delete(ctx, name, metav1.DeleteOptions{GracePeriodSeconds: &grace})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return err
+	}
+	if err := k.deleteEgressPolicy(ctx, name); err != nil {
+		return err
+	}
+	return k.deleteSecret(ctx, name)
+}
+
+func (k *Kubernetes) deleteSecret(ctx context.Context, name string) error {
+	err := k.client.CoreV1().Secrets(k.namespace).Delete(ctx, name, metav1.DeleteOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return err
+	}
+	return nil
+}
+
+func (k *Kubernetes) Rename(old, new string) error {
+	return ErrNotSupported
+}
+
+func (k *Kubernetes) Exec(name string, command []string) (int, error) {
+	return k.exec(name, command, nil, ioutil.Discard, ioutil.Discard)
+}
+
+func (k *Kubernetes) ExecAttach(name string, command []string, out io.Writer) (int, error) {
+	return k.exec(name, command, nil, out, out)
+}
+
+func (k *Kubernetes) exec(name string, command []string, stdin io.Reader, stdout, stderr io.Writer) (int, error) {
+	query := url.Values{"container": {instanceContainer}, "command": command, "stdout": {"true"}, "stderr": {"true"}}
+	if stdin != nil {
+		query.Set("stdin", "true")
+	}
+	err := k.stream(context.Background(), podName(name), "exec", query, streamOptions{Stdin: stdin, Stdout: stdout, Stderr: stderr})
+	var exit exitError
+	if errors.As(err, &exit) {
+		return exit.code, nil
+	}
+	return 0, err
+}
+
+func (k *Kubernetes) Attach(name string) (net.Conn, error) {
+	pod := podName(name)
+	conn, remote := net.Pipe()
+	sizes := make(chan terminalSize, 1)
+
+	k.mu.Lock()
+	k.terminals[pod] = sizes
+	k.mu.Unlock()
+
+	go func() {
+		query := url.Values{"container": {instanceContainer}, "stdin": {"true"}, "stdout": {"true"}, "tty": {"true"}}
+		err := k.stream(context.Background(), pod, "attach", query, streamOptions{Stdin: remote, Stdout: remote, Resize: sizes})
+		if err != nil {
+			log.Printf("Attach to pod [%s] ended: %v\n", pod, err)
+		}
+		remote.Close()
+
+		k.mu.Lock()
+		if k.terminals[pod] == sizes {
+			delete(k.terminals, pod)
+		}
+		k.mu.Unlock()
+	}()
+	return conn, nil
+}
+
+// Resize resizes the terminal of the pod's current attach connection, if any.
+// Only the latest size is kept until the stream sends it.
+func (k *Kubernetes) Resize(name string, rows, cols uint) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	sizes, found := k.terminals[podName(name)]
+	if !found {
+		return nil
+	}
+	select {
+	case <-sizes:
+	default:
+	}
+	sizes <- terminalSize{Width: uint16(cols), Height: uint16(rows)}
+	return nil
+}
+
+// CopyTo extracts the file into the pod with tar, which the instance image must provide
+func (k *Kubernetes) CopyTo(name, destination, fileName string, content io.Reader) error {
+	contents, err := ioutil.ReadAll(content)
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	t := tar.NewWriter(&buf)
+	if err := t.WriteHeader(&tar.Header{Name: fileName, Mode: 0600, Size: int64(len(contents)), ModTime: time.Now()}); err != nil {
+		return err
+	}
+	if _, err := t.Write(contents); err != nil {
+		return err
+	}
+	if err := t.Close(); err != nil {
+		return err
+	}
+
+	var stderr bytes.Buffer
+	code, err := k.exec(name, []string{"tar", "xf", "-", "-C", destination}, &buf, ioutil.Discard, &stderr)
+	if err != nil {
+		return err
+	}
+	if code != 0 {
+		return fmt.Errorf("Could not copy [%s] to [%s]: %s", fileName, destination, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
+
+func (k *Kubernetes) CopyFrom(name, filePath string) (io.Reader, error) {
+	var stdout, stderr bytes.Buffer
+	code, err := k.exec(name, []string{"cat", "--", filePath}, nil, &stdout, &stderr)
+	if err != nil {
+		return nil, err
+	}
+	if code != 0 {
+		return nil, fmt.Errorf("Could not copy [%s]: %s", filePath, strings.TrimSpace(stderr.String()))
+	}
+	return &stdout, nil
+}
+
+// CopyTarTo extracts the archive into the pod with tar
+func (k *Kubernetes) CopyTarTo(name, destination string, archive io.Reader) error {
+	var stderr bytes.Buffer
+	code, err := k.exec(name, []string{"tar", "xf", "-", "-C", destination}, archive, ioutil.Discard, &stderr)
+	if err != nil {
+		return err
+	}
+	if code != 0 {
+		return fmt.Errorf("Could not extract archive to [%s]: %s", destination, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
+
+// CopyTarFrom streams the output of tar in the pod
+func (k *Kubernetes) CopyTarFrom(name, srcPath string) (io.ReadCloser, error) {
+	srcPath = path.Clean(srcPath)
+	pr, pw := io.Pipe()
+	go func() {
+		var stderr bytes.Buffer
+		code, err := k.exec(name, []string{"tar", "cf", "-", "-C", path.Dir(srcPath), "--", path.Base(srcPath)}, nil, pw, &stderr)
+		if err == nil && code != 0 {
+			err = fmt.Errorf("Could not archive [%s]: %s", srcPath, strings.TrimSpace(stderr.String()))
+		}
+		pw.CloseWithError(err)
+	}()
+	return pr, nil
+}
+
+func (k *Kubernetes) Stats(name string) (io.ReadCloser, error) {
+	return nil, ErrNotSupported
+}
+
+// Commit is not supported, pods cannot be committed to images through the API server
+// Pause is not supported, the API server cannot freeze the processes of a pod
+func (k *Kubernetes) Pause(name string) error {
+	return ErrNotSupported
+}
+
+func (k *Kubernetes) Unpause(name string) error {
+	return ErrNotSupported
+}
+
+func (k *Kubernetes) Stop(name string) error {
+	return ErrNotSupported
+}
+
+func (k *Kubernetes) Start(name string) (map[string]string, error) {
+	return nil, ErrNotSupported
+}
+
+func (k *Kubernetes) Commit(name, image string, labels map[string]string) (int64, error) {
+	return 0, ErrNotSupported
+}
+
+func (k *Kubernetes) ImageDelete(image string) error {
+	return ErrNotSupported
+}
+
+func policyName(network string) string {
+	return "lessoncraft-" + podName(network)
+}
+
+// NetworkCreate creates a NetworkPolicy that only lets pods on the network
+// receive traffic from other pods on it
+func (k *Kubernetes) NetworkCreate(network string) error {
+	selector := metav1.LabelSelector{MatchLabels: map[string]string{networkLabel(network): "true"}}
+	policy := &networkingv1.NetworkPolicy{
+		ObjectMeta: metav1.ObjectMeta{Name: policyName(network)},
+		Spec: networkingv1.NetworkPolicySpec{
+			PodSelector: selector,
+			PolicyTypes: []networkingv1.PolicyType{networkingv1.PolicyTypeIngress},
+			Ingress: []networkingv1.NetworkPolicyIngressRule{{
+				From: []networkingv1.NetworkPolicyPeer{{PodSelector: &selector}},
+			}},
+		},
+	}
+	_, err := k.client.NetworkingV1().NetworkPolicies(k.namespace).Create(context.Background(), policy, metav1.CreateOptions{})
+	if err != nil && !apierrors.IsAlreadyExists(err) {
+		return err
+	}
+	return nil
+}
+
+// NetworkConnect labels the pod as being on the network. Pods have a single IP,
+// so the requested ip is ignored and the pod IP returned.
+func (k *Kubernetes) NetworkConnect(name, network, ip string) (string, error) {
+	pod, err := k.setLabel(name, networkLabel(network), "true")
+	if err != nil {
+		return "", err
+	}
+	return pod.Status.PodIP, nil
+}
+
+func (k *Kubernetes) NetworkDisconnect(name, network string) error {
+	_, err := k.setLabel(name, networkLabel(network), "")
+	return err
+}
+
+func (k *Kubernetes) NetworkDelete(network string) error {
+	err := k.client.NetworkingV1().NetworkPolicies(k.namespace).Delete(context.Background(), policyName(network), metav1.DeleteOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return err
+	}
+	return nil
+}
+
+func egressPolicyName(name string) string {
+	return "lessoncraft-egress-" + podName(name)
+}
+
+// SetEgressPolicy creates a NetworkPolicy for the egress traffic of the pod.
+// Policies can only allow traffic, so every allow rule becomes a policy rule
+// excepting the ranges denied before it, and port-specific deny rules are only
+// enforced where they are not covered by a later allow rule. Pods of the same
+// session can always reach each other.
+func (k *Kubernetes) SetEgressPolicy(name string, rules []netpolicy.EgressRule) error {
+	ctx := context.Background()
+	if rules == nil {
+		return k.deleteEgressPolicy(ctx, name)
+	}
+	pod, err := k.client.CoreV1().Pods(k.namespace).Get(ctx, podName(name), metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+
+	session := metav1.LabelSelector{MatchLabels: map[string]string{SessionLabel: pod.Labels[SessionLabel]}}
+	egress := []networkingv1.NetworkPolicyEgressRule{{To: []networkingv1.NetworkPolicyPeer{{PodSelector: &session}}}}
+	egress = append(egress, egressRules(rules)...)
+
+	policy := &networkingv1.NetworkPolicy{
+		ObjectMeta: metav1.ObjectMeta{Name: egressPolicyName(name), Labels: map[string]string{SessionLabel: pod.Labels[SessionLabel]}},
+		Spec: networkingv1.NetworkPolicySpec{
+			PodSelector: metav1.LabelSelector{MatchLabels: map[string]string{InstanceLabel: podName(name)}},
+			PolicyTypes: []networkingv1.PolicyType{networkingv1.PolicyTypeEgress},
+			Egress:      egress,
+		},
+	}
+	policies := k.client.NetworkingV1().NetworkPolicies(k.namespace)
+	current, err := policies.Get(ctx, policy.Name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		_, err = policies.Create(ctx, policy, metav1.CreateOptions{})
+		return err
+	} else if err != nil {
+		return err
+	}
+	current.Spec = policy.Spec
+	_, err = policies.Update(ctx, current, metav1.UpdateOptions{})
+	return err
+}
+
+// egressRules turns first-match rules into the allow-only rules of a
+// NetworkPolicy. Traffic no rule matches is allowed, like the docker runtime.
+func egressRules(rules []netpolicy.EgressRule) []networkingv1.NetworkPolicyEgressRule {
+	rules = append(rules, netpolicy.EgressRule{Action: netpolicy.Allow, CIDR: "0.0.0.0/0"})
+
+	var egress []networkingv1.NetworkPolicyEgressRule
+	var denied []*net.IPNet
+	for _, r := range rules {
+		_, cidr, err := net.ParseCIDR(r.CIDR)
+		if err != nil {
+			log.Printf("Skipping egress rule with invalid cidr [%s]\n", r.CIDR)
+			continue
+		}
+		if r.Action == netpolicy.Deny {
+			if len(r.Ports) > 0 || r.Protocol != "" {
+				log.Printf("Egress deny rule for [%s] is only enforced where no later rule allows it\n", r.CIDR)
+				continue
+			}
+			denied = append(denied, cidr)
+			continue
+		}
+
+		block := &networkingv1.IPBlock{CIDR: cidr.String()}
+		covered := false
+		for _, d := range denied {
+			dOnes, _ := d.Mask.Size()
+			cOnes, _ := cidr.Mask.Size()
+			if d.Contains(cidr.IP) && dOnes <= cOnes {
+				// The whole range is denied
+				covered = true
+				break
+			}
+			if cidr.Contains(d.IP) && dOnes > cOnes {
+				block.Except = append(block.Except, d.String())
+			}
+		}
+		if covered {
+			continue
+		}
+		rule := networkingv1.NetworkPolicyEgressRule{To: []networkingv1.NetworkPolicyPeer{{IPBlock: block}}}
+		rule.Ports = policyPorts(r)
+		egress = append(egress, rule)
+
+		if r.CIDR == "0.0.0.0/0" && r.Ports == nil && r.Protocol == "" {
+			break
+		}
+	}
+	return egress
+}
+
+func policyPorts(r netpolicy.EgressRule) []networkingv1.NetworkPolicyPort {
+	protocols := []corev1.Protocol{corev1.ProtocolTCP, corev1.ProtocolUDP}
+	if r.Protocol != "" {
+		protocols = []corev1.Protocol{corev1.Protocol(strings.ToUpper(r.Protocol))}
+	} else if len(r.Ports) == 0 {
+		return nil
+	}
+	var ports []networkingv1.NetworkPolicyPort
+	for i := range protocols {
+		protocol := protocols[i]
+		if len(r.Ports) == 0 {
+			ports = append(ports, networkingv1.NetworkPolicyPort{Protocol: &protocol})
+		}
+		for _, p := range r.Ports {
+			port := intstr.FromInt32(int32(p))
+			ports = append(ports, networkingv1.NetworkPolicyPort{Protocol: &protocol, Port: &port})
+		}
+	}
+	return ports
+}
+
+func (k *Kubernetes) deleteEgressPolicy(ctx context.Context, name string) error {
+	err := k.client.NetworkingV1().NetworkPolicies(k.namespace).Delete(ctx, egressPolicyName(name), metav1.DeleteOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return err
+	}
+	return nil
+}
+
+// setLabel sets a pod label, or removes it when value is empty
+func (k *Kubernetes) setLabel(name, label, value string) (*corev1.Pod, error) {
+	ctx := context.Background()
+	pods := k.client.CoreV1().Pods(k.namespace)
+
+	pod, err := pods.Get(ctx, podName(name), metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	if pod.Labels == nil {
+		pod.Labels = map[string]string{}
+	}
+	if value == "" {
+		delete(pod.Labels, label)
+	} else {
+		pod.Labels[label] = value
+	}
+	return pods.Update(ctx, pod, metav1.UpdateOptions{})
+}
@@ -0,0 +1,185 @@
+package backend
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+
+	"github.com/gorilla/websocket"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/remotecommand"
+	"k8s.io/client-go/rest"
+)
+
+// terminalSize is sent on the resize channel of a stream
+type terminalSize struct {
+	Width  uint16
+	Height uint16
+}
+
+type streamOptions struct {
+	Stdin  io.Reader
+	Stdout io.Writer
+	Stderr io.Writer
+	// Resize receives the terminal sizes of a TTY stream
+	Resize <-chan terminalSize
+}
+
+// exitError is returned by exec streams of commands that exited with a non-zero code
+type exitError struct {
+	code int
+}
+
+func (e exitError) Error() string {
+	return fmt.Sprintf("command terminated with exit code %d", e.code)
+}
+
+// streamFunc runs an exec or attach request against a pod
+type streamFunc func(ctx context.Context, pod, subresource string, query url.Values, opts streamOptions) error
+
+// websocketStream runs exec and attach requests with the websocket version of
+// the remote command protocol, which the API server supports since 1.30
+func (k *Kubernetes) websocketStream(ctx context.Context, pod, subresource string, query url.Values, opts streamOptions) error {
+	if k.config == nil {
+		return fmt.Errorf("%s needs a REST config for the cluster", subresource)
+	}
+	u, _, err := rest.DefaultServerUrlFor(k.config)
+	if err != nil {
+		return err
+	}
+	tlsConfig, err := rest.TLSConfigFor(k.config)
+	if err != nil {
+		return err
+	}
+	if u.Scheme == "https" {
+		u.Scheme = "wss"
+	} else {
+		u.Scheme = "ws"
+	}
+	u.Path = fmt.Sprintf("%s/api/v1/namespaces/%s/pods/%s/%s", u.Path, k.namespace, pod, subresource)
+	u.RawQuery = query.Encode()
+
+	header := http.Header{}
+	if k.config.BearerToken != "" {
+		header.Set("Authorization", "Bearer "+k.config.BearerToken)
+	} else if k.config.Username != "" {
+		r := &http.Request{Header: header}
+		r.SetBasicAuth(k.config.Username, k.config.Password)
+	}
+
+	dialer := websocket.Dialer{
+		Proxy:           http.ProxyFromEnvironment,
+		TLSClientConfig: tlsConfig,
+		Subprotocols:    []string{remotecommand.StreamProtocolV5Name},
+	}
+	conn, resp, err := dialer.DialContext(ctx, u.String(), header)
+	if err != nil {
+		if resp != nil {
+			return fmt.Errorf("%s failed with status %d: %v", subresource, resp.StatusCode, err)
+		}
+		return err
+	}
+	defer conn.Close()
+
+	done := make(chan struct{})
+	defer close(done)
+
+	var wmu sync.Mutex
+	write := func(channel byte, data []byte) error {
+		wmu.Lock()
+		defer wmu.Unlock()
+		return conn.WriteMessage(websocket.BinaryMessage, append([]byte{channel}, data...))
+	}
+
+	if opts.Stdin != nil {
+		go func() {
+			buf := make([]byte, 32*1024)
+			for {
+				n, err := opts.Stdin.Read(buf)
+				if n > 0 {
+					if write(remotecommand.StreamStdIn, buf[:n]) != nil {
+						return
+					}
+				}
+				if err != nil {
+					if err == io.EOF {
+						write(remotecommand.StreamClose, []byte{remotecommand.StreamStdIn})
+					}
+					return
+				}
+			}
+		}()
+	}
+	if opts.Resize != nil {
+		go func() {
+			for {
+				select {
+				case size := <-opts.Resize:
+					data, _ := json.Marshal(size)
+					if write(remotecommand.StreamResize, data) != nil {
+						return
+					}
+				case <-done:
+					return
+				}
+			}
+		}()
+	}
+
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			if websocket.IsCloseError(err, websocket.CloseNormalClosure) {
+				return nil
+			}
+			return err
+		}
+		if len(data) == 0 {
+			continue
+		}
+		var out io.Writer
+		switch data[0] {
+		case remotecommand.StreamStdOut:
+			out = opts.Stdout
+		case remotecommand.StreamStdErr:
+			out = opts.Stderr
+		case remotecommand.StreamErr:
+			return statusError(data[1:])
+		}
+		if out != nil {
+			if _, err := out.Write(data[1:]); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// statusError decodes the status sent on the error channel when a command ends
+func statusError(data []byte) error {
+	var status metav1.Status
+	if err := json.Unmarshal(data, &status); err != nil {
+		return fmt.Errorf("invalid stream status %q: %v", data, err)
+	}
+	if status.Status == metav1.StatusSuccess {
+		return nil
+	}
+	if status.Reason == remotecommand.NonZeroExitCodeReason && status.Details != nil {
+		for _, cause := range status.Details.Causes {
+			if cause.Type != remotecommand.ExitCodeCauseType {
+				continue
+			}
+			code, err := strconv.Atoi(cause.Message)
+			if err != nil {
+				return fmt.Errorf("invalid exit code %q", cause.Message)
+			}
+			return exitError{code: code}
+		}
+	}
+	return errors.New(status.Message)
+}
@@ -0,0 +1,307 @@
+package backend
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/ringo380/lessoncraft/netpolicy"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/rest"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+// newTestKubernetes returns a runtime on a fake clientset where created pods are
+// immediately running
+func newTestKubernetes() (*Kubernetes, *fake.Clientset) {
+	client := fake.NewSimpleClientset()
+	client.PrependReactor("create", "pods", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		pod := action.(k8stesting.CreateAction).GetObject().(*corev1.Pod)
+		if pod.Spec.Containers[0].Image != "broken" {
+			pod.Status = corev1.PodStatus{Phase: corev1.PodRunning, PodIP: "10.1.0.5"}
+		} else {
+			pod.Status = corev1.PodStatus{Phase: corev1.PodFailed, Message: "ErrImagePull"}
+		}
+		return false, nil, nil
+	})
+	k := NewKubernetes(client, nil, "learners")
+	k.pollInterval = time.Millisecond
+	return k, client
+}
+
+func TestKubernetes_Create(t *testing.T) {
+	k, client := newTestKubernetes()
+
+	ips, err := k.Create(CreateOpts{
+		Image:          "franela/dind",
+		SessionId:      "aaaabbbbcccc",
+		Name:           "aaaabbbb_node1",
+		Hostname:       "node1",
+		Privileged:     true,
+		HostFQDN:       "localhost",
+		Networks:       []string{"aaaabbbbcccc", "extra"},
+		DindVolumeSize: "5G",
+		Envs:           []string{"FOO=bar"},
+		MaxMemoryMB:    512,
+		ServerCert:     []byte("cert"),
+		ServerKey:      []byte("key"),
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]string{"aaaabbbbcccc": "10.1.0.5", "extra": "10.1.0.5"}, ips)
+
+	pod, err := client.CoreV1().Pods("learners").Get(context.Background(), "aaaabbbb-node1", metav1.GetOptions{})
+	assert.Nil(t, err)
+	assert.Equal(t, "node1", pod.Spec.Hostname)
+	assert.Equal(t, "aaaabbbbcccc", pod.Labels[SessionLabel])
+	assert.Equal(t, "true", pod.Labels[NetworkLabelPrefix+"extra"])
+
+	c := pod.Spec.Containers[0]
+	assert.Equal(t, "franela/dind", c.Image)
+	assert.True(t, *c.SecurityContext.Privileged)
+	assert.Contains(t, c.Env, corev1.EnvVar{Name: "FOO", Value: "bar"})
+	assert.Contains(t, c.Env, corev1.EnvVar{Name: "SESSION_ID", Value: "aaaabbbbcccc"})
+	assert.Contains(t, c.Env, corev1.EnvVar{Name: "DOCKER_TLSENABLE", Value: "true"})
+	assert.Equal(t, "512Mi", c.Resources.Limits.Memory().String())
+	assert.Equal(t, "5G", pod.Spec.Volumes[0].EmptyDir.SizeLimit.String())
+
+	secret, err := client.CoreV1().Secrets("learners").Get(context.Background(), "aaaabbbb-node1", metav1.GetOptions{})
+	assert.Nil(t, err)
+	assert.Equal(t, map[string][]byte{"cert.pem": []byte("cert"), "key.pem": []byte("key")}, secret.Data)
+
+	// Deleting removes the pod with its certificates, and can be repeated
+	assert.Nil(t, k.Delete("aaaabbbb_node1"))
+	assert.Nil(t, k.Delete("aaaabbbb_node1"))
+	pods, _ := client.CoreV1().Pods("learners").List(context.Background(), metav1.ListOptions{})
+	assert.Empty(t, pods.Items)
+	secrets, _ := client.CoreV1().Secrets("learners").List(context.Background(), metav1.ListOptions{})
+	assert.Empty(t, secrets.Items)
+}
+
+func TestKubernetes_CreateFailed(t *testing.T) {
+	k, client := newTestKubernetes()
+
+	_, err := k.Create(CreateOpts{Image: "broken", SessionId: "aaaabbbbcccc", Name: "aaaabbbb_node1", Networks: []string{"aaaabbbbcccc"}})
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "ErrImagePull")
+
+	pods, _ := client.CoreV1().Pods("learners").List(context.Background(), metav1.ListOptions{})
+	assert.Empty(t, pods.Items)
+}
+
+func TestKubernetes_Networks(t *testing.T) {
+	k, client := newTestKubernetes()
+	_, err := k.Create(CreateOpts{Image: "franela/dind", SessionId: "aaaabbbbcccc", Name: "aaaabbbb_node1", Networks: []string{"aaaabbbbcccc"}})
+	assert.Nil(t, err)
+
+	assert.Nil(t, k.NetworkCreate("other"))
+	assert.Nil(t, k.NetworkCreate("other"))
+	policy, err := client.NetworkingV1().NetworkPolicies("learners").Get(context.Background(), "lessoncraft-other", metav1.GetOptions{})
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]string{NetworkLabelPrefix + "other": "true"}, policy.Spec.PodSelector.MatchLabels)
+
+	ip, err := k.NetworkConnect("aaaabbbb_node1", "other", "")
+	assert.Nil(t, err)
+	assert.Equal(t, "10.1.0.5", ip)
+	pod, _ := client.CoreV1().Pods("learners").Get(context.Background(), "aaaabbbb-node1", metav1.GetOptions{})
+	assert.Equal(t, "true", pod.Labels[NetworkLabelPrefix+"other"])
+
+	assert.Nil(t, k.NetworkDisconnect("aaaabbbb_node1", "other"))
+	pod, _ = client.CoreV1().Pods("learners").Get(context.Background(), "aaaabbbb-node1", metav1.GetOptions{})
+	assert.NotContains(t, pod.Labels, NetworkLabelPrefix+"other")
+
+	assert.Nil(t, k.NetworkDelete("other"))
+	assert.Nil(t, k.NetworkDelete("other"))
+}
+
+func TestKubernetes_EgressPolicy(t *testing.T) {
+	k, client := newTestKubernetes()
+	_, err := k.Create(CreateOpts{
+		Image:     "franela/dind",
+		SessionId: "aaaabbbbcccc",
+		Name:      "aaaabbbb_node1",
+		Networks:  []string{"aaaabbbbcccc"},
+		DNS:       []string{"10.0.0.2"},
+		Labels:    map[string]string{"lessoncraft.dns.allow": "docker.io,github.com"},
+	})
+	assert.Nil(t, err)
+	pod, _ := client.CoreV1().Pods("learners").Get(context.Background(), "aaaabbbb-node1", metav1.GetOptions{})
+	assert.Equal(t, corev1.DNSNone, pod.Spec.DNSPolicy)
+	assert.Equal(t, []string{"10.0.0.2"}, pod.Spec.DNSConfig.Nameservers)
+	assert.Equal(t, "aaaabbbb-node1", pod.Labels[InstanceLabel])
+	// Values that are not valid label values are kept as annotations
+	assert.Equal(t, "docker.io,github.com", pod.Annotations["lessoncraft.dns.allow"])
+
+	policy := &netpolicy.Policy{
+		DefaultEgress: netpolicy.Deny,
+		Allow:         []netpolicy.Rule{{CIDR: "0.0.0.0/0", Protocol: "tcp", Ports: []int{443}}},
+		Deny:          []netpolicy.Rule{{CIDR: "203.0.113.0/24"}},
+	}
+	assert.Nil(t, k.SetEgressPolicy("aaaabbbb_node1", policy.EgressRules("")))
+	np, err := client.NetworkingV1().NetworkPolicies("learners").Get(context.Background(), "lessoncraft-egress-aaaabbbb-node1", metav1.GetOptions{})
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]string{InstanceLabel: "aaaabbbb-node1"}, np.Spec.PodSelector.MatchLabels)
+	assert.Len(t, np.Spec.Egress, 2)
+	// Pods of the session can reach each other
+	assert.Equal(t, map[string]string{SessionLabel: "aaaabbbbcccc"}, np.Spec.Egress[0].To[0].PodSelector.MatchLabels)
+	assert.Equal(t, "0.0.0.0/0", np.Spec.Egress[1].To[0].IPBlock.CIDR)
+	assert.Equal(t, []string{"203.0.113.0/24"}, np.Spec.Egress[1].To[0].IPBlock.Except)
+	assert.Equal(t, int32(443), np.Spec.Egress[1].Ports[0].Port.IntVal)
+	assert.Equal(t, corev1.ProtocolTCP, *np.Spec.Egress[1].Ports[0].Protocol)
+
+	// Policies are replaced, and traffic no rule matches is allowed
+	assert.Nil(t, k.SetEgressPolicy("aaaabbbb_node1", (&netpolicy.Policy{Isolate: true}).EgressRules("")))
+	np, _ = client.NetworkingV1().NetworkPolicies("learners").Get(context.Background(), "lessoncraft-egress-aaaabbbb-node1", metav1.GetOptions{})
+	assert.Len(t, np.Spec.Egress, 2)
+	assert.Equal(t, "0.0.0.0/0", np.Spec.Egress[1].To[0].IPBlock.CIDR)
+	assert.Len(t, np.Spec.Egress[1].To[0].IPBlock.Except, len(netpolicy.PrivateRanges))
+	assert.Nil(t, np.Spec.Egress[1].Ports)
+
+	// Deleting the pod deletes its policy
+	assert.Nil(t, k.Delete("aaaabbbb_node1"))
+	policies, _ := client.NetworkingV1().NetworkPolicies("learners").List(context.Background(), metav1.ListOptions{})
+	assert.Empty(t, policies.Items)
+}
+
+func TestKubernetes_Exec(t *testing.T) {
+	k, _ := newTestKubernetes()
+
+	var commands [][]string
+	var stdin []byte
+	k.stream = func(ctx context.Context, pod, subresource string, query url.Values, opts streamOptions) error {
+		assert.Equal(t, "aaaabbbb-node1", pod)
+		assert.Equal(t, "exec", subresource)
+		command := query["command"]
+		commands = append(commands, command)
+		if opts.Stdin != nil {
+			assert.Equal(t, "true", query.Get("stdin"))
+			stdin, _ = ioutil.ReadAll(opts.Stdin)
+		}
+		switch command[0] {
+		case "cat":
+			if command[2] == "/missing" {
+				io.WriteString(opts.Stderr, "cat: /missing: No such file or directory\n")
+				return exitError{code: 1}
+			}
+			io.WriteString(opts.Stdout, "hello")
+		case "false":
+			return exitError{code: 2}
+		}
+		return nil
+	}
+
+	code, err := k.Exec("aaaabbbb_node1", []string{"false"})
+	assert.Nil(t, err)
+	assert.Equal(t, 2, code)
+
+	r, err := k.CopyFrom("aaaabbbb_node1", "/root/file.txt")
+	assert.Nil(t, err)
+	content, _ := ioutil.ReadAll(r)
+	assert.Equal(t, "hello", string(content))
+
+	_, err = k.CopyFrom("aaaabbbb_node1", "/missing")
+	assert.EqualError(t, err, "Could not copy [/missing]: cat: /missing: No such file or directory")
+
+	assert.Nil(t, k.CopyTo("aaaabbbb_node1", "/root", "file.txt", strings.NewReader("hi")))
+	assert.Equal(t, []string{"tar", "xf", "-", "-C", "/root"}, commands[len(commands)-1])
+	tr := tar.NewReader(bytes.NewReader(stdin))
+	header, err := tr.Next()
+	assert.Nil(t, err)
+	assert.Equal(t, "file.txt", header.Name)
+	content, _ = ioutil.ReadAll(tr)
+	assert.Equal(t, "hi", string(content))
+
+	// Stream failures other than exit codes are reported as errors
+	k.stream = func(ctx context.Context, pod, subresource string, query url.Values, opts streamOptions) error {
+		return errors.New("connection refused")
+	}
+	_, err = k.Exec("aaaabbbb_node1", []string{"true"})
+	assert.NotNil(t, err)
+}
+
+func TestKubernetes_Attach(t *testing.T) {
+	k, _ := newTestKubernetes()
+
+	sizes := make(chan terminalSize, 1)
+	k.stream = func(ctx context.Context, pod, subresource string, query url.Values, opts streamOptions) error {
+		assert.Equal(t, "attach", subresource)
+		assert.Equal(t, "true", query.Get("tty"))
+		sizes <- <-opts.Resize
+		// Echo the terminal input
+		buf := make([]byte, 5)
+		n, _ := opts.Stdin.Read(buf)
+		opts.Stdout.Write(buf[:n])
+		return nil
+	}
+
+	conn, err := k.Attach("aaaabbbb_node1")
+	assert.Nil(t, err)
+	assert.Nil(t, k.Resize("aaaabbbb_node1", 24, 80))
+	assert.Equal(t, terminalSize{Width: 80, Height: 24}, <-sizes)
+
+	go conn.Write([]byte("ls\r"))
+	buf := make([]byte, 5)
+	n, err := conn.Read(buf)
+	assert.Nil(t, err)
+	assert.Equal(t, "ls\r", string(buf[:n]))
+
+	// The connection is closed when the stream ends
+	_, err = conn.Read(buf)
+	assert.Equal(t, io.EOF, err)
+}
+
+func TestKubernetes_WebsocketStream(t *testing.T) {
+	upgrader := websocket.Upgrader{Subprotocols: []string{"v5.channel.k8s.io"}}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/api/v1/namespaces/learners/pods/aaaabbbb-node1/exec", r.URL.Path)
+		assert.Equal(t, []string{"tr", "a-z", "A-Z"}, r.URL.Query()["command"])
+		assert.Equal(t, "Bearer secret", r.Header.Get("Authorization"))
+
+		conn, err := upgrader.Upgrade(w, r, nil)
+		assert.Nil(t, err)
+		defer conn.Close()
+		assert.Equal(t, "v5.channel.k8s.io", conn.Subprotocol())
+
+		// Upper-case stdin until it is closed, then fail with exit code 3
+		for {
+			_, data, err := conn.ReadMessage()
+			assert.Nil(t, err)
+			if data[0] == 255 {
+				assert.Equal(t, []byte{255, 0}, data)
+				break
+			}
+			conn.WriteMessage(websocket.BinaryMessage, append([]byte{1}, bytes.ToUpper(data[1:])...))
+		}
+		conn.WriteMessage(websocket.BinaryMessage, append([]byte{2}, "warning"...))
+		status, _ := json.Marshal(metav1.Status{
+			Status:  metav1.StatusFailure,
+			Reason:  "NonZeroExitCode",
+			Details: &metav1.StatusDetails{Causes: []metav1.StatusCause{{Type: "ExitCode", Message: "3"}}},
+		})
+		conn.WriteMessage(websocket.BinaryMessage, append([]byte{3}, status...))
+	}))
+	defer server.Close()
+
+	k := NewKubernetes(fake.NewSimpleClientset(), &rest.Config{Host: server.URL, BearerToken: "secret"}, "learners")
+
+	var stdout, stderr bytes.Buffer
+	code, err := k.exec("aaaabbbb_node1", []string{"tr", "a-z", "A-Z"}, strings.NewReader("hello"), &stdout, &stderr)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, code)
+	assert.Equal(t, "HELLO", stdout.String())
+	assert.Equal(t, "warning", stderr.String())
+}
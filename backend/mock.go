@@ -0,0 +1,120 @@
+package backend
+
+import (
+	"io"
+	"net"
+
+	"github.com/ringo380/lessoncraft/netpolicy"
+	"github.com/ringo380/lessoncraft/pwd/types"
+	"github.com/stretchr/testify/mock"
+)
+
+type Mock struct {
+	mock.Mock
+}
+
+func (m *Mock) Host() string {
+	args := m.Called()
+	return args.String(0)
+}
+func (m *Mock) Create(opts CreateOpts) (map[string]string, error) {
+	args := m.Called(opts)
+	return args.Get(0).(map[string]string), args.Error(1)
+}
+func (m *Mock) Delete(name string) error {
+	args := m.Called(name)
+	return args.Error(0)
+}
+func (m *Mock) Rename(old, new string) error {
+	args := m.Called(old, new)
+	return args.Error(0)
+}
+func (m *Mock) Exec(name string, command []string) (int, error) {
+	args := m.Called(name, command)
+	return args.Int(0), args.Error(1)
+}
+func (m *Mock) ExecAttach(name string, command []string, out io.Writer) (int, error) {
+	args := m.Called(name, command, out)
+	return args.Int(0), args.Error(1)
+}
+func (m *Mock) Attach(name string) (net.Conn, error) {
+	args := m.Called(name)
+	return args.Get(0).(net.Conn), args.Error(1)
+}
+func (m *Mock) Resize(name string, rows, cols uint) error {
+	args := m.Called(name, rows, cols)
+	return args.Error(0)
+}
+func (m *Mock) CopyTo(name, destination, fileName string, content io.Reader) error {
+	args := m.Called(name, destination, fileName, content)
+	return args.Error(0)
+}
+func (m *Mock) CopyFrom(name, filePath string) (io.Reader, error) {
+	args := m.Called(name, filePath)
+	return args.Get(0).(io.Reader), args.Error(1)
+}
+func (m *Mock) CopyTarTo(name, destination string, archive io.Reader) error {
+	args := m.Called(name, destination, archive)
+	return args.Error(0)
+}
+func (m *Mock) CopyTarFrom(name, srcPath string) (io.ReadCloser, error) {
+	args := m.Called(name, srcPath)
+	return args.Get(0).(io.ReadCloser), args.Error(1)
+}
+func (m *Mock) Stats(name string) (io.ReadCloser, error) {
+	args := m.Called(name)
+	return args.Get(0).(io.ReadCloser), args.Error(1)
+}
+func (m *Mock) Pause(name string) error {
+	args := m.Called(name)
+	return args.Error(0)
+}
+func (m *Mock) Unpause(name string) error {
+	args := m.Called(name)
+	return args.Error(0)
+}
+func (m *Mock) Stop(name string) error {
+	args := m.Called(name)
+	return args.Error(0)
+}
+func (m *Mock) Start(name string) (map[string]string, error) {
+	args := m.Called(name)
+	return args.Get(0).(map[string]string), args.Error(1)
+}
+func (m *Mock) Commit(name, image string, labels map[string]string) (int64, error) {
+	args := m.Called(name, image, labels)
+	return args.Get(0).(int64), args.Error(1)
+}
+func (m *Mock) ImageDelete(image string) error {
+	args := m.Called(image)
+	return args.Error(0)
+}
+func (m *Mock) NetworkCreate(network string) error {
+	args := m.Called(network)
+	return args.Error(0)
+}
+func (m *Mock) NetworkConnect(name, network, ip string) (string, error) {
+	args := m.Called(name, network, ip)
+	return args.String(0), args.Error(1)
+}
+func (m *Mock) NetworkDisconnect(name, network string) error {
+	args := m.Called(name, network)
+	return args.Error(0)
+}
+func (m *Mock) NetworkDelete(network string) error {
+	args := m.Called(network)
+	return args.Error(0)
+}
+func (m *Mock) SetEgressPolicy(name string, rules []netpolicy.EgressRule) error {
+	args := m.Called(name, rules)
+	return args.Error(0)
+}
+
+type FactoryMock struct {
+	mock.Mock
+}
+
+func (m *FactoryMock) GetForSession(session *types.Session) (Runtime, error) {
+	args := m.Called(session)
+	return args.Get(0).(Runtime), args.Error(1)
+}
@@ -4,6 +4,7 @@ import (
 	"flag"
 	"os"
 	"regexp"
+	"time"
 
 	"github.com/gorilla/securecookie"
 
@@ -42,8 +43,150 @@ var Unsafe bool
 
 var PlaygroundDomain string
 
+// FirewallImage is the image run on docker hosts to change their firewall. It needs iptables.
+var FirewallImage string
+
 var SegmentId string
 
+// WorkspaceArchiveMaxMB caps the size of the files of workspace archives
+// downloaded from instances or uploaded to them
+var WorkspaceArchiveMaxMB int
+
+// WarmPoolInterval is how often the pools of idle DinD containers are refilled.
+// Pools are sized with the WarmPoolSize and WarmPoolSizes playground extras.
+// Instances are always created on demand when it is 0.
+var WarmPoolInterval time.Duration
+
+// Runtime is what instances run on: "docker" runs them as containers on the
+// docker hosts, "kubernetes" as pods in KubeNamespace
+var Runtime string
+
+// KubeConfig is the kubeconfig file instances are run as pods with. The
+// in-cluster configuration is used when it is empty.
+var KubeConfig string
+
+// KubeNamespace is the namespace the pods of instances are created in
+var KubeNamespace string
+
+// DockerHosts is a comma separated list of the docker daemons sessions are
+// placed on, as id=addr pairs, e.g. a=tcp://10.0.0.5:2375. Sessions are placed
+// on the local daemon when it is empty. More hosts can be added through the
+// hosts API.
+var DockerHosts string
+
+// PlacementStrategy is a comma separated list of the strategies that choose the
+// host of new sessions, e.g. image-local,least-loaded
+var PlacementStrategy string
+
+// HostProbeInterval is how often the capacity of the docker hosts is refreshed
+var HostProbeInterval time.Duration
+
+// RateLimit is how many requests a client can make to the API per minute,
+// counted per API key, user or IP address. Requests are not limited when it is 0.
+var RateLimit int
+
+// RateLimitRedis is the host:port of a Redis server the rate limit counters are
+// kept in, so replicas share them. They are kept in memory when it is empty.
+var RateLimitRedis string
+
+// RateLimitRedisPassword authenticates to the rate limit Redis server
+var RateLimitRedisPassword string
+
+// OIDCConfigPath is a JSON file listing the OpenID Connect providers users can
+// sign in with. Only local accounts can sign in when it is empty.
+var OIDCConfigPath string
+
+// AuditLogPath is the file the audit log is appended to. The log is only kept
+// in memory, and lost on restart, when it is empty.
+var AuditLogPath string
+
+// L2AccessKey signs the tokens the l2 router requires to reach the ports of
+// instances. Ports are not protected when it is empty.
+var L2AccessKey string
+
+// L2PublicPorts is a comma separated list of the instance ports the l2 router
+// serves without a token
+var L2PublicPorts string
+
+// L2TrustedNetworks is a comma separated list of the networks LessonCraft
+// reaches instances from through the l2 router, which need no token
+var L2TrustedNetworks string
+
+// L2SSHAuthURL is the LessonCraft API endpoint the l2 router checks the public
+// keys of SSH logins with. SSH logins are refused when it is empty, unless
+// L2SSHAnyKey is set.
+var L2SSHAuthURL string
+
+// L2SSHAnyKey makes the l2 router accept SSH logins with any key when
+// L2SSHAuthURL is empty
+var L2SSHAnyKey bool
+
+// L2InstanceSSHKey is the private key file the l2 router logs in to instances
+// with. LessonCraft authorizes its public key on new instances. The router
+// falls back to the root password of the instances when it is empty.
+var L2InstanceSSHKey string
+
+// L2EndpointsURL is the LessonCraft API endpoint the l2 router resolves the
+// named endpoints of sessions with. Endpoint hosts are unknown when it is
+// empty.
+var L2EndpointsURL string
+
+// L2PortRange is the range of ports of the l2 router sessions can have
+// forwarded to their instances, e.g. 30000-30099
+var L2PortRange string
+
+// L2PortsURL is the LessonCraft API endpoint the l2 router fetches the port
+// forwards of sessions from. No port is forwarded when it is empty.
+var L2PortsURL string
+
+// L2ZonesURL is the l2 router endpoint LessonCraft pushes the DNS zones of
+// sessions to as their instances change. Instances cannot find each other by
+// hostname or role when it is empty.
+var L2ZonesURL string
+
+// L2DNSDomain is the domain the l2 router serves the DNS zones of sessions
+// under, e.g. db.<session id>.lessoncraft.internal
+var L2DNSDomain string
+
+// L2DNSUpstream is the DNS server the l2 router forwards the queries it cannot
+// answer to. They are looked up with the system resolver when it is empty.
+var L2DNSUpstream string
+
+// L2MaxConnections caps the connections and HTTP requests the l2 router
+// proxies at once, 0 for no limit
+var L2MaxConnections int
+
+// L2MaxSessionConnections caps the connections and HTTP requests the l2
+// router proxies at once to the instances of a session, 0 for no limit
+var L2MaxSessionConnections int
+
+// L2IdleTimeout closes connections passed through the l2 router after this
+// long without traffic
+var L2IdleTimeout time.Duration
+
+// L2DrainTimeout is how long the l2 router waits for connections to finish
+// when it is stopped
+var L2DrainTimeout time.Duration
+
+// L2TLSMode makes the l2 router terminate TLS for instance hosts: "wildcard"
+// with L2TLSCert and L2TLSKey, "acme" with certificates requested on demand
+// for hosts under L2TLSDomain, or "local" with a local CA kept in L2TLSDir.
+// TLS is passed through to instances when it is empty.
+var L2TLSMode string
+
+// L2TLSCert and L2TLSKey are the files of the wildcard certificate
+var L2TLSCert, L2TLSKey string
+
+// L2TLSDomain is the domain instance hosts live under, e.g. direct.example.com
+var L2TLSDomain string
+
+// L2TLSDir keeps the ACME certificates or the local CA
+var L2TLSDir string
+
+// L2TLSPassthroughPorts is a comma separated list of instance ports whose TLS
+// is passed through even when the router terminates TLS
+var L2TLSPassthroughPorts string
+
 // TODO move this to a sync map so it can be updated on demand when the configuration for a playground changes
 var Providers = map[string]map[string]*oauth2.Config{}
 
@@ -66,9 +209,45 @@ func ParseFlags() {
 	flag.StringVar(&CookieBlockKey, "cookie-block-key", "", "Block key to use to encrypt cookies")
 
 	flag.StringVar(&PlaygroundDomain, "playground-domain", "localhost", "Domain to use for the playground")
+	flag.StringVar(&FirewallImage, "firewall-image", "lessoncraft/firewall", "Image used to apply network policies on docker hosts")
 	flag.StringVar(&AdminToken, "admin-token", "", "Token to validate admin user for admin endpoints")
 
 	flag.StringVar(&SegmentId, "segment-id", "", "Segment id to post metrics")
+	flag.IntVar(&WorkspaceArchiveMaxMB, "workspace-archive-max-mb", 200, "Maximum size in MB of the files of workspace archives downloaded from or uploaded to instances")
+	flag.DurationVar(&WarmPoolInterval, "warm-pool-interval", 0, "How often the pools of idle instances sized by the playgrounds are refilled, 0 to create every instance on demand")
+	flag.StringVar(&Runtime, "runtime", "docker", "What instances run on: docker or kubernetes")
+	flag.StringVar(&KubeConfig, "kubeconfig", "", "Kubeconfig file of the cluster instances run in with the kubernetes runtime. Empty uses the in-cluster configuration")
+	flag.StringVar(&KubeNamespace, "kube-namespace", "lessoncraft", "Namespace the pods of instances are created in with the kubernetes runtime")
+	flag.StringVar(&DockerHosts, "docker-hosts", "", "Comma separated id=addr docker daemons sessions are placed on, e.g. a=tcp://10.0.0.5:2375. Empty uses the local daemon")
+	flag.StringVar(&PlacementStrategy, "placement-strategy", "least-loaded", "Comma separated strategies choosing the docker host of new sessions: least-loaded, bin-pack, image-local or anti-affinity")
+	flag.DurationVar(&HostProbeInterval, "host-probe-interval", 30*time.Second, "How often the capacity of the docker hosts is refreshed")
+	flag.IntVar(&RateLimit, "rate-limit", 300, "Requests a client can make to the API per minute, 0 for no limit")
+	flag.StringVar(&RateLimitRedis, "rate-limit-redis", "", "host:port of a Redis server replicas share rate limit counters in, empty to keep them in memory")
+	flag.StringVar(&RateLimitRedisPassword, "rate-limit-redis-password", os.Getenv("LESSONCRAFT_RATE_LIMIT_REDIS_PASSWORD"), "Password of the rate limit Redis server")
+	flag.StringVar(&OIDCConfigPath, "oidc-config", "", "JSON file listing the OpenID Connect providers users can sign in with")
+	flag.StringVar(&AuditLogPath, "audit-log", "./pwd/audit.log", "File the audit log is appended to, empty to keep it in memory")
+	flag.StringVar(&L2AccessKey, "l2-access-key", os.Getenv("LESSONCRAFT_L2_ACCESS_KEY"), "Key signing the tokens required to reach instance ports through the L2 router, empty to leave ports open")
+	flag.StringVar(&L2PublicPorts, "l2-public-ports", "", "Comma separated instance ports reachable through the L2 router without a token")
+	flag.StringVar(&L2TrustedNetworks, "l2-trusted-networks", "", "Comma separated networks that reach instance ports through the L2 router without a token")
+	flag.StringVar(&L2TLSMode, "l2-tls-mode", "", "How the L2 router terminates TLS for instance hosts: wildcard, acme or local. Empty passes TLS through to instances")
+	flag.StringVar(&L2TLSCert, "l2-tls-cert", "", "Wildcard certificate file for the L2 router")
+	flag.StringVar(&L2TLSKey, "l2-tls-key", "", "Wildcard certificate key file for the L2 router")
+	flag.StringVar(&L2TLSDomain, "l2-tls-domain", "", "Domain instance hosts live under, e.g. direct.example.com, for ACME certificates")
+	flag.StringVar(&L2TLSDir, "l2-tls-dir", "/certs/l2", "Path where the L2 router keeps ACME certificates or its local CA")
+	flag.StringVar(&L2TLSPassthroughPorts, "l2-tls-passthrough-ports", "2376", "Comma separated instance ports whose TLS the L2 router passes through")
+	flag.StringVar(&L2SSHAuthURL, "l2-ssh-auth-url", "", "LessonCraft endpoint the L2 router checks SSH public keys with, e.g. https://lessoncraft.example.com/api/ssh/authorize. Requires l2-access-key")
+	flag.BoolVar(&L2SSHAnyKey, "l2-ssh-any-key", false, "Accept SSH logins through the L2 router with any key when l2-ssh-auth-url is empty")
+	flag.StringVar(&L2InstanceSSHKey, "l2-instance-ssh-key", "", "Private key file the L2 router logs in to instances with. LessonCraft authorizes its public key on new instances")
+	flag.StringVar(&L2EndpointsURL, "l2-endpoints-url", "", "LessonCraft endpoint the L2 router resolves session endpoints with, e.g. https://lessoncraft.example.com/router/endpoints/resolve. Requires l2-access-key")
+	flag.StringVar(&L2PortRange, "l2-port-range", "30000-30099", "Range of L2 router ports sessions can forward to their instances")
+	flag.StringVar(&L2PortsURL, "l2-ports-url", "", "LessonCraft endpoint the L2 router fetches port forwards from, e.g. https://lessoncraft.example.com/router/ports. Requires l2-access-key")
+	flag.StringVar(&L2ZonesURL, "l2-zones-url", "", "L2 router endpoint LessonCraft pushes the DNS zones of sessions to, e.g. http://l2:8080/zones. Requires l2-access-key")
+	flag.StringVar(&L2DNSDomain, "l2-dns-domain", "lessoncraft.internal", "Domain the L2 router serves the DNS zones of sessions under")
+	flag.StringVar(&L2DNSUpstream, "l2-dns-upstream", "", "DNS server the L2 router forwards other queries to, e.g. 8.8.8.8:53. Empty uses the system resolver")
+	flag.IntVar(&L2MaxConnections, "l2-max-connections", 0, "Maximum connections the L2 router proxies at once, 0 for no limit")
+	flag.IntVar(&L2MaxSessionConnections, "l2-max-session-connections", 0, "Maximum connections the L2 router proxies at once to a session, 0 for no limit")
+	flag.DurationVar(&L2IdleTimeout, "l2-idle-timeout", time.Hour, "Time after which the L2 router closes idle TLS and SSH connections, 0 to keep them open")
+	flag.DurationVar(&L2DrainTimeout, "l2-drain-timeout", 5*time.Minute, "Time the L2 router waits for connections to finish when it is stopped")
 
 	flag.BoolVar(&Unsafe, "unsafe", os.Getenv("LESSONCRAFT_UNSAFE") == "true", "Operate in unsafe mode")
 
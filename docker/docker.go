@@ -4,6 +4,7 @@ import (
 	"archive/tar"
 	"bytes"
 	"context"
+	"encoding/json"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -17,14 +18,19 @@ import (
 	"github.com/containerd/containerd/reference"
 	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/image"
 	"github.com/docker/docker/api/types/network"
 	"github.com/docker/docker/api/types/swarm"
+	"github.com/docker/docker/api/types/system"
 	"github.com/docker/docker/api/types/volume"
 	"github.com/docker/docker/client"
 	"github.com/ringo380/lessoncraft/config"
-	"github.com/ringo380/lessoncraft/internal/circuitbreaker"
 )
 
+// GatewayBridgeNetwork is the bridge containers on overlay networks reach the
+// outside world through
+const GatewayBridgeNetwork = "docker_gwbridge"
+
 const (
 	Byte     = 1
 	Kilobyte = 1024 * Byte
@@ -32,15 +38,17 @@ const (
 )
 
 type DockerApi interface {
-	GetClient() *client.Client
+	// Ping checks that the daemon is reachable
+	Ping() error
+	Close() error
 
-	NetworkCreate(id string, opts types.NetworkCreate) error
+	NetworkCreate(id string, opts network.CreateOptions) error
 	NetworkConnect(container, network, ip string) (string, error)
-	NetworkInspect(id string) (types.NetworkResource, error)
+	NetworkInspect(id string) (network.Inspect, error)
 	NetworkDelete(id string) error
 	NetworkDisconnect(containerId, networkId string) error
 
-	DaemonInfo() (types.Info, error)
+	DaemonInfo() (system.Info, error)
 	DaemonHost() string
 
 	GetSwarmPorts() ([]string, []uint16, error)
@@ -50,14 +58,40 @@ type DockerApi interface {
 	ContainerResize(name string, rows, cols uint) error
 	ContainerRename(old, new string) error
 	ContainerDelete(name string) error
+	// ContainerPause freezes the processes of a container, keeping its memory
+	ContainerPause(name string) error
+	ContainerUnpause(name string) error
+	// ContainerStop stops a container, keeping its filesystem until it is
+	// deleted. ContainerStart starts it again.
+	ContainerStop(name string) error
+	ContainerStart(name string) error
 	ContainerCreate(opts CreateContainerOpts) error
 	ContainerIPs(id string) (map[string]string, error)
+	// ContainerGatewayIP returns the IP of a container on the gateway bridge,
+	// which containers on overlay networks reach the outside world through
+	ContainerGatewayIP(name string) (string, error)
+	// ContainerCommit commits the filesystem of a container to an image and
+	// returns the size of the layer it added
+	ContainerCommit(name, reference string, labels map[string]string) (int64, error)
+	ImageDelete(reference string) error
+	// ImageTags returns the tags of the images pulled on the daemon
+	ImageTags() ([]string, error)
+	// ImageBuild builds an image, writing the build output to out. It returns
+	// the ID and size of the image.
+	ImageBuild(ctx context.Context, opts ImageBuildOpts, out io.Writer) (string, int64, error)
 	ExecAttach(instanceName string, command []string, out io.Writer) (int, error)
 	Exec(instanceName string, command []string) (int, error)
+	// HostExec runs a command in a throwaway container on the network of the
+	// host, with the capability to change its firewall
+	HostExec(image string, command []string) (int, error)
 
 	CreateAttachConnection(name string) (net.Conn, error)
 	CopyToContainer(containerName, destination, fileName string, content io.Reader) error
 	CopyFromContainer(containerName, filePath string) (io.Reader, error)
+	// CopyTarToContainer extracts a tar archive into the destination directory
+	CopyTarToContainer(containerName, destination string, archive io.Reader) error
+	// CopyTarFromContainer returns the files under srcPath as a tar archive
+	CopyTarFromContainer(containerName, srcPath string) (io.ReadCloser, error)
 	SwarmInit(advertiseAddr string) (*SwarmTokens, error)
 	SwarmJoin(addr, token string) error
 
@@ -74,8 +108,13 @@ type docker struct {
 	c *client.Client
 }
 
-func (d *docker) GetClient() *client.Client {
-	return d.c
+func (d *docker) Ping() error {
+	_, err := d.c.Ping(context.Background())
+	return err
+}
+
+func (d *docker) Close() error {
+	return d.c.Close()
 }
 
 func (d *docker) ConfigCreate(name string, labels map[string]string, data []byte) error {
@@ -90,7 +129,7 @@ func (d *docker) ConfigDelete(name string) error {
 	return d.c.ConfigRemove(context.Background(), name)
 }
 
-func (d *docker) NetworkCreate(id string, opts types.NetworkCreate) error {
+func (d *docker) NetworkCreate(id string, opts network.CreateOptions) error {
 	_, err := d.c.NetworkCreate(context.Background(), id, opts)
 
 	if err != nil {
@@ -129,11 +168,11 @@ func (d *docker) NetworkConnect(containerId, networkId, ip string) (string, erro
 	return n.IPAddress, nil
 }
 
-func (d *docker) NetworkInspect(id string) (types.NetworkResource, error) {
-	return d.c.NetworkInspect(context.Background(), id, types.NetworkInspectOptions{})
+func (d *docker) NetworkInspect(id string) (network.Inspect, error) {
+	return d.c.NetworkInspect(context.Background(), id, network.InspectOptions{})
 }
 
-func (d *docker) DaemonInfo() (types.Info, error) {
+func (d *docker) DaemonInfo() (system.Info, error) {
 	return d.c.Info(context.Background())
 }
 
@@ -169,7 +208,7 @@ func (d *docker) GetSwarmPorts() ([]string, []uint16, error) {
 }
 
 func (d *docker) GetPorts() ([]uint16, error) {
-	opts := types.ContainerListOptions{}
+	opts := container.ListOptions{}
 	containers, err := d.c.ContainerList(context.Background(), opts)
 	if err != nil {
 		return nil, err
@@ -195,7 +234,7 @@ func (d *docker) ContainerStats(name string) (io.ReadCloser, error) {
 }
 
 func (d *docker) ContainerResize(name string, rows, cols uint) error {
-	return d.c.ContainerResize(context.Background(), name, types.ResizeOptions{Height: rows, Width: cols})
+	return d.c.ContainerResize(context.Background(), name, container.ResizeOptions{Height: rows, Width: cols})
 }
 
 func (d *docker) ContainerRename(old, new string) error {
@@ -205,7 +244,7 @@ func (d *docker) ContainerRename(old, new string) error {
 func (d *docker) CreateAttachConnection(name string) (net.Conn, error) {
 	ctx := context.Background()
 
-	conf := types.ContainerAttachOptions{true, true, true, true, "ctrl-^,ctrl-^", true}
+	conf := container.AttachOptions{Stream: true, Stdin: true, Stdout: true, Stderr: true, DetachKeys: "ctrl-^,ctrl-^", Logs: true}
 	conn, err := d.c.ContainerAttach(ctx, name, conf)
 	if err != nil {
 		return nil, err
@@ -230,7 +269,7 @@ func (d *docker) CopyToContainer(containerName, destination, fileName string, co
 	if err := t.Close(); err != nil {
 		return err
 	}
-	return d.c.CopyToContainer(context.Background(), containerName, destination, &buf, types.CopyToContainerOptions{AllowOverwriteDirWithFile: true, CopyUIDGID: true})
+	return d.c.CopyToContainer(context.Background(), containerName, destination, &buf, container.CopyToContainerOptions{AllowOverwriteDirWithFile: true, CopyUIDGID: true})
 }
 
 func (d *docker) CopyFromContainer(containerName, filePath string) (io.Reader, error) {
@@ -247,12 +286,37 @@ func (d *docker) CopyFromContainer(containerName, filePath string) (io.Reader, e
 	return tr, nil
 }
 
+func (d *docker) CopyTarToContainer(containerName, destination string, archive io.Reader) error {
+	return d.c.CopyToContainer(context.Background(), containerName, destination, archive, container.CopyToContainerOptions{CopyUIDGID: true})
+}
+
+func (d *docker) CopyTarFromContainer(containerName, srcPath string) (io.ReadCloser, error) {
+	rc, _, err := d.c.CopyFromContainer(context.Background(), containerName, srcPath)
+	return rc, err
+}
+
 func (d *docker) ContainerDelete(name string) error {
-	err := d.c.ContainerRemove(context.Background(), name, types.ContainerRemoveOptions{Force: true, RemoveVolumes: true})
+	err := d.c.ContainerRemove(context.Background(), name, container.RemoveOptions{Force: true, RemoveVolumes: true})
 	d.c.VolumeRemove(context.Background(), name, true)
 	return err
 }
 
+func (d *docker) ContainerPause(name string) error {
+	return d.c.ContainerPause(context.Background(), name)
+}
+
+func (d *docker) ContainerUnpause(name string) error {
+	return d.c.ContainerUnpause(context.Background(), name)
+}
+
+func (d *docker) ContainerStop(name string) error {
+	return d.c.ContainerStop(context.Background(), name, container.StopOptions{})
+}
+
+func (d *docker) ContainerStart(name string) error {
+	return d.c.ContainerStart(context.Background(), name, container.StartOptions{})
+}
+
 type CreateContainerOpts struct {
 	Image          string
 	SessionId      string
@@ -267,11 +331,14 @@ type CreateContainerOpts struct {
 	Networks       []string
 	DindVolumeSize string
 	Envs           []string
+	// DNS servers of the container, the daemon default if empty
+	DNS []string
 
 	// Resource limits
-	MaxProcesses int64  // Maximum number of processes (default: 1000)
-	MaxMemoryMB  int64  // Maximum memory in MB (default: from environment)
-	StorageSize  string // Maximum storage size (default: from environment)
+	MaxProcesses int64   // Maximum number of processes (default: 1000)
+	MaxMemoryMB  int64   // Maximum memory in MB (default: from environment)
+	StorageSize  string  // Maximum storage size (default: from environment)
+	MaxCPUs      float64 // Maximum number of CPUs (default: no limit)
 }
 
 func (d *docker) ContainerCreate(opts CreateContainerOpts) (err error) {
@@ -302,15 +369,17 @@ func (d *docker) ContainerCreate(opts CreateContainerOpts) (err error) {
 	h := &container.HostConfig{
 		NetworkMode: container.NetworkMode(opts.SessionId),
 		Privileged:  opts.Privileged,
-		AutoRemove:  true,
 		LogConfig:   container.LogConfig{Config: map[string]string{"max-size": "10m", "max-file": "1"}},
+		DNS:         opts.DNS,
 	}
 
 	if os.Getenv("APPARMOR_PROFILE") != "" {
 		h.SecurityOpt = []string{fmt.Sprintf("apparmor=%s", os.Getenv("APPARMOR_PROFILE"))}
 	}
 
-	if os.Getenv("STORAGE_SIZE") != "" {
+	if opts.StorageSize != "" {
+		h.StorageOpt = map[string]string{"size": opts.StorageSize}
+	} else if os.Getenv("STORAGE_SIZE") != "" {
 		// assing 10GB size FS for each container
 		h.StorageOpt = map[string]string{"size": os.Getenv("STORAGE_SIZE")}
 	}
@@ -321,13 +390,21 @@ func (d *docker) ContainerCreate(opts CreateContainerOpts) (err error) {
 			pidsLimit = int64(i)
 		}
 	}
+	if opts.MaxProcesses > 0 {
+		pidsLimit = opts.MaxProcesses
+	}
 	h.Resources.PidsLimit = &pidsLimit
 
-	if memLimit := os.Getenv("MAX_MEMORY_MB"); memLimit != "" {
+	if opts.MaxMemoryMB > 0 {
+		h.Resources.Memory = opts.MaxMemoryMB * Megabyte
+	} else if memLimit := os.Getenv("MAX_MEMORY_MB"); memLimit != "" {
 		if i, err := strconv.Atoi(memLimit); err == nil {
 			h.Resources.Memory = int64(i) * Megabyte
 		}
 	}
+	if opts.MaxCPUs > 0 {
+		h.Resources.NanoCPUs = int64(opts.MaxCPUs * 1e9)
+	}
 
 	t := true
 	h.Resources.OomKillDisable = &t
@@ -350,7 +427,7 @@ func (d *docker) ContainerCreate(opts CreateContainerOpts) (err error) {
 	}
 
 	if config.ExternalDindVolume {
-		_, err = d.c.VolumeCreate(context.Background(), volume.VolumeCreateBody{
+		_, err = d.c.VolumeCreate(context.Background(), volume.CreateOptions{
 			Driver: "xfsvol",
 			DriverOpts: map[string]string{
 				"size": opts.DindVolumeSize,
@@ -369,7 +446,7 @@ func (d *docker) ContainerCreate(opts CreateContainerOpts) (err error) {
 		}()
 	}
 
-	container, err := d.c.ContainerCreate(context.Background(), cf, h, networkConf, opts.ContainerName)
+	c, err := d.c.ContainerCreate(context.Background(), cf, h, networkConf, nil, opts.ContainerName)
 
 	if err != nil {
 		// Check if the error is because the image doesn't exist locally
@@ -380,7 +457,7 @@ func (d *docker) ContainerCreate(opts CreateContainerOpts) (err error) {
 				return fmt.Errorf("failed to pull image '%s': %w", opts.Image, err)
 			}
 			// Try to create the container again after pulling the image
-			container, err = d.c.ContainerCreate(context.Background(), cf, h, networkConf, opts.ContainerName)
+			c, err = d.c.ContainerCreate(context.Background(), cf, h, networkConf, nil, opts.ContainerName)
 			if err != nil {
 				return fmt.Errorf("failed to create container after pulling image: %w", err)
 			}
@@ -392,7 +469,7 @@ func (d *docker) ContainerCreate(opts CreateContainerOpts) (err error) {
 	//connect remaining networks if there are any
 	if len(opts.Networks) > 1 {
 		for _, nid := range opts.Networks {
-			err = d.c.NetworkConnect(context.Background(), nid, container.ID, &network.EndpointSettings{})
+			err = d.c.NetworkConnect(context.Background(), nid, c.ID, &network.EndpointSettings{})
 			if err != nil {
 				return
 			}
@@ -409,7 +486,7 @@ func (d *docker) ContainerCreate(opts CreateContainerOpts) (err error) {
 		return
 	}
 
-	err = d.c.ContainerStart(context.Background(), container.ID, types.ContainerStartOptions{})
+	err = d.c.ContainerStart(context.Background(), c.ID, container.StartOptions{})
 	if err != nil {
 		return
 	}
@@ -431,15 +508,134 @@ func (d *docker) ContainerIPs(id string) (map[string]string, error) {
 
 }
 
-func (d *docker) pullImage(ctx context.Context, image string) error {
-	_, err := reference.Parse(image)
+func (d *docker) ContainerGatewayIP(name string) (string, error) {
+	cinfo, err := d.c.ContainerInspect(context.Background(), name)
+	if err != nil {
+		return "", err
+	}
+	gateway, err := d.c.NetworkInspect(context.Background(), GatewayBridgeNetwork, network.InspectOptions{})
+	if err != nil {
+		return "", err
+	}
+	endpoint, found := gateway.Containers[cinfo.ID]
+	if !found {
+		return "", fmt.Errorf("Container [%s] is not on the %s network", name, GatewayBridgeNetwork)
+	}
+	return strings.Split(endpoint.IPv4Address, "/")[0], nil
+}
+
+func (d *docker) ContainerCommit(name, reference string, labels map[string]string) (int64, error) {
+	resp, err := d.c.ContainerCommit(context.Background(), name, container.CommitOptions{
+		Reference: reference,
+		Pause:     true,
+		Config:    &container.Config{Labels: labels},
+	})
+	if err != nil {
+		return 0, err
+	}
+	img, _, err := d.c.ImageInspectWithRaw(context.Background(), resp.ID)
+	if err != nil {
+		return 0, err
+	}
+	if img.Parent == "" {
+		return img.Size, nil
+	}
+	parent, _, err := d.c.ImageInspectWithRaw(context.Background(), img.Parent)
+	if err != nil {
+		return img.Size, nil
+	}
+	return img.Size - parent.Size, nil
+}
+
+func (d *docker) ImageDelete(reference string) error {
+	_, err := d.c.ImageRemove(context.Background(), reference, image.RemoveOptions{Force: true, PruneChildren: true})
+	return err
+}
+
+func (d *docker) ImageTags() ([]string, error) {
+	images, err := d.c.ImageList(context.Background(), image.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	tags := []string{}
+	for _, i := range images {
+		tags = append(tags, i.RepoTags...)
+	}
+	return tags, nil
+}
+
+// ImageBuildOpts describes an image to build
+type ImageBuildOpts struct {
+	// Context is a tar archive with a Dockerfile at its root
+	Context   io.Reader
+	Tags      []string
+	BuildArgs map[string]string
+	Labels    map[string]string
+}
+
+// buildMessage is a line of the output of a build
+type buildMessage struct {
+	Stream string `json:"stream"`
+	Status string `json:"status"`
+	Error  string `json:"error"`
+}
+
+func (d *docker) ImageBuild(ctx context.Context, opts ImageBuildOpts, out io.Writer) (string, int64, error) {
+	if len(opts.Tags) == 0 {
+		return "", 0, fmt.Errorf("Images need a tag to be built")
+	}
+	args := map[string]*string{}
+	for name, value := range opts.BuildArgs {
+		value := value
+		args[name] = &value
+	}
+	resp, err := d.c.ImageBuild(ctx, opts.Context, types.ImageBuildOptions{
+		Tags:        opts.Tags,
+		BuildArgs:   args,
+		Labels:      opts.Labels,
+		PullParent:  true,
+		Remove:      true,
+		ForceRemove: true,
+	})
+	if err != nil {
+		return "", 0, err
+	}
+	defer resp.Body.Close()
+
+	dec := json.NewDecoder(resp.Body)
+	for {
+		var m buildMessage
+		if err := dec.Decode(&m); err == io.EOF {
+			break
+		} else if err != nil {
+			return "", 0, err
+		}
+		if m.Error != "" {
+			return "", 0, fmt.Errorf("%s", m.Error)
+		}
+		if m.Stream != "" {
+			io.WriteString(out, m.Stream)
+		} else if m.Status != "" {
+			fmt.Fprintln(out, m.Status)
+		}
+	}
+
+	img, _, err := d.c.ImageInspectWithRaw(ctx, opts.Tags[0])
+	if err != nil {
+		return "", 0, err
+	}
+	return img.ID, img.Size, nil
+}
+
+func (d *docker) pullImage(ctx context.Context, ref string) error {
+	_, err := reference.Parse(ref)
 	if err != nil {
 		return err
 	}
 
-	options := types.ImageCreateOptions{}
+	options := image.CreateOptions{}
 
-	responseBody, err := d.c.ImageCreate(ctx, image, options)
+	responseBody, err := d.c.ImageCreate(ctx, ref, options)
 	if err != nil {
 		return err
 	}
@@ -456,18 +652,18 @@ func (d *docker) copyIfSet(content []byte, fileName, path, containerName string)
 }
 
 func (d *docker) ExecAttach(instanceName string, command []string, out io.Writer) (int, error) {
-	e, err := d.c.ContainerExecCreate(context.Background(), instanceName, types.ExecConfig{Cmd: command, AttachStdout: true, AttachStderr: true, Tty: true})
+	e, err := d.c.ContainerExecCreate(context.Background(), instanceName, container.ExecOptions{Cmd: command, AttachStdout: true, AttachStderr: true, Tty: true})
 	if err != nil {
 		return 0, err
 	}
-	resp, err := d.c.ContainerExecAttach(context.Background(), e.ID, types.ExecStartCheck{
+	resp, err := d.c.ContainerExecAttach(context.Background(), e.ID, container.ExecStartOptions{
 		Tty: true,
 	})
 	if err != nil {
 		return 0, err
 	}
 	io.Copy(out, resp.Reader)
-	var ins types.ContainerExecInspect
+	var ins container.ExecInspect
 	for _ = range time.Tick(1 * time.Second) {
 		ins, err = d.c.ContainerExecInspect(context.Background(), e.ID)
 		if ins.Running {
@@ -483,15 +679,15 @@ func (d *docker) ExecAttach(instanceName string, command []string, out io.Writer
 }
 
 func (d *docker) Exec(instanceName string, command []string) (int, error) {
-	e, err := d.c.ContainerExecCreate(context.Background(), instanceName, types.ExecConfig{Cmd: command})
+	e, err := d.c.ContainerExecCreate(context.Background(), instanceName, container.ExecOptions{Cmd: command})
 	if err != nil {
 		return 0, err
 	}
-	err = d.c.ContainerExecStart(context.Background(), e.ID, types.ExecStartCheck{})
+	err = d.c.ContainerExecStart(context.Background(), e.ID, container.ExecStartOptions{})
 	if err != nil {
 		return 0, err
 	}
-	var ins types.ContainerExecInspect
+	var ins container.ExecInspect
 	for _ = range time.Tick(1 * time.Second) {
 		ins, err = d.c.ContainerExecInspect(context.Background(), e.ID)
 		if ins.Running {
@@ -505,6 +701,35 @@ func (d *docker) Exec(instanceName string, command []string) (int, error) {
 	return ins.ExitCode, nil
 }
 
+func (d *docker) HostExec(image string, command []string) (int, error) {
+	ctx := context.Background()
+	cf := &container.Config{Image: image, Cmd: command}
+	h := &container.HostConfig{NetworkMode: "host", CapAdd: []string{"NET_ADMIN"}}
+
+	c, err := d.c.ContainerCreate(ctx, cf, h, nil, nil, "")
+	if err != nil && strings.Contains(err.Error(), "No such image") {
+		if err = d.pullImage(ctx, image); err != nil {
+			return 0, fmt.Errorf("failed to pull image '%s': %w", image, err)
+		}
+		c, err = d.c.ContainerCreate(ctx, cf, h, nil, nil, "")
+	}
+	if err != nil {
+		return 0, err
+	}
+	defer d.c.ContainerRemove(ctx, c.ID, container.RemoveOptions{Force: true})
+
+	if err := d.c.ContainerStart(ctx, c.ID, container.StartOptions{}); err != nil {
+		return 0, err
+	}
+	statusCh, errCh := d.c.ContainerWait(ctx, c.ID, container.WaitConditionNotRunning)
+	select {
+	case err := <-errCh:
+		return 0, err
+	case status := <-statusCh:
+		return int(status.StatusCode), nil
+	}
+}
+
 func (d *docker) NetworkDisconnect(containerId, networkId string) error {
 	err := d.c.NetworkDisconnect(context.Background(), networkId, containerId, true)
 
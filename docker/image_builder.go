@@ -0,0 +1,98 @@
+package docker
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+
+	"github.com/ringo380/lessoncraft/images"
+)
+
+// DaemonLister is implemented by factories that know every daemon sessions
+// can be placed on
+type DaemonLister interface {
+	Daemons() ([]DockerApi, error)
+}
+
+// imageBuilder builds catalog images on every daemon, so instances can be
+// created from them wherever their session is placed
+type imageBuilder struct {
+	daemons DaemonLister
+}
+
+// NewImageBuilder creates an images.Builder building on the daemons of a factory
+func NewImageBuilder(daemons DaemonLister) images.Builder {
+	return &imageBuilder{daemons: daemons}
+}
+
+func (b *imageBuilder) Build(ctx context.Context, req images.BuildRequest, out io.Writer) (images.BuildResult, error) {
+	result := images.BuildResult{}
+	daemons, err := b.daemons.Daemons()
+	if err != nil {
+		return result, err
+	}
+	if len(daemons) == 0 {
+		return result, fmt.Errorf("There are no docker daemons to build on")
+	}
+
+	for _, d := range daemons {
+		host := d.DaemonHost()
+		fmt.Fprintf(out, "Building %s on %s\n", req.Reference, host)
+		opts := ImageBuildOpts{
+			Context:   bytes.NewReader(req.Context),
+			Tags:      []string{req.Reference},
+			BuildArgs: req.Args,
+			Labels:    req.Labels,
+		}
+		id, size, err := d.ImageBuild(ctx, opts, out)
+		if err != nil {
+			return result, fmt.Errorf("Could not build on %s: %v", host, err)
+		}
+		if result.Digest == "" {
+			result.Digest = id
+			result.Size = size
+		}
+		result.Hosts = append(result.Hosts, host)
+	}
+	return result, nil
+}
+
+func (b *imageBuilder) Remove(reference string) error {
+	daemons, err := b.daemons.Daemons()
+	if err != nil {
+		return err
+	}
+	for _, d := range daemons {
+		if err := d.ImageDelete(reference); err != nil && !strings.Contains(err.Error(), "No such image") {
+			return err
+		}
+	}
+	return nil
+}
+
+// Daemons returns the local daemon
+func (f *localCachedFactory) Daemons() ([]DockerApi, error) {
+	d, err := f.GetForSession(nil)
+	if err != nil {
+		return nil, err
+	}
+	return []DockerApi{d}, nil
+}
+
+// Daemons returns the daemons of the hosts that can be reached, skipping the
+// others
+func (f *multiHostFactory) Daemons() ([]DockerApi, error) {
+	daemons := []DockerApi{}
+	for _, h := range f.registry.List() {
+		c, err := f.client(h)
+		if err != nil {
+			log.Printf("Skipping host [%s]: %v\n", h.ID, err)
+			continue
+		}
+		daemons = append(daemons, c)
+	}
+	return daemons, nil
+}
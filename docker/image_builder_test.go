@@ -0,0 +1,38 @@
+package docker
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/ringo380/lessoncraft/images"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestImageBuilder_Build(t *testing.T) {
+	d1 := &Mock{}
+	d1.On("Ping").Return(nil)
+	d1.On("DaemonHost").Return("tcp://10.0.0.1:2375")
+	d1.On("ImageBuild", mock.Anything, mock.MatchedBy(func(opts ImageBuildOpts) bool {
+		return opts.Tags[0] == "lessoncraft/lessons/l1:v1" && opts.Labels[images.LessonLabel] == "l1"
+	}), mock.Anything).Return("sha256:abc", int64(100), nil)
+	d2 := &Mock{}
+	d2.On("Ping").Return(errors.New("connection refused"))
+	d2.On("Close").Return(nil)
+
+	f, _ := newTestMultiHostFactory(t, map[string]*Mock{"tcp://10.0.0.1:2375": d1, "tcp://10.0.0.2:2375": d2})
+	b := NewImageBuilder(f)
+
+	var out bytes.Buffer
+	req := images.BuildRequest{Reference: "lessoncraft/lessons/l1:v1", Context: []byte("tar"), Labels: map[string]string{images.LessonLabel: "l1"}}
+	result, err := b.Build(context.Background(), req, &out)
+	assert.Nil(t, err)
+	// Hosts that cannot be reached are skipped
+	assert.Equal(t, images.BuildResult{Digest: "sha256:abc", Size: 100, Hosts: []string{"tcp://10.0.0.1:2375"}}, result)
+	assert.Contains(t, out.String(), "Building lessoncraft/lessons/l1:v1 on tcp://10.0.0.1:2375")
+
+	d1.On("ImageDelete", "lessoncraft/lessons/l1:v1").Return(errors.New("No such image: lessoncraft/lessons/l1:v1"))
+	assert.Nil(t, b.Remove("lessoncraft/lessons/l1:v1"))
+}
@@ -1,7 +1,6 @@
 package docker
 
 import (
-	"context"
 	"fmt"
 	"log"
 	"sync"
@@ -32,10 +31,10 @@ func (f *localCachedFactory) GetForSession(session *types.Session) (DockerApi, e
 	defer f.rw.Unlock()
 
 	if f.sessionClient != nil {
-		if err := f.check(f.sessionClient.GetClient()); err == nil {
+		if err := f.check(f.sessionClient); err == nil {
 			return f.sessionClient, nil
 		} else {
-			f.sessionClient.GetClient().Close()
+			f.sessionClient.Close()
 		}
 	}
 
@@ -43,11 +42,11 @@ func (f *localCachedFactory) GetForSession(session *types.Session) (DockerApi, e
 	if err != nil {
 		return nil, err
 	}
-	err = f.check(c)
+	d := NewDocker(c)
+	err = f.check(d)
 	if err != nil {
 		return nil, err
 	}
-	d := NewDocker(c)
 	f.sessionClient = d
 	return f.sessionClient, nil
 }
@@ -68,10 +67,10 @@ func (f *localCachedFactory) GetForInstance(instance *types.Instance) (DockerApi
 	defer c.rw.Unlock()
 
 	if c.client != nil {
-		if err := f.check(c.client.GetClient()); err == nil {
+		if err := f.check(c.client); err == nil {
 			return c.client, nil
 		} else {
-			c.client.GetClient().Close()
+			c.client.Close()
 		}
 	}
 
@@ -79,23 +78,23 @@ func (f *localCachedFactory) GetForInstance(instance *types.Instance) (DockerApi
 	if err != nil {
 		return nil, err
 	}
-	err = f.check(dc)
+	dockerClient := NewDocker(dc)
+	err = f.check(dockerClient)
 	if err != nil {
 		return nil, err
 	}
-	dockerClient := NewDocker(dc)
 	c.client = dockerClient
 
 	return dockerClient, nil
 }
 
-func (f *localCachedFactory) check(c *client.Client) error {
+func (f *localCachedFactory) check(c DockerApi) error {
 	// Use the circuit breaker to protect against repeated failures
 	err := f.cb.Execute(func() error {
 		// Preserve the existing retry logic within the circuit breaker
 		ok := false
 		for i := 0; i < 5; i++ {
-			_, err := c.Ping(context.Background())
+			err := c.Ping()
 			if err != nil {
 				log.Printf("Connection to [%s] has failed, maybe instance is not ready yet, sleeping and retrying in 1 second. Try #%d. Got: %v\n", c.DaemonHost(), i+1, err)
 				time.Sleep(time.Second)
@@ -1,12 +1,13 @@
 package docker
 
 import (
+	"context"
 	"io"
 	"net"
 	"time"
 
-	"github.com/docker/docker/api/types"
-	"github.com/docker/docker/client"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/docker/api/types/system"
 	"github.com/stretchr/testify/mock"
 )
 
@@ -14,12 +15,17 @@ type Mock struct {
 	mock.Mock
 }
 
-func (m *Mock) GetClient() *client.Client {
+func (m *Mock) Ping() error {
 	args := m.Called()
-	return args.Get(0).(*client.Client)
+	return args.Error(0)
+}
+
+func (m *Mock) Close() error {
+	args := m.Called()
+	return args.Error(0)
 }
 
-func (m *Mock) NetworkCreate(id string, opts types.NetworkCreate) error {
+func (m *Mock) NetworkCreate(id string, opts network.CreateOptions) error {
 	args := m.Called(id, opts)
 	return args.Error(0)
 }
@@ -29,14 +35,14 @@ func (m *Mock) NetworkConnect(container, network, ip string) (string, error) {
 	return args.String(0), args.Error(1)
 }
 
-func (m *Mock) NetworkInspect(id string) (types.NetworkResource, error) {
+func (m *Mock) NetworkInspect(id string) (network.Inspect, error) {
 	args := m.Called(id)
-	return args.Get(0).(types.NetworkResource), args.Error(1)
+	return args.Get(0).(network.Inspect), args.Error(1)
 }
 
-func (m *Mock) DaemonInfo() (types.Info, error) {
+func (m *Mock) DaemonInfo() (system.Info, error) {
 	args := m.Called()
-	return args.Get(0).(types.Info), args.Error(1)
+	return args.Get(0).(system.Info), args.Error(1)
 }
 
 func (m *Mock) DaemonHost() string {
@@ -78,10 +84,52 @@ func (m *Mock) CopyFromContainer(containerName, filePath string) (io.Reader, err
 	args := m.Called(containerName, filePath)
 	return args.Get(0).(io.Reader), args.Error(1)
 }
+func (m *Mock) CopyTarToContainer(containerName, destination string, archive io.Reader) error {
+	args := m.Called(containerName, destination, archive)
+	return args.Error(0)
+}
+
+func (m *Mock) CopyTarFromContainer(containerName, srcPath string) (io.ReadCloser, error) {
+	args := m.Called(containerName, srcPath)
+	return args.Get(0).(io.ReadCloser), args.Error(1)
+}
+
 func (m *Mock) ContainerDelete(id string) error {
 	args := m.Called(id)
 	return args.Error(0)
 }
+func (m *Mock) ContainerCommit(name, reference string, labels map[string]string) (int64, error) {
+	args := m.Called(name, reference, labels)
+	return args.Get(0).(int64), args.Error(1)
+}
+func (m *Mock) ImageDelete(reference string) error {
+	args := m.Called(reference)
+	return args.Error(0)
+}
+func (m *Mock) ImageTags() ([]string, error) {
+	args := m.Called()
+	return args.Get(0).([]string), args.Error(1)
+}
+func (m *Mock) ImageBuild(ctx context.Context, opts ImageBuildOpts, out io.Writer) (string, int64, error) {
+	args := m.Called(ctx, opts, out)
+	return args.String(0), args.Get(1).(int64), args.Error(2)
+}
+func (m *Mock) ContainerPause(name string) error {
+	args := m.Called(name)
+	return args.Error(0)
+}
+func (m *Mock) ContainerUnpause(name string) error {
+	args := m.Called(name)
+	return args.Error(0)
+}
+func (m *Mock) ContainerStop(name string) error {
+	args := m.Called(name)
+	return args.Error(0)
+}
+func (m *Mock) ContainerStart(name string) error {
+	args := m.Called(name)
+	return args.Error(0)
+}
 func (m *Mock) ContainerCreate(opts CreateContainerOpts) error {
 	args := m.Called(opts)
 	return args.Error(0)
@@ -107,6 +155,14 @@ func (m *Mock) Exec(instanceName string, command []string) (int, error) {
 	args := m.Called(instanceName, command)
 	return args.Int(0), args.Error(1)
 }
+func (m *Mock) ContainerGatewayIP(name string) (string, error) {
+	args := m.Called(name)
+	return args.String(0), args.Error(1)
+}
+func (m *Mock) HostExec(image string, command []string) (int, error) {
+	args := m.Called(image, command)
+	return args.Int(0), args.Error(1)
+}
 func (m *Mock) SwarmInit(advertiseAddr string) (*SwarmTokens, error) {
 	args := m.Called(advertiseAddr)
 	return args.Get(0).(*SwarmTokens), args.Error(1)
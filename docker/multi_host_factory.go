@@ -0,0 +1,195 @@
+package docker
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/client"
+	"github.com/ringo380/lessoncraft/placement"
+	"github.com/ringo380/lessoncraft/pwd/types"
+	"github.com/ringo380/lessoncraft/storage"
+)
+
+// SessionPlacer is implemented by factories that spread sessions across
+// several daemons. Session provisioners use it to move a session to another
+// daemon when it cannot be set up on the one it was placed on.
+type SessionPlacer interface {
+	// ReleaseSession frees the place of a closed session
+	ReleaseSession(session *types.Session)
+	// RejectSession releases a session that could not be set up on its daemon,
+	// the next GetForSession places it on another one
+	RejectSession(session *types.Session)
+}
+
+// multiHostFactory places each session on one of the hosts of a registry
+type multiHostFactory struct {
+	*localCachedFactory
+
+	registry *placement.Registry
+	strategy placement.Strategy
+	connect  func(addr string) (DockerApi, error)
+
+	mu       sync.Mutex
+	clients  map[string]DockerApi
+	rejected map[string]map[string]bool
+}
+
+// NewMultiHostFactory creates a factory that places sessions on the hosts of a
+// registry using a placement strategy. Instances are reached through l2 like
+// with NewLocalCachedFactory.
+func NewMultiHostFactory(s storage.StorageApi, registry *placement.Registry, strategy placement.Strategy) *multiHostFactory {
+	return &multiHostFactory{
+		localCachedFactory: NewLocalCachedFactory(s),
+		registry:           registry,
+		strategy:           strategy,
+		connect:            connectHost,
+		clients:            map[string]DockerApi{},
+		rejected:           map[string]map[string]bool{},
+	}
+}
+
+func connectHost(addr string) (DockerApi, error) {
+	c, err := client.NewClientWithOpts(client.WithHost(addr), client.WithAPIVersionNegotiation())
+	if err != nil {
+		return nil, err
+	}
+	return NewDocker(c), nil
+}
+
+// GetForSession returns the daemon of the host a session is placed on, placing
+// it first if needed. Hosts that cannot be reached are skipped, so the session
+// fails over to the next best host.
+func (f *multiHostFactory) GetForSession(session *types.Session) (DockerApi, error) {
+	req := placement.Request{SessionID: session.Id, OrgID: session.OrgId, Image: session.ImageName}
+
+	if h, found := f.registry.HostOf(session.Id); found {
+		return f.client(h)
+	}
+	// Sessions placed before a restart are found by the host they were set up on
+	if session.Host != "" {
+		if h, found := f.registry.FindByHostname(session.Host); found {
+			if err := f.registry.Adopt(h.ID, req); err != nil {
+				return nil, err
+			}
+			return f.client(h)
+		}
+	}
+
+	f.mu.Lock()
+	rejected := f.rejected[session.Id]
+	f.mu.Unlock()
+
+	for _, h := range f.registry.Rank(req, f.strategy) {
+		if rejected[h.ID] {
+			continue
+		}
+		c, err := f.client(h)
+		if err != nil {
+			log.Printf("Could not place session [%s] on host [%s]: %v\n", session.Id, h.ID, err)
+			f.registry.UpdateCapacity(h.ID, h.Capacity, err)
+			continue
+		}
+		if err := f.registry.Assign(h.ID, req); err != nil {
+			continue
+		}
+		log.Printf("Session [%s] placed on host [%s]\n", session.Id, h.ID)
+		return c, nil
+	}
+	return nil, placement.ErrNoCapacity
+}
+
+func (f *multiHostFactory) ReleaseSession(session *types.Session) {
+	f.registry.Release(session.Id)
+	f.mu.Lock()
+	delete(f.rejected, session.Id)
+	f.mu.Unlock()
+}
+
+func (f *multiHostFactory) RejectSession(session *types.Session) {
+	if h, found := f.registry.HostOf(session.Id); found {
+		f.mu.Lock()
+		if f.rejected[session.Id] == nil {
+			f.rejected[session.Id] = map[string]bool{}
+		}
+		f.rejected[session.Id][h.ID] = true
+		f.mu.Unlock()
+	}
+	f.registry.Release(session.Id)
+}
+
+// client returns a checked connection to the daemon of a host
+func (f *multiHostFactory) client(h *placement.Host) (DockerApi, error) {
+	f.mu.Lock()
+	c, found := f.clients[h.ID]
+	f.mu.Unlock()
+	if found {
+		if err := c.Ping(); err == nil {
+			return c, nil
+		}
+		c.Close()
+	}
+
+	c, err := f.connect(h.Addr)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.Ping(); err != nil {
+		c.Close()
+		return nil, fmt.Errorf("host [%s] is not reachable: %v", h.ID, err)
+	}
+	f.mu.Lock()
+	f.clients[h.ID] = c
+	f.mu.Unlock()
+	return c, nil
+}
+
+// Probe updates the capacity of every host from the information its daemon
+// reports
+func (f *multiHostFactory) Probe() {
+	for _, h := range f.registry.List() {
+		c, err := f.probe(h)
+		if err != nil {
+			log.Printf("Could not probe host [%s]: %v\n", h.ID, err)
+		}
+		f.registry.UpdateCapacity(h.ID, c, err)
+	}
+}
+
+func (f *multiHostFactory) probe(h *placement.Host) (placement.Capacity, error) {
+	d, err := f.client(h)
+	if err != nil {
+		return placement.Capacity{}, err
+	}
+	info, err := d.DaemonInfo()
+	if err != nil {
+		return placement.Capacity{}, err
+	}
+	images, err := d.ImageTags()
+	if err != nil {
+		return placement.Capacity{}, err
+	}
+	return placement.Capacity{
+		CPUs:              info.NCPU,
+		MemoryBytes:       info.MemTotal,
+		ContainersRunning: info.ContainersRunning,
+		Images:            images,
+	}, nil
+}
+
+// Run probes the hosts every interval until the context is done
+func (f *multiHostFactory) Run(ctx context.Context, interval time.Duration) {
+	f.Probe()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			f.Probe()
+		}
+	}
+}
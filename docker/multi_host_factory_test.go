@@ -0,0 +1,93 @@
+package docker
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/docker/docker/api/types/system"
+	"github.com/ringo380/lessoncraft/placement"
+	ptypes "github.com/ringo380/lessoncraft/pwd/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestMultiHostFactory(t *testing.T, daemons map[string]*Mock) (*multiHostFactory, *placement.Registry) {
+	registry := placement.NewRegistry()
+	f := NewMultiHostFactory(nil, registry, placement.LeastLoaded)
+	f.connect = func(addr string) (DockerApi, error) {
+		d, found := daemons[addr]
+		if !found {
+			return nil, errors.New("unknown host")
+		}
+		return d, nil
+	}
+	for _, addr := range []string{"tcp://10.0.0.1:2375", "tcp://10.0.0.2:2375"} {
+		_, err := registry.Add(placement.Host{ID: addr[6:14], Addr: addr})
+		assert.Nil(t, err)
+	}
+	return f, registry
+}
+
+func TestMultiHostFactory_Probe(t *testing.T) {
+	d1 := &Mock{}
+	d1.On("Ping").Return(nil)
+	d1.On("DaemonInfo").Return(system.Info{NCPU: 4, MemTotal: 8 << 30, ContainersRunning: 6}, nil)
+	d1.On("ImageTags").Return([]string{"franela/dind:latest"}, nil)
+	d2 := &Mock{}
+	d2.On("Ping").Return(errors.New("connection refused"))
+	d2.On("Close").Return(nil)
+
+	f, registry := newTestMultiHostFactory(t, map[string]*Mock{"tcp://10.0.0.1:2375": d1, "tcp://10.0.0.2:2375": d2})
+	f.Probe()
+
+	h1, err := registry.Get("10.0.0.1")
+	assert.Nil(t, err)
+	assert.True(t, h1.Healthy)
+	assert.Equal(t, placement.Capacity{CPUs: 4, MemoryBytes: 8 << 30, ContainersRunning: 6, Images: []string{"franela/dind:latest"}}, h1.Capacity)
+	h2, err := registry.Get("10.0.0.2")
+	assert.Nil(t, err)
+	assert.False(t, h2.Healthy)
+	assert.NotEmpty(t, h2.ProbeError)
+}
+
+func TestMultiHostFactory_GetForSession(t *testing.T) {
+	d1 := &Mock{}
+	d1.On("Ping").Return(nil)
+	d2 := &Mock{}
+	d2.On("Ping").Return(nil)
+
+	f, registry := newTestMultiHostFactory(t, map[string]*Mock{"tcp://10.0.0.1:2375": d1, "tcp://10.0.0.2:2375": d2})
+	registry.UpdateCapacity("10.0.0.1", placement.Capacity{CPUs: 4, ContainersRunning: 1}, nil)
+	registry.UpdateCapacity("10.0.0.2", placement.Capacity{CPUs: 4, ContainersRunning: 3}, nil)
+
+	session := &ptypes.Session{Id: "s1", OrgId: "acme"}
+	d, err := f.GetForSession(session)
+	assert.Nil(t, err)
+	assert.Equal(t, d1, d)
+
+	// Sessions stay on their host
+	d, err = f.GetForSession(session)
+	assert.Nil(t, err)
+	assert.Equal(t, d1, d)
+
+	// Rejected sessions fail over to the next host
+	f.RejectSession(session)
+	d, err = f.GetForSession(session)
+	assert.Nil(t, err)
+	assert.Equal(t, d2, d)
+	f.RejectSession(session)
+	_, err = f.GetForSession(session)
+	assert.Equal(t, placement.ErrNoCapacity, err)
+	f.ReleaseSession(session)
+
+	// Sessions placed before a restart are adopted by the host they are on
+	d, err = f.GetForSession(&ptypes.Session{Id: "s2", Host: "10.0.0.2"})
+	assert.Nil(t, err)
+	assert.Equal(t, d2, d)
+	h2, _ := registry.Get("10.0.0.2")
+	assert.Equal(t, 1, h2.Sessions)
+
+	assert.Nil(t, registry.Cordon("10.0.0.1"))
+	assert.Nil(t, registry.Cordon("10.0.0.2"))
+	_, err = f.GetForSession(&ptypes.Session{Id: "s3"})
+	assert.Equal(t, placement.ErrNoCapacity, err)
+}
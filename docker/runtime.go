@@ -0,0 +1,193 @@
+package docker
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"strings"
+
+	"github.com/docker/docker/api/types/network"
+	"github.com/ringo380/lessoncraft/backend"
+	"github.com/ringo380/lessoncraft/config"
+	"github.com/ringo380/lessoncraft/netpolicy"
+	pwdtypes "github.com/ringo380/lessoncraft/pwd/types"
+)
+
+type dockerRuntime struct {
+	d DockerApi
+}
+
+// NewRuntime runs instances as containers of a docker daemon
+func NewRuntime(d DockerApi) backend.Runtime {
+	return &dockerRuntime{d: d}
+}
+
+func (r *dockerRuntime) Host() string {
+	return r.d.DaemonHost()
+}
+
+func (r *dockerRuntime) Create(opts backend.CreateOpts) (map[string]string, error) {
+	err := r.d.ContainerCreate(CreateContainerOpts{
+		Image:          opts.Image,
+		SessionId:      opts.SessionId,
+		ContainerName:  opts.Name,
+		Hostname:       opts.Hostname,
+		ServerCert:     opts.ServerCert,
+		ServerKey:      opts.ServerKey,
+		CACert:         opts.CACert,
+		Privileged:     opts.Privileged,
+		HostFQDN:       opts.HostFQDN,
+		Labels:         opts.Labels,
+		Networks:       opts.Networks,
+		DindVolumeSize: opts.DindVolumeSize,
+		Envs:           opts.Envs,
+		DNS:            opts.DNS,
+		MaxProcesses:   opts.MaxProcesses,
+		MaxMemoryMB:    opts.MaxMemoryMB,
+		StorageSize:    opts.StorageSize,
+		MaxCPUs:        opts.MaxCPUs,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return r.d.ContainerIPs(opts.Name)
+}
+
+func (r *dockerRuntime) Delete(name string) error {
+	err := r.d.ContainerDelete(name)
+	if err != nil && !strings.Contains(err.Error(), "No such container") {
+		return err
+	}
+	return nil
+}
+
+func (r *dockerRuntime) Rename(old, new string) error {
+	return r.d.ContainerRename(old, new)
+}
+
+func (r *dockerRuntime) Exec(name string, command []string) (int, error) {
+	return r.d.Exec(name, command)
+}
+
+func (r *dockerRuntime) ExecAttach(name string, command []string, out io.Writer) (int, error) {
+	return r.d.ExecAttach(name, command, out)
+}
+
+func (r *dockerRuntime) Attach(name string) (net.Conn, error) {
+	return r.d.CreateAttachConnection(name)
+}
+
+func (r *dockerRuntime) Resize(name string, rows, cols uint) error {
+	return r.d.ContainerResize(name, rows, cols)
+}
+
+func (r *dockerRuntime) CopyTo(name, destination, fileName string, content io.Reader) error {
+	return r.d.CopyToContainer(name, destination, fileName, content)
+}
+
+func (r *dockerRuntime) CopyFrom(name, filePath string) (io.Reader, error) {
+	return r.d.CopyFromContainer(name, filePath)
+}
+
+func (r *dockerRuntime) CopyTarTo(name, destination string, archive io.Reader) error {
+	return r.d.CopyTarToContainer(name, destination, archive)
+}
+
+func (r *dockerRuntime) CopyTarFrom(name, srcPath string) (io.ReadCloser, error) {
+	return r.d.CopyTarFromContainer(name, srcPath)
+}
+
+func (r *dockerRuntime) Stats(name string) (io.ReadCloser, error) {
+	return r.d.ContainerStats(name)
+}
+
+func (r *dockerRuntime) Pause(name string) error {
+	return r.d.ContainerPause(name)
+}
+
+func (r *dockerRuntime) Unpause(name string) error {
+	return r.d.ContainerUnpause(name)
+}
+
+func (r *dockerRuntime) Stop(name string) error {
+	return r.d.ContainerStop(name)
+}
+
+func (r *dockerRuntime) Start(name string) (map[string]string, error) {
+	if err := r.d.ContainerStart(name); err != nil {
+		return nil, err
+	}
+	return r.d.ContainerIPs(name)
+}
+
+func (r *dockerRuntime) Commit(name, image string, labels map[string]string) (int64, error) {
+	return r.d.ContainerCommit(name, image, labels)
+}
+
+func (r *dockerRuntime) ImageDelete(image string) error {
+	err := r.d.ImageDelete(image)
+	if err != nil && !strings.Contains(err.Error(), "No such image") {
+		return err
+	}
+	return nil
+}
+
+// NetworkCreate creates an attachable overlay network, like the session networks
+func (r *dockerRuntime) NetworkCreate(name string) error {
+	return r.d.NetworkCreate(name, network.CreateOptions{Driver: "overlay", Attachable: true})
+}
+
+func (r *dockerRuntime) NetworkConnect(name, network, ip string) (string, error) {
+	return r.d.NetworkConnect(name, network, ip)
+}
+
+func (r *dockerRuntime) NetworkDisconnect(name, network string) error {
+	return r.d.NetworkDisconnect(name, network)
+}
+
+func (r *dockerRuntime) NetworkDelete(network string) error {
+	return r.d.NetworkDelete(network)
+}
+
+// SetEgressPolicy filters the traffic the container sends to the outside
+// world with iptables rules on the docker host. Overlay networks reach the
+// outside world through the gateway bridge, so the rules match the address
+// of the container on it.
+func (r *dockerRuntime) SetEgressPolicy(name string, rules []netpolicy.EgressRule) error {
+	chain := netpolicy.ChainName(name)
+	script := netpolicy.IPTablesCleanupScript(chain)
+	if rules != nil {
+		source, err := r.d.ContainerGatewayIP(name)
+		if err != nil {
+			return err
+		}
+		script = netpolicy.IPTablesScript(chain, source, rules)
+	}
+
+	code, err := r.d.HostExec(config.FirewallImage, []string{"sh", "-c", script})
+	if err != nil {
+		return err
+	}
+	if code != 0 {
+		return fmt.Errorf("updating the firewall of [%s] exited with code %d", name, code)
+	}
+	return nil
+}
+
+type runtimeFactory struct {
+	f FactoryApi
+}
+
+// NewRuntimeFactory runs the instances of each session on the docker daemon the
+// factory returns for it
+func NewRuntimeFactory(f FactoryApi) backend.Factory {
+	return &runtimeFactory{f: f}
+}
+
+func (f *runtimeFactory) GetForSession(session *pwdtypes.Session) (backend.Runtime, error) {
+	d, err := f.f.GetForSession(session)
+	if err != nil {
+		return nil, err
+	}
+	return NewRuntime(d), nil
+}
@@ -11,14 +11,24 @@ var (
 	LESSON_STEP_COMPLETE     = EventType("lesson step complete")
 	LESSON_COMMAND_EXECUTE   = EventType("lesson command execute")
 	LESSON_VALIDATE          = EventType("lesson validate")
+	LESSON_STEP_SETUP        = EventType("lesson step setup")
+	LESSON_STEP_READY        = EventType("lesson step ready")
 	INSTANCE_VIEWPORT_RESIZE = EventType("instance viewport resize")
 	INSTANCE_DELETE          = EventType("instance delete")
 	INSTANCE_NEW             = EventType("instance new")
 	INSTANCE_STATS           = EventType("instance stats")
+	INSTANCE_FILES_CHANGED   = EventType("instance files changed")
 	SESSION_NEW              = EventType("session new")
 	SESSION_END              = EventType("session end")
 	SESSION_READY            = EventType("session ready")
 	SESSION_BUILDER_OUT      = EventType("session builder out")
+	SESSION_EXPIRING         = EventType("session expiring")
+	SESSION_EXTENDED         = EventType("session extended")
+	SESSION_PAUSED           = EventType("session paused")
+	SESSION_RESUMED          = EventType("session resumed")
+	SESSION_IDLE             = EventType("session idle")
+	SESSION_ENDPOINTS        = EventType("session endpoints")
+	SESSION_PORTS            = EventType("session ports")
 	PLAYGROUND_NEW           = EventType("playground_new")
 )
 
@@ -0,0 +1,197 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path"
+
+	"github.com/ringo380/lessoncraft/config"
+	"github.com/ringo380/lessoncraft/provisioner"
+	"github.com/ringo380/lessoncraft/pwd/types"
+	"github.com/ringo380/lessoncraft/workspace"
+)
+
+func maxArchiveSize() int64 {
+	return int64(config.WorkspaceArchiveMaxMB) << 20
+}
+
+// ArchiveDownload streams the directory at path, the home directory by
+// default, as a tar.gz or zip archive
+func ArchiveDownload(rw http.ResponseWriter, req *http.Request) {
+	i, ok := fileInstance(rw, req)
+	if !ok {
+		return
+	}
+	query := req.URL.Query()
+	format, err := workspace.ParseFormat(query.Get("format"))
+	if err != nil {
+		rw.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintln(rw, `{"error": "invalid_format"}`)
+		return
+	}
+	dir := ""
+	if query.Get("path") != "" {
+		if dir, ok = cleanFilePath(rw, query.Get("path")); !ok {
+			return
+		}
+	}
+
+	files, err := core.InstanceArchive(i, dir)
+	if err != nil {
+		writeFileError(rw, err)
+		return
+	}
+	defer files.Close()
+
+	name := instanceHostname(i)
+	if dir != "" && dir != "/" {
+		name = fmt.Sprintf("%s-%s", name, path.Base(dir))
+	}
+	writeArchive(rw, fmt.Sprintf("%s.%s", name, format), format, func(w *workspace.Writer) error {
+		return w.AddTar("", files)
+	})
+}
+
+// SessionArchive bundles the home directories of all the instances of a
+// session, each under a directory named after the instance
+func SessionArchive(rw http.ResponseWriter, req *http.Request) {
+	session, ok := lifecycleSession(rw, req)
+	if !ok {
+		return
+	}
+	format, err := workspace.ParseFormat(req.URL.Query().Get("format"))
+	if err != nil {
+		rw.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintln(rw, `{"error": "invalid_format"}`)
+		return
+	}
+	instances, err := core.InstanceFindBySession(session)
+	if err != nil {
+		log.Println(err)
+		rw.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	writeArchive(rw, fmt.Sprintf("session-%.8s.%s", session.Id, format), format, func(w *workspace.Writer) error {
+		seen := map[string]bool{}
+		for _, i := range instances {
+			files, err := core.InstanceArchive(i, "")
+			if provisioner.FileOpsNotSupported(err) {
+				continue
+			} else if err != nil {
+				return err
+			}
+			prefix := instanceHostname(i)
+			if seen[prefix] {
+				prefix = i.Name
+			}
+			seen[prefix] = true
+			err = w.AddTar(prefix, files)
+			files.Close()
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// ArchiveUpload extracts the tar, tar.gz or zip archive in the body into the
+// directory at path. Archives with entries that would end up outside of it
+// are refused.
+func ArchiveUpload(rw http.ResponseWriter, req *http.Request) {
+	i, ok := fileInstance(rw, req)
+	if !ok {
+		return
+	}
+	dir, ok := cleanFilePath(rw, req.URL.Query().Get("path"))
+	if !ok {
+		return
+	}
+
+	// Zip archives are read from their end, so uploads are kept on disk
+	upload, err := os.CreateTemp("", "lessoncraft-upload-")
+	if err != nil {
+		log.Println(err)
+		rw.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	defer os.Remove(upload.Name())
+	defer upload.Close()
+	size, err := io.Copy(upload, http.MaxBytesReader(rw, req.Body, maxArchiveSize()))
+	if err != nil {
+		writeArchiveError(rw, err)
+		return
+	}
+
+	files, err := os.CreateTemp("", "lessoncraft-extract-")
+	if err != nil {
+		log.Println(err)
+		rw.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	defer os.Remove(files.Name())
+	defer files.Close()
+	if err := workspace.Sanitize(files, upload, size, maxArchiveSize()); err != nil {
+		writeArchiveError(rw, err)
+		return
+	}
+	if _, err := files.Seek(0, io.SeekStart); err != nil {
+		log.Println(err)
+		rw.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	if err := core.InstanceExtract(i, dir, files); err != nil {
+		writeFileError(rw, err)
+		return
+	}
+	rw.WriteHeader(http.StatusNoContent)
+}
+
+// writeArchive streams an archive built by add. Once the archive has started,
+// errors can only be told by aborting the response, so clients do not take a
+// truncated archive for a complete one.
+func writeArchive(rw http.ResponseWriter, fileName string, format workspace.Format, add func(w *workspace.Writer) error) {
+	rw.Header().Set("Content-Type", format.ContentType())
+	rw.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, fileName))
+
+	w := workspace.NewWriter(rw, format, maxArchiveSize())
+	err := add(w)
+	if err == nil {
+		err = w.Close()
+	}
+	if err != nil {
+		log.Printf("Aborted archive [%s]: %v\n", fileName, err)
+		panic(http.ErrAbortHandler)
+	}
+}
+
+func writeArchiveError(rw http.ResponseWriter, err error) {
+	var unsafe *workspace.UnsafeEntryError
+	var tooLarge *http.MaxBytesError
+	switch {
+	case errors.Is(err, workspace.ErrTooLarge), errors.As(err, &tooLarge):
+		rw.WriteHeader(http.StatusRequestEntityTooLarge)
+		fmt.Fprintln(rw, `{"error": "archive_too_large"}`)
+	case errors.As(err, &unsafe):
+		rw.Header().Set("Content-Type", "application/json")
+		rw.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(rw).Encode(map[string]string{"error": "unsafe_archive", "entry": unsafe.Name})
+	default:
+		rw.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintln(rw, `{"error": "invalid_archive"}`)
+	}
+}
+
+func instanceHostname(i *types.Instance) string {
+	if i.Hostname != "" {
+		return i.Hostname
+	}
+	return i.Name
+}
@@ -23,10 +23,12 @@ import (
 	lru "github.com/hashicorp/golang-lru"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/ringo380/lessoncraft/api/auth"
 	"github.com/ringo380/lessoncraft/config"
 	"github.com/ringo380/lessoncraft/event"
 	"github.com/ringo380/lessoncraft/pwd"
 	"github.com/ringo380/lessoncraft/pwd/types"
+	"github.com/ringo380/lessoncraft/router"
 	"github.com/urfave/negroni"
 	oauth2Facebook "golang.org/x/oauth2/facebook"
 	oauth2Github "golang.org/x/oauth2/github"
@@ -61,6 +63,15 @@ func init() {
 func Bootstrap(c pwd.PWDApi, ev event.EventApi) {
 	core = c
 	e = ev
+	if config.L2AccessKey != "" && portAccess == nil {
+		portAccess = router.NewAccessControl([]byte(config.L2AccessKey))
+	}
+	if config.L2ZonesURL != "" {
+		if config.L2AccessKey == "" {
+			log.Fatal("l2-zones-url requires l2-access-key")
+		}
+		pushZones()
+	}
 }
 
 func Register(extend HandlerExtender) {
@@ -69,7 +80,7 @@ func Register(extend HandlerExtender) {
 	r := mux.NewRouter()
 	corsRouter := mux.NewRouter()
 
-	corsHandler := gh.CORS(gh.AllowCredentials(), gh.AllowedHeaders([]string{"x-requested-with", "content-type"}), gh.AllowedMethods([]string{"GET", "POST", "HEAD", "DELETE"}), gh.AllowedOriginValidator(func(origin string) bool {
+	corsHandler := gh.CORS(gh.AllowCredentials(), gh.AllowedHeaders([]string{"x-requested-with", "content-type", "if-match", "authorization"}), gh.ExposedHeaders([]string{"etag"}), gh.AllowedMethods([]string{"GET", "POST", "PUT", "HEAD", "DELETE"}), gh.AllowedOriginValidator(func(origin string) bool {
 		if strings.HasSuffix(origin, ".play-with-docker.com") ||
 			strings.HasSuffix(origin, ".play-with-kubernetes.com") ||
 			strings.HasSuffix(origin, ".docker.com") ||
@@ -81,17 +92,11 @@ func Register(extend HandlerExtender) {
 
 	// Specific routes
 	r.HandleFunc("/ping", Ping).Methods("GET")
+	r.HandleFunc("/router/endpoints/resolve", ResolveEndpoint).Methods("POST")
+	r.HandleFunc("/router/ports", RouterPorts).Methods("POST")
 	corsRouter.HandleFunc("/instances/images", GetInstanceImages).Methods("GET")
-	corsRouter.HandleFunc("/sessions/{sessionId}", GetSession).Methods("GET")
-	corsRouter.HandleFunc("/sessions/{sessionId}/close", CloseSession).Methods("POST")
-	corsRouter.HandleFunc("/sessions/{sessionId}", CloseSession).Methods("DELETE")
-	corsRouter.HandleFunc("/sessions/{sessionId}/setup", SessionSetup).Methods("POST")
-	corsRouter.HandleFunc("/sessions/{sessionId}/instances", NewInstance).Methods("POST")
-	corsRouter.HandleFunc("/sessions/{sessionId}/instances/{instanceName}/uploads", FileUpload).Methods("POST")
-	corsRouter.HandleFunc("/sessions/{sessionId}/instances/{instanceName}", DeleteInstance).Methods("DELETE")
-	corsRouter.HandleFunc("/sessions/{sessionId}/instances/{instanceName}/exec", Exec).Methods("POST")
-	corsRouter.HandleFunc("/sessions/{sessionId}/instances/{instanceName}/fstree", fsTree).Methods("GET")
-	corsRouter.HandleFunc("/sessions/{sessionId}/instances/{instanceName}/file", file).Methods("GET")
+
+	registerSessionRoutes(corsRouter)
 
 	r.HandleFunc("/sessions/{sessionId}/instances/{instanceName}/editor", func(rw http.ResponseWriter, r *http.Request) {
 		serveAsset(rw, r, "editor.html")
@@ -111,7 +116,6 @@ func Register(extend HandlerExtender) {
 		serveAsset(rw, r, "robots.txt")
 	})
 
-	corsRouter.HandleFunc("/sessions/{sessionId}/ws/", WSH)
 	r.Handle("/metrics", promhttp.Handler())
 
 	// Generic routes
@@ -126,15 +130,24 @@ func Register(extend HandlerExtender) {
 	r.HandleFunc("/playgrounds", ListPlaygrounds).Methods("GET")
 	r.HandleFunc("/my/playground", GetCurrentPlayground).Methods("GET")
 
-	corsRouter.HandleFunc("/", NewSession).Methods("POST")
-
 	if extend != nil {
 		extend(corsRouter)
 	}
 
 	n := negroni.Classic()
 
-	r.PathPrefix("/").Handler(negroni.New(negroni.Wrap(corsHandler(corsRouter))))
+	var api http.Handler = corsRouter
+	if rateLimiter != nil {
+		rateLimiter.LimitRoute("POST", "/", SessionCreateRateLimit)
+		rateLimiter.LimitRoute("POST", "/sessions/{sessionId}/instances/{instanceName}/exec", ExecRateLimit)
+		api = rateLimiter.Handler(corsRouter)
+		if tokenValidator != nil {
+			// Bearer tokens are validated once, for the limiter and the routes
+			api = auth.IdentifyMiddleware(tokenValidator)(api)
+		}
+	}
+
+	r.PathPrefix("/").Handler(negroni.New(negroni.Wrap(corsHandler(api))))
 	n.UseHandler(r)
 
 	httpServer := http.Server{
@@ -198,6 +211,42 @@ func Register(extend HandlerExtender) {
 	}
 }
 
+// registerSessionRoutes adds the routes that create and use sessions
+func registerSessionRoutes(r *mux.Router) {
+	r.Handle("/", sessionRoute(NewSession)).Methods("POST")
+	r.Handle("/sessions/{sessionId}", sessionRoute(GetSession)).Methods("GET")
+	r.Handle("/sessions/{sessionId}/close", sessionRoute(CloseSession)).Methods("POST")
+	r.Handle("/sessions/{sessionId}", sessionRoute(CloseSession)).Methods("DELETE")
+	r.Handle("/sessions/{sessionId}/setup", sessionRoute(SessionSetup)).Methods("POST")
+	r.Handle("/sessions/{sessionId}/archive", sessionRoute(SessionArchive)).Methods("GET")
+	r.Handle("/sessions/{sessionId}/extend", sessionRoute(ExtendSession)).Methods("POST")
+	r.Handle("/sessions/{sessionId}/pause", sessionRoute(PauseSession)).Methods("POST")
+	r.Handle("/sessions/{sessionId}/resume", sessionRoute(ResumeSession)).Methods("POST")
+	r.Handle("/sessions/{sessionId}/endpoints", sessionRoute(ListEndpoints)).Methods("GET")
+	r.Handle("/sessions/{sessionId}/endpoints", sessionRoute(AddEndpoint)).Methods("POST")
+	r.Handle("/sessions/{sessionId}/endpoints/{name}", sessionRoute(RemoveEndpoint)).Methods("DELETE")
+	r.Handle("/sessions/{sessionId}/ports", sessionRoute(ListPorts)).Methods("GET")
+	r.Handle("/sessions/{sessionId}/ports", sessionRoute(AddPort)).Methods("POST")
+	r.Handle("/sessions/{sessionId}/ports/{protocol}/{port}", sessionRoute(RemovePort)).Methods("DELETE")
+	r.Handle("/sessions/{sessionId}/instances", sessionRoute(NewInstance)).Methods("POST")
+	r.Handle("/sessions/{sessionId}/instances/{instanceName}/uploads", sessionRoute(FileUpload)).Methods("POST")
+	r.Handle("/sessions/{sessionId}/instances/{instanceName}", sessionRoute(DeleteInstance)).Methods("DELETE")
+	r.Handle("/sessions/{sessionId}/instances/{instanceName}/exec", sessionRoute(Exec)).Methods("POST")
+	r.Handle("/sessions/{sessionId}/instances/{instanceName}/lesson", sessionRoute(LessonStep)).Methods("POST")
+	r.Handle("/sessions/{sessionId}/instances/{instanceName}/ports/{port}/access", sessionRoute(PortAccess)).Methods("POST")
+	r.Handle("/sessions/{sessionId}/instances/{instanceName}/fstree", sessionRoute(fsTree)).Methods("GET")
+	r.Handle("/sessions/{sessionId}/instances/{instanceName}/file", sessionRoute(file)).Methods("GET")
+	r.Handle("/sessions/{sessionId}/instances/{instanceName}/file", sessionRoute(FileWrite)).Methods("PUT")
+	r.Handle("/sessions/{sessionId}/instances/{instanceName}/file", sessionRoute(FileDelete)).Methods("DELETE")
+	r.Handle("/sessions/{sessionId}/instances/{instanceName}/files/move", sessionRoute(FileMove)).Methods("POST")
+	r.Handle("/sessions/{sessionId}/instances/{instanceName}/files/mkdir", sessionRoute(FileMkdir)).Methods("POST")
+	r.Handle("/sessions/{sessionId}/instances/{instanceName}/files/chmod", sessionRoute(FileChmod)).Methods("POST")
+	r.Handle("/sessions/{sessionId}/instances/{instanceName}/files/search", sessionRoute(FileSearch)).Methods("GET")
+	r.Handle("/sessions/{sessionId}/instances/{instanceName}/archive", sessionRoute(ArchiveDownload)).Methods("GET")
+	r.Handle("/sessions/{sessionId}/instances/{instanceName}/archive", sessionRoute(ArchiveUpload)).Methods("POST")
+	r.Handle("/sessions/{sessionId}/ws/", sessionRoute(WSH))
+}
+
 func serveAsset(w http.ResponseWriter, r *http.Request, name string) {
 	a, err := fs.ReadFile(staticFiles, name)
 	if err != nil {
@@ -20,11 +20,17 @@ func CloseSession(rw http.ResponseWriter, req *http.Request) {
 		rw.WriteHeader(http.StatusInternalServerError)
 		return
 	}
+	if !canAccessSession(req, session) {
+		rw.WriteHeader(http.StatusNotFound)
+		return
+	}
 
+	before := *session
 	if err := core.SessionClose(session); err != nil {
 		log.Println(err)
 		rw.WriteHeader(http.StatusInternalServerError)
 		return
 	}
+	recordAudit(req, "session.close", "session", session.Id, before, nil)
 
 }
@@ -0,0 +1,99 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/gorilla/securecookie"
+	"github.com/ringo380/lessoncraft/api/audit"
+	"github.com/ringo380/lessoncraft/api/auth"
+	"github.com/ringo380/lessoncraft/config"
+	"github.com/ringo380/lessoncraft/pwd"
+	"github.com/ringo380/lessoncraft/pwd/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestCloseSession_auditActor(t *testing.T) {
+	_p := &pwd.Mock{}
+	core = _p
+	config.SecureCookie = securecookie.New(securecookie.GenerateRandomKey(32), nil)
+	store := audit.NewMemoryStore()
+	defer SetAuditor(nil)
+	SetAuditor(audit.NewLogger(store).Record)
+
+	session := &types.Session{Id: "aaaabbbbcccc", UserId: "alice"}
+	_p.On("SessionGet", "aaaabbbbcccc").Return(session, nil)
+	_p.On("SessionClose", session).Return(nil)
+
+	rw := httptest.NewRecorder()
+	cookie := CookieID{Id: "alice"}
+	assert.Nil(t, cookie.SetCookie(rw, "localhost"))
+
+	req := httptest.NewRequest("DELETE", "/sessions/aaaabbbbcccc", nil)
+	for _, c := range rw.Result().Cookies() {
+		req.AddCookie(c)
+	}
+	r := mux.NewRouter()
+	r.HandleFunc("/sessions/{sessionId}", CloseSession)
+	rw = httptest.NewRecorder()
+	r.ServeHTTP(rw, req)
+	assert.Equal(t, http.StatusOK, rw.Code)
+
+	var entries []*audit.Entry
+	assert.Nil(t, store.Query(audit.Filter{Action: "session.close"}, func(e *audit.Entry) error {
+		entries = append(entries, e)
+		return nil
+	}))
+	if assert.Len(t, entries, 1) {
+		assert.Equal(t, audit.ActorUser, entries[0].ActorType)
+		assert.Equal(t, "alice", entries[0].ActorID)
+		assert.Equal(t, "aaaabbbbcccc", entries[0].TargetID)
+	}
+}
+
+func TestNewPlayground_auditActor(t *testing.T) {
+	_p := &pwd.Mock{}
+	core = _p
+	store := audit.NewMemoryStore()
+	defer SetAuditor(nil)
+	SetAuditor(audit.NewLogger(store).Record)
+
+	users := auth.NewMemoryUserStore()
+	account := &auth.UserWithAuth{ServiceAccount: true, AccountStatus: "active"}
+	account.Id = "ops"
+	assert.Nil(t, users.CreateUser(account))
+	apiKeys := auth.NewAPIKeyService(auth.NewMemoryAPIKeyStore(), users, auth.NewJWTService("secret", "lessoncraft", time.Hour))
+	key, apiKey, err := apiKeys.Generate(account, "ops", []auth.Scope{auth.ScopeAdmin}, time.Time{}, "admin")
+	assert.Nil(t, err)
+
+	defer SetTokenValidator(nil)
+	SetTokenValidator(apiKeys)
+	defer SetAdminKeyValidator(nil)
+	SetAdminKeyValidator(func(token string) bool {
+		_, err := apiKeys.ValidateToken(token)
+		return err == nil
+	})
+	_p.On("PlaygroundNew", mock.AnythingOfType("types.Playground")).Return(&types.Playground{Id: "p1"}, nil)
+
+	req := httptest.NewRequest("POST", "/playgrounds", strings.NewReader(`{"domain": "example.com"}`))
+	req.Header.Set("Authorization", "Bearer "+key)
+	rw := httptest.NewRecorder()
+	NewPlayground(rw, req)
+	assert.Equal(t, http.StatusOK, rw.Code)
+
+	var entries []*audit.Entry
+	assert.Nil(t, store.Query(audit.Filter{Action: "playground.create"}, func(e *audit.Entry) error {
+		entries = append(entries, e)
+		return nil
+	}))
+	if assert.Len(t, entries, 1) {
+		assert.Equal(t, audit.ActorAPIKey, entries[0].ActorType)
+		assert.Equal(t, "ops", entries[0].ActorID)
+		assert.Equal(t, apiKey.ID, entries[0].APIKeyID)
+	}
+}
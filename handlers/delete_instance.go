@@ -13,7 +13,10 @@ func DeleteInstance(rw http.ResponseWriter, req *http.Request) {
 	instanceName := vars["instanceName"]
 
 	s, err := core.SessionGet(sessionId)
-	if s != nil {
+	if s != nil && !canAccessSession(req, s) {
+		rw.WriteHeader(http.StatusNotFound)
+		return
+	} else if s != nil {
 		i := core.InstanceGet(s, instanceName)
 		err := core.InstanceDelete(s, i)
 		if err != nil {
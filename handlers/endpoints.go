@@ -0,0 +1,147 @@
+package handlers
+
+import (
+	"crypto/hmac"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/ringo380/lessoncraft/config"
+	"github.com/ringo380/lessoncraft/pwd"
+	"github.com/ringo380/lessoncraft/pwd/types"
+	"github.com/ringo380/lessoncraft/router"
+	"github.com/ringo380/lessoncraft/storage"
+)
+
+type EndpointRequest struct {
+	Name     string `json:"name"`
+	Instance string `json:"instance"`
+	Port     int    `json:"port"`
+}
+
+type EndpointInfo struct {
+	types.SessionEndpoint
+	Host string `json:"host"`
+}
+
+func ListEndpoints(rw http.ResponseWriter, req *http.Request) {
+	session, ok := lifecycleSession(rw, req)
+	if !ok {
+		return
+	}
+
+	endpoints := []EndpointInfo{}
+	for _, e := range session.Endpoints {
+		endpoints = append(endpoints, endpointInfo(req, session, e))
+	}
+	rw.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(rw).Encode(endpoints)
+}
+
+func AddEndpoint(rw http.ResponseWriter, req *http.Request) {
+	session, ok := lifecycleSession(rw, req)
+	if !ok {
+		return
+	}
+
+	var body EndpointRequest
+	if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+		rw.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintln(rw, `{"error": "invalid_request"}`)
+		return
+	}
+	endpoint := types.SessionEndpoint{Name: body.Name, Instance: body.Instance, Port: body.Port}
+	if err := core.SessionEndpointAdd(session, endpoint); err != nil {
+		writeEndpointError(rw, err)
+		return
+	}
+	endpoint, _ = session.Endpoint(body.Name)
+	recordAudit(req, "session.endpoint.add", "session", session.Id, nil, endpoint)
+
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(http.StatusCreated)
+	json.NewEncoder(rw).Encode(endpointInfo(req, session, endpoint))
+}
+
+func RemoveEndpoint(rw http.ResponseWriter, req *http.Request) {
+	session, ok := lifecycleSession(rw, req)
+	if !ok {
+		return
+	}
+
+	name := mux.Vars(req)["name"]
+	endpoint, _ := session.Endpoint(name)
+	if err := core.SessionEndpointRemove(session, name); err != nil {
+		writeEndpointError(rw, err)
+		return
+	}
+	recordAudit(req, "session.endpoint.remove", "session", session.Id, endpoint, nil)
+	rw.WriteHeader(http.StatusNoContent)
+}
+
+// ResolveEndpoint tells the l2 router where an endpoint leads. Requests are
+// signed with the l2 access key.
+func ResolveEndpoint(rw http.ResponseWriter, req *http.Request) {
+	if config.L2AccessKey == "" {
+		rw.WriteHeader(http.StatusNotFound)
+		return
+	}
+	body, err := io.ReadAll(io.LimitReader(req.Body, 4096))
+	if err != nil {
+		rw.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	signature := []byte(req.Header.Get(router.SignatureHeader))
+	if !hmac.Equal(signature, []byte(router.SignRequest([]byte(config.L2AccessKey), body))) {
+		rw.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	var alias router.AliasRequest
+	if err := json.Unmarshal(body, &alias); err != nil {
+		rw.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	session, err := core.SessionGet(alias.SessionId)
+	if err == storage.NotFoundError {
+		rw.WriteHeader(http.StatusNotFound)
+		return
+	} else if err != nil {
+		log.Println(err)
+		rw.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	instance, port, err := core.SessionEndpointResolve(session, alias.Name)
+	if err != nil {
+		rw.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	rw.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(rw).Encode(router.AliasResponse{IP: instance.RoutableIP, Port: port})
+}
+
+func endpointInfo(req *http.Request, session *types.Session, e types.SessionEndpoint) EndpointInfo {
+	return EndpointInfo{SessionEndpoint: e, Host: router.EncodeAlias(e.Name, session.Id, router.HostOpts{TLD: fmt.Sprintf("%s.%s", config.L2Subdomain, req.Host)})}
+}
+
+func writeEndpointError(rw http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, pwd.ErrInvalidEndpoint):
+		rw.Header().Set("Content-Type", "application/json")
+		rw.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(rw).Encode(map[string]string{"error": "invalid_endpoint", "reason": err.Error()})
+	case errors.Is(err, pwd.ErrEndpointExists):
+		rw.WriteHeader(http.StatusConflict)
+		fmt.Fprintln(rw, `{"error": "endpoint_exists"}`)
+	case errors.Is(err, pwd.ErrEndpointNotFound):
+		rw.WriteHeader(http.StatusNotFound)
+	default:
+		log.Println(err)
+		rw.WriteHeader(http.StatusInternalServerError)
+	}
+}
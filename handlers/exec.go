@@ -29,7 +29,7 @@ func Exec(rw http.ResponseWriter, req *http.Request) {
 	}
 
 	s, _ := core.SessionGet(sessionId)
-	if s == nil {
+	if s == nil || !canAccessSession(req, s) {
 		rw.WriteHeader(http.StatusNotFound)
 		return
 	}
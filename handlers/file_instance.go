@@ -1,7 +1,10 @@
 package handlers
 
 import (
+	"crypto/sha256"
 	"encoding/base64"
+	"encoding/hex"
+	"fmt"
 	"io"
 	"log"
 	"net/http"
@@ -24,7 +27,7 @@ func file(rw http.ResponseWriter, req *http.Request) {
 	}
 
 	s, _ := core.SessionGet(sessionId)
-	if s == nil {
+	if s == nil || !canAccessSession(req, s) {
 		rw.WriteHeader(http.StatusNotFound)
 		return
 	}
@@ -42,13 +45,18 @@ func file(rw http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	encoder := base64.NewEncoder(base64.StdEncoding, rw)
-
-	if _, err = io.Copy(encoder, instanceFile); err != nil {
+	content, err := io.ReadAll(instanceFile)
+	if err != nil {
 		log.Println(err)
 		rw.WriteHeader(http.StatusInternalServerError)
 		return
 	}
 
+	// The checksum of the content is sent back by the editor when saving it
+	sum := sha256.Sum256(content)
+	rw.Header().Set("ETag", fmt.Sprintf(`"%s"`, hex.EncodeToString(sum[:])))
+
+	encoder := base64.NewEncoder(base64.StdEncoding, rw)
+	encoder.Write(content)
 	encoder.Close()
 }
@@ -0,0 +1,230 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/ringo380/lessoncraft/provisioner"
+	"github.com/ringo380/lessoncraft/pwd/types"
+)
+
+// maxFileWriteSize caps the content the editor can save at once
+const maxFileWriteSize = 10 << 20
+
+type fileMoveRequest struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+type filePathRequest struct {
+	Path string `json:"path"`
+	// Mode is the octal mode of chmod, e.g. 0755
+	Mode string `json:"mode,omitempty"`
+}
+
+// FileWrite saves the body of the request to the file at path. The editor
+// sends the checksum of the content it loaded as If-Match, so edits made in
+// the meantime are not overwritten.
+func FileWrite(rw http.ResponseWriter, req *http.Request) {
+	i, ok := fileInstance(rw, req)
+	if !ok {
+		return
+	}
+	p, ok := cleanFilePath(rw, req.URL.Query().Get("path"))
+	if !ok {
+		return
+	}
+
+	checksum := strings.Trim(req.Header.Get("If-Match"), `"`)
+	body := http.MaxBytesReader(rw, req.Body, maxFileWriteSize)
+	sum, err := core.InstanceFileWrite(i, p, body, checksum)
+	if err != nil {
+		writeFileError(rw, err)
+		return
+	}
+	rw.Header().Set("Content-Type", "application/json")
+	rw.Header().Set("ETag", fmt.Sprintf(`"%s"`, sum))
+	json.NewEncoder(rw).Encode(map[string]string{"path": p, "checksum": sum})
+}
+
+func FileDelete(rw http.ResponseWriter, req *http.Request) {
+	i, ok := fileInstance(rw, req)
+	if !ok {
+		return
+	}
+	p, ok := cleanFilePath(rw, req.URL.Query().Get("path"))
+	if !ok {
+		return
+	}
+	if p == "/" {
+		rw.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintln(rw, `{"error": "invalid_path"}`)
+		return
+	}
+	if err := core.InstanceFileDelete(i, p); err != nil {
+		writeFileError(rw, err)
+		return
+	}
+	rw.WriteHeader(http.StatusNoContent)
+}
+
+func FileMove(rw http.ResponseWriter, req *http.Request) {
+	i, ok := fileInstance(rw, req)
+	if !ok {
+		return
+	}
+	var body fileMoveRequest
+	if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+		rw.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintln(rw, `{"error": "invalid_request"}`)
+		return
+	}
+	from, ok := cleanFilePath(rw, body.From)
+	if !ok {
+		return
+	}
+	to, ok := cleanFilePath(rw, body.To)
+	if !ok {
+		return
+	}
+	if err := core.InstanceFileMove(i, from, to); err != nil {
+		writeFileError(rw, err)
+		return
+	}
+	rw.WriteHeader(http.StatusNoContent)
+}
+
+func FileMkdir(rw http.ResponseWriter, req *http.Request) {
+	i, ok := fileInstance(rw, req)
+	if !ok {
+		return
+	}
+	var body filePathRequest
+	if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+		rw.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintln(rw, `{"error": "invalid_request"}`)
+		return
+	}
+	p, ok := cleanFilePath(rw, body.Path)
+	if !ok {
+		return
+	}
+	if err := core.InstanceMkdir(i, p); err != nil {
+		writeFileError(rw, err)
+		return
+	}
+	rw.WriteHeader(http.StatusCreated)
+}
+
+func FileChmod(rw http.ResponseWriter, req *http.Request) {
+	i, ok := fileInstance(rw, req)
+	if !ok {
+		return
+	}
+	var body filePathRequest
+	if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+		rw.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintln(rw, `{"error": "invalid_request"}`)
+		return
+	}
+	p, ok := cleanFilePath(rw, body.Path)
+	if !ok {
+		return
+	}
+	mode, err := strconv.ParseUint(body.Mode, 8, 32)
+	if err != nil || mode > 0777 {
+		rw.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintln(rw, `{"error": "invalid_mode"}`)
+		return
+	}
+	if err := core.InstanceChmod(i, p, os.FileMode(mode)); err != nil {
+		writeFileError(rw, err)
+		return
+	}
+	rw.WriteHeader(http.StatusNoContent)
+}
+
+// FileSearch looks for the lines of the files under path containing q, as a
+// regular expression if regex is true
+func FileSearch(rw http.ResponseWriter, req *http.Request) {
+	i, ok := fileInstance(rw, req)
+	if !ok {
+		return
+	}
+	query := req.URL.Query()
+	p, ok := cleanFilePath(rw, query.Get("path"))
+	if !ok {
+		return
+	}
+	if query.Get("q") == "" {
+		rw.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintln(rw, `{"error": "invalid_request"}`)
+		return
+	}
+	matches, err := core.InstanceFileSearch(i, p, query.Get("q"), query.Get("regex") == "true")
+	if err != nil {
+		writeFileError(rw, err)
+		return
+	}
+	rw.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(rw).Encode(matches)
+}
+
+// fileInstance returns the instance whose files are changed, checking the
+// caller has access to its session
+func fileInstance(rw http.ResponseWriter, req *http.Request) (*types.Instance, bool) {
+	session, ok := lifecycleSession(rw, req)
+	if !ok {
+		return nil, false
+	}
+	i := core.InstanceGet(session, mux.Vars(req)["instanceName"])
+	if i == nil {
+		rw.WriteHeader(http.StatusNotFound)
+		return nil, false
+	}
+	return i, true
+}
+
+// cleanFilePath only accepts absolute paths, so files do not depend on the
+// working directory of the instance
+func cleanFilePath(rw http.ResponseWriter, p string) (string, bool) {
+	if !path.IsAbs(p) {
+		rw.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintln(rw, `{"error": "invalid_path"}`)
+		return "", false
+	}
+	return path.Clean(p), true
+}
+
+func writeFileError(rw http.ResponseWriter, err error) {
+	var fileErr *provisioner.FileError
+	var tooLarge *http.MaxBytesError
+	switch {
+	case provisioner.FileChanged(err):
+		rw.WriteHeader(http.StatusConflict)
+		fmt.Fprintln(rw, `{"error": "file_changed"}`)
+	case provisioner.FileExists(err):
+		rw.WriteHeader(http.StatusConflict)
+		fmt.Fprintln(rw, `{"error": "file_exists"}`)
+	case provisioner.FileOpsNotSupported(err):
+		rw.WriteHeader(http.StatusNotImplemented)
+		fmt.Fprintln(rw, `{"error": "not_supported"}`)
+	case errors.As(err, &tooLarge):
+		rw.WriteHeader(http.StatusRequestEntityTooLarge)
+	case errors.As(err, &fileErr):
+		rw.Header().Set("Content-Type", "application/json")
+		rw.WriteHeader(http.StatusUnprocessableEntity)
+		json.NewEncoder(rw).Encode(map[string]string{"error": "file_error", "reason": fileErr.Message})
+	default:
+		log.Println(err)
+		rw.WriteHeader(http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,95 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/ringo380/lessoncraft/provisioner"
+	"github.com/ringo380/lessoncraft/pwd"
+	"github.com/ringo380/lessoncraft/pwd/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func newFileOpsTest(t *testing.T) (*pwd.Mock, *types.Instance, *mux.Router) {
+	_p := &pwd.Mock{}
+	core = _p
+	session := &types.Session{Id: "aaaabbbbcccc"}
+	instance := &types.Instance{Name: "aaaabbbb_node1", SessionId: session.Id}
+	_p.On("SessionGet", session.Id).Return(session, nil)
+	_p.On("InstanceGet", session, instance.Name).Return(instance)
+
+	r := mux.NewRouter()
+	registerSessionRoutes(r)
+	return _p, instance, r
+}
+
+func TestFileWrite(t *testing.T) {
+	_p, instance, r := newFileOpsTest(t)
+	_p.On("InstanceFileWrite", instance, "/root/app.go", mock.Anything, "stale").Return("", provisioner.FileChangedError)
+	_p.On("InstanceFileWrite", instance, "/root/app.go", mock.Anything, "current").Return("new", nil)
+
+	write := func(p, checksum string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("PUT", "/sessions/aaaabbbbcccc/instances/aaaabbbb_node1/file?path="+url.QueryEscape(p), strings.NewReader("package main"))
+		req.Header.Set("If-Match", `"`+checksum+`"`)
+		rw := httptest.NewRecorder()
+		r.ServeHTTP(rw, req)
+		return rw
+	}
+
+	// The file was changed since the editor loaded it
+	rw := write("/root/app.go", "stale")
+	assert.Equal(t, http.StatusConflict, rw.Code)
+	assert.Contains(t, rw.Body.String(), "file_changed")
+
+	// Paths are cleaned before they reach the instance
+	rw = write("/root/src/../app.go", "current")
+	assert.Equal(t, http.StatusOK, rw.Code)
+	assert.Equal(t, `"new"`, rw.Header().Get("ETag"))
+	assert.JSONEq(t, `{"path": "/root/app.go", "checksum": "new"}`, rw.Body.String())
+
+	rw = write("app.go", "current")
+	assert.Equal(t, http.StatusBadRequest, rw.Code)
+	_p.AssertNumberOfCalls(t, "InstanceFileWrite", 2)
+}
+
+func TestFileMove(t *testing.T) {
+	_p, instance, r := newFileOpsTest(t)
+	_p.On("InstanceFileMove", instance, "/root/a.txt", "/root/b.txt").Return(provisioner.FileExistsError)
+	_p.On("InstanceFileMove", instance, "/root/a.txt", "/root/c.txt").Return(nil)
+
+	move := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/sessions/aaaabbbbcccc/instances/aaaabbbb_node1/files/move", strings.NewReader(body))
+		rw := httptest.NewRecorder()
+		r.ServeHTTP(rw, req)
+		return rw
+	}
+
+	// Moves never overwrite the destination
+	rw := move(`{"from": "/root/a.txt", "to": "/root/b.txt"}`)
+	assert.Equal(t, http.StatusConflict, rw.Code)
+	assert.Contains(t, rw.Body.String(), "file_exists")
+
+	rw = move(`{"from": "/root//a.txt", "to": "/root/./tmp/../c.txt/"}`)
+	assert.Equal(t, http.StatusNoContent, rw.Code)
+	_p.AssertCalled(t, "InstanceFileMove", instance, "/root/a.txt", "/root/c.txt")
+
+	rw = move(`{"from": "/root/a.txt", "to": "../etc/passwd"}`)
+	assert.Equal(t, http.StatusBadRequest, rw.Code)
+	_p.AssertNumberOfCalls(t, "InstanceFileMove", 2)
+}
+
+func TestFileDelete_root(t *testing.T) {
+	_p, _, r := newFileOpsTest(t)
+
+	// /root/.. cleans to / which is never deleted
+	req := httptest.NewRequest("DELETE", "/sessions/aaaabbbbcccc/instances/aaaabbbb_node1/file?path="+url.QueryEscape("/root/.."), nil)
+	rw := httptest.NewRecorder()
+	r.ServeHTTP(rw, req)
+	assert.Equal(t, http.StatusBadRequest, rw.Code)
+	_p.AssertNotCalled(t, "InstanceFileDelete", mock.Anything, mock.Anything)
+}
@@ -23,6 +23,10 @@ func FileUpload(rw http.ResponseWriter, req *http.Request) {
 		rw.WriteHeader(http.StatusInternalServerError)
 		return
 	}
+	if !canAccessSession(req, s) {
+		rw.WriteHeader(http.StatusNotFound)
+		return
+	}
 	i := core.InstanceGet(s, instanceName)
 
 	// Path to upload the file to
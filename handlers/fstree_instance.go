@@ -14,7 +14,7 @@ func fsTree(rw http.ResponseWriter, req *http.Request) {
 	instanceName := vars["instanceName"]
 
 	s, _ := core.SessionGet(sessionId)
-	if s == nil {
+	if s == nil || !canAccessSession(req, s) {
 		rw.WriteHeader(http.StatusNotFound)
 		return
 	}
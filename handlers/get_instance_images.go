@@ -4,8 +4,21 @@ import (
 	"encoding/json"
 	"log"
 	"net/http"
+
+	"github.com/ringo380/lessoncraft/pwd/types"
 )
 
+// InstanceImagesFunc returns the images instances of a playground can be created from
+type InstanceImagesFunc func(playground *types.Playground) ([]string, error)
+
+var instanceImages InstanceImagesFunc
+
+// SetInstanceImages sets where the images offered for new instances come from,
+// e.g. the image catalog. Without it, the images of the playground are offered.
+func SetInstanceImages(f InstanceImagesFunc) {
+	instanceImages = f
+}
+
 func GetInstanceImages(rw http.ResponseWriter, req *http.Request) {
 	playground := core.PlaygroundFindByDomain(req.Host)
 	if playground == nil {
@@ -13,5 +26,15 @@ func GetInstanceImages(rw http.ResponseWriter, req *http.Request) {
 		rw.WriteHeader(http.StatusBadRequest)
 		return
 	}
-	json.NewEncoder(rw).Encode(playground.AvailableDinDInstanceImages)
+	images := playground.AvailableDinDInstanceImages
+	if instanceImages != nil {
+		var err error
+		images, err = instanceImages(playground)
+		if err != nil {
+			log.Printf("Could not get instance images for playground %s. Got: %v\n", playground.Id, err)
+			rw.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+	}
+	json.NewEncoder(rw).Encode(images)
 }
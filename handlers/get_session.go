@@ -6,10 +6,52 @@ import (
 	"net/http"
 
 	"github.com/gorilla/mux"
+	"github.com/ringo380/lessoncraft/api/auth"
 	"github.com/ringo380/lessoncraft/pwd/types"
 	"github.com/ringo380/lessoncraft/storage"
 )
 
+// SessionAccessFunc decides whether a request may see a session. It is used to
+// restrict sessions that belong to an organisation.
+type SessionAccessFunc func(req *http.Request, session *types.Session) bool
+
+var sessionAccess SessionAccessFunc
+
+// SetSessionAccess sets the visibility rules applied to session requests
+func SetSessionAccess(f SessionAccessFunc) {
+	sessionAccess = f
+}
+
+// SessionViewer decides whether a user, with their global roles, may see a
+// session. org.Policy.CanViewSession is one.
+type SessionViewer func(userID string, roles []auth.Role, session *types.Session) bool
+
+// UserSessionAccess applies per user visibility rules to session requests
+func UserSessionAccess(canView SessionViewer) SessionAccessFunc {
+	return func(req *http.Request, session *types.Session) bool {
+		userId, roles := requestUser(req)
+		return canView(userId, roles, session)
+	}
+}
+
+// requestUser returns the user making a request, from its bearer token when
+// it was authenticated with one and from the login cookie otherwise
+func requestUser(req *http.Request) (string, []auth.Role) {
+	if userId, ok := auth.GetUserID(req); ok && userId != "" {
+		roles, _ := auth.GetUserRoles(req)
+		return userId, roles
+	}
+	if cookie, err := ReadCookie(req); err == nil {
+		return cookie.Id, nil
+	}
+	return "", nil
+}
+
+// canAccessSession applies the session visibility rules to a request
+func canAccessSession(req *http.Request, session *types.Session) bool {
+	return sessionAccess == nil || sessionAccess(req, session)
+}
+
 type SessionInfo struct {
 	*types.Session
 	Instances map[string]*types.Instance `json:"instances"`
@@ -27,6 +69,10 @@ func GetSession(rw http.ResponseWriter, req *http.Request) {
 		rw.WriteHeader(http.StatusNotFound)
 		return
 	}
+	if !canAccessSession(req, session) {
+		rw.WriteHeader(http.StatusNotFound)
+		return
+	}
 
 	instances, err := core.InstanceFindBySession(session)
 	if err != nil {
@@ -23,6 +23,10 @@ func Home(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
+	if !canAccessSession(r, s) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
 	if s.Stack != "" {
 		go core.SessionDeployStack(s)
 	}
@@ -0,0 +1,45 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/ringo380/lessoncraft/pwd/types"
+)
+
+type lessonStepRequest struct {
+	LessonId  string `json:"lesson_id"`
+	StepIndex int    `json:"step_index"`
+}
+
+// LessonStep prepares an instance for a lesson step. The setup runs in the
+// background; its progress is sent to the session as builder output, followed
+// by a lesson step ready event.
+func LessonStep(rw http.ResponseWriter, req *http.Request) {
+	vars := mux.Vars(req)
+	sessionId := vars["sessionId"]
+	instanceName := vars["instanceName"]
+
+	var lr lessonStepRequest
+	err := json.NewDecoder(req.Body).Decode(&lr)
+	if err != nil || lr.LessonId == "" || lr.StepIndex < 0 {
+		rw.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	s, _ := core.SessionGet(sessionId)
+	if s == nil || !canAccessSession(req, s) {
+		rw.WriteHeader(http.StatusNotFound)
+		return
+	}
+	i := core.InstanceGet(s, instanceName)
+	if i == nil {
+		rw.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	go core.InstanceLessonSetup(i, types.LessonContext{LessonID: lr.LessonId, StepIndex: lr.StepIndex})
+
+	rw.WriteHeader(http.StatusAccepted)
+}
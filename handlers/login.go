@@ -2,6 +2,7 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
@@ -12,13 +13,45 @@ import (
 
 	"github.com/google/go-github/github"
 	"github.com/gorilla/mux"
+	"github.com/ringo380/lessoncraft/api/auth"
 	"github.com/ringo380/lessoncraft/config"
 	"github.com/ringo380/lessoncraft/pwd/types"
+	"github.com/ringo380/lessoncraft/storage"
 	uuid "github.com/satori/go.uuid"
 	"google.golang.org/api/option"
 	"google.golang.org/api/people/v1"
 )
 
+// IdentityResolver shares accounts between the OAuth login of the playground and
+// the API, so a user has a single identity whichever way they log in
+type IdentityResolver interface {
+	// ResolveIdentity returns the account of a user that logged in with a provider,
+	// creating it on first login
+	ResolveIdentity(provider string, user *types.User) (*types.User, error)
+	// LookupUser returns the account with the given ID
+	LookupUser(id string) (*types.User, error)
+}
+
+var identities IdentityResolver
+
+// SetIdentityResolver makes logins resolve to the accounts of the API. Without a
+// resolver users only exist in the playground storage.
+func SetIdentityResolver(r IdentityResolver) {
+	identities = r
+}
+
+// lookupUser returns the user with the given ID from the configured account store
+func lookupUser(id string) (*types.User, error) {
+	if identities != nil {
+		u, err := identities.LookupUser(id)
+		if errors.Is(err, auth.ErrUserNotFound) {
+			return nil, storage.NotFoundError
+		}
+		return u, err
+	}
+	return core.UserGet(id)
+}
+
 func LoggedInUser(rw http.ResponseWriter, req *http.Request) {
 	cookie, err := ReadCookie(req)
 	if err != nil {
@@ -27,7 +60,7 @@ func LoggedInUser(rw http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	user, err := core.UserGet(cookie.Id)
+	user, err := lookupUser(cookie.Id)
 	if err != nil {
 		log.Printf("Couldn't get user with id %s. Got: %v\n", cookie.Id, err)
 		rw.WriteHeader(http.StatusUnauthorized)
@@ -242,6 +275,23 @@ func LoginCallback(rw http.ResponseWriter, req *http.Request) {
 		return
 	}
 
+	if identities != nil {
+		account, err := identities.ResolveIdentity(providerName, user)
+		if err != nil {
+			log.Printf("Could not resolve account of user %s. Got: %v\n", user.Id, err)
+			switch {
+			case errors.Is(err, auth.ErrAccountInactive):
+				rw.WriteHeader(http.StatusForbidden)
+			case errors.Is(err, auth.ErrLinkRequired), errors.Is(err, auth.ErrUserAlreadyExists), errors.Is(err, auth.ErrIdentityAlreadyLinked):
+				rw.WriteHeader(http.StatusConflict)
+			default:
+				rw.WriteHeader(http.StatusInternalServerError)
+			}
+			return
+		}
+		user = account
+	}
+
 	cookieData := CookieID{Id: user.Id, UserName: user.Name, UserAvatar: user.Avatar, ProviderId: user.ProviderUserId}
 
 	host := "localhost"
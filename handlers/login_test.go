@@ -0,0 +1,52 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/gorilla/securecookie"
+	"github.com/ringo380/lessoncraft/api/auth"
+	"github.com/ringo380/lessoncraft/config"
+	"github.com/ringo380/lessoncraft/pwd"
+	"github.com/ringo380/lessoncraft/pwd/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoggedInUser_identityResolver(t *testing.T) {
+	core = &pwd.Mock{}
+	config.SecureCookie = securecookie.New(securecookie.GenerateRandomKey(32), nil)
+	accounts := auth.NewAuthHandler(auth.NewMemoryUserStore(), auth.NewJWTService("secret", "lessoncraft", time.Hour))
+	defer SetIdentityResolver(nil)
+	SetIdentityResolver(accounts)
+
+	// A playground login resolves to the account the API knows the user by
+	user, err := accounts.ResolveIdentity("github", &types.User{Id: "legacy-id", Provider: "github", ProviderUserId: "42", Name: "Jane"})
+	assert.Nil(t, err)
+
+	rw := httptest.NewRecorder()
+	cookie := CookieID{Id: user.Id, UserName: user.Name}
+	assert.Nil(t, cookie.SetCookie(rw, "localhost"))
+
+	req := httptest.NewRequest("GET", "/users/me", nil)
+	for _, c := range rw.Result().Cookies() {
+		req.AddCookie(c)
+	}
+	rw = httptest.NewRecorder()
+	LoggedInUser(rw, req)
+	assert.Equal(t, http.StatusOK, rw.Code)
+
+	var me types.User
+	assert.Nil(t, json.NewDecoder(rw.Body).Decode(&me))
+	assert.Equal(t, "legacy-id", me.Id)
+	assert.Equal(t, "Jane", me.Name)
+
+	r := mux.NewRouter()
+	r.HandleFunc("/users/{userId}", GetUser)
+	rw = httptest.NewRecorder()
+	r.ServeHTTP(rw, httptest.NewRequest("GET", "/users/unknown", nil))
+	assert.Equal(t, http.StatusNotFound, rw.Code)
+}
@@ -2,11 +2,13 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
 
 	"github.com/gorilla/mux"
+	"github.com/ringo380/lessoncraft/api/quota"
 	"github.com/ringo380/lessoncraft/provisioner"
 	"github.com/ringo380/lessoncraft/pwd/types"
 )
@@ -24,6 +26,10 @@ func NewInstance(rw http.ResponseWriter, req *http.Request) {
 		rw.WriteHeader(http.StatusInternalServerError)
 		return
 	}
+	if !canAccessSession(req, s) {
+		rw.WriteHeader(http.StatusNotFound)
+		return
+	}
 
 	playground := core.PlaygroundGet(s.PlaygroundId)
 	if playground == nil {
@@ -68,6 +74,10 @@ func NewInstance(rw http.ResponseWriter, req *http.Request) {
 			fmt.Fprintln(rw, `{"error": "out_of_capacity"}`)
 			return
 		}
+		if quota.IsExceeded(err) {
+			writeQuotaExceeded(rw, err)
+			return
+		}
 		log.Println(err)
 		rw.WriteHeader(http.StatusInternalServerError)
 		return
@@ -76,3 +86,20 @@ func NewInstance(rw http.ResponseWriter, req *http.Request) {
 		json.NewEncoder(rw).Encode(i)
 	}
 }
+
+// writeQuotaExceeded tells the client which quota a session or instance did
+// not fit in. Unlike running out of capacity, retrying does not help until
+// something of the user is closed.
+func writeQuotaExceeded(rw http.ResponseWriter, err error) {
+	var exceeded *quota.ExceededError
+	errors.As(err, &exceeded)
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(http.StatusForbidden)
+	json.NewEncoder(rw).Encode(map[string]interface{}{
+		"error":     "quota_exceeded",
+		"scope":     exceeded.Scope,
+		"resource":  exceeded.Resource,
+		"limit":     exceeded.Limit,
+		"requested": exceeded.Requested,
+	})
+}
@@ -10,6 +10,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/ringo380/lessoncraft/api/quota"
 	"github.com/ringo380/lessoncraft/config"
 	"github.com/ringo380/lessoncraft/provisioner"
 	"github.com/ringo380/lessoncraft/pwd/types"
@@ -30,21 +31,18 @@ func NewSession(rw http.ResponseWriter, req *http.Request) {
 
 	req.ParseForm()
 
-	userId := ""
-	if len(config.Providers[playground.Id]) > 0 {
-		cookie, err := ReadCookie(req)
-		if err != nil {
-			// User it not a human
-			rw.WriteHeader(http.StatusForbidden)
-			return
-		}
-		userId = cookie.Id
+	userId, _ := requestUser(req)
+	if userId == "" && len(config.Providers[playground.Id]) > 0 {
+		// User it not a human
+		rw.WriteHeader(http.StatusForbidden)
+		return
 	}
 
 	reqDur := req.Form.Get("session-duration")
 	stack := req.Form.Get("stack")
 	stackName := req.Form.Get("stack_name")
 	imageName := req.Form.Get("image_name")
+	orgId := req.Form.Get("org_id")
 
 	if stack != "" {
 		stack = formatStack(stack)
@@ -77,13 +75,26 @@ func NewSession(rw http.ResponseWriter, req *http.Request) {
 		duration = playground.DefaultSessionDuration
 	}
 
-	sConfig := types.SessionConfig{Playground: playground, UserId: userId, Duration: duration, Stack: stack, StackName: stackName, ImageName: imageName}
+	if orgId != "" {
+		// Sessions of an organisation are only visible to its members, so the
+		// user has to pass the same rules before creating one
+		if !canAccessSession(req, &types.Session{UserId: userId, OrgId: orgId}) {
+			rw.WriteHeader(http.StatusForbidden)
+			return
+		}
+	}
+
+	sConfig := types.SessionConfig{Playground: playground, UserId: userId, Duration: duration, Stack: stack, StackName: stackName, ImageName: imageName, OrgId: orgId}
 	s, err := core.SessionNew(context.Background(), sConfig)
 	if err != nil {
 		if provisioner.OutOfCapacity(err) {
 			http.Redirect(rw, req, "/ooc", http.StatusFound)
 			return
 		}
+		if quota.IsExceeded(err) {
+			writeQuotaExceeded(rw, err)
+			return
+		}
 		log.Printf("%#v \n", err)
 		http.Redirect(rw, req, "/500", http.StatusInternalServerError)
 		return
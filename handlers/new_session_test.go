@@ -0,0 +1,49 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ringo380/lessoncraft/api/auth"
+	"github.com/ringo380/lessoncraft/api/org"
+	"github.com/ringo380/lessoncraft/pwd"
+	"github.com/ringo380/lessoncraft/pwd/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestNewSession_org(t *testing.T) {
+	_p := &pwd.Mock{}
+	core = _p
+	orgs := org.NewMemoryStore()
+	assert.Nil(t, orgs.CreateOrganisation(&org.Organisation{ID: "acme", Name: "Acme"}))
+	assert.Nil(t, orgs.SetMembership(&org.Membership{OrgID: "acme", UserID: "member", Role: org.RoleStudent}))
+	defer SetSessionAccess(nil)
+	SetSessionAccess(UserSessionAccess(org.NewPolicy(orgs).CanViewSession))
+
+	playground := &types.Playground{Id: "p1", DefaultSessionDuration: time.Hour}
+	_p.On("PlaygroundFindByDomain", "example.com").Return(playground)
+	_p.On("SessionNew", mock.Anything, mock.AnythingOfType("types.SessionConfig")).Return(&types.Session{Id: "aaaabbbbcccc"}, nil)
+
+	newSession := func(userId string) int {
+		form := url.Values{"org_id": {"acme"}}
+		req := httptest.NewRequest("POST", "http://example.com/", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set("X-Requested-With", "XMLHttpRequest")
+		req = req.WithContext(context.WithValue(req.Context(), auth.UserContextKey, userId))
+		rw := httptest.NewRecorder()
+		NewSession(rw, req)
+		return rw.Code
+	}
+
+	assert.Equal(t, http.StatusForbidden, newSession("stranger"))
+	_p.AssertNotCalled(t, "SessionNew", mock.Anything, mock.Anything)
+
+	assert.Equal(t, http.StatusOK, newSession("member"))
+	_p.AssertCalled(t, "SessionNew", mock.Anything, types.SessionConfig{Playground: playground, UserId: "member", Duration: time.Hour, OrgId: "acme"})
+}
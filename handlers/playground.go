@@ -1,12 +1,15 @@
 package handlers
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"log"
 	"net/http"
+	"strings"
 	"time"
 
+	"github.com/ringo380/lessoncraft/api/auth"
 	"github.com/ringo380/lessoncraft/config"
 	"github.com/ringo380/lessoncraft/pwd/types"
 )
@@ -33,6 +36,8 @@ func NewPlayground(rw http.ResponseWriter, req *http.Request) {
 		return
 	}
 
+	recordAudit(req, "playground.create", "playground", newPlayground.Id, nil, newPlayground)
+
 	json.NewEncoder(rw).Encode(newPlayground)
 }
 
@@ -80,7 +85,78 @@ func GetCurrentPlayground(rw http.ResponseWriter, req *http.Request) {
 	})
 }
 
+// AuditFunc records an administrative action in the audit log. before and after
+// are the state of the target around the action and may be nil.
+type AuditFunc func(req *http.Request, action, targetType, targetID string, before, after interface{})
+
+var auditor AuditFunc
+
+// SetAuditor sets the audit log that playground creation and session closes are
+// recorded in
+func SetAuditor(f AuditFunc) {
+	auditor = f
+}
+
+func recordAudit(req *http.Request, action, targetType, targetID string, before, after interface{}) {
+	if auditor != nil {
+		auditor(withCaller(req), action, targetType, targetID, before, after)
+	}
+}
+
+// withCaller adds the caller to the request context, where the audit log looks
+// for the actor. Session routes are authenticated with the login cookie and the
+// admin routes check their bearer token themselves, so unlike routes behind
+// AuthMiddleware the context does not carry the caller yet.
+func withCaller(req *http.Request) *http.Request {
+	if _, ok := auth.GetUserID(req); ok {
+		return req
+	}
+
+	ctx := req.Context()
+	header := req.Header.Get("Authorization")
+	if token := strings.TrimPrefix(header, "Bearer "); token != header {
+		if tokenValidator == nil {
+			return req
+		}
+		claims, err := tokenValidator.ValidateToken(token)
+		if err != nil {
+			return req
+		}
+		ctx = context.WithValue(ctx, auth.UserContextKey, claims.UserID)
+		if claims.APIKeyID != "" {
+			ctx = context.WithValue(ctx, auth.APIKeyContextKey, claims.APIKeyID)
+		}
+		return req.WithContext(ctx)
+	}
+
+	// Callers using the shared admin token are recorded as such, not as the
+	// user that happens to be logged in on the same browser
+	if header == "" {
+		if cookie, err := ReadCookie(req); err == nil {
+			return req.WithContext(context.WithValue(ctx, auth.UserContextKey, cookie.Id))
+		}
+	}
+	return req
+}
+
+// AdminKeyValidator checks if a bearer token grants access to the admin endpoints
+type AdminKeyValidator func(token string) bool
+
+var adminKeyValidator AdminKeyValidator
+
+// SetAdminKeyValidator makes the admin endpoints accept API keys in addition to
+// the shared admin token
+func SetAdminKeyValidator(v AdminKeyValidator) {
+	adminKeyValidator = v
+}
+
 func ValidateToken(req *http.Request) bool {
+	if adminKeyValidator != nil {
+		if token := strings.TrimPrefix(req.Header.Get("Authorization"), "Bearer "); token != req.Header.Get("Authorization") {
+			return adminKeyValidator(token)
+		}
+	}
+
 	_, password, ok := req.BasicAuth()
 	if !ok {
 		return false
@@ -0,0 +1,106 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/ringo380/lessoncraft/config"
+	"github.com/ringo380/lessoncraft/pwd/types"
+	"github.com/ringo380/lessoncraft/router"
+)
+
+// portAccessTTL is how long a port link is valid for sessions that do not expire
+const portAccessTTL = time.Hour
+
+var portAccess *router.AccessControl
+
+// SetPortAccess sets the access control signing the links to instance ports.
+// It must share its key with the l2 router. Without it, plain links are
+// handed out.
+func SetPortAccess(a *router.AccessControl) {
+	portAccess = a
+}
+
+var sessionManager SessionViewer
+
+// SetSessionManager sets who may get signed links to the ports of a session;
+// org.Policy.CanManageSession is one. Without it only the owner of the session
+// may.
+func SetSessionManager(canManage SessionViewer) {
+	sessionManager = canManage
+}
+
+// canManageSession checks if the user of the request may act for the session
+func canManageSession(req *http.Request, session *types.Session) bool {
+	userId, roles := requestUser(req)
+	if sessionManager != nil {
+		return sessionManager(userId, roles, session)
+	}
+	return userId != "" && userId == session.UserId
+}
+
+type PortAccessResponse struct {
+	URL       string    `json:"url"`
+	ExpiresAt time.Time `json:"expires_at,omitempty"`
+}
+
+func PortAccess(rw http.ResponseWriter, req *http.Request) {
+	vars := mux.Vars(req)
+	port, err := strconv.Atoi(vars["port"])
+	if err != nil || port < 1 || port > 65535 {
+		rw.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintln(rw, `{"error": "invalid_port"}`)
+		return
+	}
+
+	session, ok := lifecycleSession(rw, req)
+	if !ok {
+		return
+	}
+	instance := core.InstanceGet(session, vars["instanceName"])
+	if instance == nil {
+		rw.WriteHeader(http.StatusNotFound)
+		return
+	}
+	// Session IDs are part of every public hostname, so being able to see the
+	// session is not enough to be handed a token for it
+	if portAccess != nil && !canManageSession(req, session) {
+		rw.WriteHeader(http.StatusForbidden)
+		fmt.Fprintln(rw, `{"error": "forbidden"}`)
+		return
+	}
+
+	secure := req.URL.Query().Get("tls") == "true"
+	u := url.URL{Scheme: "http", Host: fmt.Sprintf("%s-%d.%s.%s", instance.ProxyHost, port, config.L2Subdomain, req.Host), Path: "/"}
+	if config.L2TLSMode != "" {
+		// The router terminates TLS itself, so links go straight to HTTPS
+		u.Scheme = "https"
+		secure = false
+	}
+	resp := PortAccessResponse{}
+	if portAccess != nil {
+		expires := session.ExpiresAt
+		if expires.IsZero() {
+			expires = time.Now().Add(portAccessTTL)
+		}
+		q := url.Values{}
+		q.Set(router.AccessParam, portAccess.NewToken(session.Id, port, expires))
+		if secure {
+			// The router redirects to the TLS endpoint once it has seen the token
+			q.Set(router.AccessSchemeParam, "https")
+		}
+		u.RawQuery = q.Encode()
+		resp.ExpiresAt = expires
+	} else if secure {
+		u.Scheme = "https"
+	}
+	resp.URL = u.String()
+
+	rw.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(rw).Encode(resp)
+}
@@ -0,0 +1,50 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/ringo380/lessoncraft/api/auth"
+	"github.com/ringo380/lessoncraft/pwd"
+	"github.com/ringo380/lessoncraft/pwd/types"
+	"github.com/ringo380/lessoncraft/router"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPortAccess_owner(t *testing.T) {
+	_p := &pwd.Mock{}
+	core = _p
+	defer SetPortAccess(nil)
+	SetPortAccess(router.NewAccessControl([]byte("secret")))
+
+	// Sessions outside an organisation are visible to everyone
+	session := &types.Session{Id: "aaaabbbbcccc", UserId: "owner"}
+	instance := &types.Instance{Name: "aaaabbbb_node1", SessionId: session.Id, ProxyHost: "ip10-0-0-1-aaaabbbbcccc"}
+	_p.On("SessionGet", session.Id).Return(session, nil)
+	_p.On("InstanceGet", session, instance.Name).Return(instance)
+
+	r := mux.NewRouter()
+	r.HandleFunc("/sessions/{sessionId}/instances/{instanceName}/ports/{port}/access", PortAccess).Methods("POST")
+	access := func(user string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "http://example.com/sessions/aaaabbbbcccc/instances/aaaabbbb_node1/ports/8080/access", nil)
+		if user != "" {
+			req = req.WithContext(context.WithValue(req.Context(), auth.UserContextKey, user))
+		}
+		rw := httptest.NewRecorder()
+		r.ServeHTTP(rw, req)
+		return rw
+	}
+
+	assert.Equal(t, http.StatusForbidden, access("").Code)
+	assert.Equal(t, http.StatusForbidden, access("stranger").Code)
+
+	rw := access("owner")
+	assert.Equal(t, http.StatusOK, rw.Code)
+	var resp PortAccessResponse
+	assert.Nil(t, json.NewDecoder(rw.Body).Decode(&resp))
+	assert.Contains(t, resp.URL, router.AccessParam+"=")
+}
@@ -0,0 +1,151 @@
+package handlers
+
+import (
+	"crypto/hmac"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/ringo380/lessoncraft/config"
+	"github.com/ringo380/lessoncraft/pwd"
+	"github.com/ringo380/lessoncraft/pwd/types"
+	"github.com/ringo380/lessoncraft/router"
+)
+
+type PortRequest struct {
+	// Protocol is tcp or udp
+	Protocol string `json:"protocol"`
+	Instance string `json:"instance"`
+	Port     int    `json:"port"`
+}
+
+type PortInfo struct {
+	types.SessionPort
+	// Address is where clients reach the port, e.g. direct.example.com:30000
+	Address string `json:"address"`
+}
+
+func ListPorts(rw http.ResponseWriter, req *http.Request) {
+	session, ok := lifecycleSession(rw, req)
+	if !ok {
+		return
+	}
+
+	ports := []PortInfo{}
+	for _, p := range session.Ports {
+		ports = append(ports, portInfo(req, p))
+	}
+	rw.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(rw).Encode(ports)
+}
+
+func AddPort(rw http.ResponseWriter, req *http.Request) {
+	session, ok := lifecycleSession(rw, req)
+	if !ok {
+		return
+	}
+
+	var body PortRequest
+	if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+		rw.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintln(rw, `{"error": "invalid_request"}`)
+		return
+	}
+	if body.Protocol == "" {
+		body.Protocol = router.ForwardTCP
+	}
+	port, err := core.SessionPortAdd(session, types.SessionPort{Protocol: body.Protocol, Instance: body.Instance, InstancePort: body.Port})
+	if err != nil {
+		writePortError(rw, err)
+		return
+	}
+	recordAudit(req, "session.port.add", "session", session.Id, nil, port)
+
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(http.StatusCreated)
+	json.NewEncoder(rw).Encode(portInfo(req, *port))
+}
+
+func RemovePort(rw http.ResponseWriter, req *http.Request) {
+	session, ok := lifecycleSession(rw, req)
+	if !ok {
+		return
+	}
+
+	vars := mux.Vars(req)
+	port, err := strconv.Atoi(vars["port"])
+	if err != nil {
+		rw.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if err := core.SessionPortRemove(session, vars["protocol"], port); err != nil {
+		writePortError(rw, err)
+		return
+	}
+	recordAudit(req, "session.port.remove", "session", session.Id, map[string]interface{}{"protocol": vars["protocol"], "port": port}, nil)
+	rw.WriteHeader(http.StatusNoContent)
+}
+
+// RouterPorts gives the l2 router the port forwards of every session.
+// Requests are signed with the l2 access key.
+func RouterPorts(rw http.ResponseWriter, req *http.Request) {
+	if config.L2AccessKey == "" {
+		rw.WriteHeader(http.StatusNotFound)
+		return
+	}
+	body, err := io.ReadAll(io.LimitReader(req.Body, 4096))
+	if err != nil {
+		rw.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	signature := []byte(req.Header.Get(router.SignatureHeader))
+	if !hmac.Equal(signature, []byte(router.SignRequest([]byte(config.L2AccessKey), body))) {
+		rw.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	var r router.PortForwardsRequest
+	if err := json.Unmarshal(body, &r); err != nil || r.Expired() {
+		rw.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	forwards, err := core.PortForwards()
+	if err != nil {
+		log.Println(err)
+		rw.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	rw.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(rw).Encode(forwards)
+}
+
+func portInfo(req *http.Request, p types.SessionPort) PortInfo {
+	host := req.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return PortInfo{SessionPort: p, Address: net.JoinHostPort(fmt.Sprintf("%s.%s", config.L2Subdomain, host), strconv.Itoa(p.Port))}
+}
+
+func writePortError(rw http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, pwd.ErrInvalidPort):
+		rw.Header().Set("Content-Type", "application/json")
+		rw.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(rw).Encode(map[string]string{"error": "invalid_port", "reason": err.Error()})
+	case errors.Is(err, pwd.ErrNoFreePort):
+		rw.WriteHeader(http.StatusServiceUnavailable)
+		fmt.Fprintln(rw, `{"error": "no_free_port"}`)
+	case errors.Is(err, pwd.ErrPortNotFound):
+		rw.WriteHeader(http.StatusNotFound)
+	default:
+		log.Println(err)
+		rw.WriteHeader(http.StatusInternalServerError)
+	}
+}
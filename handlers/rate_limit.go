@@ -0,0 +1,45 @@
+package handlers
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/ringo380/lessoncraft/api/ratelimit"
+)
+
+var (
+	// SessionCreateRateLimit limits how often a client can create sessions
+	SessionCreateRateLimit = ratelimit.Policy{Name: "session-create", Limit: 5, Window: time.Minute}
+	// ExecRateLimit limits how often a client can run commands in instances
+	ExecRateLimit = ratelimit.Policy{Name: "exec", Limit: 60, Window: time.Minute}
+)
+
+var rateLimiter *ratelimit.Limiter
+
+// SetRateLimiter limits the session API with l. Register adds the
+// SessionCreateRateLimit and ExecRateLimit route policies to it, and requests are
+// counted per API key or logged in user, falling back to the client IP.
+// It must be called before Register.
+func SetRateLimiter(l *ratelimit.Limiter) {
+	l.SetKeyFuncs(ratelimit.UserKey, tokenRateLimitKey, cookieRateLimitKey)
+	rateLimiter = l
+}
+
+// tokenRateLimitKey identifies requests by the user or API key of their bearer
+// token. Register puts the user of valid tokens in the request context before
+// the limiter runs, so the token is only validated here when it was not.
+func tokenRateLimitKey(req *http.Request) string {
+	if tokenValidator == nil {
+		return ""
+	}
+	return ratelimit.TokenKey(tokenValidator)(req)
+}
+
+// cookieRateLimitKey identifies requests by the user of the login cookie
+func cookieRateLimitKey(req *http.Request) string {
+	cookie, err := ReadCookie(req)
+	if err != nil || cookie.Id == "" {
+		return ""
+	}
+	return "user:" + cookie.Id
+}
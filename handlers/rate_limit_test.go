@@ -0,0 +1,42 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/ringo380/lessoncraft/api/auth"
+	"github.com/ringo380/lessoncraft/api/ratelimit"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRateLimiter_bearerUsers(t *testing.T) {
+	jwt := auth.NewJWTService("secret", "lessoncraft", time.Hour)
+	defer SetTokenValidator(nil)
+	SetTokenValidator(jwt)
+	l := ratelimit.NewLimiter(ratelimit.NewMemoryBackend(), ratelimit.Policy{Name: "default", Limit: 1, Window: time.Minute})
+	SetRateLimiter(l)
+	defer func() { rateLimiter = nil }()
+
+	r := mux.NewRouter()
+	r.HandleFunc("/sessions/{sessionId}", func(rw http.ResponseWriter, req *http.Request) {}).Methods("GET")
+	h := l.Handler(r)
+
+	get := func(userId string) int {
+		token, _, err := jwt.GenerateToken(userId, userId+"@example.com", []auth.Role{auth.RoleLearner})
+		assert.Nil(t, err)
+		req := httptest.NewRequest("GET", "/sessions/aaaabbbbcccc", nil)
+		req.RemoteAddr = "10.0.0.1:5000"
+		req.Header.Set("Authorization", "Bearer "+token)
+		rw := httptest.NewRecorder()
+		h.ServeHTTP(rw, req)
+		return rw.Code
+	}
+
+	// Users behind the same address have their own buckets
+	assert.Equal(t, http.StatusOK, get("alice"))
+	assert.Equal(t, http.StatusOK, get("bob"))
+	assert.Equal(t, http.StatusTooManyRequests, get("alice"))
+}
@@ -0,0 +1,25 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/ringo380/lessoncraft/api/auth"
+)
+
+var tokenValidator auth.TokenValidator
+
+// SetTokenValidator makes the session routes accept bearer tokens and API keys
+// in addition to the login cookie. API keys need the sessions:write scope. It
+// must be called before Register.
+func SetTokenValidator(v auth.TokenValidator) {
+	tokenValidator = v
+}
+
+// sessionRoute authenticates the bearer token of a session request, when
+// there is one, so the session rules apply to the user of the token
+func sessionRoute(f http.HandlerFunc) http.Handler {
+	if tokenValidator == nil {
+		return f
+	}
+	return auth.OptionalAuthMiddleware(tokenValidator)(auth.ScopeMiddleware(auth.ScopeSessionsWrite)(f))
+}
@@ -0,0 +1,54 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/ringo380/lessoncraft/api/auth"
+	"github.com/ringo380/lessoncraft/pwd"
+	"github.com/ringo380/lessoncraft/pwd/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestSessionRoutes_apiKeyScopes(t *testing.T) {
+	_p := &pwd.Mock{}
+	core = _p
+	playground := &types.Playground{Id: "p1", DefaultSessionDuration: time.Hour}
+	_p.On("PlaygroundFindByDomain", "example.com").Return(playground)
+	_p.On("SessionNew", mock.Anything, mock.AnythingOfType("types.SessionConfig")).Return(&types.Session{Id: "aaaabbbbcccc"}, nil)
+
+	users := auth.NewMemoryUserStore()
+	account := &auth.UserWithAuth{ServiceAccount: true, AccountStatus: "active", Roles: []auth.Role{auth.RoleEducator}}
+	account.Id = "ci"
+	assert.Nil(t, users.CreateUser(account))
+	apiKeys := auth.NewAPIKeyService(auth.NewMemoryAPIKeyStore(), users, auth.NewJWTService("secret", "lessoncraft", time.Hour))
+	lessonsKey, _, err := apiKeys.Generate(account, "lessons", []auth.Scope{auth.ScopeLessonsWrite}, time.Time{}, "admin")
+	assert.Nil(t, err)
+	sessionsKey, _, err := apiKeys.Generate(account, "sessions", []auth.Scope{auth.ScopeSessionsWrite}, time.Time{}, "admin")
+	assert.Nil(t, err)
+
+	defer SetTokenValidator(nil)
+	SetTokenValidator(apiKeys)
+	r := mux.NewRouter()
+	registerSessionRoutes(r)
+
+	newSession := func(key string) int {
+		req := httptest.NewRequest("POST", "http://example.com/", nil)
+		req.Header.Set("Authorization", "Bearer "+key)
+		req.Header.Set("X-Requested-With", "XMLHttpRequest")
+		rw := httptest.NewRecorder()
+		r.ServeHTTP(rw, req)
+		return rw.Code
+	}
+
+	assert.Equal(t, http.StatusForbidden, newSession(lessonsKey))
+	_p.AssertNotCalled(t, "SessionNew", mock.Anything, mock.Anything)
+
+	// Sessions are owned by the service account of the key
+	assert.Equal(t, http.StatusOK, newSession(sessionsKey))
+	_p.AssertCalled(t, "SessionNew", mock.Anything, types.SessionConfig{Playground: playground, UserId: "ci", Duration: time.Hour})
+}
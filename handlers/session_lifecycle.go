@@ -0,0 +1,129 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/ringo380/lessoncraft/provisioner"
+	"github.com/ringo380/lessoncraft/pwd"
+	"github.com/ringo380/lessoncraft/pwd/types"
+	"github.com/ringo380/lessoncraft/storage"
+)
+
+// defaultSessionExtension is how much a session is extended when no duration
+// is requested
+const defaultSessionExtension = 30 * time.Minute
+
+type ExtendSessionRequest struct {
+	// Duration to extend the session by, e.g. "30m"
+	Duration string `json:"duration"`
+}
+
+type ExtendSessionResponse struct {
+	ExpiresAt  time.Time `json:"expires_at"`
+	Extensions int       `json:"extensions"`
+}
+
+func ExtendSession(rw http.ResponseWriter, req *http.Request) {
+	session, ok := lifecycleSession(rw, req)
+	if !ok {
+		return
+	}
+
+	var body ExtendSessionRequest
+	json.NewDecoder(req.Body).Decode(&body)
+	d, err := parseExtension(body.Duration)
+	if err != nil {
+		rw.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintln(rw, `{"error": "invalid_duration"}`)
+		return
+	}
+
+	before := *session
+	if err := core.SessionExtend(session, d); err != nil {
+		writeLifecycleError(rw, err)
+		return
+	}
+	recordAudit(req, "session.extend", "session", session.Id, before, session)
+
+	rw.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(rw).Encode(ExtendSessionResponse{ExpiresAt: session.ExpiresAt, Extensions: session.Extensions})
+}
+
+func PauseSession(rw http.ResponseWriter, req *http.Request) {
+	session, ok := lifecycleSession(rw, req)
+	if !ok {
+		return
+	}
+	if err := core.SessionPause(session); err != nil {
+		writeLifecycleError(rw, err)
+		return
+	}
+	recordAudit(req, "session.pause", "session", session.Id, nil, nil)
+}
+
+func ResumeSession(rw http.ResponseWriter, req *http.Request) {
+	session, ok := lifecycleSession(rw, req)
+	if !ok {
+		return
+	}
+	if err := core.SessionResume(session); err != nil {
+		writeLifecycleError(rw, err)
+		return
+	}
+	recordAudit(req, "session.resume", "session", session.Id, nil, nil)
+}
+
+// lifecycleSession loads the session of the request, answering 404 when it
+// does not exist or the request may not see it
+func lifecycleSession(rw http.ResponseWriter, req *http.Request) (*types.Session, bool) {
+	sessionId := mux.Vars(req)["sessionId"]
+
+	session, err := core.SessionGet(sessionId)
+	if err == storage.NotFoundError {
+		rw.WriteHeader(http.StatusNotFound)
+		return nil, false
+	} else if err != nil {
+		rw.WriteHeader(http.StatusInternalServerError)
+		return nil, false
+	}
+	if !canAccessSession(req, session) {
+		rw.WriteHeader(http.StatusNotFound)
+		return nil, false
+	}
+	return session, true
+}
+
+func parseExtension(duration string) (time.Duration, error) {
+	if duration == "" {
+		return defaultSessionExtension, nil
+	}
+	d, err := time.ParseDuration(duration)
+	if err != nil {
+		return 0, err
+	}
+	if d <= 0 {
+		return 0, fmt.Errorf("duration must be positive, got %s", d)
+	}
+	return d, nil
+}
+
+func writeLifecycleError(rw http.ResponseWriter, err error) {
+	if pwd.ExtensionDenied(err) {
+		rw.Header().Set("Content-Type", "application/json")
+		rw.WriteHeader(http.StatusForbidden)
+		json.NewEncoder(rw).Encode(map[string]string{"error": "extension_denied", "reason": err.Error()})
+		return
+	}
+	if provisioner.PauseNotSupported(err) {
+		rw.WriteHeader(http.StatusNotImplemented)
+		fmt.Fprintln(rw, `{"error": "pause_not_supported"}`)
+		return
+	}
+	log.Println(err)
+	rw.WriteHeader(http.StatusInternalServerError)
+}
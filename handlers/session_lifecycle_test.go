@@ -0,0 +1,41 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/ringo380/lessoncraft/pwd"
+	"github.com/ringo380/lessoncraft/pwd/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPauseSession_access(t *testing.T) {
+	_p := &pwd.Mock{}
+	core = _p
+	defer SetSessionAccess(nil)
+	SetSessionAccess(func(req *http.Request, s *types.Session) bool {
+		return req.Header.Get("X-User") == s.UserId
+	})
+
+	session := &types.Session{Id: "aaaabbbbcccc", UserId: "owner"}
+	_p.On("SessionGet", session.Id).Return(session, nil)
+	_p.On("SessionPause", session).Return(nil)
+
+	r := mux.NewRouter()
+	r.HandleFunc("/sessions/{sessionId}/pause", PauseSession).Methods("POST")
+	pause := func(user string) int {
+		req := httptest.NewRequest("POST", "/sessions/aaaabbbbcccc/pause", nil)
+		req.Header.Set("X-User", user)
+		rw := httptest.NewRecorder()
+		r.ServeHTTP(rw, req)
+		return rw.Code
+	}
+
+	assert.Equal(t, http.StatusNotFound, pause("intruder"))
+	_p.AssertNotCalled(t, "SessionPause", session)
+
+	assert.Equal(t, http.StatusOK, pause("owner"))
+	_p.AssertCalled(t, "SessionPause", session)
+}
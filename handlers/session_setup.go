@@ -22,6 +22,10 @@ func SessionSetup(rw http.ResponseWriter, req *http.Request) {
 		rw.WriteHeader(http.StatusInternalServerError)
 		return
 	}
+	if !canAccessSession(req, s) {
+		rw.WriteHeader(http.StatusNotFound)
+		return
+	}
 
 	playground := core.PlaygroundGet(s.PlaygroundId)
 	if playground == nil {
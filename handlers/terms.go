@@ -69,6 +69,7 @@ type manager struct {
 }
 
 func (m *manager) Send(name string, data []byte) {
+	core.SessionTouch(m.session.Id)
 	m.sendCh <- info{name: name, data: data}
 }
 func (m *manager) Receive(cb func(name string, data []byte)) {
@@ -19,7 +19,7 @@ func GetUser(rw http.ResponseWriter, req *http.Request) {
 	vars := mux.Vars(req)
 	userId := vars["userId"]
 
-	u, err := core.UserGet(userId)
+	u, err := lookupUser(userId)
 	if err != nil {
 		if storage.NotFound(err) {
 			log.Printf("User with id %s was not found\n", userId)
@@ -150,6 +150,10 @@ func ws(so *socket) {
 		log.Printf("Session with id [%s] does not exist!\n", sessionId)
 		return
 	}
+	if !canAccessSession(so.Request(), session) {
+		log.Printf("Session with id [%s] is not visible to the client\n", sessionId)
+		return
+	}
 
 	client := core.ClientNew(so.Id(), session)
 	if client == nil {
@@ -180,6 +184,32 @@ func ws(so *socket) {
 		core.SessionClose(session)
 	})
 
+	so.On("session extend", func(args ...interface{}) {
+		var duration string
+		if len(args) > 0 && args[0] != nil {
+			duration, _ = args[0].(string)
+		}
+		d, err := parseExtension(duration)
+		if err == nil {
+			err = core.SessionExtend(session, d)
+		}
+		if err != nil {
+			so.Emit("session extend error", err.Error())
+		}
+	})
+
+	so.On("session pause", func(args ...interface{}) {
+		if err := core.SessionPause(session); err != nil {
+			so.Emit("session pause error", err.Error())
+		}
+	})
+
+	so.On("session resume", func(args ...interface{}) {
+		if err := core.SessionResume(session); err != nil {
+			so.Emit("session resume error", err.Error())
+		}
+	})
+
 	so.On("instance terminal in", func(args ...interface{}) {
 		if len(args) == 2 && args[0] != nil && args[1] != nil {
 			name := args[0].(string)
@@ -396,8 +396,20 @@
       var port = prompt('What port would you like to open?');
       if (!port) return;
 
-      var url = $scope.getProxyUrl(instance, port);
-      window.open(url, '_blank');
+      $scope.openProxyUrl(instance, port);
+    }
+
+    $scope.openProxyUrl = function(instance, port) {
+      // The window is opened right away so it is not blocked as a popup
+      var w = window.open('', '_blank');
+      $http({
+        method: 'POST',
+        url: '/sessions/' + $scope.sessionId + '/instances/' + instance.name + '/ports/' + port + '/access',
+      }).then(function(response) {
+        w.location = response.data.url;
+      }, function(response) {
+        w.location = $scope.getProxyUrl(instance, port);
+      });
     }
 
     $scope.getProxyUrl = function(instance, port) {
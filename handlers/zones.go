@@ -0,0 +1,37 @@
+package handlers
+
+import (
+	"log"
+
+	"github.com/ringo380/lessoncraft/config"
+	"github.com/ringo380/lessoncraft/event"
+	"github.com/ringo380/lessoncraft/router"
+)
+
+// pushZones sends the DNS zone of a session to the l2 router whenever its
+// instances change, so they can find each other by hostname and role
+func pushZones() {
+	// Zones are pushed one at a time and read when pushed, so the router
+	// always ends up with the latest zone of a session
+	changed := make(chan string, 100)
+	go func() {
+		for sessionId := range changed {
+			zone := router.Zone{SessionId: sessionId}
+			if s, err := core.SessionGet(sessionId); err == nil && s != nil {
+				if zone, err = core.SessionZone(s); err != nil {
+					log.Printf("Couldn't get the zone of session [%s]: %v\n", sessionId, err)
+					continue
+				}
+			}
+			if err := router.PushZone(config.L2ZonesURL, []byte(config.L2AccessKey), zone); err != nil {
+				log.Printf("Couldn't push the zone of session [%s]: %v\n", sessionId, err)
+			}
+		}
+	}()
+
+	for _, ev := range []event.EventType{event.INSTANCE_NEW, event.INSTANCE_DELETE, event.SESSION_RESUMED, event.SESSION_END} {
+		e.On(ev, func(sessionId string, args ...interface{}) {
+			changed <- sessionId
+		})
+	}
+}
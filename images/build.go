@@ -0,0 +1,89 @@
+package images
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/ringo380/lessoncraft/lesson"
+)
+
+// BuildRequest describes an image to build
+type BuildRequest struct {
+	// Reference is the name the image is tagged with
+	Reference string
+	// Context is a tar archive with a Dockerfile at its root
+	Context []byte
+	Args    map[string]string
+	Labels  map[string]string
+}
+
+// BuildResult describes a built image
+type BuildResult struct {
+	Digest string
+	Size   int64
+	Hosts  []string
+}
+
+// Builder builds images on the docker hosts instances run on, so every host
+// can start instances from them
+type Builder interface {
+	// Build builds and tags an image, writing the build output to log
+	Build(ctx context.Context, req BuildRequest, log io.Writer) (BuildResult, error)
+	// Remove deletes an image from the docker hosts
+	Remove(reference string) error
+}
+
+// Scanner is a hook that checks built images for vulnerabilities. Scanners
+// that report asynchronously return ScanPending and report the result later
+// through Catalog.UpdateScan.
+type Scanner interface {
+	Scan(ctx context.Context, image *Image) (Scan, error)
+}
+
+// BuildContext creates the tar archive of the build context of a lesson image
+func BuildContext(build *lesson.ImageBuild) ([]byte, error) {
+	if strings.TrimSpace(build.Dockerfile) == "" {
+		return nil, fmt.Errorf("the build has no Dockerfile")
+	}
+
+	files := map[string]string{}
+	for name, content := range build.Files {
+		clean := path.Clean(name)
+		if path.IsAbs(clean) || clean == "." || clean == ".." || strings.HasPrefix(clean, "../") {
+			return nil, fmt.Errorf("file %q is outside the build context", name)
+		}
+		if clean == "Dockerfile" {
+			return nil, fmt.Errorf("the Dockerfile cannot be given as a file")
+		}
+		files[clean] = content
+	}
+	files["Dockerfile"] = build.Dockerfile
+
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, name := range names {
+		content := files[name]
+		if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(content))}); err != nil {
+			return nil, err
+		}
+		if _, err := tw.Write([]byte(content)); err != nil {
+			return nil, err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
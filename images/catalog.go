@@ -0,0 +1,319 @@
+package images
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/ringo380/lessoncraft/lesson"
+)
+
+var (
+	// ErrNoBuild is returned when building the image of a lesson without a build
+	ErrNoBuild = errors.New("lesson has no image build")
+	// ErrInvalidBuild is returned when the build context of a lesson cannot be created
+	ErrInvalidBuild = errors.New("invalid image build")
+	// ErrBuildInProgress is returned when the image is being built
+	ErrBuildInProgress = errors.New("image is being built")
+	// ErrInvalidScan is returned when a scan hook reports an unknown status
+	ErrInvalidScan = errors.New("invalid scan status")
+)
+
+// DefaultRepository is the image repository lesson images are tagged in
+const DefaultRepository = "lessoncraft/lessons"
+
+// DefaultBuildTimeout is how long a build may run before it is cancelled
+const DefaultBuildTimeout = 30 * time.Minute
+
+const (
+	// LessonLabel is the label built images record their lesson in
+	LessonLabel = "lessoncraft.lesson"
+	// LessonVersionLabel is the label built images record their lesson version in
+	LessonVersionLabel = "lessoncraft.lesson.version"
+)
+
+// Catalog builds the images of lessons and keeps track of them
+type Catalog struct {
+	store        Store
+	builder      Builder
+	scanner      Scanner
+	repository   string
+	buildTimeout time.Duration
+	now          func() time.Time
+
+	// mu serializes changes to the catalog, so a lesson version is not built twice
+	mu     sync.Mutex
+	builds sync.WaitGroup
+}
+
+// NewCatalog creates a new image catalog building images with a builder
+func NewCatalog(store Store, builder Builder) *Catalog {
+	return &Catalog{
+		store:        store,
+		builder:      builder,
+		repository:   DefaultRepository,
+		buildTimeout: DefaultBuildTimeout,
+		now:          time.Now,
+	}
+}
+
+// SetRepository sets the image repository lesson images are tagged in
+func (c *Catalog) SetRepository(repository string) {
+	c.repository = repository
+}
+
+// SetScanner sets the hook built images are scanned with. Without one, scans
+// are skipped.
+func (c *Catalog) SetScanner(scanner Scanner) {
+	c.scanner = scanner
+}
+
+var invalidReferenceChars = regexp.MustCompile(`[^a-z0-9_.-]+`)
+
+// Reference returns the reference the image of a lesson version is tagged with
+func (c *Catalog) Reference(lessonID string, version int) string {
+	name := invalidReferenceChars.ReplaceAllString(strings.ToLower(lessonID), "-")
+	return fmt.Sprintf("%s/%s:v%d", c.repository, strings.Trim(name, "-._"), version)
+}
+
+// Build starts building the image of the current version of a lesson. The
+// image is returned while it is being built, its status tells when it is done.
+// Rebuilding a version replaces its image once the new one is ready.
+func (c *Catalog) Build(userID string, l *lesson.Lesson) (*Image, error) {
+	if l.Build == nil {
+		return nil, ErrNoBuild
+	}
+	buildContext, err := BuildContext(l.Build)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidBuild, err)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	reference := c.Reference(l.ID, l.Version)
+	existing, err := c.findByReference(reference)
+	if err != nil {
+		return nil, err
+	}
+	for _, i := range existing {
+		if i.Status == StatusBuilding {
+			return nil, ErrBuildInProgress
+		}
+	}
+
+	image := &Image{
+		ID:            uuid.New().String(),
+		Reference:     reference,
+		LessonID:      l.ID,
+		LessonVersion: l.Version,
+		Status:        StatusBuilding,
+		Scan:          Scan{Status: ScanPending},
+		CreatedBy:     userID,
+		CreatedAt:     c.now(),
+	}
+	if err := c.store.CreateImage(image); err != nil {
+		return nil, err
+	}
+
+	req := BuildRequest{
+		Reference: reference,
+		Context:   buildContext,
+		Args:      l.Build.Args,
+		Labels: map[string]string{
+			LessonLabel:        l.ID,
+			LessonVersionLabel: strconv.Itoa(l.Version),
+		},
+	}
+	c.builds.Add(1)
+	go c.run(image.copy(), req)
+
+	return image, nil
+}
+
+func (c *Catalog) run(image *Image, req BuildRequest) {
+	defer c.builds.Done()
+
+	ctx, cancel := context.WithTimeout(context.Background(), c.buildTimeout)
+	defer cancel()
+
+	var output bytes.Buffer
+	result, err := c.builder.Build(ctx, req, &output)
+	image.BuildLog = output.String()
+	if err != nil {
+		log.Printf("Could not build image %s: %v\n", image.Reference, err)
+		image.Status = StatusFailed
+		image.Error = err.Error()
+		if err := c.store.UpdateImage(image); err != nil {
+			log.Printf("Could not update image %s: %v\n", image.ID, err)
+		}
+		return
+	}
+
+	image.Status = StatusReady
+	image.Digest = result.Digest
+	image.Size = result.Size
+	image.Hosts = result.Hosts
+	image.BuiltAt = c.now()
+	image.Scan = c.scan(ctx, image)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	// A scan reported through the hook while building is kept
+	if stored, err := c.store.GetImage(image.ID); err == nil && stored.Scan.Status != ScanPending {
+		image.Scan = stored.Scan
+	}
+	if err := c.store.UpdateImage(image); err != nil {
+		log.Printf("Could not update image %s: %v\n", image.ID, err)
+		return
+	}
+	log.Printf("Image %s built on %d hosts\n", image.Reference, len(image.Hosts))
+
+	// The tag now points to the new image, older builds of the version are gone
+	previous, err := c.findByReference(image.Reference)
+	if err != nil {
+		log.Printf("Could not find previous builds of %s: %v\n", image.Reference, err)
+		return
+	}
+	for _, p := range previous {
+		if p.ID != image.ID && p.Status != StatusBuilding {
+			if err := c.store.DeleteImage(p.ID); err != nil {
+				log.Printf("Could not remove previous build %s of %s: %v\n", p.ID, image.Reference, err)
+			}
+		}
+	}
+}
+
+func (c *Catalog) scan(ctx context.Context, image *Image) Scan {
+	if c.scanner == nil {
+		return Scan{Status: ScanSkipped}
+	}
+	scan, err := c.scanner.Scan(ctx, image)
+	if err != nil {
+		log.Printf("Could not scan image %s: %v\n", image.Reference, err)
+		return Scan{Status: ScanPending, Report: err.Error()}
+	}
+	if scan.Status != ScanPending && scan.ScannedAt.IsZero() {
+		scan.ScannedAt = c.now()
+	}
+	return scan
+}
+
+// wait blocks until the builds that were started are done
+func (c *Catalog) wait() {
+	c.builds.Wait()
+}
+
+// UpdateScan records the result of a scan, e.g. reported by a scanner webhook
+func (c *Catalog) UpdateScan(id string, scan Scan) (*Image, error) {
+	switch scan.Status {
+	case ScanPending, ScanPassed, ScanFailed, ScanSkipped:
+	default:
+		return nil, ErrInvalidScan
+	}
+	if scan.Status != ScanPending && scan.ScannedAt.IsZero() {
+		scan.ScannedAt = c.now()
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	image, err := c.store.GetImage(id)
+	if err != nil {
+		return nil, err
+	}
+	image.Scan = scan
+	if err := c.store.UpdateImage(image); err != nil {
+		return nil, err
+	}
+	return image, nil
+}
+
+// Get returns an image of the catalog
+func (c *Catalog) Get(id string) (*Image, error) {
+	return c.store.GetImage(id)
+}
+
+// List returns the images of the catalog, or of a lesson when lessonID is set
+func (c *Catalog) List(lessonID string) ([]*Image, error) {
+	all, err := c.store.ListImages()
+	if err != nil {
+		return nil, err
+	}
+	if lessonID == "" {
+		return all, nil
+	}
+	result := []*Image{}
+	for _, i := range all {
+		if i.LessonID == lessonID {
+			result = append(result, i)
+		}
+	}
+	return result, nil
+}
+
+// Delete removes an image from the catalog and from the docker hosts
+func (c *Catalog) Delete(id string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	image, err := c.store.GetImage(id)
+	if err != nil {
+		return err
+	}
+	if image.Status == StatusBuilding {
+		return ErrBuildInProgress
+	}
+	if image.Status == StatusReady {
+		if err := c.builder.Remove(image.Reference); err != nil {
+			return err
+		}
+	}
+	return c.store.DeleteImage(id)
+}
+
+// InstanceImages returns the images instances can be created from: the
+// default images followed by the usable images of the catalog
+func (c *Catalog) InstanceImages(defaults []string) ([]string, error) {
+	all, err := c.store.ListImages()
+	if err != nil {
+		return nil, err
+	}
+	seen := map[string]bool{}
+	result := []string{}
+	for _, reference := range defaults {
+		if !seen[reference] {
+			seen[reference] = true
+			result = append(result, reference)
+		}
+	}
+	for _, i := range all {
+		if i.Usable() && !seen[i.Reference] {
+			seen[i.Reference] = true
+			result = append(result, i.Reference)
+		}
+	}
+	return result, nil
+}
+
+func (c *Catalog) findByReference(reference string) ([]*Image, error) {
+	all, err := c.store.ListImages()
+	if err != nil {
+		return nil, err
+	}
+	result := []*Image{}
+	for _, i := range all {
+		if i.Reference == reference {
+			result = append(result, i)
+		}
+	}
+	return result, nil
+}
@@ -0,0 +1,183 @@
+package images
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"testing"
+
+	"github.com/ringo380/lessoncraft/lesson"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeBuilder struct {
+	mu       sync.Mutex
+	err      error
+	requests []BuildRequest
+	removed  []string
+}
+
+func (b *fakeBuilder) Build(ctx context.Context, req BuildRequest, log io.Writer) (BuildResult, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.requests = append(b.requests, req)
+	fmt.Fprintf(log, "Step 1/1 : building %s\n", req.Reference)
+	if b.err != nil {
+		return BuildResult{}, b.err
+	}
+	return BuildResult{Digest: "sha256:abc", Size: 42, Hosts: []string{"host1", "host2"}}, nil
+}
+
+func (b *fakeBuilder) Remove(reference string) error {
+	b.removed = append(b.removed, reference)
+	return nil
+}
+
+type fakeScanner struct {
+	status ScanStatus
+}
+
+func (s fakeScanner) Scan(ctx context.Context, image *Image) (Scan, error) {
+	return Scan{Status: s.status, Scanner: "fake"}, nil
+}
+
+func testLesson() *lesson.Lesson {
+	return &lesson.Lesson{
+		ID:      "Docker-Basics",
+		Version: 3,
+		Build: &lesson.ImageBuild{
+			Dockerfile: "FROM alpine\nCOPY motd /etc/motd\n",
+			Files:      map[string]string{"motd": "hello"},
+			Args:       map[string]string{"VERSION": "1"},
+		},
+	}
+}
+
+func TestBuildContext(t *testing.T) {
+	data, err := BuildContext(testLesson().Build)
+	require.NoError(t, err)
+
+	files := map[string]string{}
+	tr := tar.NewReader(bytes.NewReader(data))
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		content, _ := io.ReadAll(tr)
+		files[h.Name] = string(content)
+	}
+	assert.Equal(t, map[string]string{"Dockerfile": "FROM alpine\nCOPY motd /etc/motd\n", "motd": "hello"}, files)
+
+	_, err = BuildContext(&lesson.ImageBuild{})
+	assert.Error(t, err)
+	_, err = BuildContext(&lesson.ImageBuild{Dockerfile: "FROM alpine", Files: map[string]string{"../etc/passwd": "x"}})
+	assert.Error(t, err)
+	_, err = BuildContext(&lesson.ImageBuild{Dockerfile: "FROM alpine", Files: map[string]string{"/etc/passwd": "x"}})
+	assert.Error(t, err)
+}
+
+func TestCatalog_Build(t *testing.T) {
+	builder := &fakeBuilder{}
+	c := NewCatalog(NewMemoryStore(), builder)
+	c.SetScanner(fakeScanner{status: ScanPassed})
+
+	image, err := c.Build("educator1", testLesson())
+	require.NoError(t, err)
+	assert.Equal(t, StatusBuilding, image.Status)
+	assert.Equal(t, "lessoncraft/lessons/docker-basics:v3", image.Reference)
+	c.wait()
+
+	image, err = c.Get(image.ID)
+	require.NoError(t, err)
+	assert.Equal(t, StatusReady, image.Status)
+	assert.Equal(t, "sha256:abc", image.Digest)
+	assert.Equal(t, int64(42), image.Size)
+	assert.Equal(t, []string{"host1", "host2"}, image.Hosts)
+	assert.Equal(t, ScanPassed, image.Scan.Status)
+	assert.False(t, image.Scan.ScannedAt.IsZero())
+	assert.Contains(t, image.BuildLog, "Step 1/1")
+
+	require.Len(t, builder.requests, 1)
+	assert.Equal(t, map[string]string{LessonLabel: "Docker-Basics", LessonVersionLabel: "3"}, builder.requests[0].Labels)
+	assert.Equal(t, map[string]string{"VERSION": "1"}, builder.requests[0].Args)
+
+	// Rebuilding the version replaces its image
+	rebuilt, err := c.Build("educator1", testLesson())
+	require.NoError(t, err)
+	c.wait()
+	all, err := c.List("Docker-Basics")
+	require.NoError(t, err)
+	require.Len(t, all, 1)
+	assert.Equal(t, rebuilt.ID, all[0].ID)
+}
+
+func TestCatalog_BuildErrors(t *testing.T) {
+	builder := &fakeBuilder{err: errors.New("step 2 failed")}
+	c := NewCatalog(NewMemoryStore(), builder)
+
+	_, err := c.Build("educator1", &lesson.Lesson{ID: "l1"})
+	assert.Equal(t, ErrNoBuild, err)
+	_, err = c.Build("educator1", &lesson.Lesson{ID: "l1", Build: &lesson.ImageBuild{}})
+	assert.True(t, errors.Is(err, ErrInvalidBuild))
+
+	image, err := c.Build("educator1", testLesson())
+	require.NoError(t, err)
+	c.wait()
+	image, err = c.Get(image.ID)
+	require.NoError(t, err)
+	assert.Equal(t, StatusFailed, image.Status)
+	assert.Equal(t, "step 2 failed", image.Error)
+	assert.Equal(t, ScanPending, image.Scan.Status)
+
+	// Failed builds are not offered and have nothing to remove
+	images, err := c.InstanceImages([]string{"franela/dind"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"franela/dind"}, images)
+	require.NoError(t, c.Delete(image.ID))
+	assert.Empty(t, builder.removed)
+}
+
+func TestCatalog_InstanceImages(t *testing.T) {
+	builder := &fakeBuilder{}
+	c := NewCatalog(NewMemoryStore(), builder)
+
+	l1 := testLesson()
+	l2 := testLesson()
+	l2.ID = "networking"
+	i1, err := c.Build("educator1", l1)
+	require.NoError(t, err)
+	_, err = c.Build("educator1", l2)
+	require.NoError(t, err)
+	c.wait()
+
+	images, err := c.InstanceImages([]string{"franela/dind"})
+	require.NoError(t, err)
+	sort.Strings(images[1:])
+	assert.Equal(t, []string{"franela/dind", "lessoncraft/lessons/docker-basics:v3", "lessoncraft/lessons/networking:v3"}, images)
+	stored, err := c.Get(i1.ID)
+	require.NoError(t, err)
+	assert.Equal(t, ScanSkipped, stored.Scan.Status)
+
+	// Images failing their scan are not offered
+	_, err = c.UpdateScan(i1.ID, Scan{Status: ScanFailed, Scanner: "trivy", Report: "CVE-2024-0001"})
+	require.NoError(t, err)
+	images, err = c.InstanceImages(nil)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"lessoncraft/lessons/networking:v3"}, images)
+
+	_, err = c.UpdateScan(i1.ID, Scan{Status: "unknown"})
+	assert.Equal(t, ErrInvalidScan, err)
+
+	require.NoError(t, c.Delete(i1.ID))
+	assert.Equal(t, []string{"lessoncraft/lessons/docker-basics:v3"}, builder.removed)
+	_, err = c.Get(i1.ID)
+	assert.Equal(t, ErrNotFound, err)
+}
@@ -0,0 +1,74 @@
+package images
+
+import (
+	"time"
+)
+
+// Status is the build status of a catalog image
+type Status string
+
+const (
+	// StatusBuilding images are being built on the docker hosts
+	StatusBuilding Status = "building"
+	// StatusReady images were built and can be used by instances
+	StatusReady Status = "ready"
+	// StatusFailed images could not be built, the build log tells why
+	StatusFailed Status = "failed"
+)
+
+// ScanStatus is the status of the vulnerability scan of an image
+type ScanStatus string
+
+const (
+	// ScanPending images wait for the scanner to report
+	ScanPending ScanStatus = "pending"
+	// ScanPassed images have no vulnerabilities the scanner objects to
+	ScanPassed ScanStatus = "passed"
+	// ScanFailed images are not offered to instances
+	ScanFailed ScanStatus = "failed"
+	// ScanSkipped images were not scanned because no scanner is configured
+	ScanSkipped ScanStatus = "skipped"
+)
+
+// Scan is the result of the vulnerability scan of an image
+type Scan struct {
+	Status ScanStatus `json:"status"`
+	// Scanner names what scanned the image
+	Scanner string `json:"scanner,omitempty"`
+	// Report is a summary or a link to the full report
+	Report    string    `json:"report,omitempty"`
+	ScannedAt time.Time `json:"scanned_at,omitempty"`
+}
+
+// Image is an image of the catalog, built for a version of a lesson
+type Image struct {
+	ID            string `json:"id"`
+	Reference     string `json:"reference"`
+	LessonID      string `json:"lesson_id"`
+	LessonVersion int    `json:"lesson_version"`
+	Status        Status `json:"status"`
+	Error         string `json:"error,omitempty"`
+	// Digest is the content addressable ID of the image
+	Digest string `json:"digest,omitempty"`
+	Size   int64  `json:"size,omitempty"`
+	// Hosts are the docker hosts the image was built on
+	Hosts     []string  `json:"hosts,omitempty"`
+	Scan      Scan      `json:"scan"`
+	CreatedBy string    `json:"created_by,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	BuiltAt   time.Time `json:"built_at,omitempty"`
+	// BuildLog is the output of the build. It is served on its own as it can
+	// be large.
+	BuildLog string `json:"-"`
+}
+
+// Usable checks if instances can be created from the image
+func (i *Image) Usable() bool {
+	return i.Status == StatusReady && i.Scan.Status != ScanFailed
+}
+
+func (i *Image) copy() *Image {
+	c := *i
+	c.Hosts = append([]string(nil), i.Hosts...)
+	return &c
+}
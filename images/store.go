@@ -0,0 +1,102 @@
+package images
+
+import (
+	"errors"
+	"sort"
+	"sync"
+)
+
+var (
+	// ErrNotFound is returned when an image is not in the catalog
+	ErrNotFound = errors.New("image not found")
+	// ErrAlreadyExists is returned when adding an image with an ID that is already in use
+	ErrAlreadyExists = errors.New("image already exists")
+)
+
+// Store defines the interface for catalog storage operations
+type Store interface {
+	// CreateImage adds an image to the catalog
+	CreateImage(i *Image) error
+	// GetImage retrieves an image by ID
+	GetImage(id string) (*Image, error)
+	// UpdateImage replaces an image of the catalog
+	UpdateImage(i *Image) error
+	// DeleteImage removes an image from the catalog
+	DeleteImage(id string) error
+	// ListImages retrieves all images, oldest first
+	ListImages() ([]*Image, error)
+}
+
+// MemoryStore is an in-memory implementation of the Store interface
+type MemoryStore struct {
+	images map[string]*Image
+	mu     sync.RWMutex
+}
+
+// NewMemoryStore creates a new in-memory catalog store
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{images: make(map[string]*Image)}
+}
+
+// CreateImage adds an image to the catalog
+func (s *MemoryStore) CreateImage(i *Image) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.images[i.ID]; ok {
+		return ErrAlreadyExists
+	}
+	s.images[i.ID] = i.copy()
+	return nil
+}
+
+// GetImage retrieves an image by ID
+func (s *MemoryStore) GetImage(id string) (*Image, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	i, ok := s.images[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return i.copy(), nil
+}
+
+// UpdateImage replaces an image of the catalog
+func (s *MemoryStore) UpdateImage(i *Image) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.images[i.ID]; !ok {
+		return ErrNotFound
+	}
+	s.images[i.ID] = i.copy()
+	return nil
+}
+
+// DeleteImage removes an image from the catalog
+func (s *MemoryStore) DeleteImage(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.images[id]; !ok {
+		return ErrNotFound
+	}
+	delete(s.images, id)
+	return nil
+}
+
+// ListImages retrieves all images, oldest first
+func (s *MemoryStore) ListImages() ([]*Image, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	result := make([]*Image, 0, len(s.images))
+	for _, i := range s.images {
+		result = append(result, i.copy())
+	}
+	sort.Slice(result, func(a, b int) bool {
+		return result[a].CreatedAt.Before(result[b].CreatedAt)
+	})
+	return result, nil
+}
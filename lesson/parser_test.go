@@ -209,3 +209,50 @@ func TestParse_TimeoutSetting(t *testing.T) {
 	// Check if timeout is set to the default value (5 minutes)
 	assert.Equal(t, 5*time.Minute, lesson.Steps[0].Timeout)
 }
+
+func TestParse_SetupBlocks(t *testing.T) {
+	parser := NewParser()
+	markdown := "# Setup Lesson\nSteps with a prepared environment.\n\n" +
+		"```docker\ndocker ps\n```\n\n" +
+		"```setup-file /root/app/app.py\nprint('hello')\n```\n\n" +
+		"```setup retries=3 delay=2s\npip install flask\npython /root/app/app.py &\n```\n\n" +
+		"```ready timeout=30s\nport 5000\nhttp http://localhost:5000/health\nfile /tmp/ready\n```\n\n" +
+		"```docker\ncurl localhost:5000\n```\n"
+	lesson, err := parser.Parse(strings.NewReader(markdown))
+
+	assert.NoError(t, err)
+	assert.Len(t, lesson.Steps, 2)
+	assert.Nil(t, lesson.Steps[0].Setup)
+
+	setup := lesson.Steps[1].Setup
+	assert.NotNil(t, setup)
+	assert.Equal(t, []SetupFile{{Path: "/root/app/app.py", Content: "print('hello')\n"}}, setup.Files)
+	assert.Equal(t, []SetupCommand{
+		{Command: "pip install flask", Retries: 3, RetryDelay: 2 * time.Second},
+		{Command: "python /root/app/app.py &", Retries: 3, RetryDelay: 2 * time.Second},
+	}, setup.Run)
+	assert.Equal(t, []ReadinessProbe{
+		{Type: ProbePort, Port: 5000, Timeout: 30 * time.Second},
+		{Type: ProbeHTTP, URL: "http://localhost:5000/health", Timeout: 30 * time.Second},
+		{Type: ProbeFile, Path: "/tmp/ready", Timeout: 30 * time.Second},
+	}, setup.Ready)
+	assert.NoError(t, setup.Validate())
+}
+
+func TestParse_InvalidSetupBlocks(t *testing.T) {
+	parser := NewParser()
+	for _, markdown := range []string{
+		"# Lesson\nDescription.\n\n```setup-file\ncontent\n```\n\n```docker\nls\n```\n",
+		"# Lesson\nDescription.\n\n```setup retries=many\nls\n```\n\n```docker\nls\n```\n",
+		"# Lesson\nDescription.\n\n```ready\nsocket /tmp/s\n```\n\n```docker\nls\n```\n",
+		"# Lesson\nDescription.\n\n```docker\nls\n```\n\n```setup\nls\n```\n",
+	} {
+		_, err := parser.Parse(strings.NewReader(markdown))
+		assert.Error(t, err, markdown)
+	}
+
+	// Other languages starting with a block type are not setup blocks
+	lesson, err := parser.Parse(strings.NewReader("# Lesson\nDescription.\n\n```dockerfile\nFROM alpine\n```\n\n```setupx\nls\n```\n"))
+	assert.NoError(t, err)
+	assert.Empty(t, lesson.Steps)
+}
@@ -0,0 +1,258 @@
+package lesson
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultSetupRetryDelay is how long a failed setup command waits before it is retried
+	DefaultSetupRetryDelay = time.Second
+	// DefaultProbeTimeout is how long a readiness probe waits for its condition
+	DefaultProbeTimeout = time.Minute
+	// DefaultProbeInterval is how often a readiness probe checks its condition
+	DefaultProbeInterval = time.Second
+
+	maxSetupRetries = 10
+	maxProbeTimeout = 10 * time.Minute
+)
+
+// StepSetup prepares the environment of a step before it is shown to the user.
+// Files are copied first, then commands run in order, then the readiness probes
+// are waited for.
+type StepSetup struct {
+	// Files are copied into the environment
+	Files []SetupFile `json:"files,omitempty" bson:"files,omitempty"`
+
+	// Run is a list of shell commands run in the environment
+	Run []SetupCommand `json:"run,omitempty" bson:"run,omitempty"`
+
+	// Ready is a list of conditions the environment must meet before the step is shown
+	Ready []ReadinessProbe `json:"ready,omitempty" bson:"ready,omitempty"`
+}
+
+// SetupFile is a file copied into the environment of a step
+type SetupFile struct {
+	// Path is the absolute path of the file in the environment
+	Path string `json:"path" bson:"path"`
+
+	// Content is the content of the file
+	Content string `json:"content" bson:"content"`
+}
+
+// SetupCommand is a shell command run in the environment of a step
+type SetupCommand struct {
+	// Command is run with sh -c
+	Command string `json:"command" bson:"command"`
+
+	// Retries is how many more times the command is run if it fails
+	Retries int `json:"retries,omitempty" bson:"retries,omitempty"`
+
+	// RetryDelay is how long to wait between attempts, DefaultSetupRetryDelay if not set
+	RetryDelay time.Duration `json:"retry_delay,omitempty" bson:"retry_delay,omitempty"`
+}
+
+// ProbeType identifies the condition a readiness probe checks
+type ProbeType string
+
+const (
+	// ProbePort waits for a TCP port to accept connections
+	ProbePort ProbeType = "port"
+	// ProbeHTTP waits for a URL to respond successfully
+	ProbeHTTP ProbeType = "http"
+	// ProbeFile waits for a file to exist
+	ProbeFile ProbeType = "file"
+)
+
+// ReadinessProbe is a condition the environment of a step must meet before the
+// step is shown
+type ReadinessProbe struct {
+	// Type is the condition checked
+	Type ProbeType `json:"type" bson:"type"`
+
+	// Port is the port checked by port probes
+	Port int `json:"port,omitempty" bson:"port,omitempty"`
+
+	// URL is requested by http probes
+	URL string `json:"url,omitempty" bson:"url,omitempty"`
+
+	// Path is checked by file probes
+	Path string `json:"path,omitempty" bson:"path,omitempty"`
+
+	// Timeout is how long to wait for the condition, DefaultProbeTimeout if not set
+	Timeout time.Duration `json:"timeout,omitempty" bson:"timeout,omitempty"`
+
+	// Interval is how often the condition is checked, DefaultProbeInterval if not set
+	Interval time.Duration `json:"interval,omitempty" bson:"interval,omitempty"`
+}
+
+// String describes the probe for progress output
+func (p ReadinessProbe) String() string {
+	switch p.Type {
+	case ProbePort:
+		return fmt.Sprintf("port %d", p.Port)
+	case ProbeHTTP:
+		return fmt.Sprintf("http %s", p.URL)
+	case ProbeFile:
+		return fmt.Sprintf("file %s", p.Path)
+	}
+	return string(p.Type)
+}
+
+// Command returns the command that exits with 0 once the condition is met
+func (p ReadinessProbe) Command() []string {
+	switch p.Type {
+	case ProbePort:
+		return []string{"sh", "-c", fmt.Sprintf("nc -z 127.0.0.1 %d", p.Port)}
+	case ProbeHTTP:
+		u := shellQuote(p.URL)
+		return []string{"sh", "-c", fmt.Sprintf("if command -v curl >/dev/null; then curl -fsS -o /dev/null --max-time 5 %s; else wget -q -O /dev/null -T 5 %s; fi", u, u)}
+	case ProbeFile:
+		return []string{"test", "-e", p.Path}
+	}
+	return nil
+}
+
+// shellQuote quotes s as a single shell word
+func shellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
+
+// Validate checks that the setup can be run
+func (s *StepSetup) Validate() error {
+	for i, f := range s.Files {
+		if !path.IsAbs(f.Path) || strings.HasSuffix(f.Path, "/") {
+			return fmt.Errorf("file %d must have an absolute file path", i+1)
+		}
+	}
+	for i, c := range s.Run {
+		if strings.TrimSpace(c.Command) == "" {
+			return fmt.Errorf("command %d is empty", i+1)
+		}
+		if c.Retries < 0 || c.Retries > maxSetupRetries {
+			return fmt.Errorf("command %d retries must be between 0 and %d", i+1, maxSetupRetries)
+		}
+		if c.RetryDelay < 0 || c.RetryDelay > time.Minute {
+			return fmt.Errorf("command %d retry delay must be between 0 and 1 minute", i+1)
+		}
+	}
+	for i, p := range s.Ready {
+		switch p.Type {
+		case ProbePort:
+			if p.Port < 1 || p.Port > 65535 {
+				return fmt.Errorf("probe %d port must be between 1 and 65535", i+1)
+			}
+		case ProbeHTTP:
+			u, err := url.Parse(p.URL)
+			if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				return fmt.Errorf("probe %d must have an http or https URL", i+1)
+			}
+		case ProbeFile:
+			if !path.IsAbs(p.Path) {
+				return fmt.Errorf("probe %d must have an absolute file path", i+1)
+			}
+		default:
+			return fmt.Errorf("probe %d has unknown type %q", i+1, p.Type)
+		}
+		if p.Timeout < 0 || p.Timeout > maxProbeTimeout {
+			return fmt.Errorf("probe %d timeout must be between 0 and %s", i+1, maxProbeTimeout)
+		}
+		if p.Interval < 0 {
+			return fmt.Errorf("probe %d interval must not be negative", i+1)
+		}
+	}
+	return nil
+}
+
+// SetupTarget is the environment a step is set up in
+type SetupTarget interface {
+	// Exec runs a command and returns its exit code
+	Exec(cmd []string) (int, error)
+	// Upload copies content to the file fileName in the directory dest
+	Upload(fileName, dest string, content io.Reader) error
+}
+
+// Apply sets up the environment of a step, writing its progress to out. It
+// stops at the first file, command or probe that fails.
+func (s *StepSetup) Apply(ctx context.Context, target SetupTarget, out io.Writer) error {
+	for _, f := range s.Files {
+		fmt.Fprintf(out, "Copying %s\r\n", f.Path)
+		dir, name := path.Split(f.Path)
+		if code, err := target.Exec([]string{"mkdir", "-p", dir}); err != nil || code != 0 {
+			return fmt.Errorf("creating directory %s: %d %v", dir, code, err)
+		}
+		if err := target.Upload(name, dir, strings.NewReader(f.Content)); err != nil {
+			return fmt.Errorf("copying %s: %v", f.Path, err)
+		}
+	}
+
+	for _, c := range s.Run {
+		if err := runSetupCommand(ctx, target, c, out); err != nil {
+			return err
+		}
+	}
+
+	for _, p := range s.Ready {
+		if err := waitReady(ctx, target, p, out); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func runSetupCommand(ctx context.Context, target SetupTarget, c SetupCommand, out io.Writer) error {
+	delay := c.RetryDelay
+	if delay == 0 {
+		delay = DefaultSetupRetryDelay
+	}
+	for attempt := 0; ; attempt++ {
+		fmt.Fprintf(out, "$ %s\r\n", c.Command)
+		code, err := target.Exec([]string{"sh", "-c", c.Command})
+		if err == nil && code == 0 {
+			return nil
+		}
+		if err == nil {
+			err = fmt.Errorf("exit code %d", code)
+		}
+		if attempt >= c.Retries {
+			return fmt.Errorf("running %q: %v", c.Command, err)
+		}
+		fmt.Fprintf(out, "Command failed (%v), retrying in %s\r\n", err, delay)
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func waitReady(ctx context.Context, target SetupTarget, p ReadinessProbe, out io.Writer) error {
+	timeout := p.Timeout
+	if timeout == 0 {
+		timeout = DefaultProbeTimeout
+	}
+	interval := p.Interval
+	if interval == 0 {
+		interval = DefaultProbeInterval
+	}
+
+	fmt.Fprintf(out, "Waiting for %s\r\n", p)
+	deadline := time.After(timeout)
+	for {
+		if code, err := target.Exec(p.Command()); err == nil && code == 0 {
+			return nil
+		}
+		select {
+		case <-time.After(interval):
+		case <-deadline:
+			return fmt.Errorf("%s not ready after %s", p, timeout)
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
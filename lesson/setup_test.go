@@ -0,0 +1,104 @@
+package lesson
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeTarget records the commands and uploads of a setup. Commands fail until
+// they have run the number of times in failures.
+type fakeTarget struct {
+	commands [][]string
+	uploads  map[string]string
+	failures map[string]int
+}
+
+func (f *fakeTarget) Exec(cmd []string) (int, error) {
+	f.commands = append(f.commands, cmd)
+	key := strings.Join(cmd, " ")
+	if f.failures[key] > 0 {
+		f.failures[key]--
+		return 1, nil
+	}
+	return 0, nil
+}
+
+func (f *fakeTarget) Upload(fileName, dest string, content io.Reader) error {
+	b, _ := ioutil.ReadAll(content)
+	f.uploads[dest+fileName] = string(b)
+	return nil
+}
+
+func TestStepSetup_Apply(t *testing.T) {
+	probe := ReadinessProbe{Type: ProbePort, Port: 8080, Interval: time.Millisecond}
+	target := &fakeTarget{
+		uploads: map[string]string{},
+		failures: map[string]int{
+			"sh -c make":                       2,
+			strings.Join(probe.Command(), " "): 3,
+		},
+	}
+	setup := &StepSetup{
+		Files: []SetupFile{{Path: "/root/app/Makefile", Content: "all:\n"}},
+		Run:   []SetupCommand{{Command: "make", Retries: 2, RetryDelay: time.Millisecond}},
+		Ready: []ReadinessProbe{probe},
+	}
+
+	var out bytes.Buffer
+	assert.Nil(t, setup.Apply(context.Background(), target, &out))
+	assert.Equal(t, "all:\n", target.uploads["/root/app/Makefile"])
+	assert.Equal(t, []string{"mkdir", "-p", "/root/app/"}, target.commands[0])
+	// make is run three times, the probe four times
+	assert.Len(t, target.commands, 1+3+4)
+	assert.Contains(t, out.String(), "Copying /root/app/Makefile")
+	assert.Contains(t, out.String(), "$ make")
+	assert.Contains(t, out.String(), "Waiting for port 8080")
+}
+
+func TestStepSetup_ApplyFails(t *testing.T) {
+	target := &fakeTarget{uploads: map[string]string{}, failures: map[string]int{"sh -c make": 2}}
+	setup := &StepSetup{Run: []SetupCommand{{Command: "make", Retries: 1, RetryDelay: time.Millisecond}, {Command: "never"}}}
+	err := setup.Apply(context.Background(), target, ioutil.Discard)
+	assert.EqualError(t, err, `running "make": exit code 1`)
+	assert.Len(t, target.commands, 2)
+
+	target = &fakeTarget{uploads: map[string]string{}, failures: map[string]int{"test -e /tmp/ready": 1000}}
+	setup = &StepSetup{Ready: []ReadinessProbe{{Type: ProbeFile, Path: "/tmp/ready", Timeout: 20 * time.Millisecond, Interval: time.Millisecond}}}
+	err = setup.Apply(context.Background(), target, ioutil.Discard)
+	assert.EqualError(t, err, "file /tmp/ready not ready after 20ms")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	setup.Ready[0].Timeout = time.Minute
+	assert.True(t, errors.Is(setup.Apply(ctx, target, ioutil.Discard), context.Canceled))
+}
+
+func TestStepSetup_Validate(t *testing.T) {
+	assert.Nil(t, (&StepSetup{}).Validate())
+	for _, setup := range []StepSetup{
+		{Files: []SetupFile{{Path: "relative.txt"}}},
+		{Run: []SetupCommand{{Command: " "}}},
+		{Run: []SetupCommand{{Command: "ls", Retries: 100}}},
+		{Ready: []ReadinessProbe{{Type: ProbePort, Port: 70000}}},
+		{Ready: []ReadinessProbe{{Type: ProbeHTTP, URL: "ftp://localhost"}}},
+		{Ready: []ReadinessProbe{{Type: "socket", Path: "/tmp/s"}}},
+		{Ready: []ReadinessProbe{{Type: ProbeFile, Path: "/tmp/ready", Timeout: time.Hour}}},
+	} {
+		assert.NotNil(t, setup.Validate(), "%+v", setup)
+	}
+}
+
+func TestReadinessProbe_Command(t *testing.T) {
+	assert.Equal(t, []string{"sh", "-c", "nc -z 127.0.0.1 80"}, ReadinessProbe{Type: ProbePort, Port: 80}.Command())
+	assert.Equal(t, []string{"test", "-e", "/tmp/ready"}, ReadinessProbe{Type: ProbeFile, Path: "/tmp/ready"}.Command())
+	cmd := ReadinessProbe{Type: ProbeHTTP, URL: "http://localhost/it's"}.Command()
+	assert.Contains(t, cmd[2], `curl -fsS -o /dev/null --max-time 5 'http://localhost/it'\''s'`)
+}
@@ -3,8 +3,10 @@ package lesson
 import (
 	"bufio"
 	"bytes"
+	"fmt"
 	"io"
 	"regexp"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -19,6 +21,7 @@ import (
 // - Docker blocks: Code blocks with the docker language identifier (```docker)
 // - Expect blocks: Code blocks with the expect language identifier (```expect)
 // - Question blocks: Code blocks with the question language identifier (```question)
+// - Setup blocks: Code blocks that prepare the next step (```setup-file <path>, ```setup, ```ready)
 type SimpleParser struct{}
 
 // NewSimpleParser creates a new SimpleParser instance.
@@ -40,8 +43,9 @@ var (
 	// simpleQuestionRegex matches a question code block (```question\n...\n```)
 	simpleQuestionRegex = regexp.MustCompile("(?s)```question\n(.*?)\n```")
 
-	// simpleBlockRegex matches any of the above code blocks and captures the type and content
-	simpleBlockRegex = regexp.MustCompile("(?s)```(docker|expect|question)\n(.*?)\n```")
+	// simpleBlockRegex matches any of the above code blocks, or a setup block, and
+	// captures the type, the options following it and the content
+	simpleBlockRegex = regexp.MustCompile("(?s)```(docker|expect|question|setup-file|setup|ready)((?:[ \t][^\n]*)?)\n(.*?)\n```")
 )
 
 // Parse implements the Parser interface by reading markdown content from the provided reader
@@ -99,19 +103,22 @@ func (p *SimpleParser) Parse(r io.Reader) (*Lesson, error) {
 		lesson.Description = strings.Join(descLines, " ")
 	}
 
-	// Find all blocks (docker, expect, question) in order
+	// Find all blocks (docker, expect, question, setup) in order
 	blockMatches := simpleBlockRegex.FindAllStringSubmatch(content, -1)
 
 	var currentStep *LessonStep
+	// setup collects the setup blocks for the next step
+	var setup *StepSetup
 
 	// Process each block in order
 	for _, match := range blockMatches {
-		if len(match) < 3 {
+		if len(match) < 4 {
 			continue
 		}
 
 		blockType := match[1]
-		blockContent := match[2]
+		blockOptions := strings.Fields(match[2])
+		blockContent := match[3]
 
 		switch blockType {
 		case "docker":
@@ -129,7 +136,9 @@ func (p *SimpleParser) Parse(r io.Reader) (*Lesson, error) {
 					ID:       generateStepID(len(lesson.Steps)),
 					Commands: commands,
 					Timeout:  5 * time.Minute,
+					Setup:    setup,
 				}
+				setup = nil
 
 				lesson.Steps = append(lesson.Steps, *currentStep)
 				// Update the pointer to point to the step in the slice
@@ -149,12 +158,116 @@ func (p *SimpleParser) Parse(r io.Reader) (*Lesson, error) {
 				// After setting question, we're done with this step
 				currentStep = nil
 			}
+
+		default:
+			// Setup blocks apply to the next step, so they end the current one
+			currentStep = nil
+			if setup == nil {
+				setup = &StepSetup{}
+			}
+			if err := parseSetupBlock(setup, blockType, blockOptions, blockContent); err != nil {
+				return nil, err
+			}
 		}
 	}
 
+	if setup != nil {
+		return nil, fmt.Errorf("setup blocks must be followed by a docker block")
+	}
+
 	return lesson, nil
 }
 
+// parseSetupBlock adds the content of a setup-file, setup or ready block to the
+// setup of the next step.
+func parseSetupBlock(setup *StepSetup, blockType string, options []string, content string) error {
+	switch blockType {
+	case "setup-file":
+		if len(options) != 1 {
+			return fmt.Errorf("setup-file blocks need a file path")
+		}
+		setup.Files = append(setup.Files, SetupFile{Path: options[0], Content: content + "\n"})
+
+	case "setup":
+		var retries int
+		var delay time.Duration
+		for _, option := range options {
+			name, value, err := parseBlockOption(option)
+			if err != nil {
+				return err
+			}
+			switch name {
+			case "retries":
+				if retries, err = strconv.Atoi(value); err != nil {
+					return fmt.Errorf("invalid setup retries %q", value)
+				}
+			case "delay":
+				if delay, err = time.ParseDuration(value); err != nil {
+					return fmt.Errorf("invalid setup delay %q", value)
+				}
+			default:
+				return fmt.Errorf("unknown setup option %q", name)
+			}
+		}
+		for _, command := range parseCommands(content) {
+			setup.Run = append(setup.Run, SetupCommand{Command: command, Retries: retries, RetryDelay: delay})
+		}
+
+	case "ready":
+		var timeout, interval time.Duration
+		for _, option := range options {
+			name, value, err := parseBlockOption(option)
+			if err != nil {
+				return err
+			}
+			d, err := time.ParseDuration(value)
+			if err != nil {
+				return fmt.Errorf("invalid ready %s %q", name, value)
+			}
+			switch name {
+			case "timeout":
+				timeout = d
+			case "interval":
+				interval = d
+			default:
+				return fmt.Errorf("unknown ready option %q", name)
+			}
+		}
+		for _, line := range parseCommands(content) {
+			fields := strings.Fields(line)
+			if len(fields) != 2 {
+				return fmt.Errorf("invalid readiness probe %q", line)
+			}
+			probe := ReadinessProbe{Type: ProbeType(fields[0]), Timeout: timeout, Interval: interval}
+			switch probe.Type {
+			case ProbePort:
+				port, err := strconv.Atoi(fields[1])
+				if err != nil {
+					return fmt.Errorf("invalid readiness probe port %q", fields[1])
+				}
+				probe.Port = port
+			case ProbeHTTP:
+				probe.URL = fields[1]
+			case ProbeFile:
+				probe.Path = fields[1]
+			default:
+				return fmt.Errorf("unknown readiness probe %q", fields[0])
+			}
+			setup.Ready = append(setup.Ready, probe)
+		}
+	}
+	return nil
+}
+
+// parseBlockOption splits a name=value option of a code block
+func parseBlockOption(option string) (string, string, error) {
+	parts := strings.SplitN(option, "=", 2)
+	if len(parts) != 2 {
+		return "", "", fmt.Errorf("invalid block option %q", option)
+	}
+	return parts[0], parts[1], nil
+}
+
 // parseCommands extracts individual commands from a docker code block.
 // It splits the content by newlines, trims whitespace, and filters out empty lines.
 //
@@ -2,6 +2,8 @@ package lesson
 
 import (
 	"time"
+
+	"github.com/ringo380/lessoncraft/netpolicy"
 )
 
 // VersionInfo represents information about a specific version of a lesson.
@@ -53,6 +55,18 @@ type ContainerConfig struct {
 	StorageSize string `json:"storage_size,omitempty" bson:"storage_size,omitempty"`
 }
 
+// ImageBuild is the build context of an image built for a lesson
+type ImageBuild struct {
+	// Dockerfile is the content of the Dockerfile
+	Dockerfile string `json:"dockerfile" bson:"dockerfile"`
+
+	// Files are added to the build context, keyed by their path relative to it
+	Files map[string]string `json:"files,omitempty" bson:"files,omitempty"`
+
+	// Args are the build arguments passed to the Dockerfile
+	Args map[string]string `json:"args,omitempty" bson:"args,omitempty"`
+}
+
 // LessonStep represents a single step in a lesson.
 // Each step contains content to be displayed to the user, commands to be executed,
 // expected output for validation, and other metadata.
@@ -94,6 +108,18 @@ type LessonStep struct {
 	// Containers is a list of container configurations for multi-container environments
 	// If this field is empty, a single container will be created using the Image field
 	Containers []ContainerConfig `json:"containers,omitempty" bson:"containers,omitempty"`
+
+	// Setup prepares the environment before the step is shown
+	Setup *StepSetup `json:"setup,omitempty" bson:"setup,omitempty"`
+}
+
+// Endpoint names a port of the instance a lesson runs in
+type Endpoint struct {
+	// Name is the first label of the endpoint host, e.g. "frontend"
+	Name string `json:"name" bson:"name"`
+
+	// Port is the port of the instance the endpoint leads to
+	Port int `json:"port" bson:"port"`
 }
 
 // Lesson represents a complete lesson with multiple steps.
@@ -137,6 +163,18 @@ type Lesson struct {
 	// DefaultStorageSize is the default maximum amount of storage that the container can use
 	DefaultStorageSize string `json:"default_storage_size,omitempty" bson:"default_storage_size,omitempty"`
 
+	// NetworkPolicy restricts what the lesson's instances can reach. It is
+	// merged with the policy of the playground the lesson runs in.
+	NetworkPolicy *netpolicy.Policy `json:"network_policy,omitempty" bson:"network_policy,omitempty"`
+
+	// Build describes an image built for the lesson and added to the image
+	// catalog, tagged with the lesson version
+	Build *ImageBuild `json:"build,omitempty" bson:"build,omitempty"`
+
+	// Endpoints name ports of the instance the lesson runs in, reachable at
+	// stable hosts such as frontend-<session>.direct.<domain>
+	Endpoints []Endpoint `json:"endpoints,omitempty" bson:"endpoints,omitempty"`
+
 	// Steps is an ordered list of steps that make up the lesson
 	Steps []LessonStep `json:"steps" bson:"steps"`
 
@@ -154,4 +192,8 @@ type Lesson struct {
 
 	// CurrentStep is the index of the current step in the lesson
 	CurrentStep int `json:"current_step" bson:"current_step"`
+
+	// OrgID restricts the lesson to members of an organisation.
+	// Lessons without an organisation are visible to everyone.
+	OrgID string `json:"org_id,omitempty" bson:"org_id,omitempty"`
 }
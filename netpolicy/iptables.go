@@ -0,0 +1,83 @@
+package netpolicy
+
+import (
+	"fmt"
+	"hash/fnv"
+	"strconv"
+	"strings"
+)
+
+// ForwardChain is the chain of the docker host that filters the traffic of
+// containers before docker's own rules
+const ForwardChain = "DOCKER-USER"
+
+// ChainName returns the iptables chain holding the rules of an instance.
+// Chain names are limited to 28 characters, so the name is hashed.
+func ChainName(instance string) string {
+	h := fnv.New64a()
+	h.Write([]byte(instance))
+	return fmt.Sprintf("LC-%016x", h.Sum64())
+}
+
+// IPTablesScript returns a shell script that filters the traffic sent from
+// source with rules. The rules live in their own chain, which is replaced if
+// it already exists.
+func IPTablesScript(chain, source string, rules []EgressRule) string {
+	lines := []string{
+		"set -e",
+		fmt.Sprintf("iptables -N %s 2>/dev/null || iptables -F %s", chain, chain),
+		unhookScript(chain),
+		fmt.Sprintf("iptables -I %s -s %s -j %s", ForwardChain, source, chain),
+		// Replies to connections opened from outside, e.g. through the router, are always allowed
+		fmt.Sprintf("iptables -A %s -m conntrack --ctstate ESTABLISHED,RELATED -j RETURN", chain),
+	}
+	for _, r := range rules {
+		target := "RETURN"
+		if r.Action == Deny {
+			target = "REJECT"
+		}
+		for _, match := range ruleMatches(r) {
+			lines = append(lines, fmt.Sprintf("iptables -A %s -d %s%s -j %s", chain, r.CIDR, match, target))
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// IPTablesCleanupScript returns a shell script that removes a chain created
+// by IPTablesScript
+func IPTablesCleanupScript(chain string) string {
+	return strings.Join([]string{
+		unhookScript(chain),
+		fmt.Sprintf("iptables -F %s 2>/dev/null || true", chain),
+		fmt.Sprintf("iptables -X %s 2>/dev/null || true", chain),
+	}, "\n")
+}
+
+// unhookScript removes the jumps to chain from the forward chain
+func unhookScript(chain string) string {
+	return fmt.Sprintf("iptables -S %s | grep -- '-j %s$' | sed 's/^-A //' | while read -r rule; do iptables -D $rule; done", ForwardChain, chain)
+}
+
+// ruleMatches returns the protocol and port matches of a rule. Rules with
+// ports but no protocol match both tcp and udp.
+func ruleMatches(r EgressRule) []string {
+	if len(r.Ports) == 0 {
+		if r.Protocol == "" {
+			return []string{""}
+		}
+		return []string{" -p " + r.Protocol}
+	}
+	ports := make([]string, len(r.Ports))
+	for i, port := range r.Ports {
+		ports[i] = strconv.Itoa(port)
+	}
+	protocols := []string{"tcp", "udp"}
+	if r.Protocol != "" {
+		protocols = []string{r.Protocol}
+	}
+	var matches []string
+	for _, protocol := range protocols {
+		matches = append(matches, fmt.Sprintf(" -p %s -m multiport --dports %s", protocol, strings.Join(ports, ",")))
+	}
+	return matches
+}
@@ -0,0 +1,255 @@
+// Package netpolicy describes what learner instances may reach on the network.
+// Playgrounds and lessons declare a Policy, which is compiled into an ordered
+// list of egress rules that the instance runtimes enforce, and a DNS allowlist
+// that the router enforces when it resolves names for instances.
+package netpolicy
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"strings"
+)
+
+const (
+	// PlaygroundExtra is the playground extra holding the playground policy
+	PlaygroundExtra = "network_policy"
+
+	// DNSAllowLabel is the instance label listing the domains the router
+	// resolves for it, separated by commas
+	DNSAllowLabel = "lessoncraft.dns.allow"
+)
+
+// Action is what happens to traffic matching a rule
+type Action string
+
+const (
+	Allow Action = "allow"
+	Deny  Action = "deny"
+)
+
+// PrivateRanges are the ranges isolated instances cannot reach. They include
+// the addresses of other sessions and the cloud metadata endpoints.
+var PrivateRanges = []string{"10.0.0.0/8", "172.16.0.0/12", "192.168.0.0/16", "100.64.0.0/10", "169.254.0.0/16"}
+
+// Rule matches egress traffic by destination
+type Rule struct {
+	// CIDR is the destination range, e.g. 10.0.0.0/8. A single address is a /32.
+	CIDR string `json:"cidr" bson:"cidr"`
+
+	// Protocol is tcp or udp. Rules without a protocol match both, or any
+	// protocol if they have no ports.
+	Protocol string `json:"protocol,omitempty" bson:"protocol,omitempty"`
+
+	// Ports are the destination ports. Rules without ports match every port.
+	Ports []int `json:"ports,omitempty" bson:"ports,omitempty"`
+}
+
+// Policy restricts the egress traffic and the DNS names of learner instances
+type Policy struct {
+	// DefaultEgress applies to traffic no rule matches. Empty means allow.
+	DefaultEgress Action `json:"default_egress,omitempty" bson:"default_egress,omitempty"`
+
+	// Allow lists destinations that can be reached
+	Allow []Rule `json:"allow,omitempty" bson:"allow,omitempty"`
+
+	// Deny lists destinations that cannot be reached. Deny rules win over allow rules.
+	Deny []Rule `json:"deny,omitempty" bson:"deny,omitempty"`
+
+	// DNSAllow lists the domains, and their subdomains, the router resolves
+	// for instances. Every domain is resolved if it is empty.
+	DNSAllow []string `json:"dns_allow,omitempty" bson:"dns_allow,omitempty"`
+
+	// Isolate stops instances from reaching private ranges, including other sessions
+	Isolate bool `json:"isolate,omitempty" bson:"isolate,omitempty"`
+}
+
+// EgressRule is a compiled rule. Runtimes apply the first rule matching a
+// packet and let it through if none does.
+type EgressRule struct {
+	Action   Action
+	CIDR     string
+	Protocol string
+	Ports    []int
+}
+
+// Parse reads a policy from a playground extra, which is either a policy or
+// the JSON decoded form of one
+func Parse(v interface{}) (*Policy, error) {
+	if p, ok := v.(*Policy); ok {
+		return p, p.Validate()
+	}
+	var b []byte
+	switch value := v.(type) {
+	case string:
+		b = []byte(value)
+	default:
+		var err error
+		if b, err = json.Marshal(value); err != nil {
+			return nil, fmt.Errorf("invalid network policy: %v", err)
+		}
+	}
+	p := &Policy{}
+	if err := json.Unmarshal(b, p); err != nil {
+		return nil, fmt.Errorf("invalid network policy: %v", err)
+	}
+	return p, p.Validate()
+}
+
+// Validate checks that the policy can be enforced
+func (p *Policy) Validate() error {
+	switch p.DefaultEgress {
+	case "", Allow, Deny:
+	default:
+		return fmt.Errorf("unknown default egress %q", p.DefaultEgress)
+	}
+	for i, r := range p.Allow {
+		if err := r.validate(); err != nil {
+			return fmt.Errorf("allow rule %d: %v", i+1, err)
+		}
+	}
+	for i, r := range p.Deny {
+		if err := r.validate(); err != nil {
+			return fmt.Errorf("deny rule %d: %v", i+1, err)
+		}
+	}
+	for _, d := range p.DNSAllow {
+		if d = strings.Trim(d, "."); d == "" || strings.ContainsAny(d, ", \t*") {
+			return fmt.Errorf("invalid dns domain %q", d)
+		}
+	}
+	return nil
+}
+
+func (r Rule) validate() error {
+	if _, _, err := net.ParseCIDR(r.cidr()); err != nil {
+		return fmt.Errorf("invalid cidr %q", r.CIDR)
+	}
+	switch r.Protocol {
+	case "", "tcp", "udp":
+	default:
+		return fmt.Errorf("unknown protocol %q", r.Protocol)
+	}
+	for _, port := range r.Ports {
+		if port < 1 || port > 65535 {
+			return fmt.Errorf("port %d must be between 1 and 65535", port)
+		}
+	}
+	return nil
+}
+
+// cidr returns the destination range, turning single addresses into ranges
+func (r Rule) cidr() string {
+	if strings.Contains(r.CIDR, "/") {
+		return r.CIDR
+	}
+	if ip := net.ParseIP(r.CIDR); ip != nil && ip.To4() == nil {
+		return r.CIDR + "/128"
+	}
+	return r.CIDR + "/32"
+}
+
+// Merge combines the policy of a playground with the policy of a lesson run in
+// it. The lesson can tighten the playground policy and add allow rules, but
+// the deny rules, isolation and DNS allowlist of the playground always apply.
+// It returns nil if neither has a policy.
+func Merge(playground, lesson *Policy) *Policy {
+	if playground == nil && lesson == nil {
+		return nil
+	}
+	if playground == nil {
+		playground = &Policy{}
+	}
+	if lesson == nil {
+		lesson = &Policy{}
+	}
+
+	merged := &Policy{
+		DefaultEgress: playground.DefaultEgress,
+		Allow:         append(append([]Rule{}, playground.Allow...), lesson.Allow...),
+		Deny:          append(append([]Rule{}, playground.Deny...), lesson.Deny...),
+		Isolate:       playground.Isolate || lesson.Isolate,
+	}
+	if lesson.DefaultEgress == Deny {
+		merged.DefaultEgress = Deny
+	}
+
+	switch {
+	case len(lesson.DNSAllow) == 0:
+		merged.DNSAllow = playground.DNSAllow
+	case len(playground.DNSAllow) == 0:
+		merged.DNSAllow = lesson.DNSAllow
+	default:
+		// Lesson domains must be allowed by the playground too
+		for _, d := range lesson.DNSAllow {
+			if playground.AllowsDomain(d) {
+				merged.DNSAllow = append(merged.DNSAllow, d)
+			}
+		}
+		if len(merged.DNSAllow) == 0 {
+			// Nothing is in both lists, which must not mean everything is allowed
+			merged.DNSAllow = []string{"invalid"}
+		}
+	}
+	return merged
+}
+
+// AllowsDomain tells whether the router resolves name for instances
+func (p *Policy) AllowsDomain(name string) bool {
+	if p == nil || len(p.DNSAllow) == 0 {
+		return true
+	}
+	return DomainAllowed(p.DNSAllow, name)
+}
+
+// DomainAllowed tells whether name is one of domains or a subdomain of one
+func DomainAllowed(domains []string, name string) bool {
+	name = strings.ToLower(strings.TrimSuffix(name, "."))
+	for _, d := range domains {
+		d = strings.ToLower(strings.Trim(d, "."))
+		if name == d || strings.HasSuffix(name, "."+d) {
+			return true
+		}
+	}
+	return false
+}
+
+// Restricted tells whether the policy changes anything for instances
+func (p *Policy) Restricted() bool {
+	return p != nil && (p.DefaultEgress == Deny || len(p.Deny) > 0 || len(p.DNSAllow) > 0 || p.Isolate)
+}
+
+// NoEgress tells whether instances cannot reach anything outside their session
+func (p *Policy) NoEgress() bool {
+	return p != nil && p.DefaultEgress == Deny && len(p.Allow) == 0
+}
+
+// EgressRules compiles the policy into the rules runtimes enforce. dnsServer
+// is the address of the router; when the policy has a DNS allowlist it is the
+// only DNS server instances can reach, so the allowlist cannot be bypassed.
+func (p *Policy) EgressRules(dnsServer string) []EgressRule {
+	var rules []EgressRule
+	if len(p.DNSAllow) > 0 {
+		if dnsServer != "" {
+			rules = append(rules, EgressRule{Action: Allow, CIDR: Rule{CIDR: dnsServer}.cidr(), Ports: []int{53}})
+		}
+		rules = append(rules,
+			EgressRule{Action: Deny, CIDR: "0.0.0.0/0", Protocol: "udp", Ports: []int{53}},
+			EgressRule{Action: Deny, CIDR: "0.0.0.0/0", Protocol: "tcp", Ports: []int{53}})
+	}
+	for _, r := range p.Deny {
+		rules = append(rules, EgressRule{Action: Deny, CIDR: r.cidr(), Protocol: r.Protocol, Ports: r.Ports})
+	}
+	if p.Isolate {
+		for _, cidr := range PrivateRanges {
+			rules = append(rules, EgressRule{Action: Deny, CIDR: cidr})
+		}
+	}
+	for _, r := range p.Allow {
+		rules = append(rules, EgressRule{Action: Allow, CIDR: r.cidr(), Protocol: r.Protocol, Ports: r.Ports})
+	}
+	if p.DefaultEgress == Deny {
+		rules = append(rules, EgressRule{Action: Deny, CIDR: "0.0.0.0/0"})
+	}
+	return rules
+}
@@ -0,0 +1,135 @@
+package netpolicy
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParse(t *testing.T) {
+	// Playground extras are decoded from JSON
+	p, err := Parse(map[string]interface{}{
+		"default_egress": "deny",
+		"allow":          []interface{}{map[string]interface{}{"cidr": "1.1.1.1", "protocol": "tcp", "ports": []interface{}{443.0}}},
+		"dns_allow":      []interface{}{"docker.io"},
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, &Policy{
+		DefaultEgress: Deny,
+		Allow:         []Rule{{CIDR: "1.1.1.1", Protocol: "tcp", Ports: []int{443}}},
+		DNSAllow:      []string{"docker.io"},
+	}, p)
+
+	p, err = Parse(`{"isolate": true}`)
+	assert.Nil(t, err)
+	assert.True(t, p.Isolate)
+
+	_, err = Parse(map[string]interface{}{"default_egress": "maybe"})
+	assert.NotNil(t, err)
+	_, err = Parse(`{"deny": [{"cidr": "not a range"}]}`)
+	assert.NotNil(t, err)
+	_, err = Parse(`{"deny": [{"cidr": "0.0.0.0/0", "protocol": "icmp"}]}`)
+	assert.NotNil(t, err)
+	_, err = Parse(`{"allow": [{"cidr": "0.0.0.0/0", "ports": [70000]}]}`)
+	assert.NotNil(t, err)
+	_, err = Parse(`{"dns_allow": ["*.example.com"]}`)
+	assert.NotNil(t, err)
+}
+
+func TestMerge(t *testing.T) {
+	assert.Nil(t, Merge(nil, nil))
+
+	playground := &Policy{
+		Deny:     []Rule{{CIDR: "203.0.113.0/24"}},
+		DNSAllow: []string{"docker.io", "github.com"},
+		Isolate:  true,
+	}
+	lesson := &Policy{
+		DefaultEgress: Deny,
+		Allow:         []Rule{{CIDR: "0.0.0.0/0", Protocol: "tcp", Ports: []int{443}}},
+		DNSAllow:      []string{"registry-1.docker.io", "example.com"},
+	}
+	assert.Equal(t, &Policy{
+		DefaultEgress: Deny,
+		Allow:         []Rule{{CIDR: "0.0.0.0/0", Protocol: "tcp", Ports: []int{443}}},
+		Deny:          []Rule{{CIDR: "203.0.113.0/24"}},
+		DNSAllow:      []string{"registry-1.docker.io"},
+		Isolate:       true,
+	}, Merge(playground, lesson))
+
+	// Lessons cannot loosen the default of the playground
+	merged := Merge(&Policy{DefaultEgress: Deny}, &Policy{DefaultEgress: Allow})
+	assert.Equal(t, Deny, merged.DefaultEgress)
+
+	// Disjoint DNS allowlists resolve nothing
+	merged = Merge(&Policy{DNSAllow: []string{"docker.io"}}, &Policy{DNSAllow: []string{"example.com"}})
+	assert.False(t, merged.AllowsDomain("example.com"))
+	assert.False(t, merged.AllowsDomain("docker.io"))
+}
+
+func TestAllowsDomain(t *testing.T) {
+	var p *Policy
+	assert.True(t, p.AllowsDomain("example.com."))
+
+	p = &Policy{DNSAllow: []string{"docker.io", ".github.com."}}
+	assert.True(t, p.AllowsDomain("docker.io."))
+	assert.True(t, p.AllowsDomain("registry-1.Docker.io."))
+	assert.True(t, p.AllowsDomain("api.github.com"))
+	assert.False(t, p.AllowsDomain("notdocker.io."))
+	assert.False(t, p.AllowsDomain("example.com."))
+}
+
+func TestEgressRules(t *testing.T) {
+	p := &Policy{
+		DefaultEgress: Deny,
+		Allow:         []Rule{{CIDR: "0.0.0.0/0", Protocol: "tcp", Ports: []int{80, 443}}},
+		Deny:          []Rule{{CIDR: "198.51.100.7"}},
+		DNSAllow:      []string{"docker.io"},
+		Isolate:       true,
+	}
+	rules := p.EgressRules("10.0.0.2")
+	assert.Equal(t, []EgressRule{
+		{Action: Allow, CIDR: "10.0.0.2/32", Ports: []int{53}},
+		{Action: Deny, CIDR: "0.0.0.0/0", Protocol: "udp", Ports: []int{53}},
+		{Action: Deny, CIDR: "0.0.0.0/0", Protocol: "tcp", Ports: []int{53}},
+		{Action: Deny, CIDR: "198.51.100.7/32"},
+		{Action: Deny, CIDR: "10.0.0.0/8"},
+		{Action: Deny, CIDR: "172.16.0.0/12"},
+		{Action: Deny, CIDR: "192.168.0.0/16"},
+		{Action: Deny, CIDR: "100.64.0.0/10"},
+		{Action: Deny, CIDR: "169.254.0.0/16"},
+		{Action: Allow, CIDR: "0.0.0.0/0", Protocol: "tcp", Ports: []int{80, 443}},
+		{Action: Deny, CIDR: "0.0.0.0/0"},
+	}, rules)
+
+	assert.Empty(t, (&Policy{}).EgressRules("10.0.0.2"))
+	assert.True(t, p.Restricted())
+	assert.False(t, (&Policy{Allow: []Rule{{CIDR: "0.0.0.0/0"}}}).Restricted())
+	assert.True(t, (&Policy{DefaultEgress: Deny}).NoEgress())
+	assert.False(t, p.NoEgress())
+}
+
+func TestIPTablesScript(t *testing.T) {
+	chain := ChainName("aaaabbbb_node1")
+	assert.Equal(t, chain, ChainName("aaaabbbb_node1"))
+	assert.NotEqual(t, chain, ChainName("aaaabbbb_node2"))
+	assert.True(t, len(chain) <= 28)
+
+	script := IPTablesScript(chain, "172.18.0.5", []EgressRule{
+		{Action: Allow, CIDR: "10.0.0.2/32", Ports: []int{53}},
+		{Action: Deny, CIDR: "0.0.0.0/0", Protocol: "udp"},
+		{Action: Deny, CIDR: "0.0.0.0/0"},
+	})
+	lines := strings.Split(script, "\n")
+	assert.Contains(t, lines, "iptables -I DOCKER-USER -s 172.18.0.5 -j "+chain)
+	assert.Equal(t, []string{
+		"iptables -A " + chain + " -d 10.0.0.2/32 -p tcp -m multiport --dports 53 -j RETURN",
+		"iptables -A " + chain + " -d 10.0.0.2/32 -p udp -m multiport --dports 53 -j RETURN",
+		"iptables -A " + chain + " -d 0.0.0.0/0 -p udp -j REJECT",
+		"iptables -A " + chain + " -d 0.0.0.0/0 -j REJECT",
+	}, lines[len(lines)-4:])
+
+	cleanup := IPTablesCleanupScript(chain)
+	assert.Contains(t, cleanup, "iptables -X "+chain)
+}
@@ -0,0 +1,110 @@
+package placement
+
+import (
+	"strings"
+	"time"
+)
+
+// State controls whether new sessions can be placed on a host
+type State string
+
+const (
+	// StateActive hosts receive new sessions
+	StateActive State = "active"
+	// StateCordoned hosts keep their sessions but receive no new ones
+	StateCordoned State = "cordoned"
+	// StateDraining hosts receive no new sessions and leave the registry once
+	// their last session closes
+	StateDraining State = "draining"
+)
+
+// Host is a docker daemon sessions can be placed on
+type Host struct {
+	ID string `json:"id"`
+	// Addr is the address of the daemon, e.g. tcp://10.0.0.5:2375
+	Addr   string            `json:"addr"`
+	Labels map[string]string `json:"labels,omitempty"`
+	State  State             `json:"state"`
+	// MaxSessions limits the sessions placed on the host, zero means no limit
+	MaxSessions int       `json:"max_sessions,omitempty"`
+	AddedAt     time.Time `json:"added_at"`
+
+	// Capacity is what the host reported the last time it was probed
+	Capacity   Capacity  `json:"capacity"`
+	Healthy    bool      `json:"healthy"`
+	ProbeError string    `json:"probe_error,omitempty"`
+	ProbedAt   time.Time `json:"probed_at,omitempty"`
+
+	// Sessions is the number of sessions placed on the host, Orgs the number of
+	// them per organisation
+	Sessions int            `json:"sessions"`
+	Orgs     map[string]int `json:"orgs,omitempty"`
+}
+
+// Capacity is the size and usage of a host as reported by its daemon
+type Capacity struct {
+	CPUs              int      `json:"cpus"`
+	MemoryBytes       int64    `json:"memory_bytes"`
+	ContainersRunning int      `json:"containers_running"`
+	Images            []string `json:"images,omitempty"`
+}
+
+// Schedulable checks if new sessions can be placed on the host
+func (h *Host) Schedulable() bool {
+	return h.State == StateActive && h.Healthy && (h.MaxSessions == 0 || h.Sessions < h.MaxSessions)
+}
+
+// Load is how busy the host is. It is the share of its session slots in use
+// when MaxSessions is set, otherwise the running containers per CPU.
+func (h *Host) Load() float64 {
+	if h.MaxSessions > 0 {
+		return float64(h.Sessions) / float64(h.MaxSessions)
+	}
+	if h.Capacity.CPUs == 0 {
+		return float64(h.Capacity.ContainersRunning)
+	}
+	return float64(h.Capacity.ContainersRunning) / float64(h.Capacity.CPUs)
+}
+
+// HasImage checks if the host has pulled an image. Images without a tag match
+// the latest tag.
+func (h *Host) HasImage(image string) bool {
+	if image == "" {
+		return false
+	}
+	if !strings.Contains(image[strings.LastIndex(image, "/")+1:], ":") {
+		image += ":latest"
+	}
+	for _, i := range h.Capacity.Images {
+		if i == image {
+			return true
+		}
+	}
+	return false
+}
+
+// Hostname returns the host part of the address of the daemon
+func (h *Host) Hostname() string {
+	addr := h.Addr
+	if i := strings.Index(addr, "://"); i >= 0 {
+		addr = addr[i+3:]
+	}
+	if i := strings.IndexAny(addr, ":/"); i >= 0 {
+		addr = addr[:i]
+	}
+	return addr
+}
+
+func (h *Host) copy() *Host {
+	c := *h
+	c.Labels = make(map[string]string, len(h.Labels))
+	for k, v := range h.Labels {
+		c.Labels[k] = v
+	}
+	c.Orgs = make(map[string]int, len(h.Orgs))
+	for k, v := range h.Orgs {
+		c.Orgs[k] = v
+	}
+	c.Capacity.Images = append([]string(nil), h.Capacity.Images...)
+	return &c
+}
@@ -0,0 +1,110 @@
+package placement
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func addHosts(t *testing.T, r *Registry, hosts ...Host) {
+	for _, h := range hosts {
+		_, err := r.Add(h)
+		assert.Nil(t, err)
+		assert.Nil(t, r.UpdateCapacity(h.ID, h.Capacity, nil))
+	}
+}
+
+func ids(hosts []*Host) []string {
+	ids := []string{}
+	for _, h := range hosts {
+		ids = append(ids, h.ID)
+	}
+	return ids
+}
+
+func TestRegistry(t *testing.T) {
+	r := NewRegistry()
+	_, err := r.Add(Host{ID: "h1"})
+	assert.NotNil(t, err)
+	addHosts(t, r, Host{ID: "h1", Addr: "tcp://10.0.0.1:2375", MaxSessions: 1}, Host{ID: "h2", Addr: "tcp://10.0.0.2:2375"})
+	_, err = r.Add(Host{ID: "h1", Addr: "tcp://10.0.0.9:2375"})
+	assert.Equal(t, ErrHostExists, err)
+
+	// Full hosts are not ranked, and cannot be assigned to
+	assert.Nil(t, r.Assign("h1", Request{SessionID: "s1", OrgID: "acme"}))
+	assert.Equal(t, []string{"h2"}, ids(r.Rank(Request{SessionID: "s2"}, LeastLoaded)))
+	assert.Equal(t, ErrNoCapacity, r.Assign("h1", Request{SessionID: "s2"}))
+
+	h, found := r.HostOf("s1")
+	assert.True(t, found)
+	assert.Equal(t, "h1", h.ID)
+	assert.Equal(t, map[string]int{"acme": 1}, h.Orgs)
+	h, found = r.FindByHostname("10.0.0.2")
+	assert.True(t, found)
+	assert.Equal(t, "h2", h.ID)
+
+	// Unhealthy and cordoned hosts receive no sessions
+	assert.Nil(t, r.UpdateCapacity("h2", Capacity{}, errors.New("connection refused")))
+	assert.Empty(t, r.Rank(Request{SessionID: "s2"}, LeastLoaded))
+	assert.Nil(t, r.UpdateCapacity("h2", Capacity{CPUs: 4}, nil))
+	assert.Nil(t, r.Cordon("h2"))
+	assert.Empty(t, r.Rank(Request{SessionID: "s2"}, LeastLoaded))
+	assert.Nil(t, r.Uncordon("h2"))
+	assert.Len(t, r.Rank(Request{SessionID: "s2"}, LeastLoaded), 1)
+
+	assert.Equal(t, ErrHostInUse, r.Remove("h1", false))
+	assert.Equal(t, ErrHostNotFound, r.Cordon("h3"))
+}
+
+func TestRegistry_Drain(t *testing.T) {
+	r := NewRegistry()
+	addHosts(t, r, Host{ID: "h1", Addr: "tcp://10.0.0.1:2375"}, Host{ID: "h2", Addr: "tcp://10.0.0.2:2375"})
+	assert.Nil(t, r.Assign("h1", Request{SessionID: "s1"}))
+	assert.Nil(t, r.Adopt("h1", Request{SessionID: "s2"}))
+
+	// Draining hosts keep their sessions until they close
+	assert.Nil(t, r.Drain("h1"))
+	h, err := r.Get("h1")
+	assert.Nil(t, err)
+	assert.Equal(t, StateDraining, h.State)
+	assert.Equal(t, 2, h.Sessions)
+
+	r.Release("s1")
+	r.Release("s1")
+	_, err = r.Get("h1")
+	assert.Nil(t, err)
+	r.Release("s2")
+	_, err = r.Get("h1")
+	assert.Equal(t, ErrHostNotFound, err)
+
+	// Empty hosts are removed right away
+	assert.Nil(t, r.Drain("h2"))
+	assert.Empty(t, r.List())
+}
+
+func TestStrategies(t *testing.T) {
+	r := NewRegistry()
+	addHosts(t, r,
+		Host{ID: "h1", Addr: "tcp://10.0.0.1:2375", Capacity: Capacity{CPUs: 4, ContainersRunning: 8}},
+		Host{ID: "h2", Addr: "tcp://10.0.0.2:2375", Capacity: Capacity{CPUs: 4, ContainersRunning: 2, Images: []string{"franela/dind:latest"}}},
+		Host{ID: "h3", Addr: "tcp://10.0.0.3:2375", Capacity: Capacity{CPUs: 8, ContainersRunning: 8, Images: []string{"franela/dind:latest"}}},
+	)
+	req := Request{SessionID: "s1", OrgID: "acme", Image: "franela/dind"}
+
+	assert.Equal(t, []string{"h2", "h3", "h1"}, ids(r.Rank(req, LeastLoaded)))
+	assert.Equal(t, []string{"h1", "h3", "h2"}, ids(r.Rank(req, BinPack)))
+	assert.Equal(t, []string{"h3", "h2", "h1"}, ids(r.Rank(req, Chain(ImageLocality, BinPack))))
+
+	assert.Nil(t, r.Assign("h2", Request{SessionID: "s0", OrgID: "acme"}))
+	assert.Equal(t, []string{"h3", "h1", "h2"}, ids(r.Rank(req, Chain(OrgAntiAffinity, LeastLoaded))))
+
+	s, err := ParseStrategy("image-local, anti-affinity, least-loaded")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"h3", "h2", "h1"}, ids(r.Rank(req, s)))
+	_, err = ParseStrategy("random")
+	assert.NotNil(t, err)
+	s, err = ParseStrategy("")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"h2", "h3", "h1"}, ids(r.Rank(req, s)))
+}
@@ -0,0 +1,297 @@
+package placement
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrHostNotFound is returned when a host is not in the registry
+	ErrHostNotFound = errors.New("host not found")
+	// ErrHostExists is returned when adding a host with an ID already in use
+	ErrHostExists = errors.New("host already exists")
+	// ErrHostInUse is returned when removing a host that still has sessions
+	ErrHostInUse = errors.New("host still has sessions")
+	// ErrNoCapacity is returned when no host can take a session
+	ErrNoCapacity = errors.New("no host has capacity for the session")
+)
+
+// Request describes a session to place
+type Request struct {
+	SessionID string
+	OrgID     string
+	// Image is the image instances of the session are expected to run
+	Image string
+}
+
+type assignment struct {
+	hostID string
+	orgID  string
+}
+
+// Registry keeps the hosts sessions can be placed on and which host each
+// session is on
+type Registry struct {
+	mu          sync.RWMutex
+	hosts       map[string]*Host
+	assignments map[string]assignment
+}
+
+// NewRegistry creates an empty registry
+func NewRegistry() *Registry {
+	return &Registry{hosts: map[string]*Host{}, assignments: map[string]assignment{}}
+}
+
+// Add registers a host. Hosts are unhealthy until they are first probed.
+func (r *Registry) Add(h Host) (*Host, error) {
+	if h.ID == "" || h.Addr == "" {
+		return nil, errors.New("hosts need an ID and an address")
+	}
+	if h.MaxSessions < 0 {
+		return nil, errors.New("max sessions cannot be negative")
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, found := r.hosts[h.ID]; found {
+		return nil, ErrHostExists
+	}
+	h.State = StateActive
+	h.AddedAt = time.Now()
+	h.Healthy = false
+	h.Sessions = 0
+	h.Orgs = map[string]int{}
+	r.hosts[h.ID] = h.copy()
+	log.Printf("Host [%s] added at [%s]\n", h.ID, h.Addr)
+	return h.copy(), nil
+}
+
+// Remove unregisters a host. Hosts with sessions are only removed when forced,
+// their sessions are then no longer tracked.
+func (r *Registry) Remove(id string, force bool) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	h, found := r.hosts[id]
+	if !found {
+		return ErrHostNotFound
+	}
+	if h.Sessions > 0 && !force {
+		return ErrHostInUse
+	}
+	r.remove(id)
+	return nil
+}
+
+func (r *Registry) remove(id string) {
+	for sessionID, a := range r.assignments {
+		if a.hostID == id {
+			delete(r.assignments, sessionID)
+		}
+	}
+	delete(r.hosts, id)
+	log.Printf("Host [%s] removed\n", id)
+}
+
+// Get returns a host
+func (r *Registry) Get(id string) (*Host, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	h, found := r.hosts[id]
+	if !found {
+		return nil, ErrHostNotFound
+	}
+	return h.copy(), nil
+}
+
+// List returns all hosts ordered by ID
+func (r *Registry) List() []*Host {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	hosts := make([]*Host, 0, len(r.hosts))
+	for _, h := range r.hosts {
+		hosts = append(hosts, h.copy())
+	}
+	sort.Slice(hosts, func(i, j int) bool { return hosts[i].ID < hosts[j].ID })
+	return hosts
+}
+
+// Cordon stops placing new sessions on a host
+func (r *Registry) Cordon(id string) error {
+	return r.setState(id, StateCordoned)
+}
+
+// Uncordon places new sessions on a cordoned or draining host again
+func (r *Registry) Uncordon(id string) error {
+	return r.setState(id, StateActive)
+}
+
+// Drain stops placing new sessions on a host and removes it once its last
+// session closes. Hosts without sessions are removed right away.
+func (r *Registry) Drain(id string) error {
+	if err := r.setState(id, StateDraining); err != nil {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if h, found := r.hosts[id]; found && h.Sessions == 0 {
+		r.remove(id)
+	}
+	return nil
+}
+
+func (r *Registry) setState(id string, state State) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	h, found := r.hosts[id]
+	if !found {
+		return ErrHostNotFound
+	}
+	h.State = state
+	log.Printf("Host [%s] is now %s\n", id, state)
+	return nil
+}
+
+// UpdateCapacity records the result of probing a host. Hosts that could not
+// be probed are unhealthy and receive no sessions until they recover.
+func (r *Registry) UpdateCapacity(id string, c Capacity, probeErr error) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	h, found := r.hosts[id]
+	if !found {
+		return ErrHostNotFound
+	}
+	h.ProbedAt = time.Now()
+	if probeErr != nil {
+		if h.Healthy {
+			log.Printf("Host [%s] is unhealthy: %v\n", id, probeErr)
+		}
+		h.Healthy = false
+		h.ProbeError = probeErr.Error()
+		return nil
+	}
+	h.Healthy = true
+	h.ProbeError = ""
+	h.Capacity = c
+	h.Capacity.Images = append([]string(nil), c.Images...)
+	return nil
+}
+
+// Rank returns the hosts a session can be placed on, best first
+func (r *Registry) Rank(req Request, s Strategy) []*Host {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	hosts := []*Host{}
+	for _, h := range r.hosts {
+		if h.Schedulable() {
+			hosts = append(hosts, h.copy())
+		}
+	}
+	sort.SliceStable(hosts, func(i, j int) bool {
+		if c := s.Compare(req, hosts[i], hosts[j]); c != 0 {
+			return c < 0
+		}
+		return hosts[i].ID < hosts[j].ID
+	})
+	return hosts
+}
+
+// Assign places a session on a host. It fails with ErrNoCapacity if the host
+// stopped being schedulable since it was ranked.
+func (r *Registry) Assign(id string, req Request) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	h, found := r.hosts[id]
+	if !found {
+		return ErrHostNotFound
+	}
+	if a, found := r.assignments[req.SessionID]; found {
+		if a.hostID == id {
+			return nil
+		}
+		return fmt.Errorf("session [%s] is already placed on host [%s]", req.SessionID, a.hostID)
+	}
+	if !h.Schedulable() {
+		return ErrNoCapacity
+	}
+	r.assign(h, req)
+	return nil
+}
+
+// Adopt records a session found on a host, e.g. after a restart. Unlike Assign
+// it does not check the host is schedulable.
+func (r *Registry) Adopt(id string, req Request) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	h, found := r.hosts[id]
+	if !found {
+		return ErrHostNotFound
+	}
+	if _, found := r.assignments[req.SessionID]; !found {
+		r.assign(h, req)
+	}
+	return nil
+}
+
+func (r *Registry) assign(h *Host, req Request) {
+	r.assignments[req.SessionID] = assignment{hostID: h.ID, orgID: req.OrgID}
+	h.Sessions++
+	if req.OrgID != "" {
+		h.Orgs[req.OrgID]++
+	}
+}
+
+// Release frees the place of a session on its host. Draining hosts are removed
+// once their last session is released.
+func (r *Registry) Release(sessionID string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	a, found := r.assignments[sessionID]
+	if !found {
+		return
+	}
+	delete(r.assignments, sessionID)
+	h, found := r.hosts[a.hostID]
+	if !found {
+		return
+	}
+	h.Sessions--
+	if a.orgID != "" {
+		if h.Orgs[a.orgID]--; h.Orgs[a.orgID] <= 0 {
+			delete(h.Orgs, a.orgID)
+		}
+	}
+	if h.State == StateDraining && h.Sessions == 0 {
+		r.remove(h.ID)
+	}
+}
+
+// HostOf returns the host a session is placed on
+func (r *Registry) HostOf(sessionID string) (*Host, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	a, found := r.assignments[sessionID]
+	if !found {
+		return nil, false
+	}
+	h, found := r.hosts[a.hostID]
+	if !found {
+		return nil, false
+	}
+	return h.copy(), true
+}
+
+// FindByHostname returns the host whose daemon address has the given hostname
+func (r *Registry) FindByHostname(hostname string) (*Host, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, h := range r.hosts {
+		if h.Hostname() == hostname {
+			return h.copy(), true
+		}
+	}
+	return nil, false
+}
@@ -0,0 +1,116 @@
+package placement
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Strategy orders the hosts a session can be placed on
+type Strategy interface {
+	// Compare returns a negative number if a is a better host for the session
+	// than b, a positive one if b is better and zero if neither is
+	Compare(req Request, a, b *Host) int
+}
+
+// StrategyFunc adapts a function to a Strategy
+type StrategyFunc func(req Request, a, b *Host) int
+
+func (f StrategyFunc) Compare(req Request, a, b *Host) int {
+	return f(req, a, b)
+}
+
+// LeastLoaded prefers the host with the lowest load, spreading sessions evenly
+var LeastLoaded = StrategyFunc(func(req Request, a, b *Host) int {
+	return compareFloat(a.Load(), b.Load())
+})
+
+// BinPack prefers the host with the highest load, filling hosts one at a time
+// so idle ones can be scaled down
+var BinPack = StrategyFunc(func(req Request, a, b *Host) int {
+	return compareFloat(b.Load(), a.Load())
+})
+
+// ImageLocality prefers hosts that already pulled the image of the session
+var ImageLocality = StrategyFunc(func(req Request, a, b *Host) int {
+	return compareBool(a.HasImage(req.Image), b.HasImage(req.Image))
+})
+
+// OrgAntiAffinity prefers hosts with the fewest sessions of the organisation of
+// the session, so a host failing does not take down a whole class
+var OrgAntiAffinity = StrategyFunc(func(req Request, a, b *Host) int {
+	if req.OrgID == "" {
+		return 0
+	}
+	return a.Orgs[req.OrgID] - b.Orgs[req.OrgID]
+})
+
+// Chain orders hosts by the first strategy that tells them apart
+func Chain(strategies ...Strategy) Strategy {
+	return StrategyFunc(func(req Request, a, b *Host) int {
+		for _, s := range strategies {
+			if c := s.Compare(req, a, b); c != 0 {
+				return c
+			}
+		}
+		return 0
+	})
+}
+
+var strategies = map[string]Strategy{
+	"least-loaded":  LeastLoaded,
+	"bin-pack":      BinPack,
+	"image-local":   ImageLocality,
+	"anti-affinity": OrgAntiAffinity,
+}
+
+// RegisterStrategy makes a strategy available to ParseStrategy
+func RegisterStrategy(name string, s Strategy) {
+	strategies[name] = s
+}
+
+// ParseStrategy builds a strategy from a comma separated list of strategy
+// names, e.g. "image-local,anti-affinity,least-loaded"
+func ParseStrategy(names string) (Strategy, error) {
+	chain := []Strategy{}
+	for _, name := range strings.Split(names, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		s, found := strategies[name]
+		if !found {
+			known := make([]string, 0, len(strategies))
+			for k := range strategies {
+				known = append(known, k)
+			}
+			sort.Strings(known)
+			return nil, fmt.Errorf("unknown placement strategy %q, known strategies are %s", name, strings.Join(known, ", "))
+		}
+		chain = append(chain, s)
+	}
+	if len(chain) == 0 {
+		return LeastLoaded, nil
+	}
+	return Chain(chain...), nil
+}
+
+func compareFloat(a, b float64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+func compareBool(a, b bool) int {
+	switch {
+	case a && !b:
+		return -1
+	case b && !a:
+		return 1
+	}
+	return 0
+}
@@ -11,27 +11,63 @@ import (
 	"strings"
 
 	lru "github.com/hashicorp/golang-lru"
+	"github.com/ringo380/lessoncraft/backend"
 	"github.com/ringo380/lessoncraft/config"
 	"github.com/ringo380/lessoncraft/docker"
 	"github.com/ringo380/lessoncraft/id"
 	"github.com/ringo380/lessoncraft/lesson"
+	"github.com/ringo380/lessoncraft/netpolicy"
 	"github.com/ringo380/lessoncraft/pwd/types"
 	"github.com/ringo380/lessoncraft/router"
 	"github.com/ringo380/lessoncraft/storage"
+	"golang.org/x/crypto/ssh"
 )
 
 type DinD struct {
-	factory   docker.FactoryApi
+	factory   backend.Factory
 	storage   storage.StorageApi
 	generator id.Generator
 	cache     *lru.Cache
+	pool      *WarmPool
+	sshKey    string
 }
 
 func NewDinD(generator id.Generator, f docker.FactoryApi, s storage.StorageApi) *DinD {
+	return NewDinDWithRuntime(generator, docker.NewRuntimeFactory(f), s)
+}
+
+// NewDinDWithRuntime creates a DinD provisioner that runs instances on the
+// runtimes of f, e.g. as Kubernetes pods
+func NewDinDWithRuntime(generator id.Generator, f backend.Factory, s storage.StorageApi) *DinD {
 	c, _ := lru.New(5000)
 	return &DinD{generator: generator, factory: f, storage: s, cache: c}
 }
 
+// SetWarmPool makes InstanceNew claim pre-created containers from the pool when
+// one matches the instance, instead of always creating a new container
+func (d *DinD) SetWarmPool(pool *WarmPool) {
+	d.pool = pool
+}
+
+// SetInstanceSSHKey authorizes key to log in as root on new instances, e.g. the
+// key the l2 router forwards SSH logins with
+func (d *DinD) SetInstanceSSHKey(key ssh.PublicKey) {
+	d.sshKey = strings.TrimSpace(string(ssh.MarshalAuthorizedKey(key)))
+}
+
+// authorizeSSHKey adds the instance key to the authorized keys of root
+func (d *DinD) authorizeSSHKey(rt backend.Runtime, name string) error {
+	cmd := []string{"sh", "-c", `mkdir -p /root/.ssh && chmod 700 /root/.ssh && echo "$1" >> /root/.ssh/authorized_keys`, "sh", d.sshKey}
+	code, err := rt.Exec(name, cmd)
+	if err != nil {
+		return err
+	}
+	if code != 0 {
+		return fmt.Errorf("authorizing the SSH key exited with %d", code)
+	}
+	return nil
+}
+
 func checkHostnameExists(sessionId, hostname string, instances []*types.Instance) bool {
 	exists := false
 	for _, instance := range instances {
@@ -44,105 +80,23 @@ func checkHostnameExists(sessionId, hostname string, instances []*types.Instance
 }
 
 func (d *DinD) InstanceNew(session *types.Session, conf types.InstanceConfig) (*types.Instance, error) {
+	var lessonPolicy *netpolicy.Policy
 	// Check if a lesson context is provided and if it specifies a custom Docker image or containers
 	if conf.LessonCtx != nil && conf.LessonCtx.LessonID != "" {
 		// Get the lesson from storage
 		lessonData, err := d.storage.LessonGet(conf.LessonCtx.LessonID)
 		if err == nil && lessonData != nil {
-			// Check if the current step specifies containers or a custom Docker image
-			if conf.LessonCtx.StepIndex >= 0 && conf.LessonCtx.StepIndex < len(lessonData.Steps) {
-				currentStep := lessonData.Steps[conf.LessonCtx.StepIndex]
-
-				// Check if the step has multiple containers defined
-				if len(currentStep.Containers) > 0 {
-					// For now, we only support creating the primary container
-					// Multi-container support will be implemented in a future update
-
-					// Find the primary container (role="primary" or first container if no primary is specified)
-					var primaryContainer *lesson.ContainerConfig
-					for i := range currentStep.Containers {
-						if currentStep.Containers[i].Role == "primary" {
-							primaryContainer = &currentStep.Containers[i]
-							break
-						}
-					}
-
-					// If no primary container was found, use the first container
-					if primaryContainer == nil && len(currentStep.Containers) > 0 {
-						primaryContainer = &currentStep.Containers[0]
-					}
-
-					// Use the primary container's image if found
-					if primaryContainer != nil {
-						conf.ImageName = primaryContainer.Image
-
-						// Apply container-specific resource limits if specified
-						if primaryContainer.MaxProcesses > 0 {
-							conf.MaxProcesses = primaryContainer.MaxProcesses
-						}
-						if primaryContainer.MaxMemoryMB > 0 {
-							conf.MaxMemoryMB = primaryContainer.MaxMemoryMB
-						}
-						if primaryContainer.StorageSize != "" {
-							conf.StorageSize = primaryContainer.StorageSize
-						}
-
-						// Apply container-specific hostname if specified
-						if primaryContainer.Hostname != "" {
-							conf.Hostname = primaryContainer.Hostname
-						}
-
-						// Apply container-specific environment variables if specified
-						if len(primaryContainer.Envs) > 0 {
-							conf.Envs = append(conf.Envs, primaryContainer.Envs...)
-						}
-
-						// Apply container-specific networks if specified
-						if len(primaryContainer.Networks) > 0 {
-							conf.Networks = append(conf.Networks, primaryContainer.Networks...)
-						}
-
-						log.Printf("NewInstance - using container-specific image: [%s]\n", conf.ImageName)
-					}
-				} else if currentStep.Image != "" {
-					// Fall back to the step's Image field for backward compatibility
-					conf.ImageName = currentStep.Image
-					log.Printf("NewInstance - using step-specific image: [%s]\n", conf.ImageName)
-				} else if lessonData.DefaultImage != "" {
-					// Use the lesson's default image if the step doesn't specify one
-					conf.ImageName = lessonData.DefaultImage
-					log.Printf("NewInstance - using lesson default image: [%s]\n", conf.ImageName)
-				}
-
-				// Apply step-specific resource limits if not already set by a container config
-				if conf.MaxProcesses == 0 && currentStep.MaxProcesses > 0 {
-					conf.MaxProcesses = currentStep.MaxProcesses
-				}
-				if conf.MaxMemoryMB == 0 && currentStep.MaxMemoryMB > 0 {
-					conf.MaxMemoryMB = currentStep.MaxMemoryMB
-				}
-				if conf.StorageSize == "" && currentStep.StorageSize != "" {
-					conf.StorageSize = currentStep.StorageSize
-				}
-			} else if lessonData.DefaultImage != "" {
-				// Use the lesson's default image if no step is specified
-				conf.ImageName = lessonData.DefaultImage
-				log.Printf("NewInstance - using lesson default image: [%s]\n", conf.ImageName)
-			}
-
-			// Apply lesson-level default resource limits if not already set
-			if conf.MaxProcesses == 0 && lessonData.DefaultMaxProcesses > 0 {
-				conf.MaxProcesses = lessonData.DefaultMaxProcesses
-			}
-			if conf.MaxMemoryMB == 0 && lessonData.DefaultMaxMemoryMB > 0 {
-				conf.MaxMemoryMB = lessonData.DefaultMaxMemoryMB
-			}
-			if conf.StorageSize == "" && lessonData.DefaultStorageSize != "" {
-				conf.StorageSize = lessonData.DefaultStorageSize
-			}
+			lessonPolicy = lessonData.NetworkPolicy
+			applyLesson(&conf, lessonData)
 		}
 	}
 
+	// Restored instances start from their snapshot whatever the lesson image is
+	if conf.SnapshotImage != "" {
+		conf.ImageName = conf.SnapshotImage
+		log.Printf("NewInstance - restoring snapshot image: [%s]\n", conf.ImageName)
+	}
+
 	// Fall back to playground default if no image is specified
 	if conf.ImageName == "" {
 		playground, err := d.storage.PlaygroundGet(session.PlaygroundId)
@@ -176,10 +130,10 @@ func (d *DinD) InstanceNew(session *types.Session, conf types.InstanceConfig) (*
 	}
 
 	containerName := fmt.Sprintf("%s_%s", session.Id[:8], d.generator.NewId())
-	opts := docker.CreateContainerOpts{
+	opts := backend.CreateOpts{
 		Image:          conf.ImageName,
 		SessionId:      session.Id,
-		ContainerName:  containerName,
+		Name:           containerName,
 		Hostname:       conf.Hostname,
 		ServerCert:     conf.ServerCert,
 		ServerKey:      conf.ServerKey,
@@ -189,28 +143,68 @@ func (d *DinD) InstanceNew(session *types.Session, conf types.InstanceConfig) (*
 		Networks:       networks,
 		DindVolumeSize: conf.DindVolumeSize,
 		Envs:           conf.Envs,
+		MaxProcesses:   conf.MaxProcesses,
+		MaxMemoryMB:    conf.MaxMemoryMB,
+		StorageSize:    conf.StorageSize,
+		MaxCPUs:        conf.MaxCPUs,
+	}
+
+	policy := netpolicy.Merge(session.NetworkPolicy, lessonPolicy)
+	if policy != nil && len(policy.DNSAllow) > 0 {
+		// The router only resolves the allowed domains, and needs the label to
+		// know which ones
+		opts.Labels = map[string]string{netpolicy.DNSAllowLabel: strings.Join(policy.DNSAllow, ",")}
+		if session.PwdIpAddress != "" {
+			opts.DNS = []string{session.PwdIpAddress}
+		}
 	}
 
-	dockerClient, err := d.factory.GetForSession(session)
+	rt, err := d.factory.GetForSession(session)
 	if err != nil {
 		return nil, err
 	}
-	if err := dockerClient.ContainerCreate(opts); err != nil {
-		return nil, err
+
+	var ip string
+	claimed := false
+	// Warm containers were created without the DNS settings of the policy
+	if d.pool != nil && opts.Labels == nil {
+		ip, claimed = d.pool.Claim(rt, session, conf, containerName)
+	}
+	if !claimed {
+		ips, err := rt.Create(opts)
+		if err != nil {
+			return nil, err
+		}
+		ip = ips[session.Id]
 	}
 
-	ips, err := dockerClient.ContainerIPs(containerName)
-	if err != nil {
-		return nil, err
+	if d.sshKey != "" {
+		if err := d.authorizeSSHKey(rt, containerName); err != nil {
+			// Only SSH logins through the l2 router depend on it
+			log.Printf("Could not authorize the SSH key on instance [%s]: %v\n", containerName, err)
+		}
+	}
+
+	if policy.Restricted() {
+		if err := rt.SetEgressPolicy(containerName, policy.EgressRules(session.PwdIpAddress)); err != nil {
+			// Instances are never left running without their policy
+			log.Printf("Could not apply the network policy of instance [%s]: %v\n", containerName, err)
+			rt.Delete(containerName)
+			return nil, err
+		}
+	} else {
+		policy = nil
 	}
 
 	instance := &types.Instance{}
 	instance.Image = opts.Image
-	instance.IP = ips[session.Id]
+	instance.IP = ip
 	instance.RoutableIP = instance.IP
 	instance.SessionId = session.Id
 	instance.Name = containerName
 	instance.Hostname = conf.Hostname
+	instance.Role = conf.Role
+	instance.Ports = conf.Ports
 	instance.Cert = conf.Cert
 	instance.Key = conf.Key
 	instance.ServerCert = conf.ServerCert
@@ -219,10 +213,115 @@ func (d *DinD) InstanceNew(session *types.Session, conf types.InstanceConfig) (*
 	instance.Tls = conf.Tls
 	instance.ProxyHost = router.EncodeHost(session.Id, instance.RoutableIP, router.HostOpts{})
 	instance.SessionHost = session.Host
+	instance.LessonCtx = conf.LessonCtx
+	instance.NetworkPolicy = policy
 
 	return instance, nil
 }
 
+// applyLesson sets the image, resource limits and container settings of the
+// lesson step of conf, falling back to the defaults of the lesson
+func applyLesson(conf *types.InstanceConfig, lessonData *lesson.Lesson) {
+	// Check if the current step specifies containers or a custom Docker image
+	if conf.LessonCtx.StepIndex >= 0 && conf.LessonCtx.StepIndex < len(lessonData.Steps) {
+		currentStep := lessonData.Steps[conf.LessonCtx.StepIndex]
+
+		// Check if the step has multiple containers defined
+		if len(currentStep.Containers) > 0 {
+			// For now, we only support creating the primary container
+			// Multi-container support will be implemented in a future update
+
+			// Find the primary container (role="primary" or first container if no primary is specified)
+			var primaryContainer *lesson.ContainerConfig
+			for i := range currentStep.Containers {
+				if currentStep.Containers[i].Role == "primary" {
+					primaryContainer = &currentStep.Containers[i]
+					break
+				}
+			}
+
+			// If no primary container was found, use the first container
+			if primaryContainer == nil && len(currentStep.Containers) > 0 {
+				primaryContainer = &currentStep.Containers[0]
+			}
+
+			// Use the primary container's image if found
+			if primaryContainer != nil {
+				conf.ImageName = primaryContainer.Image
+
+				// Apply container-specific resource limits if specified
+				if primaryContainer.MaxProcesses > 0 {
+					conf.MaxProcesses = primaryContainer.MaxProcesses
+				}
+				if primaryContainer.MaxMemoryMB > 0 {
+					conf.MaxMemoryMB = primaryContainer.MaxMemoryMB
+				}
+				if primaryContainer.StorageSize != "" {
+					conf.StorageSize = primaryContainer.StorageSize
+				}
+
+				// Apply container-specific hostname if specified
+				if primaryContainer.Hostname != "" {
+					conf.Hostname = primaryContainer.Hostname
+				}
+
+				if primaryContainer.Role != "" {
+					conf.Role = primaryContainer.Role
+				}
+				if len(primaryContainer.Ports) > 0 {
+					conf.Ports = primaryContainer.Ports
+				}
+
+				// Apply container-specific environment variables if specified
+				if len(primaryContainer.Envs) > 0 {
+					conf.Envs = append(conf.Envs, primaryContainer.Envs...)
+				}
+
+				// Apply container-specific networks if specified
+				if len(primaryContainer.Networks) > 0 {
+					conf.Networks = append(conf.Networks, primaryContainer.Networks...)
+				}
+
+				log.Printf("NewInstance - using container-specific image: [%s]\n", conf.ImageName)
+			}
+		} else if currentStep.Image != "" {
+			// Fall back to the step's Image field for backward compatibility
+			conf.ImageName = currentStep.Image
+			log.Printf("NewInstance - using step-specific image: [%s]\n", conf.ImageName)
+		} else if lessonData.DefaultImage != "" {
+			// Use the lesson's default image if the step doesn't specify one
+			conf.ImageName = lessonData.DefaultImage
+			log.Printf("NewInstance - using lesson default image: [%s]\n", conf.ImageName)
+		}
+
+		// Apply step-specific resource limits if not already set by a container config
+		if conf.MaxProcesses == 0 && currentStep.MaxProcesses > 0 {
+			conf.MaxProcesses = currentStep.MaxProcesses
+		}
+		if conf.MaxMemoryMB == 0 && currentStep.MaxMemoryMB > 0 {
+			conf.MaxMemoryMB = currentStep.MaxMemoryMB
+		}
+		if conf.StorageSize == "" && currentStep.StorageSize != "" {
+			conf.StorageSize = currentStep.StorageSize
+		}
+	} else if lessonData.DefaultImage != "" {
+		// Use the lesson's default image if no step is specified
+		conf.ImageName = lessonData.DefaultImage
+		log.Printf("NewInstance - using lesson default image: [%s]\n", conf.ImageName)
+	}
+
+	// Apply lesson-level default resource limits if not already set
+	if conf.MaxProcesses == 0 && lessonData.DefaultMaxProcesses > 0 {
+		conf.MaxProcesses = lessonData.DefaultMaxProcesses
+	}
+	if conf.MaxMemoryMB == 0 && lessonData.DefaultMaxMemoryMB > 0 {
+		conf.MaxMemoryMB = lessonData.DefaultMaxMemoryMB
+	}
+	if conf.StorageSize == "" && lessonData.DefaultStorageSize != "" {
+		conf.StorageSize = lessonData.DefaultStorageSize
+	}
+}
+
 func (d *DinD) getSession(sessionId string) (*types.Session, error) {
 	var session *types.Session
 	if s, found := d.cache.Get(sessionId); !found {
@@ -239,14 +338,18 @@ func (d *DinD) getSession(sessionId string) (*types.Session, error) {
 }
 
 func (d *DinD) InstanceDelete(session *types.Session, instance *types.Instance) error {
-	dockerClient, err := d.factory.GetForSession(session)
+	rt, err := d.factory.GetForSession(session)
 	if err != nil {
 		return err
 	}
-	err = dockerClient.ContainerDelete(instance.Name)
-	if err != nil && !strings.Contains(err.Error(), "No such container") {
+	if err := rt.Delete(instance.Name); err != nil {
 		return err
 	}
+	if instance.NetworkPolicy != nil {
+		if err := rt.SetEgressPolicy(instance.Name, nil); err != nil {
+			log.Printf("Could not remove the network policy of instance [%s]: %v\n", instance.Name, err)
+		}
+	}
 	return nil
 }
 
@@ -255,11 +358,11 @@ func (d *DinD) InstanceExec(instance *types.Instance, cmd []string) (int, error)
 	if err != nil {
 		return -1, err
 	}
-	dockerClient, err := d.factory.GetForSession(session)
+	rt, err := d.factory.GetForSession(session)
 	if err != nil {
 		return -1, err
 	}
-	return dockerClient.Exec(instance.Name, cmd)
+	return rt.Exec(instance.Name, cmd)
 }
 
 func (d *DinD) InstanceFSTree(instance *types.Instance) (io.Reader, error) {
@@ -267,13 +370,13 @@ func (d *DinD) InstanceFSTree(instance *types.Instance) (io.Reader, error) {
 	if err != nil {
 		return nil, err
 	}
-	dockerClient, err := d.factory.GetForSession(session)
+	rt, err := d.factory.GetForSession(session)
 	if err != nil {
 		return nil, err
 	}
 	b := bytes.NewBuffer([]byte{})
 
-	if c, err := dockerClient.ExecAttach(instance.Name, []string{"bash", "-c", `tree --noreport -J $HOME`}, b); c > 0 {
+	if c, err := rt.ExecAttach(instance.Name, []string{"bash", "-c", `tree --noreport -J $HOME`}, b); c > 0 {
 		log.Println(b.String())
 		return nil, fmt.Errorf("Error %d trying list directories", c)
 	} else if err != nil {
@@ -288,12 +391,133 @@ func (d *DinD) InstanceFile(instance *types.Instance, filePath string) (io.Reade
 	if err != nil {
 		return nil, err
 	}
-	dockerClient, err := d.factory.GetForSession(session)
+	rt, err := d.factory.GetForSession(session)
 	if err != nil {
 		return nil, err
 	}
 
-	return dockerClient.CopyFromContainer(instance.Name, filePath)
+	return rt.CopyFrom(instance.Name, filePath)
+}
+
+func (d *DinD) InstanceSnapshot(instance *types.Instance, image string) (*types.InstanceSnapshot, error) {
+	session, err := d.getSession(instance.SessionId)
+	if err != nil {
+		return nil, err
+	}
+	rt, err := d.factory.GetForSession(session)
+	if err != nil {
+		return nil, err
+	}
+
+	snapshot := &types.InstanceSnapshot{Image: image, Type: instance.Type}
+
+	// The inner docker daemon keeps its data in a volume the commit does not
+	// include, so only record which images it had
+	b := bytes.NewBuffer([]byte{})
+	if c, err := rt.ExecAttach(instance.Name, []string{"docker", "image", "ls", "--format", "{{.Repository}}:{{.Tag}}"}, b); err != nil || c > 0 {
+		log.Printf("Could not list inner images of instance [%s]: %d %v\n", instance.Name, c, err)
+	} else {
+		for _, line := range strings.Split(b.String(), "\n") {
+			line = strings.TrimSpace(line)
+			if line == "" || strings.Contains(line, "<none>") {
+				continue
+			}
+			snapshot.InnerImages = append(snapshot.InnerImages, line)
+		}
+	}
+
+	labels := map[string]string{
+		"lessoncraft.snapshot.session":  instance.SessionId,
+		"lessoncraft.snapshot.instance": instance.Name,
+	}
+	size, err := rt.Commit(instance.Name, image, labels)
+	if err == backend.ErrNotSupported {
+		return nil, SnapshotNotSupportedError
+	} else if err != nil {
+		return nil, err
+	}
+	snapshot.Size = size
+
+	return snapshot, nil
+}
+
+func (d *DinD) InstanceSnapshotDelete(session *types.Session, snapshot *types.InstanceSnapshot) error {
+	rt, err := d.factory.GetForSession(session)
+	if err != nil {
+		return err
+	}
+	err = rt.ImageDelete(snapshot.Image)
+	if err == backend.ErrNotSupported {
+		return SnapshotNotSupportedError
+	}
+	return err
+}
+
+func (d *DinD) InstancePause(session *types.Session, instance *types.Instance) error {
+	rt, err := d.factory.GetForSession(session)
+	if err != nil {
+		return err
+	}
+	err = rt.Pause(instance.Name)
+	if err == backend.ErrNotSupported {
+		return PauseNotSupportedError
+	}
+	return err
+}
+
+func (d *DinD) InstanceResume(session *types.Session, instance *types.Instance) error {
+	rt, err := d.factory.GetForSession(session)
+	if err != nil {
+		return err
+	}
+	err = rt.Unpause(instance.Name)
+	if err == backend.ErrNotSupported {
+		return PauseNotSupportedError
+	}
+	return err
+}
+
+func (d *DinD) InstanceStop(session *types.Session, instance *types.Instance) error {
+	rt, err := d.factory.GetForSession(session)
+	if err != nil {
+		return err
+	}
+	err = rt.Stop(instance.Name)
+	if err == backend.ErrNotSupported {
+		return PauseNotSupportedError
+	}
+	return err
+}
+
+func (d *DinD) InstanceStart(session *types.Session, instance *types.Instance) error {
+	rt, err := d.factory.GetForSession(session)
+	if err != nil {
+		return err
+	}
+	ips, err := rt.Start(instance.Name)
+	if err == backend.ErrNotSupported {
+		return PauseNotSupportedError
+	}
+	if err != nil {
+		return err
+	}
+
+	// Started containers may get new addresses, and the firewall rules of
+	// the policy match the old ones
+	if ip := ips[session.Id]; ip != "" && ip != instance.IP {
+		instance.IP = ip
+		instance.RoutableIP = ip
+		instance.ProxyHost = router.EncodeHost(session.Id, ip, router.HostOpts{})
+	}
+	if instance.NetworkPolicy.Restricted() {
+		if err := rt.SetEgressPolicy(instance.Name, instance.NetworkPolicy.EgressRules(session.PwdIpAddress)); err != nil {
+			// Instances are never left running without their policy
+			log.Printf("Could not apply the network policy of instance [%s]: %v\n", instance.Name, err)
+			rt.Stop(instance.Name)
+			return err
+		}
+	}
+	return nil
 }
 
 func (d *DinD) InstanceResizeTerminal(instance *types.Instance, rows, cols uint) error {
@@ -301,11 +525,11 @@ func (d *DinD) InstanceResizeTerminal(instance *types.Instance, rows, cols uint)
 	if err != nil {
 		return err
 	}
-	dockerClient, err := d.factory.GetForSession(session)
+	rt, err := d.factory.GetForSession(session)
 	if err != nil {
 		return err
 	}
-	return dockerClient.ContainerResize(instance.Name, rows, cols)
+	return rt.Resize(instance.Name, rows, cols)
 }
 
 func (d *DinD) InstanceGetTerminal(instance *types.Instance) (net.Conn, error) {
@@ -313,11 +537,11 @@ func (d *DinD) InstanceGetTerminal(instance *types.Instance) (net.Conn, error) {
 	if err != nil {
 		return nil, err
 	}
-	dockerClient, err := d.factory.GetForSession(session)
+	rt, err := d.factory.GetForSession(session)
 	if err != nil {
 		return nil, err
 	}
-	return dockerClient.CreateAttachConnection(instance.Name)
+	return rt.Attach(instance.Name)
 }
 
 func (d *DinD) InstanceUploadFromUrl(instance *types.Instance, fileName, dest, url string) error {
@@ -334,12 +558,12 @@ func (d *DinD) InstanceUploadFromUrl(instance *types.Instance, fileName, dest, u
 	if err != nil {
 		return err
 	}
-	dockerClient, err := d.factory.GetForSession(session)
+	rt, err := d.factory.GetForSession(session)
 	if err != nil {
 		return err
 	}
 
-	copyErr := dockerClient.CopyToContainer(instance.Name, dest, fileName, resp.Body)
+	copyErr := rt.CopyTo(instance.Name, dest, fileName, resp.Body)
 
 	if copyErr != nil {
 		return fmt.Errorf("Error while downloading file [%s]. Error: %s\n", url, copyErr)
@@ -353,13 +577,13 @@ func (d *DinD) getInstanceCWD(instance *types.Instance) (string, error) {
 	if err != nil {
 		return "", err
 	}
-	dockerClient, err := d.factory.GetForSession(session)
+	rt, err := d.factory.GetForSession(session)
 	if err != nil {
 		return "", err
 	}
 	b := bytes.NewBufferString("")
 
-	if c, err := dockerClient.ExecAttach(instance.Name, []string{"bash", "-c", `pwdx $(</var/run/cwd)`}, b); c > 0 {
+	if c, err := rt.ExecAttach(instance.Name, []string{"bash", "-c", `pwdx $(</var/run/cwd)`}, b); c > 0 {
 		return "", fmt.Errorf("Error %d trying to get CWD", c)
 	} else if err != nil {
 		return "", err
@@ -375,7 +599,7 @@ func (d *DinD) InstanceUploadFromReader(instance *types.Instance, fileName, dest
 	if err != nil {
 		return err
 	}
-	dockerClient, err := d.factory.GetForSession(session)
+	rt, err := d.factory.GetForSession(session)
 	if err != nil {
 		return err
 	}
@@ -390,7 +614,7 @@ func (d *DinD) InstanceUploadFromReader(instance *types.Instance, fileName, dest
 		}
 	}
 
-	copyErr := dockerClient.CopyToContainer(instance.Name, finalDest, fileName, reader)
+	copyErr := rt.CopyTo(instance.Name, finalDest, fileName, reader)
 
 	if copyErr != nil {
 		return fmt.Errorf("Error while uploading file [%s]. Error: %s\n", fileName, copyErr)
@@ -0,0 +1,215 @@
+package provisioner
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/ringo380/lessoncraft/pwd/types"
+)
+
+// maxFileMatches caps the lines a search returns
+const maxFileMatches = 500
+
+// fileChangedExitCode is what the write script exits with when the file no
+// longer has the expected checksum
+const fileChangedExitCode = 99
+
+// fileExistsExitCode is what the move script exits with when the destination
+// already exists
+const fileExistsExitCode = 98
+
+// writeScript checks the checksum of the file, when one is given, and copies
+// the uploaded content over it. The file is truncated rather than replaced,
+// so it keeps its mode and owner.
+var writeScript = fmt.Sprintf(`tmp="$1"; dst="$2"; sum="$3"
+if [ -n "$sum" ] && [ "$(sha256sum < "$dst" 2>/dev/null | cut -d' ' -f1)" != "$sum" ]; then
+	rm -f "$tmp"
+	exit %d
+fi
+cat "$tmp" > "$dst"; status=$?
+rm -f "$tmp"
+exit $status`, fileChangedExitCode)
+
+// moveScript refuses to overwrite the destination, which mv -n silently
+// skips
+var moveScript = fmt.Sprintf(`if [ -e "$2" ]; then
+	exit %d
+fi
+mv -- "$1" "$2"`, fileExistsExitCode)
+
+// fileExec runs a command on the files of an instance. Arguments are passed
+// to the command as they are, so paths are never interpreted by a shell.
+func (d *DinD) fileExec(instance *types.Instance, cmd ...string) (string, int, error) {
+	session, err := d.getSession(instance.SessionId)
+	if err != nil {
+		return "", -1, err
+	}
+	rt, err := d.factory.GetForSession(session)
+	if err != nil {
+		return "", -1, err
+	}
+	b := bytes.NewBuffer([]byte{})
+	c, err := rt.ExecAttach(instance.Name, cmd, b)
+	if err != nil {
+		return "", -1, err
+	}
+	return b.String(), c, nil
+}
+
+// fileOp runs a command changing the files of an instance, failing with what
+// it printed if it exits with an error
+func (d *DinD) fileOp(instance *types.Instance, cmd ...string) error {
+	out, c, err := d.fileExec(instance, cmd...)
+	if err != nil {
+		return err
+	}
+	if c > 0 {
+		return &FileError{Message: strings.TrimSpace(out)}
+	}
+	return nil
+}
+
+func (d *DinD) InstanceFileWrite(instance *types.Instance, filePath string, content io.Reader, checksum string) (string, error) {
+	session, err := d.getSession(instance.SessionId)
+	if err != nil {
+		return "", err
+	}
+	rt, err := d.factory.GetForSession(session)
+	if err != nil {
+		return "", err
+	}
+
+	h := sha256.New()
+	tmp := fmt.Sprintf(".lessoncraft-write-%s", d.generator.NewId())
+	if err := rt.CopyTo(instance.Name, "/tmp", tmp, io.TeeReader(content, h)); err != nil {
+		return "", err
+	}
+
+	out, c, err := d.fileExec(instance, "sh", "-c", writeScript, "sh", "/tmp/"+tmp, filePath, checksum)
+	if err != nil {
+		return "", err
+	}
+	if c == fileChangedExitCode {
+		return "", FileChangedError
+	} else if c > 0 {
+		return "", &FileError{Message: strings.TrimSpace(out)}
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func (d *DinD) InstanceFileMove(instance *types.Instance, from, to string) error {
+	out, c, err := d.fileExec(instance, "sh", "-c", moveScript, "sh", from, to)
+	if err != nil {
+		return err
+	}
+	if c == fileExistsExitCode {
+		return FileExistsError
+	} else if c > 0 {
+		return &FileError{Message: strings.TrimSpace(out)}
+	}
+	return nil
+}
+
+func (d *DinD) InstanceFileDelete(instance *types.Instance, filePath string) error {
+	return d.fileOp(instance, "rm", "-r", "--", filePath)
+}
+
+func (d *DinD) InstanceMkdir(instance *types.Instance, dirPath string) error {
+	return d.fileOp(instance, "mkdir", "-p", "--", dirPath)
+}
+
+func (d *DinD) InstanceChmod(instance *types.Instance, filePath string, mode os.FileMode) error {
+	return d.fileOp(instance, "chmod", strconv.FormatUint(uint64(mode.Perm()), 8), "--", filePath)
+}
+
+func (d *DinD) InstanceFileSearch(instance *types.Instance, dir, pattern string, regex bool) ([]types.FileMatch, error) {
+	syntax := "-F"
+	if regex {
+		syntax = "-E"
+	}
+	// File names end with a NUL, so they may contain colons. grep exits with 1
+	// when nothing matches and with 2 on errors, e.g. an invalid pattern or an
+	// unreadable file, which only fail the search when nothing matched.
+	out, c, err := d.fileExec(instance, "grep", "-rnIZ", syntax, "-e", pattern, "--", dir)
+	if err != nil {
+		return nil, err
+	}
+	matches := parseFileMatches(out)
+	if c > 1 && len(matches) == 0 {
+		return nil, &FileError{Message: strings.TrimSpace(out)}
+	}
+	return matches, nil
+}
+
+func (d *DinD) InstanceArchive(instance *types.Instance, dir string) (io.ReadCloser, error) {
+	if dir == "" {
+		out, c, err := d.fileExec(instance, "sh", "-c", "echo $HOME")
+		if err != nil {
+			return nil, err
+		}
+		if c > 0 || strings.TrimSpace(out) == "" {
+			return nil, fmt.Errorf("Error %d trying to find the home directory", c)
+		}
+		dir = strings.TrimSpace(out)
+	}
+	// Missing directories are told apart from failures of the runtime
+	if err := d.fileOp(instance, "ls", "-d", "--", dir); err != nil {
+		return nil, err
+	}
+
+	session, err := d.getSession(instance.SessionId)
+	if err != nil {
+		return nil, err
+	}
+	rt, err := d.factory.GetForSession(session)
+	if err != nil {
+		return nil, err
+	}
+	return rt.CopyTarFrom(instance.Name, dir)
+}
+
+func (d *DinD) InstanceExtract(instance *types.Instance, dir string, archive io.Reader) error {
+	if err := d.InstanceMkdir(instance, dir); err != nil {
+		return err
+	}
+	session, err := d.getSession(instance.SessionId)
+	if err != nil {
+		return err
+	}
+	rt, err := d.factory.GetForSession(session)
+	if err != nil {
+		return err
+	}
+	return rt.CopyTarTo(instance.Name, dir, archive)
+}
+
+// parseFileMatches parses the output of grep -nZ, one match per line as
+// path\0line:text
+func parseFileMatches(out string) []types.FileMatch {
+	matches := []types.FileMatch{}
+	for _, line := range strings.Split(out, "\n") {
+		parts := strings.SplitN(line, "\x00", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		rest := strings.SplitN(parts[1], ":", 2)
+		if len(rest) != 2 {
+			continue
+		}
+		n, err := strconv.Atoi(rest[0])
+		if err != nil {
+			continue
+		}
+		matches = append(matches, types.FileMatch{Path: parts[0], Line: n, Text: rest[1]})
+		if len(matches) == maxFileMatches {
+			break
+		}
+	}
+	return matches
+}
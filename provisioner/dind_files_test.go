@@ -0,0 +1,67 @@
+package provisioner
+
+import (
+	"io"
+	"testing"
+
+	"github.com/ringo380/lessoncraft/docker"
+	"github.com/ringo380/lessoncraft/id"
+	"github.com/ringo380/lessoncraft/pwd/types"
+	"github.com/ringo380/lessoncraft/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestParseFileMatches(t *testing.T) {
+	out := "/root/app.go\x0012:func main() {\n" +
+		"/root/a:b.txt\x003:key: value\n" +
+		"grep: /root/secret: Permission denied\n"
+	assert.Equal(t, []types.FileMatch{
+		{Path: "/root/app.go", Line: 12, Text: "func main() {"},
+		{Path: "/root/a:b.txt", Line: 3, Text: "key: value"},
+	}, parseFileMatches(out))
+	assert.Empty(t, parseFileMatches(""))
+}
+
+func TestDinD_InstanceFileSearch(t *testing.T) {
+	_d := &docker.Mock{}
+	_f := &docker.FactoryMock{}
+	_s := &storage.Mock{}
+
+	session := &types.Session{Id: "aaaabbbbcccc"}
+	instance := &types.Instance{Name: "aaaabbbb_node1", SessionId: session.Id}
+	_s.On("SessionGet", session.Id).Return(session, nil)
+	_f.On("GetForSession", session).Return(_d, nil)
+	search := func(pattern string, out string, code int) {
+		cmd := []string{"grep", "-rnIZ", "-E", "-e", pattern, "--", "/root"}
+		_d.On("ExecAttach", instance.Name, cmd, mock.Anything).Run(func(args mock.Arguments) {
+			args.Get(2).(io.Writer).Write([]byte(out))
+		}).Return(code, nil).Once()
+	}
+	d := NewDinD(&id.MockGenerator{}, _f, _s)
+
+	search("main", "/root/app.go\x0012:func main() {\n", 0)
+	matches, err := d.InstanceFileSearch(instance, "/root", "main", true)
+	assert.Nil(t, err)
+	assert.Equal(t, []types.FileMatch{{Path: "/root/app.go", Line: 12, Text: "func main() {"}}, matches)
+
+	// Nothing matching is not an error
+	search("nothing", "", 1)
+	matches, err = d.InstanceFileSearch(instance, "/root", "nothing", true)
+	assert.Nil(t, err)
+	assert.Empty(t, matches)
+
+	// Unreadable files do not hide the matches of the others
+	search("main", "/root/app.go\x0012:func main() {\ngrep: /root/secret: Permission denied\n", 2)
+	matches, err = d.InstanceFileSearch(instance, "/root", "main", true)
+	assert.Nil(t, err)
+	assert.Len(t, matches, 1)
+
+	search("(", "grep: Unmatched ( or \\(\n", 2)
+	_, err = d.InstanceFileSearch(instance, "/root", "(", true)
+	var fileErr *FileError
+	assert.ErrorAs(t, err, &fileErr)
+	assert.Equal(t, "grep: Unmatched ( or \\(", fileErr.Message)
+
+	_d.AssertExpectations(t)
+}
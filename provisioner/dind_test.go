@@ -0,0 +1,117 @@
+package provisioner
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"testing"
+
+	"github.com/ringo380/lessoncraft/docker"
+	"github.com/ringo380/lessoncraft/id"
+	"github.com/ringo380/lessoncraft/pwd/types"
+	"github.com/ringo380/lessoncraft/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestDinD_PauseResume(t *testing.T) {
+	_d := &docker.Mock{}
+	_f := &docker.FactoryMock{}
+	_s := &storage.Mock{}
+	_g := &id.MockGenerator{}
+
+	session := &types.Session{Id: "aaaabbbbcccc", PlaygroundId: "p1"}
+	_f.On("GetForSession", session).Return(_d, nil)
+	_g.On("NewId").Return("ddddeeeeffff")
+	_s.On("PlaygroundGet", "p1").Return(&types.Playground{Id: "p1", DefaultDinDInstanceImage: "franela/dind"}, nil)
+	_s.On("InstanceFindBySessionId", session.Id).Return([]*types.Instance{}, nil)
+	_d.On("ContainerCreate", mock.AnythingOfType("docker.CreateContainerOpts")).Return(nil)
+	_d.On("ContainerIPs", "aaaabbbb_ddddeeeeffff").Return(map[string]string{session.Id: "10.0.0.1"}, nil)
+
+	d := NewDinD(_g, _f, _s)
+	instance, err := d.InstanceNew(session, types.InstanceConfig{})
+	assert.Nil(t, err)
+
+	_d.On("ContainerPause", instance.Name).Return(nil)
+	_d.On("ContainerUnpause", instance.Name).Return(nil)
+	assert.Nil(t, d.InstancePause(session, instance))
+	assert.Nil(t, d.InstanceResume(session, instance))
+
+	// The container is frozen rather than stopped, so it is not removed and
+	// keeps its address
+	_d.On("Exec", instance.Name, []string{"true"}).Return(0, nil)
+	_s.On("SessionGet", session.Id).Return(session, nil)
+	code, err := d.InstanceExec(instance, []string{"true"})
+	assert.Nil(t, err)
+	assert.Equal(t, 0, code)
+	assert.Equal(t, "10.0.0.1", instance.IP)
+
+	_d.AssertNumberOfCalls(t, "ContainerCreate", 1)
+	_d.AssertNotCalled(t, "ContainerDelete", mock.Anything)
+	_d.AssertExpectations(t)
+}
+
+func TestDinD_StopStart(t *testing.T) {
+	_d := &docker.Mock{}
+	_f := &docker.FactoryMock{}
+	_s := &storage.Mock{}
+	_g := &id.MockGenerator{}
+
+	session := &types.Session{Id: "aaaabbbbcccc", PlaygroundId: "p1"}
+	_f.On("GetForSession", session).Return(_d, nil)
+	_g.On("NewId").Return("ddddeeeeffff")
+	_s.On("PlaygroundGet", "p1").Return(&types.Playground{Id: "p1", DefaultDinDInstanceImage: "franela/dind"}, nil)
+	_s.On("InstanceFindBySessionId", session.Id).Return([]*types.Instance{}, nil)
+	_d.On("ContainerCreate", mock.AnythingOfType("docker.CreateContainerOpts")).Return(nil)
+	_d.On("ContainerIPs", "aaaabbbb_ddddeeeeffff").Return(map[string]string{session.Id: "10.0.0.1"}, nil).Once()
+
+	d := NewDinD(_g, _f, _s)
+	instance, err := d.InstanceNew(session, types.InstanceConfig{})
+	assert.Nil(t, err)
+
+	_d.On("ContainerStop", instance.Name).Return(nil)
+	assert.Nil(t, d.InstanceStop(session, instance))
+
+	// The same container is started again, and may get a new address
+	_d.On("ContainerStart", instance.Name).Return(nil)
+	_d.On("ContainerIPs", instance.Name).Return(map[string]string{session.Id: "10.0.0.7"}, nil).Once()
+	assert.Nil(t, d.InstanceStart(session, instance))
+	assert.Equal(t, "10.0.0.7", instance.IP)
+	assert.Equal(t, "10.0.0.7", instance.RoutableIP)
+	assert.Contains(t, instance.ProxyHost, "10-0-0-7")
+
+	_d.AssertNumberOfCalls(t, "ContainerCreate", 1)
+	_d.AssertNotCalled(t, "ContainerDelete", mock.Anything)
+	_d.AssertExpectations(t)
+}
+
+func TestDinD_InstanceSSHKey(t *testing.T) {
+	_d := &docker.Mock{}
+	_f := &docker.FactoryMock{}
+	_s := &storage.Mock{}
+	_g := &id.MockGenerator{}
+
+	session := &types.Session{Id: "aaaabbbbcccc", PlaygroundId: "p1"}
+	_f.On("GetForSession", session).Return(_d, nil)
+	_g.On("NewId").Return("ddddeeeeffff")
+	_s.On("PlaygroundGet", "p1").Return(&types.Playground{Id: "p1", DefaultDinDInstanceImage: "franela/dind"}, nil)
+	_s.On("InstanceFindBySessionId", session.Id).Return([]*types.Instance{}, nil)
+	_d.On("ContainerCreate", mock.AnythingOfType("docker.CreateContainerOpts")).Return(nil)
+	_d.On("ContainerIPs", "aaaabbbb_ddddeeeeffff").Return(map[string]string{session.Id: "10.0.0.1"}, nil)
+
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	assert.Nil(t, err)
+	key, err := ssh.NewPublicKey(pub)
+	assert.Nil(t, err)
+	authorized := string(ssh.MarshalAuthorizedKey(key))
+	_d.On("Exec", "aaaabbbb_ddddeeeeffff", mock.MatchedBy(func(cmd []string) bool {
+		return len(cmd) == 5 && cmd[4]+"\n" == authorized
+	})).Return(0, nil)
+
+	d := NewDinD(_g, _f, _s)
+	d.SetInstanceSSHKey(key)
+	_, err = d.InstanceNew(session, types.InstanceConfig{})
+	assert.Nil(t, err)
+
+	_d.AssertExpectations(t)
+}
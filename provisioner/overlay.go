@@ -2,14 +2,14 @@ package provisioner
 
 import (
 	"context"
-	"fmt"
 	"log"
 	"net/url"
 	"strings"
 
-	dtypes "github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/network"
 	"github.com/ringo380/lessoncraft/config"
 	"github.com/ringo380/lessoncraft/docker"
+	"github.com/ringo380/lessoncraft/netpolicy"
 	"github.com/ringo380/lessoncraft/pwd/types"
 )
 
@@ -21,12 +21,33 @@ func NewOverlaySessionProvisioner(df docker.FactoryApi) SessionProvisionerApi {
 	return &overlaySessionProvisioner{dockerFactory: df}
 }
 
+// maxPlacementAttempts is how many hosts a session is tried on when the
+// docker factory spreads sessions across several of them
+const maxPlacementAttempts = 3
+
 func (p *overlaySessionProvisioner) SessionNew(ctx context.Context, s *types.Session) error {
-	dockerClient, err := p.dockerFactory.GetForSession(s)
-	if err != nil {
-		// We assume we are out of capacity
-		return fmt.Errorf("Out of capacity")
+	placer, multiHost := p.dockerFactory.(docker.SessionPlacer)
+	for attempt := 1; ; attempt++ {
+		dockerClient, err := p.dockerFactory.GetForSession(s)
+		if err != nil {
+			// We assume we are out of capacity
+			log.Println(err)
+			return OutOfCapacityError
+		}
+		err = p.setup(dockerClient, s)
+		if err == nil {
+			return nil
+		}
+		if !multiHost || attempt == maxPlacementAttempts {
+			return err
+		}
+		log.Printf("Could not set up session [%s] on [%s], trying another host: %v\n", s.Id, s.Host, err)
+		placer.RejectSession(s)
+		s.Host = ""
 	}
+}
+
+func (p *overlaySessionProvisioner) setup(dockerClient docker.DockerApi, s *types.Session) error {
 	u, _ := url.Parse(dockerClient.DaemonHost())
 	if u.Host == "" {
 		s.Host = "localhost"
@@ -35,7 +56,14 @@ func (p *overlaySessionProvisioner) SessionNew(ctx context.Context, s *types.Ses
 		s.Host = chunks[0]
 	}
 
-	opts := dtypes.NetworkCreate{Driver: "overlay", Attachable: true}
+	opts := network.CreateOptions{Driver: "overlay", Attachable: true}
+	if s.NetworkPolicy.NoEgress() {
+		// Internal networks have no route out, so nothing needs to be filtered
+		opts.Internal = true
+	}
+	if s.NetworkPolicy != nil && len(s.NetworkPolicy.DNSAllow) > 0 {
+		opts.Labels = map[string]string{netpolicy.DNSAllowLabel: strings.Join(s.NetworkPolicy.DNSAllow, ",")}
+	}
 	if err := dockerClient.NetworkCreate(s.Id, opts); err != nil {
 		log.Println("ERROR NETWORKING", err)
 		return err
@@ -45,12 +73,16 @@ func (p *overlaySessionProvisioner) SessionNew(ctx context.Context, s *types.Ses
 	ip, err := dockerClient.NetworkConnect(config.L2ContainerName, s.Id, s.PwdIpAddress)
 	if err != nil {
 		log.Println(err)
+		if err := dockerClient.NetworkDelete(s.Id); err != nil {
+			log.Printf("Could not delete network [%s]: %v\n", s.Id, err)
+		}
 		return err
 	}
 	s.PwdIpAddress = ip
 	log.Printf("Connected %s to network [%s]\n", config.PWDContainerName, s.Id)
 	return nil
 }
+
 func (p *overlaySessionProvisioner) SessionClose(s *types.Session) error {
 	// Disconnect L2 router from the network
 	dockerClient, err := p.dockerFactory.GetForSession(s)
@@ -72,5 +104,8 @@ func (p *overlaySessionProvisioner) SessionClose(s *types.Session) error {
 		}
 	}
 
+	if placer, ok := p.dockerFactory.(docker.SessionPlacer); ok {
+		placer.ReleaseSession(s)
+	}
 	return nil
 }
@@ -5,6 +5,7 @@ import (
 	"errors"
 	"io"
 	"net"
+	"os"
 
 	"github.com/ringo380/lessoncraft/pwd/types"
 )
@@ -15,6 +16,50 @@ func OutOfCapacity(e error) bool {
 	return e == OutOfCapacityError
 }
 
+var SnapshotNotSupportedError = errors.New("SnapshotNotSupported")
+
+func SnapshotNotSupported(e error) bool {
+	return e == SnapshotNotSupportedError
+}
+
+var PauseNotSupportedError = errors.New("PauseNotSupported")
+
+func PauseNotSupported(e error) bool {
+	return e == PauseNotSupportedError
+}
+
+var FileOpsNotSupportedError = errors.New("FileOpsNotSupported")
+
+func FileOpsNotSupported(e error) bool {
+	return e == FileOpsNotSupportedError
+}
+
+// FileChangedError is returned when a file is written with the checksum of
+// content it no longer has, e.g. because it was edited in a terminal
+var FileChangedError = errors.New("FileChanged")
+
+func FileChanged(e error) bool {
+	return e == FileChangedError
+}
+
+// FileExistsError is returned when a file is moved onto a path that already
+// exists
+var FileExistsError = errors.New("FileExists")
+
+func FileExists(e error) bool {
+	return e == FileExistsError
+}
+
+// FileError is a file operation an instance refused, e.g. because the file
+// does not exist. Message is what the instance said.
+type FileError struct {
+	Message string
+}
+
+func (e *FileError) Error() string {
+	return e.Message
+}
+
 type InstanceProvisionerApi interface {
 	InstanceNew(session *types.Session, conf types.InstanceConfig) (*types.Instance, error)
 	InstanceDelete(session *types.Session, instance *types.Instance) error
@@ -22,11 +67,47 @@ type InstanceProvisionerApi interface {
 	InstanceFSTree(instance *types.Instance) (io.Reader, error)
 	InstanceFile(instance *types.Instance, filePath string) (io.Reader, error)
 
+	// InstanceFileWrite replaces the content of a file, keeping its mode. With
+	// a checksum, the file is only written if its content still has that
+	// SHA-256, FileChangedError otherwise. It returns the checksum of the new
+	// content.
+	InstanceFileWrite(instance *types.Instance, filePath string, content io.Reader, checksum string) (string, error)
+	// InstanceFileMove never overwrites the destination; it returns
+	// FileExistsError if the destination exists.
+	InstanceFileMove(instance *types.Instance, from, to string) error
+	InstanceFileDelete(instance *types.Instance, filePath string) error
+	InstanceMkdir(instance *types.Instance, dirPath string) error
+	InstanceChmod(instance *types.Instance, filePath string, mode os.FileMode) error
+	// InstanceFileSearch looks for lines containing pattern in the files
+	// under dir, as a regular expression if regex is set
+	InstanceFileSearch(instance *types.Instance, dir, pattern string, regex bool) ([]types.FileMatch, error)
+	// InstanceArchive returns the files under dir as a tar stream, with
+	// entries named after the base name of dir. The home directory of the
+	// instance is archived when dir is empty.
+	InstanceArchive(instance *types.Instance, dir string) (io.ReadCloser, error)
+	// InstanceExtract extracts a tar stream into dir, creating it if needed
+	InstanceExtract(instance *types.Instance, dir string, archive io.Reader) error
+
 	InstanceResizeTerminal(instance *types.Instance, cols, rows uint) error
 	InstanceGetTerminal(instance *types.Instance) (net.Conn, error)
 
 	InstanceUploadFromUrl(instance *types.Instance, fileName, dest, url string) error
 	InstanceUploadFromReader(instance *types.Instance, fileName, dest string, reader io.Reader) error
+
+	// InstanceSnapshot commits the filesystem of an instance to image. Instances
+	// can be restored from it with InstanceConfig.SnapshotImage.
+	InstanceSnapshot(instance *types.Instance, image string) (*types.InstanceSnapshot, error)
+	InstanceSnapshotDelete(session *types.Session, snapshot *types.InstanceSnapshot) error
+
+	// InstancePause freezes the processes of an instance to free its CPU,
+	// keeping its filesystem, memory and addresses. InstanceResume thaws them.
+	InstancePause(session *types.Session, instance *types.Instance) error
+	InstanceResume(session *types.Session, instance *types.Instance) error
+
+	// InstanceStop stops an instance to free its CPU and memory, keeping its
+	// filesystem. InstanceStart starts it again, updating its addresses.
+	InstanceStop(session *types.Session, instance *types.Instance) error
+	InstanceStart(session *types.Session, instance *types.Instance) error
 }
 
 type SessionProvisionerApi interface {
@@ -0,0 +1,500 @@
+package provisioner
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/ringo380/lessoncraft/backend"
+	"github.com/ringo380/lessoncraft/config"
+	"github.com/ringo380/lessoncraft/id"
+	"github.com/ringo380/lessoncraft/lesson"
+	"github.com/ringo380/lessoncraft/pwd/types"
+)
+
+const (
+	// WarmPoolNetwork is the network idle pool containers are attached to until
+	// they are claimed by a session
+	WarmPoolNetwork = "lessoncraft_warm_pool"
+
+	// WarmPoolSizeExtra is the playground extra with the number of idle containers
+	// to keep for the playground's default image
+	WarmPoolSizeExtra = "WarmPoolSize"
+	// WarmPoolSizesExtra is the playground extra with the number of idle containers
+	// to keep per image, e.g. {"franela/dind": 3}
+	WarmPoolSizesExtra = "WarmPoolSizes"
+	// WarmPoolLessonsExtra is the playground extra with the number of idle
+	// containers to keep per lesson, created with the image and resource limits
+	// of its first step, e.g. {"docker-101": 2}
+	WarmPoolLessonsExtra = "WarmPoolLessons"
+	// WarmPoolMaxAgeExtra is the playground extra with how long an idle container
+	// is kept before it is replaced, e.g. "2h"
+	WarmPoolMaxAgeExtra = "WarmPoolMaxAge"
+
+	defaultWarmPoolMaxAge     = time.Hour
+	defaultWarmPoolVolumeSize = "5G"
+	warmPoolLabel             = "lessoncraft.warm-pool"
+)
+
+var warmPoolClaims = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "lessoncraft_warm_pool_claims_total",
+	Help: "Instances started from the warm pool (hit) or created on demand (miss)",
+}, []string{"result"})
+
+func init() {
+	prometheus.MustRegister(warmPoolClaims)
+}
+
+// warmProfile is what an idle container was created with. A container can only
+// be claimed for an instance that would have been created the same way.
+type warmProfile struct {
+	Image          string
+	Privileged     bool
+	DindVolumeSize string
+	HostFQDN       string
+	MaxProcesses   int64
+	MaxMemoryMB    int64
+	StorageSize    string
+	MaxCPUs        float64
+}
+
+type warmPoolKey struct {
+	PlaygroundId string
+	Host         string
+	warmProfile
+}
+
+type warmContainer struct {
+	name    string
+	created time.Time
+}
+
+type warmPool struct {
+	size    int
+	maxAge  time.Duration
+	idle    []warmContainer
+	filling bool
+}
+
+// WarmPool keeps idle DinD containers pre-created on each runtime host, so that
+// InstanceNew can claim one instead of waiting for a container to be pulled,
+// created and started. Pools are kept per playground, image and resource profile
+// and are sized with the WarmPoolSize and WarmPoolSizes playground extras.
+//
+// Pools and claims both build their key with warmProfileFor, so an instance is
+// only started from a container created with the same image, resource limits
+// and playground domain it would have been created with.
+//
+// Idle containers are attached to WarmPoolNetwork. Claiming one renames it,
+// connects it to the session network and sets its hostname, so it needs a
+// runtime that can rename instances, such as docker. Instances that need
+// their own certificates or environment are always created on demand.
+type WarmPool struct {
+	factory   backend.Factory
+	storage   warmPoolStorage
+	generator id.Generator
+	now       func() time.Time
+
+	mu       sync.Mutex
+	pools    map[warmPoolKey]*warmPool
+	networks map[string]bool
+	stop     chan struct{}
+}
+
+// warmPoolStorage is the part of storage.StorageApi the pool needs
+type warmPoolStorage interface {
+	PlaygroundGet(id string) (*types.Playground, error)
+	PlaygroundGetAll() ([]*types.Playground, error)
+	LessonGet(id string) (*lesson.Lesson, error)
+}
+
+// NewWarmPool creates a WarmPool. Pools are only filled once Reconcile runs,
+// usually from Start.
+func NewWarmPool(generator id.Generator, f backend.Factory, s warmPoolStorage) *WarmPool {
+	return &WarmPool{
+		factory:   f,
+		storage:   s,
+		generator: generator,
+		now:       time.Now,
+		pools:     map[warmPoolKey]*warmPool{},
+		networks:  map[string]bool{},
+	}
+}
+
+// Start reconciles the pools every interval until Stop is called
+func (w *WarmPool) Start(interval time.Duration) {
+	w.mu.Lock()
+	if w.stop != nil {
+		w.mu.Unlock()
+		return
+	}
+	stop := make(chan struct{})
+	w.stop = stop
+	w.mu.Unlock()
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			if err := w.Reconcile(); err != nil {
+				log.Printf("Error reconciling warm pool: %v\n", err)
+			}
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// Stop stops reconciling and deletes all idle containers
+func (w *WarmPool) Stop() {
+	w.mu.Lock()
+	if w.stop != nil {
+		close(w.stop)
+		w.stop = nil
+	}
+	pools := w.pools
+	w.pools = map[warmPoolKey]*warmPool{}
+	w.mu.Unlock()
+
+	for key, pool := range pools {
+		w.drain(key, pool.idle)
+	}
+}
+
+// Reconcile sizes the pools from the playground configuration. It deletes the
+// containers of pools that are no longer configured and idle containers older
+// than their maximum age, and fills every pool up to its size.
+func (w *WarmPool) Reconcile() error {
+	playgrounds, err := w.storage.PlaygroundGetAll()
+	if err != nil {
+		return err
+	}
+
+	desired := map[warmPoolKey]*warmPool{}
+	for _, playground := range playgrounds {
+		client, err := w.factory.GetForSession(&types.Session{PlaygroundId: playground.Id})
+		if err != nil {
+			log.Printf("Warm pool cannot reach the runtime of playground [%s]: %v\n", playground.Id, err)
+			continue
+		}
+		host := client.Host()
+		for profile, size := range warmPoolSizes(playground, w.storage) {
+			maxAge, found := playground.Extras.GetDuration(WarmPoolMaxAgeExtra)
+			if !found || maxAge <= 0 {
+				maxAge = defaultWarmPoolMaxAge
+			}
+			desired[warmPoolKey{PlaygroundId: playground.Id, Host: host, warmProfile: profile}] = &warmPool{size: size, maxAge: maxAge}
+		}
+	}
+
+	w.mu.Lock()
+	now := w.now()
+	toDelete := map[warmPoolKey][]warmContainer{}
+	for key, pool := range w.pools {
+		want, found := desired[key]
+		if !found {
+			toDelete[key] = pool.idle
+			delete(w.pools, key)
+			continue
+		}
+		pool.size, pool.maxAge = want.size, want.maxAge
+
+		fresh := pool.idle[:0]
+		for _, c := range pool.idle {
+			if now.Sub(c.created) >= pool.maxAge {
+				toDelete[key] = append(toDelete[key], c)
+			} else {
+				fresh = append(fresh, c)
+			}
+		}
+		pool.idle = fresh
+		if len(pool.idle) > pool.size {
+			toDelete[key] = append(toDelete[key], pool.idle[pool.size:]...)
+			pool.idle = pool.idle[:pool.size]
+		}
+	}
+	for key, want := range desired {
+		if _, found := w.pools[key]; !found {
+			w.pools[key] = want
+		}
+	}
+	w.mu.Unlock()
+
+	for key, containers := range toDelete {
+		w.drain(key, containers)
+	}
+
+	for key := range desired {
+		w.fill(key)
+	}
+	return nil
+}
+
+// Claim takes an idle container matching the instance configuration and turns it
+// into the instance: it is renamed to name, connected to the session network and
+// given the configured hostname. It returns the instance IP on the session
+// network, or false if the instance has to be created on demand.
+func (w *WarmPool) Claim(client backend.Runtime, session *types.Session, conf types.InstanceConfig, name string) (string, bool) {
+	if !warmPoolCompatible(conf) {
+		return "", false
+	}
+	playground, err := w.storage.PlaygroundGet(session.PlaygroundId)
+	if err != nil {
+		warmPoolClaims.WithLabelValues("miss").Inc()
+		return "", false
+	}
+	key := warmPoolKey{PlaygroundId: session.PlaygroundId, Host: client.Host(), warmProfile: warmProfileFor(playground, conf)}
+
+	w.mu.Lock()
+	pool, found := w.pools[key]
+	var c warmContainer
+	if found && len(pool.idle) > 0 {
+		c = pool.idle[0]
+		pool.idle = pool.idle[1:]
+	}
+	w.mu.Unlock()
+
+	if !found {
+		warmPoolClaims.WithLabelValues("miss").Inc()
+		return "", false
+	}
+	go w.fill(key)
+	if c.name == "" {
+		warmPoolClaims.WithLabelValues("miss").Inc()
+		return "", false
+	}
+
+	ip, err := w.claim(client, c.name, session, conf.Hostname, name)
+	if err != nil {
+		log.Printf("Could not claim warm container [%s] for session [%s]: %v\n", c.name, session.Id, err)
+		warmPoolClaims.WithLabelValues("miss").Inc()
+		return "", false
+	}
+
+	log.Printf("Claimed warm container [%s] as [%s] for session [%s]\n", c.name, name, session.Id)
+	warmPoolClaims.WithLabelValues("hit").Inc()
+	return ip, true
+}
+
+func (w *WarmPool) claim(client backend.Runtime, warmName string, session *types.Session, hostname, name string) (string, error) {
+	if err := client.Rename(warmName, name); err != nil {
+		client.Delete(warmName)
+		return "", err
+	}
+
+	ip, err := client.NetworkConnect(name, session.Id, "")
+	if err != nil {
+		client.Delete(name)
+		return "", err
+	}
+	if err := client.NetworkDisconnect(name, WarmPoolNetwork); err != nil {
+		log.Printf("Could not disconnect [%s] from the warm pool network: %v\n", name, err)
+	}
+
+	if code, err := client.Exec(name, []string{"hostname", hostname}); err != nil || code != 0 {
+		client.Delete(name)
+		if err == nil {
+			err = fmt.Errorf("setting hostname exited with %d", code)
+		}
+		return "", err
+	}
+	return ip, nil
+}
+
+// fill creates idle containers until the pool reaches its size. Only one fill
+// runs per pool at a time.
+func (w *WarmPool) fill(key warmPoolKey) {
+	w.mu.Lock()
+	pool, found := w.pools[key]
+	if !found || pool.filling {
+		w.mu.Unlock()
+		return
+	}
+	pool.filling = true
+	w.mu.Unlock()
+
+	defer func() {
+		w.mu.Lock()
+		pool.filling = false
+		w.mu.Unlock()
+	}()
+
+	client, err := w.factory.GetForSession(&types.Session{PlaygroundId: key.PlaygroundId})
+	if err != nil {
+		log.Printf("Warm pool cannot reach the runtime of playground [%s]: %v\n", key.PlaygroundId, err)
+		return
+	}
+	if client.Host() != key.Host {
+		// The playground moved to another host; the next Reconcile creates its pool there
+		return
+	}
+	if err := w.ensureNetwork(client, key.Host); err != nil {
+		log.Printf("Could not create the warm pool network on [%s]: %v\n", key.Host, err)
+		return
+	}
+
+	for {
+		w.mu.Lock()
+		missing := pool.size - len(pool.idle)
+		current := w.pools[key] == pool
+		w.mu.Unlock()
+		if missing <= 0 || !current {
+			return
+		}
+
+		name := fmt.Sprintf("warm_%s", w.generator.NewId())
+		opts := backend.CreateOpts{
+			Image:          key.Image,
+			SessionId:      WarmPoolNetwork,
+			Name:           name,
+			Hostname:       "warm",
+			Privileged:     key.Privileged,
+			HostFQDN:       key.HostFQDN,
+			Networks:       []string{WarmPoolNetwork},
+			DindVolumeSize: key.DindVolumeSize,
+			Labels:         map[string]string{warmPoolLabel: key.PlaygroundId},
+			MaxProcesses:   key.MaxProcesses,
+			MaxMemoryMB:    key.MaxMemoryMB,
+			StorageSize:    key.StorageSize,
+			MaxCPUs:        key.MaxCPUs,
+		}
+		if _, err := client.Create(opts); err != nil {
+			log.Printf("Could not create warm container for image [%s]: %v\n", key.Image, err)
+			return
+		}
+
+		w.mu.Lock()
+		current = w.pools[key] == pool
+		if current {
+			pool.idle = append(pool.idle, warmContainer{name: name, created: w.now()})
+		}
+		w.mu.Unlock()
+		if !current {
+			// The pool was removed while the container was being created
+			client.Delete(name)
+			return
+		}
+	}
+}
+
+func (w *WarmPool) ensureNetwork(client backend.Runtime, host string) error {
+	w.mu.Lock()
+	exists := w.networks[host]
+	w.mu.Unlock()
+	if exists {
+		return nil
+	}
+
+	err := client.NetworkCreate(WarmPoolNetwork)
+	if err != nil && !strings.Contains(err.Error(), "already exists") {
+		return err
+	}
+
+	w.mu.Lock()
+	w.networks[host] = true
+	w.mu.Unlock()
+	return nil
+}
+
+func (w *WarmPool) drain(key warmPoolKey, containers []warmContainer) {
+	if len(containers) == 0 {
+		return
+	}
+	client, err := w.factory.GetForSession(&types.Session{PlaygroundId: key.PlaygroundId})
+	if err != nil {
+		log.Printf("Could not delete warm containers of playground [%s]: %v\n", key.PlaygroundId, err)
+		return
+	}
+	for _, c := range containers {
+		if err := client.Delete(c.name); err != nil {
+			log.Printf("Could not delete warm container [%s]: %v\n", c.name, err)
+		}
+	}
+}
+
+// warmPoolSizes returns the configured pool size per profile of a playground.
+// Lessons that cannot be read are skipped.
+func warmPoolSizes(playground *types.Playground, lessons warmPoolStorage) map[warmProfile]int {
+	sizes := map[string]int{}
+	if size, found := playground.Extras.GetInt(WarmPoolSizeExtra); found && playground.DefaultDinDInstanceImage != "" {
+		sizes[playground.DefaultDinDInstanceImage] = size
+	}
+	if perImage, found := playground.Extras.GetIntMap(WarmPoolSizesExtra); found {
+		for image, size := range perImage {
+			sizes[image] = size
+		}
+	}
+
+	profiles := map[warmProfile]int{}
+	for image, size := range sizes {
+		if size <= 0 {
+			continue
+		}
+		conf := types.InstanceConfig{ImageName: image, Privileged: playground.Privileged}
+		profiles[warmProfileFor(playground, conf)] = size
+	}
+
+	perLesson, _ := playground.Extras.GetIntMap(WarmPoolLessonsExtra)
+	for lessonId, size := range perLesson {
+		if size <= 0 {
+			continue
+		}
+		l, err := lessons.LessonGet(lessonId)
+		if err != nil {
+			log.Printf("Warm pool cannot read lesson [%s] of playground [%s]: %v\n", lessonId, playground.Id, err)
+			continue
+		}
+		conf := types.InstanceConfig{Privileged: playground.Privileged, LessonCtx: &types.LessonContext{LessonID: lessonId}}
+		applyLesson(&conf, l)
+		if conf.ImageName == "" {
+			conf.ImageName = playground.DefaultDinDInstanceImage
+		}
+		if !warmPoolCompatible(conf) {
+			continue
+		}
+		profiles[warmProfileFor(playground, conf)] += size
+	}
+	return profiles
+}
+
+// warmProfileFor returns the profile of the containers an instance of the
+// playground can be claimed from. The host is always the playground domain,
+// as instances are created for requests to any of its hosts.
+func warmProfileFor(playground *types.Playground, conf types.InstanceConfig) warmProfile {
+	volumeSize := conf.DindVolumeSize
+	if volumeSize == "" {
+		volumeSize = playground.DindVolumeSize
+	}
+	if volumeSize == "" {
+		volumeSize = defaultWarmPoolVolumeSize
+	}
+	return warmProfile{
+		Image:          conf.ImageName,
+		Privileged:     conf.Privileged,
+		DindVolumeSize: volumeSize,
+		HostFQDN:       playground.Domain,
+		MaxProcesses:   conf.MaxProcesses,
+		MaxMemoryMB:    conf.MaxMemoryMB,
+		StorageSize:    conf.StorageSize,
+		MaxCPUs:        conf.MaxCPUs,
+	}
+}
+
+// warmPoolCompatible checks if an instance can be started from a pre-created
+// container. Certificates and environment are set when a container is created,
+// and external volumes are named after the container.
+func warmPoolCompatible(conf types.InstanceConfig) bool {
+	if len(conf.ServerCert) > 0 || len(conf.ServerKey) > 0 || len(conf.CACert) > 0 {
+		return false
+	}
+	if len(conf.Envs) > 0 || (config.Unsafe && len(conf.Networks) > 0) {
+		return false
+	}
+	return !config.ExternalDindVolume
+}
@@ -0,0 +1,169 @@
+package provisioner
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/ringo380/lessoncraft/docker"
+	"github.com/ringo380/lessoncraft/id"
+	"github.com/ringo380/lessoncraft/lesson"
+	"github.com/ringo380/lessoncraft/pwd/types"
+	"github.com/ringo380/lessoncraft/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func newTestWarmPool(playgrounds []*types.Playground) (*WarmPool, *docker.Mock, *storage.Mock) {
+	_d := &docker.Mock{}
+	_f := &docker.FactoryMock{}
+	_s := &storage.Mock{}
+	_g := &id.MockGenerator{}
+
+	_f.On("GetForSession", mock.AnythingOfType("*types.Session")).Return(_d, nil)
+	_d.On("DaemonHost").Return("localhost")
+	_d.On("NetworkCreate", WarmPoolNetwork, mock.Anything).Return(nil)
+	_d.On("ContainerIPs", mock.AnythingOfType("string")).Return(map[string]string{WarmPoolNetwork: "10.0.255.2"}, nil)
+	_g.On("NewId").Return("aaaabbbbcccc")
+	_s.On("PlaygroundGetAll").Return(playgrounds, nil)
+	for _, p := range playgrounds {
+		_s.On("PlaygroundGet", p.Id).Return(p, nil)
+	}
+
+	return NewWarmPool(_g, docker.NewRuntimeFactory(_f), _s), _d, _s
+}
+
+func TestWarmPool_Sizes(t *testing.T) {
+	playground := &types.Playground{
+		Id:                       "p1",
+		Domain:                   "localhost",
+		DefaultDinDInstanceImage: "franela/dind",
+		Privileged:               true,
+		Extras: types.PlaygroundExtras{
+			WarmPoolSizeExtra:  2,
+			WarmPoolSizesExtra: map[string]interface{}{"alpine": 1.0, "busybox": 0},
+		},
+	}
+
+	_s := &storage.Mock{}
+	sizes := warmPoolSizes(playground, _s)
+	assert.Equal(t, map[warmProfile]int{
+		{Image: "franela/dind", Privileged: true, DindVolumeSize: "5G", HostFQDN: "localhost"}: 2,
+		{Image: "alpine", Privileged: true, DindVolumeSize: "5G", HostFQDN: "localhost"}:       1,
+	}, sizes)
+
+	assert.Empty(t, warmPoolSizes(&types.Playground{Id: "p2", DefaultDinDInstanceImage: "franela/dind"}, _s))
+}
+
+func TestWarmPool_Claim(t *testing.T) {
+	playground := &types.Playground{
+		Id:                       "p1",
+		Domain:                   "localhost",
+		DefaultDinDInstanceImage: "franela/dind",
+		Extras:                   types.PlaygroundExtras{WarmPoolSizeExtra: 1},
+	}
+	w, _d, _ := newTestWarmPool([]*types.Playground{playground})
+
+	_d.On("ContainerCreate", mock.MatchedBy(func(opts docker.CreateContainerOpts) bool {
+		return opts.ContainerName == "warm_aaaabbbbcccc" && opts.Image == "franela/dind" && opts.Networks[0] == WarmPoolNetwork
+	})).Return(nil).Once()
+	assert.Nil(t, w.Reconcile())
+
+	rt := docker.NewRuntime(_d)
+	session := &types.Session{Id: "aaaabbbbcccc", PlaygroundId: "p1"}
+	// Instances are created for whatever host the request was sent to
+	conf := types.InstanceConfig{ImageName: "franela/dind", Hostname: "node1", PlaygroundFQDN: "localhost:8080", DindVolumeSize: "5G"}
+
+	// Instances needing their own certificates are always created on demand
+	_, claimed := w.Claim(rt, session, types.InstanceConfig{ImageName: "franela/dind", ServerCert: []byte("cert")}, "aaaabbbb_node1")
+	assert.False(t, claimed)
+
+	_d.On("ContainerRename", "warm_aaaabbbbcccc", "aaaabbbb_node1").Return(nil)
+	_d.On("NetworkConnect", "aaaabbbb_node1", session.Id, "").Return("10.0.0.1", nil)
+	_d.On("NetworkDisconnect", "aaaabbbb_node1", WarmPoolNetwork).Return(nil)
+	_d.On("Exec", "aaaabbbb_node1", []string{"hostname", "node1"}).Return(0, nil)
+	// The claimed container is replaced in the background
+	_d.On("ContainerCreate", mock.AnythingOfType("docker.CreateContainerOpts")).Return(errors.New("no capacity"))
+
+	ip, claimed := w.Claim(rt, session, conf, "aaaabbbb_node1")
+	assert.True(t, claimed)
+	assert.Equal(t, "10.0.0.1", ip)
+
+	// Once the pool is empty instances are created on demand
+	_, claimed = w.Claim(rt, session, conf, "aaaabbbb_node2")
+	assert.False(t, claimed)
+}
+
+func TestWarmPool_ClaimLessonLimits(t *testing.T) {
+	playground := &types.Playground{
+		Id:                       "p1",
+		Domain:                   "localhost",
+		DefaultDinDInstanceImage: "franela/dind",
+		Extras:                   types.PlaygroundExtras{WarmPoolLessonsExtra: map[string]interface{}{"docker-101": 1}},
+	}
+	l := &lesson.Lesson{
+		ID:                  "docker-101",
+		DefaultImage:        "lessons/docker",
+		DefaultMaxMemoryMB:  512,
+		DefaultMaxProcesses: 200,
+		Steps:               []lesson.LessonStep{{ID: "run", MaxMemoryMB: 1024}},
+	}
+	w, _d, _s := newTestWarmPool([]*types.Playground{playground})
+	_s.On("LessonGet", "docker-101").Return(l, nil)
+
+	_d.On("ContainerCreate", mock.MatchedBy(func(opts docker.CreateContainerOpts) bool {
+		return opts.Image == "lessons/docker" && opts.MaxMemoryMB == 1024 && opts.MaxProcesses == 200 && opts.HostFQDN == "localhost"
+	})).Return(nil).Once()
+	assert.Nil(t, w.Reconcile())
+	_d.AssertNumberOfCalls(t, "ContainerCreate", 1)
+
+	rt := docker.NewRuntime(_d)
+	session := &types.Session{Id: "aaaabbbbcccc", PlaygroundId: "p1"}
+	// The provisioner applies the lesson to the configuration before claiming
+	conf := types.InstanceConfig{Hostname: "node1", PlaygroundFQDN: "localhost:8080", DindVolumeSize: "5G", LessonCtx: &types.LessonContext{LessonID: "docker-101"}}
+	applyLesson(&conf, l)
+
+	_d.On("ContainerRename", "warm_aaaabbbbcccc", "aaaabbbb_node1").Return(nil)
+	_d.On("NetworkConnect", "aaaabbbb_node1", session.Id, "").Return("10.0.0.1", nil)
+	_d.On("NetworkDisconnect", "aaaabbbb_node1", WarmPoolNetwork).Return(nil)
+	_d.On("Exec", "aaaabbbb_node1", []string{"hostname", "node1"}).Return(0, nil)
+	_d.On("ContainerCreate", mock.AnythingOfType("docker.CreateContainerOpts")).Return(errors.New("no capacity"))
+
+	// Instances of the lesson without its limits are not started from the pool
+	_, claimed := w.Claim(rt, session, types.InstanceConfig{ImageName: "lessons/docker", Hostname: "node1", DindVolumeSize: "5G"}, "aaaabbbb_node1")
+	assert.False(t, claimed)
+
+	ip, claimed := w.Claim(rt, session, conf, "aaaabbbb_node1")
+	assert.True(t, claimed)
+	assert.Equal(t, "10.0.0.1", ip)
+}
+
+func TestWarmPool_Reap(t *testing.T) {
+	playground := &types.Playground{
+		Id:                       "p1",
+		DefaultDinDInstanceImage: "franela/dind",
+		Extras:                   types.PlaygroundExtras{WarmPoolSizeExtra: 1, WarmPoolMaxAgeExtra: "10m"},
+	}
+	w, _d, _ := newTestWarmPool([]*types.Playground{playground})
+	now := time.Now()
+	w.now = func() time.Time { return now }
+
+	_d.On("ContainerCreate", mock.AnythingOfType("docker.CreateContainerOpts")).Return(nil)
+	assert.Nil(t, w.Reconcile())
+	_d.AssertNumberOfCalls(t, "ContainerCreate", 1)
+
+	// Fresh containers are kept
+	assert.Nil(t, w.Reconcile())
+	_d.AssertNumberOfCalls(t, "ContainerCreate", 1)
+
+	// Stale containers are deleted and replaced
+	now = now.Add(10 * time.Minute)
+	_d.On("ContainerDelete", "warm_aaaabbbbcccc").Return(nil)
+	assert.Nil(t, w.Reconcile())
+	_d.AssertNumberOfCalls(t, "ContainerDelete", 1)
+	_d.AssertNumberOfCalls(t, "ContainerCreate", 2)
+
+	// Stopping deletes idle containers
+	w.Stop()
+	_d.AssertNumberOfCalls(t, "ContainerDelete", 2)
+}
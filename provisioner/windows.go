@@ -9,6 +9,7 @@ import (
 	"net"
 	"net/http"
 	"net/url"
+	"os"
 	"sort"
 
 	"golang.org/x/net/websocket"
@@ -79,6 +80,8 @@ func (d *windows) InstanceNew(session *types.Session, conf types.InstanceConfig)
 	instance.RoutableIP = instance.IP
 	instance.SessionId = session.Id
 	instance.WindowsId = winfo.id
+	instance.Role = conf.Role
+	instance.Ports = conf.Ports
 	instance.Cert = conf.Cert
 	instance.Key = conf.Key
 	instance.Type = conf.Type
@@ -164,6 +167,62 @@ func (d *windows) InstanceFile(instance *types.Instance, filePath string) (io.Re
 	return nil, nil
 }
 
+func (d *windows) InstanceFileWrite(instance *types.Instance, filePath string, content io.Reader, checksum string) (string, error) {
+	return "", FileOpsNotSupportedError
+}
+
+func (d *windows) InstanceFileMove(instance *types.Instance, from, to string) error {
+	return FileOpsNotSupportedError
+}
+
+func (d *windows) InstanceFileDelete(instance *types.Instance, filePath string) error {
+	return FileOpsNotSupportedError
+}
+
+func (d *windows) InstanceMkdir(instance *types.Instance, dirPath string) error {
+	return FileOpsNotSupportedError
+}
+
+func (d *windows) InstanceChmod(instance *types.Instance, filePath string, mode os.FileMode) error {
+	return FileOpsNotSupportedError
+}
+
+func (d *windows) InstanceFileSearch(instance *types.Instance, dir, pattern string, regex bool) ([]types.FileMatch, error) {
+	return nil, FileOpsNotSupportedError
+}
+
+func (d *windows) InstanceArchive(instance *types.Instance, dir string) (io.ReadCloser, error) {
+	return nil, FileOpsNotSupportedError
+}
+
+func (d *windows) InstanceExtract(instance *types.Instance, dir string, archive io.Reader) error {
+	return FileOpsNotSupportedError
+}
+
+func (d *windows) InstanceSnapshot(instance *types.Instance, image string) (*types.InstanceSnapshot, error) {
+	return nil, SnapshotNotSupportedError
+}
+
+func (d *windows) InstanceSnapshotDelete(session *types.Session, snapshot *types.InstanceSnapshot) error {
+	return SnapshotNotSupportedError
+}
+
+func (d *windows) InstancePause(session *types.Session, instance *types.Instance) error {
+	return PauseNotSupportedError
+}
+
+func (d *windows) InstanceResume(session *types.Session, instance *types.Instance) error {
+	return PauseNotSupportedError
+}
+
+func (d *windows) InstanceStop(session *types.Session, instance *types.Instance) error {
+	return PauseNotSupportedError
+}
+
+func (d *windows) InstanceStart(session *types.Session, instance *types.Instance) error {
+	return PauseNotSupportedError
+}
+
 func (d *windows) releaseInstance(instanceId string) error {
 	return d.storage.WindowsInstanceDelete(instanceId)
 }
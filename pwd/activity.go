@@ -0,0 +1,47 @@
+package pwd
+
+import (
+	"sync"
+	"time"
+)
+
+// activity keeps when each session was last used. It is kept in memory only,
+// sessions count as used when the process starts.
+type activity struct {
+	mu   sync.RWMutex
+	last map[string]time.Time
+}
+
+func newActivity() *activity {
+	return &activity{last: map[string]time.Time{}}
+}
+
+func (a *activity) touch(sessionId string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.last[sessionId] = time.Now()
+}
+
+func (a *activity) get(sessionId string) time.Time {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return a.last[sessionId]
+}
+
+func (a *activity) forget(sessionId string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	delete(a.last, sessionId)
+}
+
+// SessionTouch records that a session is in use, e.g. because a learner typed
+// in one of its terminals
+func (p *lessoncraft) SessionTouch(sessionId string) {
+	p.activity.touch(sessionId)
+}
+
+// SessionLastActivity returns when a session was last used. It is zero if the
+// session was not used since the process started.
+func (p *lessoncraft) SessionLastActivity(sessionId string) time.Time {
+	return p.activity.get(sessionId)
+}
@@ -11,6 +11,7 @@ import (
 func (p *lessoncraft) ClientNew(id string, session *types.Session) *types.Client {
 	defer observeAction("ClientNew", time.Now())
 	c := &types.Client{Id: id, SessionId: session.Id}
+	p.SessionTouch(session.Id)
 	if err := p.storage.ClientPut(c); err != nil {
 		log.Println("Error saving client", err)
 	}
@@ -32,6 +33,8 @@ func (p *lessoncraft) ClientResizeViewPort(c *types.Client, cols, rows uint) {
 func (p *lessoncraft) ClientClose(client *types.Client) {
 	defer observeAction("ClientClose", time.Now())
 	// Client has disconnected. Remove from session and recheck terminal sizes.
+	// The session is idle from now on if it was its last client.
+	p.SessionTouch(client.SessionId)
 	if err := p.storage.ClientDelete(client.Id); err != nil {
 		log.Println("Error deleting client", err)
 		return
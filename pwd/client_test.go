@@ -5,7 +5,7 @@ import (
 	"testing"
 	"time"
 
-	dtypes "github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/network"
 	"github.com/ringo380/lessoncraft/config"
 	"github.com/ringo380/lessoncraft/docker"
 	"github.com/ringo380/lessoncraft/event"
@@ -29,7 +29,7 @@ func TestClientNew(t *testing.T) {
 
 	_g.On("NewId").Return("aaaabbbbcccc")
 	_f.On("GetForSession", mock.AnythingOfType("*types.Session")).Return(_d, nil)
-	_d.On("NetworkCreate", "aaaabbbbcccc", dtypes.NetworkCreate{Attachable: true, Driver: "overlay"}).Return(nil)
+	_d.On("NetworkCreate", "aaaabbbbcccc", network.CreateOptions{Attachable: true, Driver: "overlay"}).Return(nil)
 	_d.On("DaemonHost").Return("localhost")
 	_d.On("NetworkConnect", config.L2ContainerName, "aaaabbbbcccc", "").Return("10.0.0.1", nil)
 	_s.On("SessionPut", mock.AnythingOfType("*types.Session")).Return(nil)
@@ -72,7 +72,7 @@ func TestClientCount(t *testing.T) {
 
 	_g.On("NewId").Return("aaaabbbbcccc")
 	_f.On("GetForSession", mock.AnythingOfType("*types.Session")).Return(_d, nil)
-	_d.On("NetworkCreate", "aaaabbbbcccc", dtypes.NetworkCreate{Attachable: true, Driver: "overlay"}).Return(nil)
+	_d.On("NetworkCreate", "aaaabbbbcccc", network.CreateOptions{Attachable: true, Driver: "overlay"}).Return(nil)
 	_d.On("DaemonHost").Return("localhost")
 	_d.On("NetworkConnect", config.L2ContainerName, "aaaabbbbcccc", "").Return("10.0.0.1", nil)
 	_s.On("SessionPut", mock.AnythingOfType("*types.Session")).Return(nil)
@@ -113,7 +113,7 @@ func TestClientResizeViewPort(t *testing.T) {
 
 	_g.On("NewId").Return("aaaabbbbcccc")
 	_f.On("GetForSession", mock.AnythingOfType("*types.Session")).Return(_d, nil)
-	_d.On("NetworkCreate", "aaaabbbbcccc", dtypes.NetworkCreate{Attachable: true, Driver: "overlay"}).Return(nil)
+	_d.On("NetworkCreate", "aaaabbbbcccc", network.CreateOptions{Attachable: true, Driver: "overlay"}).Return(nil)
 	_d.On("DaemonHost").Return("localhost")
 	_d.On("NetworkConnect", config.L2ContainerName, "aaaabbbbcccc", "").Return("10.0.0.1", nil)
 	_s.On("SessionPut", mock.AnythingOfType("*types.Session")).Return(nil)
@@ -0,0 +1,121 @@
+package pwd
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/ringo380/lessoncraft/event"
+	"github.com/ringo380/lessoncraft/lesson"
+	"github.com/ringo380/lessoncraft/pwd/types"
+	"github.com/ringo380/lessoncraft/router"
+)
+
+// maxSessionEndpoints is how many endpoints a session may declare
+const maxSessionEndpoints = 20
+
+var (
+	ErrInvalidEndpoint  = errors.New("Invalid endpoint")
+	ErrEndpointExists   = errors.New("Endpoint already exists")
+	ErrEndpointNotFound = errors.New("Endpoint not found")
+)
+
+// SessionEndpointAdd names a port of an instance of the session. The
+// endpoint is reachable at <name>-<session> under the l2 domain.
+func (p *lessoncraft) SessionEndpointAdd(session *types.Session, endpoint types.SessionEndpoint) error {
+	defer observeAction("SessionEndpointAdd", time.Now())
+
+	if !router.ValidAliasName(endpoint.Name) || endpoint.Port < 1 || endpoint.Port > 65535 {
+		return ErrInvalidEndpoint
+	}
+	if p.endpointInstance(session, endpoint.Instance) == nil {
+		return fmt.Errorf("%w: instance %s not found", ErrInvalidEndpoint, endpoint.Instance)
+	}
+	if _, found := session.Endpoint(endpoint.Name); found {
+		return ErrEndpointExists
+	}
+	if len(session.Endpoints) >= maxSessionEndpoints {
+		return fmt.Errorf("%w: sessions cannot have more than %d endpoints", ErrInvalidEndpoint, maxSessionEndpoints)
+	}
+
+	endpoint.CreatedAt = time.Now()
+	session.Endpoints = append(session.Endpoints, endpoint)
+	if err := p.storage.SessionPut(session); err != nil {
+		session.Endpoints = session.Endpoints[:len(session.Endpoints)-1]
+		log.Println(err)
+		return err
+	}
+	p.event.Emit(event.SESSION_ENDPOINTS, session.Id, session.Endpoints)
+	return nil
+}
+
+// SessionEndpointRemove drops an endpoint of the session
+func (p *lessoncraft) SessionEndpointRemove(session *types.Session, name string) error {
+	defer observeAction("SessionEndpointRemove", time.Now())
+
+	endpoints := []types.SessionEndpoint{}
+	for _, e := range session.Endpoints {
+		if e.Name != name {
+			endpoints = append(endpoints, e)
+		}
+	}
+	if len(endpoints) == len(session.Endpoints) {
+		return ErrEndpointNotFound
+	}
+
+	before := session.Endpoints
+	session.Endpoints = endpoints
+	if err := p.storage.SessionPut(session); err != nil {
+		session.Endpoints = before
+		log.Println(err)
+		return err
+	}
+	p.event.Emit(event.SESSION_ENDPOINTS, session.Id, session.Endpoints)
+	return nil
+}
+
+// SessionEndpointResolve returns the instance and port an endpoint of a
+// session leads to
+func (p *lessoncraft) SessionEndpointResolve(session *types.Session, name string) (*types.Instance, int, error) {
+	endpoint, found := session.Endpoint(name)
+	if !found {
+		return nil, 0, ErrEndpointNotFound
+	}
+	instance := p.endpointInstance(session, endpoint.Instance)
+	if instance == nil {
+		return nil, 0, ErrEndpointNotFound
+	}
+	return instance, endpoint.Port, nil
+}
+
+// endpointInstance returns the instance of the session an endpoint names
+func (p *lessoncraft) endpointInstance(session *types.Session, name string) *types.Instance {
+	instance := p.InstanceGet(session, name)
+	if instance == nil || instance.SessionId != session.Id {
+		return nil
+	}
+	return instance
+}
+
+// sessionAddLessonEndpoints declares the endpoints of a lesson on the
+// instance it runs in. Endpoints the session already has are kept.
+func (p *lessoncraft) sessionAddLessonEndpoints(instance *types.Instance, endpoints []lesson.Endpoint) {
+	if len(endpoints) == 0 {
+		return
+	}
+	session, err := p.storage.SessionGet(instance.SessionId)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	for _, e := range endpoints {
+		if _, found := session.Endpoint(e.Name); found {
+			continue
+		}
+		err := p.SessionEndpointAdd(session, types.SessionEndpoint{Name: e.Name, Instance: instance.Name, Port: e.Port})
+		if err != nil {
+			log.Printf("Couldn't add endpoint %s of lesson to instance %s: %v\n", e.Name, instance.Name, err)
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package pwd
+
+import (
+	"io"
+	"os"
+	"time"
+
+	"github.com/ringo380/lessoncraft/event"
+	"github.com/ringo380/lessoncraft/pwd/types"
+)
+
+// File changes are emitted as INSTANCE_FILES_CHANGED with the instance name,
+// the operation and the paths it changed, so open editors can reload them
+const (
+	FileWritten = "write"
+	FileMoved   = "move"
+	FileDeleted = "delete"
+	FileMkdir   = "mkdir"
+	FileChmod   = "chmod"
+	// FileExtracted is an archive extracted into a directory
+	FileExtracted = "extract"
+)
+
+func (p *lessoncraft) InstanceFileWrite(instance *types.Instance, filePath string, content io.Reader, checksum string) (string, error) {
+	defer observeAction("InstanceFileWrite", time.Now())
+	p.SessionTouch(instance.SessionId)
+
+	prov, err := p.getProvisioner(instance.Type)
+	if err != nil {
+		return "", err
+	}
+	sum, err := prov.InstanceFileWrite(instance, filePath, content, checksum)
+	if err != nil {
+		return "", err
+	}
+	p.event.Emit(event.INSTANCE_FILES_CHANGED, instance.SessionId, instance.Name, FileWritten, filePath)
+	return sum, nil
+}
+
+func (p *lessoncraft) InstanceFileMove(instance *types.Instance, from, to string) error {
+	defer observeAction("InstanceFileMove", time.Now())
+	p.SessionTouch(instance.SessionId)
+
+	prov, err := p.getProvisioner(instance.Type)
+	if err != nil {
+		return err
+	}
+	if err := prov.InstanceFileMove(instance, from, to); err != nil {
+		return err
+	}
+	p.event.Emit(event.INSTANCE_FILES_CHANGED, instance.SessionId, instance.Name, FileMoved, from, to)
+	return nil
+}
+
+func (p *lessoncraft) InstanceFileDelete(instance *types.Instance, filePath string) error {
+	defer observeAction("InstanceFileDelete", time.Now())
+	p.SessionTouch(instance.SessionId)
+
+	prov, err := p.getProvisioner(instance.Type)
+	if err != nil {
+		return err
+	}
+	if err := prov.InstanceFileDelete(instance, filePath); err != nil {
+		return err
+	}
+	p.event.Emit(event.INSTANCE_FILES_CHANGED, instance.SessionId, instance.Name, FileDeleted, filePath)
+	return nil
+}
+
+func (p *lessoncraft) InstanceMkdir(instance *types.Instance, dirPath string) error {
+	defer observeAction("InstanceMkdir", time.Now())
+	p.SessionTouch(instance.SessionId)
+
+	prov, err := p.getProvisioner(instance.Type)
+	if err != nil {
+		return err
+	}
+	if err := prov.InstanceMkdir(instance, dirPath); err != nil {
+		return err
+	}
+	p.event.Emit(event.INSTANCE_FILES_CHANGED, instance.SessionId, instance.Name, FileMkdir, dirPath)
+	return nil
+}
+
+func (p *lessoncraft) InstanceChmod(instance *types.Instance, filePath string, mode os.FileMode) error {
+	defer observeAction("InstanceChmod", time.Now())
+	p.SessionTouch(instance.SessionId)
+
+	prov, err := p.getProvisioner(instance.Type)
+	if err != nil {
+		return err
+	}
+	if err := prov.InstanceChmod(instance, filePath, mode); err != nil {
+		return err
+	}
+	p.event.Emit(event.INSTANCE_FILES_CHANGED, instance.SessionId, instance.Name, FileChmod, filePath)
+	return nil
+}
+
+func (p *lessoncraft) InstanceFileSearch(instance *types.Instance, dir, pattern string, regex bool) ([]types.FileMatch, error) {
+	defer observeAction("InstanceFileSearch", time.Now())
+
+	prov, err := p.getProvisioner(instance.Type)
+	if err != nil {
+		return nil, err
+	}
+	return prov.InstanceFileSearch(instance, dir, pattern, regex)
+}
+
+func (p *lessoncraft) InstanceArchive(instance *types.Instance, dir string) (io.ReadCloser, error) {
+	defer observeAction("InstanceArchive", time.Now())
+
+	prov, err := p.getProvisioner(instance.Type)
+	if err != nil {
+		return nil, err
+	}
+	return prov.InstanceArchive(instance, dir)
+}
+
+func (p *lessoncraft) InstanceExtract(instance *types.Instance, dir string, archive io.Reader) error {
+	defer observeAction("InstanceExtract", time.Now())
+	p.SessionTouch(instance.SessionId)
+
+	prov, err := p.getProvisioner(instance.Type)
+	if err != nil {
+		return err
+	}
+	if err := prov.InstanceExtract(instance, dir, archive); err != nil {
+		return err
+	}
+	p.event.Emit(event.INSTANCE_FILES_CHANGED, instance.SessionId, instance.Name, FileExtracted, dir)
+	return nil
+}
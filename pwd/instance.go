@@ -11,7 +11,7 @@ import (
 	"github.com/ringo380/lessoncraft/pwd/types"
 )
 
-func (p *pwd) InstanceResizeTerminal(instance *types.Instance, rows, cols uint) error {
+func (p *lessoncraft) InstanceResizeTerminal(instance *types.Instance, rows, cols uint) error {
 	defer observeAction("InstanceResizeTerminal", time.Now())
 	prov, err := p.getProvisioner(instance.Type)
 	if err != nil {
@@ -20,7 +20,7 @@ func (p *pwd) InstanceResizeTerminal(instance *types.Instance, rows, cols uint)
 	return prov.InstanceResizeTerminal(instance, rows, cols)
 }
 
-func (p *pwd) InstanceGetTerminal(instance *types.Instance) (net.Conn, error) {
+func (p *lessoncraft) InstanceGetTerminal(instance *types.Instance) (net.Conn, error) {
 	defer observeAction("InstanceGetTerminal", time.Now())
 	prov, err := p.getProvisioner(instance.Type)
 	if err != nil {
@@ -29,8 +29,9 @@ func (p *pwd) InstanceGetTerminal(instance *types.Instance) (net.Conn, error) {
 	return prov.InstanceGetTerminal(instance)
 }
 
-func (p *pwd) InstanceUploadFromUrl(instance *types.Instance, fileName, dest string, url string) error {
+func (p *lessoncraft) InstanceUploadFromUrl(instance *types.Instance, fileName, dest string, url string) error {
 	defer observeAction("InstanceUploadFromUrl", time.Now())
+	p.SessionTouch(instance.SessionId)
 	prov, err := p.getProvisioner(instance.Type)
 	if err != nil {
 		return err
@@ -39,8 +40,9 @@ func (p *pwd) InstanceUploadFromUrl(instance *types.Instance, fileName, dest str
 	return prov.InstanceUploadFromUrl(instance, fileName, dest, url)
 }
 
-func (p *pwd) InstanceUploadFromReader(instance *types.Instance, fileName, dest string, reader io.Reader) error {
+func (p *lessoncraft) InstanceUploadFromReader(instance *types.Instance, fileName, dest string, reader io.Reader) error {
 	defer observeAction("InstanceUploadFromReader", time.Now())
+	p.SessionTouch(instance.SessionId)
 
 	prov, err := p.getProvisioner(instance.Type)
 	if err != nil {
@@ -50,7 +52,25 @@ func (p *pwd) InstanceUploadFromReader(instance *types.Instance, fileName, dest
 	return prov.InstanceUploadFromReader(instance, fileName, dest, reader)
 }
 
-func (p *pwd) InstanceGet(session *types.Session, name string) *types.Instance {
+func (p *lessoncraft) InstanceSnapshot(instance *types.Instance, image string) (*types.InstanceSnapshot, error) {
+	defer observeAction("InstanceSnapshot", time.Now())
+	prov, err := p.getProvisioner(instance.Type)
+	if err != nil {
+		return nil, err
+	}
+	return prov.InstanceSnapshot(instance, image)
+}
+
+func (p *lessoncraft) InstanceSnapshotDelete(session *types.Session, snapshot *types.InstanceSnapshot) error {
+	defer observeAction("InstanceSnapshotDelete", time.Now())
+	prov, err := p.getProvisioner(snapshot.Type)
+	if err != nil {
+		return err
+	}
+	return prov.InstanceSnapshotDelete(session, snapshot)
+}
+
+func (p *lessoncraft) InstanceGet(session *types.Session, name string) *types.Instance {
 	defer observeAction("InstanceGet", time.Now())
 	instance, err := p.storage.InstanceGet(name)
 	if err != nil {
@@ -60,7 +80,7 @@ func (p *pwd) InstanceGet(session *types.Session, name string) *types.Instance {
 	return instance
 }
 
-func (p *pwd) InstanceFindBySession(session *types.Session) ([]*types.Instance, error) {
+func (p *lessoncraft) InstanceFindBySession(session *types.Session) ([]*types.Instance, error) {
 	defer observeAction("InstanceFindBySession", time.Now())
 	instances, err := p.storage.InstanceFindBySessionId(session.Id)
 	if err != nil {
@@ -70,7 +90,7 @@ func (p *pwd) InstanceFindBySession(session *types.Session) ([]*types.Instance,
 	return instances, nil
 }
 
-func (p *pwd) InstanceDelete(session *types.Session, instance *types.Instance) error {
+func (p *lessoncraft) InstanceDelete(session *types.Session, instance *types.Instance) error {
 	defer observeAction("InstanceDelete", time.Now())
 
 	prov, err := p.getProvisioner(instance.Type)
@@ -88,6 +108,12 @@ func (p *pwd) InstanceDelete(session *types.Session, instance *types.Instance) e
 		return err
 	}
 
+	if p.quota != nil {
+		if err := p.quota.ReleaseInstance(session, instance.Name); err != nil {
+			log.Printf("Could not release quota of instance [%s]: %v\n", instance.Name, err)
+		}
+	}
+
 	p.event.Emit(event.INSTANCE_DELETE, session.Id, instance.Name)
 
 	p.setGauges()
@@ -95,8 +121,9 @@ func (p *pwd) InstanceDelete(session *types.Session, instance *types.Instance) e
 	return nil
 }
 
-func (p *pwd) InstanceNew(session *types.Session, conf types.InstanceConfig) (*types.Instance, error) {
+func (p *lessoncraft) InstanceNew(session *types.Session, conf types.InstanceConfig) (*types.Instance, error) {
 	defer observeAction("InstanceNew", time.Now())
+	p.SessionTouch(session.Id)
 
 	prov, err := p.getProvisioner(conf.Type)
 	if err != nil {
@@ -107,26 +134,56 @@ func (p *pwd) InstanceNew(session *types.Session, conf types.InstanceConfig) (*t
 		conf.Tls = true
 	}
 
+	var reservation string
+	if p.quota != nil {
+		reservation, err = p.quota.AcquireInstance(session, conf)
+		if err != nil {
+			log.Printf("Instance for session [%s] not admitted: %v\n", session.Id, err)
+			return nil, err
+		}
+	}
+
 	instance, err := prov.InstanceNew(session, conf)
 	if err != nil {
 		log.Println(err)
+		if p.quota != nil {
+			p.cancelQuota(reservation)
+		}
 		return nil, err
 	}
 
 	err = p.storage.InstancePut(instance)
 	if err != nil {
+		if p.quota != nil {
+			p.cancelQuota(reservation)
+		}
 		return nil, err
 	}
 
+	if p.quota != nil {
+		if err := p.quota.BindInstance(reservation, instance.Name); err != nil {
+			log.Printf("Could not bind quota reservation [%s] to instance [%s]: %v\n", reservation, instance.Name, err)
+		}
+	}
+
 	p.event.Emit(event.INSTANCE_NEW, session.Id, instance.Name, instance.IP, instance.Hostname, instance.ProxyHost)
 
 	p.setGauges()
 
+	// Instances created for a lesson step are prepared for it in the background,
+	// the step is shown when LESSON_STEP_READY is emitted. Snapshots already
+	// contain the prepared files, so they are not set up again.
+	if conf.LessonCtx != nil && conf.LessonCtx.LessonID != "" && conf.SnapshotImage == "" {
+		lessonCtx := *conf.LessonCtx
+		go p.InstanceLessonSetup(instance, lessonCtx)
+	}
+
 	return instance, nil
 }
 
-func (p *pwd) InstanceExec(instance *types.Instance, cmd []string) (int, error) {
+func (p *lessoncraft) InstanceExec(instance *types.Instance, cmd []string) (int, error) {
 	defer observeAction("InstanceExec", time.Now())
+	p.SessionTouch(instance.SessionId)
 
 	prov, err := p.getProvisioner(instance.Type)
 	if err != nil {
@@ -140,7 +197,7 @@ func (p *pwd) InstanceExec(instance *types.Instance, cmd []string) (int, error)
 	return exitCode, nil
 }
 
-func (p *pwd) InstanceFSTree(instance *types.Instance) (io.Reader, error) {
+func (p *lessoncraft) InstanceFSTree(instance *types.Instance) (io.Reader, error) {
 	defer observeAction("InstanceFSTree", time.Now())
 
 	prov, err := p.getProvisioner(instance.Type)
@@ -150,7 +207,7 @@ func (p *pwd) InstanceFSTree(instance *types.Instance) (io.Reader, error) {
 	return prov.InstanceFSTree(instance)
 }
 
-func (p *pwd) InstanceFile(instance *types.Instance, filePath string) (io.Reader, error) {
+func (p *lessoncraft) InstanceFile(instance *types.Instance, filePath string) (io.Reader, error) {
 	defer observeAction("InstanceFile", time.Now())
 
 	prov, err := p.getProvisioner(instance.Type)
@@ -0,0 +1,70 @@
+package pwd
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"time"
+
+	"github.com/ringo380/lessoncraft/event"
+	"github.com/ringo380/lessoncraft/pwd/types"
+)
+
+// instanceSetupTarget runs lesson step setups in an instance
+type instanceSetupTarget struct {
+	p        *lessoncraft
+	instance *types.Instance
+}
+
+func (t *instanceSetupTarget) Exec(cmd []string) (int, error) {
+	return t.p.InstanceExec(t.instance, cmd)
+}
+
+func (t *instanceSetupTarget) Upload(fileName, dest string, content io.Reader) error {
+	return t.p.InstanceUploadFromReader(t.instance, fileName, dest, content)
+}
+
+// InstanceLessonSetup prepares an instance for a lesson step: it copies the
+// step files, runs its setup commands and waits for its readiness probes. The
+// progress is streamed to the session as builder output, and the step is
+// announced as ready once the setup succeeds, and the lesson endpoints are
+// declared on the instance.
+func (p *lessoncraft) InstanceLessonSetup(instance *types.Instance, lessonCtx types.LessonContext) error {
+	defer observeAction("InstanceLessonSetup", time.Now())
+
+	l, err := p.storage.LessonGet(lessonCtx.LessonID)
+	if err != nil {
+		return err
+	}
+	if lessonCtx.StepIndex < 0 || lessonCtx.StepIndex >= len(l.Steps) {
+		return fmt.Errorf("step index %d is out of range [0-%d]", lessonCtx.StepIndex, len(l.Steps)-1)
+	}
+	step := l.Steps[lessonCtx.StepIndex]
+
+	if step.Setup != nil {
+		p.event.Emit(event.LESSON_STEP_SETUP, instance.SessionId, instance.Name, lessonCtx.LessonID, lessonCtx.StepIndex)
+
+		timeout := step.Timeout
+		if timeout <= 0 {
+			timeout = 5 * time.Minute
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+
+		w := sessionBuilderWriter{sessionId: instance.SessionId, event: p.event}
+		if err := step.Setup.Apply(ctx, &instanceSetupTarget{p: p, instance: instance}, &w); err != nil {
+			log.Printf("Setup of step %d of lesson %s failed on instance %s: %v\n", lessonCtx.StepIndex, lessonCtx.LessonID, instance.Name, err)
+			fmt.Fprintf(&w, "Setup failed: %v\r\n", err)
+			return err
+		}
+	}
+
+	instance.LessonCtx = &lessonCtx
+	if err := p.storage.InstancePut(instance); err != nil {
+		return err
+	}
+	p.sessionAddLessonEndpoints(instance, l.Endpoints)
+	p.event.Emit(event.LESSON_STEP_READY, instance.SessionId, instance.Name, lessonCtx.LessonID, lessonCtx.StepIndex)
+	return nil
+}
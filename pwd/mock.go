@@ -4,8 +4,11 @@ import (
 	"context"
 	"io"
 	"net"
+	"os"
+	"time"
 
 	"github.com/ringo380/lessoncraft/pwd/types"
+	"github.com/ringo380/lessoncraft/router"
 	"github.com/stretchr/testify/mock"
 )
 
@@ -33,6 +36,70 @@ func (m *Mock) SessionDeployStack(session *types.Session) error {
 	return args.Error(0)
 }
 
+func (m *Mock) SessionExtend(session *types.Session, d time.Duration) error {
+	args := m.Called(session, d)
+	return args.Error(0)
+}
+
+func (m *Mock) SessionPause(session *types.Session) error {
+	args := m.Called(session)
+	return args.Error(0)
+}
+
+func (m *Mock) SessionHibernate(session *types.Session) error {
+	args := m.Called(session)
+	return args.Error(0)
+}
+
+func (m *Mock) SessionResume(session *types.Session) error {
+	args := m.Called(session)
+	return args.Error(0)
+}
+
+func (m *Mock) SessionEndpointAdd(session *types.Session, endpoint types.SessionEndpoint) error {
+	args := m.Called(session, endpoint)
+	return args.Error(0)
+}
+
+func (m *Mock) SessionEndpointRemove(session *types.Session, name string) error {
+	args := m.Called(session, name)
+	return args.Error(0)
+}
+
+func (m *Mock) SessionEndpointResolve(session *types.Session, name string) (*types.Instance, int, error) {
+	args := m.Called(session, name)
+	return args.Get(0).(*types.Instance), args.Int(1), args.Error(2)
+}
+
+func (m *Mock) SessionPortAdd(session *types.Session, port types.SessionPort) (*types.SessionPort, error) {
+	args := m.Called(session, port)
+	return args.Get(0).(*types.SessionPort), args.Error(1)
+}
+
+func (m *Mock) SessionPortRemove(session *types.Session, protocol string, port int) error {
+	args := m.Called(session, protocol, port)
+	return args.Error(0)
+}
+
+func (m *Mock) PortForwards() ([]router.PortForward, error) {
+	args := m.Called()
+	return args.Get(0).([]router.PortForward), args.Error(1)
+}
+
+func (m *Mock) SessionZone(session *types.Session) (router.Zone, error) {
+	args := m.Called(session)
+	return args.Get(0).(router.Zone), args.Error(1)
+}
+
+func (m *Mock) SessionTouch(sessionId string) {
+	m.Called(sessionId)
+}
+
+func (m *Mock) SessionLastActivity(sessionId string) time.Time {
+	args := m.Called(sessionId)
+	return args.Get(0).(time.Time)
+}
+
 func (m *Mock) SessionGet(id string) (*types.Session, error) {
 	args := m.Called(id)
 	return args.Get(0).(*types.Session), args.Error(1)
@@ -68,6 +135,21 @@ func (m *Mock) InstanceUploadFromReader(instance *types.Instance, fileName, dest
 	return args.Error(0)
 }
 
+func (m *Mock) InstanceLessonSetup(instance *types.Instance, lessonCtx types.LessonContext) error {
+	args := m.Called(instance, lessonCtx)
+	return args.Error(0)
+}
+
+func (m *Mock) InstanceSnapshot(instance *types.Instance, image string) (*types.InstanceSnapshot, error) {
+	args := m.Called(instance, image)
+	return args.Get(0).(*types.InstanceSnapshot), args.Error(1)
+}
+
+func (m *Mock) InstanceSnapshotDelete(session *types.Session, snapshot *types.InstanceSnapshot) error {
+	args := m.Called(session, snapshot)
+	return args.Error(0)
+}
+
 func (m *Mock) InstanceGet(session *types.Session, name string) *types.Instance {
 	args := m.Called(session, name)
 	return args.Get(0).(*types.Instance)
@@ -97,6 +179,46 @@ func (m *Mock) InstanceFile(instance *types.Instance, filePath string) (io.Reade
 	return args.Get(0).(io.Reader), args.Error(1)
 }
 
+func (m *Mock) InstanceFileWrite(instance *types.Instance, filePath string, content io.Reader, checksum string) (string, error) {
+	args := m.Called(instance, filePath, content, checksum)
+	return args.String(0), args.Error(1)
+}
+
+func (m *Mock) InstanceFileMove(instance *types.Instance, from, to string) error {
+	args := m.Called(instance, from, to)
+	return args.Error(0)
+}
+
+func (m *Mock) InstanceFileDelete(instance *types.Instance, filePath string) error {
+	args := m.Called(instance, filePath)
+	return args.Error(0)
+}
+
+func (m *Mock) InstanceMkdir(instance *types.Instance, dirPath string) error {
+	args := m.Called(instance, dirPath)
+	return args.Error(0)
+}
+
+func (m *Mock) InstanceChmod(instance *types.Instance, filePath string, mode os.FileMode) error {
+	args := m.Called(instance, filePath, mode)
+	return args.Error(0)
+}
+
+func (m *Mock) InstanceFileSearch(instance *types.Instance, dir, pattern string, regex bool) ([]types.FileMatch, error) {
+	args := m.Called(instance, dir, pattern, regex)
+	return args.Get(0).([]types.FileMatch), args.Error(1)
+}
+
+func (m *Mock) InstanceArchive(instance *types.Instance, dir string) (io.ReadCloser, error) {
+	args := m.Called(instance, dir)
+	return args.Get(0).(io.ReadCloser), args.Error(1)
+}
+
+func (m *Mock) InstanceExtract(instance *types.Instance, dir string, archive io.Reader) error {
+	args := m.Called(instance, dir, archive)
+	return args.Error(0)
+}
+
 func (m *Mock) ClientNew(id string, session *types.Session) *types.Client {
 	args := m.Called(id, session)
 	return args.Get(0).(*types.Client)
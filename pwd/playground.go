@@ -8,7 +8,7 @@ import (
 	"github.com/ringo380/lessoncraft/pwd/types"
 )
 
-func (p *pwd) PlaygroundNew(playground types.Playground) (*types.Playground, error) {
+func (p *lessoncraft) PlaygroundNew(playground types.Playground) (*types.Playground, error) {
 	playground.Id = uuid.NewV5(uuid.NamespaceOID, playground.Domain).String()
 	if err := p.storage.PlaygroundPut(&playground); err != nil {
 		log.Printf("Error saving playground %s. Got: %v\n", playground.Id, err)
@@ -19,7 +19,7 @@ func (p *pwd) PlaygroundNew(playground types.Playground) (*types.Playground, err
 	return &playground, nil
 }
 
-func (p *pwd) PlaygroundGet(id string) *types.Playground {
+func (p *lessoncraft) PlaygroundGet(id string) *types.Playground {
 	if playground, err := p.storage.PlaygroundGet(id); err != nil {
 		log.Printf("Error retrieving playground %s. Got: %v\n", id, err)
 		return nil
@@ -28,11 +28,11 @@ func (p *pwd) PlaygroundGet(id string) *types.Playground {
 	}
 }
 
-func (p *pwd) PlaygroundFindByDomain(domain string) *types.Playground {
+func (p *lessoncraft) PlaygroundFindByDomain(domain string) *types.Playground {
 	id := uuid.NewV5(uuid.NamespaceOID, domain).String()
 	return p.PlaygroundGet(id)
 }
 
-func (p *pwd) PlaygroundList() ([]*types.Playground, error) {
+func (p *lessoncraft) PlaygroundList() ([]*types.Playground, error) {
 	return p.storage.PlaygroundGetAll()
 }
@@ -0,0 +1,153 @@
+package pwd
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/ringo380/lessoncraft/config"
+	"github.com/ringo380/lessoncraft/event"
+	"github.com/ringo380/lessoncraft/pwd/types"
+	"github.com/ringo380/lessoncraft/router"
+)
+
+// maxSessionPorts is how many ports a session may have forwarded
+const maxSessionPorts = 5
+
+var (
+	ErrInvalidPort  = errors.New("Invalid port forward")
+	ErrPortNotFound = errors.New("Port forward not found")
+	ErrNoFreePort   = errors.New("No free port left to forward")
+)
+
+// SessionPortAdd forwards a port of the l2 router, allocated from the
+// configured range, to a port of an instance of the session
+func (p *lessoncraft) SessionPortAdd(session *types.Session, port types.SessionPort) (*types.SessionPort, error) {
+	defer observeAction("SessionPortAdd", time.Now())
+
+	if port.Protocol != router.ForwardTCP && port.Protocol != router.ForwardUDP {
+		return nil, fmt.Errorf("%w: protocol must be tcp or udp", ErrInvalidPort)
+	}
+	if port.InstancePort < 1 || port.InstancePort > 65535 {
+		return nil, fmt.Errorf("%w: port must be between 1 and 65535", ErrInvalidPort)
+	}
+	if p.endpointInstance(session, port.Instance) == nil {
+		return nil, fmt.Errorf("%w: instance %s not found", ErrInvalidPort, port.Instance)
+	}
+	if len(session.Ports) >= maxSessionPorts {
+		return nil, fmt.Errorf("%w: sessions cannot have more than %d ports", ErrInvalidPort, maxSessionPorts)
+	}
+
+	p.portsMu.Lock()
+	defer p.portsMu.Unlock()
+
+	external, err := p.allocatePort(port.Protocol)
+	if err != nil {
+		return nil, err
+	}
+	port.Port = external
+	port.CreatedAt = time.Now()
+	session.Ports = append(session.Ports, port)
+	if err := p.storage.SessionPut(session); err != nil {
+		session.Ports = session.Ports[:len(session.Ports)-1]
+		log.Println(err)
+		return nil, err
+	}
+	log.Printf("Forwarding %s port %d to %s:%d of session [%s]\n", port.Protocol, port.Port, port.Instance, port.InstancePort, session.Id)
+	p.event.Emit(event.SESSION_PORTS, session.Id, session.Ports)
+	return &port, nil
+}
+
+// SessionPortRemove stops forwarding an external port to the session
+func (p *lessoncraft) SessionPortRemove(session *types.Session, protocol string, port int) error {
+	defer observeAction("SessionPortRemove", time.Now())
+
+	ports := []types.SessionPort{}
+	for _, sp := range session.Ports {
+		if sp.Protocol != protocol || sp.Port != port {
+			ports = append(ports, sp)
+		}
+	}
+	if len(ports) == len(session.Ports) {
+		return ErrPortNotFound
+	}
+
+	before := session.Ports
+	session.Ports = ports
+	if err := p.storage.SessionPut(session); err != nil {
+		session.Ports = before
+		log.Println(err)
+		return err
+	}
+	p.event.Emit(event.SESSION_PORTS, session.Id, session.Ports)
+	return nil
+}
+
+// PortForwards returns the forwards of the ports of every session, for the
+// l2 router to serve. Ports of instances that are gone are left out.
+func (p *lessoncraft) PortForwards() ([]router.PortForward, error) {
+	sessions, err := p.storage.SessionGetAll()
+	if err != nil {
+		return nil, err
+	}
+	forwards := []router.PortForward{}
+	for _, s := range sessions {
+		for _, sp := range s.Ports {
+			instance := p.endpointInstance(s, sp.Instance)
+			if instance == nil {
+				continue
+			}
+			forwards = append(forwards, router.PortForward{
+				Protocol:  sp.Protocol,
+				Port:      sp.Port,
+				SessionId: s.Id,
+				Dst:       net.JoinHostPort(instance.RoutableIP, strconv.Itoa(sp.InstancePort)),
+			})
+		}
+	}
+	return forwards, nil
+}
+
+// allocatePort returns the first port of the range not forwarded yet. The
+// ports lock must be held.
+func (p *lessoncraft) allocatePort(protocol string) (int, error) {
+	first, last, err := router.ParsePortRange(config.L2PortRange)
+	if err != nil {
+		return 0, err
+	}
+	sessions, err := p.storage.SessionGetAll()
+	if err != nil {
+		return 0, err
+	}
+	used := map[int]bool{}
+	for _, s := range sessions {
+		for _, sp := range s.Ports {
+			if sp.Protocol == protocol {
+				used[sp.Port] = true
+			}
+		}
+	}
+	for port := first; port <= last; port++ {
+		if !used[port] {
+			return port, nil
+		}
+	}
+	return 0, ErrNoFreePort
+}
+
+// sessionReleasePorts stops forwarding the ports of a session that is being
+// closed, so the router drops them before its instances go
+func (p *lessoncraft) sessionReleasePorts(session *types.Session) {
+	if len(session.Ports) == 0 {
+		return
+	}
+	session.Ports = nil
+	if err := p.storage.SessionPut(session); err != nil {
+		log.Println(err)
+		return
+	}
+	p.event.Emit(event.SESSION_PORTS, session.Id, session.Ports)
+}
@@ -5,6 +5,8 @@ import (
 	"errors"
 	"io"
 	"net"
+	"os"
+	"sync"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
@@ -13,6 +15,7 @@ import (
 	"github.com/ringo380/lessoncraft/id"
 	"github.com/ringo380/lessoncraft/provisioner"
 	"github.com/ringo380/lessoncraft/pwd/types"
+	"github.com/ringo380/lessoncraft/router"
 	"github.com/ringo380/lessoncraft/storage"
 )
 
@@ -58,6 +61,9 @@ type lessoncraft struct {
 	instanceProvisionerFactory provisioner.InstanceProvisionerFactoryApi
 	windowsProvisioner         provisioner.InstanceProvisionerApi
 	dindProvisioner            provisioner.InstanceProvisionerApi
+	quota                      QuotaApi
+	activity                   *activity
+	portsMu                    sync.Mutex
 }
 
 var sessionNotEmpty = errors.New("Session is not empty")
@@ -75,6 +81,19 @@ type LessonCraftApi interface {
 	SessionDeployStack(session *types.Session) error
 	SessionGet(id string) (*types.Session, error)
 	SessionSetup(session *types.Session, conf SessionSetupConf) error
+	SessionExtend(session *types.Session, d time.Duration) error
+	SessionPause(session *types.Session) error
+	SessionHibernate(session *types.Session) error
+	SessionResume(session *types.Session) error
+	SessionEndpointAdd(session *types.Session, endpoint types.SessionEndpoint) error
+	SessionEndpointRemove(session *types.Session, name string) error
+	SessionEndpointResolve(session *types.Session, name string) (*types.Instance, int, error)
+	SessionPortAdd(session *types.Session, port types.SessionPort) (*types.SessionPort, error)
+	SessionPortRemove(session *types.Session, protocol string, port int) error
+	PortForwards() ([]router.PortForward, error)
+	SessionZone(session *types.Session) (router.Zone, error)
+	SessionTouch(sessionId string)
+	SessionLastActivity(sessionId string) time.Time
 
 	InstanceNew(session *types.Session, conf types.InstanceConfig) (*types.Instance, error)
 	InstanceResizeTerminal(instance *types.Instance, cols, rows uint) error
@@ -87,6 +106,17 @@ type LessonCraftApi interface {
 	InstanceExec(instance *types.Instance, cmd []string) (int, error)
 	InstanceFSTree(instance *types.Instance) (io.Reader, error)
 	InstanceFile(instance *types.Instance, filePath string) (io.Reader, error)
+	InstanceFileWrite(instance *types.Instance, filePath string, content io.Reader, checksum string) (string, error)
+	InstanceFileMove(instance *types.Instance, from, to string) error
+	InstanceFileDelete(instance *types.Instance, filePath string) error
+	InstanceMkdir(instance *types.Instance, dirPath string) error
+	InstanceChmod(instance *types.Instance, filePath string, mode os.FileMode) error
+	InstanceFileSearch(instance *types.Instance, dir, pattern string, regex bool) ([]types.FileMatch, error)
+	InstanceArchive(instance *types.Instance, dir string) (io.ReadCloser, error)
+	InstanceExtract(instance *types.Instance, dir string, archive io.Reader) error
+	InstanceSnapshot(instance *types.Instance, image string) (*types.InstanceSnapshot, error)
+	InstanceLessonSetup(instance *types.Instance, lessonCtx types.LessonContext) error
+	InstanceSnapshotDelete(session *types.Session, snapshot *types.InstanceSnapshot) error
 
 	ClientNew(id string, session *types.Session) *types.Client
 	ClientResizeViewPort(client *types.Client, cols, rows uint)
@@ -104,11 +134,15 @@ type LessonCraftApi interface {
 	PlaygroundList() ([]*types.Playground, error)
 }
 
+// PWDApi is the previous name of LessonCraftApi
+// Deprecated: Use LessonCraftApi instead
+type PWDApi = LessonCraftApi
+
 // NewLessonCraft creates a new instance of the LessonCraft core functionality
 // This is the preferred function to use instead of NewPWD
 func NewLessonCraft(f docker.FactoryApi, e event.EventApi, s storage.StorageApi, sp provisioner.SessionProvisionerApi, ipf provisioner.InstanceProvisionerFactoryApi) *lessoncraft {
 	//  windowsProvisioner: provisioner.NewWindowsASG(f, s), dindProvisioner: provisioner.NewDinD(f)
-	return &lessoncraft{dockerFactory: f, event: e, storage: s, generator: id.XIDGenerator{}, sessionProvisioner: sp, instanceProvisionerFactory: ipf}
+	return &lessoncraft{dockerFactory: f, event: e, storage: s, generator: id.XIDGenerator{}, sessionProvisioner: sp, instanceProvisionerFactory: ipf, activity: newActivity()}
 }
 
 // NewPWD creates a new instance of the LessonCraft core functionality
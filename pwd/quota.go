@@ -0,0 +1,39 @@
+package pwd
+
+import (
+	"log"
+
+	"github.com/ringo380/lessoncraft/pwd/types"
+)
+
+// QuotaApi admits sessions and instances within the quotas of their users,
+// organisations and playgrounds
+type QuotaApi interface {
+	AcquireSession(session *types.Session) error
+	ReleaseSession(session *types.Session) error
+	AcquireInstance(session *types.Session, conf types.InstanceConfig) (string, error)
+	BindInstance(id, instanceName string) error
+	Cancel(id string) error
+	ReleaseInstance(session *types.Session, instanceName string) error
+}
+
+// SetQuota makes new sessions and instances count against quotas. Without it
+// only the playground instance limit applies.
+func (p *lessoncraft) SetQuota(q QuotaApi) {
+	p.quota = q
+}
+
+func (p *lessoncraft) cancelQuota(id string) {
+	if err := p.quota.Cancel(id); err != nil {
+		log.Printf("Could not cancel quota reservation [%s]: %v\n", id, err)
+	}
+}
+
+func (p *lessoncraft) releaseSessionQuota(s *types.Session) {
+	if p.quota == nil {
+		return
+	}
+	if err := p.quota.ReleaseSession(s); err != nil {
+		log.Printf("Could not release quota of session [%s]: %v\n", s.Id, err)
+	}
+}
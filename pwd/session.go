@@ -15,6 +15,7 @@ import (
 
 	"github.com/ringo380/lessoncraft/docker"
 	"github.com/ringo380/lessoncraft/event"
+	"github.com/ringo380/lessoncraft/netpolicy"
 	"github.com/ringo380/lessoncraft/pwd/types"
 )
 
@@ -79,6 +80,7 @@ func (p *lessoncraft) SessionNew(ctx context.Context, config types.SessionConfig
 	s.Stack = config.Stack
 	s.UserId = config.UserId
 	s.PlaygroundId = config.Playground.Id
+	s.OrgId = config.OrgId
 
 	if s.Stack != "" {
 		s.Ready = false
@@ -90,27 +92,47 @@ func (p *lessoncraft) SessionNew(ctx context.Context, config types.SessionConfig
 	s.StackName = stackName
 	s.ImageName = config.ImageName
 
+	if v, found := config.Playground.Extras.Get(netpolicy.PlaygroundExtra); found {
+		policy, err := netpolicy.Parse(v)
+		if err != nil {
+			log.Printf("Playground [%s] has an invalid network policy: %v\n", config.Playground.Id, err)
+			return nil, err
+		}
+		s.NetworkPolicy = policy
+	}
+
+	if p.quota != nil {
+		if err := p.quota.AcquireSession(s); err != nil {
+			log.Printf("Session for user [%s] not admitted: %v\n", s.UserId, err)
+			return nil, err
+		}
+	}
+
 	log.Printf("NewSession id=[%s]\n", s.Id)
 	if err := p.sessionProvisioner.SessionNew(ctx, s); err != nil {
 		log.Println(err)
+		p.releaseSessionQuota(s)
 		return nil, err
 	}
 
 	if err := p.storage.SessionPut(s); err != nil {
 		log.Println(err)
+		p.releaseSessionQuota(s)
 		return nil, err
 	}
 
+	p.SessionTouch(s.Id)
 	p.setGauges()
 	p.event.Emit(event.SESSION_NEW, s.Id)
 
 	return s, nil
 }
 
-func (p *pwd) SessionClose(s *types.Session) error {
+func (p *lessoncraft) SessionClose(s *types.Session) error {
 	defer observeAction("SessionClose", time.Now())
 
 	log.Printf("Starting clean up of session [%s]\n", s.Id)
+	p.sessionReleasePorts(s)
 	g, _ := errgroup.WithContext(context.Background())
 	instances, err := p.storage.InstanceFindBySessionId(s.Id)
 	if err != nil {
@@ -138,14 +160,16 @@ func (p *pwd) SessionClose(s *types.Session) error {
 	if err != nil {
 		return err
 	}
+	p.releaseSessionQuota(s)
 
+	p.activity.forget(s.Id)
 	log.Printf("Cleaned up session [%s]\n", s.Id)
 	p.setGauges()
 	p.event.Emit(event.SESSION_END, s.Id)
 	return nil
 }
 
-func (p *pwd) SessionGetSmallestViewPort(sessionId string) types.ViewPort {
+func (p *lessoncraft) SessionGetSmallestViewPort(sessionId string) types.ViewPort {
 	defer observeAction("SessionGetSmallestViewPort", time.Now())
 
 	clients, err := p.storage.ClientFindBySessionId(sessionId)
@@ -178,7 +202,7 @@ func (p *pwd) SessionGetSmallestViewPort(sessionId string) types.ViewPort {
 	return types.ViewPort{Rows: minRows, Cols: minCols}
 }
 
-func (p *pwd) SessionDeployStack(s *types.Session) error {
+func (p *lessoncraft) SessionDeployStack(s *types.Session) error {
 	defer observeAction("SessionDeployStack", time.Now())
 
 	if s.Ready {
@@ -233,7 +257,7 @@ func (p *pwd) SessionDeployStack(s *types.Session) error {
 	return nil
 }
 
-func (p *pwd) SessionGet(sessionId string) (*types.Session, error) {
+func (p *lessoncraft) SessionGet(sessionId string) (*types.Session, error) {
 	defer observeAction("SessionGet", time.Now())
 
 	s, err := p.storage.SessionGet(sessionId)
@@ -245,7 +269,7 @@ func (p *pwd) SessionGet(sessionId string) (*types.Session, error) {
 	return s, nil
 }
 
-func (p *pwd) SessionSetup(session *types.Session, sconf SessionSetupConf) error {
+func (p *lessoncraft) SessionSetup(session *types.Session, sconf SessionSetupConf) error {
 	defer observeAction("SessionSetup", time.Now())
 
 	c := sync.NewCond(&sync.Mutex{})
@@ -353,3 +377,201 @@ func (p *pwd) SessionSetup(session *types.Session, sconf SessionSetupConf) error
 
 	return nil
 }
+
+// ExtensionDeniedError is returned when the limits of the playground do not
+// allow to extend a session any further
+type ExtensionDeniedError struct {
+	Reason string
+}
+
+func (e *ExtensionDeniedError) Error() string {
+	return fmt.Sprintf("Session cannot be extended: %s", e.Reason)
+}
+
+// ExtensionDenied checks if an error was caused by the playground limits
+func ExtensionDenied(err error) bool {
+	var e *ExtensionDeniedError
+	return errors.As(err, &e)
+}
+
+// SessionExtend pushes back the expiry of a session by d, up to the maximum
+// session duration of its playground
+func (p *lessoncraft) SessionExtend(session *types.Session, d time.Duration) error {
+	defer observeAction("SessionExtend", time.Now())
+
+	if d <= 0 {
+		return fmt.Errorf("Extension must be positive, got %s", d)
+	}
+	playground, err := p.storage.PlaygroundGet(session.PlaygroundId)
+	if err != nil {
+		return err
+	}
+	if playground.MaxSessionDuration <= 0 {
+		return &ExtensionDeniedError{Reason: "the playground does not allow extensions"}
+	}
+	if playground.MaxSessionExtensions > 0 && session.Extensions >= playground.MaxSessionExtensions {
+		return &ExtensionDeniedError{Reason: fmt.Sprintf("it was already extended %d times", session.Extensions)}
+	}
+
+	expiresAt := session.ExpiresAt.Add(d)
+	if limit := session.CreatedAt.Add(playground.MaxSessionDuration); expiresAt.After(limit) {
+		expiresAt = limit
+	}
+	if !expiresAt.After(session.ExpiresAt) {
+		return &ExtensionDeniedError{Reason: fmt.Sprintf("it reached the maximum duration of %s", playground.MaxSessionDuration)}
+	}
+
+	session.ExpiresAt = expiresAt
+	session.Extensions++
+	if err := p.storage.SessionPut(session); err != nil {
+		log.Println(err)
+		return err
+	}
+	p.SessionTouch(session.Id)
+	log.Printf("Session [%s] extended until %s\n", session.Id, session.ExpiresAt)
+	p.event.Emit(event.SESSION_EXTENDED, session.Id, session.ExpiresAt)
+	return nil
+}
+
+// SessionPause freezes the instances of a session so they use no CPU until
+// the session is resumed. Their filesystems, memory and addresses are kept,
+// and the session keeps expiring while paused.
+func (p *lessoncraft) SessionPause(session *types.Session) error {
+	defer observeAction("SessionPause", time.Now())
+
+	if session.Paused() {
+		return nil
+	}
+	instances, err := p.storage.InstanceFindBySessionId(session.Id)
+	if err != nil {
+		return err
+	}
+	for i, instance := range instances {
+		prov, err := p.getProvisioner(instance.Type)
+		if err == nil {
+			err = prov.InstancePause(session, instance)
+		}
+		if err != nil {
+			log.Printf("Could not pause instance [%s]: %v\n", instance.Name, err)
+			// Sessions are either paused or running, never half of each
+			p.resumeInstances(session, instances[:i])
+			return err
+		}
+	}
+
+	session.PausedAt = time.Now()
+	if err := p.storage.SessionPut(session); err != nil {
+		log.Println(err)
+		return err
+	}
+	log.Printf("Session [%s] paused\n", session.Id)
+	p.event.Emit(event.SESSION_PAUSED, session.Id)
+	return nil
+}
+
+// SessionHibernate stops the instances of a session to free their CPU and
+// memory until the session is resumed. Their filesystems are kept, but their
+// processes are restarted on resume. The session keeps expiring while
+// hibernated.
+func (p *lessoncraft) SessionHibernate(session *types.Session) error {
+	defer observeAction("SessionHibernate", time.Now())
+
+	if session.Paused() {
+		return nil
+	}
+	instances, err := p.storage.InstanceFindBySessionId(session.Id)
+	if err != nil {
+		return err
+	}
+	for i, instance := range instances {
+		prov, err := p.getProvisioner(instance.Type)
+		if err == nil {
+			err = prov.InstanceStop(session, instance)
+		}
+		if err != nil {
+			log.Printf("Could not stop instance [%s]: %v\n", instance.Name, err)
+			p.startInstances(session, instances[:i])
+			return err
+		}
+	}
+
+	session.PausedAt = time.Now()
+	session.Hibernated = true
+	if err := p.storage.SessionPut(session); err != nil {
+		log.Println(err)
+		return err
+	}
+	log.Printf("Session [%s] hibernated\n", session.Id)
+	p.event.Emit(event.SESSION_PAUSED, session.Id)
+	return nil
+}
+
+// SessionResume thaws the instances of a paused session, or starts them again
+// if it was hibernated
+func (p *lessoncraft) SessionResume(session *types.Session) error {
+	defer observeAction("SessionResume", time.Now())
+
+	if !session.Paused() {
+		return nil
+	}
+	instances, err := p.storage.InstanceFindBySessionId(session.Id)
+	if err != nil {
+		return err
+	}
+	resume := p.resumeInstances
+	if session.Hibernated {
+		resume = p.startInstances
+	}
+	if err := resume(session, instances); err != nil {
+		return err
+	}
+
+	session.PausedAt = time.Time{}
+	session.Hibernated = false
+	if err := p.storage.SessionPut(session); err != nil {
+		log.Println(err)
+		return err
+	}
+	p.SessionTouch(session.Id)
+	log.Printf("Session [%s] resumed\n", session.Id)
+	p.event.Emit(event.SESSION_RESUMED, session.Id)
+	return nil
+}
+
+func (p *lessoncraft) startInstances(session *types.Session, instances []*types.Instance) error {
+	var firstErr error
+	for _, instance := range instances {
+		prov, err := p.getProvisioner(instance.Type)
+		if err == nil {
+			err = prov.InstanceStart(session, instance)
+		}
+		if err == nil {
+			// Started instances may have new addresses
+			err = p.storage.InstancePut(instance)
+		}
+		if err != nil {
+			log.Printf("Could not start instance [%s]: %v\n", instance.Name, err)
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	return firstErr
+}
+
+func (p *lessoncraft) resumeInstances(session *types.Session, instances []*types.Instance) error {
+	var firstErr error
+	for _, instance := range instances {
+		prov, err := p.getProvisioner(instance.Type)
+		if err == nil {
+			err = prov.InstanceResume(session, instance)
+		}
+		if err != nil {
+			log.Printf("Could not resume instance [%s]: %v\n", instance.Name, err)
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	return firstErr
+}
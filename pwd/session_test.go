@@ -6,13 +6,14 @@ import (
 	"testing"
 	"time"
 
-	dtypes "github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/network"
 	"github.com/ringo380/lessoncraft/config"
 	"github.com/ringo380/lessoncraft/docker"
 	"github.com/ringo380/lessoncraft/event"
 	"github.com/ringo380/lessoncraft/id"
 	"github.com/ringo380/lessoncraft/provisioner"
 	"github.com/ringo380/lessoncraft/pwd/types"
+	"github.com/ringo380/lessoncraft/router"
 	"github.com/ringo380/lessoncraft/storage"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
@@ -32,7 +33,7 @@ func TestSessionNew(t *testing.T) {
 
 	_g.On("NewId").Return("aaaabbbbcccc")
 	_f.On("GetForSession", mock.AnythingOfType("*types.Session")).Return(_d, nil)
-	_d.On("NetworkCreate", "aaaabbbbcccc", dtypes.NetworkCreate{Attachable: true, Driver: "overlay"}).Return(nil)
+	_d.On("NetworkCreate", "aaaabbbbcccc", network.CreateOptions{Attachable: true, Driver: "overlay"}).Return(nil)
 	_d.On("DaemonHost").Return("localhost")
 	_d.On("NetworkConnect", config.L2ContainerName, "aaaabbbbcccc", "").Return("10.0.0.1", nil)
 	_s.On("SessionPut", mock.AnythingOfType("*types.Session")).Return(nil)
@@ -126,7 +127,7 @@ func TestSessionSetup(t *testing.T) {
 
 	_g.On("NewId").Return("aaaabbbbcccc")
 	_f.On("GetForSession", "aaaabbbbcccc").Return(_d, nil)
-	_d.On("NetworkCreate", "aaaabbbbcccc", dtypes.NetworkCreate{Attachable: true, Driver: "overlay"}).Return(nil)
+	_d.On("NetworkCreate", "aaaabbbbcccc", network.CreateOptions{Attachable: true, Driver: "overlay"}).Return(nil)
 	_d.On("DaemonHost").Return("localhost")
 	_d.On("NetworkConnect", config.L2ContainerName, "aaaabbbbcccc", "").Return("10.0.0.1", nil)
 	_s.On("SessionPut", mock.AnythingOfType("*types.Session")).Return(nil)
@@ -207,3 +208,146 @@ func TestSessionSetup(t *testing.T) {
 	_e.M.AssertExpectations(t)
 }
 */
+
+func TestSessionExtend(t *testing.T) {
+	_f := &docker.FactoryMock{}
+	_s := &storage.Mock{}
+	_e := &event.Mock{}
+
+	p := NewPWD(_f, _e, _s, nil, nil)
+
+	created := time.Now()
+	session := &types.Session{Id: "aaaabbbbcccc", PlaygroundId: "foobar", CreatedAt: created, ExpiresAt: created.Add(time.Hour)}
+	playground := &types.Playground{Id: "foobar", MaxSessionDuration: 2 * time.Hour, MaxSessionExtensions: 2}
+	_s.On("PlaygroundGet", "foobar").Return(playground, nil)
+	_s.On("SessionPut", session).Return(nil)
+	_e.M.On("Emit", event.SESSION_EXTENDED, "aaaabbbbcccc", mock.Anything).Return()
+
+	assert.NotNil(t, p.SessionExtend(session, -time.Minute))
+
+	assert.Nil(t, p.SessionExtend(session, 30*time.Minute))
+	assert.Equal(t, created.Add(90*time.Minute), session.ExpiresAt)
+
+	// Extensions are capped by the maximum session duration
+	assert.Nil(t, p.SessionExtend(session, time.Hour))
+	assert.Equal(t, created.Add(2*time.Hour), session.ExpiresAt)
+	assert.Equal(t, 2, session.Extensions)
+
+	err := p.SessionExtend(session, time.Minute)
+	assert.True(t, ExtensionDenied(err))
+
+	playground.MaxSessionExtensions = 0
+	err = p.SessionExtend(session, time.Minute)
+	assert.True(t, ExtensionDenied(err))
+	assert.Equal(t, created.Add(2*time.Hour), session.ExpiresAt)
+
+	_s.AssertNumberOfCalls(t, "SessionPut", 2)
+}
+
+func TestSessionEndpoints(t *testing.T) {
+	_f := &docker.FactoryMock{}
+	_s := &storage.Mock{}
+	_e := &event.Mock{}
+
+	p := NewPWD(_f, _e, _s, nil, nil)
+
+	session := &types.Session{Id: "aaaabbbbcccc"}
+	instance := &types.Instance{Name: "aaaabbbbcccc_node1", SessionId: "aaaabbbbcccc", RoutableIP: "10.0.0.1"}
+	other := &types.Instance{Name: "ddddeeeeffff_node1", SessionId: "ddddeeeeffff"}
+	_s.On("InstanceGet", instance.Name).Return(instance, nil)
+	_s.On("InstanceGet", other.Name).Return(other, nil)
+	_s.On("SessionPut", session).Return(nil)
+	_e.M.On("Emit", event.SESSION_ENDPOINTS, "aaaabbbbcccc", mock.Anything).Return()
+
+	assert.Nil(t, p.SessionEndpointAdd(session, types.SessionEndpoint{Name: "frontend", Instance: instance.Name, Port: 3000}))
+	assert.Equal(t, ErrEndpointExists, p.SessionEndpointAdd(session, types.SessionEndpoint{Name: "frontend", Instance: instance.Name, Port: 8080}))
+	for _, e := range []types.SessionEndpoint{
+		{Name: "Frontend", Instance: instance.Name, Port: 3000},
+		{Name: "ip10-0-0-1", Instance: instance.Name, Port: 3000},
+		{Name: "api", Instance: instance.Name, Port: 70000},
+		{Name: "api", Instance: other.Name, Port: 3000},
+	} {
+		assert.True(t, errors.Is(p.SessionEndpointAdd(session, e), ErrInvalidEndpoint), "%+v", e)
+	}
+
+	i, port, err := p.SessionEndpointResolve(session, "frontend")
+	assert.Nil(t, err)
+	assert.Equal(t, instance, i)
+	assert.Equal(t, 3000, port)
+
+	assert.Nil(t, p.SessionEndpointRemove(session, "frontend"))
+	assert.Empty(t, session.Endpoints)
+	assert.Equal(t, ErrEndpointNotFound, p.SessionEndpointRemove(session, "frontend"))
+	_, _, err = p.SessionEndpointResolve(session, "frontend")
+	assert.Equal(t, ErrEndpointNotFound, err)
+
+	_s.AssertNumberOfCalls(t, "SessionPut", 2)
+}
+
+func TestSessionPorts(t *testing.T) {
+	_f := &docker.FactoryMock{}
+	_s := &storage.Mock{}
+	_e := &event.Mock{}
+
+	p := NewPWD(_f, _e, _s, nil, nil)
+	config.L2PortRange = "30000-30002"
+
+	session := &types.Session{Id: "aaaabbbbcccc"}
+	other := &types.Session{Id: "ddddeeeeffff", Ports: []types.SessionPort{{Protocol: "tcp", Port: 30000, Instance: "gone", InstancePort: 22}}}
+	instance := &types.Instance{Name: "aaaabbbbcccc_node1", SessionId: "aaaabbbbcccc", RoutableIP: "10.0.0.1"}
+	_s.On("InstanceGet", instance.Name).Return(instance, nil)
+	_s.On("InstanceGet", "gone").Return((*types.Instance)(nil), storage.NotFoundError)
+	_s.On("SessionGetAll").Return([]*types.Session{session, other}, nil)
+	_s.On("SessionPut", session).Return(nil)
+	_e.M.On("Emit", event.SESSION_PORTS, "aaaabbbbcccc", mock.Anything).Return()
+
+	_, err := p.SessionPortAdd(session, types.SessionPort{Protocol: "sctp", Instance: instance.Name, InstancePort: 5432})
+	assert.True(t, errors.Is(err, ErrInvalidPort))
+
+	// Ports forwarded for other sessions are skipped, per protocol
+	port, err := p.SessionPortAdd(session, types.SessionPort{Protocol: "tcp", Instance: instance.Name, InstancePort: 5432})
+	assert.Nil(t, err)
+	assert.Equal(t, 30001, port.Port)
+	port, err = p.SessionPortAdd(session, types.SessionPort{Protocol: "udp", Instance: instance.Name, InstancePort: 27015})
+	assert.Nil(t, err)
+	assert.Equal(t, 30000, port.Port)
+	_, err = p.SessionPortAdd(session, types.SessionPort{Protocol: "tcp", Instance: instance.Name, InstancePort: 6379})
+	assert.Nil(t, err)
+	_, err = p.SessionPortAdd(session, types.SessionPort{Protocol: "tcp", Instance: instance.Name, InstancePort: 8080})
+	assert.Equal(t, ErrNoFreePort, err)
+
+	// Ports of instances that are gone are not forwarded
+	forwards, err := p.PortForwards()
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(forwards))
+	assert.Equal(t, router.PortForward{Protocol: "tcp", Port: 30001, SessionId: "aaaabbbbcccc", Dst: "10.0.0.1:5432"}, forwards[0])
+
+	assert.Nil(t, p.SessionPortRemove(session, "tcp", 30001))
+	assert.Equal(t, ErrPortNotFound, p.SessionPortRemove(session, "tcp", 30001))
+	assert.Equal(t, 2, len(session.Ports))
+
+	p.sessionReleasePorts(session)
+	assert.Empty(t, session.Ports)
+}
+
+func TestSessionZone(t *testing.T) {
+	_f := &docker.FactoryMock{}
+	_s := &storage.Mock{}
+	_e := &event.Mock{}
+
+	p := NewPWD(_f, _e, _s, nil, nil)
+
+	session := &types.Session{Id: "aaaabbbbcccc"}
+	instances := []*types.Instance{
+		{Name: "aaaabbbbcccc_node1", Hostname: "node1", IP: "10.0.0.1", Image: "franela/dind"},
+		{Name: "aaaabbbbcccc_node2", Hostname: "postgres", Role: "db", IP: "10.0.0.2", Image: "postgres", Ports: []string{"5432", "bogus"}},
+	}
+	_s.On("InstanceFindBySessionId", "aaaabbbbcccc").Return(instances, nil)
+
+	zone, err := p.SessionZone(session)
+	assert.Nil(t, err)
+	assert.Equal(t, router.Zone{SessionId: "aaaabbbbcccc", Records: []router.ZoneRecord{
+		{Hostname: "node1", IP: "10.0.0.1", Metadata: map[string]string{"instance": "aaaabbbbcccc_node1", "image": "franela/dind"}},
+		{Hostname: "postgres", Role: "db", IP: "10.0.0.2", Ports: []router.ServicePort{{Protocol: "tcp", Port: 5432}}, Metadata: map[string]string{"instance": "aaaabbbbcccc_node2", "image": "postgres"}},
+	}}, zone)
+}
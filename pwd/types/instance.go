@@ -1,6 +1,10 @@
 package types
 
-import "context"
+import (
+	"context"
+
+	"github.com/ringo380/lessoncraft/netpolicy"
+)
 
 type LessonContext struct {
 	LessonID  string `json:"lesson_id" bson:"lesson_id"`
@@ -9,24 +13,31 @@ type LessonContext struct {
 }
 
 type Instance struct {
-	Name        string          `json:"name" bson:"name"`
-	LessonCtx   *LessonContext  `json:"lesson_ctx,omitempty" bson:"lesson_ctx,omitempty"`
-	Image       string          `json:"image" bson:"image"`
-	Hostname    string          `json:"hostname" bson:"hostname"`
-	IP          string          `json:"ip" bson:"ip"`
-	RoutableIP  string          `json:"routable_ip" bson:"routable_id"`
-	ServerCert  []byte          `json:"server_cert" bson:"server_cert"`
-	ServerKey   []byte          `json:"server_key" bson:"server_key"`
-	CACert      []byte          `json:"ca_cert" bson:"ca_cert"`
-	Cert        []byte          `json:"cert" bson:"cert"`
-	Key         []byte          `json:"key" bson:"key"`
-	Tls         bool            `json:"tls" bson:"tls"`
-	SessionId   string          `json:"session_id" bson:"session_id"`
-	ProxyHost   string          `json:"proxy_host" bson:"proxy_host"`
-	SessionHost string          `json:"session_host" bson:"session_host"`
-	Type        string          `json:"type" bson:"type"`
-	WindowsId   string          `json:"-" bson:"windows_id"`
-	ctx         context.Context `json:"-" bson:"-"`
+	Name      string         `json:"name" bson:"name"`
+	LessonCtx *LessonContext `json:"lesson_ctx,omitempty" bson:"lesson_ctx,omitempty"`
+	Image     string         `json:"image" bson:"image"`
+	Hostname  string         `json:"hostname" bson:"hostname"`
+	// Role is the purpose of the instance in the session, e.g. db, which
+	// other instances can resolve
+	Role string `json:"role,omitempty" bson:"role,omitempty"`
+	// Ports are the ports the instance declares, e.g. 5432 or 53/udp
+	Ports       []string `json:"ports,omitempty" bson:"ports,omitempty"`
+	IP          string   `json:"ip" bson:"ip"`
+	RoutableIP  string   `json:"routable_ip" bson:"routable_id"`
+	ServerCert  []byte   `json:"server_cert" bson:"server_cert"`
+	ServerKey   []byte   `json:"server_key" bson:"server_key"`
+	CACert      []byte   `json:"ca_cert" bson:"ca_cert"`
+	Cert        []byte   `json:"cert" bson:"cert"`
+	Key         []byte   `json:"key" bson:"key"`
+	Tls         bool     `json:"tls" bson:"tls"`
+	SessionId   string   `json:"session_id" bson:"session_id"`
+	ProxyHost   string   `json:"proxy_host" bson:"proxy_host"`
+	SessionHost string   `json:"session_host" bson:"session_host"`
+	Type        string   `json:"type" bson:"type"`
+	WindowsId   string   `json:"-" bson:"windows_id"`
+	// NetworkPolicy is the network policy enforced for the instance, if any
+	NetworkPolicy *netpolicy.Policy `json:"network_policy,omitempty" bson:"network_policy,omitempty"`
+	ctx           context.Context   `json:"-" bson:"-"`
 }
 
 type WindowsInstance struct {
@@ -38,6 +49,8 @@ type InstanceConfig struct {
 	ImageName      string
 	Privileged     bool
 	Hostname       string
+	Role           string
+	Ports          []string
 	ServerCert     []byte
 	ServerKey      []byte
 	CACert         []byte
@@ -50,9 +63,33 @@ type InstanceConfig struct {
 	Envs           []string
 	Networks       []string
 	LessonCtx      *LessonContext
+	// SnapshotImage restores the instance from a snapshot. It takes precedence
+	// over the lesson and playground images.
+	SnapshotImage string
 
 	// Resource limits
-	MaxProcesses int64  // Maximum number of processes (default: 1000)
-	MaxMemoryMB  int64  // Maximum memory in MB (default: from environment)
-	StorageSize  string // Maximum storage size (default: from environment)
+	MaxProcesses int64   // Maximum number of processes (default: 1000)
+	MaxMemoryMB  int64   // Maximum memory in MB (default: from environment)
+	StorageSize  string  // Maximum storage size (default: from environment)
+	MaxCPUs      float64 // Maximum number of CPUs (default: no limit)
+}
+
+// InstanceSnapshot is the image an instance's filesystem was committed to
+type InstanceSnapshot struct {
+	Image string `json:"image" bson:"image"`
+	// Type is the type of the instance the snapshot was taken from
+	Type string `json:"type,omitempty" bson:"type,omitempty"`
+	// Size is the size the snapshot adds to the instance image, in bytes
+	Size int64 `json:"size" bson:"size"`
+	// InnerImages are the images of the instance's inner docker daemon. Its data
+	// lives in a volume that is not part of the snapshot, so they are pulled again
+	// on restore.
+	InnerImages []string `json:"inner_images,omitempty" bson:"inner_images,omitempty"`
+}
+
+// FileMatch is a line of a file of an instance found by a search
+type FileMatch struct {
+	Path string `json:"path"`
+	Line int    `json:"line"`
+	Text string `json:"text"`
 }
@@ -31,6 +31,36 @@ func (e PlaygroundExtras) GetInt(name string) (int, bool) {
 	}
 }
 
+// GetIntMap returns an object of integers, such as {"franela/dind": 3}. Values
+// are converted like GetInt; entries that are not numbers are skipped.
+func (e PlaygroundExtras) GetIntMap(name string) (map[string]int, bool) {
+	v, f := e[name]
+	if !f {
+		return nil, f
+	}
+	var values map[string]interface{}
+	switch m := v.(type) {
+	case map[string]int:
+		return m, true
+	case map[string]interface{}:
+		values = m
+	case PlaygroundExtras:
+		values = m
+	default:
+		return nil, false
+	}
+	r := make(map[string]int, len(values))
+	for k, value := range values {
+		switch value.(type) {
+		case int, float64, string:
+			if i, ok := PlaygroundExtras(values).GetInt(k); ok {
+				r[k] = i
+			}
+		}
+	}
+	return r, true
+}
+
 func (e PlaygroundExtras) GetString(name string) (string, bool) {
 	v, f := e[name]
 	if f {
@@ -112,4 +142,42 @@ type Playground struct {
 	DockerHost                  string           `json:"docker_host" bson:"docker_host"`
 	MaxInstances                int              `json:"max_instances" bson:"max_instances"`
 	Privileged                  bool             `json:"privileged" bson:"privileged"`
+
+	// MaxSessionDuration caps how long a session can run counting its
+	// extensions, zero means sessions cannot be extended
+	MaxSessionDuration time.Duration `json:"max_session_duration" bson:"max_session_duration"`
+	// MaxSessionExtensions limits how many times a session can be extended,
+	// zero means no limit other than MaxSessionDuration
+	MaxSessionExtensions int `json:"max_session_extensions" bson:"max_session_extensions"`
+	// SessionExpiryWarning is how long before a session expires
+	// SESSION_EXPIRING is emitted, zero means no warning
+	SessionExpiryWarning time.Duration `json:"session_expiry_warning" bson:"session_expiry_warning"`
+	// IdlePolicy reclaims sessions nobody uses before they expire
+	IdlePolicy IdlePolicy `json:"idle_policy" bson:"idle_policy"`
+}
+
+// IdleAction is what is done with a session that stays idle
+type IdleAction string
+
+const (
+	// IdleActionHibernate stops the instances of the session, it can be resumed later
+	IdleActionHibernate IdleAction = "hibernate"
+	// IdleActionClose closes the session
+	IdleActionClose IdleAction = "close"
+)
+
+// IdlePolicy decides when a session is idle and what happens to it then.
+// Sessions are active while a client is connected to them, and for Timeout
+// after the last terminal input, exec or API call.
+type IdlePolicy struct {
+	// Timeout is how long a session can stay idle before Action is taken, zero
+	// disables idle detection
+	Timeout time.Duration `json:"timeout" bson:"timeout"`
+	// Warning is how long before Action is taken SESSION_IDLE is emitted
+	Warning time.Duration `json:"warning" bson:"warning"`
+	// Action defaults to hibernate
+	Action IdleAction `json:"action" bson:"action"`
+	// IgnoreClients makes sessions idle even while a client is connected, if
+	// nobody types in their terminals
+	IgnoreClients bool `json:"ignore_clients" bson:"ignore_clients"`
 }
@@ -11,7 +11,7 @@ import (
 
 func TestPlayground_Extras_GetInt(t *testing.T) {
 	p := Playground{
-		Id:                       uuid.NewV4().String(),
+		Id:                       uuid.Must(uuid.NewV4()).String(),
 		Domain:                   "localhost",
 		DefaultDinDInstanceImage: "franel/dind",
 		AllowWindowsInstances:    false,
@@ -44,7 +44,7 @@ func TestPlayground_Extras_GetInt(t *testing.T) {
 
 func TestPlayground_Extras_GetString(t *testing.T) {
 	p := Playground{
-		Id:                       uuid.NewV4().String(),
+		Id:                       uuid.Must(uuid.NewV4()).String(),
 		Domain:                   "localhost",
 		DefaultDinDInstanceImage: "franel/dind",
 		AllowWindowsInstances:    false,
@@ -82,7 +82,7 @@ func TestPlayground_Extras_GetString(t *testing.T) {
 
 func TestPlayground_Extras_GetDuration(t *testing.T) {
 	p := Playground{
-		Id:                       uuid.NewV4().String(),
+		Id:                       uuid.Must(uuid.NewV4()).String(),
 		Domain:                   "localhost",
 		DefaultDinDInstanceImage: "franel/dind",
 		AllowWindowsInstances:    false,
@@ -117,3 +117,30 @@ func TestPlayground_Extras_GetDuration(t *testing.T) {
 	assert.True(t, found)
 	assert.Equal(t, time.Hour*3, v)
 }
+
+func TestPlayground_Extras_GetIntMap(t *testing.T) {
+	p := Playground{
+		Id:     "playground",
+		Domain: "localhost",
+		Extras: PlaygroundExtras{
+			"sizes":  map[string]interface{}{"franela/dind": 3, "alpine": "2", "bad": true},
+			"string": "3",
+		},
+	}
+
+	b, err := json.Marshal(p)
+	assert.Nil(t, err)
+
+	var p2 Playground
+	json.Unmarshal(b, &p2)
+
+	v, found := p2.Extras.GetIntMap("sizes")
+	assert.True(t, found)
+	assert.Equal(t, map[string]int{"franela/dind": 3, "alpine": 2}, v)
+
+	_, found = p2.Extras.GetIntMap("string")
+	assert.False(t, found)
+
+	_, found = p2.Extras.GetIntMap("missing")
+	assert.False(t, found)
+}
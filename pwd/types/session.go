@@ -2,6 +2,8 @@ package types
 
 import (
 	"time"
+
+	"github.com/ringo380/lessoncraft/netpolicy"
 )
 
 type SessionConfig struct {
@@ -11,6 +13,7 @@ type SessionConfig struct {
 	Stack      string
 	StackName  string
 	ImageName  string
+	OrgId      string
 }
 
 type Session struct {
@@ -25,4 +28,52 @@ type Session struct {
 	Host         string    `json:"host" bson:"host"`
 	UserId       string    `json:"user_id" bson:"user_id"`
 	PlaygroundId string    `json:"playground_id" bson:"playground_id"`
+	OrgId        string    `json:"org_id,omitempty" bson:"org_id,omitempty"`
+	// NetworkPolicy is the network policy of the playground the session runs in
+	NetworkPolicy *netpolicy.Policy `json:"network_policy,omitempty" bson:"network_policy,omitempty"`
+	// Extensions counts how many times the expiry of the session was pushed back
+	Extensions int `json:"extensions,omitempty" bson:"extensions,omitempty"`
+	// PausedAt is set while the instances of the session are frozen or stopped
+	PausedAt time.Time `json:"paused_at,omitempty" bson:"paused_at,omitempty"`
+	// Hibernated is set while the instances of a paused session are stopped
+	// rather than frozen
+	Hibernated bool `json:"hibernated,omitempty" bson:"hibernated,omitempty"`
+	// Endpoints are the named ports of the session's instances
+	Endpoints []SessionEndpoint `json:"endpoints,omitempty" bson:"endpoints,omitempty"`
+	// Ports are the ports of the l2 router forwarded to the session's instances
+	Ports []SessionPort `json:"ports,omitempty" bson:"ports,omitempty"`
+}
+
+// SessionEndpoint gives a port of an instance a stable host, e.g.
+// frontend-<session>.direct.<domain>
+type SessionEndpoint struct {
+	Name      string    `json:"name" bson:"name"`
+	Instance  string    `json:"instance" bson:"instance"`
+	Port      int       `json:"port" bson:"port"`
+	CreatedAt time.Time `json:"created_at" bson:"created_at"`
+}
+
+// SessionPort is a TCP or UDP port of the l2 router forwarded to a port of an
+// instance
+type SessionPort struct {
+	Protocol     string    `json:"protocol" bson:"protocol"`
+	Port         int       `json:"port" bson:"port"`
+	Instance     string    `json:"instance" bson:"instance"`
+	InstancePort int       `json:"instance_port" bson:"instance_port"`
+	CreatedAt    time.Time `json:"created_at" bson:"created_at"`
+}
+
+// Endpoint returns the endpoint of the session with the given name
+func (s *Session) Endpoint(name string) (SessionEndpoint, bool) {
+	for _, e := range s.Endpoints {
+		if e.Name == name {
+			return e, true
+		}
+	}
+	return SessionEndpoint{}, false
+}
+
+// Paused checks if the instances of the session are stopped
+func (s *Session) Paused() bool {
+	return !s.PausedAt.IsZero()
 }
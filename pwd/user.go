@@ -9,7 +9,7 @@ import (
 
 var userBannedError = errors.New("user is banned")
 
-func (p *pwd) UserNewLoginRequest(providerName string) (*types.LoginRequest, error) {
+func (p *lessoncraft) UserNewLoginRequest(providerName string) (*types.LoginRequest, error) {
 	req := &types.LoginRequest{Id: p.generator.NewId(), Provider: providerName}
 	if err := p.storage.LoginRequestPut(req); err != nil {
 		return nil, err
@@ -17,7 +17,7 @@ func (p *pwd) UserNewLoginRequest(providerName string) (*types.LoginRequest, err
 	return req, nil
 }
 
-func (p *pwd) UserGetLoginRequest(id string) (*types.LoginRequest, error) {
+func (p *lessoncraft) UserGetLoginRequest(id string) (*types.LoginRequest, error) {
 	if req, err := p.storage.LoginRequestGet(id); err != nil {
 		return nil, err
 	} else {
@@ -25,7 +25,7 @@ func (p *pwd) UserGetLoginRequest(id string) (*types.LoginRequest, error) {
 	}
 }
 
-func (p *pwd) UserLogin(loginRequest *types.LoginRequest, user *types.User) (*types.User, error) {
+func (p *lessoncraft) UserLogin(loginRequest *types.LoginRequest, user *types.User) (*types.User, error) {
 	if err := p.storage.LoginRequestDelete(loginRequest.Id); err != nil {
 		return nil, err
 	}
@@ -43,7 +43,7 @@ func (p *pwd) UserLogin(loginRequest *types.LoginRequest, user *types.User) (*ty
 	}
 	return u, nil
 }
-func (p *pwd) UserGet(id string) (*types.User, error) {
+func (p *lessoncraft) UserGet(id string) (*types.User, error) {
 	var user *types.User
 	var err error
 	if user, err = p.storage.UserGet(id); err != nil {
@@ -0,0 +1,40 @@
+package pwd
+
+import (
+	"log"
+	"time"
+
+	"github.com/ringo380/lessoncraft/pwd/types"
+	"github.com/ringo380/lessoncraft/router"
+)
+
+// SessionZone returns the DNS zone of a session, for the l2 router to serve.
+// Each instance is found by its hostname and role, and its declared ports are
+// served as services of its role.
+func (p *lessoncraft) SessionZone(session *types.Session) (router.Zone, error) {
+	defer observeAction("SessionZone", time.Now())
+
+	instances, err := p.storage.InstanceFindBySessionId(session.Id)
+	if err != nil {
+		return router.Zone{}, err
+	}
+	zone := router.Zone{SessionId: session.Id, Records: []router.ZoneRecord{}}
+	for _, i := range instances {
+		record := router.ZoneRecord{
+			Hostname: i.Hostname,
+			Role:     i.Role,
+			IP:       i.IP,
+			Metadata: map[string]string{"instance": i.Name, "image": i.Image},
+		}
+		for _, port := range i.Ports {
+			sp, err := router.ParseServicePort(port)
+			if err != nil {
+				log.Printf("Ignoring port of instance [%s]: %v\n", i.Name, err)
+				continue
+			}
+			record.Ports = append(record.Ports, sp)
+		}
+		zone.Records = append(zone.Records, record)
+	}
+	return zone, nil
+}
@@ -0,0 +1,331 @@
+package router
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// AccessCookie is the cookie the router keeps the access token of a host in
+	AccessCookie = "lessoncraft_access"
+	// AccessParam is the query parameter the main app passes access tokens in.
+	// The router moves the token to a cookie and redirects to the same URL
+	// without it.
+	AccessParam = "lessoncraft_token"
+	// AccessSchemeParam set to https makes the router redirect to the TLS
+	// endpoint of the port once the token is accepted. TLS connections are
+	// passed through untouched, so they are authorized by the address of the
+	// client that followed the redirect.
+	AccessSchemeParam = "lessoncraft_scheme"
+)
+
+var (
+	// ErrInvalidToken is returned for tokens that were not signed by the router key
+	ErrInvalidToken = errors.New("invalid access token")
+	// ErrExpiredToken is returned for tokens past their expiry
+	ErrExpiredToken = errors.New("access token has expired")
+	// ErrTokenMismatch is returned for tokens of another session or port
+	ErrTokenMismatch = errors.New("access token is not valid for this host")
+)
+
+// PortPolicy tells whether a port of a session can be reached without a token
+type PortPolicy func(sessionId string, port int) bool
+
+// PublicPorts returns a policy making the given ports public in every session
+func PublicPorts(ports ...int) PortPolicy {
+	public := map[int]bool{}
+	for _, p := range ports {
+		public[p] = true
+	}
+	return func(sessionId string, port int) bool {
+		return public[port]
+	}
+}
+
+// AccessControl decides which clients may reach the ports of a session. The
+// main app hands out tokens signed with the key it shares with the router.
+type AccessControl struct {
+	key     []byte
+	public  PortPolicy
+	trusted []*net.IPNet
+	now     func() time.Time
+
+	mu     sync.Mutex
+	grants map[string]time.Time
+}
+
+// NewAccessControl creates an access control verifying tokens signed with key.
+// All ports are private until SetPublicPorts is used.
+func NewAccessControl(key []byte) *AccessControl {
+	return &AccessControl{key: key, now: time.Now, grants: map[string]time.Time{}}
+}
+
+// SetPublicPorts sets which ports can be reached without a token
+func (a *AccessControl) SetPublicPorts(policy PortPolicy) {
+	a.public = policy
+}
+
+// Trust lets clients of the given networks reach every port without a token,
+// e.g. LessonCraft itself connecting to the docker daemons of instances
+func (a *AccessControl) Trust(networks ...*net.IPNet) {
+	a.trusted = append(a.trusted, networks...)
+}
+
+// NewToken returns a token granting access to a port of a session until it
+// expires. Port 0 grants access to every port of the session.
+func (a *AccessControl) NewToken(sessionId string, port int, expires time.Time) string {
+	payload := fmt.Sprintf("%s:%d:%d", sessionId, port, expires.Unix())
+	return base64.RawURLEncoding.EncodeToString([]byte(payload)) + "." + base64.RawURLEncoding.EncodeToString(a.sign(payload))
+}
+
+// Verify checks that a token grants access to a port of a session and returns
+// when it expires
+func (a *AccessControl) Verify(token, sessionId string, port int) (time.Time, error) {
+	chunks := strings.Split(token, ".")
+	if len(chunks) != 2 {
+		return time.Time{}, ErrInvalidToken
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(chunks[0])
+	if err != nil {
+		return time.Time{}, ErrInvalidToken
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(chunks[1])
+	if err != nil || !hmac.Equal(sig, a.sign(string(payload))) {
+		return time.Time{}, ErrInvalidToken
+	}
+
+	fields := strings.Split(string(payload), ":")
+	if len(fields) != 3 {
+		return time.Time{}, ErrInvalidToken
+	}
+	tokenPort, err := strconv.Atoi(fields[1])
+	if err != nil {
+		return time.Time{}, ErrInvalidToken
+	}
+	unix, err := strconv.ParseInt(fields[2], 10, 64)
+	if err != nil {
+		return time.Time{}, ErrInvalidToken
+	}
+	expires := time.Unix(unix, 0)
+	if !a.now().Before(expires) {
+		return time.Time{}, ErrExpiredToken
+	}
+	if fields[0] != sessionId || (tokenPort != 0 && tokenPort != port) {
+		return time.Time{}, ErrTokenMismatch
+	}
+	return expires, nil
+}
+
+func (a *AccessControl) sign(payload string) []byte {
+	mac := hmac.New(sha256.New, a.key)
+	mac.Write([]byte(payload))
+	return mac.Sum(nil)
+}
+
+// Public checks if a port of a session can be reached by anyone
+func (a *AccessControl) Public(sessionId string, port int) bool {
+	return a.public != nil && a.public(sessionId, port)
+}
+
+// Trusted checks if a client can reach every port
+func (a *AccessControl) Trusted(client net.IP) bool {
+	for _, n := range a.trusted {
+		if client != nil && n.Contains(client) {
+			return true
+		}
+	}
+	return false
+}
+
+// Grant lets a client reach a port of a session until the given time without
+// presenting a token, which is how TLS connections are authorized
+func (a *AccessControl) Grant(client net.IP, sessionId string, port int, until time.Time) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	now := a.now()
+	for k, expires := range a.grants {
+		if !now.Before(expires) {
+			delete(a.grants, k)
+		}
+	}
+	a.grants[grantKey(client, sessionId, port)] = until
+}
+
+// Granted checks if a client was granted access to a port of a session
+func (a *AccessControl) Granted(client net.IP, sessionId string, port int) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	for _, key := range []string{grantKey(client, sessionId, port), grantKey(client, sessionId, 0)} {
+		if expires, found := a.grants[key]; found && a.now().Before(expires) {
+			return true
+		}
+	}
+	return false
+}
+
+func grantKey(client net.IP, sessionId string, port int) string {
+	return fmt.Sprintf("%s|%s|%d", client, sessionId, port)
+}
+
+// ParseNetworks parses a comma separated list of CIDRs and IPs
+func ParseNetworks(list string) ([]*net.IPNet, error) {
+	networks := []*net.IPNet{}
+	for _, s := range strings.Split(list, ",") {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		if !strings.Contains(s, "/") {
+			ip := net.ParseIP(s)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid address %q", s)
+			}
+			bits := 32
+			if ip.To4() == nil {
+				bits = 128
+			}
+			s = fmt.Sprintf("%s/%d", s, bits)
+		}
+		_, n, err := net.ParseCIDR(s)
+		if err != nil {
+			return nil, err
+		}
+		networks = append(networks, n)
+	}
+	return networks, nil
+}
+
+const forbiddenPage = `<!DOCTYPE html>
+<html>
+<head><title>403 Forbidden</title></head>
+<body style="font-family: sans-serif; text-align: center; margin-top: 10%%">
+<h1>Access denied</h1>
+<p>%s</p>
+<p>Open this port again from your LessonCraft session to get a new link.</p>
+</body>
+</html>
+`
+
+func clientIP(c net.Conn) net.IP {
+	if addr, ok := c.RemoteAddr().(*net.TCPAddr); ok {
+		return addr.IP
+	}
+	return nil
+}
+
+// authorizeTLS checks if a TLS connection may be proxied. The session is taken
+// from the SNI, and the client must have been granted access by following a
+// token-bearing link first.
+func (r *proxyRouter) authorizeTLS(c net.Conn, host string, info *DirectorInfo) bool {
+	if r.access == nil {
+		return true
+	}
+	client := clientIP(c)
+	if r.access.Trusted(client) {
+		return true
+	}
+	sessionId, err := HostSession(host)
+	if err != nil {
+		return false
+	}
+	port := info.Dst.Port
+	return r.access.Public(sessionId, port) || r.access.Granted(client, sessionId, port)
+}
+
+// authorizeHTTP checks if an HTTP request may be proxied. Requests carrying a
+// token in the query are answered with a redirect setting the access cookie,
+// refused requests with a 403 page. It returns false when the request was
+// answered.
+func (r *proxyRouter) authorizeHTTP(w http.ResponseWriter, req *http.Request, host string, info *DirectorInfo) bool {
+	if r.access == nil {
+		return true
+	}
+	var client net.IP
+	if h, _, err := net.SplitHostPort(req.RemoteAddr); err == nil {
+		client = net.ParseIP(h)
+	}
+	if r.access.Trusted(client) {
+		return true
+	}
+	sessionId, err := HostSession(host)
+	if err != nil {
+		writeForbidden(w, "This address does not belong to a session.")
+		return false
+	}
+	port := info.Dst.Port
+	if r.access.Public(sessionId, port) {
+		return true
+	}
+
+	query := req.URL.Query()
+	if token := query.Get(AccessParam); token != "" {
+		expires, err := r.access.Verify(token, sessionId, port)
+		if err != nil {
+			log.Printf("Refused token from %s for %s: %v\n", client, host, err)
+			writeForbidden(w, tokenRefusal(err))
+			return false
+		}
+		r.access.Grant(client, sessionId, port, expires)
+
+		location := *req.URL
+		location.Scheme = ""
+		location.Host = ""
+		if query.Get(AccessSchemeParam) == "https" {
+			location.Scheme = "https"
+			location.Host = host
+		}
+		query.Del(AccessParam)
+		query.Del(AccessSchemeParam)
+		location.RawQuery = query.Encode()
+
+		http.SetCookie(w, &http.Cookie{Name: AccessCookie, Value: token, Path: "/", Expires: expires, HttpOnly: true, SameSite: http.SameSiteLaxMode})
+		w.Header().Set("Location", location.String())
+		w.WriteHeader(http.StatusFound)
+		return false
+	}
+
+	cookie, err := req.Cookie(AccessCookie)
+	if err != nil {
+		writeForbidden(w, "This port of the session is private.")
+		return false
+	}
+	if _, err := r.access.Verify(cookie.Value, sessionId, port); err != nil {
+		writeForbidden(w, tokenRefusal(err))
+		return false
+	}
+
+	// The token is not passed on to the instance
+	cookies := req.Cookies()
+	req.Header.Del("Cookie")
+	for _, cookie := range cookies {
+		if cookie.Name != AccessCookie {
+			req.AddCookie(cookie)
+		}
+	}
+	return true
+}
+
+func tokenRefusal(err error) string {
+	if err == ErrExpiredToken {
+		return "Your link to this port has expired."
+	}
+	return "Your link is not valid for this port."
+}
+
+func writeForbidden(w http.ResponseWriter, reason string) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusForbidden)
+	fmt.Fprintf(w, forbiddenPage, reason)
+}
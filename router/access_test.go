@@ -0,0 +1,179 @@
+package router
+
+import (
+	"crypto/tls"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAccessControl_Token(t *testing.T) {
+	a := NewAccessControl([]byte("secret"))
+	now := time.Now()
+	a.now = func() time.Time { return now }
+
+	token := a.NewToken("aaaabbbb", 8080, now.Add(time.Hour))
+	expires, err := a.Verify(token, "aaaabbbb", 8080)
+	assert.Nil(t, err)
+	assert.Equal(t, now.Add(time.Hour).Unix(), expires.Unix())
+
+	_, err = a.Verify(token, "aaaabbbb", 8081)
+	assert.Equal(t, ErrTokenMismatch, err)
+	_, err = a.Verify(token, "ccccdddd", 8080)
+	assert.Equal(t, ErrTokenMismatch, err)
+	_, err = NewAccessControl([]byte("other")).Verify(token, "aaaabbbb", 8080)
+	assert.Equal(t, ErrInvalidToken, err)
+	_, err = a.Verify("garbage", "aaaabbbb", 8080)
+	assert.Equal(t, ErrInvalidToken, err)
+
+	// Port 0 grants every port of the session
+	_, err = a.Verify(a.NewToken("aaaabbbb", 0, now.Add(time.Hour)), "aaaabbbb", 9000)
+	assert.Nil(t, err)
+
+	now = now.Add(2 * time.Hour)
+	_, err = a.Verify(token, "aaaabbbb", 8080)
+	assert.Equal(t, ErrExpiredToken, err)
+}
+
+func TestAccessControl_Policy(t *testing.T) {
+	a := NewAccessControl([]byte("secret"))
+	assert.False(t, a.Public("aaaabbbb", 80))
+	a.SetPublicPorts(PublicPorts(80, 443))
+	assert.True(t, a.Public("aaaabbbb", 80))
+	assert.False(t, a.Public("aaaabbbb", 8080))
+
+	networks, err := ParseNetworks("10.1.0.0/16, 192.168.0.5")
+	assert.Nil(t, err)
+	a.Trust(networks...)
+	assert.True(t, a.Trusted(net.ParseIP("10.1.2.3")))
+	assert.True(t, a.Trusted(net.ParseIP("192.168.0.5")))
+	assert.False(t, a.Trusted(net.ParseIP("192.168.0.6")))
+	_, err = ParseNetworks("not-an-ip")
+	assert.NotNil(t, err)
+
+	client := net.ParseIP("1.2.3.4")
+	assert.False(t, a.Granted(client, "aaaabbbb", 8443))
+	a.Grant(client, "aaaabbbb", 8443, time.Now().Add(time.Hour))
+	assert.True(t, a.Granted(client, "aaaabbbb", 8443))
+	assert.False(t, a.Granted(net.ParseIP("5.6.7.8"), "aaaabbbb", 8443))
+	assert.False(t, a.Granted(client, "aaaabbbb", 8444))
+}
+
+func newAccessTestRouter(t *testing.T, backend string) (*proxyRouter, *AccessControl, int, func()) {
+	dir, private, _, _ := generateKeys()
+	u, _ := url.Parse(backend)
+	dst, _ := net.ResolveTCPAddr("tcp", u.Host)
+
+	r := NewRouter(func(protocol Protocol, host string) (*DirectorInfo, error) {
+		return &DirectorInfo{Dst: dst}, nil
+	}, private)
+	access := NewAccessControl([]byte("secret"))
+	r.SetAccessControl(access)
+	r.Listen(":0", ":0", ":0")
+	return r, access, dst.Port, func() {
+		r.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func TestProxy_HttpAccess(t *testing.T) {
+	var receivedCookies string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		receivedCookies = r.Header.Get("Cookie")
+		fmt.Fprint(w, "It works!")
+	}))
+	defer ts.Close()
+
+	r, access, port, done := newAccessTestRouter(t, ts.URL)
+	defer done()
+
+	client := &http.Client{CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	get := func(path string, cookies ...*http.Cookie) *http.Response {
+		req, err := http.NewRequest("GET", getRouterUrl("http", r)+path, nil)
+		assert.Nil(t, err)
+		req.Host = "ip10-0-0-1-aaaabbbb-8080.direct.localhost"
+		for _, c := range cookies {
+			req.AddCookie(c)
+		}
+		resp, err := client.Do(req)
+		assert.Nil(t, err)
+		return resp
+	}
+
+	// Private ports need a token
+	resp := get("/")
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+	body, _ := ioutil.ReadAll(resp.Body)
+	assert.Contains(t, string(body), "Access denied")
+
+	resp = get("/?" + AccessParam + "=" + access.NewToken("ccccdddd", port, time.Now().Add(time.Hour)))
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+	// Tokens are moved to a cookie
+	token := access.NewToken("aaaabbbb", port, time.Now().Add(time.Hour))
+	resp = get("/app?page=2&" + AccessParam + "=" + token)
+	assert.Equal(t, http.StatusFound, resp.StatusCode)
+	assert.Equal(t, "/app?page=2", resp.Header.Get("Location"))
+	cookies := resp.Cookies()
+	assert.Len(t, cookies, 1)
+	assert.Equal(t, AccessCookie, cookies[0].Name)
+
+	resp = get("/app", cookies[0], &http.Cookie{Name: "theme", Value: "dark"})
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	body, _ = ioutil.ReadAll(resp.Body)
+	assert.Equal(t, "It works!", string(body))
+	// The access cookie is not passed on to the instance
+	assert.Equal(t, "theme=dark", receivedCookies)
+
+	// Public ports are reachable by anyone
+	access.SetPublicPorts(PublicPorts(port))
+	resp = get("/")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+}
+
+func TestProxy_TLSAccess(t *testing.T) {
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "It works!")
+	}))
+	defer ts.Close()
+
+	r, access, port, done := newAccessTestRouter(t, ts.URL)
+	defer done()
+
+	const host = "ip10-0-0-1-aaaabbbb-8443.direct.localhost"
+	httpsClient := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true, ServerName: host}}}
+	httpsURL := strings.Replace(getRouterUrl("https", r), "localhost", "127.0.0.1", 1)
+
+	// Without a grant the connection is closed
+	_, err := httpsClient.Get(httpsURL)
+	assert.NotNil(t, err)
+
+	// Following a token-bearing link grants the client access over TLS
+	client := &http.Client{CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	token := access.NewToken("aaaabbbb", port, time.Now().Add(time.Hour))
+	req, err := http.NewRequest("GET", strings.Replace(getRouterUrl("http", r), "localhost", "127.0.0.1", 1)+"/?"+AccessSchemeParam+"=https&"+AccessParam+"="+token, nil)
+	assert.Nil(t, err)
+	req.Host = host
+	resp, err := client.Do(req)
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusFound, resp.StatusCode)
+	assert.Equal(t, "https://"+host+"/", resp.Header.Get("Location"))
+
+	resp, err = httpsClient.Get(httpsURL)
+	assert.Nil(t, err)
+	body, _ := ioutil.ReadAll(resp.Body)
+	assert.Equal(t, "It works!", string(body))
+}
@@ -0,0 +1,105 @@
+package router
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// aliasCacheTTL is how long the router remembers where an endpoint leads
+const aliasCacheTTL = 10 * time.Second
+
+// ErrAliasNotFound is returned for endpoints that no session declares
+var ErrAliasNotFound = errors.New("alias not found")
+
+// AliasRequest asks the platform where a named endpoint of a session leads
+type AliasRequest struct {
+	SessionId string `json:"session_id"`
+	Name      string `json:"name"`
+}
+
+// AliasResponse is the instance address and port of an endpoint
+type AliasResponse struct {
+	IP   string `json:"ip"`
+	Port int    `json:"port"`
+}
+
+// AliasResolver returns where a named endpoint of a session leads
+type AliasResolver func(sessionId, name string) (*AliasResponse, error)
+
+type aliasEntry struct {
+	resp    *AliasResponse
+	err     error
+	expires time.Time
+}
+
+// RemoteAliasResolver resolves endpoints against the platform API at url,
+// signing the requests with key. Answers, including unknown endpoints, are
+// cached briefly as every request and DNS query looks them up.
+func RemoteAliasResolver(url string, key []byte) AliasResolver {
+	client := &http.Client{Timeout: 5 * time.Second}
+	var mu sync.Mutex
+	cache := map[AliasRequest]aliasEntry{}
+
+	lookup := func(alias AliasRequest) (*AliasResponse, error) {
+		body, err := json.Marshal(alias)
+		if err != nil {
+			return nil, err
+		}
+		req, err := http.NewRequest("POST", url, bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set(SignatureHeader, SignRequest(key, body))
+
+		resp, err := client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode == http.StatusNotFound {
+			return nil, ErrAliasNotFound
+		}
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("resolving alias %s of %s: status %d", alias.Name, alias.SessionId, resp.StatusCode)
+		}
+
+		var r AliasResponse
+		if err := json.NewDecoder(resp.Body).Decode(&r); err != nil {
+			return nil, err
+		}
+		return &r, nil
+	}
+
+	return func(sessionId, name string) (*AliasResponse, error) {
+		alias := AliasRequest{SessionId: sessionId, Name: name}
+		mu.Lock()
+		entry, found := cache[alias]
+		mu.Unlock()
+		if found && time.Now().Before(entry.expires) {
+			return entry.resp, entry.err
+		}
+
+		resp, err := lookup(alias)
+		if err != nil && err != ErrAliasNotFound {
+			// Failures of the platform are not cached
+			return nil, err
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		now := time.Now()
+		for k, e := range cache {
+			if now.After(e.expires) {
+				delete(cache, k)
+			}
+		}
+		cache[alias] = aliasEntry{resp: resp, err: err, expires: now.Add(aliasCacheTTL)}
+		return resp, err
+	}
+}
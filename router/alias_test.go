@@ -0,0 +1,50 @@
+package router
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRemoteAliasResolver(t *testing.T) {
+	key := []byte("secret")
+	var calls int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		body, _ := ioutil.ReadAll(r.Body)
+		if r.Header.Get(SignatureHeader) != SignRequest(key, body) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		var req AliasRequest
+		json.Unmarshal(body, &req)
+		if req.SessionId != "aaaabbbb" || req.Name != "frontend" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		json.NewEncoder(w).Encode(AliasResponse{IP: "10.0.0.1", Port: 3000})
+	}))
+	defer ts.Close()
+
+	resolve := RemoteAliasResolver(ts.URL, key)
+	resp, err := resolve("aaaabbbb", "frontend")
+	assert.Nil(t, err)
+	assert.Equal(t, &AliasResponse{IP: "10.0.0.1", Port: 3000}, resp)
+
+	_, err = resolve("aaaabbbb", "backend")
+	assert.Equal(t, ErrAliasNotFound, err)
+
+	// Answers are cached, unknown endpoints included
+	resolve("aaaabbbb", "frontend")
+	resolve("aaaabbbb", "backend")
+	assert.Equal(t, int32(2), atomic.LoadInt32(&calls))
+
+	_, err = RemoteAliasResolver(ts.URL, []byte("wrong"))("aaaabbbb", "frontend")
+	assert.NotNil(t, err)
+	assert.NotEqual(t, ErrAliasNotFound, err)
+}
@@ -0,0 +1,292 @@
+package router
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	ForwardTCP = "tcp"
+	ForwardUDP = "udp"
+)
+
+// udpFlowTimeout is how long a UDP client is remembered without traffic
+const udpFlowTimeout = 2 * time.Minute
+
+// PortForward binds an external port of the router to a port of an instance.
+// Forwarded ports are open to anyone, as the session asked for them.
+type PortForward struct {
+	Protocol  string `json:"protocol"`
+	Port      int    `json:"port"`
+	SessionId string `json:"session_id"`
+	// Dst is the address of the instance port, e.g. 10.0.0.1:5432
+	Dst string `json:"dst"`
+}
+
+func (f PortForward) key() string {
+	return fmt.Sprintf("%s/%d", f.Protocol, f.Port)
+}
+
+// PortForwardsRequest asks the platform for the port forwards of all
+// sessions. It is timed so it cannot be replayed later.
+type PortForwardsRequest struct {
+	Time time.Time `json:"time"`
+}
+
+// signedRequestAge is how old timed requests between LessonCraft and the
+// router may be
+const signedRequestAge = time.Minute
+
+// Expired checks if a request is too old to be answered
+func (r PortForwardsRequest) Expired() bool {
+	return requestExpired(r.Time)
+}
+
+func requestExpired(t time.Time) bool {
+	age := time.Since(t)
+	return age > signedRequestAge || age < -signedRequestAge
+}
+
+// PortForwardSource returns the port forwards the router should serve
+type PortForwardSource func() ([]PortForward, error)
+
+// RemotePortForwards fetches the port forwards from the platform API at url,
+// signing the requests with key
+func RemotePortForwards(url string, key []byte) PortForwardSource {
+	client := &http.Client{Timeout: 10 * time.Second}
+	return func() ([]PortForward, error) {
+		body, err := json.Marshal(PortForwardsRequest{Time: time.Now()})
+		if err != nil {
+			return nil, err
+		}
+		req, err := http.NewRequest("POST", url, bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set(SignatureHeader, SignRequest(key, body))
+
+		resp, err := client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("fetching port forwards: status %d", resp.StatusCode)
+		}
+
+		forwards := []PortForward{}
+		if err := json.NewDecoder(resp.Body).Decode(&forwards); err != nil {
+			return nil, err
+		}
+		return forwards, nil
+	}
+}
+
+// ParsePortRange parses a range of ports such as 30000-30099
+func ParsePortRange(s string) (int, int, error) {
+	parts := strings.SplitN(s, "-", 2)
+	if len(parts) != 2 {
+		return 0, 0, fmt.Errorf("Invalid port range %q", s)
+	}
+	first, err1 := strconv.Atoi(strings.TrimSpace(parts[0]))
+	last, err2 := strconv.Atoi(strings.TrimSpace(parts[1]))
+	if err1 != nil || err2 != nil || first < 1 || last > 65535 || first > last {
+		return 0, 0, fmt.Errorf("Invalid port range %q", s)
+	}
+	return first, last, nil
+}
+
+type portForwarder struct {
+	PortForward
+	closer interface{ Close() error }
+}
+
+// SetPortForwards makes the router serve exactly the given port forwards.
+// Forwards that are already served are left untouched, so their connections
+// go on.
+func (r *proxyRouter) SetPortForwards(forwards []PortForward) {
+	r.forwardsMu.Lock()
+	defer r.forwardsMu.Unlock()
+
+	wanted := map[string]PortForward{}
+	for _, f := range forwards {
+		wanted[f.key()] = f
+	}
+	for key, fw := range r.forwards {
+		if f, found := wanted[key]; !found || f != fw.PortForward {
+			fw.closer.Close()
+			delete(r.forwards, key)
+		}
+	}
+	for key, f := range wanted {
+		if _, found := r.forwards[key]; found {
+			continue
+		}
+		fw, err := r.startForward(f)
+		if err != nil {
+			log.Printf("Couldn't forward %s port %d to %s: %v\n", f.Protocol, f.Port, f.Dst, err)
+			continue
+		}
+		r.forwards[key] = fw
+	}
+}
+
+// PortForwards returns the port forwards the router serves
+func (r *proxyRouter) PortForwards() []PortForward {
+	r.forwardsMu.Lock()
+	defer r.forwardsMu.Unlock()
+
+	forwards := []PortForward{}
+	for _, fw := range r.forwards {
+		forwards = append(forwards, fw.PortForward)
+	}
+	return forwards
+}
+
+// StopSessionForwards stops the port forwards of a session once it is gone
+func (r *proxyRouter) StopSessionForwards(sessionId string) {
+	r.forwardsMu.Lock()
+	defer r.forwardsMu.Unlock()
+
+	for key, fw := range r.forwards {
+		if fw.SessionId == sessionId {
+			fw.closer.Close()
+			delete(r.forwards, key)
+		}
+	}
+}
+
+func (r *proxyRouter) stopForwards() {
+	r.forwardsMu.Lock()
+	defer r.forwardsMu.Unlock()
+
+	for key, fw := range r.forwards {
+		fw.closer.Close()
+		delete(r.forwards, key)
+	}
+}
+
+func (r *proxyRouter) startForward(f PortForward) (*portForwarder, error) {
+	switch f.Protocol {
+	case ForwardTCP:
+		l, err := net.Listen("tcp", fmt.Sprintf(":%d", f.Port))
+		if err != nil {
+			return nil, err
+		}
+		go r.forwardTCP(l, f)
+		return &portForwarder{PortForward: f, closer: l}, nil
+	case ForwardUDP:
+		c, err := net.ListenPacket("udp", fmt.Sprintf(":%d", f.Port))
+		if err != nil {
+			return nil, err
+		}
+		go r.forwardUDP(c, f)
+		return &portForwarder{PortForward: f, closer: c}, nil
+	}
+	return nil, fmt.Errorf("Unknown protocol %s", f.Protocol)
+}
+
+func (r *proxyRouter) forwardTCP(l net.Listener, f PortForward) {
+	for {
+		c, err := l.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			return
+		} else if err != nil {
+			continue
+		}
+		go func() {
+			defer c.Close()
+			release, err := r.conns.acquire(ProtocolTCP, f.SessionId)
+			if err != nil {
+				log.Printf("Refused connection from %s to port %d: %v\n", c.RemoteAddr(), f.Port, err)
+				return
+			}
+			defer release()
+			d, err := r.dialBackend(context.Background(), ProtocolTCP, f.SessionId, f.Dst)
+			if err != nil {
+				log.Printf("Error dialing backend %s: %v\n", f.Dst, err)
+				return
+			}
+			defer d.Close()
+			r.proxyConn(c, d)
+		}()
+	}
+}
+
+// forwardUDP relays the datagrams of each client through a connection of its
+// own to the instance, so answers go back to the right client
+func (r *proxyRouter) forwardUDP(c net.PacketConn, f PortForward) {
+	defer c.Close()
+
+	var mu sync.Mutex
+	flows := map[string]net.Conn{}
+	defer func() {
+		mu.Lock()
+		defer mu.Unlock()
+		for _, flow := range flows {
+			flow.Close()
+		}
+	}()
+
+	in := bytesCounterVec.WithLabelValues(ProtocolUDP.String(), f.SessionId, "in")
+	out := bytesCounterVec.WithLabelValues(ProtocolUDP.String(), f.SessionId, "out")
+	buf := make([]byte, 64*1024)
+	for {
+		n, client, err := c.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+
+		mu.Lock()
+		flow, found := flows[client.String()]
+		mu.Unlock()
+		if !found {
+			release, err := r.conns.acquire(ProtocolUDP, f.SessionId)
+			if err != nil {
+				continue
+			}
+			flow, err = net.Dial("udp", f.Dst)
+			if err != nil {
+				dialErrorsCounterVec.WithLabelValues(ProtocolUDP.String()).Inc()
+				release()
+				continue
+			}
+			flow.SetReadDeadline(time.Now().Add(udpFlowTimeout))
+			mu.Lock()
+			flows[client.String()] = flow
+			mu.Unlock()
+
+			go func(flow net.Conn, client net.Addr) {
+				defer release()
+				reply := make([]byte, 64*1024)
+				for {
+					n, err := flow.Read(reply)
+					if err != nil {
+						break
+					}
+					out.Add(float64(n))
+					c.WriteTo(reply[:n], client)
+				}
+				mu.Lock()
+				delete(flows, client.String())
+				mu.Unlock()
+				flow.Close()
+			}(flow, client)
+		}
+		if n, err := flow.Write(buf[:n]); err == nil {
+			in.Add(float64(n))
+			flow.SetReadDeadline(time.Now().Add(udpFlowTimeout))
+		}
+	}
+}
@@ -0,0 +1,152 @@
+package router
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func freePort(t *testing.T, network string) int {
+	if network == "udp" {
+		c, err := net.ListenPacket("udp", "127.0.0.1:0")
+		assert.Nil(t, err)
+		defer c.Close()
+		return c.LocalAddr().(*net.UDPAddr).Port
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	defer l.Close()
+	return l.Addr().(*net.TCPAddr).Port
+}
+
+func TestPortForward_TCP(t *testing.T) {
+	backend, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	defer backend.Close()
+	go func() {
+		for {
+			c, err := backend.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer c.Close()
+				io.Copy(c, c)
+			}()
+		}
+	}()
+
+	r := &proxyRouter{dialer: &net.Dialer{}, conns: newConnTracker(), forwards: map[string]*portForwarder{}}
+	defer r.stopForwards()
+	port := freePort(t, "tcp")
+	f := PortForward{Protocol: ForwardTCP, Port: port, SessionId: "aaaabbbb", Dst: backend.Addr().String()}
+	r.SetPortForwards([]PortForward{f})
+	assert.Equal(t, []PortForward{f}, r.PortForwards())
+
+	c, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", port))
+	assert.Nil(t, err)
+	defer c.Close()
+	fmt.Fprint(c, "ping")
+	buf := make([]byte, 4)
+	_, err = io.ReadFull(c, buf)
+	assert.Nil(t, err)
+	assert.Equal(t, "ping", string(buf))
+
+	// Unchanged forwards keep their connections
+	r.SetPortForwards([]PortForward{f})
+	fmt.Fprint(c, "pong")
+	_, err = io.ReadFull(c, buf)
+	assert.Nil(t, err)
+	assert.Equal(t, "pong", string(buf))
+
+	r.StopSessionForwards("aaaabbbb")
+	assert.Empty(t, r.PortForwards())
+	_, err = net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", port))
+	assert.NotNil(t, err)
+}
+
+func TestPortForward_UDP(t *testing.T) {
+	backend, err := net.ListenPacket("udp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	defer backend.Close()
+	go func() {
+		buf := make([]byte, 1024)
+		for {
+			n, addr, err := backend.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			backend.WriteTo(append([]byte("echo "), buf[:n]...), addr)
+		}
+	}()
+
+	r := &proxyRouter{dialer: &net.Dialer{}, conns: newConnTracker(), forwards: map[string]*portForwarder{}}
+	defer r.stopForwards()
+	port := freePort(t, "udp")
+	r.SetPortForwards([]PortForward{{Protocol: ForwardUDP, Port: port, SessionId: "aaaabbbb", Dst: backend.LocalAddr().String()}})
+
+	// Each client gets its own answers
+	for _, msg := range []string{"one", "two"} {
+		c, err := net.Dial("udp", fmt.Sprintf("127.0.0.1:%d", port))
+		assert.Nil(t, err)
+		defer c.Close()
+		c.SetDeadline(time.Now().Add(2 * time.Second))
+		_, err = c.Write([]byte(msg))
+		assert.Nil(t, err)
+		buf := make([]byte, 1024)
+		n, err := c.Read(buf)
+		assert.Nil(t, err)
+		assert.Equal(t, "echo "+msg, string(buf[:n]))
+	}
+	assert.Equal(t, 2, r.ActiveConnections())
+
+	r.SetPortForwards(nil)
+	assert.Eventually(t, func() bool {
+		return r.ActiveConnections() == 0
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestRemotePortForwards(t *testing.T) {
+	key := []byte("secret")
+	forwards := []PortForward{{Protocol: ForwardTCP, Port: 30000, SessionId: "aaaabbbb", Dst: "10.0.0.1:5432"}}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		var req PortForwardsRequest
+		json.Unmarshal(body, &req)
+		if r.Header.Get(SignatureHeader) != SignRequest(key, body) || req.Expired() {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		json.NewEncoder(w).Encode(forwards)
+	}))
+	defer ts.Close()
+
+	received, err := RemotePortForwards(ts.URL, key)()
+	assert.Nil(t, err)
+	assert.Equal(t, forwards, received)
+
+	_, err = RemotePortForwards(ts.URL, []byte("wrong"))()
+	assert.NotNil(t, err)
+
+	assert.True(t, PortForwardsRequest{Time: time.Now().Add(-time.Hour)}.Expired())
+}
+
+func TestParsePortRange(t *testing.T) {
+	first, last, err := ParsePortRange("30000-30099")
+	assert.Nil(t, err)
+	assert.Equal(t, 30000, first)
+	assert.Equal(t, 30099, last)
+
+	for _, s := range []string{"", "30000", "30099-30000", "0-10", "1-70000", "a-b"} {
+		_, _, err := ParsePortRange(s)
+		assert.NotNil(t, err, s)
+	}
+}
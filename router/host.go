@@ -9,10 +9,17 @@ import (
 
 const hostPattern = "^.*ip([0-9]{1,3}-[0-9]{1,3}-[0-9]{1,3}-[0-9]{1,3})-([0-9|a-z]+)(?:-?([0-9]{1,5}))?(?:\\.([a-z|A-Z|0-9|_|\\-\\.]+))?(?:\\:([0-9]{1,5}))?$"
 
+// aliasPattern matches the hosts of named endpoints, e.g.
+// frontend-aaaabbbb.direct.example.com
+const aliasPattern = "^([a-z0-9](?:[a-z0-9-]{0,30}[a-z0-9])?)-([0-9a-z]{8,20})(?:\\.([a-zA-Z0-9_\\-\\.]+))?(?:\\:([0-9]{1,5}))?$"
+
 var hostRegex *regexp.Regexp
+var aliasRegex *regexp.Regexp
+var aliasNameRegex = regexp.MustCompile("^[a-z0-9](?:[a-z0-9-]{0,30}[a-z0-9])?$")
 
 func init() {
 	hostRegex = regexp.MustCompile(hostPattern)
+	aliasRegex = regexp.MustCompile(aliasPattern)
 }
 
 type HostOpts struct {
@@ -69,3 +76,64 @@ func DecodeHost(host string) (HostInfo, error) {
 
 	return info, nil
 }
+
+type AliasInfo struct {
+	Name      string
+	SessionId string
+	TLD       string
+	Port      int
+}
+
+// ValidAliasName checks if a name can be used for an endpoint of a session.
+// Names are lowercase letters, digits and inner hyphens, and must not be
+// mistaken for the host of an instance IP.
+func ValidAliasName(name string) bool {
+	if !aliasNameRegex.MatchString(name) {
+		return false
+	}
+	return !hostRegex.MatchString(EncodeAlias(name, "aaaabbbb", HostOpts{}))
+}
+
+// EncodeAlias returns the host of the endpoint name of a session. The
+// EncodedPort option is ignored, as the endpoint names the port itself.
+func EncodeAlias(name, sessionId string, opts HostOpts) string {
+	sub := fmt.Sprintf("%s-%s", name, sessionId)
+	if opts.TLD != "" {
+		sub = fmt.Sprintf("%s.%s", sub, opts.TLD)
+	}
+	if opts.Port > 0 {
+		sub = fmt.Sprintf("%s:%d", sub, opts.Port)
+	}
+	return sub
+}
+
+// DecodeAlias decodes the host of a named endpoint. Hosts of instance IPs
+// are not aliases, so DecodeHost should be tried first.
+func DecodeAlias(host string) (AliasInfo, error) {
+	if hostRegex.MatchString(host) {
+		return AliasInfo{}, fmt.Errorf("Host is not an alias")
+	}
+	matches := aliasRegex.FindStringSubmatch(host)
+	if len(matches) != 5 {
+		return AliasInfo{}, fmt.Errorf("Couldn't find alias in string")
+	}
+
+	info := AliasInfo{Name: matches[1], SessionId: matches[2], TLD: matches[3]}
+	if matches[4] != "" {
+		i, _ := strconv.Atoi(matches[4])
+		info.Port = i
+	}
+	return info, nil
+}
+
+// HostSession returns the session of an instance or endpoint host
+func HostSession(host string) (string, error) {
+	if info, err := DecodeHost(host); err == nil {
+		return info.SessionId, nil
+	}
+	info, err := DecodeAlias(host)
+	if err != nil {
+		return "", err
+	}
+	return info.SessionId, nil
+}
@@ -43,3 +43,43 @@ func TestDecodeHostInfo(t *testing.T) {
 	_, err = DecodeHost("ip10-0-0-1")
 	assert.NotNil(t, err)
 }
+
+func TestEncodeDecodeAlias(t *testing.T) {
+	host := EncodeAlias("frontend", "aaaabbbb", HostOpts{TLD: "direct.foo.bar", EncodedPort: 8080, Port: 443})
+	assert.Equal(t, "frontend-aaaabbbb.direct.foo.bar:443", host)
+
+	info, err := DecodeAlias(host)
+	assert.Nil(t, err)
+	assert.Equal(t, AliasInfo{Name: "frontend", SessionId: "aaaabbbb", TLD: "direct.foo.bar", Port: 443}, info)
+
+	info, err = DecodeAlias("my-app-aaaabbbb")
+	assert.Nil(t, err)
+	assert.Equal(t, AliasInfo{Name: "my-app", SessionId: "aaaabbbb"}, info)
+
+	for _, host := range []string{"ip10-0-0-1-aaaabbbb", "frontend", "frontend-aaa.foo.bar", "-aaaabbbb", "Frontend-aaaabbbb"} {
+		_, err := DecodeAlias(host)
+		assert.NotNil(t, err, host)
+	}
+}
+
+func TestValidAliasName(t *testing.T) {
+	for _, name := range []string{"frontend", "my-app", "api2", "a"} {
+		assert.True(t, ValidAliasName(name), name)
+	}
+	for _, name := range []string{"", "Frontend", "my_app", "app-", "-app", "ip10-0-0-1", "a.b", "averyveryveryveryveryverylongname"} {
+		assert.False(t, ValidAliasName(name), name)
+	}
+}
+
+func TestHostSession(t *testing.T) {
+	id, err := HostSession("ip10-0-0-1-aaaabbbb-8080.direct.foo.bar")
+	assert.Nil(t, err)
+	assert.Equal(t, "aaaabbbb", id)
+
+	id, err = HostSession("frontend-ccccdddd.direct.foo.bar")
+	assert.Nil(t, err)
+	assert.Equal(t, "ccccdddd", id)
+
+	_, err = HostSession("localhost")
+	assert.NotNil(t, err)
+}
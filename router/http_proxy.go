@@ -0,0 +1,263 @@
+package router
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// AccessLogEntry describes a request proxied in HTTP mode
+type AccessLogEntry struct {
+	Time       time.Time `json:"time"`
+	Client     string    `json:"client"`
+	Method     string    `json:"method"`
+	Host       string    `json:"host"`
+	Path       string    `json:"path"`
+	SessionId  string    `json:"session_id,omitempty"`
+	Backend    string    `json:"backend,omitempty"`
+	Status     int       `json:"status"`
+	Bytes      int64     `json:"bytes"`
+	DurationMs float64   `json:"duration_ms"`
+	UserAgent  string    `json:"user_agent,omitempty"`
+	Websocket  bool      `json:"websocket,omitempty"`
+}
+
+// AccessLogger receives an entry for every request proxied in HTTP mode
+type AccessLogger func(entry AccessLogEntry)
+
+// logAccess is the default access logger, writing entries as JSON lines
+func logAccess(entry AccessLogEntry) {
+	b, err := json.Marshal(entry)
+	if err != nil {
+		return
+	}
+	log.Printf("access %s\n", b)
+}
+
+// SessionStats counts the HTTP requests proxied to the instances of a session
+type SessionStats struct {
+	Requests    uint64    `json:"requests"`
+	Errors      uint64    `json:"errors"`
+	Websockets  uint64    `json:"websockets"`
+	BytesOut    int64     `json:"bytes_out"`
+	LastRequest time.Time `json:"last_request"`
+}
+
+type sessionCounters struct {
+	mu       sync.Mutex
+	sessions map[string]*SessionStats
+}
+
+func (c *sessionCounters) record(entry AccessLogEntry) {
+	if entry.SessionId == "" {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	s, found := c.sessions[entry.SessionId]
+	if !found {
+		s = &SessionStats{}
+		c.sessions[entry.SessionId] = s
+	}
+	s.Requests++
+	if entry.Status >= 500 {
+		s.Errors++
+	}
+	if entry.Websocket {
+		s.Websockets++
+	}
+	s.BytesOut += entry.Bytes
+	s.LastRequest = entry.Time
+}
+
+// SetAccessLogger sets where access logs of HTTP requests go. By default
+// they are logged as JSON lines.
+func (r *proxyRouter) SetAccessLogger(logger AccessLogger) {
+	r.accessLog = logger
+}
+
+// SessionStats returns the HTTP request counters of a session
+func (r *proxyRouter) SessionStats(sessionId string) SessionStats {
+	r.counters.mu.Lock()
+	defer r.counters.mu.Unlock()
+
+	if s, found := r.counters.sessions[sessionId]; found {
+		return *s
+	}
+	return SessionStats{}
+}
+
+// ForgetSession drops the counters and metrics of a session once it is gone
+func (r *proxyRouter) ForgetSession(sessionId string) {
+	r.counters.mu.Lock()
+	defer r.counters.mu.Unlock()
+
+	delete(r.counters.sessions, sessionId)
+	forgetSessionMetrics(sessionId)
+}
+
+// connListener hands connections accepted by the router to the HTTP server
+type connListener struct {
+	addr  net.Addr
+	conns chan net.Conn
+	done  chan struct{}
+	once  sync.Once
+}
+
+func newConnListener(addr net.Addr) *connListener {
+	return &connListener{addr: addr, conns: make(chan net.Conn), done: make(chan struct{})}
+}
+
+func (l *connListener) Accept() (net.Conn, error) {
+	select {
+	case c := <-l.conns:
+		return c, nil
+	case <-l.done:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *connListener) Close() error {
+	l.once.Do(func() {
+		close(l.done)
+	})
+	return nil
+}
+
+func (l *connListener) Addr() net.Addr {
+	return l.addr
+}
+
+func (l *connListener) serve(c net.Conn) {
+	select {
+	case l.conns <- c:
+	case <-l.done:
+		c.Close()
+	}
+}
+
+// responseRecorder keeps the status and size of a response for the access log
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (w *responseRecorder) WriteHeader(code int) {
+	if w.status == 0 {
+		w.status = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *responseRecorder) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(b)
+	w.bytes += int64(n)
+	return n, err
+}
+
+// Unwrap lets the reverse proxy hijack the connection for websockets and
+// flush streamed responses
+func (w *responseRecorder) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+type backendKey struct{}
+type sessionKey struct{}
+
+// newHTTPProxy creates the reverse proxy serving plain HTTP. Each request is
+// directed on its own, so keep-alive connections can move between hosts.
+func (r *proxyRouter) newHTTPProxy() *httputil.ReverseProxy {
+	return &httputil.ReverseProxy{
+		Rewrite: func(pr *httputil.ProxyRequest) {
+			dst := pr.In.Context().Value(backendKey{}).(*net.TCPAddr)
+			pr.SetURL(&url.URL{Scheme: "http", Host: dst.String()})
+			pr.Out.Host = pr.In.Host
+
+			pr.Out.Header["X-Forwarded-For"] = pr.In.Header["X-Forwarded-For"]
+			pr.SetXForwarded()
+			if host := pr.In.Header.Get("X-Forwarded-Host"); host != "" {
+				pr.Out.Header.Set("X-Forwarded-Host", host)
+			}
+		},
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+				sessionId, _ := ctx.Value(sessionKey{}).(string)
+				return r.dialBackend(ctx, ProtocolHTTP, sessionId, addr)
+			},
+			MaxIdleConnsPerHost: 16,
+			IdleConnTimeout:     90 * time.Second,
+		},
+		ErrorHandler: func(rw http.ResponseWriter, req *http.Request, err error) {
+			log.Printf("Error proxying request to %s: %v\n", req.Host, err)
+			rw.WriteHeader(http.StatusBadGateway)
+		},
+	}
+}
+
+func (r *proxyRouter) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	start := time.Now()
+	host := req.Header.Get("X-Forwarded-Host")
+	if host == "" {
+		host = req.Host
+	}
+
+	w := &responseRecorder{ResponseWriter: rw}
+	entry := AccessLogEntry{
+		Time:      start,
+		Client:    req.RemoteAddr,
+		Method:    req.Method,
+		Host:      host,
+		Path:      req.URL.Path,
+		UserAgent: req.UserAgent(),
+		Websocket: strings.EqualFold(req.Header.Get("Upgrade"), "websocket"),
+	}
+	if sessionId, err := HostSession(host); err == nil {
+		entry.SessionId = sessionId
+	}
+	defer func() {
+		entry.Status = w.status
+		if entry.Status == 0 && entry.Websocket {
+			entry.Status = http.StatusSwitchingProtocols
+		}
+		entry.Bytes = w.bytes
+		entry.DurationMs = float64(time.Since(start).Microseconds()) / 1000
+		r.counters.record(entry)
+		if r.accessLog != nil {
+			r.accessLog(entry)
+		}
+	}()
+
+	info, err := r.direct(ProtocolHTTP, host)
+	if err != nil {
+		log.Printf("Error directing request: %v\n", err)
+		w.WriteHeader(http.StatusBadGateway)
+		return
+	}
+	if !r.authorizeHTTP(w, req, host, info) {
+		return
+	}
+	release, err := r.conns.acquire(ProtocolHTTP, entry.SessionId)
+	if err != nil {
+		log.Printf("Refused request from %s to %s: %v\n", req.RemoteAddr, host, err)
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	defer release()
+	entry.Backend = info.Dst.String()
+
+	ctx := context.WithValue(req.Context(), backendKey{}, info.Dst)
+	ctx = context.WithValue(ctx, sessionKey{}, entry.SessionId)
+	r.httpProxy.ServeHTTP(w, req.WithContext(ctx))
+}
@@ -0,0 +1,100 @@
+package router
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProxy_HttpKeepAliveHosts(t *testing.T) {
+	dir, private, _, _ := generateKeys()
+	defer os.RemoveAll(dir)
+
+	backend := func(name string) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprintf(w, "%s %s %s", name, r.Header.Get("X-Forwarded-For"), r.Header.Get("X-Forwarded-Host"))
+		}))
+	}
+	ts1, ts2 := backend("one"), backend("two")
+	defer ts1.Close()
+	defer ts2.Close()
+
+	r := NewRouter(func(protocol Protocol, host string) (*DirectorInfo, error) {
+		ts := ts1
+		if strings.HasPrefix(host, "ip10-0-0-2") {
+			ts = ts2
+		}
+		u, _ := url.Parse(ts.URL)
+		a, _ := net.ResolveTCPAddr("tcp", u.Host)
+		return &DirectorInfo{Dst: a}, nil
+	}, private)
+	var mu sync.Mutex
+	entries := []AccessLogEntry{}
+	r.SetAccessLogger(func(entry AccessLogEntry) {
+		mu.Lock()
+		entries = append(entries, entry)
+		mu.Unlock()
+	})
+	r.Listen(":0", ":0", ":0")
+	defer r.Close()
+
+	conn, err := net.Dial("tcp", strings.Replace(r.ListenHttpAddress(), "[::]", "127.0.0.1", 1))
+	assert.Nil(t, err)
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+
+	// Requests on the same connection are directed one by one
+	for _, host := range []string{"ip10-0-0-1-aaaabbbb-80.direct.localhost", "ip10-0-0-2-ccccdddd-80.direct.localhost", "ip10-0-0-1-aaaabbbb-80.direct.localhost"} {
+		req, _ := http.NewRequest("GET", "http://"+host+"/page", nil)
+		assert.Nil(t, req.Write(conn))
+		resp, err := http.ReadResponse(reader, req)
+		assert.Nil(t, err)
+		body, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+
+		name := "one"
+		if strings.HasPrefix(host, "ip10-0-0-2") {
+			name = "two"
+		}
+		assert.Equal(t, fmt.Sprintf("%s 127.0.0.1 %s", name, host), string(body))
+	}
+
+	stats := r.SessionStats("aaaabbbb")
+	assert.Equal(t, uint64(2), stats.Requests)
+	assert.Equal(t, uint64(1), r.SessionStats("ccccdddd").Requests)
+	r.ForgetSession("aaaabbbb")
+	assert.Equal(t, uint64(0), r.SessionStats("aaaabbbb").Requests)
+
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Len(t, entries, 3)
+	assert.Equal(t, "ccccdddd", entries[1].SessionId)
+	assert.Equal(t, http.StatusOK, entries[1].Status)
+	assert.Equal(t, "/page", entries[1].Path)
+	assert.Equal(t, strings.TrimPrefix(ts2.URL, "http://"), entries[1].Backend)
+}
+
+func TestProxy_HttpUnknownHost(t *testing.T) {
+	dir, private, _, _ := generateKeys()
+	defer os.RemoveAll(dir)
+
+	r := NewRouter(func(protocol Protocol, host string) (*DirectorInfo, error) {
+		return nil, fmt.Errorf("Not recognized")
+	}, private)
+	r.Listen(":0", ":0", ":0")
+	defer r.Close()
+
+	resp, err := http.Get(getRouterUrl("http", r))
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusBadGateway, resp.StatusCode)
+}
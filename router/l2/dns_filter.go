@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/docker/client"
+	"github.com/ringo380/lessoncraft/netpolicy"
+	"github.com/ringo380/lessoncraft/router"
+)
+
+// dnsAllowTTL is how long the DNS allowlist of a client is cached
+const dnsAllowTTL = 30 * time.Second
+
+type dnsAllowEntry struct {
+	domains []string
+	expires time.Time
+}
+
+// dnsAllowlists finds the DNS allowlist of the instance sending a query from
+// the labels of its container, or of its session network
+type dnsAllowlists struct {
+	c *client.Client
+
+	mu      sync.Mutex
+	entries map[string]dnsAllowEntry
+}
+
+func newDNSAllowlists(c *client.Client) *dnsAllowlists {
+	return &dnsAllowlists{c: c, entries: map[string]dnsAllowEntry{}}
+}
+
+// Filter only resolves the domains allowed for the client. Clients without an
+// allowlist can resolve every domain.
+func (l *dnsAllowlists) Filter() router.DNSFilter {
+	return func(client net.IP, name string) bool {
+		domains, err := l.get(client)
+		if err != nil {
+			// Policies are enforced even if they cannot be looked up
+			log.Printf("Could not find the DNS allowlist of [%s]: %v\n", client, err)
+			return false
+		}
+		return domains == nil || netpolicy.DomainAllowed(domains, name)
+	}
+}
+
+func (l *dnsAllowlists) get(ip net.IP) ([]string, error) {
+	key := ip.String()
+	l.mu.Lock()
+	entry, found := l.entries[key]
+	l.mu.Unlock()
+	if found && time.Now().Before(entry.expires) {
+		return entry.domains, nil
+	}
+
+	domains, err := l.lookup(ip)
+	if err != nil {
+		return nil, err
+	}
+	l.mu.Lock()
+	l.entries[key] = dnsAllowEntry{domains: domains, expires: time.Now().Add(dnsAllowTTL)}
+	l.mu.Unlock()
+	return domains, nil
+}
+
+func (l *dnsAllowlists) lookup(ip net.IP) ([]string, error) {
+	ctx := context.Background()
+	args := filters.NewArgs(filters.Arg("label", netpolicy.DNSAllowLabel))
+
+	containers, err := l.c.ContainerList(ctx, container.ListOptions{Filters: args})
+	if err != nil {
+		return nil, err
+	}
+	for _, c := range containers {
+		if c.NetworkSettings == nil {
+			continue
+		}
+		for _, n := range c.NetworkSettings.Networks {
+			if n.IPAddress == ip.String() {
+				return splitDomains(c.Labels[netpolicy.DNSAllowLabel]), nil
+			}
+		}
+	}
+
+	networks, err := l.c.NetworkList(ctx, network.ListOptions{Filters: args})
+	if err != nil {
+		return nil, err
+	}
+	for _, n := range networks {
+		for _, conf := range n.IPAM.Config {
+			if _, subnet, err := net.ParseCIDR(conf.Subnet); err == nil && subnet.Contains(ip) {
+				return splitDomains(n.Labels[netpolicy.DNSAllowLabel]), nil
+			}
+		}
+	}
+	return nil, nil
+}
+
+func splitDomains(label string) []string {
+	domains := []string{}
+	for _, d := range strings.Split(label, ",") {
+		if d = strings.TrimSpace(d); d != "" {
+			domains = append(domains, d)
+		}
+	}
+	return domains
+}
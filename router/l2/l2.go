@@ -8,6 +8,10 @@ import (
 	"net"
 	"net/http"
 	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
 	"time"
 
 	"golang.org/x/crypto/ssh"
@@ -17,15 +21,31 @@ import (
 	"github.com/docker/docker/api/types/network"
 	"github.com/docker/docker/client"
 	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/ringo380/lessoncraft/config"
 	"github.com/ringo380/lessoncraft/router"
 	"github.com/shirou/gopsutil/load"
 	"github.com/urfave/negroni"
 )
 
+// portForwardsSyncInterval is how often the port forwards are fetched
+const portForwardsSyncInterval = 5 * time.Second
+
+// aliases resolves the named endpoints of sessions, if enabled
+var aliases router.AliasResolver
+
+// instanceSSHAuth is how SSH logins are forwarded to instances. The root
+// password of the instances is only used when no instance key is configured.
+var instanceSSHAuth = []ssh.AuthMethod{ssh.Password("root")}
+
 func director(protocol router.Protocol, host string) (*router.DirectorInfo, error) {
 	info, err := router.DecodeHost(host)
 	if err != nil {
+		if aliases != nil {
+			if alias, aliasErr := router.DecodeAlias(host); aliasErr == nil {
+				return aliasDirector(protocol, alias)
+			}
+		}
 		return nil, err
 	}
 
@@ -44,7 +64,7 @@ func director(protocol router.Protocol, host string) (*router.DirectorInfo, erro
 		} else if protocol == router.ProtocolSSH {
 			port = 22
 			i.SSHUser = "root"
-			i.SSHAuthMethods = []ssh.AuthMethod{ssh.Password("root")}
+			i.SSHAuthMethods = instanceSSHAuth
 		} else if protocol == router.ProtocolDNS {
 			port = 53
 		}
@@ -58,6 +78,32 @@ func director(protocol router.Protocol, host string) (*router.DirectorInfo, erro
 	return &i, nil
 }
 
+// aliasDirector directs hosts of named endpoints to the port they name. SSH
+// logins reach the instance of the endpoint.
+func aliasDirector(protocol router.Protocol, alias router.AliasInfo) (*router.DirectorInfo, error) {
+	endpoint, err := aliases(alias.SessionId, alias.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	i := router.DirectorInfo{}
+	port := endpoint.Port
+	if protocol == router.ProtocolSSH {
+		port = 22
+		i.SSHUser = "root"
+		i.SSHAuthMethods = instanceSSHAuth
+	} else if protocol == router.ProtocolDNS {
+		port = 53
+	}
+
+	t, err := net.ResolveTCPAddr("tcp4", fmt.Sprintf("%s:%d", endpoint.IP, port))
+	if err != nil {
+		return nil, err
+	}
+	i.Dst = t
+	return &i, nil
+}
+
 func connectNetworks() error {
 	ctx := context.Background()
 	c, err := client.NewClientWithOpts()
@@ -90,7 +136,9 @@ func connectNetworks() error {
 	return nil
 }
 
-func monitorNetworks() {
+// monitorNetworks saves the networks of the router as they change, and calls
+// sessionGone when the network of a session is removed
+func monitorNetworks(sessionGone func(sessionId string)) {
 	c, err := client.NewClientWithOpts()
 	if err != nil {
 		log.Fatal(err)
@@ -107,6 +155,10 @@ func monitorNetworks() {
 		select {
 		case m := <-cmsg:
 			if m.Type == "network" {
+				if m.Action == "destroy" {
+					// Networks are named after their session
+					sessionGone(m.Actor.Attributes["name"])
+				}
 				// Router has been connected to a new network. Let's get all connections and store them in case of restart.
 				container, err := c.ContainerInspect(ctx, config.PWDContainerName)
 				if err != nil {
@@ -137,16 +189,57 @@ func main() {
 	if err != nil && !os.IsNotExist(err) {
 		log.Fatal("connect networks:", err)
 	}
-	go monitorNetworks()
+
+	if config.L2EndpointsURL != "" {
+		if config.L2AccessKey == "" {
+			log.Fatal("l2-endpoints-url requires l2-access-key")
+		}
+		aliases = router.RemoteAliasResolver(config.L2EndpointsURL, []byte(config.L2AccessKey))
+	}
+
+	r := router.NewRouter(director, config.SSHKeyPath)
+	go monitorNetworks(func(sessionId string) {
+		r.ForgetSession(sessionId)
+		r.StopSessionForwards(sessionId)
+		r.ForgetZone(sessionId)
+	})
 
 	ro := mux.NewRouter()
 	ro.HandleFunc("/ping", ping).Methods("GET")
+	ro.Handle("/metrics", promhttp.Handler())
+	ro.HandleFunc("/sessions/{sessionId}/stats", func(rw http.ResponseWriter, req *http.Request) {
+		rw.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(rw).Encode(r.SessionStats(mux.Vars(req)["sessionId"]))
+	}).Methods("GET")
+
+	// Zones of sessions are pushed by LessonCraft as their instances change
+	if config.L2AccessKey != "" {
+		ro.HandleFunc("/zones", router.ZoneUpdateHandler([]byte(config.L2AccessKey), r.SetZone)).Methods("POST")
+	}
 
 	// Add lesson routes
 	ro.HandleFunc("/api/lessons", listLessons).Methods("GET")
 	ro.HandleFunc("/api/lessons/{id}", getLesson).Methods("GET")
 	ro.HandleFunc("/api/lessons/{id}/start", startLesson).Methods("POST")
 
+	if config.L2TLSMode != "" {
+		certs, err := newCertificateSource()
+		if err != nil {
+			log.Fatal("tls certificates:", err)
+		}
+		if ca, ok := certs.(*router.LocalCA); ok {
+			ro.HandleFunc("/ca.pem", func(rw http.ResponseWriter, req *http.Request) {
+				rw.Header().Set("Content-Type", "application/x-pem-file")
+				rw.Write(ca.CertPEM())
+			}).Methods("GET")
+		}
+		ports, err := parsePorts(config.L2TLSPassthroughPorts)
+		if err != nil {
+			log.Fatal("tls passthrough ports:", err)
+		}
+		r.TerminateTLS(certs, ports...)
+	}
+
 	n := negroni.Classic()
 	n.UseHandler(ro)
 
@@ -158,9 +251,153 @@ func main() {
 	}
 	go httpServer.ListenAndServe()
 
-	r := router.NewRouter(director, config.SSHKeyPath)
-	r.ListenAndWait(":443", ":53", ":22")
-	defer r.Close()
+	c, err := client.NewClientWithOpts()
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer c.Close()
+	r.SetDNSFilter(newDNSAllowlists(c).Filter())
+	if config.L2AccessKey != "" {
+		access, err := newAccessControl()
+		if err != nil {
+			log.Fatal("access control:", err)
+		}
+		r.SetAccessControl(access)
+	}
+	if config.L2SSHAuthURL != "" {
+		if config.L2AccessKey == "" {
+			log.Fatal("l2-ssh-auth-url requires l2-access-key")
+		}
+		r.SetSSHAuthenticator(router.RemoteSSHAuthenticator(config.L2SSHAuthURL, []byte(config.L2AccessKey)))
+	} else if config.L2SSHAnyKey {
+		log.Println("SSH logins are accepted with any key")
+		r.SetSSHAuthenticator(router.AnySSHKey)
+	} else {
+		log.Println("SSH logins are disabled, set l2-ssh-auth-url to check them with LessonCraft")
+	}
+	if config.L2InstanceSSHKey != "" {
+		signer, err := loadInstanceSSHKey(config.L2InstanceSSHKey)
+		if err != nil {
+			log.Fatal("instance SSH key:", err)
+		}
+		instanceSSHAuth = []ssh.AuthMethod{ssh.PublicKeys(signer)}
+	} else {
+		log.Println("l2-instance-ssh-key is not set, logging in to instances with their root password")
+	}
+	if config.L2PortsURL != "" {
+		if config.L2AccessKey == "" {
+			log.Fatal("l2-ports-url requires l2-access-key")
+		}
+		first, last, err := router.ParsePortRange(config.L2PortRange)
+		if err != nil {
+			log.Fatal("port range:", err)
+		}
+		go syncPortForwards(r, router.RemotePortForwards(config.L2PortsURL, []byte(config.L2AccessKey)), first, last)
+	}
+	r.SetZoneDomain(config.L2DNSDomain)
+	r.SetDNSUpstream(config.L2DNSUpstream)
+	r.SetConnectionLimits(config.L2MaxConnections, config.L2MaxSessionConnections)
+	r.SetIdleTimeout(config.L2IdleTimeout)
+	r.Listen(":443", ":53", ":22")
+
+	// Connections are drained on stop, so upgrades of the router don't cut
+	// learners off
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGTERM, os.Interrupt)
+	<-stop
+	log.Printf("Draining %d connections for up to %s\n", r.ActiveConnections(), config.L2DrainTimeout)
+	ctx, cancel := context.WithTimeout(context.Background(), config.L2DrainTimeout)
+	defer cancel()
+	if err := r.Drain(ctx); err != nil {
+		log.Printf("Closed the router with %d connections left: %v\n", r.ActiveConnections(), err)
+	}
+	httpServer.Shutdown(ctx)
+}
+
+// loadInstanceSSHKey reads the private key SSH logins are forwarded to
+// instances with
+func loadInstanceSSHKey(path string) (ssh.Signer, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ssh.ParsePrivateKey(b)
+}
+
+// syncPortForwards keeps serving the port forwards of the sessions. Forwards
+// outside of the port range are ignored.
+func syncPortForwards(r interface{ SetPortForwards([]router.PortForward) }, source router.PortForwardSource, first, last int) {
+	for {
+		forwards, err := source()
+		if err != nil {
+			log.Printf("Couldn't fetch port forwards: %v\n", err)
+		} else {
+			allowed := []router.PortForward{}
+			for _, f := range forwards {
+				if f.Port >= first && f.Port <= last {
+					allowed = append(allowed, f)
+				}
+			}
+			r.SetPortForwards(allowed)
+		}
+		time.Sleep(portForwardsSyncInterval)
+	}
+}
+
+// newAccessControl requires tokens signed with the access key to reach the
+// ports of instances, except for public ports and trusted networks
+func newAccessControl() (*router.AccessControl, error) {
+	access := router.NewAccessControl([]byte(config.L2AccessKey))
+
+	ports, err := parsePorts(config.L2PublicPorts)
+	if err != nil {
+		return nil, err
+	}
+	access.SetPublicPorts(router.PublicPorts(ports...))
+
+	networks, err := router.ParseNetworks(config.L2TrustedNetworks)
+	if err != nil {
+		return nil, err
+	}
+	access.Trust(networks...)
+	return access, nil
+}
+
+// newCertificateSource returns the certificates the router terminates TLS with
+func newCertificateSource() (router.CertificateSource, error) {
+	switch config.L2TLSMode {
+	case router.TLSModeWildcard:
+		return router.WildcardCertificate(config.L2TLSCert, config.L2TLSKey)
+	case router.TLSModeACME:
+		if config.L2TLSDomain == "" {
+			return nil, fmt.Errorf("l2-tls-domain is required for ACME certificates")
+		}
+		return router.ACMECertificates(config.L2TLSDomain, config.L2TLSDir, ""), nil
+	case router.TLSModeLocal:
+		ca, err := router.NewLocalCA(config.L2TLSDir)
+		if err != nil {
+			return nil, err
+		}
+		log.Printf("Terminating TLS with the local CA in %s\n", config.L2TLSDir)
+		return ca, nil
+	}
+	return nil, fmt.Errorf("unknown TLS mode %q", config.L2TLSMode)
+}
+
+func parsePorts(list string) ([]int, error) {
+	ports := []int{}
+	for _, p := range strings.Split(list, ",") {
+		p = strings.TrimSpace(p)
+		if p == "" {
+			continue
+		}
+		port, err := strconv.Atoi(p)
+		if err != nil {
+			return nil, fmt.Errorf("invalid port %q", p)
+		}
+		ports = append(ports, port)
+	}
+	return ports, nil
 }
 
 func ping(rw http.ResponseWriter, req *http.Request) {
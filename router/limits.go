@@ -0,0 +1,149 @@
+package router
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ConnectionLimitError is returned when a connection would go over a limit
+type ConnectionLimitError struct {
+	// Limit is "global" or "session"
+	Limit string
+	Max   int
+}
+
+func (e *ConnectionLimitError) Error() string {
+	return fmt.Sprintf("%s connection limit of %d reached", e.Limit, e.Max)
+}
+
+// connTracker counts the connections being proxied, globally and by session,
+// and enforces the connection limits
+type connTracker struct {
+	mu         sync.Mutex
+	global     int
+	perSession int
+	total      int
+	sessions   map[string]int
+}
+
+func newConnTracker() *connTracker {
+	return &connTracker{sessions: map[string]int{}}
+}
+
+// acquire counts a new connection of a session, unless it goes over a
+// limit. The returned func releases it.
+func (t *connTracker) acquire(protocol Protocol, sessionId string) (func(), error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.global > 0 && t.total >= t.global {
+		refusedCounterVec.WithLabelValues(protocol.String(), "global").Inc()
+		return nil, &ConnectionLimitError{Limit: "global", Max: t.global}
+	}
+	if t.perSession > 0 && sessionId != "" && t.sessions[sessionId] >= t.perSession {
+		refusedCounterVec.WithLabelValues(protocol.String(), "session").Inc()
+		return nil, &ConnectionLimitError{Limit: "session", Max: t.perSession}
+	}
+	t.total++
+	t.sessions[sessionId]++
+	gauge := activeConnectionsGauge.WithLabelValues(protocol.String(), sessionId)
+	gauge.Inc()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			t.mu.Lock()
+			defer t.mu.Unlock()
+
+			t.total--
+			if t.sessions[sessionId]--; t.sessions[sessionId] <= 0 {
+				delete(t.sessions, sessionId)
+			}
+			gauge.Dec()
+		})
+	}, nil
+}
+
+func (t *connTracker) active() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.total
+}
+
+// wait waits until no connection is left or ctx is done
+func (t *connTracker) wait(ctx context.Context) error {
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+	for t.active() > 0 {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+	return nil
+}
+
+// SetConnectionLimits caps the connections and HTTP requests the router
+// proxies at once, in total and for each session. Zero means no limit.
+func (r *proxyRouter) SetConnectionLimits(global, perSession int) {
+	r.conns.mu.Lock()
+	defer r.conns.mu.Unlock()
+	r.conns.global = global
+	r.conns.perSession = perSession
+}
+
+// SetIdleTimeout closes passed through TLS and SSH connections after d
+// without traffic. Zero keeps them open.
+func (r *proxyRouter) SetIdleTimeout(d time.Duration) {
+	r.idleTimeout = d
+}
+
+// ActiveConnections returns how many connections and HTTP requests are
+// being proxied
+func (r *proxyRouter) ActiveConnections() int {
+	return r.conns.active()
+}
+
+// Drain stops accepting connections and waits for the proxied ones to finish,
+// for upgrades without downtime. HTTP keep-alive connections are closed once
+// idle. The router is closed when everything is done or ctx is, in which
+// case the error of ctx is returned.
+func (r *proxyRouter) Drain(ctx context.Context) error {
+	r.Lock()
+	if r.httpListener != nil {
+		r.httpListener.Close()
+	}
+	if r.sshListener != nil {
+		r.sshListener.Close()
+	}
+	r.Unlock()
+
+	var err error
+	if r.httpServer != nil {
+		wg := sync.WaitGroup{}
+		wg.Add(2)
+		var err1, err2 error
+		go func() {
+			err1 = r.httpServer.Shutdown(ctx)
+			wg.Done()
+		}()
+		go func() {
+			err2 = r.tlsServer.Shutdown(ctx)
+			wg.Done()
+		}()
+		wg.Wait()
+		if err1 != nil {
+			err = err1
+		} else {
+			err = err2
+		}
+	}
+	if err == nil {
+		err = r.conns.wait(ctx)
+	}
+	r.Close()
+	return err
+}
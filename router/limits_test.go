@@ -0,0 +1,217 @@
+package router
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProxy_ConnectionLimits(t *testing.T) {
+	dir, private, _, _ := generateKeys()
+	defer os.RemoveAll(dir)
+
+	started := make(chan bool)
+	unblock := make(chan bool)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/block" {
+			started <- true
+			<-unblock
+		}
+		fmt.Fprint(w, "hi")
+	}))
+	defer ts.Close()
+
+	r := NewRouter(func(protocol Protocol, host string) (*DirectorInfo, error) {
+		u, _ := url.Parse(ts.URL)
+		a, _ := net.ResolveTCPAddr("tcp", u.Host)
+		return &DirectorInfo{Dst: a}, nil
+	}, private)
+	r.SetAccessLogger(nil)
+	r.SetConnectionLimits(0, 1)
+	r.Listen(":0", ":0", ":0")
+	defer r.Close()
+
+	get := func(host, path string) int {
+		req, _ := http.NewRequest("GET", getRouterUrl("http", r)+path, nil)
+		req.Host = host
+		resp, err := http.DefaultClient.Do(req)
+		if !assert.Nil(t, err) {
+			return 0
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	done := make(chan int)
+	go func() {
+		done <- get("ip10-0-0-1-aaaabbbb-80.direct.localhost", "/block")
+	}()
+	<-started
+	assert.Equal(t, 1, r.ActiveConnections())
+	assert.Equal(t, float64(1), testutil.ToFloat64(activeConnectionsGauge.WithLabelValues("http", "aaaabbbb")))
+
+	// The session is at its limit, other sessions are not
+	assert.Equal(t, http.StatusServiceUnavailable, get("ip10-0-0-1-aaaabbbb-80.direct.localhost", "/"))
+	assert.Equal(t, http.StatusOK, get("ip10-0-0-2-ccccdddd-80.direct.localhost", "/"))
+
+	unblock <- true
+	assert.Equal(t, http.StatusOK, <-done)
+	// The request is released once its response was written
+	assert.Eventually(t, func() bool {
+		return r.ActiveConnections() == 0
+	}, time.Second, 10*time.Millisecond)
+	assert.Equal(t, http.StatusOK, get("ip10-0-0-1-aaaabbbb-80.direct.localhost", "/"))
+	assert.True(t, testutil.ToFloat64(bytesCounterVec.WithLabelValues("http", "aaaabbbb", "out")) > 0)
+
+	r.ForgetSession("aaaabbbb")
+	// The series of the session are gone
+	assert.False(t, activeConnectionsGauge.DeleteLabelValues("http", "aaaabbbb"))
+	assert.False(t, bytesCounterVec.DeleteLabelValues("http", "aaaabbbb", "out"))
+}
+
+func TestProxy_Drain(t *testing.T) {
+	dir, private, _, _ := generateKeys()
+	defer os.RemoveAll(dir)
+
+	started := make(chan bool)
+	unblock := make(chan bool)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		started <- true
+		<-unblock
+		fmt.Fprint(w, "done")
+	}))
+	defer ts.Close()
+
+	r := NewRouter(func(protocol Protocol, host string) (*DirectorInfo, error) {
+		u, _ := url.Parse(ts.URL)
+		a, _ := net.ResolveTCPAddr("tcp", u.Host)
+		return &DirectorInfo{Dst: a}, nil
+	}, private)
+	r.SetAccessLogger(nil)
+	r.Listen(":0", ":0", ":0")
+	routerURL := getRouterUrl("http", r)
+
+	done := make(chan int)
+	go func() {
+		resp, err := http.Get(routerURL)
+		if err != nil {
+			done <- 0
+			return
+		}
+		resp.Body.Close()
+		done <- resp.StatusCode
+	}()
+	<-started
+
+	drained := make(chan error)
+	go func() {
+		drained <- r.Drain(context.Background())
+	}()
+
+	// New connections are refused while the request in flight finishes
+	assert.Eventually(t, func() bool {
+		c, err := net.Dial("tcp", strings.TrimPrefix(routerURL, "http://"))
+		if err == nil {
+			c.Close()
+		}
+		return err != nil
+	}, time.Second, 10*time.Millisecond)
+	select {
+	case <-drained:
+		t.Fatal("drain returned before the request finished")
+	default:
+	}
+
+	unblock <- true
+	assert.Equal(t, http.StatusOK, <-done)
+	assert.Nil(t, <-drained)
+}
+
+func TestProxy_DrainTimeout(t *testing.T) {
+	dir, private, _, _ := generateKeys()
+	defer os.RemoveAll(dir)
+
+	r := NewRouter(func(protocol Protocol, host string) (*DirectorInfo, error) {
+		return nil, fmt.Errorf("Not recognized")
+	}, private)
+	r.Listen(":0", ":0", ":0")
+
+	release, err := r.conns.acquire(ProtocolSSH, "aaaabbbb")
+	assert.Nil(t, err)
+	defer release()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	assert.Equal(t, context.DeadlineExceeded, r.Drain(ctx))
+}
+
+func TestConnTracker_GlobalLimit(t *testing.T) {
+	c := newConnTracker()
+	c.global = 2
+
+	release1, err := c.acquire(ProtocolSSH, "aaaabbbb")
+	assert.Nil(t, err)
+	_, err = c.acquire(ProtocolHTTPS, "ccccdddd")
+	assert.Nil(t, err)
+	_, err = c.acquire(ProtocolHTTP, "eeeeffff")
+	assert.Equal(t, &ConnectionLimitError{Limit: "global", Max: 2}, err)
+
+	// Releasing twice only counts once
+	release1()
+	release1()
+	assert.Equal(t, 1, c.active())
+	_, err = c.acquire(ProtocolHTTP, "eeeeffff")
+	assert.Nil(t, err)
+}
+
+func TestProxy_IdleTimeout(t *testing.T) {
+	r := &proxyRouter{}
+	r.SetIdleTimeout(50 * time.Millisecond)
+
+	client, src := net.Pipe()
+	dst, backend := net.Pipe()
+	defer client.Close()
+	defer backend.Close()
+
+	done := make(chan bool)
+	go func() {
+		r.proxyConn(src, dst)
+		done <- true
+	}()
+
+	// Traffic going one way keeps both ends open
+	go func() {
+		buf := make([]byte, 16)
+		for {
+			if _, err := client.Read(buf); err != nil {
+				return
+			}
+		}
+	}()
+	for i := 0; i < 5; i++ {
+		_, err := backend.Write([]byte("tick"))
+		assert.Nil(t, err)
+		time.Sleep(20 * time.Millisecond)
+	}
+	select {
+	case <-done:
+		t.Fatal("connection timed out while in use")
+	default:
+	}
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("idle connection was not closed")
+	}
+}
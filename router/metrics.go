@@ -0,0 +1,137 @@
+package router
+
+import (
+	"context"
+	"net"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	activeConnectionsGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "lessoncraft_router_active_connections",
+		Help: "Connections and HTTP requests being proxied by the router",
+	}, []string{"protocol", "session"})
+
+	bytesCounterVec = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "lessoncraft_router_bytes_total",
+		Help: "Bytes proxied by the router, in to instances and out of them",
+	}, []string{"protocol", "session", "direction"})
+
+	dialErrorsCounterVec = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "lessoncraft_router_dial_errors_total",
+		Help: "Failed connections to instances",
+	}, []string{"protocol"})
+
+	refusedCounterVec = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "lessoncraft_router_refused_connections_total",
+		Help: "Connections refused because of the connection limits",
+	}, []string{"protocol", "limit"})
+
+	directorHistogramVec = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "lessoncraft_router_director_duration_ms",
+		Help:    "How long it took the director to find where a host leads",
+		Buckets: []float64{1, 5, 25, 100, 500},
+	}, []string{"protocol"})
+)
+
+func init() {
+	prometheus.MustRegister(activeConnectionsGauge)
+	prometheus.MustRegister(bytesCounterVec)
+	prometheus.MustRegister(dialErrorsCounterVec)
+	prometheus.MustRegister(refusedCounterVec)
+	prometheus.MustRegister(directorHistogramVec)
+}
+
+func (p Protocol) String() string {
+	switch p {
+	case ProtocolHTTP:
+		return "http"
+	case ProtocolHTTPS:
+		return "https"
+	case ProtocolSSH:
+		return "ssh"
+	case ProtocolDNS:
+		return "dns"
+	case ProtocolTCP:
+		return "tcp"
+	case ProtocolUDP:
+		return "udp"
+	}
+	return "unknown"
+}
+
+// forgetSessionMetrics drops the series of a session once it is gone
+func forgetSessionMetrics(sessionId string) {
+	activeConnectionsGauge.DeletePartialMatch(prometheus.Labels{"session": sessionId})
+	bytesCounterVec.DeletePartialMatch(prometheus.Labels{"session": sessionId})
+}
+
+// direct runs the director, observing how long it takes
+func (r *proxyRouter) direct(protocol Protocol, host string) (*DirectorInfo, error) {
+	defer func(start time.Time) {
+		directorHistogramVec.WithLabelValues(protocol.String()).Observe(float64(time.Since(start).Nanoseconds()) / 1000000)
+	}(time.Now())
+	return r.director(protocol, host)
+}
+
+// dialBackend connects to an instance. The bytes going through the connection
+// are counted for the session.
+func (r *proxyRouter) dialBackend(ctx context.Context, protocol Protocol, sessionId, addr string) (net.Conn, error) {
+	c, err := r.dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		dialErrorsCounterVec.WithLabelValues(protocol.String()).Inc()
+		return nil, err
+	}
+	return &countingConn{
+		Conn: c,
+		in:   bytesCounterVec.WithLabelValues(protocol.String(), sessionId, "in"),
+		out:  bytesCounterVec.WithLabelValues(protocol.String(), sessionId, "out"),
+	}, nil
+}
+
+// countingConn counts the bytes written to an instance as in, and those read
+// from it as out
+type countingConn struct {
+	net.Conn
+	in, out prometheus.Counter
+}
+
+func (c *countingConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	c.out.Add(float64(n))
+	return n, err
+}
+
+func (c *countingConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	c.in.Add(float64(n))
+	return n, err
+}
+
+// idleConn times out once neither it nor its peer has received data for the
+// idle timeout. Both ends of a proxied connection share the last activity, so
+// one-way transfers keep the other end alive.
+type idleConn struct {
+	net.Conn
+	timeout time.Duration
+	last    *int64
+}
+
+func (c *idleConn) Read(b []byte) (int, error) {
+	for {
+		c.Conn.SetReadDeadline(time.Now().Add(c.timeout))
+		n, err := c.Conn.Read(b)
+		if n > 0 {
+			atomic.StoreInt64(c.last, time.Now().UnixNano())
+		}
+		if ne, ok := err.(net.Error); ok && ne.Timeout() && n == 0 {
+			if time.Since(time.Unix(0, atomic.LoadInt64(c.last))) < c.timeout {
+				continue
+			}
+		}
+		return n, err
+	}
+}
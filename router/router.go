@@ -1,13 +1,16 @@
 package router
 
 import (
-	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"log"
 	"net"
 	"net/http"
+	"net/http/httputil"
 	"strings"
 	"sync"
 	"time"
@@ -25,6 +28,9 @@ const (
 	ProtocolHTTPS
 	ProtocolSSH
 	ProtocolDNS
+	// ProtocolTCP and ProtocolUDP are used by port forwards
+	ProtocolTCP
+	ProtocolUDP
 )
 
 type DirectorInfo struct {
@@ -35,18 +41,42 @@ type DirectorInfo struct {
 
 type Director func(protocol Protocol, host string) (*DirectorInfo, error)
 
+// DNSFilter tells whether the router resolves an external name for a client
+type DNSFilter func(client net.IP, name string) bool
+
 type proxyRouter struct {
 	sync.Mutex
 
 	keyPath      string
 	director     Director
+	dnsFilter    DNSFilter
+	dnsUpstream  string
+	access       *AccessControl
 	closed       bool
 	httpListener *net.TCPListener
-	udpDnsServer *dns.Server
-	tcpDnsServer *dns.Server
-	sshListener  net.Listener
-	sshConfig    *ssh.ServerConfig
-	dialer       *net.Dialer
+	httpConns    *connListener
+	httpServer   *http.Server
+	httpProxy    *httputil.ReverseProxy
+	tlsConns     *connListener
+	tlsServer    *http.Server
+
+	tlsConfig      *tls.Config
+	tlsPassthrough map[int]bool
+	accessLog      AccessLogger
+	counters       sessionCounters
+	udpDnsServer   *dns.Server
+	tcpDnsServer   *dns.Server
+	sshListener    net.Listener
+	sshConfig      *ssh.ServerConfig
+	sshAuth        SSHAuthenticator
+	dialer         *net.Dialer
+	conns          *connTracker
+	idleTimeout    time.Duration
+	forwardsMu     sync.Mutex
+	forwards       map[string]*portForwarder
+	zonesMu        sync.RWMutex
+	zones          map[string]Zone
+	zoneDomain     string
 }
 
 func (r *proxyRouter) Listen(httpAddr, dnsAddr, sshAddr string) {
@@ -69,11 +99,28 @@ func (r *proxyRouter) listen(wg *sync.WaitGroup, httpAddr, dnsAddr, sshAddr stri
 		log.Fatal(err)
 	}
 	r.httpListener = l
+	r.httpConns = newConnListener(l.Addr())
+	r.httpServer = &http.Server{
+		Handler:           r,
+		ReadHeaderTimeout: 30 * time.Second,
+		IdleTimeout:       3 * time.Minute,
+	}
+	go r.httpServer.Serve(r.httpConns)
+	// TLS connections terminated by the router are served the same way
+	r.tlsConns = newConnListener(l.Addr())
+	r.tlsServer = &http.Server{
+		Handler:           r,
+		ReadHeaderTimeout: 30 * time.Second,
+		IdleTimeout:       3 * time.Minute,
+	}
+	go r.tlsServer.Serve(r.tlsConns)
 	wg.Add(1)
 	go func() {
-		for !r.closed {
+		for {
 			conn, err := r.httpListener.AcceptTCP()
-			if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				break
+			} else if err != nil {
 				continue
 			}
 			conn.SetKeepAlive(true)
@@ -110,7 +157,9 @@ func (r *proxyRouter) listen(wg *sync.WaitGroup, httpAddr, dnsAddr, sshAddr stri
 	go func() {
 		for {
 			nConn, err := lssh.Accept()
-			if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				break
+			} else if err != nil {
 				log.Fatal("failed to accept incoming connection: ", err)
 			}
 
@@ -121,46 +170,34 @@ func (r *proxyRouter) listen(wg *sync.WaitGroup, httpAddr, dnsAddr, sshAddr stri
 }
 
 func (r *proxyRouter) sshHandle(nConn net.Conn) {
+	if r.idleTimeout > 0 {
+		nConn = &idleConn{Conn: nConn, timeout: r.idleTimeout, last: new(int64)}
+	}
 	sshCon, chans, reqs, err := ssh.NewServerConn(nConn, r.sshConfig)
 	if err != nil {
 		nConn.Close()
 		return
 	}
+	defer sshCon.Close()
 
-	info, err := r.director(ProtocolSSH, sshCon.User())
+	info, err := r.direct(ProtocolSSH, sshCon.User())
 	if err != nil {
-		nConn.Close()
 		return
 	}
-
-	// The incoming Request channel must be serviced.
-	go ssh.DiscardRequests(reqs)
-
-	newChannel := <-chans
-	if newChannel == nil {
-		sshCon.Close()
-		return
-	}
-
-	if newChannel.ChannelType() != "session" {
-		newChannel.Reject(ssh.UnknownChannelType, "unknown channel type")
+	sessionId, _ := HostSession(sshCon.User())
+	release, err := r.conns.acquire(ProtocolSSH, sessionId)
+	if err != nil {
+		go ssh.DiscardRequests(reqs)
+		for newChannel := range chans {
+			rejectSshChannel(newChannel, err)
+		}
 		return
 	}
-
-	channel, requests, err := newChannel.Accept()
-	if err != nil {
-		log.Fatalf("Could not accept channel: %v", err)
+	defer release()
+	if sshCon.Permissions != nil && sshCon.Permissions.Extensions[SSHUserExtension] != "" {
+		log.Printf("Proxying SSH connection of user [%s] to %s\n", sshCon.Permissions.Extensions[SSHUserExtension], sshCon.User())
 	}
 
-	stderr := channel.Stderr()
-
-	fmt.Fprintf(stderr, "Connecting to %s\r\n", info.Dst.String())
-
-	/*
-		Auth: []ssh.AuthMethod{
-			ssh.Password("root"),
-		},
-	*/
 	clientConfig := &ssh.ClientConfig{
 		User: info.SSHUser,
 		Auth: info.SSHAuthMethods,
@@ -168,58 +205,118 @@ func (r *proxyRouter) sshHandle(nConn net.Conn) {
 			return nil
 		},
 	}
-
-	client, err := ssh.Dial("tcp", info.Dst.String(), clientConfig)
+	upCon, upChans, upReqs, err := r.sshDial(sessionId, info.Dst.String(), clientConfig)
 	if err != nil {
-		fmt.Fprintf(stderr, "Connect failed: %v\r\n", err)
-		channel.Close()
+		go ssh.DiscardRequests(reqs)
+		for newChannel := range chans {
+			rejectSshChannel(newChannel, fmt.Errorf("Connect failed: %v", err))
+		}
 		return
 	}
-
+	defer upCon.Close()
 	go func() {
-		for newChannel = range chans {
-			if newChannel == nil {
-				return
-			}
+		upCon.Wait()
+		sshCon.Close()
+	}()
 
-			channel2, reqs2, err := client.OpenChannel(newChannel.ChannelType(), newChannel.ExtraData())
-			if err != nil {
-				x, ok := err.(*ssh.OpenChannelError)
-				if ok {
-					newChannel.Reject(x.Reason, x.Message)
-				} else {
-					newChannel.Reject(ssh.Prohibited, "remote server denied channel request")
-				}
-				continue
-			}
+	// Global requests are passed on both ways, e.g. to set up remote port
+	// forwarding, as are the channels the instance opens for it
+	go proxySshRequests(reqs, upCon)
+	go proxySshRequests(upReqs, sshCon)
+	go proxySshChannels(upChans, sshCon)
 
-			channel, reqs, err := newChannel.Accept()
-			if err != nil {
-				channel2.Close()
-				continue
-			}
-			go proxySsh(reqs, reqs2, channel, channel2)
+	// Shells, SFTP and local port forwarding
+	proxySshChannels(chans, upCon)
+}
+
+func (r *proxyRouter) sshDial(sessionId, addr string, config *ssh.ClientConfig) (ssh.Conn, <-chan ssh.NewChannel, <-chan *ssh.Request, error) {
+	conn, err := r.dialBackend(context.Background(), ProtocolSSH, sessionId, addr)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	c, chans, reqs, err := ssh.NewClientConn(conn, addr, config)
+	if err != nil {
+		conn.Close()
+		return nil, nil, nil, err
+	}
+	return c, chans, reqs, nil
+}
+
+func proxySshRequests(reqs <-chan *ssh.Request, dst ssh.Conn) {
+	for req := range reqs {
+		ok, payload, err := dst.SendRequest(req.Type, req.WantReply, req.Payload)
+		if err != nil {
+			req.Reply(false, nil)
+			continue
 		}
-	}()
+		req.Reply(ok, payload)
+	}
+}
+
+func proxySshChannels(chans <-chan ssh.NewChannel, dst ssh.Conn) {
+	for newChannel := range chans {
+		go proxySshChannel(newChannel, dst)
+	}
+}
+
+func proxySshChannel(newChannel ssh.NewChannel, dst ssh.Conn) {
+	if newChannel.ChannelType() != "session" {
+		// Forwarded connections are only accepted once the other end has
+		// accepted them, so failures are reported to the client
+		channel2, reqs2, err := dst.OpenChannel(newChannel.ChannelType(), newChannel.ExtraData())
+		if err != nil {
+			rejectSshChannel(newChannel, err)
+			return
+		}
+		channel, reqs, err := newChannel.Accept()
+		if err != nil {
+			channel2.Close()
+			return
+		}
+		proxySsh(reqs, reqs2, channel, channel2)
+		return
+	}
 
-	// Forward the session channel
-	channel2, reqs2, err := client.OpenChannel("session", []byte{})
+	channel, requests, err := newChannel.Accept()
+	if err != nil {
+		return
+	}
+	channel2, reqs2, err := dst.OpenChannel(newChannel.ChannelType(), newChannel.ExtraData())
 	if err != nil {
-		fmt.Fprintf(stderr, "Remote session setup failed: %v\r\n", err)
+		fmt.Fprintf(channel.Stderr(), "Remote session setup failed: %v\r\n", err)
 		channel.Close()
 		return
 	}
 
-	maskedReqs := make(chan *ssh.Request, 1)
+	// Agent forwarding is not passed on, the instance must not use the keys
+	// of the learner
+	reqs := make(chan *ssh.Request)
 	go func() {
+		defer close(reqs)
 		for req := range requests {
 			if req.Type == "auth-agent-req@openssh.com" {
+				req.Reply(false, nil)
 				continue
 			}
-			maskedReqs <- req
+			reqs <- req
 		}
 	}()
-	proxySsh(maskedReqs, reqs2, channel, channel2)
+	proxySsh(reqs, reqs2, channel, channel2)
+}
+
+func rejectSshChannel(newChannel ssh.NewChannel, err error) {
+	if x, ok := err.(*ssh.OpenChannelError); ok {
+		newChannel.Reject(x.Reason, x.Message)
+		return
+	}
+	if newChannel.ChannelType() == "session" {
+		if channel, _, err2 := newChannel.Accept(); err2 == nil {
+			fmt.Fprintf(channel.Stderr(), "%v\r\n", err)
+			channel.Close()
+			return
+		}
+	}
+	newChannel.Reject(ssh.ConnectionFailed, err.Error())
 }
 
 func (r *proxyRouter) dnsRequest(w dns.ResponseWriter, req *dns.Msg) {
@@ -241,8 +338,30 @@ func (r *proxyRouter) dnsRequest(w dns.ResponseWriter, req *dns.Msg) {
 			return
 		}
 
-		info, err := r.director(ProtocolDNS, strings.TrimSuffix(question, "."))
+		if answer, extra, found := r.zoneAnswer(addrIP(w.RemoteAddr()), req.Question[0]); found {
+			m := new(dns.Msg)
+			m.SetReply(req)
+			m.Authoritative = true
+			m.RecursionAvailable = true
+			m.Answer = answer
+			m.Extra = extra
+			w.WriteMsg(m)
+			return
+		}
+
+		info, err := r.direct(ProtocolDNS, strings.TrimSuffix(question, "."))
 		if err != nil {
+			if !r.allowsDomain(w.RemoteAddr(), question) {
+				log.Printf("Refused to resolve [%s] for [%s]\n", question, w.RemoteAddr())
+				m := new(dns.Msg)
+				m.SetRcode(req, dns.RcodeNameError)
+				w.WriteMsg(m)
+				return
+			}
+			if r.dnsUpstream != "" {
+				r.forwardDNS(w, req)
+				return
+			}
 			// Director couldn't resolve it, try to lookup in the system's DNS
 			ips, err := net.LookupIP(question)
 			if err != nil {
@@ -293,6 +412,51 @@ func (r *proxyRouter) dnsRequest(w dns.ResponseWriter, req *dns.Msg) {
 	}
 }
 
+// SetDNSFilter sets the filter deciding which external names are resolved
+// for each client. Names of instances are always resolved.
+func (r *proxyRouter) SetDNSFilter(filter DNSFilter) {
+	r.dnsFilter = filter
+}
+
+func (r *proxyRouter) allowsDomain(client net.Addr, name string) bool {
+	if r.dnsFilter == nil {
+		return true
+	}
+	return r.dnsFilter(addrIP(client), name)
+}
+
+// forwardDNS answers a query with the answer of the upstream DNS server
+func (r *proxyRouter) forwardDNS(w dns.ResponseWriter, req *dns.Msg) {
+	network := "udp"
+	if _, ok := w.RemoteAddr().(*net.TCPAddr); ok {
+		network = "tcp"
+	}
+	c := &dns.Client{Net: network, Timeout: 5 * time.Second}
+	m, _, err := c.Exchange(req, r.dnsUpstream)
+	if err != nil {
+		log.Printf("Couldn't forward [%s] to [%s]: %v\n", req.Question[0].Name, r.dnsUpstream, err)
+		m = new(dns.Msg)
+		m.SetRcode(req, dns.RcodeServerFailure)
+	}
+	w.WriteMsg(m)
+}
+
+func addrIP(client net.Addr) net.IP {
+	switch addr := client.(type) {
+	case *net.UDPAddr:
+		return addr.IP
+	case *net.TCPAddr:
+		return addr.IP
+	}
+	return nil
+}
+
+// SetAccessControl requires clients to present an access token to reach the
+// private ports of a session. Without it, every port is public.
+func (r *proxyRouter) SetAccessControl(access *AccessControl) {
+	r.access = access
+}
+
 func (r *proxyRouter) Close() {
 	r.Lock()
 	defer r.Unlock()
@@ -300,6 +464,16 @@ func (r *proxyRouter) Close() {
 	if r.httpListener != nil {
 		r.httpListener.Close()
 	}
+	if r.httpServer != nil {
+		r.httpServer.Close()
+		r.httpConns.Close()
+		r.tlsServer.Close()
+		r.tlsConns.Close()
+	}
+	if r.sshListener != nil {
+		r.sshListener.Close()
+	}
+	r.stopForwards()
 	if r.udpDnsServer != nil {
 		r.udpDnsServer.Shutdown()
 	}
@@ -338,92 +512,88 @@ func (r *proxyRouter) ListenSshAddress() string {
 }
 
 func (r *proxyRouter) handleConnection(c net.Conn) {
-	defer c.Close()
 	// first try tls
 	start := time.Now()
 	vhostConn, err := vhost.TLS(c)
 	discoverElapsed := time.Since(start)
 
-	if err == nil {
-		// It is a TLS connection
-		defer vhostConn.Close()
-		host := vhostConn.ClientHelloMsg.ServerName
-		log.Printf("Proxying TLS connection to %s. Discover took %s\n", host, discoverElapsed)
-		info, err := r.director(ProtocolHTTPS, host)
-		if err != nil {
-			log.Printf("Error directing request: %v\n", err)
-			return
-		}
-		dstHost := info.Dst
-		d, err := r.dialer.Dial("tcp", dstHost.String())
-		if err != nil {
-			log.Printf("Error dialing backend %s: %v\n", dstHost.String(), err)
-			return
-		}
-
-		proxyConn(vhostConn, d)
-	} else {
-		// it is not TLS
-		// treat it as an http connection
+	if err != nil {
+		// It is not TLS, so it is served as HTTP. The HTTP server closes the
+		// connection when it is done with it.
+		r.httpConns.serve(vhostConn)
+		return
+	}
 
-		start := time.Now()
-		req, err := http.ReadRequest(bufio.NewReader(vhostConn))
-		httpReadElapsed := time.Since(start)
-		if err != nil {
-			// It is not http neither. So just close the connection.
-			return
-		}
-		host := req.Header.Get("X-Forwarded-Host")
-		if host == "" {
-			host = req.Host
-		}
-		log.Printf("Proxying http connection to %s. Discover took %s. Http read took %s\n", host, discoverElapsed, httpReadElapsed)
-		info, err := r.director(ProtocolHTTP, host)
-		if err != nil {
-			log.Printf("Error directing request: %v\n", err)
-			return
-		}
-		dstHost := info.Dst
-		d, err := r.dialer.Dial("tcp", dstHost.String())
-		if err != nil {
-			log.Printf("Error dialing backend %s: %v\n", dstHost.String(), err)
-			return
-		}
-		defer d.Close()
-		err = req.Write(d)
-		if err != nil {
-			log.Printf("Error requesting backend %s: %v\n", dstHost.String(), err)
-			return
-		}
-		proxyConn(c, d)
+	host := vhostConn.ClientHelloMsg.ServerName
+	if r.terminatesTLS(host) {
+		r.tlsConns.serve(tls.Server(vhostConn, r.tlsConfig))
+		return
 	}
-}
 
-func proxySsh(reqs1, reqs2 <-chan *ssh.Request, channel1, channel2 ssh.Channel) {
-	var closer sync.Once
-	closeFunc := func() {
-		channel1.Close()
-		channel2.Close()
+	// It is a TLS connection, which is passed through untouched
+	defer c.Close()
+	defer vhostConn.Close()
+	log.Printf("Proxying TLS connection to %s. Discover took %s\n", host, discoverElapsed)
+	info, err := r.direct(ProtocolHTTPS, host)
+	if err != nil {
+		log.Printf("Error directing request: %v\n", err)
+		return
 	}
+	if !r.authorizeTLS(c, host, info) {
+		log.Printf("Refused TLS connection from %s to %s\n", c.RemoteAddr(), host)
+		return
+	}
+	sessionId, _ := HostSession(host)
+	release, err := r.conns.acquire(ProtocolHTTPS, sessionId)
+	if err != nil {
+		log.Printf("Refused TLS connection from %s to %s: %v\n", c.RemoteAddr(), host, err)
+		return
+	}
+	defer release()
+	dstHost := info.Dst
+	d, err := r.dialBackend(context.Background(), ProtocolHTTPS, sessionId, dstHost.String())
+	if err != nil {
+		log.Printf("Error dialing backend %s: %v\n", dstHost.String(), err)
+		return
+	}
+	defer d.Close()
 
-	defer closer.Do(closeFunc)
+	r.proxyConn(vhostConn, d)
+}
 
-	closerChan := make(chan bool, 1)
+func proxySsh(reqs1, reqs2 <-chan *ssh.Request, channel1, channel2 ssh.Channel) {
+	defer channel1.Close()
+	defer channel2.Close()
 
 	go func() {
-		io.Copy(channel1, channel2)
-		closerChan <- true
+		io.Copy(channel2, channel1)
+		channel2.CloseWrite()
 	}()
 
+	copied := make(chan bool, 1)
 	go func() {
-		io.Copy(channel2, channel1)
-		closerChan <- true
+		wg := sync.WaitGroup{}
+		wg.Add(2)
+		go func() {
+			io.Copy(channel1, channel2)
+			wg.Done()
+		}()
+		go func() {
+			io.Copy(channel1.Stderr(), channel2.Stderr())
+			wg.Done()
+		}()
+		wg.Wait()
+		channel1.CloseWrite()
+		copied <- true
 	}()
 
-	for {
+	// The remote end is done once all its output was copied and it closed
+	// the channel, which comes after requests such as the exit status
+	remoteClosed, remoteCopied := false, false
+	for !remoteClosed || !remoteCopied {
 		select {
-		case req := <-reqs1:
-			if req == nil {
+		case req, ok := <-reqs1:
+			if !ok {
 				return
 			}
 			b, err := channel2.SendRequest(req.Type, req.WantReply, req.Payload)
@@ -431,23 +601,31 @@ func proxySsh(reqs1, reqs2 <-chan *ssh.Request, channel1, channel2 ssh.Channel)
 				return
 			}
 			req.Reply(b, nil)
-
-		case req := <-reqs2:
-			if req == nil {
-				return
+		case req, ok := <-reqs2:
+			if !ok {
+				remoteClosed = true
+				reqs2 = nil
+				continue
 			}
 			b, err := channel1.SendRequest(req.Type, req.WantReply, req.Payload)
 			if err != nil {
 				return
 			}
 			req.Reply(b, nil)
-		case <-closerChan:
-			return
+		case <-copied:
+			remoteCopied = true
 		}
 	}
 }
 
-func proxyConn(src, dst net.Conn) {
+// proxyConn copies between two connections until one of them is done, or
+// both were idle for the idle timeout
+func (r *proxyRouter) proxyConn(src, dst net.Conn) {
+	if r.idleTimeout > 0 {
+		last := new(int64)
+		src = &idleConn{Conn: src, timeout: r.idleTimeout, last: last}
+		dst = &idleConn{Conn: dst, timeout: r.idleTimeout, last: last}
+	}
 	errc := make(chan error, 2)
 	cp := func(dst net.Conn, src net.Conn) {
 		_, err := io.Copy(dst, src)
@@ -460,11 +638,24 @@ func proxyConn(src, dst net.Conn) {
 }
 
 func NewRouter(director Director, keyPath string) *proxyRouter {
-	sshConfig := &ssh.ServerConfig{
-		PublicKeyCallback: func(c ssh.ConnMetadata, pubKey ssh.PublicKey) (*ssh.Permissions, error) {
-			return nil, nil
+	r := &proxyRouter{
+		director:   director,
+		accessLog:  logAccess,
+		counters:   sessionCounters{sessions: map[string]*SessionStats{}},
+		conns:      newConnTracker(),
+		forwards:   map[string]*portForwarder{},
+		zones:      map[string]Zone{},
+		zoneDomain: DefaultZoneDomain,
+		dialer: &net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: 30 * time.Second,
+			DualStack: true,
 		},
 	}
+
+	sshConfig := &ssh.ServerConfig{
+		PublicKeyCallback: r.sshPublicKey,
+	}
 	privateBytes, err := ioutil.ReadFile(keyPath)
 	if err != nil {
 		log.Fatal("Failed to load private key: ", err)
@@ -476,14 +667,8 @@ func NewRouter(director Director, keyPath string) *proxyRouter {
 	}
 
 	sshConfig.AddHostKey(private)
+	r.sshConfig = sshConfig
+	r.httpProxy = r.newHTTPProxy()
 
-	return &proxyRouter{
-		director:  director,
-		sshConfig: sshConfig,
-		dialer: &net.Dialer{
-			Timeout:   30 * time.Second,
-			KeepAlive: 30 * time.Second,
-			DualStack: true,
-		},
-	}
+	return r
 }
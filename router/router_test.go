@@ -457,6 +457,51 @@ func TestProxy_DNS_UDP(t *testing.T) {
 	assert.Equal(t, []string{"127.0.0.1"}, ips)
 }
 
+func TestProxy_DNS_Filter(t *testing.T) {
+	dir, private, _, _ := generateKeys()
+	defer os.RemoveAll(dir)
+
+	var filteredClient net.IP
+	var filteredName string
+	r := NewRouter(func(protocol Protocol, host string) (*DirectorInfo, error) {
+		if host == "10_0_0_1.foo.bar" {
+			a, _ := net.ResolveTCPAddr("tcp", "10.0.0.1:0")
+			return &DirectorInfo{Dst: a}, nil
+		}
+		return nil, fmt.Errorf("Not recognized")
+	}, private)
+	r.SetDNSFilter(func(client net.IP, name string) bool {
+		filteredClient = client
+		filteredName = name
+		return false
+	})
+	r.Listen(":0", ":0", ":0")
+	defer r.Close()
+
+	// Instances are always resolved
+	ips, err := routerLookup("udp", "10_0_0_1.foo.bar", r)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"10.0.0.1"}, ips)
+	assert.Empty(t, filteredName)
+
+	for _, protocol := range []string{"udp", "tcp"} {
+		c := dns.Client{Net: protocol}
+		m := dns.Msg{}
+		m.SetQuestion("www.example.com.", dns.TypeA)
+		addr := r.ListenDnsUdpAddress()
+		if protocol == "tcp" {
+			addr = r.ListenDnsTcpAddress()
+		}
+		chunks := strings.Split(addr, ":")
+		res, _, err := c.Exchange(&m, fmt.Sprintf("127.0.0.1:%s", chunks[len(chunks)-1]))
+		assert.Nil(t, err)
+		assert.Equal(t, dns.RcodeNameError, res.Rcode)
+		assert.Empty(t, res.Answer)
+		assert.Equal(t, "www.example.com.", filteredName)
+		assert.True(t, filteredClient.IsLoopback())
+	}
+}
+
 func TestProxy_DNS_TCP(t *testing.T) {
 	dir, private, _, _ := generateKeys()
 	defer os.RemoveAll(dir)
@@ -528,6 +573,7 @@ func TestProxy_SSH(t *testing.T) {
 			return nil, fmt.Errorf("Not recognized")
 		}
 	}, private)
+	r.SetSSHAuthenticator(AnySSHKey)
 	r.Listen(":0", ":0", ":0")
 	defer r.Close()
 
@@ -0,0 +1,145 @@
+package router
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	// SignatureHeader carries the signature of requests the router sends to
+	// the platform, so only routers knowing the shared key can ask
+	SignatureHeader = "X-Lessoncraft-Signature"
+	// TimestampHeader carries the Unix time a timed request was signed at
+	TimestampHeader = "X-Lessoncraft-Timestamp"
+	// MaxRequestAge is how long a timed request is accepted after it was signed
+	MaxRequestAge = time.Minute
+	// SSHUserExtension is the permission extension holding the platform user a
+	// key belongs to
+	SSHUserExtension = "lessoncraft-user-id"
+)
+
+// SSHAuthRequest asks the platform whether a public key may log in as an SSH
+// user. The user is the encoded host of the instance, e.g.
+// ip10-0-0-1-aaaabbbb.
+type SSHAuthRequest struct {
+	User string `json:"user"`
+	// PublicKey is in authorized_keys format
+	PublicKey string `json:"public_key"`
+	// Nonce is random, so no two requests have the same signature
+	Nonce string `json:"nonce"`
+}
+
+// SSHAuthResponse is the answer to an accepted SSHAuthRequest
+type SSHAuthResponse struct {
+	UserID string `json:"user_id"`
+}
+
+// SignRequest returns the signature of the body of a request to the platform
+func SignRequest(key, body []byte) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// SignTimedRequest signs the body of a request together with the time it is
+// sent, so a captured request cannot be replayed later. It returns the values of
+// the TimestampHeader and SignatureHeader.
+func SignTimedRequest(key, body []byte, sent time.Time) (string, string) {
+	timestamp := strconv.FormatInt(sent.Unix(), 10)
+	return timestamp, SignRequest(key, append([]byte(timestamp+"\n"), body...))
+}
+
+// VerifyTimedRequest checks a request signed by SignTimedRequest, refusing
+// requests signed more than MaxRequestAge from now
+func VerifyTimedRequest(key, body []byte, timestamp, signature string, now time.Time) error {
+	sec, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return errors.New("invalid request timestamp")
+	}
+	if age := now.Sub(time.Unix(sec, 0)); age > MaxRequestAge || age < -MaxRequestAge {
+		return errors.New("request timestamp is out of range")
+	}
+	expected := SignRequest(key, append([]byte(timestamp+"\n"), body...))
+	if !hmac.Equal([]byte(signature), []byte(expected)) {
+		return errors.New("invalid request signature")
+	}
+	return nil
+}
+
+// SSHAuthenticator decides whether a public key may log in as an SSH user
+type SSHAuthenticator func(user string, key ssh.PublicKey) (*ssh.Permissions, error)
+
+// AnySSHKey accepts every public key, leaving access to the instances to the
+// secrecy of their hostnames. It is how the gateway worked before logins were
+// checked with the platform.
+func AnySSHKey(user string, key ssh.PublicKey) (*ssh.Permissions, error) {
+	return nil, nil
+}
+
+// RemoteSSHAuthenticator checks SSH logins against the platform API at url,
+// signing the checks with key
+func RemoteSSHAuthenticator(url string, key []byte) SSHAuthenticator {
+	client := &http.Client{Timeout: 5 * time.Second}
+	return func(user string, pubKey ssh.PublicKey) (*ssh.Permissions, error) {
+		nonce := make([]byte, 16)
+		if _, err := rand.Read(nonce); err != nil {
+			return nil, err
+		}
+		body, err := json.Marshal(SSHAuthRequest{
+			User:      user,
+			PublicKey: strings.TrimSpace(string(ssh.MarshalAuthorizedKey(pubKey))),
+			Nonce:     hex.EncodeToString(nonce),
+		})
+		if err != nil {
+			return nil, err
+		}
+		req, err := http.NewRequest("POST", url, bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		timestamp, signature := SignTimedRequest(key, body, time.Now())
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set(TimestampHeader, timestamp)
+		req.Header.Set(SignatureHeader, signature)
+
+		resp, err := client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("public key %s refused for %s: status %d", ssh.FingerprintSHA256(pubKey), user, resp.StatusCode)
+		}
+
+		var auth SSHAuthResponse
+		if err := json.NewDecoder(resp.Body).Decode(&auth); err != nil {
+			return nil, err
+		}
+		return &ssh.Permissions{Extensions: map[string]string{SSHUserExtension: auth.UserID}}, nil
+	}
+}
+
+// SetSSHAuthenticator sets which public keys may log in through the SSH
+// gateway. Without it, every login is refused; AnySSHKey accepts any key.
+func (r *proxyRouter) SetSSHAuthenticator(auth SSHAuthenticator) {
+	r.sshAuth = auth
+}
+
+func (r *proxyRouter) sshPublicKey(c ssh.ConnMetadata, pubKey ssh.PublicKey) (*ssh.Permissions, error) {
+	if r.sshAuth == nil {
+		return nil, errors.New("SSH logins are not enabled")
+	}
+	return r.sshAuth(c.User(), pubKey)
+}
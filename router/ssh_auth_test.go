@@ -0,0 +1,199 @@
+package router
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/ssh"
+)
+
+func newTestSigner(t *testing.T) ssh.Signer {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.Nil(t, err)
+	signer, err := ssh.NewSignerFromKey(key)
+	assert.Nil(t, err)
+	return signer
+}
+
+// testEchoSshServer accepts forwarded connections and echoes what it receives
+func testEchoSshServer(t *testing.T) string {
+	config := &ssh.ServerConfig{
+		PasswordCallback: func(conn ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+			return nil, nil
+		},
+	}
+	config.AddHostKey(newTestSigner(t))
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+
+	go func() {
+		defer listener.Close()
+		nConn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		_, chans, reqs, err := ssh.NewServerConn(nConn, config)
+		if err != nil {
+			return
+		}
+		go ssh.DiscardRequests(reqs)
+		for newChannel := range chans {
+			if newChannel.ChannelType() != "direct-tcpip" {
+				newChannel.Reject(ssh.UnknownChannelType, "unknown channel type")
+				continue
+			}
+			channel, reqs, err := newChannel.Accept()
+			if err != nil {
+				continue
+			}
+			go ssh.DiscardRequests(reqs)
+			go func() {
+				io.Copy(channel, channel)
+				channel.Close()
+			}()
+		}
+	}()
+	return listener.Addr().String()
+}
+
+func newSshTestRouter(t *testing.T, upstream string) (*proxyRouter, func()) {
+	dir, private, _, _ := generateKeys()
+	r := NewRouter(func(protocol Protocol, host string) (*DirectorInfo, error) {
+		a, _ := net.ResolveTCPAddr("tcp", upstream)
+		return &DirectorInfo{Dst: a, SSHUser: "root", SSHAuthMethods: []ssh.AuthMethod{ssh.Password("root")}}, nil
+	}, private)
+	r.SetSSHAuthenticator(AnySSHKey)
+	r.Listen(":0", ":0", ":0")
+	return r, func() {
+		r.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func dialSshRouter(r *proxyRouter, user string, signer ssh.Signer) (*ssh.Client, error) {
+	chunks := strings.Split(r.ListenSshAddress(), ":")
+	return ssh.Dial("tcp", fmt.Sprintf("127.0.0.1:%s", chunks[len(chunks)-1]), &ssh.ClientConfig{
+		User: user,
+		Auth: []ssh.AuthMethod{ssh.PublicKeys(signer)},
+		HostKeyCallback: func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+			return nil
+		},
+	})
+}
+
+func TestProxy_SSHAuthenticator(t *testing.T) {
+	r, done := newSshTestRouter(t, testEchoSshServer(t))
+	defer done()
+
+	allowed := newTestSigner(t)
+	var receivedUser string
+	r.SetSSHAuthenticator(func(user string, key ssh.PublicKey) (*ssh.Permissions, error) {
+		if string(key.Marshal()) != string(allowed.PublicKey().Marshal()) {
+			return nil, errors.New("unknown key")
+		}
+		receivedUser = user
+		return &ssh.Permissions{Extensions: map[string]string{SSHUserExtension: "u1"}}, nil
+	})
+
+	_, err := dialSshRouter(r, "ip10-0-0-1-aaaabbbb", newTestSigner(t))
+	assert.NotNil(t, err)
+
+	client, err := dialSshRouter(r, "ip10-0-0-1-aaaabbbb", allowed)
+	assert.Nil(t, err)
+	defer client.Close()
+	assert.Equal(t, "ip10-0-0-1-aaaabbbb", receivedUser)
+}
+
+func TestProxy_SSHPortForwarding(t *testing.T) {
+	r, done := newSshTestRouter(t, testEchoSshServer(t))
+	defer done()
+
+	client, err := dialSshRouter(r, "ip10-0-0-1-aaaabbbb", newTestSigner(t))
+	assert.Nil(t, err)
+	defer client.Close()
+
+	// The first channel of the connection does not have to be a session
+	conn, err := client.Dial("tcp", "127.0.0.1:8080")
+	assert.Nil(t, err)
+	defer conn.Close()
+	_, err = conn.Write([]byte("hello"))
+	assert.Nil(t, err)
+	buf := make([]byte, 5)
+	_, err = io.ReadFull(conn, buf)
+	assert.Nil(t, err)
+	assert.Equal(t, "hello", string(buf))
+
+	// Sessions the instance refuses are closed with the reason on stderr
+	session, err := client.NewSession()
+	assert.Nil(t, err)
+	stderr, err := session.StderrPipe()
+	assert.Nil(t, err)
+	out, _ := io.ReadAll(stderr)
+	assert.Contains(t, string(out), "Remote session setup failed")
+}
+
+func TestRemoteSSHAuthenticator(t *testing.T) {
+	key := []byte("secret")
+	allowed := newTestSigner(t)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if VerifyTimedRequest(key, body, r.Header.Get(TimestampHeader), r.Header.Get(SignatureHeader), time.Now()) != nil {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		var req SSHAuthRequest
+		json.Unmarshal(body, &req)
+		if req.PublicKey != strings.TrimSpace(string(ssh.MarshalAuthorizedKey(allowed.PublicKey()))) {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		json.NewEncoder(w).Encode(SSHAuthResponse{UserID: "u1"})
+	}))
+	defer ts.Close()
+
+	perms, err := RemoteSSHAuthenticator(ts.URL, key)("ip10-0-0-1-aaaabbbb", allowed.PublicKey())
+	assert.Nil(t, err)
+	assert.Equal(t, "u1", perms.Extensions[SSHUserExtension])
+
+	_, err = RemoteSSHAuthenticator(ts.URL, key)("ip10-0-0-1-aaaabbbb", newTestSigner(t).PublicKey())
+	assert.NotNil(t, err)
+	_, err = RemoteSSHAuthenticator(ts.URL, []byte("other"))("ip10-0-0-1-aaaabbbb", allowed.PublicKey())
+	assert.NotNil(t, err)
+}
+
+func TestProxy_SSHRefusedWithoutAuthenticator(t *testing.T) {
+	r, done := newSshTestRouter(t, testEchoSshServer(t))
+	defer done()
+	r.SetSSHAuthenticator(nil)
+
+	_, err := dialSshRouter(r, "ip10-0-0-1-aaaabbbb", newTestSigner(t))
+	assert.NotNil(t, err)
+}
+
+func TestVerifyTimedRequest(t *testing.T) {
+	key := []byte("secret")
+	body := []byte(`{"user": "ip10-0-0-1-aaaabbbb"}`)
+	now := time.Now()
+	timestamp, signature := SignTimedRequest(key, body, now)
+
+	assert.Nil(t, VerifyTimedRequest(key, body, timestamp, signature, now))
+	assert.NotNil(t, VerifyTimedRequest([]byte("other"), body, timestamp, signature, now))
+	assert.NotNil(t, VerifyTimedRequest(key, []byte("{}"), timestamp, signature, now))
+	// Captured requests expire
+	assert.NotNil(t, VerifyTimedRequest(key, body, timestamp, signature, now.Add(2*MaxRequestAge)))
+	// The timestamp is part of the signature
+	assert.NotNil(t, VerifyTimedRequest(key, body, strconv.FormatInt(now.Unix()+1, 10), signature, now))
+}
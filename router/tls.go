@@ -0,0 +1,260 @@
+package router
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+const (
+	// TLSModeWildcard terminates TLS with a wildcard certificate, e.g. for
+	// *.direct.example.com
+	TLSModeWildcard = "wildcard"
+	// TLSModeACME terminates TLS with certificates requested on demand from
+	// an ACME CA such as Let's Encrypt
+	TLSModeACME = "acme"
+	// TLSModeLocal terminates TLS with certificates of a local CA, for
+	// development and tests
+	TLSModeLocal = "local"
+)
+
+// localCertValidity is how long certificates issued by a LocalCA are valid
+const localCertValidity = 90 * 24 * time.Hour
+
+// CertificateSource returns the certificate for the server name of a TLS
+// handshake. autocert.Manager is one.
+type CertificateSource interface {
+	GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error)
+}
+
+type staticCertificate struct {
+	cert *tls.Certificate
+}
+
+func (s *staticCertificate) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return s.cert, nil
+}
+
+// WildcardCertificate serves the certificate of the given files for every
+// host
+func WildcardCertificate(certFile, keyFile string) (CertificateSource, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+	return &staticCertificate{cert: &cert}, nil
+}
+
+// ACMECertificates requests certificates on demand for the hosts of instances
+// under domain, e.g. direct.example.com. Challenges are answered over
+// TLS-ALPN on the port of the router.
+func ACMECertificates(domain, cacheDir, email string) *autocert.Manager {
+	suffix := "." + strings.TrimPrefix(domain, ".")
+	return &autocert.Manager{
+		Prompt: autocert.AcceptTOS,
+		Email:  email,
+		Cache:  autocert.DirCache(cacheDir),
+		HostPolicy: func(ctx context.Context, host string) error {
+			if !strings.HasSuffix(host, suffix) {
+				return fmt.Errorf("Host %s is not under %s", host, domain)
+			}
+			if _, err := HostSession(host); err != nil {
+				return err
+			}
+			return nil
+		},
+	}
+}
+
+// LocalCA issues certificates signed by a CA of its own. Clients must trust
+// its certificate, so it is meant for development and tests.
+type LocalCA struct {
+	cert    *x509.Certificate
+	key     crypto.Signer
+	certPEM []byte
+
+	mu    sync.Mutex
+	certs map[string]*tls.Certificate
+}
+
+// NewLocalCA creates a local CA. When dir is set, the CA is kept in ca.pem
+// and ca-key.pem there, so browsers only have to trust it once.
+func NewLocalCA(dir string) (*LocalCA, error) {
+	if dir != "" {
+		certPEM, err := ioutil.ReadFile(filepath.Join(dir, "ca.pem"))
+		if err == nil {
+			keyPEM, err := ioutil.ReadFile(filepath.Join(dir, "ca-key.pem"))
+			if err != nil {
+				return nil, err
+			}
+			return loadLocalCA(certPEM, keyPEM)
+		} else if !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, err
+	}
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: "LessonCraft Local CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(10 * 365 * 24 * time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		return nil, err
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+
+	if dir != "" {
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			return nil, err
+		}
+		if err := ioutil.WriteFile(filepath.Join(dir, "ca-key.pem"), keyPEM, 0600); err != nil {
+			return nil, err
+		}
+		if err := ioutil.WriteFile(filepath.Join(dir, "ca.pem"), certPEM, 0644); err != nil {
+			return nil, err
+		}
+	}
+	return loadLocalCA(certPEM, keyPEM)
+}
+
+func loadLocalCA(certPEM, keyPEM []byte) (*LocalCA, error) {
+	pair, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		return nil, err
+	}
+	key, ok := pair.PrivateKey.(crypto.Signer)
+	if !ok || !cert.IsCA {
+		return nil, errors.New("not a CA certificate")
+	}
+	return &LocalCA{cert: cert, key: key, certPEM: certPEM, certs: map[string]*tls.Certificate{}}, nil
+}
+
+// CertPEM returns the certificate of the CA, for clients to trust
+func (ca *LocalCA) CertPEM() []byte {
+	return ca.certPEM
+}
+
+// GetCertificate issues a certificate for the server name, reusing it until
+// it is close to expiry
+func (ca *LocalCA) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	name := strings.ToLower(hello.ServerName)
+	if name == "" {
+		return nil, errors.New("missing server name")
+	}
+
+	ca.mu.Lock()
+	defer ca.mu.Unlock()
+
+	if cert, found := ca.certs[name]; found && time.Until(cert.Leaf.NotAfter) > 24*time.Hour {
+		return cert, nil
+	}
+	cert, err := ca.issue(name)
+	if err != nil {
+		return nil, err
+	}
+	ca.certs[name] = cert
+	return cert, nil
+}
+
+func (ca *LocalCA) issue(name string) (*tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, err
+	}
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(localCertValidity),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, key.Public(), ca.key)
+	if err != nil {
+		return nil, err
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Certificate{Certificate: [][]byte{der, ca.cert.Raw}, PrivateKey: key, Leaf: leaf}, nil
+}
+
+// TerminateTLS makes the router terminate TLS for the hosts of instances with
+// certificates of certs, and proxy them to the instances over HTTP.
+// Connections to the passthrough ports are still passed through untouched,
+// for services that terminate TLS themselves such as docker daemons.
+func (r *proxyRouter) TerminateTLS(certs CertificateSource, passthroughPorts ...int) {
+	config := &tls.Config{
+		GetCertificate: certs.GetCertificate,
+		NextProtos:     []string{"http/1.1"},
+		MinVersion:     tls.VersionTLS12,
+	}
+	if _, ok := certs.(*autocert.Manager); ok {
+		config.NextProtos = append(config.NextProtos, acme.ALPNProto)
+	}
+	r.tlsConfig = config
+	r.tlsPassthrough = map[int]bool{}
+	for _, p := range passthroughPorts {
+		r.tlsPassthrough[p] = true
+	}
+}
+
+// terminatesTLS checks if TLS connections to a host are terminated by the
+// router. Hosts of named endpoints are always terminated.
+func (r *proxyRouter) terminatesTLS(host string) bool {
+	if r.tlsConfig == nil {
+		return false
+	}
+	info, err := DecodeHost(host)
+	if err != nil {
+		_, err = DecodeAlias(host)
+		return err == nil
+	}
+	return !r.tlsPassthrough[info.EncodedPort]
+}
@@ -0,0 +1,102 @@
+package router
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLocalCA(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ca")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	ca, err := NewLocalCA(dir)
+	assert.Nil(t, err)
+	// The CA is kept in the directory
+	again, err := NewLocalCA(dir)
+	assert.Nil(t, err)
+	assert.Equal(t, ca.CertPEM(), again.CertPEM())
+
+	const host = "ip10-0-0-1-aaaabbbb-8080.direct.localhost"
+	cert, err := ca.GetCertificate(&tls.ClientHelloInfo{ServerName: host})
+	assert.Nil(t, err)
+	pool := x509.NewCertPool()
+	assert.True(t, pool.AppendCertsFromPEM(again.CertPEM()))
+	_, err = cert.Leaf.Verify(x509.VerifyOptions{DNSName: host, Roots: pool})
+	assert.Nil(t, err)
+
+	cached, err := ca.GetCertificate(&tls.ClientHelloInfo{ServerName: host})
+	assert.Nil(t, err)
+	assert.Equal(t, cert, cached)
+	_, err = ca.GetCertificate(&tls.ClientHelloInfo{})
+	assert.NotNil(t, err)
+}
+
+func TestProxy_TerminateTLS(t *testing.T) {
+	dir, private, _, _ := generateKeys()
+	defer os.RemoveAll(dir)
+
+	plain := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "plain %s", r.Header.Get("X-Forwarded-Proto"))
+	}))
+	defer plain.Close()
+	secure := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "secure")
+	}))
+	defer secure.Close()
+
+	var receivedProtocol Protocol
+	r := NewRouter(func(protocol Protocol, host string) (*DirectorInfo, error) {
+		receivedProtocol = protocol
+		ts := plain
+		if strings.Contains(host, "-2376.") {
+			ts = secure
+		}
+		u, _ := url.Parse(ts.URL)
+		a, _ := net.ResolveTCPAddr("tcp", u.Host)
+		return &DirectorInfo{Dst: a}, nil
+	}, private)
+	ca, err := NewLocalCA("")
+	assert.Nil(t, err)
+	r.TerminateTLS(ca, 2376)
+	r.Listen(":0", ":0", ":0")
+	defer r.Close()
+
+	httpsURL := strings.Replace(getRouterUrl("https", r), "localhost", "127.0.0.1", 1)
+	get := func(host string, config *tls.Config) (string, error) {
+		config.ServerName = host
+		client := &http.Client{Transport: &http.Transport{TLSClientConfig: config}}
+		resp, err := client.Get(httpsURL)
+		if err != nil {
+			return "", err
+		}
+		defer resp.Body.Close()
+		body, err := ioutil.ReadAll(resp.Body)
+		return string(body), err
+	}
+
+	// The router terminates TLS with a certificate of its CA and proxies HTTP
+	pool := x509.NewCertPool()
+	pool.AppendCertsFromPEM(ca.CertPEM())
+	body, err := get("ip10-0-0-1-aaaabbbb-8080.direct.localhost", &tls.Config{RootCAs: pool})
+	assert.Nil(t, err)
+	assert.Equal(t, "plain https", body)
+	assert.Equal(t, ProtocolHTTP, receivedProtocol)
+
+	// Passthrough ports reach the instance's own TLS
+	body, err = get("ip10-0-0-1-aaaabbbb-2376.direct.localhost", &tls.Config{InsecureSkipVerify: true})
+	assert.Nil(t, err)
+	assert.Equal(t, "secure", body)
+	assert.Equal(t, ProtocolHTTPS, receivedProtocol)
+}
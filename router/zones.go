@@ -0,0 +1,300 @@
+package router
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// DefaultZoneDomain is the domain the zones of sessions live under
+const DefaultZoneDomain = "lessoncraft.internal"
+
+// zoneTTL is the TTL of the records of session zones. Instances come and go
+// often, so it is kept short.
+const zoneTTL = 10
+
+// Zone holds the DNS records of the instances of a session. Instances of the
+// session reach each other by hostname or role, e.g. db, and anyone can use
+// the full name, e.g. db.<session id>.lessoncraft.internal.
+type Zone struct {
+	SessionId string       `json:"session_id"`
+	Records   []ZoneRecord `json:"records"`
+}
+
+// ZoneRecord is an instance of a session zone
+type ZoneRecord struct {
+	Hostname string `json:"hostname"`
+	// Role is the purpose of the instance in the session, e.g. db or cache.
+	// Several instances may share a role.
+	Role string `json:"role,omitempty"`
+	IP   string `json:"ip"`
+	// Ports are served as SRV records named after the role, e.g.
+	// _db._tcp
+	Ports []ServicePort `json:"ports,omitempty"`
+	// Metadata is served as TXT records of the hostname
+	Metadata map[string]string `json:"metadata,omitempty"`
+}
+
+// ServicePort is a port an instance declares
+type ServicePort struct {
+	Protocol string `json:"protocol"`
+	Port     int    `json:"port"`
+}
+
+// ParseServicePort parses ports as lessons declare them: 5432, 53/udp, or
+// 8080:80/tcp, which is port 80 of the instance
+func ParseServicePort(s string) (ServicePort, error) {
+	p := ServicePort{Protocol: ForwardTCP}
+	port := strings.TrimSpace(s)
+	if i := strings.LastIndex(port, "/"); i >= 0 {
+		p.Protocol = strings.ToLower(port[i+1:])
+		port = port[:i]
+	}
+	if i := strings.LastIndex(port, ":"); i >= 0 {
+		port = port[i+1:]
+	}
+	n, err := strconv.Atoi(port)
+	if err != nil || n < 1 || n > 65535 || (p.Protocol != ForwardTCP && p.Protocol != ForwardUDP) {
+		return ServicePort{}, fmt.Errorf("Invalid port %q", s)
+	}
+	p.Port = n
+	return p, nil
+}
+
+// SetZone serves the records of a session zone, replacing the previous ones.
+// A zone without records is forgotten.
+func (r *proxyRouter) SetZone(zone Zone) {
+	if len(zone.Records) == 0 {
+		r.ForgetZone(zone.SessionId)
+		return
+	}
+
+	records := make([]ZoneRecord, 0, len(zone.Records))
+	for _, rec := range zone.Records {
+		rec.Hostname = strings.ToLower(rec.Hostname)
+		rec.Role = strings.ToLower(rec.Role)
+		records = append(records, rec)
+	}
+	zone.Records = records
+
+	r.zonesMu.Lock()
+	defer r.zonesMu.Unlock()
+	r.zones[zone.SessionId] = zone
+}
+
+// ForgetZone stops serving the zone of a session once it is gone
+func (r *proxyRouter) ForgetZone(sessionId string) {
+	r.zonesMu.Lock()
+	defer r.zonesMu.Unlock()
+	delete(r.zones, sessionId)
+}
+
+// SetZoneDomain sets the domain the zones of sessions live under
+func (r *proxyRouter) SetZoneDomain(domain string) {
+	r.zonesMu.Lock()
+	defer r.zonesMu.Unlock()
+	r.zoneDomain = strings.ToLower(strings.Trim(domain, "."))
+}
+
+// SetDNSUpstream forwards the queries the router cannot answer to a DNS
+// server, e.g. 8.8.8.8:53. Without it they are looked up with the system
+// resolver.
+func (r *proxyRouter) SetDNSUpstream(addr string) {
+	r.dnsUpstream = addr
+}
+
+// clientZone returns the zone of the session the client is an instance of
+func (r *proxyRouter) clientZone(client net.IP) (Zone, bool) {
+	if client == nil {
+		return Zone{}, false
+	}
+	for _, zone := range r.zones {
+		for _, rec := range zone.Records {
+			if rec.IP == client.String() {
+				return zone, true
+			}
+		}
+	}
+	return Zone{}, false
+}
+
+// zoneAnswer answers a question from the session zones. Short names are
+// looked up in the zone of the client. It returns false when the name is not
+// in a zone.
+func (r *proxyRouter) zoneAnswer(client net.IP, q dns.Question) (answer []dns.RR, extra []dns.RR, found bool) {
+	r.zonesMu.RLock()
+	defer r.zonesMu.RUnlock()
+
+	name := strings.ToLower(strings.TrimSuffix(q.Name, "."))
+	var zone Zone
+	if suffix := "." + r.zoneDomain; r.zoneDomain != "" && strings.HasSuffix(name, suffix) {
+		name = strings.TrimSuffix(name, suffix)
+		i := strings.LastIndex(name, ".")
+		if i < 0 {
+			return nil, nil, false
+		}
+		zone, found = r.zones[name[i+1:]]
+		name = name[:i]
+	} else {
+		zone, found = r.clientZone(client)
+	}
+	if !found {
+		return nil, nil, false
+	}
+
+	if strings.HasPrefix(name, "_") {
+		return r.zoneServices(zone, q, name)
+	}
+
+	found = false
+	seen := map[string]bool{}
+	for _, rec := range zone.Records {
+		if rec.Hostname != name && rec.Role != name {
+			continue
+		}
+		found = true
+		switch q.Qtype {
+		case dns.TypeA:
+			ip := net.ParseIP(rec.IP).To4()
+			if ip == nil || seen[rec.IP] {
+				continue
+			}
+			seen[rec.IP] = true
+			answer = append(answer, &dns.A{Hdr: zoneHeader(q.Name, dns.TypeA), A: ip})
+		case dns.TypeTXT:
+			answer = append(answer, &dns.TXT{Hdr: zoneHeader(q.Name, dns.TypeTXT), Txt: zoneMetadata(rec)})
+		}
+	}
+	return answer, nil, found
+}
+
+// zoneServices answers SRV queries such as _db._tcp with the ports of the
+// instances having that role or hostname
+func (r *proxyRouter) zoneServices(zone Zone, q dns.Question, name string) (answer []dns.RR, extra []dns.RR, found bool) {
+	labels := strings.Split(name, ".")
+	if len(labels) != 2 || !strings.HasPrefix(labels[1], "_") {
+		return nil, nil, false
+	}
+	service := strings.TrimPrefix(labels[0], "_")
+	protocol := strings.TrimPrefix(labels[1], "_")
+
+	for _, rec := range zone.Records {
+		if rec.Role != service && rec.Hostname != service {
+			continue
+		}
+		found = true
+		if q.Qtype != dns.TypeSRV || rec.Hostname == "" {
+			continue
+		}
+		target := dns.Fqdn(fmt.Sprintf("%s.%s.%s", rec.Hostname, zone.SessionId, r.zoneDomain))
+		ports := 0
+		for _, p := range rec.Ports {
+			if p.Protocol != protocol {
+				continue
+			}
+			ports++
+			answer = append(answer, &dns.SRV{Hdr: zoneHeader(q.Name, dns.TypeSRV), Port: uint16(p.Port), Target: target})
+		}
+		if ip := net.ParseIP(rec.IP).To4(); ip != nil && ports > 0 {
+			extra = append(extra, &dns.A{Hdr: zoneHeader(target, dns.TypeA), A: ip})
+		}
+	}
+	return answer, extra, found
+}
+
+func zoneHeader(name string, rrtype uint16) dns.RR_Header {
+	return dns.RR_Header{Name: name, Rrtype: rrtype, Class: dns.ClassINET, Ttl: zoneTTL}
+}
+
+// zoneMetadata returns the TXT strings of a record, as key=value sorted by key
+func zoneMetadata(rec ZoneRecord) []string {
+	metadata := map[string]string{"hostname": rec.Hostname}
+	if rec.Role != "" {
+		metadata["role"] = rec.Role
+	}
+	for k, v := range rec.Metadata {
+		metadata[k] = v
+	}
+	txt := []string{}
+	for k, v := range metadata {
+		txt = append(txt, fmt.Sprintf("%s=%s", k, v))
+	}
+	sort.Strings(txt)
+	return txt
+}
+
+// ZoneUpdate carries the zone of a session from LessonCraft to the router.
+// It is timed so it cannot be replayed later.
+type ZoneUpdate struct {
+	Time time.Time `json:"time"`
+	Zone Zone      `json:"zone"`
+}
+
+// Expired checks if an update is too old to be applied
+func (u ZoneUpdate) Expired() bool {
+	return requestExpired(u.Time)
+}
+
+// PushZone sends the zone of a session to the router at url, signing the
+// request with key
+func PushZone(url string, key []byte, zone Zone) error {
+	body, err := json.Marshal(ZoneUpdate{Time: time.Now(), Zone: zone})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(SignatureHeader, SignRequest(key, body))
+
+	client := &http.Client{Timeout: 5 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		return fmt.Errorf("pushing zone of session %s: status %d", zone.SessionId, resp.StatusCode)
+	}
+	return nil
+}
+
+// ZoneUpdateHandler receives the zones pushed with PushZone, checking they
+// are signed with key
+func ZoneUpdateHandler(key []byte, update func(Zone)) http.HandlerFunc {
+	return func(rw http.ResponseWriter, req *http.Request) {
+		body, err := io.ReadAll(io.LimitReader(req.Body, 1<<20))
+		if err != nil {
+			rw.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		signature := []byte(req.Header.Get(SignatureHeader))
+		if !hmac.Equal(signature, []byte(SignRequest(key, body))) {
+			rw.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		var u ZoneUpdate
+		if err := json.Unmarshal(body, &u); err != nil || u.Expired() {
+			rw.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if u.Zone.SessionId == "" {
+			rw.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		update(u.Zone)
+		rw.WriteHeader(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,156 @@
+package router
+
+import (
+	"fmt"
+	"net"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+)
+
+func zoneQuery(t *testing.T, r *proxyRouter, name string, qtype uint16) *dns.Msg {
+	c := dns.Client{Net: "udp"}
+	m := dns.Msg{}
+	m.SetQuestion(name, qtype)
+	chunks := strings.Split(r.ListenDnsUdpAddress(), ":")
+	res, _, err := c.Exchange(&m, fmt.Sprintf("127.0.0.1:%s", chunks[len(chunks)-1]))
+	assert.Nil(t, err)
+	return res
+}
+
+func TestZones(t *testing.T) {
+	dir, private, _, _ := generateKeys()
+	defer os.RemoveAll(dir)
+
+	r := NewRouter(func(protocol Protocol, host string) (*DirectorInfo, error) {
+		return nil, fmt.Errorf("Not recognized")
+	}, private)
+	r.SetDNSFilter(func(client net.IP, name string) bool {
+		return false
+	})
+	r.Listen(":0", ":0", ":0")
+	defer r.Close()
+
+	r.SetZone(Zone{SessionId: "aaaabbbb", Records: []ZoneRecord{
+		// The test client is the first instance of the session
+		{Hostname: "node1", Role: "primary", IP: "127.0.0.1"},
+		{Hostname: "DB1", Role: "db", IP: "10.0.0.2", Ports: []ServicePort{{Protocol: ForwardTCP, Port: 5432}}, Metadata: map[string]string{"image": "postgres"}},
+		{Hostname: "db2", Role: "db", IP: "10.0.0.3", Ports: []ServicePort{{Protocol: ForwardTCP, Port: 5432}, {Protocol: ForwardUDP, Port: 53}}},
+	}})
+
+	// Short names are looked up in the zone of the client
+	res := zoneQuery(t, r, "db1.", dns.TypeA)
+	assert.Equal(t, dns.RcodeSuccess, res.Rcode)
+	assert.Len(t, res.Answer, 1)
+	assert.Equal(t, "10.0.0.2", res.Answer[0].(*dns.A).A.String())
+
+	// Roles lead to every instance having them
+	res = zoneQuery(t, r, "db.", dns.TypeA)
+	ips := []string{}
+	for _, a := range res.Answer {
+		ips = append(ips, a.(*dns.A).A.String())
+	}
+	assert.ElementsMatch(t, []string{"10.0.0.2", "10.0.0.3"}, ips)
+
+	res = zoneQuery(t, r, "db.aaaabbbb.lessoncraft.internal.", dns.TypeA)
+	assert.Len(t, res.Answer, 2)
+
+	res = zoneQuery(t, r, "_db._tcp.", dns.TypeSRV)
+	assert.Len(t, res.Answer, 2)
+	assert.Len(t, res.Extra, 2)
+	for _, rr := range res.Answer {
+		srv := rr.(*dns.SRV)
+		assert.Equal(t, uint16(5432), srv.Port)
+		assert.Contains(t, []string{"db1.aaaabbbb.lessoncraft.internal.", "db2.aaaabbbb.lessoncraft.internal."}, srv.Target)
+	}
+	res = zoneQuery(t, r, "_db._udp.aaaabbbb.lessoncraft.internal.", dns.TypeSRV)
+	assert.Len(t, res.Answer, 1)
+	assert.Equal(t, "db2.aaaabbbb.lessoncraft.internal.", res.Answer[0].(*dns.SRV).Target)
+
+	res = zoneQuery(t, r, "db1.", dns.TypeTXT)
+	assert.Len(t, res.Answer, 1)
+	assert.Equal(t, []string{"hostname=db1", "image=postgres", "role=db"}, res.Answer[0].(*dns.TXT).Txt)
+
+	// Names of the zone without records of the type have no answer
+	res = zoneQuery(t, r, "db1.", dns.TypeAAAA)
+	assert.Equal(t, dns.RcodeSuccess, res.Rcode)
+	assert.Empty(t, res.Answer)
+
+	// Other names are not in the zone
+	res = zoneQuery(t, r, "cache.", dns.TypeA)
+	assert.Equal(t, dns.RcodeNameError, res.Rcode)
+	res = zoneQuery(t, r, "db.ccccdddd.lessoncraft.internal.", dns.TypeA)
+	assert.Equal(t, dns.RcodeNameError, res.Rcode)
+
+	r.ForgetZone("aaaabbbb")
+	res = zoneQuery(t, r, "db.", dns.TypeA)
+	assert.Equal(t, dns.RcodeNameError, res.Rcode)
+}
+
+func TestZones_Upstream(t *testing.T) {
+	dir, private, _, _ := generateKeys()
+	defer os.RemoveAll(dir)
+
+	upstream, err := net.ListenPacket("udp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	server := &dns.Server{PacketConn: upstream, Handler: dns.HandlerFunc(func(w dns.ResponseWriter, req *dns.Msg) {
+		m := new(dns.Msg)
+		m.SetReply(req)
+		mx, _ := dns.NewRR(fmt.Sprintf("%s 60 IN MX 10 mail.example.com.", req.Question[0].Name))
+		m.Answer = append(m.Answer, mx)
+		w.WriteMsg(m)
+	})}
+	go server.ActivateAndServe()
+	defer server.Shutdown()
+
+	r := NewRouter(func(protocol Protocol, host string) (*DirectorInfo, error) {
+		return nil, fmt.Errorf("Not recognized")
+	}, private)
+	r.SetDNSUpstream(upstream.LocalAddr().String())
+	r.Listen(":0", ":0", ":0")
+	defer r.Close()
+
+	res := zoneQuery(t, r, "example.com.", dns.TypeMX)
+	assert.Equal(t, dns.RcodeSuccess, res.Rcode)
+	assert.Len(t, res.Answer, 1)
+	assert.Equal(t, "mail.example.com.", res.Answer[0].(*dns.MX).Mx)
+}
+
+func TestPushZone(t *testing.T) {
+	key := []byte("secret")
+	var received Zone
+	ts := httptest.NewServer(ZoneUpdateHandler(key, func(zone Zone) {
+		received = zone
+	}))
+	defer ts.Close()
+
+	zone := Zone{SessionId: "aaaabbbb", Records: []ZoneRecord{{Hostname: "node1", IP: "10.0.0.1"}}}
+	assert.Nil(t, PushZone(ts.URL, key, zone))
+	assert.Equal(t, zone, received)
+
+	assert.NotNil(t, PushZone(ts.URL, []byte("wrong"), Zone{SessionId: "ccccdddd"}))
+	assert.Equal(t, "aaaabbbb", received.SessionId)
+
+	assert.True(t, ZoneUpdate{Time: time.Now().Add(-time.Hour)}.Expired())
+}
+
+func TestParseServicePort(t *testing.T) {
+	for s, expected := range map[string]ServicePort{
+		"5432":        {Protocol: ForwardTCP, Port: 5432},
+		"53/udp":      {Protocol: ForwardUDP, Port: 53},
+		"8080:80/tcp": {Protocol: ForwardTCP, Port: 80},
+	} {
+		p, err := ParseServicePort(s)
+		assert.Nil(t, err, s)
+		assert.Equal(t, expected, p, s)
+	}
+	for _, s := range []string{"", "http", "0", "70000", "80/sctp"} {
+		_, err := ParseServicePort(s)
+		assert.NotNil(t, err, s)
+	}
+}
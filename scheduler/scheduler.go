@@ -9,6 +9,7 @@ import (
 	"time"
 
 	"github.com/ringo380/lessoncraft/event"
+	"github.com/ringo380/lessoncraft/provisioner"
 	"github.com/ringo380/lessoncraft/pwd"
 	"github.com/ringo380/lessoncraft/pwd/types"
 	"github.com/ringo380/lessoncraft/storage"
@@ -24,9 +25,16 @@ type SchedulerApi interface {
 	Stop()
 }
 
+// SessionSnapshotter saves the instances of a session before it is closed
+type SessionSnapshotter interface {
+	SnapshotSession(session *types.Session) error
+}
+
 type scheduledSession struct {
 	session *types.Session
 	cancel  context.CancelFunc
+	// idleWarned is set once SESSION_IDLE was emitted, until the session is used again
+	idleWarned bool
 }
 
 type scheduledInstance struct {
@@ -44,12 +52,16 @@ type scheduler struct {
 	playgrounds        map[string]*types.Playground
 	playgroundTasks    map[string][]Task
 	started            bool
+	startedAt          time.Time
 	ticker             *time.Ticker
+	idleTicker         *time.Ticker
 
 	storage storage.StorageApi
 	event   event.EventApi
 	pwd     pwd.PWDApi
 	mx      sync.Mutex
+
+	snapshotter SessionSnapshotter
 }
 
 func NewScheduler(tasks []Task, s storage.StorageApi, e event.EventApi, p pwd.PWDApi) (*scheduler, error) {
@@ -70,6 +82,12 @@ func NewScheduler(tasks []Task, s storage.StorageApi, e event.EventApi, p pwd.PW
 	return sch, nil
 }
 
+// SetSnapshotter makes expired sessions snapshot their instances before they
+// are closed, so learners can restore their work later
+func (s *scheduler) SetSnapshotter(snapshotter SessionSnapshotter) {
+	s.snapshotter = snapshotter
+}
+
 func (s *scheduler) updatePlaygrounds() {
 	s.mx.Lock()
 	defer s.mx.Unlock()
@@ -97,6 +115,115 @@ func (s *scheduler) schedulePlaygroundsUpdate() {
 	}()
 }
 
+// idleCheckInterval is how often sessions are checked for inactivity
+const idleCheckInterval = time.Minute
+
+func (s *scheduler) scheduleIdleChecks() {
+	s.idleTicker = time.NewTicker(idleCheckInterval)
+	go func() {
+		for now := range s.idleTicker.C {
+			s.checkIdleSessions(now)
+		}
+	}()
+}
+
+func (s *scheduler) idlePolicy(playgroundId string) types.IdlePolicy {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	if playground, found := s.playgrounds[playgroundId]; found {
+		return playground.IdlePolicy
+	}
+	return types.IdlePolicy{}
+}
+
+func (s *scheduler) checkIdleSessions(now time.Time) {
+	s.mx.Lock()
+	sessions := make([]*scheduledSession, 0, len(s.scheduledSessions))
+	for _, ss := range s.scheduledSessions {
+		sessions = append(sessions, ss)
+	}
+	s.mx.Unlock()
+
+	for _, ss := range sessions {
+		s.checkIdleSession(ss, now)
+	}
+}
+
+// checkIdleSession warns a session that is about to be reclaimed for being
+// idle, and hibernates or closes it once its idle timeout is reached
+func (s *scheduler) checkIdleSession(ss *scheduledSession, now time.Time) {
+	policy := s.idlePolicy(ss.session.PlaygroundId)
+	if policy.Timeout <= 0 {
+		return
+	}
+	// The session may have been paused or resumed since it was scheduled
+	session, err := s.storage.SessionGet(ss.session.Id)
+	if err != nil {
+		log.Printf("Session [%s] was not found in storage. Got %s\n", ss.session.Id, err)
+		return
+	}
+	if session.Paused() {
+		return
+	}
+
+	// Activity is not kept across restarts, so sessions are not reclaimed
+	// before they had a chance to be used
+	last := s.pwd.SessionLastActivity(session.Id)
+	for _, t := range []time.Time{session.CreatedAt, s.startedAt} {
+		if t.After(last) {
+			last = t
+		}
+	}
+	if !policy.IgnoreClients {
+		if clients, err := s.storage.ClientFindBySessionId(session.Id); err == nil && len(clients) > 0 {
+			last = now
+		}
+	}
+
+	idle := now.Sub(last)
+	switch {
+	case idle >= policy.Timeout:
+		s.reclaimIdleSession(session, policy.Action, idle)
+	case policy.Warning > 0 && idle >= policy.Timeout-policy.Warning:
+		if !ss.idleWarned {
+			ss.idleWarned = true
+			action := policy.Action
+			if action == "" {
+				action = types.IdleActionHibernate
+			}
+			s.event.Emit(event.SESSION_IDLE, session.Id, string(action), last.Add(policy.Timeout))
+		}
+	default:
+		ss.idleWarned = false
+	}
+}
+
+func (s *scheduler) reclaimIdleSession(session *types.Session, action types.IdleAction, idle time.Duration) {
+	if action != types.IdleActionClose {
+		log.Printf("Session %s has been idle for %s, hibernating it\n", session.Id, idle)
+		err := s.pwd.SessionHibernate(session)
+		if err == nil {
+			return
+		}
+		if !provisioner.PauseNotSupported(err) {
+			log.Printf("Could not hibernate idle session %s. Got: %v\n", session.Id, err)
+			return
+		}
+		// Runtimes that cannot pause instances can only free them
+	}
+
+	log.Printf("Session %s has been idle for %s, closing it\n", session.Id, idle)
+	if s.snapshotter != nil {
+		if err := s.snapshotter.SnapshotSession(session); err != nil {
+			log.Printf("Could not snapshot idle session %s. Got: %v\n", session.Id, err)
+		}
+	}
+	if err := s.pwd.SessionClose(session); err != nil {
+		log.Printf("Could not close idle session %s. Got: %v\n", session.Id, err)
+	}
+}
+
 func (s *scheduler) getMatchedTasks(playground *types.Playground) []Task {
 	matchedTasks := []Task{}
 	for _, expr := range playground.Tasks {
@@ -125,19 +252,60 @@ func (s *scheduler) getTasks(playgroundId string) []Task {
 	return s.playgroundTasks[playgroundId]
 }
 
+// expiryWarning returns how long before its sessions expire a playground
+// warns them
+func (s *scheduler) expiryWarning(playgroundId string) time.Duration {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	if playground, found := s.playgrounds[playgroundId]; found {
+		return playground.SessionExpiryWarning
+	}
+	return 0
+}
+
 func (s *scheduler) processSession(ctx context.Context, ss *scheduledSession) {
-	defer s.unscheduleSession(ss.session)
-	select {
-	case <-time.After(time.Until(ss.session.ExpiresAt)):
-		// Session has expired. Need to close the session.
-		s.pwd.SessionClose(ss.session)
-		return
-	case <-ctx.Done():
-		return
+	defer func() {
+		s.mx.Lock()
+		defer s.mx.Unlock()
+		// Rescheduled sessions are already tracked by their new timer
+		if s.scheduledSessions[ss.session.Id] == ss {
+			s.unscheduleSession(ss.session)
+		}
+	}()
+
+	var warning <-chan time.Time
+	if w := s.expiryWarning(ss.session.PlaygroundId); w > 0 {
+		// Sessions scheduled within their warning are warned right away
+		warning = time.After(time.Until(ss.session.ExpiresAt.Add(-w)))
+	}
+	expiry := time.After(time.Until(ss.session.ExpiresAt))
+	for {
+		select {
+		case <-warning:
+			log.Printf("Session %s expires at %s\n", ss.session.Id, ss.session.ExpiresAt)
+			s.event.Emit(event.SESSION_EXPIRING, ss.session.Id, ss.session.ExpiresAt)
+			warning = nil
+		case <-expiry:
+			// Session has expired. Need to close the session.
+			if s.snapshotter != nil {
+				if err := s.snapshotter.SnapshotSession(ss.session); err != nil {
+					log.Printf("Could not snapshot expired session %s. Got: %v\n", ss.session.Id, err)
+				}
+			}
+			s.pwd.SessionClose(ss.session)
+			return
+		case <-ctx.Done():
+			return
+		}
 	}
 }
 func (s *scheduler) processInstance(ctx context.Context, si *scheduledInstance) {
-	defer s.unscheduleInstance(si.instance)
+	defer func() {
+		s.mx.Lock()
+		defer s.mx.Unlock()
+		s.unscheduleInstance(si.instance)
+	}()
 	for {
 		select {
 		case <-ctx.Done():
@@ -177,6 +345,8 @@ func (s *scheduler) addTask(task Task) error {
 	return nil
 }
 
+// unscheduleSession, scheduleSession and their instance counterparts are
+// called with s.mx held
 func (s *scheduler) unscheduleSession(session *types.Session) {
 	ss, found := s.scheduledSessions[session.Id]
 	if !found {
@@ -199,6 +369,20 @@ func (s *scheduler) scheduleSession(session *types.Session) {
 	go s.processSession(ctx, ss)
 	log.Printf("Scheduled session %s\n", session.Id)
 }
+
+// rescheduleSession restarts the timers of a session whose expiry changed
+func (s *scheduler) rescheduleSession(sessionId string) {
+	session, err := s.storage.SessionGet(sessionId)
+	if err != nil {
+		log.Printf("Session [%s] was not found in storage. Got %s\n", sessionId, err)
+		return
+	}
+
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	s.unscheduleSession(session)
+	s.scheduleSession(session)
+}
 func (s *scheduler) unscheduleInstance(instance *types.Instance) {
 	si, found := s.scheduledInstances[instance.Name]
 	if !found {
@@ -225,6 +409,10 @@ func (s *scheduler) scheduleInstance(instance *types.Instance, playgroundId stri
 
 func (s *scheduler) Stop() {
 	s.ticker.Stop()
+	s.idleTicker.Stop()
+
+	s.mx.Lock()
+	defer s.mx.Unlock()
 	for _, ss := range s.scheduledSessions {
 		s.unscheduleSession(ss.session)
 	}
@@ -234,11 +422,12 @@ func (s *scheduler) Stop() {
 	s.started = false
 }
 
-func (s *scheduler) Start() error {
-	sessions, err := s.storage.SessionGetAll()
-	if err != nil {
-		return err
-	}
+// scheduleSessions schedules sessions and their instances, loading the
+// configuration of their playgrounds
+func (s *scheduler) scheduleSessions(sessions []*types.Session) error {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
 	for _, session := range sessions {
 		s.scheduleSession(session)
 		if _, found := s.playgrounds[session.PlaygroundId]; !found {
@@ -253,15 +442,28 @@ func (s *scheduler) Start() error {
 		if err != nil {
 			return err
 		}
-
 		for _, instance := range instances {
 			s.scheduleInstance(instance, session.PlaygroundId)
 		}
 	}
+	return nil
+}
+
+func (s *scheduler) Start() error {
+	sessions, err := s.storage.SessionGetAll()
+	if err != nil {
+		return err
+	}
+	if err := s.scheduleSessions(sessions); err != nil {
+		return err
+	}
 
 	// Refresh playground conf every 5 minutes
 	s.schedulePlaygroundsUpdate()
 
+	s.startedAt = time.Now()
+	s.scheduleIdleChecks()
+
 	s.event.On(event.SESSION_NEW, func(sessionId string, args ...interface{}) {
 		s.mx.Lock()
 		defer s.mx.Unlock()
@@ -282,9 +484,15 @@ func (s *scheduler) Start() error {
 		}
 		s.scheduleSession(session)
 	})
+	s.event.On(event.SESSION_EXTENDED, func(sessionId string, args ...interface{}) {
+		log.Printf("EVENT: Session Extended %s\n", sessionId)
+		s.rescheduleSession(sessionId)
+	})
 	s.event.On(event.SESSION_END, func(sessionId string, args ...interface{}) {
 		log.Printf("EVENT: Session End %s\n", sessionId)
 		session := &types.Session{Id: sessionId}
+		s.mx.Lock()
+		defer s.mx.Unlock()
 		s.unscheduleSession(session)
 	})
 	s.event.On(event.INSTANCE_NEW, func(sessionId string, args ...interface{}) {
@@ -300,12 +508,16 @@ func (s *scheduler) Start() error {
 			log.Printf("Session [%s] was not found in storage. Got %s\n", instance.SessionId, err)
 			return
 		}
+		s.mx.Lock()
+		defer s.mx.Unlock()
 		s.scheduleInstance(instance, session.PlaygroundId)
 	})
 	s.event.On(event.INSTANCE_DELETE, func(sessionId string, args ...interface{}) {
 		instanceName := args[0].(string)
 		log.Printf("EVENT: Instance Delete %s\n", instanceName)
 		instance := &types.Instance{Name: instanceName}
+		s.mx.Lock()
+		defer s.mx.Unlock()
 		s.unscheduleInstance(instance)
 	})
 	s.event.On(event.PLAYGROUND_NEW, func(playgroundId string, args ...interface{}) {
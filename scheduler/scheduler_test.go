@@ -2,13 +2,19 @@ package scheduler
 
 import (
 	"context"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/ringo380/lessoncraft/docker"
 	"github.com/ringo380/lessoncraft/event"
+	"github.com/ringo380/lessoncraft/id"
+	"github.com/ringo380/lessoncraft/provisioner"
 	"github.com/ringo380/lessoncraft/pwd"
 	"github.com/ringo380/lessoncraft/pwd/types"
 	"github.com/ringo380/lessoncraft/storage"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
 )
 
 type fakeTask struct {
@@ -56,3 +62,202 @@ func TestScheduler_getMatchedTasks(t *testing.T) {
 	assert.Subset(t, []Task{fakeTask{name: "docker_task1"}}, matched)
 	assert.Len(t, matched, 1)
 }
+
+func TestScheduler_processSession(t *testing.T) {
+	_s := &storage.Mock{}
+	_e := &event.Mock{}
+	_p := &pwd.Mock{}
+
+	s, err := NewScheduler(nil, _s, _e, _p)
+	assert.Nil(t, err)
+	s.playgrounds["pg"] = &types.Playground{Id: "pg", SessionExpiryWarning: time.Hour}
+
+	session := &types.Session{Id: "s1", PlaygroundId: "pg", ExpiresAt: time.Now().Add(50 * time.Millisecond)}
+	closed := make(chan struct{})
+	_e.M.On("Emit", event.SESSION_EXPIRING, "s1", []interface{}{session.ExpiresAt}).Return()
+	_p.On("SessionClose", session).Return(nil).Run(func(args mock.Arguments) { close(closed) })
+
+	s.mx.Lock()
+	s.scheduleSession(session)
+	s.mx.Unlock()
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Fatal("session was not closed when it expired")
+	}
+	_e.M.AssertExpectations(t)
+}
+
+func TestScheduler_rescheduleSession(t *testing.T) {
+	_s := &storage.Mock{}
+	_e := &event.Mock{}
+	_p := &pwd.Mock{}
+
+	s, err := NewScheduler(nil, _s, _e, _p)
+	assert.Nil(t, err)
+
+	session := &types.Session{Id: "s1", ExpiresAt: time.Now().Add(50 * time.Millisecond)}
+	extended := &types.Session{Id: "s1", ExpiresAt: time.Now().Add(time.Hour)}
+	_s.On("SessionGet", "s1").Return(extended, nil)
+
+	s.mx.Lock()
+	s.scheduleSession(session)
+	s.mx.Unlock()
+	s.rescheduleSession("s1")
+	time.Sleep(100 * time.Millisecond)
+
+	// The session is not closed at its former expiry
+	_p.AssertNotCalled(t, "SessionClose", session)
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	assert.Equal(t, extended, s.scheduledSessions["s1"].session)
+	s.unscheduleSession(extended)
+}
+
+func TestScheduler_rescheduleSession_whileProcessing(t *testing.T) {
+	_s := &storage.Mock{}
+	_e := &event.Mock{}
+	_p := &pwd.Mock{}
+
+	s, err := NewScheduler(nil, _s, _e, _p)
+	assert.Nil(t, err)
+
+	// The session expires while it is being extended, so processSession
+	// cleans up concurrently with rescheduleSession
+	session := &types.Session{Id: "s1", ExpiresAt: time.Now().Add(5 * time.Millisecond)}
+	extended := &types.Session{Id: "s1", ExpiresAt: time.Now().Add(time.Hour)}
+	_s.On("SessionGet", "s1").Return(extended, nil)
+	_p.On("SessionClose", session).Return(nil).Maybe()
+
+	s.mx.Lock()
+	s.scheduleSession(session)
+	s.mx.Unlock()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			time.Sleep(time.Duration(i) * time.Millisecond)
+			s.rescheduleSession("s1")
+		}()
+	}
+	wg.Wait()
+	time.Sleep(20 * time.Millisecond)
+
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	assert.Equal(t, extended, s.scheduledSessions["s1"].session)
+	s.unscheduleSession(extended)
+}
+
+func TestScheduler_checkIdleSessions(t *testing.T) {
+	_s := &storage.Mock{}
+	_e := &event.Mock{}
+	_p := &pwd.Mock{}
+
+	s, err := NewScheduler(nil, _s, _e, _p)
+	assert.Nil(t, err)
+	s.playgrounds["pg"] = &types.Playground{Id: "pg", IdlePolicy: types.IdlePolicy{Timeout: 30 * time.Minute, Warning: 5 * time.Minute}}
+
+	now := time.Now()
+	session := &types.Session{Id: "s1", PlaygroundId: "pg", CreatedAt: now.Add(-time.Hour)}
+	s.scheduledSessions["s1"] = &scheduledSession{session: session}
+	_s.On("SessionGet", "s1").Return(session, nil)
+	_s.On("ClientFindBySessionId", "s1").Return([]*types.Client{}, nil)
+
+	// Warned once when the session is about to be reclaimed
+	lastActivity := now.Add(-27 * time.Minute)
+	_p.On("SessionLastActivity", "s1").Return(lastActivity).Twice()
+	_e.M.On("Emit", event.SESSION_IDLE, "s1", []interface{}{"hibernate", lastActivity.Add(30 * time.Minute)}).Return().Once()
+	s.checkIdleSessions(now)
+	s.checkIdleSessions(now)
+	assert.True(t, s.scheduledSessions["s1"].idleWarned)
+
+	// Hibernated once the timeout is reached
+	_p.On("SessionLastActivity", "s1").Return(now.Add(-31 * time.Minute)).Once()
+	_p.On("SessionHibernate", session).Return(nil).Once()
+	s.checkIdleSessions(now)
+
+	_e.M.AssertExpectations(t)
+	_p.AssertExpectations(t)
+	_p.AssertNotCalled(t, "SessionClose", session)
+}
+
+func TestScheduler_checkIdleSessions_connectedClients(t *testing.T) {
+	_s := &storage.Mock{}
+	_e := &event.Mock{}
+	_p := &pwd.Mock{}
+
+	s, err := NewScheduler(nil, _s, _e, _p)
+	assert.Nil(t, err)
+	s.playgrounds["pg"] = &types.Playground{Id: "pg", IdlePolicy: types.IdlePolicy{Timeout: 30 * time.Minute, Action: types.IdleActionClose}}
+
+	now := time.Now()
+	session := &types.Session{Id: "s1", PlaygroundId: "pg", CreatedAt: now.Add(-time.Hour)}
+	s.scheduledSessions["s1"] = &scheduledSession{session: session}
+	_s.On("SessionGet", "s1").Return(session, nil)
+	_p.On("SessionLastActivity", "s1").Return(now.Add(-45 * time.Minute))
+
+	// An open terminal keeps the session alive
+	_s.On("ClientFindBySessionId", "s1").Return([]*types.Client{{Id: "c1", SessionId: "s1"}}, nil).Once()
+	s.checkIdleSessions(now)
+	_p.AssertNotCalled(t, "SessionClose", session)
+
+	_s.On("ClientFindBySessionId", "s1").Return([]*types.Client{}, nil).Once()
+	_p.On("SessionClose", session).Return(nil).Once()
+	s.checkIdleSessions(now)
+	_p.AssertExpectations(t)
+}
+
+func TestScheduler_hibernateAndWake(t *testing.T) {
+	_d := &docker.Mock{}
+	_f := &docker.FactoryMock{}
+	_s := &storage.Mock{}
+	_e := &event.Mock{}
+	_g := &id.MockGenerator{}
+
+	ipf := provisioner.NewInstanceProvisionerFactory(provisioner.NewWindowsASG(_f, _s), provisioner.NewDinD(_g, _f, _s))
+	p := pwd.NewPWD(_f, _e, _s, nil, ipf)
+	s, err := NewScheduler(nil, _s, _e, p)
+	assert.Nil(t, err)
+	s.playgrounds["pg"] = &types.Playground{Id: "pg", IdlePolicy: types.IdlePolicy{Timeout: 30 * time.Minute}}
+
+	now := time.Now()
+	session := &types.Session{Id: "s1", PlaygroundId: "pg", CreatedAt: now.Add(-time.Hour)}
+	instance := &types.Instance{Name: "s1_node1", SessionId: "s1"}
+	s.scheduledSessions["s1"] = &scheduledSession{session: session}
+	_f.On("GetForSession", session).Return(_d, nil)
+	_s.On("SessionGet", "s1").Return(session, nil)
+	_s.On("SessionPut", session).Return(nil)
+	_s.On("ClientFindBySessionId", "s1").Return([]*types.Client{}, nil)
+	_s.On("InstanceFindBySessionId", "s1").Return([]*types.Instance{instance}, nil)
+
+	// Idle sessions are hibernated by stopping their containers
+	var nilArgs []interface{}
+	_d.On("ContainerStop", "s1_node1").Return(nil).Once()
+	_e.M.On("Emit", event.SESSION_PAUSED, "s1", nilArgs).Return().Once()
+	s.checkIdleSessions(now)
+	assert.True(t, session.Paused())
+	assert.True(t, session.Hibernated)
+
+	// Hibernated sessions are left alone
+	s.checkIdleSessions(now.Add(time.Minute))
+
+	// Waking the session up starts the same containers, and counts as activity
+	_d.On("ContainerStart", "s1_node1").Return(nil).Once()
+	_d.On("ContainerIPs", "s1_node1").Return(map[string]string{"s1": "10.0.0.2"}, nil).Once()
+	_s.On("InstancePut", instance).Return(nil).Once()
+	_e.M.On("Emit", event.SESSION_RESUMED, "s1", nilArgs).Return().Once()
+	assert.Nil(t, p.SessionResume(session))
+	assert.False(t, session.Paused())
+	assert.False(t, session.Hibernated)
+	assert.Equal(t, "10.0.0.2", instance.IP)
+	s.checkIdleSessions(time.Now())
+
+	_d.AssertExpectations(t)
+	_e.M.AssertExpectations(t)
+	_s.AssertExpectations(t)
+	_d.AssertNotCalled(t, "ContainerDelete", mock.Anything)
+	_d.AssertNotCalled(t, "ContainerPause", mock.Anything)
+}
@@ -4,8 +4,8 @@ import (
 	"context"
 	"testing"
 
-	dockerTypes "github.com/docker/docker/api/types"
 	"github.com/docker/docker/api/types/swarm"
+	"github.com/docker/docker/api/types/system"
 	"github.com/ringo380/lessoncraft/docker"
 	"github.com/ringo380/lessoncraft/event"
 	"github.com/ringo380/lessoncraft/pwd/types"
@@ -34,7 +34,7 @@ func TestCheckSwarmPorts_RunWhenManager(t *testing.T) {
 		SessionId: "aaaabbbbcccc",
 		Hostname:  "node1",
 	}
-	info := dockerTypes.Info{
+	info := system.Info{
 		Swarm: swarm.Info{
 			LocalNodeState:   swarm.LocalNodeStateActive,
 			ControlAvailable: true,
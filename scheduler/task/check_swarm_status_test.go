@@ -4,8 +4,8 @@ import (
 	"context"
 	"testing"
 
-	dockerTypes "github.com/docker/docker/api/types"
 	"github.com/docker/docker/api/types/swarm"
+	"github.com/docker/docker/api/types/system"
 	"github.com/ringo380/lessoncraft/docker"
 	"github.com/ringo380/lessoncraft/event"
 	"github.com/ringo380/lessoncraft/pwd/types"
@@ -33,7 +33,7 @@ func TestCheckSwarmStatus_RunWhenInactive(t *testing.T) {
 		Name:      "node1",
 		SessionId: "aaabbbccc",
 	}
-	infoInactive := dockerTypes.Info{
+	infoInactive := system.Info{
 		Swarm: swarm.Info{
 			LocalNodeState: swarm.LocalNodeStateInactive,
 		},
@@ -64,7 +64,7 @@ func TestCheckSwarmStatus_RunWhenLocked(t *testing.T) {
 		Name:      "node1",
 		SessionId: "aaabbbccc",
 	}
-	infoLocked := dockerTypes.Info{
+	infoLocked := system.Info{
 		Swarm: swarm.Info{
 			LocalNodeState: swarm.LocalNodeStateLocked,
 		},
@@ -95,7 +95,7 @@ func TestCheckSwarmStatus_RunWhenManager(t *testing.T) {
 		Name:      "node1",
 		SessionId: "aaabbbccc",
 	}
-	infoLocked := dockerTypes.Info{
+	infoLocked := system.Info{
 		Swarm: swarm.Info{
 			LocalNodeState:   swarm.LocalNodeStateActive,
 			ControlAvailable: true,
@@ -127,7 +127,7 @@ func TestCheckSwarmStatus_RunWhenWorker(t *testing.T) {
 		Name:      "node1",
 		SessionId: "aaabbbccc",
 	}
-	infoLocked := dockerTypes.Info{
+	infoLocked := system.Info{
 		Swarm: swarm.Info{
 			LocalNodeState:   swarm.LocalNodeStateActive,
 			ControlAvailable: false,
@@ -6,6 +6,7 @@ import (
 	"os"
 	"sync"
 
+	"github.com/ringo380/lessoncraft/lesson"
 	"github.com/ringo380/lessoncraft/pwd/types"
 )
 
@@ -23,6 +24,7 @@ type DB struct {
 	LoginRequests    map[string]*types.LoginRequest    `json:"login_requests"`
 	Users            map[string]*types.User            `json:"user"`
 	Playgrounds      map[string]*types.Playground      `json:"playgrounds"`
+	Lessons          map[string]*lesson.Lesson         `json:"lessons"`
 
 	WindowsInstancesBySessionId map[string][]string `json:"windows_instances_by_session_id"`
 	InstancesBySessionId        map[string][]string `json:"instances_by_session_id"`
@@ -428,6 +430,18 @@ func (store *storage) PlaygroundGetAll() ([]*types.Playground, error) {
 	return playgrounds, nil
 }
 
+func (store *storage) LessonGet(id string) (*lesson.Lesson, error) {
+	store.rw.Lock()
+	defer store.rw.Unlock()
+
+	l, found := store.db.Lessons[id]
+	if !found {
+		return nil, NotFoundError
+	}
+
+	return l, nil
+}
+
 func (store *storage) save() error {
 	file, err := os.Create(store.path)
 	if err != nil {
@@ -1,6 +1,7 @@
 package storage
 
 import (
+	"github.com/ringo380/lessoncraft/lesson"
 	"github.com/ringo380/lessoncraft/pwd/types"
 	"github.com/stretchr/testify/mock"
 )
@@ -118,3 +119,7 @@ func (m *Mock) PlaygroundGetAll() ([]*types.Playground, error) {
 	args := m.Called()
 	return args.Get(0).([]*types.Playground), args.Error(1)
 }
+func (m *Mock) LessonGet(id string) (*lesson.Lesson, error) {
+	args := m.Called(id)
+	return args.Get(0).(*lesson.Lesson), args.Error(1)
+}
@@ -0,0 +1,365 @@
+// Package workspace packs the files of instances into archives learners can
+// download, and unpacks the archives instructors upload into instances.
+// Instances exchange files with the platform as tar streams.
+package workspace
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+type Format string
+
+const (
+	TarGz Format = "tar.gz"
+	Zip   Format = "zip"
+	// Tar is only accepted for uploads
+	Tar Format = "tar"
+)
+
+// ErrTooLarge is returned when the files of an archive add up to more than
+// the size allowed
+var ErrTooLarge = errors.New("Archive is too large")
+
+// UnsafeEntryError is an entry of an uploaded archive that would be written
+// outside of the directory it is extracted to, or is not a file, directory or
+// symlink
+type UnsafeEntryError struct {
+	Name string
+}
+
+func (e *UnsafeEntryError) Error() string {
+	return fmt.Sprintf("Unsafe archive entry %q", e.Name)
+}
+
+// ParseFormat parses the format of downloads, tar.gz by default
+func ParseFormat(s string) (Format, error) {
+	switch Format(s) {
+	case "", TarGz, "tgz":
+		return TarGz, nil
+	case Zip:
+		return Zip, nil
+	}
+	return "", fmt.Errorf("Unknown archive format %q", s)
+}
+
+// ContentType returns the media type of archives of the format
+func (f Format) ContentType() string {
+	switch f {
+	case Zip:
+		return "application/zip"
+	case Tar:
+		return "application/x-tar"
+	}
+	return "application/gzip"
+}
+
+// Writer writes the entries of tar streams into an archive, keeping count of
+// the size of the files
+type Writer struct {
+	format Format
+	gz     *gzip.Writer
+	tw     *tar.Writer
+	zw     *zip.Writer
+	max    int64
+	size   int64
+}
+
+// NewWriter writes an archive of the format to w. Files may add up to
+// maxSize bytes, 0 for no limit.
+func NewWriter(w io.Writer, format Format, maxSize int64) *Writer {
+	a := &Writer{format: format, max: maxSize}
+	if format == Zip {
+		a.zw = zip.NewWriter(w)
+	} else {
+		a.gz = gzip.NewWriter(w)
+		a.tw = tar.NewWriter(a.gz)
+	}
+	return a
+}
+
+// AddTar copies the entries of a tar stream into the archive, under prefix
+func (a *Writer) AddTar(prefix string, r io.Reader) error {
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if err := a.add(prefix, hdr, tr); err != nil {
+			return err
+		}
+	}
+}
+
+func (a *Writer) add(prefix string, hdr *tar.Header, r io.Reader) error {
+	hdr.Name = path.Join(prefix, hdr.Name)
+	if hdr.Typeflag == tar.TypeDir {
+		hdr.Name += "/"
+	}
+	if hdr.Typeflag == tar.TypeReg {
+		a.size += hdr.Size
+		if a.max > 0 && a.size > a.max {
+			return ErrTooLarge
+		}
+	}
+
+	if a.tw != nil {
+		if err := a.tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		_, err := io.Copy(a.tw, r)
+		return err
+	}
+
+	// Zip archives only hold files, directories and symlinks
+	switch hdr.Typeflag {
+	case tar.TypeReg, tar.TypeDir, tar.TypeSymlink:
+	default:
+		return nil
+	}
+	zh, err := zip.FileInfoHeader(hdr.FileInfo())
+	if err != nil {
+		return err
+	}
+	zh.Name = hdr.Name
+	if hdr.Typeflag != tar.TypeDir {
+		zh.Method = zip.Deflate
+	}
+	w, err := a.zw.CreateHeader(zh)
+	if err != nil {
+		return err
+	}
+	if hdr.Typeflag == tar.TypeSymlink {
+		_, err = io.WriteString(w, hdr.Linkname)
+		return err
+	}
+	_, err = io.Copy(w, r)
+	return err
+}
+
+// Close finishes the archive
+func (a *Writer) Close() error {
+	if a.zw != nil {
+		return a.zw.Close()
+	}
+	if err := a.tw.Close(); err != nil {
+		return err
+	}
+	return a.gz.Close()
+}
+
+// Detect tells the format of an uploaded archive from its first bytes
+func Detect(r io.ReaderAt) Format {
+	magic := make([]byte, 4)
+	n, _ := r.ReadAt(magic, 0)
+	switch {
+	case n >= 4 && bytes.Equal(magic, []byte("PK\x03\x04")):
+		return Zip
+	case n >= 2 && magic[0] == 0x1f && magic[1] == 0x8b:
+		return TarGz
+	}
+	return Tar
+}
+
+// Sanitize rewrites an uploaded archive of any format as a tar stream that is
+// safe to extract: entries stay inside the directory they are extracted to,
+// only files, directories and symlinks are kept, and files lose their setuid
+// bits. Files may add up to maxSize bytes, 0 for no limit.
+func Sanitize(w io.Writer, r io.ReaderAt, size int64, maxSize int64) error {
+	tw := tar.NewWriter(w)
+	s := &sanitizer{tw: tw, max: maxSize, links: make(map[string]bool)}
+
+	var err error
+	switch Detect(r) {
+	case Zip:
+		err = s.fromZip(r, size)
+	case TarGz:
+		var gz *gzip.Reader
+		gz, err = gzip.NewReader(io.NewSectionReader(r, 0, size))
+		if err == nil {
+			err = s.fromTar(gz)
+		}
+	default:
+		err = s.fromTar(bufio.NewReader(io.NewSectionReader(r, 0, size)))
+	}
+	if err != nil {
+		return err
+	}
+	return tw.Close()
+}
+
+type sanitizer struct {
+	tw   *tar.Writer
+	max  int64
+	size int64
+	// links are the names of the symlinks written so far
+	links map[string]bool
+}
+
+func (s *sanitizer) fromTar(r io.Reader) error {
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		switch hdr.Typeflag {
+		case tar.TypeXGlobalHeader:
+			continue
+		case tar.TypeReg, tar.TypeDir, tar.TypeSymlink:
+		default:
+			return &UnsafeEntryError{Name: hdr.Name}
+		}
+		if err := s.add(hdr.Name, hdr.Typeflag, hdr.Mode, hdr.ModTime, hdr.Linkname, hdr.Size, tr); err != nil {
+			return err
+		}
+	}
+}
+
+func (s *sanitizer) fromZip(r io.ReaderAt, size int64) error {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return err
+	}
+	for _, f := range zr.File {
+		mode := f.Mode()
+		typeflag := byte(tar.TypeReg)
+		switch {
+		case mode.IsDir():
+			typeflag = tar.TypeDir
+		case mode&os.ModeSymlink != 0:
+			typeflag = tar.TypeSymlink
+		case !mode.IsRegular():
+			return &UnsafeEntryError{Name: f.Name}
+		}
+
+		rc, err := f.Open()
+		if err != nil {
+			return err
+		}
+		var linkname string
+		if typeflag == tar.TypeSymlink {
+			target, err := io.ReadAll(io.LimitReader(rc, 4096))
+			if err != nil {
+				rc.Close()
+				return err
+			}
+			linkname = string(target)
+		}
+		err = s.add(f.Name, typeflag, int64(mode.Perm()), f.Modified, linkname, int64(f.UncompressedSize64), rc)
+		rc.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *sanitizer) add(name string, typeflag byte, mode int64, modTime time.Time, linkname string, size int64, r io.Reader) error {
+	clean, ok := safeName(name)
+	if !ok {
+		return &UnsafeEntryError{Name: name}
+	}
+	if clean == "." {
+		return nil
+	}
+	// Entries are never written through or over a symlink of the archive, which
+	// could point anywhere the entry cannot
+	if s.links[clean] || !s.contained(clean) {
+		return &UnsafeEntryError{Name: name}
+	}
+	if typeflag == tar.TypeSymlink {
+		// Symlinks may only point to entries of the archive
+		if path.IsAbs(linkname) || !s.contained(path.Dir(clean)+"/"+linkname) {
+			return &UnsafeEntryError{Name: name}
+		}
+		s.links[clean] = true
+	}
+
+	hdr := &tar.Header{
+		Name:     clean,
+		Typeflag: typeflag,
+		Mode:     mode & 0777,
+		ModTime:  modTime,
+		Linkname: linkname,
+	}
+	switch typeflag {
+	case tar.TypeDir:
+		hdr.Name += "/"
+		if hdr.Mode == 0 {
+			hdr.Mode = 0755
+		}
+	case tar.TypeReg:
+		hdr.Size = size
+		s.size += size
+		if s.max > 0 && s.size > s.max {
+			return ErrTooLarge
+		}
+		if hdr.Mode == 0 {
+			hdr.Mode = 0644
+		}
+	}
+	if err := s.tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	if typeflag == tar.TypeReg {
+		// The size of zip entries is what the archive says, so it is enforced
+		if _, err := io.CopyN(s.tw, r, size); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// contained reports whether a path relative to the extraction directory stays
+// inside it. The path must not go through a symlink of the archive: links are
+// checked to point inside, but whatever follows them, e.g. "..", is resolved
+// from their target rather than from where they are.
+func (s *sanitizer) contained(p string) bool {
+	var parts []string
+	for _, c := range strings.Split(p, "/") {
+		if c == "" || c == "." {
+			continue
+		}
+		if len(parts) > 0 && s.links[strings.Join(parts, "/")] {
+			return false
+		}
+		if c == ".." {
+			if len(parts) == 0 {
+				return false
+			}
+			parts = parts[:len(parts)-1]
+		} else {
+			parts = append(parts, c)
+		}
+	}
+	return true
+}
+
+// safeName cleans the name of an entry, which must stay inside the directory
+// the archive is extracted to
+func safeName(name string) (string, bool) {
+	if strings.HasPrefix(name, "/") {
+		return "", false
+	}
+	clean := path.Clean(name)
+	if clean == ".." || strings.HasPrefix(clean, "../") {
+		return "", false
+	}
+	return clean, true
+}
@@ -0,0 +1,177 @@
+package workspace
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type testEntry struct {
+	name     string
+	typeflag byte
+	body     string
+	linkname string
+}
+
+func testTar(t *testing.T, entries ...testEntry) []byte {
+	var b bytes.Buffer
+	tw := tar.NewWriter(&b)
+	for _, e := range entries {
+		hdr := &tar.Header{Name: e.name, Typeflag: e.typeflag, Mode: 04755, Size: int64(len(e.body)), Linkname: e.linkname}
+		assert.Nil(t, tw.WriteHeader(hdr))
+		tw.Write([]byte(e.body))
+	}
+	assert.Nil(t, tw.Close())
+	return b.Bytes()
+}
+
+func readTar(t *testing.T, r io.Reader) map[string]string {
+	files := map[string]string{}
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return files
+		}
+		assert.Nil(t, err)
+		body, _ := io.ReadAll(tr)
+		files[hdr.Name] = string(body) + hdr.Linkname
+	}
+}
+
+func TestWriter(t *testing.T) {
+	home := testTar(t,
+		testEntry{name: "root", typeflag: tar.TypeDir},
+		testEntry{name: "root/app.py", typeflag: tar.TypeReg, body: "print(1)"},
+		testEntry{name: "root/link", typeflag: tar.TypeSymlink, linkname: "app.py"},
+	)
+
+	var b bytes.Buffer
+	w := NewWriter(&b, TarGz, 0)
+	assert.Nil(t, w.AddTar("node1", bytes.NewReader(home)))
+	assert.Nil(t, w.AddTar("node2", bytes.NewReader(home)))
+	assert.Nil(t, w.Close())
+	gz, err := gzip.NewReader(&b)
+	assert.Nil(t, err)
+	files := readTar(t, gz)
+	assert.Equal(t, "print(1)", files["node1/root/app.py"])
+	assert.Equal(t, "app.py", files["node2/root/link"])
+	assert.Contains(t, files, "node2/root/")
+
+	b.Reset()
+	w = NewWriter(&b, Zip, 0)
+	assert.Nil(t, w.AddTar("", bytes.NewReader(home)))
+	assert.Nil(t, w.Close())
+	zr, err := zip.NewReader(bytes.NewReader(b.Bytes()), int64(b.Len()))
+	assert.Nil(t, err)
+	names := []string{}
+	for _, f := range zr.File {
+		names = append(names, f.Name)
+	}
+	assert.Equal(t, []string{"root/", "root/app.py", "root/link"}, names)
+
+	w = NewWriter(io.Discard, TarGz, 4)
+	assert.Equal(t, ErrTooLarge, w.AddTar("", bytes.NewReader(home)))
+}
+
+func TestSanitize(t *testing.T) {
+	upload := testTar(t,
+		testEntry{name: "./repo/", typeflag: tar.TypeDir},
+		testEntry{name: "repo/run.sh", typeflag: tar.TypeReg, body: "echo hi"},
+		testEntry{name: "repo/current", typeflag: tar.TypeSymlink, linkname: "../repo/run.sh"},
+	)
+	var b bytes.Buffer
+	assert.Nil(t, Sanitize(&b, bytes.NewReader(upload), int64(len(upload)), 0))
+	tr := tar.NewReader(&b)
+	hdr, _ := tr.Next()
+	assert.Equal(t, "repo/", hdr.Name)
+	hdr, _ = tr.Next()
+	assert.Equal(t, "repo/run.sh", hdr.Name)
+	// setuid bits are dropped
+	assert.Equal(t, int64(0755), hdr.Mode)
+
+	for _, e := range []testEntry{
+		{name: "../evil", typeflag: tar.TypeReg},
+		{name: "/etc/passwd", typeflag: tar.TypeReg},
+		{name: "repo/../../evil", typeflag: tar.TypeReg},
+		{name: "etc", typeflag: tar.TypeSymlink, linkname: "/etc"},
+		{name: "repo/up", typeflag: tar.TypeSymlink, linkname: "../../"},
+		{name: "repo/hard", typeflag: tar.TypeLink, linkname: "repo/run.sh"},
+		{name: "dev", typeflag: tar.TypeChar},
+	} {
+		upload := testTar(t, e)
+		err := Sanitize(io.Discard, bytes.NewReader(upload), int64(len(upload)), 0)
+		_, unsafe := err.(*UnsafeEntryError)
+		assert.True(t, unsafe, e.name)
+	}
+
+	assert.Equal(t, ErrTooLarge, Sanitize(io.Discard, bytes.NewReader(upload), int64(len(upload)), 4))
+}
+
+func TestSanitize_ChainedSymlinks(t *testing.T) {
+	for _, entries := range [][]testEntry{
+		// Each link stays inside on its own, but the second resolves ".." from
+		// the target of the first
+		{
+			{name: "repo/up", typeflag: tar.TypeSymlink, linkname: ".."},
+			{name: "repo/out", typeflag: tar.TypeSymlink, linkname: "up/.."},
+		},
+		// Files cannot be written through a link
+		{
+			{name: "repo/link", typeflag: tar.TypeSymlink, linkname: "."},
+			{name: "repo/link/evil", typeflag: tar.TypeReg},
+		},
+		// nor over one
+		{
+			{name: "repo/link", typeflag: tar.TypeSymlink, linkname: "run.sh"},
+			{name: "repo/link", typeflag: tar.TypeReg},
+		},
+	} {
+		upload := testTar(t, entries...)
+		err := Sanitize(io.Discard, bytes.NewReader(upload), int64(len(upload)), 0)
+		assert.IsType(t, &UnsafeEntryError{}, err, entries[1].name)
+	}
+
+	// Links to other links are fine as long as nothing goes through them
+	upload := testTar(t,
+		testEntry{name: "repo/run.sh", typeflag: tar.TypeReg, body: "echo hi"},
+		testEntry{name: "repo/current", typeflag: tar.TypeSymlink, linkname: "run.sh"},
+		testEntry{name: "bin/run", typeflag: tar.TypeSymlink, linkname: "../repo/current"},
+	)
+	assert.Nil(t, Sanitize(io.Discard, bytes.NewReader(upload), int64(len(upload)), 0))
+}
+
+func TestSanitize_Zip(t *testing.T) {
+	var z bytes.Buffer
+	zw := zip.NewWriter(&z)
+	f, _ := zw.Create("repo/README.md")
+	f.Write([]byte("# Starter"))
+	assert.Nil(t, zw.Close())
+
+	var b bytes.Buffer
+	assert.Nil(t, Sanitize(&b, bytes.NewReader(z.Bytes()), int64(z.Len()), 0))
+	assert.Equal(t, map[string]string{"repo/README.md": "# Starter"}, readTar(t, &b))
+
+	z.Reset()
+	zw = zip.NewWriter(&z)
+	zw.Create("../../root/.bashrc")
+	assert.Nil(t, zw.Close())
+	err := Sanitize(io.Discard, bytes.NewReader(z.Bytes()), int64(z.Len()), 0)
+	assert.IsType(t, &UnsafeEntryError{}, err)
+}
+
+func TestParseFormat(t *testing.T) {
+	f, err := ParseFormat("")
+	assert.Nil(t, err)
+	assert.Equal(t, TarGz, f)
+	f, err = ParseFormat("zip")
+	assert.Nil(t, err)
+	assert.Equal(t, Zip, f)
+	_, err = ParseFormat("rar")
+	assert.NotNil(t, err)
+}